@@ -0,0 +1,189 @@
+// Benchmark of the regex engine against the POSIX ERE matcher of the C
+// library, see `make bench-regex`. Every line of the corpus is a pattern, a
+// tab, a subject and optionally another tab and how many times to repeat the
+// subject; blank lines and lines starting with '#' are skipped. Each pattern
+// is timed searching its subject with both engines, and with the engine's
+// capture-free match as re:match? uses it. When the pattern is in the syntax
+// the two engines share, they must find the same leftmost match. Any
+// mismatch is listed and makes the exit status 1.
+
+#include <regex.h>
+#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+
+#include "posix_syntax.h"
+#include "regex.h"
+
+// Each engine repeats a search until this much time has passed.
+#define BENCH_MIN_NS 20000000LL
+#define BENCH_MIN_RUNS 10
+#define BENCH_LINE_MAX 4096
+
+typedef struct {
+    bool matched;
+    long start;  // Of the leftmost match, -1 without one
+    long end;
+} Found;
+
+static long long nowNs(void) {
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return (long long)ts.tv_sec * 1000000000LL + ts.tv_nsec;
+}
+
+static Found searchLiss(ReProgram* prog, const char* text) {
+    const char* submatch[MAX_GROUPS * 2] = {0};
+    Found found = {.start = -1, .end = -1};
+    found.matched = matchGroups(prog, text, submatch);
+    if (found.matched) {
+        found.start = submatch[0] - text;
+        found.end = submatch[1] - text;
+    }
+    return found;
+}
+
+static Found searchPosix(regex_t* re, const char* text) {
+    regmatch_t m;
+    Found found = {.start = -1, .end = -1};
+    found.matched = regexec(re, text, 1, &m, 0) == 0;
+    if (found.matched) {
+        found.start = m.rm_so;
+        found.end = m.rm_eo;
+    }
+    return found;
+}
+
+// Returns the mean time of one search in nanoseconds.
+static double timeLiss(ReProgram* prog, const char* text) {
+    long long begin = nowNs();
+    long long elapsed = 0;
+    long runs = 0;
+    while (runs < BENCH_MIN_RUNS || elapsed < BENCH_MIN_NS) {
+        searchLiss(prog, text);
+        runs++;
+        elapsed = nowNs() - begin;
+    }
+    return (double)elapsed / runs;
+}
+
+static double timeMatch(ReProgram* prog, const char* text) {
+    long long begin = nowNs();
+    long long elapsed = 0;
+    long runs = 0;
+    while (runs < BENCH_MIN_RUNS || elapsed < BENCH_MIN_NS) {
+        match(prog, text);
+        runs++;
+        elapsed = nowNs() - begin;
+    }
+    return (double)elapsed / runs;
+}
+
+static double timePosix(regex_t* re, const char* text) {
+    long long begin = nowNs();
+    long long elapsed = 0;
+    long runs = 0;
+    while (runs < BENCH_MIN_RUNS || elapsed < BENCH_MIN_NS) {
+        searchPosix(re, text);
+        runs++;
+        elapsed = nowNs() - begin;
+    }
+    return (double)elapsed / runs;
+}
+
+// Returns the subject repeated count times. The caller frees it.
+static char* repeat(const char* subject, long count) {
+    size_t len = strlen(subject);
+    char* text = malloc(len * count + 1);
+    for (long i = 0; i < count; i++) {
+        memcpy(text + len * i, subject, len);
+    }
+    text[len * count] = '\0';
+    return text;
+}
+
+// Times one corpus entry and prints its row. Returns false on a mismatch.
+static bool benchEntry(const char* pattern, const char* text) {
+    ReProgram* prog = compilePattern(pattern);
+    if (prog == NULL) {
+        printf("%-28s %8zu  does not compile\n", pattern, strlen(text));
+        return true;
+    }
+    regex_t posix;
+    bool shared = sharedSyntax(pattern) && isAscii(text) &&
+                  regcomp(&posix, pattern, REG_EXTENDED) == 0;
+
+    Found got = searchLiss(prog, text);
+    double liss_ns = timeLiss(prog, text);
+    double bool_ns = timeMatch(prog, text);
+    bool ok = match(prog, text) == got.matched;
+    if (shared) {
+        Found want = searchPosix(&posix, text);
+        double posix_ns = timePosix(&posix, text);
+        // The engine is leftmost-first, POSIX leftmost-longest, so only
+        // where the match starts has to agree.
+        ok = ok && got.matched == want.matched && got.start == want.start;
+        printf("%-28s %8zu %12.0f %12.0f %12.0f %7.2f  %s\n", pattern,
+               strlen(text), liss_ns, bool_ns, posix_ns, liss_ns / posix_ns,
+               ok ? "ok" : "MISMATCH");
+        if (!ok) {
+            printf("    liss  %s at %ld..%ld\n",
+                   got.matched ? "match" : "no match", got.start, got.end);
+            printf("    posix %s at %ld..%ld\n",
+                   want.matched ? "match" : "no match", want.start, want.end);
+        }
+        regfree(&posix);
+    } else {
+        const char* verdict = got.matched ? "match" : "no match";
+        printf("%-28s %8zu %12.0f %12.0f %12s %7s  %s\n", pattern,
+               strlen(text), liss_ns, bool_ns, "-", "-",
+               ok ? verdict : "MISMATCH");
+    }
+    free(prog->instrs);
+    free(prog);
+    return ok;
+}
+
+int main(int argc, char** argv) {
+    const char* path = argc > 1 ? argv[1] : "bench/corpus/regex.txt";
+    FILE* corpus = fopen(path, "r");
+    if (corpus == NULL) {
+        fprintf(stderr, "Could not open corpus \"%s\".\n", path);
+        return 74;
+    }
+
+    printf("%-28s %8s %12s %12s %12s %7s\n", "pattern", "bytes", "liss ns",
+           "match? ns", "posix ns", "ratio");
+    char line[BENCH_LINE_MAX];
+    int mismatches = 0;
+    while (fgets(line, sizeof(line), corpus) != NULL) {
+        line[strcspn(line, "\r\n")] = '\0';
+        if (line[0] == '\0' || line[0] == '#') continue;
+        char* subject = strchr(line, '\t');
+        if (subject == NULL) {
+            fprintf(stderr, "Skipping line without a subject: %s\n", line);
+            continue;
+        }
+        *subject++ = '\0';
+        long count = 1;
+        char* times = strchr(subject, '\t');
+        if (times != NULL) {
+            *times++ = '\0';
+            count = strtol(times, NULL, 10);
+            if (count < 1) count = 1;
+        }
+        char* text = repeat(subject, count);
+        if (!benchEntry(line, text)) mismatches++;
+        free(text);
+    }
+    fclose(corpus);
+
+    if (mismatches > 0) {
+        printf("%d mismatch%s\n", mismatches, mismatches == 1 ? "" : "es");
+        return 1;
+    }
+    return 0;
+}
@@ -0,0 +1,32 @@
+// libFuzzer target for the scanner and the one-pass compiler, see
+// `make fuzz-compiler`. The input is Liss source, compiled and disassembled
+// but never run. It must compile or fail with an error, never crash or hang.
+// The VM is a sandbox so imports can't read files, and it is reset between
+// inputs instead of being rebuilt.
+
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "vm.h"
+
+int LLVMFuzzerTestOneInput(const uint8_t* data, size_t size) {
+    static VM* vm = NULL;
+    if (vm == NULL) {
+        VMOptions options = defaultVMOptions();
+        options.sandbox = true;
+        vm = newVM(options);
+    }
+    resetVM(vm);
+
+    char* source = malloc(size + 1);
+    memcpy(source, data, size);
+    source[size] = '\0';
+
+    char* listing = NULL;
+    if (disassemble(vm, source, &listing) == INTERPRET_OK) free(listing);
+
+    free(source);
+    return 0;
+}
@@ -0,0 +1,50 @@
+#ifndef liss_posix_syntax_h
+#define liss_posix_syntax_h
+
+// Shared by the regex fuzzer and benchmark, which check the engine against
+// the POSIX ERE matcher of the C library.
+
+#include <stdbool.h>
+#include <string.h>
+
+// glibc reads bytes >= 128 by the locale, this engine as single chars.
+static bool isAscii(const char* s) {
+    for (; *s != '\0'; s++) {
+        if ((unsigned char)*s >= 128) return false;
+    }
+    return true;
+}
+
+// Tells whether re stays in the syntax where POSIX ERE and this engine agree
+// on match positions. Escapes, POSIX bracket classes and {,n} are spelled or
+// supported differently, POSIX leaves stacked quantifiers undefined, and
+// glibc mishandles anchors inside repeated groups, so anchors are only
+// allowed at the very ends of the pattern.
+static bool sharedSyntax(const char* re) {
+    if (!isAscii(re)) return false;
+    if (strchr(re, '\\') != NULL) return false;
+    size_t len = strlen(re);
+    bool quantified = false;
+    for (size_t i = 0; i < len; i++) {
+        char c = re[i];
+        bool quantifier = c == '*' || c == '+' || c == '?' || c == '{';
+        if (quantifier && quantified) return false;
+        if ((c == '^' && i != 0) || (c == '$' && i != len - 1)) return false;
+        if (c == '[') {
+            size_t k = i + 1;
+            if (k < len && re[k] == '^') k++;
+            if (k < len && re[k] == ']') k++;
+            for (; k < len && re[k] != ']'; k++) {
+                if (re[k] == '[') return false;  // [:class:], [=e=], [.c.]
+            }
+            i = k;
+        } else if (c == '{') {
+            if (i + 1 < len && re[i + 1] == ',') return false;
+            while (i < len && re[i] != '}') i++;
+        }
+        quantified = quantifier;
+    }
+    return true;
+}
+
+#endif
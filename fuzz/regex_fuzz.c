@@ -0,0 +1,62 @@
+// libFuzzer target for the regex engine, see `make fuzz-regex`. The input is
+// a pattern and a subject on separate lines. Any pattern must compile or be
+// rejected without crashing, and a compiled one must match without crashing.
+// When the pattern is in the syntax POSIX ERE shares with the engine, both
+// must agree on whether the subject matches and where the leftmost match
+// starts; a disagreement aborts so libFuzzer keeps the input.
+
+#include <regex.h>
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "posix_syntax.h"
+#include "regex.h"
+
+// Copies n bytes into a NUL-terminated string; an embedded NUL ends it early.
+static char* copyBytes(const uint8_t* data, size_t n) {
+    char* s = malloc(n + 1);
+    memcpy(s, data, n);
+    s[n] = '\0';
+    return s;
+}
+
+int LLVMFuzzerTestOneInput(const uint8_t* data, size_t size) {
+    const uint8_t* newline = memchr(data, '\n', size);
+    size_t pattern_len = newline ? (size_t)(newline - data) : size;
+    const uint8_t* rest = newline ? newline + 1 : data + size;
+    size_t rest_len = size - (size_t)(rest - data);
+    const uint8_t* end = memchr(rest, '\n', rest_len);
+
+    char* pattern = copyBytes(data, pattern_len);
+    char* text = copyBytes(rest, end ? (size_t)(end - rest) : rest_len);
+
+    ReProgram* prog = compilePattern(pattern);
+    if (prog != NULL) {
+        const char* submatch[MAX_GROUPS * 2] = {0};
+        bool got = matchGroups(prog, text, submatch);
+
+        regex_t posix;
+        if (sharedSyntax(pattern) && isAscii(text) &&
+            regcomp(&posix, pattern, REG_EXTENDED) == 0) {
+            regmatch_t want;
+            bool want_match = regexec(&posix, text, 1, &want, 0) == 0;
+            if (got != want_match ||
+                (got && submatch[0] - text != want.rm_so)) {
+                fprintf(stderr, "pattern '%s' on '%s': match %d, POSIX %d\n",
+                        pattern, text, got, want_match);
+                abort();
+            }
+            regfree(&posix);
+        }
+        free(prog->instrs);
+        free(prog);
+    }
+
+    free(pattern);
+    free(text);
+    return 0;
+}
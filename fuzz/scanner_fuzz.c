@@ -0,0 +1,36 @@
+// libFuzzer target for the scanner, see `make fuzz-scanner`. The input is
+// Liss source. Scanning it must not crash, and every token but the last must
+// move the scanner forward: the compiler keeps asking for tokens until EOF, so
+// a token that consumes nothing, even an error, would hang it.
+
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "scanner.h"
+
+int LLVMFuzzerTestOneInput(const uint8_t* data, size_t size) {
+    char* source = malloc(size + 1);
+    memcpy(source, data, size);
+    source[size] = '\0';
+
+    Scanner scanner;
+    initScanner(&scanner, source);
+    for (;;) {
+        const char* before = scanner.current;
+        Token token = scanToken(&scanner);
+        if (token.type == TOKEN_EOF) break;
+        if (scanner.current <= before || token.length < 0) {
+            fprintf(stderr, "token %s at offset %td made no progress\n",
+                    printTokenType(token.type), before - source);
+            abort();
+        }
+        // String tokens carry their unescaped text in a buffer of their own.
+        if (token.type == TOKEN_STRING) free((char*)token.start);
+    }
+
+    free(source);
+    return 0;
+}
@@ -0,0 +1,259 @@
+#define _POSIX_C_SOURCE 200809L
+
+#include "bench.h"
+
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+
+#include "memory.h"
+#include "object.h"
+#include "table.h"
+#include "value.h"
+
+// Iterations of a single run are capped as in testing.B.
+#define BENCH_MAX_ITERS 1000000000LL
+#define BENCH_LINE_MAX 512
+
+// A workload is a script that defines `op`, a function of no arguments that
+// does one iteration of the work.
+typedef struct {
+    const char* name;
+    const char* source;
+} Workload;
+
+static const Workload workloads[] = {
+    {"fib",
+     "(fn fib [n] (cond (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))\n"
+     "(fn op [] (fib 20))\n"},
+    {"strings",
+     "(fn op []\n"
+     "    (let s \"\")\n"
+     "    (for [i 200] (set! s (+ s (str i))))\n"
+     "    (len s))\n"},
+    {"dict",
+     "(fn op []\n"
+     "    (let d (dict))\n"
+     "    (for [i 200] (set! d (put d i (* i i))))\n"
+     "    (for [i 100] (set! d (del d i)))\n"
+     "    (len d))\n"},
+    {"regex",
+     "(import re)\n"
+     "(let lines [\"GET /index.html 200\" \"POST /api/v1/users 201\"\n"
+     "            \"GET /missing 404\" \"DELETE /api/v1/users/7 204\"])\n"
+     "(fn op []\n"
+     "    (let n 0)\n"
+     "    (for [l lines]\n"
+     "        (cond (re:match? \"^[A-Z]+ /[a-z/0-9.]+ 2[0-9][0-9]$\" l)\n"
+     "            (set! n (+ n 1))))\n"
+     "    n)\n"},
+    {"closures",
+     "(import list [\"push\"])\n"
+     "(fn counter [] (let c 0) (fn [] (set! c (+ c 1))))\n"
+     "(fn op []\n"
+     "    (let fs [])\n"
+     "    (for [i 50] (set! fs (push fs (counter))))\n"
+     "    (let t 0)\n"
+     "    (for [f fs] (f) (set! t (+ t (f))))\n"
+     "    t)\n"},
+};
+
+BenchOptions defaultBenchOptions(void) {
+    return (BenchOptions){.time_ns = DEFAULT_BENCH_TIME_NS};
+}
+
+static long long nowNs(void) {
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return (long long)ts.tv_sec * 1000000000LL + ts.tv_nsec;
+}
+
+// Calls op n times and fills result from that run. Returns false if op
+// raised, with the error in vm->raise_value.
+static bool runOp(VM* vm, Value op, int64_t n, BenchResult* result) {
+    uint64_t allocs = vm->alloc_cnt;
+    uint64_t bytes = vm->alloc_bytes;
+    long long begin = nowNs();
+    for (int64_t i = 0; i < n; i++) {
+        callFromNative(vm, op, 0, NULL);
+        if (vm->last_result != INTERPRET_OK) return false;
+    }
+    long long elapsed = nowNs() - begin;
+    result->iterations = n;
+    result->ns_per_op = (double)elapsed / n;
+    result->bytes_per_op = (double)(vm->alloc_bytes - bytes) / n;
+    result->allocs_per_op = (double)(vm->alloc_cnt - allocs) / n;
+    return true;
+}
+
+// Runs a workload until a run lasts time_ns. Returns false and prints why if
+// the workload failed to load or raised.
+static bool benchWorkload(const Workload* workload, long long time_ns,
+                          VMOptions options, BenchResult* result, FILE* out) {
+    snprintf(result->name, sizeof(result->name), "%s", workload->name);
+    VM* vm = newVM(options);
+    if (vm == NULL) {
+        fprintf(out, "%-10s could not create VM\n", workload->name);
+        return false;
+    }
+    InterpretResult status = interpret(vm, workload->source, NULL);
+    Value op = NIL_VAL;
+    if (status == INTERPRET_OK) {
+        Value name = OBJ_VAL(copyString(vm, "op", 2));
+        Value* found = tableGet(&vm->main_module->symbols, name);
+        if (found != NULL) op = *found;
+    }
+    bool ok = !IS_NIL(op);
+    for (int64_t n = 1; ok;) {
+        ok = runOp(vm, op, n, result);
+        long long elapsed = (long long)(result->ns_per_op * n);
+        if (!ok || elapsed >= time_ns || n >= BENCH_MAX_ITERS) break;
+        // Aim a fifth past the time left, growing at most a hundredfold.
+        int64_t next = elapsed > 0 ? (int64_t)((double)time_ns * n / elapsed)
+                                   : 100 * n;
+        next += next / 5;
+        if (next > 100 * n) next = 100 * n;
+        if (next < n + 1) next = n + 1;
+        if (next > BENCH_MAX_ITERS) next = BENCH_MAX_ITERS;
+        n = next;
+    }
+    if (!ok) {
+        if (status == INTERPRET_COMPILE_ERROR) {
+            fprintf(out, "%-10s %s\n", workload->name, vm->error_msg);
+        } else if (status == INTERPRET_OK && IS_NIL(op)) {
+            fprintf(out, "%-10s defines no op\n", workload->name);
+        } else {
+            char* str = sprintValue(vm->raise_value);
+            fprintf(out, "%-10s %s\n", workload->name, str);
+            free(str);
+        }
+    }
+    destroyVM(vm);
+    return ok;
+}
+
+// Reads the number after "key": in a line written by saveResults.
+static bool readField(const char* line, const char* key, double* value) {
+    char pattern[40];
+    snprintf(pattern, sizeof(pattern), "\"%s\":", key);
+    const char* at = strstr(line, pattern);
+    if (at == NULL) return false;
+    char* end;
+    *value = strtod(at + strlen(pattern), &end);
+    return end != at + strlen(pattern);
+}
+
+// Reads a file written by saveResults, which holds one benchmark per line.
+// Returns the number of results read into *results, or -1 if path cannot be
+// opened. The caller frees *results.
+static int loadBaseline(const char* path, BenchResult** results) {
+    FILE* file = fopen(path, "r");
+    if (file == NULL) return -1;
+    int count = 0;
+    int capacity = 0;
+    *results = NULL;
+    char line[BENCH_LINE_MAX];
+    while (fgets(line, sizeof(line), file) != NULL) {
+        const char* name = strstr(line, "\"name\": \"");
+        if (name == NULL) continue;
+        name += strlen("\"name\": \"");
+        int len = (int)strcspn(name, "\"");
+        BenchResult result = {0};
+        double iterations = 0;
+        if (!readField(line, "ns_per_op", &result.ns_per_op)) continue;
+        readField(line, "iterations", &iterations);
+        readField(line, "bytes_per_op", &result.bytes_per_op);
+        readField(line, "allocs_per_op", &result.allocs_per_op);
+        result.iterations = (int64_t)iterations;
+        snprintf(result.name, sizeof(result.name), "%.*s", len, name);
+        if (count == capacity) {
+            capacity = GROW_CAPACITY(capacity);
+            *results = realloc(*results, sizeof(BenchResult) * capacity);
+        }
+        (*results)[count++] = result;
+    }
+    fclose(file);
+    return count;
+}
+
+static bool saveResults(const char* path, const BenchResult* results,
+                        int count) {
+    FILE* file = fopen(path, "w");
+    if (file == NULL) return false;
+    fprintf(file, "{\n  \"benchmarks\": [\n");
+    for (int i = 0; i < count; i++) {
+        const BenchResult* r = &results[i];
+        fprintf(file,
+                "    {\"name\": \"%s\", \"iterations\": %lld, "
+                "\"ns_per_op\": %.1f, \"bytes_per_op\": %.1f, "
+                "\"allocs_per_op\": %.2f}%s\n",
+                r->name, (long long)r->iterations, r->ns_per_op,
+                r->bytes_per_op, r->allocs_per_op, i + 1 < count ? "," : "");
+    }
+    fprintf(file, "  ]\n}\n");
+    return fclose(file) == 0;
+}
+
+static const BenchResult* findResult(const BenchResult* results, int count,
+                                     const char* name) {
+    for (int i = 0; i < count; i++) {
+        if (strcmp(results[i].name, name) == 0) return &results[i];
+    }
+    return NULL;
+}
+
+int runBenchmarks(BenchOptions bench, VMOptions options, FILE* out) {
+    BenchResult* baseline = NULL;
+    int baseline_cnt = 0;
+    if (bench.baseline != NULL) {
+        baseline_cnt = loadBaseline(bench.baseline, &baseline);
+        if (baseline_cnt < 0) {
+            fprintf(stderr, "Could not open baseline \"%s\".\n",
+                    bench.baseline);
+            return 74;
+        }
+    }
+
+    int workload_cnt = sizeof(workloads) / sizeof(workloads[0]);
+    BenchResult* results = calloc(workload_cnt, sizeof(BenchResult));
+    int count = 0;
+    int failed = 0;
+    fprintf(out, "%-10s %10s %14s %12s %12s", "benchmark", "iters", "ns/op",
+            "B/op", "allocs/op");
+    fprintf(out, bench.baseline != NULL ? " %14s %8s\n" : "\n", "base ns/op",
+            "delta");
+    for (int i = 0; i < workload_cnt; i++) {
+        const Workload* workload = &workloads[i];
+        if (bench.filter != NULL &&
+            strstr(workload->name, bench.filter) == NULL) {
+            continue;
+        }
+        BenchResult* r = &results[count];
+        if (!benchWorkload(workload, bench.time_ns, options, r, out)) {
+            failed++;
+            continue;
+        }
+        count++;
+        fprintf(out, "%-10s %10lld %14.0f %12.0f %12.2f", r->name,
+                (long long)r->iterations, r->ns_per_op, r->bytes_per_op,
+                r->allocs_per_op);
+        const BenchResult* base =
+            findResult(baseline, baseline_cnt, r->name);
+        if (base != NULL && base->ns_per_op > 0) {
+            double delta = (r->ns_per_op / base->ns_per_op - 1) * 100;
+            fprintf(out, " %14.0f %+7.1f%%", base->ns_per_op, delta);
+        } else if (bench.baseline != NULL) {
+            fprintf(out, " %14s %8s", "-", "-");
+        }
+        fprintf(out, "\n");
+    }
+    free(baseline);
+
+    int status = failed > 0 ? 1 : 0;
+    if (bench.save != NULL && !saveResults(bench.save, results, count)) {
+        fprintf(stderr, "Could not write \"%s\".\n", bench.save);
+        status = 74;
+    }
+    free(results);
+    return status;
+}
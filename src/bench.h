@@ -0,0 +1,38 @@
+#ifndef liss_bench_h
+#define liss_bench_h
+
+#include <stdint.h>
+#include <stdio.h>
+
+#include "vm.h"
+
+// Default of BenchOptions.time_ns: one second per workload.
+#define DEFAULT_BENCH_TIME_NS 1000000000LL
+
+typedef struct {
+    const char* filter;    // Only workloads whose name contains it, or NULL
+    long long time_ns;     // How long to run each workload for at least
+    const char* baseline;  // Results written by save to compare with, or NULL
+    const char* save;      // Where to write the results as JSON, or NULL
+} BenchOptions;
+
+typedef struct {
+    char name[32];
+    int64_t iterations;
+    double ns_per_op;
+    double bytes_per_op;  // Heap bytes allocated, freed or not
+    double allocs_per_op;
+} BenchResult;
+
+BenchOptions defaultBenchOptions(void);
+
+// Runs the built-in workloads (fib, string building, dict churn, regex
+// matching and closures), each in a fresh VM. Like Go's testing.B, a workload
+// runs 1 iteration, then more and more, predicting how many fill
+// options.time_ns, and the last run is reported. Prints a row per workload to
+// out, with the change in ns/op from the baseline when there is one. Returns
+// the exit status for the process: 0 on success, 1 when a workload raised and
+// 74 when a file could not be read or written.
+int runBenchmarks(BenchOptions bench, VMOptions options, FILE* out);
+
+#endif
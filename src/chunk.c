@@ -40,24 +40,29 @@ void initChunk(VM* vm, Chunk* chunk) {
     chunk->count = 0;
     chunk->capacity = 0;
     chunk->code = NULL;
+    chunk->lines = NULL;
     initValueArray(vm, &chunk->constants);
 }
 
 void freeChunk(VM* vm, Chunk* chunk) {
     FREE_ARRAY(uint8_t, vm, chunk->code, chunk->capacity);
+    FREE_ARRAY(int, vm, chunk->lines, chunk->capacity);
     freeValueArray(vm, &chunk->constants);
     initChunk(vm, chunk);
 }
 
-void writeChunk(VM* vm, Chunk* chunk, uint8_t byte) {
+void writeChunk(VM* vm, Chunk* chunk, uint8_t byte, int line) {
     if (chunk->capacity < chunk->count + 1) {
         int oldCapacity = chunk->capacity;
         chunk->capacity = GROW_CAPACITY(oldCapacity);
         chunk->code =
             GROW_ARRAY(uint8_t, vm, chunk->code, oldCapacity, chunk->capacity);
+        chunk->lines =
+            GROW_ARRAY(int, vm, chunk->lines, oldCapacity, chunk->capacity);
     }
 
     chunk->code[chunk->count] = byte;
+    chunk->lines[chunk->count] = line;
     chunk->count++;
 }
 
@@ -67,20 +72,20 @@ int addConstant(VM* vm, Chunk* chunk, Value value) {
             return i;  // Return existing index if constant already exists
         }
     }
+    // Growing the array may collect, and nothing else holds the value yet.
+    push(vm, value);
     writeValueArray(vm, &chunk->constants, value);
+    pop(vm);
     // Return the index where the constant was appended.
     return chunk->constants.count - 1;
 }
 
-char* sprintChunk(const Chunk* chunk) {
-    char* buffer = NULL;
-    size_t buffer_size = 0;
-    size_t offset = 0;
-
+// Appends to the growing buffer, buffer_size and offset locals of the
+// sprint* functions below.
 #define APPEND_TO_BUFFER(fmt, ...)                                     \
     do {                                                               \
         int needed = snprintf(NULL, 0, fmt, ##__VA_ARGS__);            \
-        if (offset + needed + 1 > buffer_size) {                       \
+        while (offset + needed + 1 > buffer_size) {                    \
             buffer_size = (buffer_size == 0) ? 128 : buffer_size * 2;  \
             buffer = realloc(buffer, buffer_size);                     \
         }                                                              \
@@ -88,7 +93,20 @@ char* sprintChunk(const Chunk* chunk) {
                            ##__VA_ARGS__);                             \
     } while (0)
 
-    for (int i = 0; i < chunk->count; i++) {
+// Returns the chars of a string constant, or "?" if the slot holds something
+// else.
+static const char* constantName(const Chunk* chunk, int index) {
+    Value value = chunk->constants.values[index];
+    return IS_STRING(value) ? AS_CSTRING(value) : "?";
+}
+
+// Lists the instructions that start in [from, to) of the chunk's code.
+static char* sprintCode(const Chunk* chunk, int from, int to) {
+    char* buffer = NULL;
+    size_t buffer_size = 0;
+    size_t offset = 0;
+
+    for (int i = from; i < to; i++) {
         APPEND_TO_BUFFER("%04d ", i);
         uint8_t opcode = chunk->code[i];
         switch (opcode) {
@@ -110,16 +128,17 @@ char* sprintChunk(const Chunk* chunk) {
                 APPEND_TO_BUFFER("OP_POP\n");
                 break;
             case OP_JUMP: {
-                uint16_t offset =
+                uint16_t jump =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_JUMP %d\n", offset);
+                APPEND_TO_BUFFER("OP_JUMP %d -> %04d\n", jump, i + 3 + jump);
                 i += 2;  // Skip the operand bytes
                 break;
             }
             case OP_JUMP_IF_FALSE: {
-                uint16_t offset =
+                uint16_t jump =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_JUMP_IF_FALSE %d\n", offset);
+                APPEND_TO_BUFFER("OP_JUMP_IF_FALSE %d -> %04d\n", jump,
+                                 i + 3 + jump);
                 i += 2;  // Skip the operand bytes
                 break;
             }
@@ -129,6 +148,9 @@ char* sprintChunk(const Chunk* chunk) {
             case OP_SUBTRACT:
                 APPEND_TO_BUFFER("OP_SUBTRACT\n");
                 break;
+            case OP_MODULO:
+                APPEND_TO_BUFFER("OP_MODULO\n");
+                break;
             case OP_MULTIPLY:
                 APPEND_TO_BUFFER("OP_MULTIPLY\n");
                 break;
@@ -168,13 +190,15 @@ char* sprintChunk(const Chunk* chunk) {
             case OP_SET_GLOBAL:
                 uint16_t set_index =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_SET_GLOBAL %d\n", set_index);
+                APPEND_TO_BUFFER("OP_SET_GLOBAL %d '%s'\n", set_index,
+                                 constantName(chunk, set_index));
                 i += 2;  // Skip the operand bytes
                 break;
             case OP_GET_GLOBAL:
                 uint16_t get_index =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_GET_GLOBAL %d\n", get_index);
+                APPEND_TO_BUFFER("OP_GET_GLOBAL %d '%s'\n", get_index,
+                                 constantName(chunk, get_index));
                 i += 2;  // Skip the operand bytes
                 break;
             case OP_EQUAL:
@@ -202,10 +226,11 @@ char* sprintChunk(const Chunk* chunk) {
             case OP_CLOSURE: {
                 uint16_t const_index =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_CLOSURE %d\n", const_index);
-                i += 2;  // Skip the operand bytes
                 ObjFunction* fn =
                     AS_FUNCTION(chunk->constants.values[const_index]);
+                APPEND_TO_BUFFER("OP_CLOSURE %d <fn %s>\n", const_index,
+                                 fn->name ? fn->name->chars : "<code>");
+                i += 2;  // Skip the operand bytes
                 for (int j = 0; j < fn->upvalue_cnt; j++) {
                     uint8_t is_local = chunk->code[i + 1];
                     uint8_t index = chunk->code[i + 2];
@@ -231,7 +256,8 @@ char* sprintChunk(const Chunk* chunk) {
             case OP_TRY_START: {
                 uint16_t handler_offset =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_TRY_START %d\n", handler_offset);
+                APPEND_TO_BUFFER("OP_TRY_START %d -> %04d\n", handler_offset,
+                                 i + 3 + handler_offset);
                 i += 2;  // Skip the operand bytes
                 break;
             }
@@ -250,8 +276,10 @@ char* sprintChunk(const Chunk* chunk) {
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
                 uint16_t const_ix =
                     (uint16_t)(chunk->code[i + 3] << 8) | chunk->code[i + 4];
-                APPEND_TO_BUFFER("OP_GET_MODULE_GLOBAL %d %d\n",
-                                 module_const_ix, const_ix);
+                APPEND_TO_BUFFER("OP_GET_MODULE_GLOBAL %d %d '%s:%s'\n",
+                                 module_const_ix, const_ix,
+                                 constantName(chunk, module_const_ix),
+                                 constantName(chunk, const_ix));
                 i += 4;  // Skip the operand bytes
                 break;
             }
@@ -281,6 +309,20 @@ char* sprintChunk(const Chunk* chunk) {
             case OP_SWAP:
                 APPEND_TO_BUFFER("OP_SWAP\n");
                 break;
+            case OP_UNPACK:
+                APPEND_TO_BUFFER("OP_UNPACK %d\n", chunk->code[i + 1]);
+                i++;
+                break;
+            case OP_ARG_MISSING:
+                APPEND_TO_BUFFER("OP_ARG_MISSING %d\n", chunk->code[i + 1]);
+                i++;
+                break;
+            case OP_CALL_KW:
+            case OP_TAIL_CALL_KW:
+                APPEND_TO_BUFFER("%s %d %d\n", opcodeToString(chunk->code[i]),
+                                 chunk->code[i + 1], chunk->code[i + 2]);
+                i += 2;
+                break;
             case OP_SLIDE:
                 APPEND_TO_BUFFER("OP_SLIDE %d\n", chunk->code[i + 1]);
                 i++;
@@ -288,7 +330,52 @@ char* sprintChunk(const Chunk* chunk) {
             case OP_JUMP_IF_ERR: {
                 uint16_t jmp_offset =
                     (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
-                APPEND_TO_BUFFER("OP_JUMP_IF_ERR %d\n", jmp_offset);
+                APPEND_TO_BUFFER("OP_JUMP_IF_ERR %d -> %04d\n", jmp_offset,
+                                 i + 3 + jmp_offset);
+                i += 2;
+                break;
+            }
+            case OP_APPROX_EQUAL:
+                APPEND_TO_BUFFER("OP_APPROX_EQUAL\n");
+                break;
+            case OP_ACCESS: {
+                uint16_t const_ix =
+                    (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
+                APPEND_TO_BUFFER("OP_ACCESS %d\n", const_ix);
+                i += 2;
+                break;
+            }
+            case OP_SWITCH_TABLE: {
+                uint16_t const_ix =
+                    (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
+                APPEND_TO_BUFFER("OP_SWITCH_TABLE %d\n", const_ix);
+                i += 2;
+                break;
+            }
+            case OP_TUPLE: {
+                uint8_t item_count = chunk->code[i + 1];
+                i++;
+                APPEND_TO_BUFFER("OP_TUPLE %d\n", item_count);
+                break;
+            }
+            case OP_LIST_APPEND:
+                APPEND_TO_BUFFER("OP_LIST_APPEND\n");
+                break;
+            case OP_LOOP: {
+                uint16_t jump =
+                    (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
+                APPEND_TO_BUFFER("OP_LOOP %d -> %04d\n", jump, i + 3 - jump);
+                i += 2;
+                break;
+            }
+            case OP_FOR_INIT:
+                APPEND_TO_BUFFER("OP_FOR_INIT\n");
+                break;
+            case OP_FOR_NEXT: {
+                uint16_t jump =
+                    (uint16_t)(chunk->code[i + 1] << 8) | chunk->code[i + 2];
+                APPEND_TO_BUFFER("OP_FOR_NEXT %d -> %04d\n", jump,
+                                 i + 3 + jump);
                 i += 2;
                 break;
             }
@@ -297,7 +384,47 @@ char* sprintChunk(const Chunk* chunk) {
                 break;
         }
     }
-#undef APPEND_TO_BUFFER
+    if (buffer == NULL) return calloc(1, 1);
     buffer[offset] = '\0';  // Null-terminate the string
     return buffer;
 }
+
+char* sprintChunk(const Chunk* chunk) {
+    return sprintCode(chunk, 0, chunk->count);
+}
+
+char* sprintInstruction(const Chunk* chunk, int offset) {
+    return sprintCode(chunk, offset, offset + 1);
+}
+
+char* sprintFunction(const ObjFunction* function) {
+    char* buffer = NULL;
+    size_t buffer_size = 0;
+    size_t offset = 0;
+    const Chunk* chunk = &function->chunk;
+
+    APPEND_TO_BUFFER("== %s ==\n",
+                     function->usage ? function->usage->chars : "<script>");
+    if (chunk->constants.count > 0) APPEND_TO_BUFFER("constants:\n");
+    for (int i = 0; i < chunk->constants.count; i++) {
+        char* value_str = sprintValue(chunk->constants.values[i]);
+        APPEND_TO_BUFFER("%6d %s\n", i, value_str);
+        free(value_str);
+    }
+    APPEND_TO_BUFFER("code:\n");
+    char* code = sprintChunk(chunk);
+    APPEND_TO_BUFFER("%s", code);
+    free(code);
+
+    // Nested functions follow their parent, depth first.
+    for (int i = 0; i < chunk->constants.count; i++) {
+        Value value = chunk->constants.values[i];
+        if (!IS_FUNCTION(value)) continue;
+        char* nested = sprintFunction(AS_FUNCTION(value));
+        APPEND_TO_BUFFER("\n%s", nested);
+        free(nested);
+    }
+    return buffer;
+}
+
+#undef APPEND_TO_BUFFER
@@ -21,11 +21,14 @@ typedef struct {
     int capacity;
     uint8_t* code;  // The portable bytecode emitted by the compiler.
     ValueArray constants;
-    // We can add a dynamic array for line numbers here later.
+    // The source anchor table: the line of the token that emitted each byte
+    // of code, in step with it.
+    int* lines;
 } Chunk;
 
 typedef struct VM
     VM;  // Forward declaration of VM for memory management functions.
+typedef struct ObjFunction ObjFunction;
 
 #define DEBUG_CHUNK(fmt, chunk)          \
     do {                                 \
@@ -41,12 +44,22 @@ void freeValueArray(VM* vm, ValueArray* array);
 void initChunk(VM* vm, Chunk* chunk);
 void freeChunk(VM* vm, Chunk* chunk);
 
-// Appends a byte to the end of the chunk.
-void writeChunk(VM* vm, Chunk* chunk, uint8_t byte);
+// Appends a byte emitted for the given source line to the end of the chunk.
+void writeChunk(VM* vm, Chunk* chunk, uint8_t byte, int line);
 
 // Adds a constant to the chunk's constant pool and returns its index.
 int addConstant(VM* vm, Chunk* chunk, Value value);
 
+// Returns the instruction listing of a chunk with global names and jump
+// targets resolved. The caller owns the returned buffer.
 char* sprintChunk(const Chunk* chunk);
 
+// Returns the listing line of the instruction at offset as sprintChunk writes
+// it. The caller owns the returned buffer.
+char* sprintInstruction(const Chunk* chunk, int offset);
+
+// Returns the constants and instructions of a function followed by those of
+// every function nested in it. The caller owns the returned buffer.
+char* sprintFunction(const ObjFunction* function);
+
 #endif
@@ -1,13 +1,14 @@
+#define _POSIX_C_SOURCE 200809L
+
 #include "common.h"
 
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <unistd.h>
 
-char* readLissFile(const char* path) {
-    char buf[1024];
-    snprintf(buf, sizeof(buf), "%s%s", path, LISS_FILE_EXT);
-    FILE* file = fopen(buf, "rb");
+static char* readSource(const char* name) {
+    FILE* file = fopen(name, "rb");
     if (file == NULL) {
         return NULL;
     }
@@ -24,3 +25,158 @@ char* readLissFile(const char* path) {
     fclose(file);
     return source;
 }
+
+char* readLissFile(const char* path) {
+    char buf[1024];
+    snprintf(buf, sizeof(buf), "%s%s", path, LISS_FILE_EXT);
+    return readSource(buf);
+}
+
+char* defaultModulePath(void) {
+    const char* env = getenv("LISS_PATH");
+    if (env == NULL) env = "";
+    size_t size = strlen(".:lib::") + strlen(env) + strlen(LISS_STDLIB_DIR) + 1;
+    char* path = malloc(size);
+    if (*env == '\0') {
+        snprintf(path, size, ".:lib:%s", LISS_STDLIB_DIR);
+    } else {
+        snprintf(path, size, ".:lib:%s:%s", env, LISS_STDLIB_DIR);
+    }
+    return path;
+}
+
+// Calls fn with every file name search_path has for name with extension ext,
+// in order, until fn returns true. Empty directories in the path are skipped.
+static bool eachCandidate(const char* search_path, const char* name,
+                          const char* ext,
+                          bool (*fn)(const char* file, void* ctx), void* ctx) {
+    char file[1024];
+    if (name[0] == '/') {
+        snprintf(file, sizeof(file), "%s%s", name, ext);
+        return fn(file, ctx);
+    }
+    const char* dir = search_path;
+    while (dir != NULL && *dir != '\0') {
+        const char* end = strchr(dir, ':');
+        int len = end != NULL ? (int)(end - dir) : (int)strlen(dir);
+        if (len > 0) {
+            snprintf(file, sizeof(file), "%.*s/%s%s", len, dir, name, ext);
+            if (fn(file, ctx)) return true;
+        }
+        dir = end != NULL ? end + 1 : NULL;
+    }
+    return false;
+}
+
+typedef struct {
+    char* source;
+    char** path;
+} FoundFile;
+
+static bool tryRead(const char* file, void* ctx) {
+    FoundFile* found = ctx;
+    found->source = readSource(file);
+    if (found->source == NULL) return false;
+    if (found->path != NULL) *found->path = strdup(file);
+    return true;
+}
+
+char* findLissFile(const char* search_path, const char* name, char** path) {
+    FoundFile found = {NULL, path};
+    eachCandidate(search_path, name, LISS_FILE_EXT, tryRead, &found);
+    return found.source;
+}
+
+static bool tryExists(const char* file, void* ctx) {
+    if (access(file, F_OK) != 0) return false;
+    *(char**)ctx = strdup(file);
+    return true;
+}
+
+char* findModuleFile(const char* search_path, const char* name,
+                     const char* ext) {
+    char* path = NULL;
+    eachCandidate(search_path, name, ext, tryExists, &path);
+    return path;
+}
+
+typedef struct {
+    char* buf;
+    size_t size;
+    size_t len;
+} SearchList;
+
+static bool listFile(const char* file, void* ctx) {
+    SearchList* list = ctx;
+    if (list->len >= list->size) return true;
+    int n = snprintf(list->buf + list->len, list->size - list->len, "%s%s",
+                     list->len > 0 ? ", " : "", file);
+    if (n > 0) list->len += (size_t)n;
+    return false;
+}
+
+void describeSearch(const char* search_path, const char* name, char* buf,
+                    size_t size) {
+    if (size == 0) return;
+    buf[0] = '\0';
+    SearchList list = {buf, size, 0};
+    eachCandidate(search_path, name, LISS_FILE_EXT, listFile, &list);
+}
+
+int cleanModuleName(const char* name, char* out, size_t size) {
+    // The parts kept so far, after the ".." ones at the start.
+    if (size == 0) return -1;
+    out[0] = '\0';
+    const char* parts[64];
+    int lens[64];
+    int part_cnt = 0;
+    int climbs = 0;
+    bool absolute = name[0] == '/';
+    const char* part = name;
+    while (part != NULL) {
+        const char* end = strchr(part, '/');
+        int len = end != NULL ? (int)(end - part) : (int)strlen(part);
+        if (len == 2 && strncmp(part, "..", 2) == 0) {
+            if (part_cnt > 0) {
+                part_cnt--;
+            } else if (!absolute) {
+                climbs++;
+            }
+        } else if (len > 0 && !(len == 1 && part[0] == '.')) {
+            if (part_cnt == 64) return -1;
+            parts[part_cnt] = part;
+            lens[part_cnt++] = len;
+        }
+        part = end != NULL ? end + 1 : NULL;
+    }
+
+    size_t pos = 0;
+    if (absolute) pos += (size_t)snprintf(out, size, "/");
+    for (int i = 0; i < climbs + part_cnt && pos < size; i++) {
+        const char* sep = i > 0 ? "/" : "";
+        if (i < climbs) {
+            pos += (size_t)snprintf(out + pos, size - pos, "%s..", sep);
+        } else {
+            int j = i - climbs;
+            pos += (size_t)snprintf(out + pos, size - pos, "%s%.*s", sep,
+                                    lens[j], parts[j]);
+        }
+    }
+    return pos < size ? climbs : -1;
+}
+
+bool isInsideDir(const char* dir, const char* file) {
+    char* real_dir = realpath(dir, NULL);
+    char* real_file = realpath(file, NULL);
+    bool inside = false;
+    if (real_dir != NULL && real_file != NULL) {
+        size_t len = strlen(real_dir);
+        // The root directory holds everything.
+        if (len == 1) len = 0;
+        inside = strncmp(real_file, real_dir, len) == 0 &&
+                 real_file[len] == '/';
+    }
+    free(real_dir);
+    free(real_file);
+    return inside;
+}
@@ -25,12 +25,31 @@
     fprintf(stdout, "[ERROR] %s:%d: " format "\n", __FILE__, \
             __LINE__ __VA_OPT__(, ) __VA_ARGS__)
 
-#define COMPILE_ERR(compiler, fmt, ...)                                        \
-    do {                                                                       \
-        snprintf((compiler)->vm->error_msg, sizeof((compiler)->vm->error_msg), \
-                 "[line %d] " fmt, (compiler)->parser->current.line,           \
-                 ##__VA_ARGS__);                                               \
-        (compiler)->parser->hadError = true;                                   \
+// Only the first error of a top-level form is kept: the ones that follow are
+// usually fallout, such as each enclosing form missing its ')' after a nested
+// error. The compiler then skips to the next form. compileError records it.
+#define COMPILE_ERR(compiler, fmt, ...)                         \
+    do {                                                        \
+        if ((compiler)->parser->hadError) break;                \
+        char _msg[256];                                         \
+        snprintf(_msg, sizeof(_msg), fmt, ##__VA_ARGS__);       \
+        compileError((compiler), _msg);                         \
+    } while (0)
+
+// Reports a compile warning on stderr. Warnings never fail the compilation.
+#define COMPILE_NOTE(compiler, line, fmt, ...)                      \
+    do {                                                            \
+        fprintf(stderr, "warning: [line %d] " fmt "\n", (line),     \
+                ##__VA_ARGS__);                                     \
+        (compiler)->vm->warning_cnt++;                              \
+    } while (0)
+
+// Reports an opt-in compile warning, shown only when -W is given.
+#define COMPILE_WARN(compiler, line, fmt, ...)                      \
+    do {                                                            \
+        if ((compiler)->vm->options.warnings) {                     \
+            COMPILE_NOTE(compiler, line, fmt, ##__VA_ARGS__);       \
+        }                                                           \
     } while (0)
 
 #define RUNTIME_ERR(vm, fmt, ...)                         \
@@ -42,4 +61,40 @@
 
 char* readLissFile(const char* path);
 
+// Where the standard library of Liss files is installed, searched after the
+// directories of $LISS_PATH.
+#ifndef LISS_STDLIB_DIR
+#define LISS_STDLIB_DIR "/usr/local/lib/liss"
+#endif
+
+// Directories separated by ':' where Liss file imports are looked up when the
+// host sets none: the current one, its lib, the ones in $LISS_PATH and
+// LISS_STDLIB_DIR. The caller frees the string.
+char* defaultModulePath(void);
+
+// Looks for name.liss in every directory of search_path in turn and returns
+// the source of the first one found, or NULL. If path is not NULL, *path is
+// set to the file read, which the caller frees with the source. A name
+// starting with '/' is read as is.
+char* findLissFile(const char* search_path, const char* name, char** path);
+
+// Returns the first file named name with extension ext, e.g. ".so", in a
+// directory of search_path, or NULL. The caller frees it.
+char* findModuleFile(const char* search_path, const char* name,
+                     const char* ext);
+
+// Writes the files findLissFile tries for name to buf, separated by ", ", for
+// an error to list where it looked.
+void describeSearch(const char* search_path, const char* name, char* buf,
+                    size_t size);
+
+// Writes name, a module written as a path relative to the search path, to out
+// with "." and empty parts left out and "dir/.." folded away, so one file has
+// one module name. Returns how many ".." parts are left at its start, which
+// climb out of the search path, or -1 if out is too small.
+int cleanModuleName(const char* name, char* out, size_t size);
+
+// True if file, once links and ".." are resolved, is inside dir.
+bool isInsideDir(const char* dir, const char* file);
+
 #endif
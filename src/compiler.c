@@ -6,13 +6,18 @@
 
 #include "chunk.h"
 #include "common.h"
+#include "eval.h"
 #include "gc.h"
+#include "hamt.h"
+#include "memory.h"
 #include "object.h"
 #include "opcode.h"
 #include "token.h"
+#include "utf8.h"
 #include "vm.h"
 
 static void parseExpression(Compiler* compiler, bool is_tail);
+static bool isRaiseCall(Compiler* compiler);
 
 static void initParser(Parser* parser) {
     parser->hadError = false;
@@ -20,6 +25,58 @@ static void initParser(Parser* parser) {
     parser->previous = (Token){0};
     parser->current = (Token){0};
     parser->next = (Token){0};
+    parser->depth = 0;
+    parser->errors = 0;
+}
+
+// Copies line of source into dst, which has room for size bytes. It is cut
+// short if it doesn't fit.
+static void copySourceLine(char* dst, size_t size, const char* source,
+                           int line) {
+    const char* start = source;
+    for (int i = 1; i < line && start != NULL; i++) {
+        start = strchr(start, '\n');
+        if (start != NULL) start++;
+    }
+    if (start == NULL) {
+        dst[0] = '\0';
+        return;
+    }
+    size_t len = strcspn(start, "\r\n");
+    if (len >= size) len = size - 1;
+    memcpy(dst, start, len);
+    dst[len] = '\0';
+}
+
+// Reports msg at the current token: as a line of vm->error_msg, the errors of
+// earlier forms staying on the lines above, and as a diagnostic.
+static void compileError(Compiler* compiler, const char* msg) {
+    Parser* parser = compiler->parser;
+    VM* vm = compiler->vm;
+    if (parser->errors == 0) {
+        vm->error_msg[0] = '\0';
+        vm->diagnostic_cnt = 0;
+    }
+    size_t len = strlen(vm->error_msg);
+    if (len > 0 && len + 1 < sizeof(vm->error_msg)) {
+        vm->error_msg[len++] = '\n';
+    }
+    snprintf(vm->error_msg + len, sizeof(vm->error_msg) - len,
+             "[line %d] %s", parser->current.line, msg);
+
+    if (vm->diagnostic_cnt < DIAGNOSTICS_MAX) {
+        Diagnostic* diag = &vm->diagnostics[vm->diagnostic_cnt];
+        snprintf(diag->module, sizeof(diag->module), "%s",
+                 compiler->module != NULL ? compiler->module->name->chars
+                                          : "");
+        diag->line = parser->current.line;
+        diag->column = parser->current.column;
+        copySourceLine(diag->source_line, sizeof(diag->source_line),
+                       parser->scanner.source, diag->line);
+        snprintf(diag->message, sizeof(diag->message), "%s", msg);
+    }
+    vm->diagnostic_cnt++;
+    parser->hadError = true;
 }
 
 // Function advance moves the parser forward.
@@ -78,7 +135,8 @@ static Chunk* currentChunk(Compiler* compiler) {
 }
 
 static void emitByte(Compiler* compiler, uint8_t byte) {
-    writeChunk(compiler->vm, currentChunk(compiler), byte);
+    writeChunk(compiler->vm, currentChunk(compiler), byte,
+               compiler->parser->previous.line);
 }
 
 static void emitBytes(Compiler* compiler, uint8_t byte1, uint8_t byte2) {
@@ -105,6 +163,18 @@ static int emitJump(Compiler* compiler, OpCode op) {
     return currentChunk(compiler)->count - 2;
 }
 
+// Emits a jump back to loop_start, which is before the OP_LOOP itself.
+static void emitLoop(Compiler* compiler, int loop_start) {
+    emitByte(compiler, OP_LOOP);
+    // +2 for the operand bytes the offset is relative to.
+    int offset = currentChunk(compiler)->count - loop_start + 2;
+    if (offset > UINT16_MAX) {
+        COMPILE_ERR(compiler, "Loop body too large");
+        return;
+    }
+    emitBytes(compiler, (offset >> 8) & 0xff, offset & 0xff);
+}
+
 static void patchJump(Compiler* compiler, int offset) {
     // -2 to adjust for the bytecode for the jump offset itself.
     int jump = currentChunk(compiler)->count - offset - 2;
@@ -120,13 +190,28 @@ static void patchJump(Compiler* compiler, int offset) {
 
 static void maybePatchTailCall(Compiler* compiler) {
     Chunk* chunk = currentChunk(compiler);
-    if (chunk->count >= 2 && chunk->code[chunk->count - 2] == OP_CALL) {
+    if (chunk->count >= 2 && compiler->last_call == chunk->count - 2 &&
+        chunk->code[chunk->count - 2] == OP_CALL) {
         chunk->code[chunk->count - 2] = OP_TAIL_CALL;
+    } else if (chunk->count >= 3 && compiler->last_call == chunk->count - 3 &&
+               chunk->code[chunk->count - 3] == OP_CALL_KW) {
+        chunk->code[chunk->count - 3] = OP_TAIL_CALL_KW;
     }
 }
 
+// Tells whether the top-level expression just compiled, which opened with
+// head, defined a global. A top-level fn without a name ends in its
+// OP_CLOSURE instead, as it captures nothing.
+static bool definedGlobal(Compiler* compiler, TokenType head) {
+    if (head == TOKEN_LET_KW || head == TOKEN_CONST_KW) return true;
+    Chunk* chunk = currentChunk(compiler);
+    return head == TOKEN_FN_KW && chunk->count >= 3 &&
+           chunk->code[chunk->count - 3] == OP_SET_GLOBAL;
+}
+
 static void initCompiler(Compiler* compiler, Compiler* enclosing,
                          ObjModule* module) {
+    compiler->module = module;
     // Enforce that the module is not NULL. Unconditionally.
     if (module == NULL) {
         COMPILE_ERR(compiler, "Compiler requires a non-NULL module");
@@ -136,7 +221,11 @@ static void initCompiler(Compiler* compiler, Compiler* enclosing,
     compiler->enclosing = enclosing;
     compiler->local_count = 0;
     compiler->scope_depth = 0;
-    compiler->module = module;
+    compiler->temps = 0;
+    compiler->fn_binding = (Token){0};
+    compiler->fn_bound = false;
+    compiler->last_call = -1;
+    compiler->try_depth = 0;
 
     if (enclosing != NULL) {
         compiler->parser = enclosing->parser;
@@ -144,11 +233,18 @@ static void initCompiler(Compiler* compiler, Compiler* enclosing,
     }
     compiler->function = NULL;
     initTable(&compiler->aliases);
+    compiler->defined = NULL;
+    compiler->defined_cnt = 0;
+    compiler->defined_cap = 0;
 
     Local* local = &compiler->locals[compiler->local_count++];
     local->depth = 0;
     local->name.start = "";
     local->name.length = 0;
+    local->is_let = false;
+    local->used = false;
+    local->slot = 0;
+    local->symbol = -1;
 
     compiler->upvalue_cnt = 0;
     compiler->function = newFunction(compiler->vm, compiler->module);
@@ -161,6 +257,28 @@ static ObjFunction* endCompiler(Compiler* compiler) {
 
 static void beginScope(Compiler* compiler) { compiler->scope_depth++; }
 
+// Warns about let-bound locals in [from, to) that were never resolved. Names
+// starting with '_' are deliberately unused.
+static void warnUnusedLocals(Compiler* compiler, int from, int to) {
+    for (int i = from; i < to; i++) {
+        Local* local = &compiler->locals[i];
+        if (!local->is_let || local->used) continue;
+        if (local->name.length > 0 && local->name.start[0] == '_') continue;
+        COMPILE_WARN(compiler, local->name.line, "unused variable '%.*s'",
+                     local->name.length, local->name.start);
+    }
+}
+
+// Warns once per block about expressions following a raise!. raised tells if
+// the expression just compiled was a raise! call, more if anything follows it.
+static void warnUnreachable(Compiler* compiler, bool raised, bool more,
+                            bool* warned) {
+    if (!raised || !more || *warned) return;
+    COMPILE_WARN(compiler, compiler->parser->current.line,
+                 "unreachable code after raise!");
+    *warned = true;
+}
+
 // last_was_let: the final expression in the block defined a local, so that
 // local's value IS the result — no separate result sits above the locals.
 static void endScope(Compiler* compiler, bool last_was_let) {
@@ -173,6 +291,10 @@ static void endScope(Compiler* compiler, bool last_was_let) {
         locals_in_scope++;
         compiler->local_count--;
     }
+    // A trailing let is the block's result, so its value is used.
+    warnUnusedLocals(compiler, compiler->local_count,
+                     compiler->local_count + locals_in_scope -
+                         (last_was_let ? 1 : 0));
 
     // OP_SLIDE(n) pops the result, discards n values below it, then pushes
     // the result back. Without last_was_let: stack = [L1..LN, R], slide N.
@@ -193,16 +315,18 @@ static void parseNumber(Compiler* compiler) {
     memcpy(buf, prev.start, prev.length);
     buf[prev.length] = '\0';
 
+    // errno may still hold ERANGE from an earlier conversion.
+    errno = 0;
     if (prev.type == TOKEN_INT) {
-        int64_t value = strtoll(prev.start, NULL,
-                                0);  // Support hex, octal, and binary literals
+        int64_t value =
+            strtoll(buf, NULL, 0);  // Support hex, octal, and binary literals
         if (errno == ERANGE) {
             COMPILE_ERR(compiler, "Integer literal out of range");
             goto END_PARSE_NUMBER;
         }
         emitConstant(compiler, INT_VAL(value));
     } else {
-        double value = strtod(compiler->parser->previous.start, NULL);
+        double value = strtod(buf, NULL);
         if (errno == ERANGE) {
             COMPILE_ERR(compiler, "Real number literal out of range");
             goto END_PARSE_NUMBER;
@@ -220,15 +344,27 @@ static void parseString(Compiler* compiler) {
 }
 
 static void parseAnd(Compiler* compiler) {
-    int jump_list[100];
+    // (and) is true, the identity of and.
+    if (compiler->parser->current.type == TOKEN_RPAREN) {
+        emitByte(compiler, OP_TRUE);
+        return;
+    }
+    int jump_list[MAX_LOGIC_OPERANDS];
     int jump_count = 0;
 
     parseExpression(compiler, false);
     if (compiler->parser->hadError) return;
 
     while (compiler->parser->current.type != TOKEN_RPAREN) {
+        if (jump_count == MAX_LOGIC_OPERANDS - 1) {
+            COMPILE_ERR(compiler, "`and` takes at most %d operands",
+                        MAX_LOGIC_OPERANDS);
+            return;
+        }
+        // A falsy operand is the result; a truthy one makes way for the next.
         int jump = emitJump(compiler, OP_JUMP_IF_FALSE);
         jump_list[jump_count++] = jump;
+        emitByte(compiler, OP_POP);
         parseExpression(compiler, false);
         if (compiler->parser->hadError) return;
     }
@@ -239,17 +375,23 @@ static void parseAnd(Compiler* compiler) {
 }
 
 static void parseOr(Compiler* compiler) {
+    // (or) is false, the identity of or.
     if (compiler->parser->current.type == TOKEN_RPAREN) {
-        COMPILE_ERR(compiler, "`or` expression requires at least one operand");
+        emitByte(compiler, OP_FALSE);
         return;
     }
-    int jump_list[100];
+    int jump_list[MAX_LOGIC_OPERANDS];
     int jump_count = 0;
 
     parseExpression(compiler, false);
     if (compiler->parser->hadError) return;
 
     while (compiler->parser->current.type != TOKEN_RPAREN) {
+        if (jump_count == MAX_LOGIC_OPERANDS - 1) {
+            COMPILE_ERR(compiler, "`or` takes at most %d operands",
+                        MAX_LOGIC_OPERANDS);
+            return;
+        }
         // If the previous expression is false, jump to the next operand
         int else_jump = emitJump(compiler, OP_JUMP_IF_FALSE);
         int end_jump = emitJump(compiler, OP_JUMP);
@@ -265,14 +407,30 @@ static void parseOr(Compiler* compiler) {
     }
 }
 
+// Records a definition of name, when the compiler is indexing symbols, and
+// returns its index, or -1. Names the compiler makes up are left out.
+static int defineSymbol(Compiler* compiler, Token name, SymbolKind kind) {
+    SymbolIndex* index = compiler->vm->symbols;
+    if (index == NULL || name.length == 0) return -1;
+    return addSymbol(index, name, kind, -1);
+}
+
 static void addLocal(Compiler* compiler, Token name) {
-    if (compiler->local_count >= MAX_LOCALS) {
-        COMPILE_ERR(compiler, "Too many local variables in function");
+    if (compiler->local_count + compiler->temps >= MAX_LOCALS) {
+        // Slot operands are a single byte, and slot 0 holds the callee.
+        COMPILE_ERR(compiler,
+                    "Too many local variables in function: '%.*s' at column "
+                    "%d is over the limit of %d",
+                    name.length, name.start, name.column, MAX_LOCALS - 1);
         return;
     }
     Local* local = &compiler->locals[compiler->local_count++];
     local->name = name;
     local->depth = compiler->scope_depth;
+    local->is_let = false;
+    local->used = false;
+    local->slot = (uint8_t)(compiler->local_count - 1 + compiler->temps);
+    local->symbol = defineSymbol(compiler, name, SYMBOL_LOCAL);
 }
 
 static int resolveLocal(Compiler* compiler, Token name) {
@@ -295,7 +453,9 @@ static int addUpvalue(Compiler* compiler, uint8_t index, bool is_local) {
         }
     }
     if (cnt == MAX_UPVALUES) {
-        COMPILE_ERR(compiler, "Too many closure variables in function");
+        COMPILE_ERR(compiler,
+                    "Too many closure variables in function, the limit is %d",
+                    MAX_UPVALUES);
         return -1;
     }
     compiler->upvalues[cnt].is_local = is_local;
@@ -309,7 +469,9 @@ static int resolveUpvalue(Compiler* compiler, Token name) {
 
     int local = resolveLocal(compiler->enclosing, name);
     if (local != -1) {
-        return addUpvalue(compiler, (uint8_t)local, true);
+        compiler->enclosing->locals[local].used = true;
+        return addUpvalue(compiler, compiler->enclosing->locals[local].slot,
+                          true);
     }
 
     int upvalue = resolveUpvalue(compiler->enclosing, name);
@@ -320,6 +482,24 @@ static int resolveUpvalue(Compiler* compiler, Token name) {
     return -1;
 }
 
+// Records a use of name, when the compiler is indexing symbols, along with the
+// local of this or an enclosing function it resolves to. A global is resolved
+// once the whole source is compiled, see indexSymbols.
+static void referSymbol(Compiler* compiler, Token name) {
+    SymbolIndex* index = compiler->vm->symbols;
+    if (index == NULL) return;
+    int definition = -1;
+    for (Compiler* current = compiler; current != NULL;
+         current = current->enclosing) {
+        int local = resolveLocal(current, name);
+        if (local != -1) {
+            definition = current->locals[local].symbol;
+            break;
+        }
+    }
+    addSymbol(index, name, SYMBOL_REFERENCE, definition);
+}
+
 static int identifierConstant(Compiler* compiler, Token name) {
     ObjString* var_name = copyString(compiler->vm, name.start, name.length);
     return addConstant(compiler->vm, &compiler->function->chunk,
@@ -330,49 +510,337 @@ static bool identifiersEqual(Token* a, Token* b) {
     return a->length == b->length && memcmp(a->start, b->start, a->length) == 0;
 }
 
+// Returns the index of the first occurrence of ch in str, or -1 if not found.
+static int indexOf(const char* str, const size_t len, const char ch) {
+    for (int i = 0; i < len; i++) {
+        if (str[i] == ch) {
+            return i;
+        }
+    }
+    return -1;
+}
+
+// Declares the global name of the module with the given value, or reports
+// that it already exists. var_index is the constant index that the code will
+// refer to it by.
+static bool declareGlobal(Compiler* compiler, Value name, int var_index,
+                          Value value) {
+    ObjString* str = AS_STRING(name);
+    if (tableGet(&compiler->module->symbols, name) != NULL) {
+        if (compiler->redefine) return true;
+        COMPILE_ERR(compiler, "Cannot redeclare global variable '%.*s'",
+                    str->length, str->chars);
+        return false;
+    }
+    if (var_index > UINT16_MAX) {
+        COMPILE_ERR(compiler,
+                    "Too many globals to declare '%.*s': module '%s' has %zu, "
+                    "and a chunk holds at most %d constants",
+                    str->length, str->chars, compiler->module->name->chars,
+                    compiler->module->symbols.size, UINT16_MAX + 1);
+        return false;
+    }
+    if (compiler->added_globals_cnt == compiler->added_globals_cap) {
+        int old_cap = compiler->added_globals_cap;
+        compiler->added_globals_cap = GROW_CAPACITY(old_cap);
+        compiler->added_globals =
+            GROW_ARRAY(Value, compiler->vm, compiler->added_globals, old_cap,
+                       compiler->added_globals_cap);
+    }
+    tableInsert(&compiler->module->symbols, name, value);
+    compiler->added_globals[compiler->added_globals_cnt++] = name;
+    return true;
+}
+
+// Reports a let that would shadow a local of the same scope among the first
+// count locals.
+static bool checkRedeclare(Compiler* compiler, Token identifier, int count) {
+    for (int i = count - 1; i >= 0; i--) {
+        Local* local = &compiler->locals[i];
+        if (local->depth != -1 && local->depth < compiler->scope_depth) {
+            break;
+        }
+        if (identifiersEqual(&identifier, &local->name)) {
+            COMPILE_ERR(compiler,
+                        "Cannot redeclare variable '%.*s' in this scope",
+                        identifier.length, identifier.start);
+            return false;
+        }
+    }
+    return true;
+}
+
+// (let [a b ...] expr) binds each name to the item in its place in the tuple,
+// list or pair expr evaluates to, which must have as many items as there are
+// names. It evaluates to the last item, as a let does to its value.
+static void parseLetPattern(Compiler* compiler) {
+    advance(compiler);  // The [
+    Token names[UINT8_MAX];
+    int name_cnt = 0;
+    while (compiler->parser->current.type != TOKEN_RBRAKET) {
+        Token name = consume(compiler, TOKEN_IDENTIFIER,
+                             "expect a name or `]` in a `let` pattern");
+        if (compiler->parser->hadError) return;
+        if (name_cnt == UINT8_MAX) {
+            COMPILE_ERR(compiler, "a `let` pattern binds at most %d names",
+                        UINT8_MAX);
+            return;
+        }
+        names[name_cnt++] = name;
+    }
+    advance(compiler);  // The ]
+    if (name_cnt == 0) {
+        COMPILE_ERR(compiler, "a `let` pattern needs at least one name");
+        return;
+    }
+
+    int prev_locals = compiler->local_count;
+    parseExpression(compiler, false);
+    if (compiler->parser->hadError) return;
+    if (compiler->scope_depth > 0 && compiler->local_count > prev_locals) {
+        // As in parseLet, the initializer's own local holds its value.
+        emitBytes(compiler, OP_GET_LOCAL,
+                  compiler->locals[compiler->local_count - 1].slot);
+    }
+    emitBytes(compiler, OP_UNPACK, (uint8_t)name_cnt);
+
+    if (compiler->scope_depth == 0) {
+        // The items are stored from the top down, then the last is read
+        // back as the value of the let.
+        int last_index = 0;
+        for (int i = name_cnt - 1; i >= 0; i--) {
+            int var_index = identifierConstant(compiler, names[i]);
+            Value name = currentChunk(compiler)->constants.values[var_index];
+            if (!declareGlobal(compiler, name, var_index, NIL_VAL)) return;
+            defineSymbol(compiler, names[i], SYMBOL_GLOBAL);
+            emitByte(compiler, OP_SET_GLOBAL);
+            emitBytes(compiler, (uint8_t)(var_index >> 8),
+                      (uint8_t)(var_index & 0xff));
+            emitByte(compiler, OP_POP);
+            if (i == name_cnt - 1) last_index = var_index;
+        }
+        emitByte(compiler, OP_GET_GLOBAL);
+        emitBytes(compiler, (uint8_t)(last_index >> 8),
+                  (uint8_t)(last_index & 0xff));
+        return;
+    }
+    // The items on the stack are the slots of the new locals.
+    for (int i = 0; i < name_cnt; i++) {
+        if (!checkRedeclare(compiler, names[i], compiler->local_count)) {
+            return;
+        }
+        addLocal(compiler, names[i]);
+        if (compiler->parser->hadError) return;
+        compiler->locals[compiler->local_count - 1].is_let = true;
+    }
+}
+
 static void parseLet(Compiler* compiler) {
+    if (compiler->parser->current.type == TOKEN_LBRAKET) {
+        parseLetPattern(compiler);
+        return;
+    }
     Token identifier =
         consume(compiler, TOKEN_IDENTIFIER, "expect an identifier after `let`");
     if (compiler->parser->hadError) return;
 
+    if (compiler->parser->current.type == TOKEN_LPAREN &&
+        compiler->parser->next.type == TOKEN_FN_KW) {
+        compiler->fn_binding = identifier;
+    }
+    int prev_locals = compiler->local_count;
     parseExpression(compiler, false);
+    compiler->fn_binding = (Token){0};
+    bool fn_bound = compiler->fn_bound;
+    compiler->fn_bound = false;
     if (compiler->parser->hadError) return;
 
     if (compiler->scope_depth == 0) {
         // Global variable declaration
         int var_index = identifierConstant(compiler, identifier);
         Value name = currentChunk(compiler)->constants.values[var_index];
-        if (tableGet(&compiler->module->symbols, name) != NULL) {
-            COMPILE_ERR(compiler, "Cannot redeclare global variable '%.*s'",
-                        identifier.length, identifier.start);
-            return;
-        }
-        if (compiler->added_globals_cnt >= MAX_GLOBALS) {
-            COMPILE_ERR(compiler,
-                        "Too many global variables declared in program");
-            return;
-        }
-        tableInsert(&compiler->module->symbols, name, NIL_VAL);
-        compiler->added_globals[compiler->added_globals_cnt++] = name;
+        if (!declareGlobal(compiler, name, var_index, NIL_VAL)) return;
+        defineSymbol(compiler, identifier, SYMBOL_GLOBAL);
         emitByte(compiler, OP_SET_GLOBAL);
         emitBytes(compiler, (uint8_t)(var_index >> 8),
                   (uint8_t)(var_index & 0xff));
+    } else if (fn_bound) {
+        // The function's own local is this variable.
+        if (!checkRedeclare(compiler, identifier, compiler->local_count - 1)) {
+            return;
+        }
+        compiler->locals[compiler->local_count - 1].is_let = true;
     } else {
         // Local variable declaration
-        for (int i = compiler->local_count - 1; i >= 0; i--) {
-            Local* local = &compiler->locals[i];
-            if (local->depth != -1 && local->depth < compiler->scope_depth) {
+        if (!checkRedeclare(compiler, identifier, compiler->local_count)) {
+            return;
+        }
+        if (compiler->local_count > prev_locals) {
+            // The initializer declared a local of its own, like the name in
+            // (fn h [] ...) or an inner let, and its value is that local.
+            // Copy it into a slot of this variable.
+            emitBytes(compiler, OP_GET_LOCAL,
+                      compiler->locals[compiler->local_count - 1].slot);
+        }
+        addLocal(compiler, identifier);
+        compiler->locals[compiler->local_count - 1].is_let = true;
+    }
+}
+
+// Runs the code compiled since start, which must only push constants and
+// apply operators to them, and stores its value in *out. The code is dropped
+// from the chunk. Reports a compile error and returns false otherwise.
+static bool foldConstant(Compiler* compiler, Token name, int start,
+                         Value* out) {
+    VM* vm = compiler->vm;
+    Chunk* chunk = currentChunk(compiler);
+    Value* base = vm->stack_top;
+    const char* reason = NULL;
+    int ip = start;
+    while (ip < chunk->count && reason == NULL) {
+        uint8_t op = chunk->code[ip++];
+        switch (op) {
+            case OP_CONSTANT: {
+                int ix = (chunk->code[ip] << 8) | chunk->code[ip + 1];
+                ip += 2;
+                push(vm, chunk->constants.values[ix]);
                 break;
             }
-            if (identifiersEqual(&identifier, &local->name)) {
-                COMPILE_ERR(compiler,
-                            "Cannot redeclare variable '%.*s' in this scope",
-                            identifier.length, identifier.start);
-                return;
+            case OP_TRUE:
+                push(vm, BOOL_VAL(true));
+                break;
+            case OP_FALSE:
+                push(vm, BOOL_VAL(false));
+                break;
+            case OP_NULL:
+                push(vm, NIL_VAL);
+                break;
+            case OP_NOT:
+            case OP_NEGATE:
+            case OP_BNOT:
+                reason = evalUnary(op, vm->stack_top[-1], &vm->stack_top[-1]);
+                break;
+            case OP_EQUAL:
+            case OP_LESS:
+            case OP_GREATER:
+            case OP_ADD:
+            case OP_SUBTRACT:
+            case OP_MULTIPLY:
+            case OP_DIVIDE:
+            case OP_MODULO:
+            case OP_BAND:
+            case OP_BOR:
+            case OP_BXOR:
+            case OP_LSHIFT:
+            case OP_RSHIFT: {
+                Value result;
+                reason = evalBinary(vm, op, vm->stack_top[-2],
+                                    vm->stack_top[-1], &result);
+                if (reason != NULL) break;
+                vm->stack_top[-2] = result;
+                vm->stack_top--;
+                break;
             }
+            default:
+                vm->stack_top = base;
+                COMPILE_ERR(compiler,
+                            "const '%.*s' must be a literal or an operator "
+                            "expression over constants",
+                            name.length, name.start);
+                return false;
         }
-        addLocal(compiler, identifier);
     }
+    if (reason != NULL) {
+        vm->stack_top = base;
+        COMPILE_ERR(compiler, "Cannot evaluate const '%.*s': %s", name.length,
+                    name.start, reason);
+        return false;
+    }
+    *out = pop(vm);
+    chunk->count = start;
+    return true;
+}
+
+// Compiles (const NAME expr): expr is evaluated here, and references to NAME
+// compile to its value. It also becomes a global of the module, so other
+// modules can import it.
+static void parseConst(Compiler* compiler) {
+    Token name = consume(compiler, TOKEN_IDENTIFIER,
+                         "expect an identifier after `const`");
+    if (compiler->parser->hadError) return;
+    if (compiler->scope_depth > 0) {
+        COMPILE_ERR(compiler, "const '%.*s' must be declared at the top level",
+                    name.length, name.start);
+        return;
+    }
+    Chunk* chunk = currentChunk(compiler);
+    int start = chunk->count;
+    int constants = chunk->constants.count;
+    parseExpression(compiler, false);
+    if (compiler->parser->hadError) return;
+    Value value;
+    if (!foldConstant(compiler, name, start, &value)) return;
+    // Drop the operands too, only the value is kept.
+    chunk->constants.count = constants;
+    push(compiler->vm, value);
+
+    Value key = OBJ_VAL(copyString(compiler->vm, name.start, name.length));
+    push(compiler->vm, key);
+    // References don't name the const, so its index doesn't matter.
+    if (declareGlobal(compiler, key, 0, value)) {
+        defineSymbol(compiler, name, SYMBOL_CONST);
+        // Not new when recompiled, so the value is set here.
+        tableInsert(&compiler->module->symbols, key, value);
+        tableInsert(&compiler->module->consts, key, value);
+        emitConstant(compiler, value);
+    }
+    pop(compiler->vm);
+    pop(compiler->vm);
+}
+
+// Compiles (set! name value), which assigns to an existing local, captured
+// variable or global of the current module. The new value is the result.
+static void parseSet(Compiler* compiler) {
+    Token name = consume(compiler, TOKEN_IDENTIFIER,
+                         "expect an identifier after `set!`");
+    if (compiler->parser->hadError) return;
+    referSymbol(compiler, name);
+
+    parseExpression(compiler, false);
+    if (compiler->parser->hadError) return;
+
+    if (indexOf(name.start, name.length, ':') != -1) {
+        COMPILE_ERR(compiler, "Cannot set! '%.*s' of another module",
+                    name.length, name.start);
+        return;
+    }
+
+    int arg = resolveLocal(compiler, name);
+    if (arg != -1) {
+        emitBytes(compiler, OP_SET_LOCAL, compiler->locals[arg].slot);
+        return;
+    }
+    arg = resolveUpvalue(compiler, name);
+    if (arg != -1) {
+        emitBytes(compiler, OP_SET_UPVALUE, (uint8_t)arg);
+        return;
+    }
+
+    int var_index = identifierConstant(compiler, name);
+    Value var_name = currentChunk(compiler)->constants.values[var_index];
+    if (tableGet(&compiler->module->consts, var_name) != NULL) {
+        COMPILE_ERR(compiler, "Cannot set! const '%.*s'", name.length,
+                    name.start);
+        return;
+    }
+    if (tableGet(&compiler->module->symbols, var_name) == NULL) {
+        COMPILE_ERR(compiler, "Cannot set! undefined variable '%.*s'",
+                    name.length, name.start);
+        return;
+    }
+    emitByte(compiler, OP_SET_GLOBAL);
+    emitBytes(compiler, (uint8_t)(var_index >> 8),
+              (uint8_t)(var_index & 0xff));
 }
 
 static void parseCond(Compiler* compiler, bool is_tail) {
@@ -400,7 +868,160 @@ static void parseCond(Compiler* compiler, bool is_tail) {
     patchJump(compiler, end_jump);
 }
 
+// Builds the usage line of a compiled function, e.g. "(fib n)", from its name
+// and the parameters, which are the first locals after the reserved slot.
+// Parameters with a default are in brackets, as in "(greet name [greeting])".
+static ObjString* functionUsage(Compiler* fn_compiler) {
+    ObjFunction* function = fn_compiler->function;
+    const char* name = function->name ? function->name->chars : "fn";
+    int len = (int)strlen(name) + 2;
+    for (int i = 1; i <= function->arity; i++) {
+        len += fn_compiler->locals[i].name.length + 1;
+        if (i > function->min_arity) len += 2;
+    }
+    char* buf = malloc(len + 1);
+    int pos = sprintf(buf, "(%s", name);
+    for (int i = 1; i <= function->arity; i++) {
+        Token param = fn_compiler->locals[i].name;
+        pos += sprintf(buf + pos, i > function->min_arity ? " [%.*s]" : " %.*s",
+                       param.length, param.start);
+    }
+    sprintf(buf + pos, ")");
+    ObjString* usage = copyString(fn_compiler->vm, buf, len);
+    free(buf);
+    return usage;
+}
+
+// Keeps the names of the parameters on the function, for keyword arguments.
+static void recordParams(Compiler* fn_compiler) {
+    ObjFunction* function = fn_compiler->function;
+    // The function holds on to the tuple while the names are allocated.
+    ObjTuple* params = newTuple(fn_compiler->vm, function->arity);
+    function->params = params;
+    for (int i = 0; i < function->arity; i++) {
+        Token param = fn_compiler->locals[i + 1].name;
+        params->items[i] = OBJ_VAL(
+            copyString(fn_compiler->vm, param.start, param.length));
+    }
+}
+
+// Skips the tokens of a parameter's default, up to the `)` that closes the
+// parameter.
+static void skipDefault(Compiler* compiler) {
+    int depth = 0;
+    for (;;) {
+        TokenType type = compiler->parser->current.type;
+        if (type == TOKEN_EOF || type == TOKEN_ZERO) {
+            COMPILE_ERR(compiler, "expect ')' after a parameter default");
+            return;
+        }
+        if (type == TOKEN_RPAREN && depth == 0) return;
+        if (type == TOKEN_LPAREN || type == TOKEN_LBRAKET ||
+            type == TOKEN_HASH_LBRAKET) {
+            depth++;
+        } else if (type == TOKEN_RPAREN || type == TOKEN_RBRAKET) {
+            depth--;
+        }
+        advance(compiler);
+    }
+}
+
+// Compiles the default of the parameter in slot, with the parser at it. The
+// code runs on entry and stores the default if the caller left it out.
+static void compileDefault(Compiler* fn_compiler, Token param, uint8_t slot) {
+    emitBytes(fn_compiler, OP_ARG_MISSING, slot);
+    int passed_jump = emitJump(fn_compiler, OP_JUMP_IF_FALSE);
+    emitByte(fn_compiler, OP_POP);
+    int prev_locals = fn_compiler->local_count;
+    parseExpression(fn_compiler, false);
+    if (fn_compiler->parser->hadError) return;
+    if (fn_compiler->local_count > prev_locals) {
+        COMPILE_ERR(fn_compiler, "the default of '%.*s' cannot be a let",
+                    param.length, param.start);
+        return;
+    }
+    if (fn_compiler->parser->current.type != TOKEN_RPAREN) {
+        COMPILE_ERR(fn_compiler, "expect ')' after the default of '%.*s'",
+                    param.length, param.start);
+        return;
+    }
+    emitBytes(fn_compiler, OP_SET_LOCAL, slot);
+    emitByte(fn_compiler, OP_POP);
+    int end_jump = emitJump(fn_compiler, OP_JUMP);
+    patchJump(fn_compiler, passed_jump);
+    emitByte(fn_compiler, OP_POP);
+    patchJump(fn_compiler, end_jump);
+}
+
+// Parses the parameters after the `[`, each a name or (name default). The
+// parameters with a default come last and may be left out by the caller.
+// Their defaults are compiled once every parameter is a local, so the code
+// sees the whole frame and a default can use the parameters before it.
+static bool parseParams(Compiler* compiler, Compiler* fn_compiler) {
+    Parser* parser = fn_compiler->parser;
+    ObjFunction* function = fn_compiler->function;
+    Parser* defaults = NULL;  // The parser at each default, in order
+    int default_cnt = 0;
+    while (parser->current.type == TOKEN_IDENTIFIER ||
+           parser->current.type == TOKEN_LPAREN) {
+        function->arity++;
+        if (function->arity >= MAX_LOCALS) {
+            COMPILE_ERR(compiler,
+                        "Too many function parameters, the limit is %d",
+                        MAX_LOCALS - 1);
+            break;
+        }
+        bool has_default = parser->current.type == TOKEN_LPAREN;
+        if (has_default) advance(fn_compiler);
+        Token param =
+            consume(fn_compiler, TOKEN_IDENTIFIER, "Expect parameter name");
+        if (parser->hadError) break;
+        addLocal(fn_compiler, param);
+        int symbol = fn_compiler->locals[fn_compiler->local_count - 1].symbol;
+        if (symbol != -1) {
+            compiler->vm->symbols->symbols[symbol].kind = SYMBOL_PARAM;
+        }
+        if (!has_default) {
+            if (default_cnt > 0) {
+                COMPILE_ERR(fn_compiler,
+                            "parameter '%.*s' needs a default, as the ones "
+                            "before it have",
+                            param.length, param.start);
+                break;
+            }
+            function->min_arity = function->arity;
+            continue;
+        }
+        defaults = realloc(defaults, sizeof(Parser) * (default_cnt + 1));
+        defaults[default_cnt++] = *parser;
+        skipDefault(fn_compiler);
+        consume(fn_compiler, TOKEN_RPAREN, "expect ')' after a default");
+        if (parser->hadError) break;
+    }
+    if (!parser->hadError) {
+        consume(fn_compiler, TOKEN_RBRAKET, "Expect ']' after parameters");
+    }
+
+    Parser body = *parser;
+    for (int i = 0; i < default_cnt && !parser->hadError; i++) {
+        *parser = defaults[i];
+        Local* local = &fn_compiler->locals[function->min_arity + 1 + i];
+        compileDefault(fn_compiler, local->name, local->slot);
+        body.hadError = parser->hadError;
+        body.panicMode = parser->panicMode;
+    }
+    *parser = body;
+    free(defaults);
+    return !parser->hadError;
+}
+
 static ObjFunction* compileFunction(Compiler* compiler, Compiler* fn_compiler) {
+    // Every enclosing function waits on the VM stack while it compiles.
+    VM* vm = compiler->vm;
+    if ((size_t)(vm->stack_top - vm->stack) >= vm->options.stack_capacity) {
+        COMPILE_ERR(compiler, "functions nested too deeply for the VM stack");
+        return NULL;
+    }
     initCompiler(fn_compiler, compiler, compiler->module);
 
     push(compiler->vm, OBJ_VAL(fn_compiler->function));
@@ -417,35 +1038,36 @@ static ObjFunction* compileFunction(Compiler* compiler, Compiler* fn_compiler) {
     }
 
     consume(fn_compiler, TOKEN_LBRAKET, "expect '[' for function parameters");
-
-    if (fn_compiler->parser->current.type != TOKEN_RBRAKET) {
-        do {
-            fn_compiler->function->arity++;
-            if (fn_compiler->function->arity >= MAX_LOCALS) {
-                COMPILE_ERR(compiler, "Max function parameter limit reached");
-                return NULL;
-            }
-            Token param =
-                consume(fn_compiler, TOKEN_IDENTIFIER, "Expect parameter name");
-            if (fn_compiler->parser->hadError) return NULL;
-            addLocal(fn_compiler, param);
-        } while (fn_compiler->parser->current.type == TOKEN_IDENTIFIER);
+    if (!parseParams(compiler, fn_compiler)) return NULL;
+
+    // A leading string is a docstring unless it is the whole body, in which
+    // case it is the return value.
+    if (fn_compiler->parser->current.type == TOKEN_STRING &&
+        fn_compiler->parser->next.type != TOKEN_RPAREN) {
+        advance(fn_compiler);
+        Token doc = fn_compiler->parser->previous;
+        fn_compiler->function->doc =
+            copyString(fn_compiler->vm, doc.start, doc.length);
     }
 
-    consume(fn_compiler, TOKEN_RBRAKET, "Expect ']' after parameters");
-
 #define WILL_READ_BODY()                                  \
     (fn_compiler->parser->current.type != TOKEN_RPAREN && \
      fn_compiler->parser->current.type != TOKEN_ZERO &&   \
      fn_compiler->parser->current.type != TOKEN_EOF)
 
     bool is_empty_body = true;
+    bool last_was_let = false;
+    bool warned_unreachable = false;
     while (WILL_READ_BODY()) {
         int prev_locals = fn_compiler->local_count;
+        bool raises = isRaiseCall(fn_compiler);
         parseExpression(fn_compiler, false);
         if (fn_compiler->parser->hadError) return NULL;
         is_empty_body = false;
         bool defined_local = (fn_compiler->local_count > prev_locals);
+        last_was_let = defined_local;
+        warnUnreachable(fn_compiler, raises, WILL_READ_BODY(),
+                        &warned_unreachable);
         if (WILL_READ_BODY()) {
             // Don't pop a local let: its value on the stack IS the variable.
             if (!defined_local) emitByte(fn_compiler, OP_POP);
@@ -457,6 +1079,8 @@ static ObjFunction* compileFunction(Compiler* compiler, Compiler* fn_compiler) {
         // If the function body is empty, we emit a null value so it returns nil
         emitByte(fn_compiler, OP_NULL);
     }
+    warnUnusedLocals(fn_compiler, 1,
+                     fn_compiler->local_count - (last_was_let ? 1 : 0));
 
 #undef WILL_READ_BODY
 
@@ -464,12 +1088,29 @@ static ObjFunction* compileFunction(Compiler* compiler, Compiler* fn_compiler) {
     return function;
 }
 
+// Tells if a grouping opened by this token is a statement that belongs to a
+// block, like the let in ((let x 1) (+ x 1)), rather than a callee.
+static bool opensBlockStatement(TokenType type) {
+    switch (type) {
+        case TOKEN_LET_KW:
+        case TOKEN_SET_KW:
+        case TOKEN_IMPORT_KW:
+        case TOKEN_PRAGMA_KW:
+        case TOKEN_BREAKPOINT_KW:
+            return true;
+        default:
+            return false;
+    }
+}
+
 static void parsePairOrBlock(Compiler* compiler, bool is_tail) {
     beginScope(compiler);
     bool first_expr = true;
     bool last_was_let = false;
+    bool warned_unreachable = false;
     while (compiler->parser->current.type != TOKEN_RPAREN) {
         int prev_locals = compiler->local_count;
+        bool raises = isRaiseCall(compiler);
         parseExpression(compiler, false);
         if (compiler->parser->hadError) return;
         bool defined_local = (compiler->local_count > prev_locals);
@@ -483,6 +1124,9 @@ static void parsePairOrBlock(Compiler* compiler, bool is_tail) {
             break;
         }
         first_expr = false;
+        warnUnreachable(compiler, raises,
+                        compiler->parser->current.type != TOKEN_RPAREN,
+                        &warned_unreachable);
         if (compiler->parser->current.type != TOKEN_RPAREN) {
             // Don't pop a local let: its value on the stack IS the variable.
             if (!defined_local) emitByte(compiler, OP_POP);
@@ -493,61 +1137,338 @@ static void parsePairOrBlock(Compiler* compiler, bool is_tail) {
     endScope(compiler, last_was_let);
 }
 
-static void parseTry(Compiler* compiler) {
-    int jump_to = emitJump(compiler, OP_TRY_START);
+// (while cond body...) runs the body for as long as cond is truthy. It
+// evaluates to the value of the last run of the body, or null if it never ran.
+// The body is a block, so its lets are fresh on every iteration.
+static void parseWhile(Compiler* compiler) {
+    // The result so far sits in an unnamed local, so lets in cond and body
+    // get the slots above it.
+    emitByte(compiler, OP_NULL);
+    addLocal(compiler, (Token){.start = "", .length = 0});
+    if (compiler->parser->hadError) return;
+
+    int loop_start = currentChunk(compiler)->count;
     parseExpression(compiler, false);
     if (compiler->parser->hadError) return;
-    emitByte(compiler, OP_TRY_END);
-    patchJump(compiler, jump_to);
-}
+    int exit_jump = emitJump(compiler, OP_JUMP_IF_FALSE);
+    emitByte(compiler, OP_POP);
 
-static void parseList(Compiler* compiler) {
-    int len = 0;
-    while (compiler->parser->current.type != TOKEN_RBRAKET) {
+    beginScope(compiler);
+    bool is_empty_body = true;
+    bool last_was_let = false;
+    bool warned_unreachable = false;
+    while (compiler->parser->current.type != TOKEN_RPAREN) {
+        int prev_locals = compiler->local_count;
+        bool raises = isRaiseCall(compiler);
         parseExpression(compiler, false);
         if (compiler->parser->hadError) return;
-        len++;
-    }
-    if (len > UINT8_MAX) {
-        COMPILE_ERR(compiler, "List literal too long");
-        return;
+        is_empty_body = false;
+        bool defined_local = (compiler->local_count > prev_locals);
+        last_was_let = defined_local;
+        bool more = compiler->parser->current.type != TOKEN_RPAREN;
+        warnUnreachable(compiler, raises, more, &warned_unreachable);
+        // Don't pop a local let: its value on the stack IS the variable.
+        if (more && !defined_local) emitByte(compiler, OP_POP);
     }
-    consume(compiler, TOKEN_RBRAKET, "expect ']' after list literal");
-    if (compiler->parser->hadError) return;
-    emitBytes(compiler, OP_LIST, (uint8_t)(len & 0xff));
-}
+    if (is_empty_body) emitByte(compiler, OP_NULL);
+    endScope(compiler, last_was_let);
 
-// Idk: looks clumsy, but useful.
-static Token readStringOrIdentifier(Compiler* compiler, const char* error) {
-    consumeAnyOf(compiler, 2, (TokenType[]){TOKEN_STRING, TOKEN_IDENTIFIER},
-                 error);
-    return compiler->parser->previous;
+    // The body's value replaces the previous result.
+    emitBytes(compiler, OP_SLIDE, 1);
+    emitLoop(compiler, loop_start);
+    patchJump(compiler, exit_jump);
+    emitByte(compiler, OP_POP);
+
+    // The result stays on the stack as the value of the loop.
+    compiler->local_count--;
 }
 
-// There are 6 variations of import syntax:
-// 1) (import "module_name")
-// 2) (import module_name) -- equivalent to the one above, we keep the previous
-// one for backward compatibility 3) (import module_name as alias) 4) (import
-// "module_name" as alias) 5) (import module_name [foo bar baz]) 6) (import
-// "module_name" ["foo" "bar" "baz"])
-static void parseImport(Compiler* compiler) {
-    Token module_name_token = readStringOrIdentifier(
-        compiler, "expect module name as string or identifier");
-    if (compiler->parser->hadError) {
-        COMPILE_ERR(compiler, "Expect module name as string or identifier");
+// (for [x coll] body...) runs the body once per item of coll, with x bound
+// to the item: the items of a list or tuple, the characters of a string, the
+// [key value] entries of a dict or the integers from 0 below an integer. It
+// evaluates to the value of the last run of the body, or null if it never ran.
+// Like a while body, the body is a block that starts fresh on every run.
+static void parseFor(Compiler* compiler) {
+    consume(compiler, TOKEN_LBRAKET, "expect '[' after 'for'");
+    if (compiler->parser->hadError) return;
+    Token name = consume(compiler, TOKEN_IDENTIFIER,
+                         "expect a variable name in 'for'");
+    if (compiler->parser->hadError) return;
+    int prev_locals = compiler->local_count;
+    parseExpression(compiler, false);
+    if (compiler->parser->hadError) return;
+    if (compiler->local_count > prev_locals) {
+        COMPILE_ERR(compiler, "the collection of 'for' cannot be a let");
         return;
     }
-    ObjString* module_name_obj = copyString(
-        compiler->vm, module_name_token.start, module_name_token.length);
+    consume(compiler, TOKEN_RBRAKET, "expect ']' after the 'for' collection");
+    if (compiler->parser->hadError) return;
 
-    ObjModule* module = loadModule(compiler->vm, module_name_obj);
-    if (module == NULL) {
-        COMPILE_ERR(compiler, "could not load module %s",
-                    module_name_obj->chars);
-        return;
-    }
+    // The collection, the loop state and the result so far sit in unnamed
+    // locals, so lets in the body get the slots above them.
+    Token hidden = {.start = "", .length = 0};
+    addLocal(compiler, hidden);
+    emitByte(compiler, OP_FOR_INIT);
+    addLocal(compiler, hidden);
+    emitByte(compiler, OP_NULL);
+    addLocal(compiler, hidden);
+    if (compiler->parser->hadError) return;
 
-    if (compiler->parser->current.type == TOKEN_AS_KW) {
+    int loop_start = currentChunk(compiler)->count;
+    int exit_jump = emitJump(compiler, OP_FOR_NEXT);
+
+    beginScope(compiler);
+    addLocal(compiler, name);
+    bool is_empty_body = true;
+    bool last_was_let = false;
+    bool warned_unreachable = false;
+    while (compiler->parser->current.type != TOKEN_RPAREN) {
+        int prev_locals = compiler->local_count;
+        bool raises = isRaiseCall(compiler);
+        parseExpression(compiler, false);
+        if (compiler->parser->hadError) return;
+        is_empty_body = false;
+        bool defined_local = (compiler->local_count > prev_locals);
+        last_was_let = defined_local;
+        bool more = compiler->parser->current.type != TOKEN_RPAREN;
+        warnUnreachable(compiler, raises, more, &warned_unreachable);
+        // Don't pop a local let: its value on the stack IS the variable.
+        if (more && !defined_local) emitByte(compiler, OP_POP);
+    }
+    if (is_empty_body) emitByte(compiler, OP_NULL);
+    endScope(compiler, last_was_let);
+
+    // The body's value replaces the previous result.
+    emitBytes(compiler, OP_SLIDE, 1);
+    emitLoop(compiler, loop_start);
+    patchJump(compiler, exit_jump);
+
+    // The result stays on the stack as the value of the loop.
+    emitBytes(compiler, OP_SLIDE, 2);
+    compiler->local_count -= 3;
+}
+
+static void parseTry(Compiler* compiler) {
+    int jump_to = emitJump(compiler, OP_TRY_START);
+    compiler->try_depth++;
+    parseExpression(compiler, false);
+    compiler->try_depth--;
+    if (compiler->parser->hadError) return;
+    emitByte(compiler, OP_TRY_END);
+    patchJump(compiler, jump_to);
+}
+
+// (return expr) leaves the function with the value of expr, or null without
+// one, from anywhere in its body. The try blocks it is in are closed first.
+// Code after it still compiles as if the return yielded a value, though it
+// never runs.
+static void parseReturn(Compiler* compiler) {
+    if (compiler->enclosing == NULL) {
+        COMPILE_ERR(compiler, "return outside of a function");
+        return;
+    }
+    if (compiler->parser->current.type == TOKEN_RPAREN) {
+        emitByte(compiler, OP_NULL);
+    } else {
+        parseExpression(compiler, false);
+        if (compiler->parser->hadError) return;
+        // A tail call would leave the try blocks open.
+        if (compiler->try_depth == 0) maybePatchTailCall(compiler);
+    }
+    for (int i = 0; i < compiler->try_depth; i++) {
+        emitByte(compiler, OP_TRY_END);
+    }
+    emitReturn(compiler);
+}
+
+// Emits OP_LIST for the last pending items of a list literal, and appends
+// them to the list built from the earlier chunks, if there are any.
+static void emitListChunk(Compiler* compiler, int pending, bool first) {
+    emitBytes(compiler, OP_LIST, (uint8_t)pending);
+    if (!first) emitByte(compiler, OP_LIST_APPEND);
+}
+
+static void parseList(Compiler* compiler) {
+    int temps = compiler->temps;
+    int len = 0;
+    int pending = 0;
+    while (compiler->parser->current.type != TOKEN_RBRAKET) {
+        parseExpression(compiler, false);
+        if (compiler->parser->hadError) return;
+        compiler->temps++;
+        len++;
+        if (++pending == LIST_LITERAL_CHUNK) {
+            emitListChunk(compiler, pending, len == pending);
+            pending = 0;
+            // Only the list built so far stays on the stack.
+            compiler->temps = temps + 1;
+        }
+    }
+    compiler->temps = temps;
+    consume(compiler, TOKEN_RBRAKET, "expect ']' after list literal");
+    if (compiler->parser->hadError) return;
+    if (pending > 0 || len == 0) {
+        emitListChunk(compiler, pending, len == pending);
+    }
+}
+
+static void parseTuple(Compiler* compiler) {
+    int temps = compiler->temps;
+    int len = 0;
+    while (compiler->parser->current.type != TOKEN_RBRAKET) {
+        parseExpression(compiler, false);
+        if (compiler->parser->hadError) return;
+        compiler->temps++;
+        len++;
+    }
+    compiler->temps = temps;
+    if (len > UINT8_MAX) {
+        COMPILE_ERR(compiler, "Tuple literal too long");
+        return;
+    }
+    consume(compiler, TOKEN_RBRAKET, "expect ']' after tuple literal");
+    if (compiler->parser->hadError) return;
+    emitBytes(compiler, OP_TUPLE, (uint8_t)(len & 0xff));
+}
+
+// Idk: looks clumsy, but useful.
+static Token readStringOrIdentifier(Compiler* compiler, const char* error) {
+    consumeAnyOf(compiler, 2, (TokenType[]){TOKEN_STRING, TOKEN_IDENTIFIER},
+                 error);
+    return compiler->parser->previous;
+}
+
+// Compiles (.users[0].name expr): evaluates expr and walks the accessor path
+// with one OP_ACCESS per segment. Names are dict keys, [n] are list indices.
+static void parseAccess(Compiler* compiler) {
+    Token path = compiler->parser->previous;
+    if (compiler->parser->current.type == TOKEN_RPAREN) {
+        COMPILE_ERR(compiler, "expect a value to apply the accessor to");
+        return;
+    }
+    parseExpression(compiler, false);
+    if (compiler->parser->hadError) return;
+    if (compiler->parser->current.type != TOKEN_RPAREN) {
+        COMPILE_ERR(compiler, "An accessor takes exactly one argument");
+        return;
+    }
+
+    const char* p = path.start;
+    const char* end = path.start + path.length;
+    while (p < end) {
+        Value key;
+        if (*p == '.') {
+            const char* name = ++p;
+            while (p < end && *p != '.' && *p != '[') p++;
+            key = OBJ_VAL(copyString(compiler->vm, name, (int)(p - name)));
+        } else {
+            int64_t index = 0;
+            for (p++; *p != ']'; p++) {
+                index = index * 10 + (*p - '0');
+                if (index > INT32_MAX) {
+                    COMPILE_ERR(compiler, "Accessor index is too large");
+                    return;
+                }
+            }
+            p++;
+            key = INT_VAL(index);
+        }
+        int const_ix = addConstant(compiler->vm, currentChunk(compiler), key);
+        if (const_ix > UINT16_MAX) {
+            COMPILE_ERR(compiler, "Too many constants in one chunk");
+            return;
+        }
+        emitByte(compiler, OP_ACCESS);
+        emitBytes(compiler, (uint8_t)(const_ix >> 8),
+                  (uint8_t)(const_ix & 0xff));
+    }
+}
+
+// Parses (pragma :lang "M.N"), which records the language version the module
+// is written against. Pragmas must come first in the module.
+static void parsePragma(Compiler* compiler) {
+    if (compiler->enclosing != NULL || compiler->scope_depth > 0 ||
+        compiler->pragmas_closed) {
+        COMPILE_ERR(compiler,
+                    "pragma must precede all other expressions in a module");
+        return;
+    }
+    Token name =
+        consume(compiler, TOKEN_KEYWORD, "expect a pragma name like :lang");
+    if (compiler->parser->hadError) return;
+
+    if (name.length != 5 || memcmp(name.start, ":lang", 5) != 0) {
+        COMPILE_ERR(compiler, "Unknown pragma '%.*s'", name.length,
+                    name.start);
+        return;
+    }
+    Token version =
+        consume(compiler, TOKEN_STRING, "expect a version string after :lang");
+    if (compiler->parser->hadError) return;
+    int lang_version = 0;
+    if (!parseLangVersion(version.start, version.length, &lang_version) ||
+        lang_version < LANG_VERSION_MIN ||
+        lang_version > LANG_VERSION_LATEST) {
+        COMPILE_ERR(compiler, "Unsupported language version \"%.*s\"",
+                    version.length, version.start);
+        return;
+    }
+    compiler->module->lang_version = lang_version;
+    emitByte(compiler, OP_NULL);
+}
+
+// Makes alias name module_name in qualified names like alias:symbol. Fails
+// if the alias already names another module, rather than rebinding it.
+static bool addAlias(Compiler* compiler, ObjString* alias,
+                     ObjString* module_name) {
+    Value* taken = tableGet(&compiler->aliases, OBJ_VAL(alias));
+    if (taken != NULL && AS_STRING(*taken) != module_name) {
+        COMPILE_ERR(compiler,
+                    "'%s' names module '%s' already, import '%s' as another "
+                    "name with `as`",
+                    alias->chars, AS_STRING(*taken)->chars,
+                    module_name->chars);
+        return false;
+    }
+    tableInsert(&compiler->aliases, OBJ_VAL(alias), OBJ_VAL(module_name));
+    return true;
+}
+
+// There are 6 variations of import syntax:
+// 1) (import "module_name")
+// 2) (import module_name) -- equivalent to the one above, we keep the previous
+// one for backward compatibility 3) (import module_name as alias) 4) (import
+// "module_name" as alias) 5) (import module_name [foo bar baz]) 6) (import
+// "module_name" ["foo" "bar" "baz"])
+static void parseImport(Compiler* compiler) {
+    Token module_name_token = readStringOrIdentifier(
+        compiler, "expect module name as string or identifier");
+    if (compiler->parser->hadError) {
+        COMPILE_ERR(compiler, "Expect module name as string or identifier");
+        return;
+    }
+    ObjString* module_name_obj = copyString(
+        compiler->vm, module_name_token.start, module_name_token.length);
+
+    push(compiler->vm, OBJ_VAL(module_name_obj));
+    ObjModule* module = loadModule(compiler->vm, module_name_obj);
+    pop(compiler->vm);
+    if (module == NULL) {
+        // The loader raised why, e.g. the files it looked for.
+        Value reason = compiler->vm->raise_value;
+        if (IS_ERROR(reason)) {
+            COMPILE_ERR(compiler, "%s", AS_ERROR(reason)->message->chars);
+        } else {
+            COMPILE_ERR(compiler, "could not load module %s",
+                        module_name_obj->chars);
+        }
+        return;
+    }
+    // The module is known by its cleaned name, e.g. "utils/strings" for
+    // "./utils/strings".
+    module_name_obj = module->name;
+
+    if (compiler->parser->current.type == TOKEN_AS_KW) {
         advance(compiler);
         Token alias_token = readStringOrIdentifier(
             compiler, "expect alias as string or identifier");
@@ -558,13 +1479,19 @@ static void parseImport(Compiler* compiler) {
         ObjString* alias_obj =
             copyString(compiler->vm, alias_token.start, alias_token.length);
         // Insert alias -> module name mapping into the compiler's alias table.
-        tableInsert(&compiler->aliases, OBJ_VAL(alias_obj),
-                    OBJ_VAL(module_name_obj));
+        if (!addAlias(compiler, alias_obj, module_name_obj)) return;
     } else {
         // If there is no alias, we insert module name -> module name mapping,
-        // so we can resolve imports the same way.
-        tableInsert(&compiler->aliases, OBJ_VAL(module_name_obj),
-                    OBJ_VAL(module_name_obj));
+        // so we can resolve imports the same way. A module in a subdirectory
+        // goes by the last part of its name too, so "utils/strings" is
+        // strings:name.
+        if (!addAlias(compiler, module_name_obj, module_name_obj)) return;
+        const char* base = strrchr(module_name_obj->chars, '/');
+        if (base != NULL) {
+            ObjString* base_obj =
+                copyString(compiler->vm, base + 1, (int)strlen(base + 1));
+            if (!addAlias(compiler, base_obj, module_name_obj)) return;
+        }
     }
 
     if (compiler->parser->current.type == TOKEN_LBRAKET) {
@@ -604,6 +1531,9 @@ static void parseImport(Compiler* compiler) {
             // the table exceeded the "ought to be enogh for everyon" size.
             tableInsert(&compiler->module->imports, OBJ_VAL(symbol_obj),
                         *remote_ptr);
+            // The loader reads the name from module, see resolveGlobal.
+            tableInsert(&compiler->module->import_from, OBJ_VAL(symbol_obj),
+                        OBJ_VAL(module));
         }
         consume(compiler, TOKEN_RBRAKET, "expect `]` after the symbol list");
     }
@@ -642,6 +1572,7 @@ static void parsePipe(Compiler* compiler, bool is_tail) {
             extra++;
         }
         consume(compiler, TOKEN_RPAREN, "expect ')' after pipe step");
+        compiler->last_call = currentChunk(compiler)->count;
         emitBytes(compiler, OP_CALL, (uint8_t)(extra + 1));
     }
 
@@ -650,16 +1581,144 @@ static void parsePipe(Compiler* compiler, bool is_tail) {
     }
 }
 
-static void parseSwitch(Compiler* compiler, bool is_tail) {
+// Builds the jump table of a switch whose OP_SWITCH_TABLE placeholder is at
+// table_op, or drops the placeholder if there are too few literal arms to be
+// worth it. Targets are byte offsets from the end of the instruction.
+static void finishSwitchTable(Compiler* compiler, int table_op,
+                              Value* keys, int* targets, int cnt) {
+    Chunk* chunk = currentChunk(compiler);
+    int base = table_op + 3;
+    if (cnt < SWITCH_TABLE_MIN_ARMS) {
+        memmove(chunk->code + table_op, chunk->code + base,
+                chunk->count - base);
+        memmove(chunk->lines + table_op, chunk->lines + base,
+                (chunk->count - base) * sizeof(int));
+        chunk->count -= 3;
+        return;
+    }
+
+    VM* vm = compiler->vm;
+    ObjDict* table = newDict(vm);
+    push(vm, OBJ_VAL(table));
+    for (int i = 0; i < cnt; i++) {
+        table->root = hamtPut(vm, table->root, keys[i],
+                              INT_VAL(targets[i] - base), table->next_seq++,
+                              hamtKeyHash(vm, keys[i]), 0);
+        table->count++;
+    }
+    int constant = addConstant(vm, chunk, OBJ_VAL(table));
+    pop(vm);
+    if (constant > UINT16_MAX) {
+        COMPILE_ERR(compiler, "Too many constants in one chunk");
+        return;
+    }
+    chunk->code[table_op + 1] = (uint8_t)(constant >> 8);
+    chunk->code[table_op + 2] = (uint8_t)(constant & 0xff);
+}
+
+// Returns the int or string a literal switch arm compiled to, or null if the
+// arm is anything else. start is where the arm's code begins.
+static Value switchArmKey(Compiler* compiler, TokenType type, int start) {
+    Chunk* chunk = currentChunk(compiler);
+    if (type != TOKEN_INT && type != TOKEN_STRING) return NIL_VAL;
+    if (chunk->count != start + 3 || chunk->code[start] != OP_CONSTANT) {
+        return NIL_VAL;
+    }
+    return chunk->constants
+        .values[(chunk->code[start + 1] << 8) | chunk->code[start + 2]];
+}
+
+// Adds a literal arm value to the switch table with the offset of its body,
+// unless an earlier arm already claimed it. Returns false once the table is
+// full, which closes it to later arms.
+static bool addSwitchKey(Value keys[], int targets[], int* cnt, Value key,
+                         int target) {
+    for (int i = 0; i < *cnt; i++) {
+        if (valuesEqual(keys[i], key)) return true;
+    }
+    if (*cnt == MAX_SWITCH_ARMS) return false;
+    keys[*cnt] = key;
+    targets[(*cnt)++] = target;
+    return true;
+}
+
+static bool isComparison(TokenType type) {
+    switch (type) {
+        case TOKEN_EQUAL_OP:
+        case TOKEN_EQUAL_KW:
+        case TOKEN_NOT_EQUAL_OP:
+        case TOKEN_NOT_EQUAL_KW:
+        case TOKEN_GREATER_OP:
+        case TOKEN_GREATER_KW:
+        case TOKEN_GREATER_EQUAL_OP:
+        case TOKEN_GREATER_EQUAL_KW:
+        case TOKEN_LESS_OP:
+        case TOKEN_LESS_KW:
+        case TOKEN_LESS_EQUAL_OP:
+        case TOKEN_LESS_EQUAL_KW:
+            return true;
+        default:
+            return false;
+    }
+}
+
+// Emits the comparison of the two values on top of the stack that the
+// operator token stands for, as the binary forms compile it.
+static void emitComparison(Compiler* compiler, TokenType type) {
+    switch (type) {
+        case TOKEN_EQUAL_OP:
+        case TOKEN_EQUAL_KW:
+            emitByte(compiler, OP_EQUAL);
+            break;
+        case TOKEN_NOT_EQUAL_OP:
+        case TOKEN_NOT_EQUAL_KW:
+            emitBytes(compiler, OP_EQUAL, OP_NOT);
+            break;
+        case TOKEN_GREATER_OP:
+        case TOKEN_GREATER_KW:
+            emitByte(compiler, OP_GREATER);
+            break;
+        case TOKEN_GREATER_EQUAL_OP:
+        case TOKEN_GREATER_EQUAL_KW:
+            emitBytes(compiler, OP_LESS, OP_NOT);
+            break;
+        case TOKEN_LESS_OP:
+        case TOKEN_LESS_KW:
+            emitByte(compiler, OP_LESS);
+            break;
+        default:
+            emitBytes(compiler, OP_GREATER, OP_NOT);
+            break;
+    }
+}
+
+// Not inlined for the same reason as parseFn: the arm tables are sizeable.
+__attribute__((noinline)) static void parseSwitch(Compiler* compiler,
+                                                  bool is_tail) {
     parseExpression(compiler, false);
     if (compiler->parser->hadError) return;
 
     int N = compiler->local_count;
-    int end_jumps[64];
+    int end_jumps[MAX_SWITCH_ARMS];
     int end_jump_cnt = 0;
     bool has_default = false;
 
+    // Int and string literal arms also go into a table the subject is looked
+    // up in first. A hit jumps straight to the arm's body; a miss falls
+    // through to the compare chain. Only arms before the first one that could
+    // match a listed value some other way make it into the table.
+    int table_op = emitJump(compiler, OP_SWITCH_TABLE) - 1;
+    Value keys[MAX_SWITCH_ARMS];
+    int targets[MAX_SWITCH_ARMS];
+    int key_cnt = 0;
+    bool table_open = true;
+
     while (!has_default && compiler->parser->current.type == TOKEN_LBRAKET) {
+        if (end_jump_cnt == MAX_SWITCH_ARMS) {
+            COMPILE_ERR(compiler, "switch has more than %d arms",
+                        MAX_SWITCH_ARMS);
+            return;
+        }
         consume(compiler, TOKEN_LBRAKET, "expect '[' in switch arm");
         if (compiler->parser->hadError) return;
 
@@ -684,13 +1743,13 @@ static void parseSwitch(Compiler* compiler, bool is_tail) {
             has_default = true;
         } else if (ptype == TOKEN_LPAREN) {
             advance(compiler);
-            Token head =
-                consume(compiler, TOKEN_IDENTIFIER, "expect pattern head");
-            if (compiler->parser->hadError) return;
-
-            bool is_err = head.length == 3 && memcmp(head.start, "err", 3) == 0;
-            bool is_pair =
-                head.length == 4 && memcmp(head.start, "pair", 4) == 0;
+            Token head = compiler->parser->current;
+            bool is_name = head.type == TOKEN_IDENTIFIER;
+            bool is_err = is_name && head.length == 3 &&
+                          memcmp(head.start, "err", 3) == 0;
+            bool is_pair = is_name && head.length == 4 &&
+                           memcmp(head.start, "pair", 4) == 0;
+            if (is_err || is_pair) advance(compiler);
 
             if (is_err) {
                 Token msg_sym = consume(compiler, TOKEN_IDENTIFIER,
@@ -736,21 +1795,93 @@ static void parseSwitch(Compiler* compiler, bool is_tail) {
                 end_jumps[end_jump_cnt++] = emitJump(compiler, OP_JUMP);
                 patchJump(compiler, no_match);
                 emitByte(compiler, OP_POP);
+            } else if (isComparison(head.type)) {
+                // (< 10) compares the subject with the operand, which can
+                // match any number of values, so no table past this arm.
+                advance(compiler);
+                emitByte(compiler, OP_DUP);
+                parseExpression(compiler, false);
+                if (compiler->parser->hadError) return;
+                consume(compiler, TOKEN_RPAREN,
+                        "expect ')' to close comparison pattern");
+                if (compiler->parser->hadError) return;
+                emitComparison(compiler, head.type);
+                table_open = false;
+                int no_match = emitJump(compiler, OP_JUMP_IF_FALSE);
+                emitByte(compiler, OP_POP);
+                emitByte(compiler, OP_POP);
+                parseExpression(compiler, is_tail);
+                if (compiler->parser->hadError) return;
+                end_jumps[end_jump_cnt++] = emitJump(compiler, OP_JUMP);
+                patchJump(compiler, no_match);
+                emitByte(compiler, OP_POP);
             } else {
-                COMPILE_ERR(
-                    compiler,
-                    "Unknown pattern head '%.*s': expected 'err' or 'pair'",
-                    head.length, head.start);
-                return;
+                // (1 2 3) matches if the subject equals any of the values.
+                // Each equal one jumps to the shared pop of the subject.
+                int matched[MAX_SWITCH_ARMS];
+                int matched_cnt = 0;
+                Value arm_keys[MAX_SWITCH_ARMS];
+                int arm_key_cnt = 0;
+                while (compiler->parser->current.type != TOKEN_RPAREN) {
+                    if (matched_cnt == MAX_SWITCH_ARMS) {
+                        COMPILE_ERR(compiler,
+                                    "switch arm has more than %d values",
+                                    MAX_SWITCH_ARMS);
+                        return;
+                    }
+                    TokenType vtype = compiler->parser->current.type;
+                    emitByte(compiler, OP_DUP);
+                    int start = currentChunk(compiler)->count;
+                    parseExpression(compiler, false);
+                    if (compiler->parser->hadError) return;
+                    Value key = switchArmKey(compiler, vtype, start);
+                    if (IS_NIL(key)) {
+                        table_open = false;
+                    } else if (table_open) {
+                        arm_keys[arm_key_cnt++] = key;
+                    }
+                    emitByte(compiler, OP_EQUAL);
+                    int next = emitJump(compiler, OP_JUMP_IF_FALSE);
+                    emitByte(compiler, OP_POP);
+                    matched[matched_cnt++] = emitJump(compiler, OP_JUMP);
+                    patchJump(compiler, next);
+                    emitByte(compiler, OP_POP);
+                }
+                if (matched_cnt == 0) {
+                    COMPILE_ERR(compiler, "expect a value in switch pattern");
+                    return;
+                }
+                advance(compiler);
+                int no_match = emitJump(compiler, OP_JUMP);
+                for (int i = 0; i < matched_cnt; i++) {
+                    patchJump(compiler, matched[i]);
+                }
+                emitByte(compiler, OP_POP);
+                int body = currentChunk(compiler)->count;
+                for (int i = 0; i < arm_key_cnt && table_open; i++) {
+                    table_open = addSwitchKey(keys, targets, &key_cnt,
+                                              arm_keys[i], body);
+                }
+                parseExpression(compiler, is_tail);
+                if (compiler->parser->hadError) return;
+                end_jumps[end_jump_cnt++] = emitJump(compiler, OP_JUMP);
+                patchJump(compiler, no_match);
             }
         } else {
             emitByte(compiler, OP_DUP);
+            int start = currentChunk(compiler)->count;
             parseExpression(compiler, false);
             if (compiler->parser->hadError) return;
+            Value key = switchArmKey(compiler, ptype, start);
+            if (IS_NIL(key)) table_open = false;
             emitByte(compiler, OP_EQUAL);
             int no_match = emitJump(compiler, OP_JUMP_IF_FALSE);
             emitByte(compiler, OP_POP);
             emitByte(compiler, OP_POP);
+            if (table_open) {
+                table_open = addSwitchKey(keys, targets, &key_cnt, key,
+                                          currentChunk(compiler)->count);
+            }
             parseExpression(compiler, is_tail);
             if (compiler->parser->hadError) return;
             end_jumps[end_jump_cnt++] = emitJump(compiler, OP_JUMP);
@@ -770,9 +1901,389 @@ static void parseSwitch(Compiler* compiler, bool is_tail) {
     for (int i = 0; i < end_jump_cnt; i++) {
         patchJump(compiler, end_jumps[i]);
     }
+    finishSwitchTable(compiler, table_op, keys, targets, key_cnt);
+}
+
+// Returns true if name is a local of this compiler or of any enclosing one.
+// Unlike resolveUpvalue, it does not capture anything.
+static bool isLocalName(Compiler* compiler, Token name) {
+    for (Compiler* current = compiler; current != NULL;
+         current = current->enclosing) {
+        if (resolveLocal(current, name) != -1) return true;
+    }
+    return false;
+}
+
+// Statically looks a callee name up, following the same lookup order the
+// loader uses. Returns NULL if the name is a local or an unknown symbol.
+static Value* lookupCallee(Compiler* compiler, Token name) {
+    if (isLocalName(compiler, name)) return NULL;
+
+    Value* value = NULL;
+    int module_name_ix = indexOf(name.start, name.length, ':');
+    if (module_name_ix != -1) {
+        ObjString* raw_name =
+            copyString(compiler->vm, name.start, module_name_ix);
+        Value* actual_name = NULL;
+        for (Compiler* current = compiler; current != NULL;
+             current = current->enclosing) {
+            actual_name = tableGet(&current->aliases, OBJ_VAL(raw_name));
+            if (actual_name != NULL) break;
+        }
+        Value module_name =
+            (actual_name != NULL) ? *actual_name : OBJ_VAL(raw_name);
+        Value* module = tableGet(&compiler->vm->modules, module_name);
+        if (module == NULL) return NULL;
+        ObjString* var_name =
+            copyString(compiler->vm, name.start + module_name_ix + 1,
+                       name.length - module_name_ix - 1);
+        value = tableGet(&AS_MODULE(*module)->symbols, OBJ_VAL(var_name));
+    } else {
+        ObjString* var_name =
+            copyString(compiler->vm, name.start, name.length);
+        value = resolveGlobal(compiler->vm, compiler->module,
+                              OBJ_VAL(var_name));
+    }
+    return value;
+}
+
+// Counts the bindings of name in the source being compiled, see
+// Compiler.defined.
+static int definedCount(Compiler* compiler, Token name) {
+    Compiler* script = compiler;
+    while (script->enclosing != NULL) script = script->enclosing;
+    int count = 0;
+    for (int i = 0; i < script->defined_cnt; i++) {
+        if (identifiersEqual(&script->defined[i], &name)) count++;
+    }
+    return count;
+}
+
+// Returns true if the source being compiled binds name somewhere.
+static bool isDefinedName(Compiler* compiler, Token name) {
+    return definedCount(compiler, name) > 0;
+}
+
+// Statically resolves a callee name to a native function. Returns NULL if the
+// name is a local, a user defined global, an unknown symbol, anything but a
+// native, or a name the source defines, even after the call.
+static ObjNative* resolveNative(Compiler* compiler, Token name) {
+    if (isDefinedName(compiler, name)) return NULL;
+    Value* value = lookupCallee(compiler, name);
+    if (value == NULL || !IS_NATIVE(*value)) return NULL;
+    return AS_NATIVE(*value);
+}
+
+// Statically resolves a callee name to the function it calls: one defined
+// earlier in the script, or a closure the name is bound to already, like an
+// import or a definition from an earlier REPL line. Returns NULL if the
+// callee is only known at run time, which includes a name the script binds
+// more than once, or only after the call.
+static ObjFunction* resolveFunction(Compiler* compiler, Token name) {
+    if (isLocalName(compiler, name)) return NULL;
+    int defined = definedCount(compiler, name);
+    if (defined > 1) return NULL;
+    if (defined == 1) {
+        Compiler* script = compiler;
+        while (script->enclosing != NULL) script = script->enclosing;
+        ValueArray* constants = &script->function->chunk.constants;
+        for (int i = constants->count - 1; i >= 0; i--) {
+            if (!IS_FUNCTION(constants->values[i])) continue;
+            ObjFunction* function = AS_FUNCTION(constants->values[i]);
+            if (function->name != NULL &&
+                function->name->length == name.length &&
+                memcmp(function->name->chars, name.start, name.length) == 0) {
+                return function;
+            }
+        }
+        return NULL;
+    }
+    Value* value = lookupCallee(compiler, name);
+    if (value == NULL || !IS_CLOSURE(*value)) return NULL;
+    return AS_CLOSURE(*value)->function;
+}
+
+// Returns true if the parser is at a call to the core raise! native, which
+// never returns to the enclosing block. Only the unreachable code warning
+// asks, so without warnings this is always false.
+static bool isRaiseCall(Compiler* compiler) {
+    if (!compiler->vm->options.warnings) return false;
+    Parser* parser = compiler->parser;
+    if (parser->current.type != TOKEN_LPAREN ||
+        parser->next.type != TOKEN_IDENTIFIER) {
+        return false;
+    }
+    ObjNative* native = resolveNative(compiler, parser->next);
+    return native != NULL && strcmp(native->name->chars, "raise!") == 0;
+}
+
+// Returns the type name of a literal argument judging by its first token, or
+// NULL if the argument is not a literal.
+static const char* literalTypeName(TokenType type) {
+    switch (type) {
+        case TOKEN_INT:          return "int";
+        case TOKEN_REAL:         return "real";
+        case TOKEN_STRING:       return "string";
+        case TOKEN_TRUE_KW:
+        case TOKEN_FALSE_KW:     return "bool";
+        case TOKEN_NULL_KW:      return "null";
+        case TOKEN_LBRAKET:      return "list";
+        case TOKEN_HASH_LBRAKET: return "tuple";
+        default:                 return NULL;
+    }
+}
+
+// Checks a literal argument against the kind a native declares for it in
+// ObjNative.params. Returns the expected kind description on a mismatch and
+// NULL if the argument is acceptable or not checked.
+static const char* checkLiteralArg(ObjNative* native, int index,
+                                   const char* type_name) {
+    if (native->params == NULL || type_name == NULL) return NULL;
+    if (index >= (int)strlen(native->params)) return NULL;
+
+    bool is_num = strcmp(type_name, "int") == 0 ||
+                  strcmp(type_name, "real") == 0;
+    bool is_str = strcmp(type_name, "string") == 0;
+    bool is_list = strcmp(type_name, "list") == 0;
+    bool is_tuple = strcmp(type_name, "tuple") == 0;
+    switch (native->params[index]) {
+        case 'n': return is_num ? NULL : "an int or real";
+        case 'i': return strcmp(type_name, "int") == 0 ? NULL : "an int";
+        case 's': return is_str ? NULL : "a string";
+        case 'l': return is_list ? NULL : "a list";
+        // Dicts have no literal syntax, so any literal is a mismatch.
+        case 'd': return "a dict";
+        case 'c':
+            return (is_str || is_list || is_tuple)
+                       ? NULL
+                       : "a string, list, tuple or dict";
+        default:  return NULL;
+    }
+}
+
+// Consumes the :name of a keyword argument and checks that a value follows
+// and that no earlier keyword of the call has the same name.
+static Token keywordArg(Compiler* compiler, const Token* seen, int seen_cnt) {
+    Token key = consume(compiler, TOKEN_KEYWORD, "expect a keyword argument");
+    if (compiler->parser->hadError) return key;
+    for (int i = 0; i < seen_cnt; i++) {
+        if (seen[i].length == key.length &&
+            memcmp(seen[i].start, key.start, key.length) == 0) {
+            COMPILE_ERR(compiler, "keyword argument '%.*s' given twice",
+                        key.length, key.start);
+            return key;
+        }
+    }
+    TokenType next = compiler->parser->current.type;
+    if (next == TOKEN_KEYWORD || next == TOKEN_RPAREN) {
+        COMPILE_ERR(compiler, "expect a value after '%.*s'", key.length,
+                    key.start);
+    }
+    return key;
+}
+
+// Checks the keyword arguments of a call, the count names in keys, against
+// the parameters of function, the callee known at compile time, which
+// arg_cnt positional arguments precede.
+static void checkKeywordArgs(Compiler* compiler, ObjFunction* function,
+                             int arg_cnt, const Token* keys, int count) {
+    bool given[MAX_LOCALS] = {false};
+    int last = arg_cnt;
+    const char* name = function->name ? function->name->chars : "fn";
+    for (int k = 0; k < count; k++) {
+        Token key = keys[k];
+        int param = -1;
+        for (int i = 0; i < function->arity; i++) {
+            ObjString* param_name = AS_STRING(function->params->items[i]);
+            if (param_name->length == key.length - 1 &&
+                memcmp(param_name->chars, key.start + 1, key.length - 1) ==
+                    0) {
+                param = i;
+                break;
+            }
+        }
+        if (param == -1) {
+            COMPILE_ERR(compiler, "'%s' has no parameter '%.*s'", name,
+                        key.length - 1, key.start + 1);
+            return;
+        }
+        if (param < arg_cnt) {
+            COMPILE_ERR(compiler,
+                        "'%.*s' of '%s' is given by position already",
+                        key.length - 1, key.start + 1, name);
+            return;
+        }
+        given[param] = true;
+        if (param + 1 > last) last = param + 1;
+    }
+    for (int i = arg_cnt; i < last && i < function->min_arity; i++) {
+        if (given[i]) continue;
+        ObjString* param_name = AS_STRING(function->params->items[i]);
+        COMPILE_ERR(compiler, "call to '%s' leaves out '%s'", name,
+                    param_name->chars);
+        return;
+    }
+}
+
+// Compiles the keyword arguments at the end of a call, each name without the
+// colon followed by its value, in the order they are written. The VM binds
+// them to the parameters of the callee when the call is made, see
+// OP_CALL_KW. When function, the callee known at compile time, is not NULL
+// they are checked against its parameters. Returns the number of keyword
+// arguments.
+static int compileKeywordArgs(Compiler* compiler, ObjFunction* function,
+                              int arg_cnt) {
+    Token seen[MAX_ARITY];
+    int count = 0;
+    while (compiler->parser->current.type == TOKEN_KEYWORD) {
+        if (count == MAX_ARITY) {
+            COMPILE_ERR(compiler, "Too many keyword arguments in a call");
+            return count;
+        }
+        Token key = keywordArg(compiler, seen, count);
+        if (compiler->parser->hadError) return count;
+        seen[count++] = key;
+        emitConstant(compiler, OBJ_VAL(copyString(compiler->vm, key.start + 1,
+                                                  key.length - 1)));
+        compiler->temps++;
+        parseExpression(compiler, false);
+        if (compiler->parser->hadError) return count;
+        compiler->temps++;
+    }
+    if (function != NULL && function->params != NULL) {
+        checkKeywordArgs(compiler, function, arg_cnt, seen, count);
+    }
+    return count;
+}
+
+// Kept out of parseGrouping so that only fn forms pay for the Compiler on the
+// C stack, not every nested call.
+__attribute__((noinline)) static void parseFn(Compiler* compiler) {
+    // Only the function a let binds directly takes the let's name.
+    Token binding = compiler->fn_binding;
+    compiler->fn_binding = (Token){0};
+
+    Token fn_name = {0};
+    bool is_named_fn = false;
+    if (compiler->parser->current.type == TOKEN_IDENTIFIER) {
+        fn_name = consume(compiler, TOKEN_IDENTIFIER,
+                          "expect function name after 'fn'");
+        if (compiler->parser->hadError) return;
+        is_named_fn = true;
+        if (compiler->scope_depth > 0) {
+            addLocal(compiler, fn_name);
+        }
+    } else if (binding.length > 0 && compiler->scope_depth > 0) {
+        // The closure lands in the let's slot, so the body can capture it
+        // and call itself, as a named function does.
+        addLocal(compiler, binding);
+        compiler->fn_bound = true;
+    }
+
+    Compiler fn_compiler;
+    ObjFunction* func = compileFunction(compiler, &fn_compiler);
+    if (compiler->parser->hadError) return;
+    if (is_named_fn) {
+        func->name = copyString(compiler->vm, fn_name.start, fn_name.length);
+    } else if (binding.length > 0) {
+        func->name = copyString(compiler->vm, binding.start, binding.length);
+    }
+    func->usage = functionUsage(&fn_compiler);
+    recordParams(&fn_compiler);
+
+    int arg =
+        addConstant(compiler->vm, currentChunk(compiler), OBJ_VAL(func));
+    emitByte(compiler, OP_CLOSURE);
+    emitBytes(compiler, (uint8_t)(arg >> 8), (uint8_t)(arg & 0xff));
+    for (int i = 0; i < func->upvalue_cnt; i++) {
+        emitByte(compiler, fn_compiler.upvalues[i].is_local ? 1 : 0);
+        emitByte(compiler, fn_compiler.upvalues[i].index);
+    }
+
+    pop(compiler->vm);
+
+    if (is_named_fn) {
+        if (compiler->scope_depth > 0) {
+            int local = resolveLocal(compiler, fn_name);
+            if (local == -1) {
+                COMPILE_ERR(compiler,
+                            "Failed to resolve local variable for function "
+                            "name '%.*s'",
+                            fn_name.length, fn_name.start);
+                return;
+            }
+            emitBytes(compiler, OP_SET_LOCAL, compiler->locals[local].slot);
+        } else {
+            int var_name_ix = identifierConstant(compiler, fn_name);
+            Value name = currentChunk(compiler)->constants.values[var_name_ix];
+            if (tableGet(&compiler->module->consts, name) != NULL) {
+                COMPILE_ERR(compiler, "Cannot redeclare const '%.*s'",
+                            fn_name.length, fn_name.start);
+                return;
+            }
+            // A function may be defined again, replacing the old one.
+            if (tableGet(&compiler->module->symbols, name) == NULL &&
+                !declareGlobal(compiler, name, var_name_ix, NIL_VAL)) {
+                return;
+            }
+            int symbol = defineSymbol(compiler, fn_name, SYMBOL_FUNCTION);
+            if (symbol >= 0) {
+                addSymbolDocs(compiler->vm->symbols, symbol, OBJ_VAL(func));
+            }
+            emitByte(compiler, OP_SET_GLOBAL);
+            emitBytes(compiler, (uint8_t)(var_name_ix >> 8),
+                      (uint8_t)(var_name_ix & 0xff));
+        }
+    }
+}
+
+// (+) is 0 and (*) is 1, their identities. No other operator goes without
+// operands.
+static void parseNoOperands(Compiler* compiler, Token op) {
+    switch (op.type) {
+        case TOKEN_PLUS_OP:
+        case TOKEN_PLUS_KW:
+            emitConstant(compiler, INT_VAL(0));
+            break;
+        case TOKEN_STAR_OP:
+        case TOKEN_STAR_KW:
+            emitConstant(compiler, INT_VAL(1));
+            break;
+        case TOKEN_MINUS_OP:
+        case TOKEN_MINUS_KW:
+            COMPILE_ERR(compiler, "`%.*s` expects 1 or 2 operands, got 0",
+                        op.length, op.start);
+            break;
+        default:
+            COMPILE_ERR(compiler, "`%.*s` expects 2 operands, got 0",
+                        op.length, op.start);
+            break;
+    }
+}
+
+// (+ x) and (* x) are x and (- x) negates x, once x is on the stack. Any other
+// operator needs a second operand.
+static void parseOneOperand(Compiler* compiler, Token op) {
+    switch (op.type) {
+        case TOKEN_PLUS_OP:
+        case TOKEN_PLUS_KW:
+        case TOKEN_STAR_OP:
+        case TOKEN_STAR_KW:
+            break;
+        case TOKEN_MINUS_OP:
+        case TOKEN_MINUS_KW:
+            emitByte(compiler, OP_NEGATE);
+            break;
+        default:
+            COMPILE_ERR(compiler, "`%.*s` expects 2 operands, got 1",
+                        op.length, op.start);
+            break;
+    }
 }
 
 static void parseGrouping(Compiler* compiler, bool is_tail) {
+    // Operands count as temporaries while the rest of the form is compiled.
+    int temps = compiler->temps;
     switch (compiler->parser->current.type) {
         case TOKEN_AND_KW:
             advance(compiler);
@@ -790,65 +2301,35 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
             advance(compiler);
             parseLet(compiler);
             break;
-        case TOKEN_FN_KW:
+        case TOKEN_CONST_KW:
             advance(compiler);
-            Token fn_name = {0};
-            bool is_named_fn = false;
-            if (compiler->parser->current.type == TOKEN_IDENTIFIER) {
-                fn_name = consume(compiler, TOKEN_IDENTIFIER,
-                                  "expect function name after 'fn'");
-                if (compiler->parser->hadError) return;
-                is_named_fn = true;
-                if (compiler->scope_depth > 0) {
-                    addLocal(compiler, fn_name);
-                }
-            }
-
-            Compiler fn_compiler;
-            ObjFunction* func = compileFunction(compiler, &fn_compiler);
+            parseConst(compiler);
+            break;
+        case TOKEN_SET_KW:
+            advance(compiler);
+            parseSet(compiler);
             if (compiler->parser->hadError) return;
-            if (is_named_fn) {
-                func->name =
-                    copyString(compiler->vm, fn_name.start, fn_name.length);
-            }
-
-            int arg = addConstant(compiler->vm, currentChunk(compiler),
-                                  OBJ_VAL(func));
-            emitByte(compiler, OP_CLOSURE);
-            emitBytes(compiler, (uint8_t)(arg >> 8), (uint8_t)(arg & 0xff));
-            for (int i = 0; i < func->upvalue_cnt; i++) {
-                emitByte(compiler, fn_compiler.upvalues[i].is_local ? 1 : 0);
-                emitByte(compiler, fn_compiler.upvalues[i].index);
-            }
-
-            pop(compiler->vm);
-
-            if (is_named_fn) {
-                if (compiler->scope_depth > 0) {
-                    int local_slot = resolveLocal(compiler, fn_name);
-                    if (local_slot == -1) {
-                        COMPILE_ERR(compiler,
-                                    "Failed to resolve local variable for "
-                                    "function name '%.*s'",
-                                    fn_name.length, fn_name.start);
-                        return;
-                    }
-                    emitBytes(compiler, OP_SET_LOCAL, (uint8_t)local_slot);
-                } else {
-                    int var_name_ix = identifierConstant(compiler, fn_name);
-                    Value name =
-                        currentChunk(compiler)->constants.values[var_name_ix];
-                    tableInsert(&compiler->module->symbols, name, NIL_VAL);
-                    emitByte(compiler, OP_SET_GLOBAL);
-                    emitBytes(compiler, (uint8_t)(var_name_ix >> 8),
-                              (uint8_t)(var_name_ix & 0xff));
-                }
-            }
+            break;
+        case TOKEN_FN_KW:
+            advance(compiler);
+            parseFn(compiler);
             break;
         case TOKEN_TRY_KW:
             advance(compiler);
             parseTry(compiler);
             break;
+        case TOKEN_RETURN_KW:
+            advance(compiler);
+            parseReturn(compiler);
+            break;
+        case TOKEN_WHILE_KW:
+            advance(compiler);
+            parseWhile(compiler);
+            break;
+        case TOKEN_FOR_KW:
+            advance(compiler);
+            parseFor(compiler);
+            break;
         case TOKEN_SWITCH_KW:
             advance(compiler);
             parseSwitch(compiler, is_tail);
@@ -861,6 +2342,16 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
             advance(compiler);
             parseImport(compiler);
             break;
+        case TOKEN_PRAGMA_KW:
+            advance(compiler);
+            parsePragma(compiler);
+            if (compiler->parser->hadError) return;
+            break;
+        case TOKEN_ACCESSOR:
+            advance(compiler);
+            parseAccess(compiler);
+            if (compiler->parser->hadError) return;
+            break;
         case TOKEN_NOT_OP:
         case TOKEN_NOT_KW:
             advance(compiler);
@@ -875,6 +2366,22 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
             if (compiler->parser->hadError) return;
             emitByte(compiler, OP_BNOT);
             break;
+        case TOKEN_APPROX_EQUAL_OP:
+        case TOKEN_APPROX_EQUAL_KW:
+            // (~= a b) or (~= a b eps)
+            advance(compiler);
+            parseExpression(compiler, false);
+            if (compiler->parser->hadError) return;
+            parseExpression(compiler, false);
+            if (compiler->parser->hadError) return;
+            if (compiler->parser->current.type != TOKEN_RPAREN) {
+                parseExpression(compiler, false);
+                if (compiler->parser->hadError) return;
+            } else {
+                emitConstant(compiler, REAL_VAL(APPROX_EQUAL_EPSILON));
+            }
+            emitByte(compiler, OP_APPROX_EQUAL);
+            break;
         case TOKEN_PLUS_OP:
         case TOKEN_PLUS_KW:
         case TOKEN_MINUS_OP:
@@ -907,13 +2414,24 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
         case TOKEN_LSHIFT_KW:
         case TOKEN_RSHIFT_OP:
         case TOKEN_RSHIFT_KW: {
-            TokenType op = compiler->parser->current.type;
+            Token op_token = compiler->parser->current;
+            TokenType op = op_token.type;
             advance(compiler);
+            if (compiler->parser->current.type == TOKEN_RPAREN) {
+                parseNoOperands(compiler, op_token);
+                break;
+            }
             parseExpression(compiler, false);
             if (compiler->parser->hadError) return;
+            if (compiler->parser->current.type == TOKEN_RPAREN) {
+                parseOneOperand(compiler, op_token);
+                break;
+            }
+            compiler->temps++;
 
             while (compiler->parser->current.type != TOKEN_RPAREN) {
                 parseExpression(compiler, false);
+                if (compiler->parser->hadError) return;
                 switch (op) {
                     // Tokens with 2+ arity:
                     case TOKEN_PLUS_OP:
@@ -999,11 +2517,13 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
             // A grouping is either a function call or a block of 1+
             // expressions. The disambiguation strategy is the following:
             // 1. If the next token is an identifier, it is a function call.
-            // 2. If the next token is an open parenthesis and the next after is
-            // a FN keyword,
-            //   it is a function call with an anonymous function as the callee.
+            // 2. If the next token is an open parenthesis, the callee is
+            //   whatever that expression evaluates to, like a call result in
+            //   ((get handlers "x") 1), unless it opens a let, set!, import,
+            //   pragma or breakpoint: those only make sense in a block.
             // 3. If the second token is TOKEN_DOT, it is a pair
             // 4. Otherwise, it's a block of expressions.
+            bool paren_callee = false;
             switch (compiler->parser->current.type) {
                 case TOKEN_IDENTIFIER:
                     if (compiler->parser->next.type == TOKEN_DOT) {
@@ -1012,9 +2532,10 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
                     }
                     break;  // It's a function call, we will parse it below
                 case TOKEN_LPAREN:
-                    if (compiler->parser->next.type == TOKEN_FN_KW) {
-                        break;  // It's a function call with an anonymous
-                                // function callee
+                    if (!opensBlockStatement(compiler->parser->next.type)) {
+                        paren_callee = true;
+                        break;  // It's a function call, the callee is
+                                // evaluated first
                     }
                     // Otherwise, it's a block
                 default:
@@ -1022,39 +2543,114 @@ static void parseGrouping(Compiler* compiler, bool is_tail) {
                     goto END_PARSE_GROUPING;
             }
 
+            // Calls to natives are checked against the declared arity and
+            // argument kinds, so (len) or (len 42) fail at compile time.
+            ObjNative* native = NULL;
+            Token callee = {0};
+            if (compiler->parser->current.type == TOKEN_IDENTIFIER) {
+                native = resolveNative(compiler, compiler->parser->current);
+                callee = compiler->parser->current;
+            }
+            bool raises = paren_callee && isRaiseCall(compiler);
             parseExpression(compiler, false);
+            if (compiler->parser->hadError) return;
+            compiler->temps++;
+            if (paren_callee && compiler->parser->current.type == TOKEN_DOT) {
+                // Not a call after all: ((f x) . y) is a pair.
+                consume(compiler, TOKEN_DOT,
+                        "expect `.` when initializing a pair");
+                parseExpression(compiler, false);
+                if (compiler->parser->hadError) return;
+                emitByte(compiler, OP_PAIR);
+                goto END_PARSE_GROUPING;
+            }
+            bool warned_unreachable = false;
+            warnUnreachable(compiler, raises,
+                            compiler->parser->current.type != TOKEN_RPAREN,
+                            &warned_unreachable);
             int arg_count = 0;
-            while (compiler->parser->current.type != TOKEN_RPAREN) {
+            while (compiler->parser->current.type != TOKEN_RPAREN &&
+                   compiler->parser->current.type != TOKEN_KEYWORD) {
                 if (arg_count > MAX_ARITY) {
                     COMPILE_ERR(compiler,
                                 "Too many arguments in a function call");
                     return;
                 }
+                const char* arg_type =
+                    literalTypeName(compiler->parser->current.type);
+                const char* expected =
+                    native ? checkLiteralArg(native, arg_count, arg_type)
+                           : NULL;
+                if (expected != NULL) {
+                    COMPILE_ERR(compiler,
+                                "Native function '%s': argument %d must be "
+                                "%s, got %s",
+                                native->name->chars, arg_count + 1, expected,
+                                arg_type);
+                    return;
+                }
                 parseExpression(compiler, false);
                 if (compiler->parser->hadError) return;
+                compiler->temps++;
                 arg_count++;
             }
-            emitBytes(compiler, is_tail ? OP_TAIL_CALL : OP_CALL,
-                      (uint8_t)arg_count);
+            int kw_cnt = 0;
+            if (compiler->parser->current.type == TOKEN_KEYWORD) {
+                ObjFunction* function =
+                    callee.length > 0 ? resolveFunction(compiler, callee)
+                                      : NULL;
+                kw_cnt = compileKeywordArgs(compiler, function, arg_count);
+                if (compiler->parser->hadError) return;
+                if (compiler->parser->current.type != TOKEN_RPAREN) {
+                    COMPILE_ERR(compiler,
+                                "positional arguments must come before "
+                                "keyword arguments");
+                    return;
+                }
+                if (arg_count + kw_cnt > MAX_ARITY) {
+                    COMPILE_ERR(compiler,
+                                "Too many arguments in a function call");
+                    return;
+                }
+            }
+            // A native gets the keyword arguments as one trailing dict.
+            int passed = kw_cnt > 0 ? arg_count + 1 : arg_count;
+            if (native != NULL && native->arity >= 0 &&
+                native->arity != passed) {
+                COMPILE_ERR(compiler,
+                            "Native function '%s': expected %d arguments but "
+                            "got %d",
+                            native->name->chars, native->arity, passed);
+                return;
+            }
+            if (native != NULL && native->deprecated != NULL &&
+                !(compiler->vm->options.warn_deprecated_once &&
+                  native->deprecation_warned)) {
+                COMPILE_NOTE(compiler, compiler->parser->current.line,
+                             "'%s' is deprecated, use '%s' instead",
+                             native->name->chars, native->deprecated);
+                native->deprecation_warned = true;
+            }
+            compiler->last_call = currentChunk(compiler)->count;
+            if (kw_cnt > 0) {
+                emitBytes(compiler, is_tail ? OP_TAIL_CALL_KW : OP_CALL_KW,
+                          (uint8_t)arg_count);
+                emitByte(compiler, (uint8_t)kw_cnt);
+            } else {
+                emitBytes(compiler, is_tail ? OP_TAIL_CALL : OP_CALL,
+                          (uint8_t)arg_count);
+            }
             break;
         }
     }
 
 END_PARSE_GROUPING:
+    compiler->temps = temps;
     consume(compiler, TOKEN_RPAREN, "expect ')' after expression");
 }
 
-// Returns the index of the first occurrence of ch in str, or -1 if not found.
-static int indexOf(const char* str, const size_t len, const char ch) {
-    for (int i = 0; i < len; i++) {
-        if (str[i] == ch) {
-            return i;
-        }
-    }
-    return -1;
-}
-
 static void namedVariable(Compiler* compiler, Token name) {
+    referSymbol(compiler, name);
     // Check if the name contains ":". If it does, it is a module-qualified
     // name.
     int module_name_ix = indexOf(name.start, name.length, ':');
@@ -1088,7 +2684,8 @@ static void namedVariable(Compiler* compiler, Token name) {
     // Try local lookup first
     int arg = resolveLocal(compiler, name);
     if (arg != -1) {
-        emitBytes(compiler, OP_GET_LOCAL, (uint8_t)arg);
+        compiler->locals[arg].used = true;
+        emitBytes(compiler, OP_GET_LOCAL, compiler->locals[arg].slot);
         return;
     }
 
@@ -1099,6 +2696,15 @@ static void namedVariable(Compiler* compiler, Token name) {
         return;
     }
 
+    // Consts compile to their values
+    ObjString* const_name = copyString(compiler->vm, name.start, name.length);
+    Value* const_val =
+        tableGet(&compiler->module->consts, OBJ_VAL(const_name));
+    if (const_val != NULL) {
+        emitConstant(compiler, *const_val);
+        return;
+    }
+
     // Fall back to global lookup
     int const_index = identifierConstant(compiler, name);
 
@@ -1113,6 +2719,14 @@ static void namedVariable(Compiler* compiler, Token name) {
 }
 
 static void parseExpression(Compiler* compiler, bool is_tail) {
+    int max_nesting = compiler->vm->options.max_nesting;
+    if (compiler->parser->depth >= max_nesting) {
+        COMPILE_ERR(compiler,
+                    "expression at column %d nested more than %d levels deep",
+                    compiler->parser->current.column, max_nesting);
+        return;
+    }
+    compiler->parser->depth++;
     switch (compiler->parser->current.type) {
         case TOKEN_INT:
         case TOKEN_REAL:
@@ -1153,10 +2767,15 @@ static void parseExpression(Compiler* compiler, bool is_tail) {
             advance(compiler);
             parseList(compiler);
             break;
+        case TOKEN_HASH_LBRAKET:
+            advance(compiler);
+            parseTuple(compiler);
+            break;
         default:
             COMPILE_ERR(compiler, "Expected expression");
             break;
     }
+    compiler->parser->depth--;
 }
 
 void markCompilerRoots(VM* vm) {
@@ -1171,7 +2790,70 @@ void markCompilerRoots(VM* vm) {
     }
 }
 
-ObjFunction* compile(VM* vm, const char* source, ObjModule* module) {
+// Skips the top-level form the parser is at, which failed to compile, up to
+// its closing bracket or the end of the source. The error has been reported,
+// so errors met on the way are not.
+static void skipForm(Compiler* compiler) {
+    Parser* parser = compiler->parser;
+    parser->hadError = true;
+    int depth = 0;
+    do {
+        TokenType type = parser->current.type;
+        if (type == TOKEN_EOF || type == TOKEN_ZERO) break;
+        if (type == TOKEN_LPAREN || type == TOKEN_LBRAKET ||
+            type == TOKEN_HASH_LBRAKET) {
+            depth++;
+        } else if (type == TOKEN_RPAREN || type == TOKEN_RBRAKET) {
+            depth--;
+        }
+        advance(compiler);
+    } while (depth > 0);
+    parser->hadError = false;
+    parser->panicMode = false;
+}
+
+static void addDefinedName(Compiler* compiler, Token name) {
+    if (compiler->defined_cnt == compiler->defined_cap) {
+        compiler->defined_cap = GROW_CAPACITY(compiler->defined_cap);
+        compiler->defined = realloc(
+            compiler->defined, sizeof(Token) * (size_t)compiler->defined_cap);
+        if (compiler->defined == NULL) exit(1);
+    }
+    compiler->defined[compiler->defined_cnt++] = name;
+}
+
+// Fills compiler->defined with the names source binds: (fn name ...),
+// (let name ...), (let [a b] ...) and (const name ...), at any depth.
+static void scanDefinedNames(Compiler* compiler, const char* source) {
+    Scanner scanner;
+    initScanner(&scanner, source);
+    Token prev = {0};
+    Token token = scanToken(&scanner);
+    while (token.type != TOKEN_EOF) {
+        Token next = scanToken(&scanner);
+        bool binds = prev.type == TOKEN_LPAREN &&
+                     (token.type == TOKEN_FN_KW || token.type == TOKEN_LET_KW ||
+                      token.type == TOKEN_CONST_KW);
+        if (binds && next.type == TOKEN_IDENTIFIER) {
+            addDefinedName(compiler, next);
+        } else if (binds && next.type == TOKEN_LBRAKET &&
+                   token.type == TOKEN_LET_KW) {
+            next = scanToken(&scanner);
+            while (next.type == TOKEN_IDENTIFIER) {
+                addDefinedName(compiler, next);
+                next = scanToken(&scanner);
+            }
+        }
+        prev = token;
+        token = next;
+    }
+}
+
+// Compiles source as the top level of module. With single_expr, anything
+// after the first expression is an error.
+static ObjFunction* compileSource(VM* vm, const char* source,
+                                  ObjModule* module, bool single_expr,
+                                  bool redefine) {
     Parser parser;
     initParser(&parser);
     initScanner(&parser.scanner, source);
@@ -1180,20 +2862,62 @@ ObjFunction* compile(VM* vm, const char* source, ObjModule* module) {
     compiler.vm = vm;
     compiler.parser = &parser;
     compiler.added_globals_cnt = 0;
+    compiler.added_globals_cap = 0;
+    compiler.added_globals = NULL;
+    compiler.redefine = redefine;
+    compiler.pragmas_closed = false;
     void* prev_compiler = vm->compiler;
     vm->compiler = &compiler;
     initCompiler(&compiler, NULL, module);
     push(vm, OBJ_VAL(compiler.function));
+    scanDefinedNames(&compiler, source);
 
     advance(&compiler);
 
 #define WILL_READ_BODY() (compiler.parser->current.type != TOKEN_EOF)
 
+    bool warned_unreachable = false;
     do {
+        bool raises = isRaiseCall(&compiler);
+        bool is_pragma = compiler.parser->current.type == TOKEN_LPAREN &&
+                         compiler.parser->next.type == TOKEN_PRAGMA_KW;
+        TokenType head = compiler.parser->current.type == TOKEN_LPAREN
+                             ? compiler.parser->next.type
+                             : TOKEN_EOF;
+        Parser form = parser;
+        int local_count = compiler.local_count;
+        Value* stack_top = vm->stack_top;
         parseExpression(&compiler, false);
-        if (compiler.parser->hadError) break;
+        if (compiler.parser->hadError) {
+            if (single_expr) break;
+            // Report the error and go on from the next form, so one run
+            // shows the errors of every form.
+            parser = form;
+            parser.errors++;
+            skipForm(&compiler);
+            compiler.local_count = local_count;
+            compiler.scope_depth = 0;
+            compiler.temps = 0;
+            compiler.try_depth = 0;
+            compiler.last_call = -1;
+            compiler.fn_binding = (Token){0};
+            compiler.fn_bound = false;
+            vm->stack_top = stack_top;
+            continue;
+        }
+        if (single_expr && WILL_READ_BODY()) {
+            COMPILE_ERR(&compiler, "expect a single expression");
+            break;
+        }
+        if (!is_pragma) compiler.pragmas_closed = true;
+        warnUnreachable(&compiler, raises, WILL_READ_BODY(),
+                        &warned_unreachable);
         if (WILL_READ_BODY()) {
             emitByte(&compiler, OP_POP);
+        } else if (!vm->options.defs_yield_value &&
+                   definedGlobal(&compiler, head)) {
+            // A definition is not the value of the script.
+            emitBytes(&compiler, OP_POP, OP_NULL);
         } else {
             maybePatchTailCall(&compiler);
         }
@@ -1201,10 +2925,13 @@ ObjFunction* compile(VM* vm, const char* source, ObjModule* module) {
 
 #undef WILL_READ_BODY
 
+    if (parser.errors > 0) parser.hadError = true;
     if (compiler.parser->hadError) {
         for (int i = 0; i < compiler.added_globals_cnt; i++) {
             tableRemove(&compiler.function->module->symbols,
                         compiler.added_globals[i]);
+            tableRemove(&compiler.function->module->consts,
+                        compiler.added_globals[i]);
         }
         goto END_COMPILE;
     }
@@ -1213,7 +2940,34 @@ ObjFunction* compile(VM* vm, const char* source, ObjModule* module) {
     ObjFunction* function = endCompiler(&compiler);
 
 END_COMPILE:
+    FREE_ARRAY(Value, vm, compiler.added_globals, compiler.added_globals_cap);
+    free(compiler.defined);
     pop(vm);  // pop the compiler.function
     vm->compiler = prev_compiler;
     return parser.hadError ? NULL : function;
 }
+
+ObjFunction* compile(VM* vm, const char* source, ObjModule* module) {
+    return compileSource(vm, source, module, false, false);
+}
+
+ObjFunction* recompile(VM* vm, const char* source, ObjModule* module) {
+    return compileSource(vm, source, module, false, true);
+}
+
+ObjClosure* compileExpr(VM* vm, const char* source, ObjModule* env) {
+    push(vm, OBJ_VAL(env));
+    ObjFunction* function = compileSource(vm, source, env, true, false);
+    if (function == NULL) {
+        pop(vm);
+        return NULL;
+    }
+    push(vm, OBJ_VAL(function));
+    ObjClosure* closure = newClosure(vm, function);
+    push(vm, OBJ_VAL(closure));
+    pinValue(vm, OBJ_VAL(closure));
+    pop(vm);
+    pop(vm);
+    pop(vm);
+    return closure;
+}
@@ -5,9 +5,17 @@
 #include "vm.h"
 
 #define MAX_LOCALS 256
-#define MAX_GLOBALS 1024
 #define MAX_UPVALUES 256
 #define MAX_ARITY 255
+#define MAX_SWITCH_ARMS 64
+#define MAX_LOGIC_OPERANDS 100
+// A list literal keeps at most this many items on the VM stack: longer ones
+// are built a chunk at a time and appended to the list made so far.
+#define LIST_LITERAL_CHUNK 32
+// A switch needs this many int or string literal arms to get a jump table.
+#define SWITCH_TABLE_MIN_ARMS 4
+// Default tolerance of (~= a b) when no explicit epsilon is given.
+#define APPROX_EQUAL_EPSILON 1e-9
 
 typedef struct {
     Scanner scanner;
@@ -16,11 +24,17 @@ typedef struct {
     Token next;
     bool hadError;
     bool panicMode;
+    int depth;   // Nesting of the expression being parsed
+    int errors;  // Top-level forms that failed to compile so far
 } Parser;
 
 typedef struct {
     Token name;
     int depth;
+    bool is_let;  // Bound by `let`, so it is reported if never used
+    bool used;
+    uint8_t slot;  // Stack slot, past the temporaries pushed below the local
+    int symbol;    // Its definition in vm->symbols, or -1
 } Local;
 
 typedef struct {
@@ -42,15 +56,62 @@ struct Compiler {
     Local locals[MAX_LOCALS];
     int local_count;
     int scope_depth;
+    // Values the enclosing expressions keep on the stack while their operands
+    // are compiled, like the callee and the arguments so far of a call. A
+    // local declared in an operand sits above them.
+    int temps;
 
     int upvalue_cnt;
     Upvalue upvalues[MAX_UPVALUES];
 
+    // Try blocks open around the code being compiled, which a return inside
+    // them has to close.
+    int try_depth;
+
+    // Offset of the last OP_CALL emitted, or -1. An operand byte can hold
+    // the same value, so the opcode alone does not tell a call ended the code.
+    int last_call;
+
+    // Globals declared by this compilation, removed again if it fails. It
+    // grows as needed: a REPL session or generated code may declare many.
     int added_globals_cnt;
-    Value added_globals[MAX_GLOBALS];
+    int added_globals_cap;
+    Value* added_globals;
+    // Compiling a module again over its own globals, see recompile. A let or
+    // const of an existing global reuses it instead of failing.
+    bool redefine;
+
+    // Set once a top-level expression other than a pragma is compiled.
+    bool pragmas_closed;
+
+    // Name of a let whose initializer is an (fn ...), so an anonymous
+    // function takes it and can call itself by it. fn_bound tells the let
+    // that the function already declared the local.
+    Token fn_binding;
+    bool fn_bound;
+
+    // Names the source binds with fn, let or const anywhere, found before it
+    // is compiled. A call to one of them is not checked against a native of
+    // that name, as it may be shadowed by a definition further down. Only
+    // the outermost compiler has them.
+    Token* defined;
+    int defined_cnt;
+    int defined_cap;
 };
 
 ObjFunction* compile(VM* vm, const char* source, ObjModule* module);
+
+// Like compile, for source that module has already run. Its globals are
+// declared again in place, so the values others hold pointers to are updated
+// when the result runs.
+ObjFunction* recompile(VM* vm, const char* source, ObjModule* module);
+
+// Compiles a single expression into a closure that reads the globals of env,
+// so a host can compile a formula once and evaluate it with callExpr many
+// times, updating the globals it refers to in between with defineConst.
+// Globals must exist before the first call. The closure stays alive until
+// freeExpr. Returns NULL on a compile error, described in vm->error_msg.
+ObjClosure* compileExpr(VM* vm, const char* source, ObjModule* env);
 void markCompilerRoots(VM* vm);
 
 #endif
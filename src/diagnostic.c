@@ -0,0 +1,60 @@
+#include "diagnostic.h"
+
+#include <string.h>
+
+#include "common.h"
+#include "vm.h"
+
+// Prints the gutter of a source line, as wide as its number, without one.
+static void printGutter(FILE* out, int width) {
+    fprintf(out, "%*s |", width, "");
+}
+
+// Prints the marker line under source, with the caret under column. Tabs are
+// kept so the caret lines up however they are shown, and a multi-byte
+// character takes one space.
+static void printCaret(FILE* out, int width, const char* source, int column) {
+    printGutter(out, width);
+    fputc(' ', out);
+    size_t len = strlen(source);
+    for (size_t i = 0; i + 1 < (size_t)column && i < len; i++) {
+        unsigned char c = (unsigned char)source[i];
+        if ((c & 0xC0) == 0x80) continue;  // UTF-8 continuation byte
+        fputc(c == '\t' ? '\t' : ' ', out);
+    }
+    fputs("^\n", out);
+}
+
+void printDiagnostics(VM* vm, FILE* out, const char* main_file) {
+    if (vm->diagnostic_cnt == 0) {
+        fprintf(out, "%s\n", vm->error_msg);
+        return;
+    }
+    int kept = vm->diagnostic_cnt < DIAGNOSTICS_MAX ? vm->diagnostic_cnt
+                                                    : DIAGNOSTICS_MAX;
+    for (int i = 0; i < kept; i++) {
+        Diagnostic* diag = &vm->diagnostics[i];
+        if (i > 0) fputc('\n', out);
+        fprintf(out, "error: %s\n", diag->message);
+        if (strcmp(diag->module, "main") == 0 && main_file != NULL) {
+            fprintf(out, " --> %s:%d", main_file, diag->line);
+        } else {
+            fprintf(out, " --> %s%s:%d", diag->module, LISS_FILE_EXT,
+                    diag->line);
+        }
+        if (diag->column > 0) fprintf(out, ":%d", diag->column);
+        fputc('\n', out);
+        if (diag->source_line[0] == '\0') continue;
+
+        int width = snprintf(NULL, 0, "%d", diag->line);
+        printGutter(out, width);
+        fputc('\n', out);
+        fprintf(out, "%d | %s\n", diag->line, diag->source_line);
+        if (diag->column > 0) {
+            printCaret(out, width, diag->source_line, diag->column);
+        }
+    }
+    if (vm->diagnostic_cnt > kept) {
+        fprintf(out, "\n... and %d more errors\n", vm->diagnostic_cnt - kept);
+    }
+}
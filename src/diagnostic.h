@@ -0,0 +1,37 @@
+#ifndef liss_diagnostic_h
+#define liss_diagnostic_h
+
+#include <stdio.h>
+
+// The errors a failed compile keeps with their place in the source. Any past
+// this many still count, and are in vm->error_msg, but are not kept.
+#define DIAGNOSTICS_MAX 16
+
+// A compile error from the scanner, parser or compiler, with enough of the
+// source to show where it is.
+typedef struct {
+    char module[64];  // Name of the module the source was compiled for
+    int line;
+    int column;  // 1-based byte offset into the line, 0 if unknown
+    char source_line[160];  // The line itself, cut short if it is longer
+    char message[256];
+} Diagnostic;
+
+typedef struct VM VM;
+
+// Prints the diagnostics of the last failed compile, each as the message,
+// the file, line and column, and the source line with a caret under the
+// column:
+//
+//     error: `-` expects 1 or 2 operands, got 0
+//      --> script.liss:3:11
+//       |
+//     3 | (let b (- ))
+//       |           ^
+//
+// The main module is named main_file, such as the path of the script, and
+// other modules the file they are loaded from. Without diagnostics, prints
+// vm->error_msg as it is.
+void printDiagnostics(VM* vm, FILE* out, const char* main_file);
+
+#endif
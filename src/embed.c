@@ -0,0 +1,23 @@
+#include "embed.h"
+
+#include <stddef.h>
+#include <string.h>
+
+// #embed gives the bytes of a file without a terminator, so one is added.
+static const char seq_source[] = {
+#embed "../stdlib/seq.liss"
+    , '\0'};
+
+static const EmbeddedModule embedded_modules[] = {
+    {"seq", seq_source},
+};
+
+const char* embeddedModule(const char* name) {
+    size_t count = sizeof(embedded_modules) / sizeof(embedded_modules[0]);
+    for (size_t i = 0; i < count; i++) {
+        if (strcmp(embedded_modules[i].name, name) == 0) {
+            return embedded_modules[i].source;
+        }
+    }
+    return NULL;
+}
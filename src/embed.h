@@ -0,0 +1,15 @@
+#ifndef liss_embed_h
+#define liss_embed_h
+
+// A Liss module whose source is compiled into the interpreter from stdlib/, so
+// it can be imported without installing any files.
+typedef struct {
+    const char* name;
+    const char* source;
+} EmbeddedModule;
+
+// Returns the source of the bundled module called name, or NULL if there is
+// none.
+const char* embeddedModule(const char* name);
+
+#endif
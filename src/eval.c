@@ -0,0 +1,677 @@
+#include "eval.h"
+
+#include <errno.h>
+#include <math.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "compiler.h"
+#include "object.h"
+#include "scanner.h"
+#include "utf8.h"
+#include "vm.h"
+
+const char* evalBinary(VM* vm, OpCode op, Value a, Value b, Value* out) {
+    switch (op) {
+        case OP_EQUAL:
+            *out = BOOL_VAL(valuesEqual(a, b));
+            return NULL;
+        case OP_LESS:
+        case OP_GREATER: {
+            bool less;
+            bool greater;
+            if (IS_STRING(a) && IS_STRING(b)) {
+                int cmp = compareText(AS_STRING(a)->chars, AS_STRING(a)->length,
+                                      AS_STRING(b)->chars,
+                                      AS_STRING(b)->length);
+                less = cmp < 0;
+                greater = cmp > 0;
+            } else if (a.type != b.type || !IS_NUMERIC(a)) {
+                return "only numbers of one type or strings compare";
+            } else {
+                less = IS_INT(a) ? AS_INT(a) < AS_INT(b)
+                                 : AS_REAL(a) < AS_REAL(b);
+                greater = IS_INT(a) ? AS_INT(a) > AS_INT(b)
+                                    : AS_REAL(a) > AS_REAL(b);
+            }
+            *out = BOOL_VAL(op == OP_LESS ? less : greater);
+            return NULL;
+        }
+        default:
+            break;
+    }
+    if (op == OP_ADD && IS_STRING(a) && IS_STRING(b)) {
+        ObjString* left = AS_STRING(a);
+        ObjString* right = AS_STRING(b);
+        int length = left->length + right->length;
+        char* chars = (char*)malloc(length + 1);
+        if (chars == NULL) return "out of memory";
+        memcpy(chars, left->chars, left->length);
+        memcpy(chars + left->length, right->chars, right->length);
+        chars[length] = '\0';
+        *out = OBJ_VAL(copyString(vm, chars, length));
+        free(chars);
+        return NULL;
+    }
+    if (op == OP_MULTIPLY && IS_STRING(a) && IS_INT(b)) {
+        ObjString* str = AS_STRING(a);
+        int64_t count = AS_INT(b);
+        if (count < 0) return "the count of copies is negative";
+        if (str->length > 0 && count > INT32_MAX / str->length) {
+            return "the string would be too long";
+        }
+        int length = str->length * (int)count;
+        char* chars = (char*)malloc(length + 1);
+        if (chars == NULL) return "out of memory";
+        for (int64_t i = 0; i < count; i++) {
+            memcpy(chars + i * str->length, str->chars, str->length);
+        }
+        chars[length] = '\0';
+        *out = OBJ_VAL(copyString(vm, chars, length));
+        free(chars);
+        return NULL;
+    }
+    if (op != OP_ADD && op != OP_SUBTRACT && op != OP_MULTIPLY &&
+        op != OP_DIVIDE) {
+        if (!IS_INT(a) || !IS_INT(b)) return "operands must be ints";
+        int64_t x = AS_INT(a);
+        int64_t y = AS_INT(b);
+        switch (op) {
+            case OP_MODULO:
+                if (y == 0) return "integer modulo by zero";
+                *out = INT_VAL(x % y);
+                break;
+            case OP_BAND:
+                *out = INT_VAL(x & y);
+                break;
+            case OP_BOR:
+                *out = INT_VAL(x | y);
+                break;
+            case OP_BXOR:
+                *out = INT_VAL(x ^ y);
+                break;
+            case OP_LSHIFT:
+                *out = INT_VAL(x << y);
+                break;
+            default:
+                *out = INT_VAL(x >> y);
+                break;
+        }
+        return NULL;
+    }
+    if (!IS_NUMERIC(a) || !IS_NUMERIC(b)) return "operands must be numbers";
+    if (IS_INT(a) && IS_INT(b)) {
+        int64_t x = AS_INT(a);
+        int64_t y = AS_INT(b);
+        switch (op) {
+            case OP_ADD:
+                *out = INT_VAL(x + y);
+                break;
+            case OP_SUBTRACT:
+                *out = INT_VAL(x - y);
+                break;
+            case OP_MULTIPLY:
+                *out = INT_VAL(x * y);
+                break;
+            default:
+                if (y == 0) return "integer division by zero";
+                *out = INT_VAL(x / y);
+                break;
+        }
+        return NULL;
+    }
+    double x = IS_INT(a) ? (double)AS_INT(a) : AS_REAL(a);
+    double y = IS_INT(b) ? (double)AS_INT(b) : AS_REAL(b);
+    switch (op) {
+        case OP_ADD:
+            *out = REAL_VAL(x + y);
+            break;
+        case OP_SUBTRACT:
+            *out = REAL_VAL(x - y);
+            break;
+        case OP_MULTIPLY:
+            *out = REAL_VAL(x * y);
+            break;
+        default:
+            *out = REAL_VAL(x / y);
+            break;
+    }
+    return NULL;
+}
+
+const char* evalUnary(OpCode op, Value value, Value* out) {
+    switch (op) {
+        case OP_NOT:
+            *out = BOOL_VAL(isFalsey(value));
+            return NULL;
+        case OP_NEGATE:
+            if (IS_INT(value)) {
+                *out = INT_VAL(-AS_INT(value));
+            } else if (IS_REAL(value)) {
+                *out = REAL_VAL(-AS_REAL(value));
+            } else {
+                return "operand of - must be a number";
+            }
+            return NULL;
+        default:
+            if (!IS_INT(value)) return "operand of ~ must be an int";
+            *out = INT_VAL(~AS_INT(value));
+            return NULL;
+    }
+}
+
+// A form of the source: an atom, or the forms between a bracket and the one
+// that closes it.
+typedef struct Node Node;
+struct Node {
+    Token token;  // The atom, or the opening bracket
+    Node* items;
+    int count;
+};
+
+// A name a let bound, to the value in a slot of the VM stack.
+typedef struct {
+    Token name;
+    int slot;
+    int scope;  // Depth of the block the let is in, 0 at the top level
+} Binding;
+
+typedef struct {
+    VM* vm;
+    Scanner scanner;
+    Token current;
+    int depth;  // Brackets the form being read is in
+    int scope;  // Blocks the form being evaluated is in
+    Binding* bindings;
+    int binding_cnt;
+    EvalResult result;
+    char* error;
+    size_t error_size;
+} Evaluator;
+
+// Records why the evaluation stops. Returns false, for the caller to return.
+static bool fail(Evaluator* ev, EvalResult result, const char* fmt, ...) {
+    ev->result = result;
+    va_list args;
+    va_start(args, fmt);
+    vsnprintf(ev->error, ev->error_size, fmt, args);
+    va_end(args);
+    return false;
+}
+
+static void freeNode(Node* node) {
+    // The scanner unescapes a string into a buffer of its own.
+    if (node->token.type == TOKEN_STRING) free((char*)node->token.start);
+    for (int i = 0; i < node->count; i++) freeNode(&node->items[i]);
+    free(node->items);
+}
+
+// Reads the next form into node, which is freeable even on failure.
+static bool readNode(Evaluator* ev, Node* node) {
+    *node = (Node){.token = ev->current};
+    Token token = ev->current;
+    if (token.type == TOKEN_ERROR) {
+        node->token.type = TOKEN_ZERO;
+        return fail(ev, EVAL_ERROR, "[line %d] %.*s", token.line,
+                    token.length, token.start);
+    }
+    ev->current = scanToken(&ev->scanner);
+    TokenType closing;
+    switch (token.type) {
+        case TOKEN_LPAREN:
+            closing = TOKEN_RPAREN;
+            break;
+        case TOKEN_LBRAKET:
+        case TOKEN_HASH_LBRAKET:
+            closing = TOKEN_RBRAKET;
+            break;
+        case TOKEN_RPAREN:
+        case TOKEN_RBRAKET:
+            return fail(ev, EVAL_ERROR, "[line %d] unexpected '%.*s'",
+                        token.line, token.length, token.start);
+        default:
+            return true;
+    }
+    if (ev->depth >= ev->vm->options.max_nesting) {
+        return fail(ev, EVAL_ERROR,
+                    "[line %d] expression nested more than %d levels deep",
+                    token.line, ev->vm->options.max_nesting);
+    }
+    ev->depth++;
+    int capacity = 0;
+    while (ev->current.type != closing) {
+        if (ev->current.type == TOKEN_EOF) {
+            return fail(ev, EVAL_ERROR, "[line %d] expect '%s' to close '%.*s'",
+                        token.line, closing == TOKEN_RPAREN ? ")" : "]",
+                        token.length, token.start);
+        }
+        if (node->count == capacity) {
+            capacity = capacity < 4 ? 4 : capacity * 2;
+            node->items = realloc(node->items, sizeof(Node) * capacity);
+        }
+        if (!readNode(ev, &node->items[node->count++])) return false;
+    }
+    ev->current = scanToken(&ev->scanner);
+    ev->depth--;
+    return true;
+}
+
+// Pushes a value to keep it from the collector while it is used.
+static bool keep(Evaluator* ev, Value value) {
+    VM* vm = ev->vm;
+    if ((size_t)(vm->stack_top - vm->stack) >= vm->options.stack_capacity) {
+        return fail(ev, EVAL_UNSUPPORTED, "the source needs over %zu values",
+                    vm->options.stack_capacity);
+    }
+    push(vm, value);
+    return true;
+}
+
+// Leaves the result of a form on the stack in place of the values it was
+// computed from, unless a let in the form bound one of them.
+static bool settle(Evaluator* ev, Value* mark, int bindings, Value result) {
+    if (ev->binding_cnt == bindings) ev->vm->stack_top = mark;
+    return keep(ev, result);
+}
+
+static bool evalNode(Evaluator* ev, Node* node, Value* out);
+
+static bool evalAtom(Evaluator* ev, Node* node, Value* out) {
+    Token token = node->token;
+    switch (token.type) {
+        case TOKEN_INT:
+        case TOKEN_REAL: {
+            char buf[64];
+            if (token.length >= (int)sizeof(buf)) {
+                return fail(ev, EVAL_ERROR, "[line %d] number literal out of "
+                            "range", token.line);
+            }
+            memcpy(buf, token.start, token.length);
+            buf[token.length] = '\0';
+            errno = 0;
+            if (token.type == TOKEN_INT) {
+                *out = INT_VAL(strtoll(buf, NULL, 0));
+            } else {
+                *out = REAL_VAL(strtod(buf, NULL));
+            }
+            if (errno == ERANGE) {
+                return fail(ev, EVAL_ERROR, "[line %d] number literal out of "
+                            "range", token.line);
+            }
+            break;
+        }
+        case TOKEN_STRING:
+            *out = OBJ_VAL(copyString(ev->vm, token.start, token.length));
+            break;
+        case TOKEN_TRUE_KW:
+            *out = BOOL_VAL(true);
+            break;
+        case TOKEN_FALSE_KW:
+            *out = BOOL_VAL(false);
+            break;
+        case TOKEN_NULL_KW:
+            *out = NIL_VAL;
+            break;
+        case TOKEN_IDENTIFIER:
+            for (int i = ev->binding_cnt - 1; i >= 0; i--) {
+                Token name = ev->bindings[i].name;
+                if (name.length == token.length &&
+                    memcmp(name.start, token.start, token.length) == 0) {
+                    *out = ev->vm->stack[ev->bindings[i].slot];
+                    return keep(ev, *out);
+                }
+            }
+            return fail(ev, EVAL_UNSUPPORTED,
+                        "[line %d] '%.*s' is not bound in the source",
+                        token.line, token.length, token.start);
+        default:
+            return fail(ev, EVAL_UNSUPPORTED,
+                        "[line %d] '%.*s' is not a pure expression", token.line,
+                        token.length, token.start);
+    }
+    return keep(ev, *out);
+}
+
+// Evaluates the forms of node in order, to the value of the last. The names
+// its lets bind go out of scope after it.
+static bool evalBlock(Evaluator* ev, Node* node, Value* out) {
+    Value* mark = ev->vm->stack_top;
+    int bindings = ev->binding_cnt;
+    ev->scope++;
+    for (int i = 0; i < node->count; i++) {
+        if (!evalNode(ev, &node->items[i], out)) return false;
+    }
+    ev->scope--;
+    ev->binding_cnt = bindings;
+    return settle(ev, mark, bindings, *out);
+}
+
+static bool evalLet(Evaluator* ev, Node* node, Value* out) {
+    if (node->count != 3 || node->items[1].token.type != TOKEN_IDENTIFIER) {
+        return fail(ev, EVAL_UNSUPPORTED,
+                    "[line %d] only (let name value) binds in the evaluator",
+                    node->token.line);
+    }
+    Token name = node->items[1].token;
+    // A block may shadow a name of the blocks around it, but not its own.
+    for (int i = 0; i < ev->binding_cnt; i++) {
+        Token bound = ev->bindings[i].name;
+        if (ev->bindings[i].scope == ev->scope &&
+            bound.length == name.length &&
+            memcmp(bound.start, name.start, name.length) == 0) {
+            return fail(ev, EVAL_ERROR, "[line %d] Cannot redeclare %s '%.*s'",
+                        name.line,
+                        ev->scope == 0 ? "global variable" : "variable",
+                        name.length, name.start);
+        }
+    }
+    if (!evalNode(ev, &node->items[2], out)) return false;
+    // The value stays where evalNode left it, in the slot of the name.
+    int slot = (int)(ev->vm->stack_top - ev->vm->stack) - 1;
+    ev->bindings[ev->binding_cnt++] = (Binding){name, slot, ev->scope};
+    return keep(ev, *out);
+}
+
+// (and) is true and (or) is false. Otherwise the first operand that is falsy
+// for and, truthy for or, is the value, or the last operand.
+static bool evalLogic(Evaluator* ev, Node* node, bool is_and, Value* out) {
+    Value* mark = ev->vm->stack_top;
+    int bindings = ev->binding_cnt;
+    *out = BOOL_VAL(is_and);
+    for (int i = 1; i < node->count; i++) {
+        if (!evalNode(ev, &node->items[i], out)) return false;
+        if (isFalsey(*out) == is_and) break;
+    }
+    return settle(ev, mark, bindings, *out);
+}
+
+static bool evalCond(Evaluator* ev, Node* node, Value* out) {
+    if (node->count < 3 || node->count > 4) {
+        return fail(ev, EVAL_ERROR,
+                    "[line %d] cond takes a condition, a branch and an "
+                    "optional else branch",
+                    node->token.line);
+    }
+    Value* mark = ev->vm->stack_top;
+    int bindings = ev->binding_cnt;
+    Value cond;
+    if (!evalNode(ev, &node->items[1], &cond)) return false;
+    if (!isFalsey(cond)) {
+        if (!evalNode(ev, &node->items[2], out)) return false;
+    } else if (node->count == 4) {
+        if (!evalNode(ev, &node->items[3], out)) return false;
+    } else {
+        *out = NIL_VAL;
+    }
+    return settle(ev, mark, bindings, *out);
+}
+
+static bool evalApprox(Evaluator* ev, Node* node, Value* out) {
+    if (node->count < 3 || node->count > 4) {
+        return fail(ev, EVAL_ERROR, "[line %d] ~= takes 2 or 3 operands",
+                    node->token.line);
+    }
+    Value* mark = ev->vm->stack_top;
+    int bindings = ev->binding_cnt;
+    Value operands[3] = {NIL_VAL, NIL_VAL, REAL_VAL(APPROX_EQUAL_EPSILON)};
+    for (int i = 1; i < node->count; i++) {
+        if (!evalNode(ev, &node->items[i], &operands[i - 1])) return false;
+    }
+    double nums[3];
+    for (int i = 0; i < 3; i++) {
+        if (!IS_NUMERIC(operands[i])) {
+            return fail(ev, EVAL_ERROR, "[line %d] ~= operands must be numbers",
+                        node->token.line);
+        }
+        nums[i] = IS_INT(operands[i]) ? (double)AS_INT(operands[i])
+                                      : AS_REAL(operands[i]);
+    }
+    // The tolerance is absolute for small magnitudes and relative otherwise.
+    double scale = fmax(1.0, fmax(fabs(nums[0]), fabs(nums[1])));
+    *out = BOOL_VAL(fabs(nums[0] - nums[1]) <= nums[2] * scale);
+    return settle(ev, mark, bindings, *out);
+}
+
+// Applies the unary operator of node to its single operand.
+static bool evalPrefix(Evaluator* ev, Node* node, OpCode op, Value* out) {
+    if (node->count != 2) {
+        return fail(ev, EVAL_ERROR, "[line %d] `%.*s` takes 1 operand",
+                    node->token.line, node->items[0].token.length,
+                    node->items[0].token.start);
+    }
+    Value* mark = ev->vm->stack_top;
+    int bindings = ev->binding_cnt;
+    Value operand;
+    if (!evalNode(ev, &node->items[1], &operand)) return false;
+    const char* reason = evalUnary(op, operand, out);
+    if (reason != NULL) {
+        return fail(ev, EVAL_ERROR, "[line %d] %s", node->token.line, reason);
+    }
+    return settle(ev, mark, bindings, *out);
+}
+
+// The opcode of a binary operator, and whether the VM negates its result.
+static OpCode binaryOp(TokenType type, bool* negate) {
+    *negate = false;
+    switch (type) {
+        case TOKEN_PLUS_OP:
+        case TOKEN_PLUS_KW:
+            return OP_ADD;
+        case TOKEN_MINUS_OP:
+        case TOKEN_MINUS_KW:
+            return OP_SUBTRACT;
+        case TOKEN_STAR_OP:
+        case TOKEN_STAR_KW:
+            return OP_MULTIPLY;
+        case TOKEN_SLASH_OP:
+        case TOKEN_SLASH_KW:
+            return OP_DIVIDE;
+        case TOKEN_MODULO_OP:
+        case TOKEN_MODULO_KW:
+            return OP_MODULO;
+        case TOKEN_NOT_EQUAL_OP:
+        case TOKEN_NOT_EQUAL_KW:
+            *negate = true;
+            return OP_EQUAL;
+        case TOKEN_GREATER_EQUAL_OP:
+        case TOKEN_GREATER_EQUAL_KW:
+            *negate = true;
+            return OP_LESS;
+        case TOKEN_LESS_OP:
+        case TOKEN_LESS_KW:
+            return OP_LESS;
+        case TOKEN_LESS_EQUAL_OP:
+        case TOKEN_LESS_EQUAL_KW:
+            *negate = true;
+            return OP_GREATER;
+        case TOKEN_GREATER_OP:
+        case TOKEN_GREATER_KW:
+            return OP_GREATER;
+        case TOKEN_BAND_OP:
+        case TOKEN_BAND_KW:
+            return OP_BAND;
+        case TOKEN_BOR_OP:
+        case TOKEN_BOR_KW:
+            return OP_BOR;
+        case TOKEN_BXOR_OP:
+        case TOKEN_BXOR_KW:
+            return OP_BXOR;
+        case TOKEN_LSHIFT_OP:
+        case TOKEN_LSHIFT_KW:
+            return OP_LSHIFT;
+        case TOKEN_RSHIFT_OP:
+        case TOKEN_RSHIFT_KW:
+            return OP_RSHIFT;
+        case TOKEN_EQUAL_OP:
+        case TOKEN_EQUAL_KW:
+            return OP_EQUAL;
+        default:
+            return OP_RETURN;
+    }
+}
+
+// Applies a binary operator with the arities the compiler allows: (+) is 0
+// and (*) is 1, (+ x) and (* x) are x and (- x) negates x, + and * fold over
+// any number of operands, and every other operator takes exactly 2.
+static bool evalOperator(Evaluator* ev, Node* node, OpCode op, bool negate,
+                         Value* out) {
+    Token name = node->items[0].token;
+    int operands = node->count - 1;
+    bool variadic = op == OP_ADD || op == OP_MULTIPLY;
+    if (operands == 0 && variadic) {
+        *out = INT_VAL(op == OP_ADD ? 0 : 1);
+        return keep(ev, *out);
+    }
+    if (operands > 2 && !variadic) {
+        return fail(ev, EVAL_ERROR, "[line %d] expect ')' after expression",
+                    name.line);
+    }
+    if (operands == 0 || (operands == 1 && !variadic && op != OP_SUBTRACT)) {
+        return fail(ev, EVAL_ERROR, "[line %d] `%.*s` expects %s operands, "
+                    "got %d", name.line, name.length, name.start,
+                    op == OP_SUBTRACT ? "1 or 2" : "2", operands);
+    }
+    Value* mark = ev->vm->stack_top;
+    int bindings = ev->binding_cnt;
+    if (!evalNode(ev, &node->items[1], out)) return false;
+    if (operands == 1 && op == OP_SUBTRACT) {
+        const char* reason = evalUnary(OP_NEGATE, *out, out);
+        if (reason != NULL) {
+            return fail(ev, EVAL_ERROR, "[line %d] %s", name.line, reason);
+        }
+    }
+    if (!settle(ev, mark, bindings, *out)) return false;
+    for (int i = 2; i < node->count; i++) {
+        Value left = *out;
+        Value right;
+        if (!evalNode(ev, &node->items[i], &right)) return false;
+        const char* reason = evalBinary(ev->vm, op, left, right, out);
+        if (reason == NULL && negate) reason = evalUnary(OP_NOT, *out, out);
+        if (reason != NULL) {
+            return fail(ev, EVAL_ERROR, "[line %d] %s", name.line, reason);
+        }
+        if (!settle(ev, mark, bindings, *out)) return false;
+    }
+    return true;
+}
+
+// Evaluates a parenthesized form, deciding what it is the way the compiler
+// does: an operator or keyword first names the form, a name or a call first
+// makes a call, and anything else makes a block.
+static bool evalList(Evaluator* ev, Node* node, Value* out) {
+    if (node->count == 0) {
+        return fail(ev, EVAL_UNSUPPORTED, "[line %d] () is left to the VM",
+                    node->token.line);
+    }
+    Token head = node->items[0].token;
+    bool negate;
+    OpCode op = binaryOp(head.type, &negate);
+    if (op != OP_RETURN) return evalOperator(ev, node, op, negate, out);
+    switch (head.type) {
+        case TOKEN_AND_KW:
+            return evalLogic(ev, node, true, out);
+        case TOKEN_OR_KW:
+            return evalLogic(ev, node, false, out);
+        case TOKEN_NOT_OP:
+        case TOKEN_NOT_KW:
+            return evalPrefix(ev, node, OP_NOT, out);
+        case TOKEN_BNOT_OP:
+        case TOKEN_BNOT_KW:
+            return evalPrefix(ev, node, OP_BNOT, out);
+        case TOKEN_APPROX_EQUAL_OP:
+        case TOKEN_APPROX_EQUAL_KW:
+            return evalApprox(ev, node, out);
+        case TOKEN_COND_KW:
+            return evalCond(ev, node, out);
+        case TOKEN_LET_KW:
+            return evalLet(ev, node, out);
+        case TOKEN_INT:
+        case TOKEN_REAL:
+        case TOKEN_STRING:
+        case TOKEN_TRUE_KW:
+        case TOKEN_FALSE_KW:
+        case TOKEN_NULL_KW:
+            return evalBlock(ev, node, out);
+        case TOKEN_LPAREN: {
+            // ((let x 1) x) is a block, ((f) x) calls what (f) returns.
+            Node* first = &node->items[0];
+            if (first->count > 0 &&
+                first->items[0].token.type == TOKEN_LET_KW) {
+                return evalBlock(ev, node, out);
+            }
+            break;
+        }
+        default:
+            break;
+    }
+    return fail(ev, EVAL_UNSUPPORTED, "[line %d] '%.*s' is left to the VM",
+                head.line, head.length, head.start);
+}
+
+static bool evalNode(Evaluator* ev, Node* node, Value* out) {
+    switch (node->token.type) {
+        case TOKEN_LPAREN:
+            return evalList(ev, node, out);
+        case TOKEN_LBRAKET:
+        case TOKEN_HASH_LBRAKET:
+            return fail(ev, EVAL_UNSUPPORTED,
+                        "[line %d] collections are left to the VM",
+                        node->token.line);
+        default:
+            return evalAtom(ev, node, out);
+    }
+}
+
+EvalResult evalSource(VM* vm, const char* source, Value* out, char* error,
+                      size_t error_size) {
+    Evaluator ev = {
+        .vm = vm,
+        .bindings = malloc(sizeof(Binding) * vm->options.stack_capacity),
+        .result = EVAL_OK,
+        .error = error,
+        .error_size = error_size,
+    };
+    if (error_size > 0) error[0] = '\0';
+    initScanner(&ev.scanner, source);
+    ev.current = scanToken(&ev.scanner);
+    // Every form is read before any runs, so that a syntax error anywhere
+    // fails the source as a compile error does.
+    Node root = {.token = {.type = TOKEN_LPAREN, .line = 1}};
+    int capacity = 0;
+    bool ok = true;
+    while (ok && ev.current.type != TOKEN_EOF) {
+        if (root.count == capacity) {
+            capacity = capacity < 8 ? 8 : capacity * 2;
+            root.items = realloc(root.items, sizeof(Node) * capacity);
+        }
+        ok = readNode(&ev, &root.items[root.count++]);
+    }
+    if (ok && root.count == 0) {
+        ok = fail(&ev, EVAL_ERROR, "[line 1] Expected expression");
+    }
+    // The top level is no block: its lets stay bound to the end, and its
+    // forms leave nothing on the stack but the values of the names.
+    Value* base = vm->stack_top;
+    for (int i = 0; ok && i < root.count; i++) {
+        Value* mark = vm->stack_top;
+        int bindings = ev.binding_cnt;
+        ok = evalNode(&ev, &root.items[i], out) &&
+             settle(&ev, mark, bindings, *out);
+        if (ok) vm->stack_top--;
+    }
+    // A definition is not the value of the script.
+    if (ok && !vm->options.defs_yield_value) {
+        Node* last = &root.items[root.count - 1];
+        if (last->count > 0 && last->items[0].token.type == TOKEN_LET_KW) {
+            *out = NIL_VAL;
+        }
+    }
+    vm->stack_top = base;
+    // A failed read leaves the token after the form unread.
+    if (ev.current.type == TOKEN_STRING) free((char*)ev.current.start);
+    freeNode(&root);
+    free(ev.bindings);
+    return ok ? EVAL_OK : ev.result;
+}
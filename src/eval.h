@@ -0,0 +1,42 @@
+#ifndef liss_eval_h
+#define liss_eval_h
+
+#include <stddef.h>
+
+#include "opcode.h"
+#include "value.h"
+
+typedef struct VM VM;
+
+typedef enum {
+    EVAL_OK,
+    EVAL_ERROR,        // The source does not compile, or it would raise
+    EVAL_UNSUPPORTED,  // The source is more than a pure expression
+} EvalResult;
+
+// Applies a binary operator to two values the way the VM does: the
+// arithmetic operators to numbers, OP_ADD to two strings too and OP_MULTIPLY
+// to a string and a count, the bitwise operators to ints, and OP_EQUAL,
+// OP_LESS and OP_GREATER. Returns the reason it fails, or NULL with the
+// result in *out. A string result is allocated in vm.
+const char* evalBinary(VM* vm, OpCode op, Value a, Value b, Value* out);
+
+// Applies OP_NOT, OP_NEGATE or OP_BNOT to a value the way the VM does.
+// Returns the reason it fails, or NULL with the result in *out.
+const char* evalUnary(OpCode op, Value value, Value* out);
+
+// Evaluates source by walking its forms instead of compiling it, and stores
+// the value of the last one in *out. It is a reference for what the compiler
+// and the VM make of pure expressions: literals, the operators, and, or, not,
+// cond, blocks, and lets, whose names stay bound to the end of the source as
+// globals of the main module do. Anything else, like a call, a collection or
+// a name the source does not bind, gives EVAL_UNSUPPORTED. A form that does
+// not compile, or would raise, gives EVAL_ERROR. Either way error, of
+// error_size bytes, says why.
+//
+// vm only allocates the strings and keeps the values on its stack while they
+// are used; nothing is defined in it, so its globals are left alone.
+EvalResult evalSource(VM* vm, const char* source, Value* out, char* error,
+                      size_t error_size);
+
+#endif
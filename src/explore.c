@@ -0,0 +1,343 @@
+#include "explore.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/ioctl.h>
+#include <termios.h>
+#include <unistd.h>
+
+#include "chunk.h"
+#include "common.h"
+#include "memory.h"
+#include "object.h"
+#include "value.h"
+#include "vm.h"
+
+#define EXPLORE_HELP " j/k instruction  n/p source line  g/G first/last  q quit"
+
+static void addRow(ExploreListing* listing, const ObjFunction* function,
+                   int line, const char* text, int len) {
+    if (listing->count == listing->capacity) {
+        listing->capacity = GROW_CAPACITY(listing->capacity);
+        listing->rows = realloc(listing->rows,
+                                sizeof(ExploreRow) * listing->capacity);
+    }
+    listing->rows[listing->count++] = (ExploreRow){
+        .function = function,
+        .line = line,
+        .text = strndup(text, len),
+    };
+}
+
+void listFunction(const ObjFunction* function, ExploreListing* listing) {
+    const Chunk* chunk = &function->chunk;
+    char header[256];
+    int len = snprintf(header, sizeof(header), "== %s ==",
+                       function->usage ? function->usage->chars : "<script>");
+    if (len >= (int)sizeof(header)) len = sizeof(header) - 1;
+    addRow(listing, function, 0, header, len);
+
+    // Every line of the listing starts with the offset of its instruction.
+    char* code = sprintChunk(chunk);
+    for (char* row = code; row != NULL && *row != '\0';) {
+        char* end = strchr(row, '\n');
+        int row_len = end ? (int)(end - row) : (int)strlen(row);
+        int offset = atoi(row);
+        int line = offset < chunk->count ? chunk->lines[offset] : 0;
+        addRow(listing, function, line, row, row_len);
+        row = end ? end + 1 : NULL;
+    }
+    free(code);
+
+    for (int i = 0; i < chunk->constants.count; i++) {
+        Value value = chunk->constants.values[i];
+        if (IS_FUNCTION(value)) listFunction(AS_FUNCTION(value), listing);
+    }
+}
+
+void freeListing(ExploreListing* listing) {
+    for (int i = 0; i < listing->count; i++) {
+        free(listing->rows[i].text);
+    }
+    free(listing->rows);
+    *listing = (ExploreListing){0};
+}
+
+typedef struct {
+    const char* path;
+    const char** lines;  // Start of every source line
+    int line_cnt;
+    ExploreListing listing;
+    int cursor;  // Selected instruction row
+    int width;
+    int height;
+    char* frame;  // The screen being drawn
+    size_t frame_len;
+    size_t frame_cap;
+} Explorer;
+
+static struct termios orig_termios;
+
+static void leaveScreen(void) {
+    // Back to the main screen with the cursor shown.
+    write(STDOUT_FILENO, "\x1b[?1049l\x1b[?25h", 14);
+    tcsetattr(STDIN_FILENO, TCSAFLUSH, &orig_termios);
+}
+
+static void enterScreen(void) {
+    tcgetattr(STDIN_FILENO, &orig_termios);
+    struct termios raw = orig_termios;
+    raw.c_iflag &= ~(ICRNL | IXON);
+    raw.c_lflag &= ~(ECHO | ICANON | IEXTEN | ISIG);
+    raw.c_cc[VMIN] = 1;
+    raw.c_cc[VTIME] = 0;
+    tcsetattr(STDIN_FILENO, TCSAFLUSH, &raw);
+    // The alternate screen keeps the shell's scrollback untouched.
+    write(STDOUT_FILENO, "\x1b[?1049h\x1b[?25l", 14);
+}
+
+static void measureScreen(Explorer* ex) {
+    struct winsize ws;
+    if (ioctl(STDOUT_FILENO, TIOCGWINSZ, &ws) == 0 && ws.ws_col > 0) {
+        ex->width = ws.ws_col;
+        ex->height = ws.ws_row;
+    } else {
+        ex->width = 80;
+        ex->height = 24;
+    }
+}
+
+static void put(Explorer* ex, const char* str, size_t len) {
+    if (ex->frame_len + len > ex->frame_cap) {
+        while (ex->frame_len + len > ex->frame_cap) {
+            ex->frame_cap = ex->frame_cap == 0 ? 4096 : ex->frame_cap * 2;
+        }
+        ex->frame = realloc(ex->frame, ex->frame_cap);
+    }
+    memcpy(ex->frame + ex->frame_len, str, len);
+    ex->frame_len += len;
+}
+
+// Puts text up to the end of its line into a cell of *width columns and
+// takes the columns used from *width. Tabs and other control characters
+// show as spaces, and UTF-8 sequences count as one column.
+static void putText(Explorer* ex, const char* text, int* width) {
+    for (const char* c = text; *c != '\0' && *c != '\n'; c++) {
+        bool continues = ((unsigned char)*c & 0xC0) == 0x80;
+        if (!continues && *width == 0) break;
+        if ((unsigned char)*c < ' ') {
+            put(ex, " ", 1);
+        } else {
+            put(ex, c, 1);
+        }
+        if (!continues) (*width)--;
+    }
+}
+
+// Pads the rest of a cell with spaces and ends any highlight.
+static void endCell(Explorer* ex, int width, bool highlight) {
+    for (; width > 0; width--) put(ex, " ", 1);
+    if (highlight) put(ex, "\x1b[0m", 4);
+}
+
+// Returns the first of height rows to show so that selected sits mid-pane.
+static int scrollTop(int selected, int count, int height) {
+    int top = selected - height / 2;
+    if (top > count - height) top = count - height;
+    return top < 0 ? 0 : top;
+}
+
+static void drawSourceCell(Explorer* ex, int line, int width, int anchor) {
+    bool highlight = line == anchor;
+    if (highlight) put(ex, "\x1b[7m", 4);
+    if (line <= ex->line_cnt) {
+        char number[16];
+        snprintf(number, sizeof(number), "%4d ", line);
+        putText(ex, number, &width);
+        putText(ex, ex->lines[line - 1], &width);
+    }
+    endCell(ex, width, highlight);
+}
+
+static void drawConstCell(Explorer* ex, const Chunk* chunk, int index,
+                          int width) {
+    if (index < chunk->constants.count) {
+        char number[16];
+        snprintf(number, sizeof(number), "%4d ", index);
+        putText(ex, number, &width);
+        char* value = sprintValue(chunk->constants.values[index]);
+        putText(ex, value, &width);
+        free(value);
+    }
+    endCell(ex, width, false);
+}
+
+static void drawBar(Explorer* ex, const char* text) {
+    int width = ex->width;
+    put(ex, "\x1b[7m", 4);
+    putText(ex, text, &width);
+    endCell(ex, width, true);
+}
+
+static void draw(Explorer* ex) {
+    measureScreen(ex);
+    ex->frame_len = 0;
+    put(ex, "\x1b[H", 3);
+
+    ExploreRow* row = &ex->listing.rows[ex->cursor];
+    const Chunk* chunk = &row->function->chunk;
+    char title[512];
+    snprintf(title, sizeof(title), " %s  line %d  %s", ex->path, row->line,
+             row->text);
+    drawBar(ex, title);
+    put(ex, "\r\n", 2);
+
+    int body = ex->height > 2 ? ex->height - 2 : 1;
+    int src_w = ex->width * 2 / 5;
+    int dis_w = ex->width * 7 / 20;
+    int const_w = ex->width - src_w - dis_w - 2;
+    if (const_w < 0) const_w = 0;
+    int src_top = scrollTop(row->line - 1, ex->line_cnt, body);
+    int dis_top = scrollTop(ex->cursor, ex->listing.count, body);
+    for (int y = 0; y < body; y++) {
+        drawSourceCell(ex, src_top + y + 1, src_w, row->line);
+        put(ex, "|", 1);
+        int ix = dis_top + y;
+        bool selected = ix == ex->cursor;
+        int width = dis_w;
+        if (selected) put(ex, "\x1b[7m", 4);
+        if (ix < ex->listing.count) {
+            putText(ex, ex->listing.rows[ix].text, &width);
+        }
+        endCell(ex, width, selected);
+        put(ex, "|", 1);
+        drawConstCell(ex, chunk, y, const_w);
+        put(ex, "\r\n", 2);
+    }
+    drawBar(ex, EXPLORE_HELP);
+    write(STDOUT_FILENO, ex->frame, ex->frame_len);
+}
+
+// Moves the cursor by step rows, passing over function headers.
+static void moveCursor(Explorer* ex, int step) {
+    for (int ix = ex->cursor + step; ix >= 0 && ix < ex->listing.count;
+         ix += step) {
+        if (ex->listing.rows[ix].line != 0) {
+            ex->cursor = ix;
+            return;
+        }
+    }
+}
+
+// Selects the first instruction of the nearest source line after (step 1)
+// or before (step -1) the current one that has code, in whichever function.
+static void moveLine(Explorer* ex, int step) {
+    int current = ex->listing.rows[ex->cursor].line;
+    int target = 0;
+    for (int i = 0; i < ex->listing.count; i++) {
+        int line = ex->listing.rows[i].line;
+        if (line == 0 || (line - current) * step <= 0) continue;
+        if (target == 0 || (target - line) * step > 0) target = line;
+    }
+    if (target == 0) return;
+    for (int i = 0; i < ex->listing.count; i++) {
+        if (ex->listing.rows[i].line == target) {
+            ex->cursor = i;
+            return;
+        }
+    }
+}
+
+static void browse(Explorer* ex) {
+    ex->cursor = 0;
+    moveCursor(ex, 1);
+    for (;;) {
+        draw(ex);
+        char c;
+        if (read(STDIN_FILENO, &c, 1) <= 0) return;
+        if (c == '\x1b') {
+            char seq[2];
+            if (read(STDIN_FILENO, &seq[0], 1) <= 0) return;
+            if (read(STDIN_FILENO, &seq[1], 1) <= 0) return;
+            if (seq[0] != '[') continue;
+            c = seq[1] == 'A'   ? 'k'
+                : seq[1] == 'B' ? 'j'
+                : seq[1] == 'C' ? 'n'
+                : seq[1] == 'D' ? 'p'
+                                : 0;
+        }
+        switch (c) {
+            case 'j':
+                moveCursor(ex, 1);
+                break;
+            case 'k':
+                moveCursor(ex, -1);
+                break;
+            case 'n':
+                moveLine(ex, 1);
+                break;
+            case 'p':
+                moveLine(ex, -1);
+                break;
+            case 'g':
+                ex->cursor = 0;
+                moveCursor(ex, 1);
+                break;
+            case 'G':
+                ex->cursor = ex->listing.count;
+                moveCursor(ex, -1);
+                break;
+            case 'q':
+            case '\x03':  // Ctrl+C
+                return;
+        }
+    }
+}
+
+// Points ex->lines at the start of every line of source.
+static void splitLines(Explorer* ex, const char* source) {
+    int capacity = 64;
+    ex->lines = malloc(sizeof(const char*) * capacity);
+    ex->line_cnt = 0;
+    for (const char* line = source; line != NULL;) {
+        if (ex->line_cnt == capacity) {
+            capacity *= 2;
+            ex->lines = realloc(ex->lines, sizeof(const char*) * capacity);
+        }
+        ex->lines[ex->line_cnt++] = line;
+        line = strchr(line, '\n');
+        if (line != NULL) line++;
+    }
+}
+
+int exploreFile(const char* path, const char* source, VMOptions options) {
+    if (!isatty(STDIN_FILENO) || !isatty(STDOUT_FILENO)) {
+        fprintf(stderr, "-explore needs a terminal.\n");
+        return 64;
+    }
+    VM* vm = newVM(options);
+    if (vm == NULL) {
+        fprintf(stderr, "Could not create VM.\n");
+        return 74;
+    }
+    ObjFunction* function = compileMain(vm, source);
+    if (function == NULL) {
+        fprintf(stderr, "%s\n", vm->error_msg);
+        destroyVM(vm);
+        return 65;
+    }
+
+    Explorer ex = {.path = path};
+    splitLines(&ex, source);
+    listFunction(function, &ex.listing);
+    enterScreen();
+    browse(&ex);
+    leaveScreen();
+
+    free(ex.frame);
+    free(ex.lines);
+    freeListing(&ex.listing);
+    destroyVM(vm);
+    return 0;
+}
@@ -0,0 +1,34 @@
+#ifndef liss_explore_h
+#define liss_explore_h
+
+#include "object.h"
+#include "vm.h"
+
+// A row of the disassembly pane: a function header or one instruction.
+typedef struct {
+    const ObjFunction* function;  // Whose constants the constants pane shows
+    int line;    // Source line the instruction was emitted for, 0 on headers
+    char* text;  // As -disasm prints it
+} ExploreRow;
+
+typedef struct {
+    int count;
+    int capacity;
+    ExploreRow* rows;
+} ExploreListing;
+
+// Appends the instructions of function and then those of every function
+// nested in it, depth first, each function led by a "== name ==" header row.
+// The instruction rows carry the anchors of the chunk's source line table.
+void listFunction(const ObjFunction* function, ExploreListing* listing);
+
+void freeListing(ExploreListing* listing);
+
+// Compiles the script read from path and browses it in the terminal: source,
+// disassembly and constants side by side, with the cursor on an instruction
+// and the source line it came from highlighted. The script is not run, though
+// the modules it imports are loaded as for -disasm. Returns the exit status
+// for the process.
+int exploreFile(const char* path, const char* source, VMOptions options);
+
+#endif
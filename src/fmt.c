@@ -0,0 +1,193 @@
+#include "fmt.h"
+
+#include <stdbool.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "value.h"
+
+typedef enum {
+    FMT_OPEN,
+    FMT_CLOSE,
+    FMT_ATOM,
+    FMT_STRING,
+    FMT_LINE_COMMENT,
+    FMT_BLOCK_COMMENT,
+} FmtKind;
+
+// The formatter reads raw text rather than scanner tokens: the scanner drops
+// comments and unescapes strings, and both must come out unchanged.
+typedef struct {
+    FmtKind kind;
+    const char* start;
+    size_t length;
+} FmtToken;
+
+static bool isDelimiter(char c) {
+    return c == '\0' || c == ' ' || c == '\t' || c == '\r' || c == '\n' ||
+           c == '(' || c == ')' || c == '[' || c == ']' || c == '"' ||
+           c == ';';
+}
+
+static bool isLetter(char c) {
+    return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_';
+}
+
+// Reads the token at *p and advances past it. Returns false with *err_msg set
+// on an unterminated string or block comment.
+static bool nextToken(const char** p, FmtToken* token, const char** err_msg) {
+    const char* s = *p;
+    token->start = s;
+    switch (*s) {
+        case '(':
+        case '[':
+            token->kind = FMT_OPEN;
+            s++;
+            break;
+        case ')':
+        case ']':
+            token->kind = FMT_CLOSE;
+            s++;
+            break;
+        case '"':
+            token->kind = FMT_STRING;
+            for (s++; *s != '"'; s++) {
+                if (*s == '\0') {
+                    *err_msg = "Unterminated string.";
+                    return false;
+                }
+                if (*s == '\\' && s[1] != '\0') s++;
+            }
+            s++;
+            break;
+        case ';':
+            token->kind = FMT_LINE_COMMENT;
+            while (*s != '\n' && *s != '\0') s++;
+            while (s > token->start && (s[-1] == ' ' || s[-1] == '\t' ||
+                                        s[-1] == '\r')) {
+                s--;
+            }
+            break;
+        default:
+            if (s[0] == '#' && s[1] == '|') {
+                token->kind = FMT_BLOCK_COMMENT;
+                int depth = 1;
+                for (s += 2; depth > 0; s++) {
+                    if (*s == '\0') {
+                        *err_msg = "Unterminated block comment.";
+                        return false;
+                    }
+                    if (s[0] == '#' && s[1] == '|') {
+                        depth++;
+                        s++;
+                    } else if (s[0] == '|' && s[1] == '#') {
+                        depth--;
+                        s++;
+                    }
+                }
+                break;
+            }
+            if (s[0] == '#' && s[1] == '[') {
+                // A tuple literal opens like a list.
+                token->kind = FMT_OPEN;
+                s += 2;
+                break;
+            }
+            token->kind = FMT_ATOM;
+            // An accessor like .users[0].name keeps its index brackets.
+            bool accessor = s[0] == '.' && isLetter(s[1]);
+            while (!isDelimiter(*s) || (accessor && (*s == '[' || *s == ']'))) {
+                s++;
+            }
+            break;
+    }
+    token->length = (size_t)(s - token->start);
+    *p = s;
+    return true;
+}
+
+// Skips whitespace before the next token and returns how many line breaks it
+// contained.
+static int skipSpace(const char** p) {
+    int newlines = 0;
+    for (;; (*p)++) {
+        char c = **p;
+        if (c == '\n') {
+            newlines++;
+        } else if (c != ' ' && c != '\t' && c != '\r') {
+            return newlines;
+        }
+    }
+}
+
+char* formatSource(const char* source, const char** err_msg) {
+    CharBuf out = {0};
+    // The opening bracket of every enclosing form, to match closers against.
+    CharBuf open = {0};
+    const char* p = source;
+    bool first = true;
+    FmtKind prev = FMT_ATOM;
+
+    // A "#!" first line is kept as it is, like a comment.
+    if (p[0] == '#' && p[1] == '!') {
+        size_t len = strcspn(p, "\r\n");
+        while (p[len - 1] == ' ' || p[len - 1] == '\t') len--;
+        charBufAppend(&out, p, len);
+        p += strcspn(p, "\n");
+        prev = FMT_LINE_COMMENT;
+        first = false;
+    }
+
+    for (;;) {
+        int newlines = skipSpace(&p);
+        if (*p == '\0') break;
+        FmtToken token;
+        if (!nextToken(&p, &token, err_msg)) goto FAIL;
+
+        if (token.kind == FMT_CLOSE) {
+            char expected = token.start[0] == ')' ? '(' : '[';
+            if (open.len == 0 || open.chars[open.len - 1] != expected) {
+                *err_msg = "Unbalanced closing bracket.";
+                goto FAIL;
+            }
+            open.chars[--open.len] = '\0';
+            // Closers hug the previous token unless a comment ends its line.
+            newlines = prev == FMT_LINE_COMMENT ? 1 : 0;
+        } else if (prev == FMT_LINE_COMMENT && newlines == 0) {
+            newlines = 1;
+        }
+
+        if (first) {
+            // Leading blank lines are dropped.
+        } else if (newlines > 0) {
+            charBufAppend(&out, "\n", 1);
+            if (newlines > 1) charBufAppend(&out, "\n", 1);
+            for (size_t i = 0; i < open.len * FMT_INDENT; i++) {
+                charBufAppend(&out, " ", 1);
+            }
+        } else if (prev != FMT_OPEN && token.kind != FMT_CLOSE) {
+            charBufAppend(&out, " ", 1);
+        }
+        charBufAppend(&out, token.start, token.length);
+
+        if (token.kind == FMT_OPEN) {
+            charBufAppend(&open, token.start + token.length - 1, 1);
+        }
+        prev = token.kind;
+        first = false;
+    }
+
+    if (open.len > 0) {
+        *err_msg = "Unclosed bracket at the end of the source.";
+        goto FAIL;
+    }
+    free(open.chars);
+    if (!first) charBufAppend(&out, "\n", 1);
+    if (out.chars == NULL) return calloc(1, 1);
+    return out.chars;
+
+FAIL:
+    free(open.chars);
+    free(out.chars);
+    return NULL;
+}
@@ -0,0 +1,17 @@
+#ifndef liss_fmt_h
+#define liss_fmt_h
+
+// Indentation added for every bracket a line is nested in.
+#define FMT_INDENT 4
+
+// Re-emits Liss source in canonical layout and returns it, or NULL with
+// *err_msg pointing at a static description if the brackets don't balance or
+// a string or block comment is left open. The caller owns the result.
+//
+// Only whitespace changes, so the result compiles to the same program:
+// tokens and comments are kept verbatim, line breaks are kept but closing
+// brackets always hug the preceding token, runs of blank lines shrink to one,
+// and every line is indented FMT_INDENT spaces per enclosing bracket.
+char* formatSource(const char* source, const char** err_msg);
+
+#endif
@@ -1,6 +1,7 @@
 #include "gc.h"
 
 #include <stdio.h>
+#include <stdlib.h>
 #include <string.h>
 
 #include "compiler.h"
@@ -13,7 +14,11 @@
 
 void gc(VM* vm) {
     DEBUG_LOG("--- GC Begin ---");
+    vm->metrics.gc_cnt++;
     markRoots(vm);
+    traceReferences(vm);
+    // Interned strings nothing else refers to are dropped with the rest.
+    tableRemoveWhite(&vm->strings);
     sweep(vm);
     size_t new_threshold = vm->bytes_allocated * vm->options.heap_growth_factor;
     vm->next_gc = (new_threshold < vm->options.gc_threshold)
@@ -29,12 +34,19 @@ void markRoots(VM* vm) {
         markValue(vm, *value);
     }
     markValue(vm, vm->last_popped_value);
-    // TODO: Implement weak references for string interning
-    markTable(vm, &vm->strings);
     markValue(vm, vm->raise_value);
     markTable(vm, &vm->modules);
     markValue(vm, OBJ_VAL(vm->core_module));
     markValue(vm, OBJ_VAL(vm->main_module));
+    for (int i = 0; i < vm->pinned.count; i++) {
+        markValue(vm, vm->pinned.values[i]);
+    }
+    for (int i = 0; i < vm->re_cache.count; i++) {
+        markValue(vm, vm->re_cache.values[i]);
+    }
+    for (int i = 0; i < vm->tests.count; i++) {
+        markValue(vm, vm->tests.values[i]);
+    }
     //  mark upvalues
     for (ObjUpvalue* upvalue = vm->open_upvalues; upvalue != NULL;
          upvalue = upvalue->next) {
@@ -56,11 +68,26 @@ void markObject(VM* vm, Obj* object) {
     object->isMarked = true;
     // DEBUG_LOG("Marked object %p", (void*)object);
 
+    if (vm->gray_cnt == vm->gray_cap) {
+        vm->gray_cap = vm->gray_cap < 64 ? 64 : vm->gray_cap * 2;
+        // Plain realloc: growing through reallocate could start another GC.
+        vm->gray_stack =
+            realloc(vm->gray_stack, sizeof(Obj*) * (size_t)vm->gray_cap);
+        if (vm->gray_stack == NULL) exit(1);
+    }
+    vm->gray_stack[vm->gray_cnt++] = object;
+}
+
+// Marks everything object refers to.
+static void blackenObject(VM* vm, Obj* object) {
     switch (object->type) {
         case OBJ_FUNCTION: {
             ObjFunction* function = (ObjFunction*)object;
             markObject(vm, (Obj*)function->name);
             markObject(vm, (Obj*)function->module);
+            markObject(vm, (Obj*)function->usage);
+            markObject(vm, (Obj*)function->doc);
+            markObject(vm, (Obj*)function->params);
             for (int i = 0; i < function->chunk.constants.count; i++) {
                 markValue(vm, function->chunk.constants.values[i]);
             }
@@ -85,6 +112,7 @@ void markObject(VM* vm, Obj* object) {
         case OBJ_ERROR: {
             ObjError* error = (ObjError*)object;
             markObject(vm, (Obj*)error->message);
+            markObject(vm, (Obj*)error->trace);
             break;
         }
         case OBJ_NATIVE:
@@ -102,6 +130,13 @@ void markObject(VM* vm, Obj* object) {
             markValue(vm, list->head);
             break;
         }
+        case OBJ_TUPLE: {
+            ObjTuple* tuple = (ObjTuple*)object;
+            for (uint32_t i = 0; i < tuple->len; i++) {
+                markValue(vm, tuple->items[i]);
+            }
+            break;
+        }
         case OBJ_DICT: {
             ObjDict* dict = (ObjDict*)object;
             markObject(vm, (Obj*)dict->root);
@@ -112,9 +147,12 @@ void markObject(VM* vm, Obj* object) {
             markObject(vm, (Obj*)module->name);
             markTable(vm, &module->symbols);
             markTable(vm, &module->imports);
+            markTable(vm, &module->import_from);
+            markTable(vm, &module->consts);
             break;
         }
-        case OBJ_FILE: {
+        case OBJ_FILE:
+        case OBJ_BYTES: {
             break;
         }
         case OBJ_RE: {
@@ -122,6 +160,11 @@ void markObject(VM* vm, Obj* object) {
             markObject(vm, (Obj*)re->pattern);
             break;
         }
+        case OBJ_BUILDER: {
+            ObjBuilder* builder = (ObjBuilder*)object;
+            markObject(vm, (Obj*)builder->str);
+            break;
+        }
         case OBJ_HAMT_NODE: {
             HamtNode* node = (HamtNode*)object;
             hamtMark(vm, node);
@@ -130,6 +173,12 @@ void markObject(VM* vm, Obj* object) {
     }
 }
 
+void traceReferences(VM* vm) {
+    while (vm->gray_cnt > 0) {
+        blackenObject(vm, vm->gray_stack[--vm->gray_cnt]);
+    }
+}
+
 void markTable(VM* vm, Table* table) {
     for (size_t i = 0; i < table->bucket_count; i++) {
         TableEntry* entry = table->buckets[i];
@@ -182,6 +231,8 @@ void freeObject(VM* vm, Obj* object) {
             if (function->loaded_code != NULL) {
                 FREE_ARRAY(void*, vm, function->loaded_code,
                            function->loaded_code_size);
+                FREE_ARRAY(int, vm, function->code_offsets,
+                           function->loaded_code_size);
             }
             freeChunk(vm, &function->chunk);
             reallocate(vm, function, sizeof(ObjFunction), 0);
@@ -225,6 +276,17 @@ void freeObject(VM* vm, Obj* object) {
             reallocate(vm, list, sizeof(ObjList), 0);
             break;
         }
+        case OBJ_TUPLE: {
+            ObjTuple* tuple = (ObjTuple*)object;
+            reallocate(vm, tuple, sizeof(ObjTuple) + sizeof(Value) * tuple->len,
+                       0);
+            break;
+        }
+        case OBJ_BYTES: {
+            ObjBytes* bytes = (ObjBytes*)object;
+            reallocate(vm, bytes, sizeof(ObjBytes) + bytes->len, 0);
+            break;
+        }
         case OBJ_DICT: {
             ObjDict* dict = (ObjDict*)object;
             reallocate(vm, dict, sizeof(ObjDict), 0);
@@ -234,14 +296,18 @@ void freeObject(VM* vm, Obj* object) {
             ObjModule* module = (ObjModule*)object;
             freeTable(&module->symbols);
             freeTable(&module->imports);
+            freeTable(&module->import_from);
+            freeTable(&module->consts);
             reallocate(vm, module, sizeof(ObjModule), 0);
             break;
         }
         case OBJ_FILE: {
             ObjFile* file = (ObjFile*)object;
-            if (!file->is_closed && file->file != NULL) {
+            if (file->resource.owned && !file->is_closed &&
+                file->file != NULL) {
                 fclose(file->file);
             }
+            untrackResource(vm, &file->resource);
             reallocate(vm, file, sizeof(ObjFile), 0);
             break;
         }
@@ -254,6 +320,12 @@ void freeObject(VM* vm, Obj* object) {
             reallocate(vm, re, sizeof(ObjRe), 0);
             break;
         }
+        case OBJ_BUILDER: {
+            ObjBuilder* builder = (ObjBuilder*)object;
+            FREE_ARRAY(char, vm, builder->chars, builder->capacity);
+            reallocate(vm, builder, sizeof(ObjBuilder), 0);
+            break;
+        }
         case OBJ_HAMT_NODE: {
             HamtNode* node = (HamtNode*)object;
             hamtFree(vm, node);
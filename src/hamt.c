@@ -1,6 +1,7 @@
 #include "hamt.h"
 
 #include <stdint.h>
+#include <stdlib.h>
 
 #include "gc.h"
 #include "memory.h"
@@ -8,6 +9,20 @@
 #include "value.h"
 #include "vm.h"
 
+#define ENTRY_BYTES (HAMT_ENTRY * sizeof(Value))
+
+uint64_t hamtKeyHash(VM* vm, Value key) {
+    uint64_t hash = hamtHash(key);
+    uint64_t seed = vm->options.hash_seed;
+    if (seed == 0) return hash;
+    // The splitmix64 finalizer, so that every bit of the seed moves the
+    // chunks the trie branches on.
+    hash ^= seed;
+    hash = (hash ^ (hash >> 30)) * 0xbf58476d1ce4e5b9ull;
+    hash = (hash ^ (hash >> 27)) * 0x94d049bb133111ebull;
+    return hash ^ (hash >> 31);
+}
+
 static HamtNode* allocNode(VM* vm) {
     HamtNode* node = (HamtNode*)reallocate(vm, NULL, 0, sizeof(HamtNode));
     node->obj.type = OBJ_HAMT_NODE;
@@ -24,13 +39,170 @@ static HamtNode* allocNode(VM* vm) {
 
 HamtNode* hamtNew(VM* vm) { return allocNode(vm); }
 
+static bool isComposite(Value v) {
+    return IS_LIST(v) || IS_PAIR(v) || IS_TUPLE(v);
+}
+
+static uint64_t mixHash(uint64_t h, uint64_t x) {
+    return h ^ (x + 0x9e3779b97f4a7c15ull + (h << 6) + (h >> 2));
+}
+
+// Frozen keys are within the limits, so a key that runs out of budget here
+// cannot equal any of them and its hash does not matter past that point.
+static uint64_t hashComposite(Value v, int depth, int* budget) {
+    if (!isComposite(v)) return hamtHash(v);
+    if (depth > HAMT_KEY_DEPTH_MAX || --(*budget) < 0) return 0;
+    if (IS_PAIR(v)) {
+        uint64_t h = mixHash(0x9a1, hashComposite(AS_PAIR(v)->first,
+                                                  depth + 1, budget));
+        return mixHash(h, hashComposite(AS_PAIR(v)->second, depth + 1, budget));
+    }
+    if (IS_TUPLE(v)) {
+        ObjTuple* tuple = AS_TUPLE(v);
+        uint64_t h = mixHash(0x7e1, tuple->len);
+        for (uint32_t i = 0; i < tuple->len; i++) {
+            h = mixHash(h, hashComposite(tuple->items[i], depth + 1, budget));
+        }
+        return h;
+    }
+    ObjList* list = AS_LIST(v);
+    uint64_t h = mixHash(0x115, list->len);
+    Value cur = list->head;
+    for (uint32_t i = 0; i < list->len; i++) {
+        h = mixHash(h, hashComposite(AS_PAIR(cur)->first, depth + 1, budget));
+        cur = AS_PAIR(cur)->second;
+    }
+    return h;
+}
+
+uint64_t hamtHashComposite(Value v) {
+    int budget = HAMT_KEY_NODES_MAX;
+    return hashComposite(v, 0, &budget);
+}
+
+// Stored keys are frozen and so within the limits, which bounds the walk.
+static bool keysEqualAt(Value a, Value b, int depth) {
+    if (!isComposite(a) || !isComposite(b) || OBJ_TYPE(a) != OBJ_TYPE(b)) {
+        return valuesEqual(a, b);
+    }
+    if (AS_OBJ(a) == AS_OBJ(b)) return true;
+    if (depth > HAMT_KEY_DEPTH_MAX) return false;
+    if (IS_PAIR(a)) {
+        return keysEqualAt(AS_PAIR(a)->first, AS_PAIR(b)->first, depth + 1) &&
+               keysEqualAt(AS_PAIR(a)->second, AS_PAIR(b)->second, depth + 1);
+    }
+    if (IS_TUPLE(a)) {
+        ObjTuple* x = AS_TUPLE(a);
+        ObjTuple* y = AS_TUPLE(b);
+        if (x->len != y->len) return false;
+        for (uint32_t i = 0; i < x->len; i++) {
+            if (!keysEqualAt(x->items[i], y->items[i], depth + 1)) return false;
+        }
+        return true;
+    }
+    if (AS_LIST(a)->len != AS_LIST(b)->len) return false;
+    Value x = AS_LIST(a)->head;
+    Value y = AS_LIST(b)->head;
+    for (uint32_t i = 0; i < AS_LIST(a)->len; i++) {
+        if (!keysEqualAt(AS_PAIR(x)->first, AS_PAIR(y)->first, depth + 1)) {
+            return false;
+        }
+        x = AS_PAIR(x)->second;
+        y = AS_PAIR(y)->second;
+    }
+    return true;
+}
+
+bool hamtKeysEqual(Value a, Value b) { return keysEqualAt(a, b, 0); }
+
+static bool freezeKey(VM* vm, Value* key, int depth, int* budget) {
+    if (!isComposite(*key)) return true;
+    if (depth > HAMT_KEY_DEPTH_MAX || --(*budget) < 0) return false;
+    // Every level keeps at most four values on the VM stack, counting the
+    // two newPair pushes.
+    size_t used = (size_t)(vm->stack_top - vm->stack);
+    if (used + 4 > vm->options.stack_capacity) return false;
+
+    if (IS_PAIR(*key)) {
+        Value first = AS_PAIR(*key)->first;
+        Value second = AS_PAIR(*key)->second;
+        if (!isComposite(first) && !isComposite(second)) return true;
+        if (!freezeKey(vm, &first, depth + 1, budget)) return false;
+        push(vm, first);
+        bool ok = freezeKey(vm, &second, depth + 1, budget);
+        if (ok) {
+            push(vm, second);
+            *key = OBJ_VAL(newPair(vm, first, second));
+            pop(vm);
+        }
+        pop(vm);
+        return ok;
+    }
+
+    if (IS_TUPLE(*key)) {
+        // A tuple of plain values is already immutable and is kept as it is.
+        ObjTuple* tuple = AS_TUPLE(*key);
+        bool plain = true;
+        for (uint32_t i = 0; i < tuple->len && plain; i++) {
+            plain = !isComposite(tuple->items[i]);
+        }
+        if (plain) return true;
+        ObjTuple* copy = newTuple(vm, tuple->len);
+        push(vm, OBJ_VAL(copy));
+        for (uint32_t i = 0; i < tuple->len; i++) {
+            Value item = tuple->items[i];
+            if (!freezeKey(vm, &item, depth + 1, budget)) {
+                pop(vm);
+                return false;
+            }
+            copy->items[i] = item;
+        }
+        pop(vm);
+        *key = OBJ_VAL(copy);
+        return true;
+    }
+
+    ObjList* list = AS_LIST(*key);
+    push(vm, NIL_VAL);  // head of the copy
+    ObjPair* tail = NULL;
+    Value cur = list->head;
+    for (uint32_t i = 0; i < list->len; i++) {
+        Value item = AS_PAIR(cur)->first;
+        if (!freezeKey(vm, &item, depth + 1, budget)) {
+            pop(vm);
+            return false;
+        }
+        push(vm, item);
+        ObjPair* pair = newPair(vm, item, NIL_VAL);
+        pop(vm);
+        if (tail == NULL) {
+            vm->stack_top[-1] = OBJ_VAL(pair);
+        } else {
+            tail->second = OBJ_VAL(pair);
+        }
+        tail = pair;
+        cur = AS_PAIR(cur)->second;
+    }
+    ObjList* copy = newList(vm, list->len, vm->stack_top[-1]);
+    copy->last = tail;
+    copy->frozen = true;
+    pop(vm);
+    *key = OBJ_VAL(copy);
+    return true;
+}
+
+bool hamtFreezeKey(VM* vm, Value* key) {
+    int budget = HAMT_KEY_NODES_MAX;
+    return freezeKey(vm, key, 0, &budget);
+}
+
 Value* hamtGet(HamtNode* node, Value key, uint64_t hash, int depth) {
     if (node == NULL) return NULL;
 
     if (node->is_collision) {
         for (int i = 0; i < node->cnt; i++) {
-            if (valuesEqual(node->pairs[2 * i], key)) {
-                return &node->pairs[2 * i + 1];
+            if (hamtKeysEqual(node->pairs[HAMT_ENTRY * i], key)) {
+                return &node->pairs[HAMT_ENTRY * i + 1];
             }
         }
         return NULL;
@@ -40,8 +212,8 @@ Value* hamtGet(HamtNode* node, Value key, uint64_t hash, int depth) {
 
     if (node->data_map & bit) {
         int idx = hamt_idx(node->data_map, bit);
-        if (valuesEqual(node->data[2 * idx], key)) {
-            return &node->data[2 * idx + 1];
+        if (hamtKeysEqual(node->data[HAMT_ENTRY * idx], key)) {
+            return &node->data[HAMT_ENTRY * idx + 1];
         }
         return NULL;
     }
@@ -54,23 +226,25 @@ Value* hamtGet(HamtNode* node, Value key, uint64_t hash, int depth) {
     return NULL;
 }
 
-HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
-                  int depth) {
+HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, int64_t seq,
+                  uint64_t hash, int depth) {
     // Case 1: an empty subtrie
     if (node == NULL) {
         HamtNode* n = allocNode(vm);
         push(vm, OBJ_VAL(n));
         if (depth > HAMT_DEPTH_MAX) {
-            n->pairs = (Value*)reallocate(vm, NULL, 0, 2 * sizeof(Value));
+            n->pairs = (Value*)reallocate(vm, NULL, 0, ENTRY_BYTES);
             n->pairs[0] = key;
             n->pairs[1] = val;
+            n->pairs[2] = INT_VAL(seq);
             n->is_collision = true;
             n->cnt = 1;
         } else {
             uint32_t bit = 1u << hamt_chunk(hash, depth);
-            n->data = (Value*)reallocate(vm, NULL, 0, 2 * sizeof(Value));
+            n->data = (Value*)reallocate(vm, NULL, 0, ENTRY_BYTES);
             n->data[0] = key;
             n->data[1] = val;
+            n->data[2] = INT_VAL(seq);
             n->data_map = bit;
         }
         pop(vm);
@@ -80,14 +254,14 @@ HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
     // Case 2: collision node
     if (node->is_collision) {
         for (int i = 0; i < node->cnt; i++) {
-            if (valuesEqual(node->pairs[2 * i], key)) {
+            if (hamtKeysEqual(node->pairs[HAMT_ENTRY * i], key)) {
                 HamtNode* n = allocNode(vm);
                 push(vm, OBJ_VAL(n));
                 int new_cnt = node->cnt;
                 n->pairs = (Value*)reallocate(vm, NULL, 0,
-                                              2 * new_cnt * sizeof(Value));
-                memcpy(n->pairs, node->pairs, 2 * new_cnt * sizeof(Value));
-                n->pairs[2 * i + 1] = val;
+                                              new_cnt * ENTRY_BYTES);
+                memcpy(n->pairs, node->pairs, new_cnt * ENTRY_BYTES);
+                n->pairs[HAMT_ENTRY * i + 1] = val;
                 n->is_collision = true;
                 n->cnt = new_cnt;
                 pop(vm);
@@ -97,10 +271,11 @@ HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
         HamtNode* n = allocNode(vm);
         push(vm, OBJ_VAL(n));
         int new_cnt = node->cnt + 1;
-        n->pairs = (Value*)reallocate(vm, NULL, 0, 2 * new_cnt * sizeof(Value));
-        memcpy(n->pairs, node->pairs, 2 * node->cnt * sizeof(Value));
-        n->pairs[2 * node->cnt] = key;
-        n->pairs[2 * node->cnt + 1] = val;
+        n->pairs = (Value*)reallocate(vm, NULL, 0, new_cnt * ENTRY_BYTES);
+        memcpy(n->pairs, node->pairs, node->cnt * ENTRY_BYTES);
+        n->pairs[HAMT_ENTRY * node->cnt] = key;
+        n->pairs[HAMT_ENTRY * node->cnt + 1] = val;
+        n->pairs[HAMT_ENTRY * node->cnt + 2] = INT_VAL(seq);
         n->is_collision = true;
         n->cnt = new_cnt;
         pop(vm);
@@ -116,12 +291,12 @@ HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
         int didx = hamt_idx(node->data_map, bit);
 
         // 3a: same key, we need to update the value
-        if (valuesEqual(node->data[2 * didx], key)) {
+        if (hamtKeysEqual(node->data[HAMT_ENTRY * didx], key)) {
             HamtNode* n = allocNode(vm);
             push(vm, OBJ_VAL(n));
-            n->data = (Value*)reallocate(vm, NULL, 0, dc * 2 * sizeof(Value));
-            memcpy(n->data, node->data, dc * 2 * sizeof(Value));
-            n->data[2 * didx + 1] = val;
+            n->data = (Value*)reallocate(vm, NULL, 0, dc * ENTRY_BYTES);
+            memcpy(n->data, node->data, dc * ENTRY_BYTES);
+            n->data[HAMT_ENTRY * didx + 1] = val;
             if (nc > 0) {
                 n->nodes =
                     (HamtNode**)reallocate(vm, NULL, 0, nc * sizeof(HamtNode*));
@@ -134,23 +309,25 @@ HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
         }
 
         // 3b: different key, push both down into a subtrie
-        Value ex_key = node->data[2 * didx];
-        Value ex_val = node->data[2 * didx + 1];
-        uint64_t ex_hash = hamtHash(ex_key);
-        HamtNode* sub = hamtPut(vm, NULL, ex_key, ex_val, ex_hash, depth + 1);
+        Value ex_key = node->data[HAMT_ENTRY * didx];
+        Value ex_val = node->data[HAMT_ENTRY * didx + 1];
+        int64_t ex_seq = AS_INT(node->data[HAMT_ENTRY * didx + 2]);
+        uint64_t ex_hash = hamtKeyHash(vm, ex_key);
+        HamtNode* sub =
+            hamtPut(vm, NULL, ex_key, ex_val, ex_seq, ex_hash, depth + 1);
         push(vm, OBJ_VAL(sub));
-        sub = hamtPut(vm, sub, key, val, hash, depth + 1);
+        sub = hamtPut(vm, sub, key, val, seq, hash, depth + 1);
         vm->stack_top[-1] = OBJ_VAL(sub);
 
         int nidx = hamt_idx(node->node_map, bit);
         HamtNode* n = allocNode(vm);
         push(vm, OBJ_VAL(n));
         if (dc - 1 > 0) {
-            n->data =
-                (Value*)reallocate(vm, NULL, 0, (dc - 1) * 2 * sizeof(Value));
-            memcpy(n->data, node->data, didx * 2 * sizeof(Value));
-            memcpy(&n->data[2 * didx], &node->data[2 * (didx + 1)],
-                   (dc - didx - 1) * 2 * sizeof(Value));
+            n->data = (Value*)reallocate(vm, NULL, 0, (dc - 1) * ENTRY_BYTES);
+            memcpy(n->data, node->data, didx * ENTRY_BYTES);
+            memcpy(&n->data[HAMT_ENTRY * didx],
+                   &node->data[HAMT_ENTRY * (didx + 1)],
+                   (dc - didx - 1) * ENTRY_BYTES);
         }
         n->nodes =
             (HamtNode**)reallocate(vm, NULL, 0, (nc + 1) * sizeof(HamtNode*));
@@ -169,13 +346,13 @@ HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
     if (node->node_map & bit) {
         int nidx = hamt_idx(node->node_map, bit);
         HamtNode* child =
-            hamtPut(vm, node->nodes[nidx], key, val, hash, depth + 1);
+            hamtPut(vm, node->nodes[nidx], key, val, seq, hash, depth + 1);
         push(vm, OBJ_VAL(child));
         HamtNode* n = allocNode(vm);
         push(vm, OBJ_VAL(n));
         if (dc > 0) {
-            n->data = (Value*)reallocate(vm, NULL, 0, dc * 2 * sizeof(Value));
-            memcpy(n->data, node->data, dc * 2 * sizeof(Value));
+            n->data = (Value*)reallocate(vm, NULL, 0, dc * ENTRY_BYTES);
+            memcpy(n->data, node->data, dc * ENTRY_BYTES);
         }
         n->nodes = (HamtNode**)reallocate(vm, NULL, 0, nc * sizeof(HamtNode*));
         memcpy(n->nodes, node->nodes, nc * sizeof(HamtNode*));
@@ -191,12 +368,13 @@ HamtNode* hamtPut(VM* vm, HamtNode* node, Value key, Value val, uint64_t hash,
     int didx = hamt_idx(node->data_map, bit);
     HamtNode* n = allocNode(vm);
     push(vm, OBJ_VAL(n));
-    n->data = (Value*)reallocate(vm, NULL, 0, (dc + 1) * 2 * sizeof(Value));
-    memcpy(n->data, node->data, didx * 2 * sizeof(Value));
-    n->data[2 * didx] = key;
-    n->data[2 * didx + 1] = val;
-    memcpy(&n->data[2 * (didx + 1)], &node->data[2 * didx],
-           (dc - didx) * 2 * sizeof(Value));
+    n->data = (Value*)reallocate(vm, NULL, 0, (dc + 1) * ENTRY_BYTES);
+    memcpy(n->data, node->data, didx * ENTRY_BYTES);
+    n->data[HAMT_ENTRY * didx] = key;
+    n->data[HAMT_ENTRY * didx + 1] = val;
+    n->data[HAMT_ENTRY * didx + 2] = INT_VAL(seq);
+    memcpy(&n->data[HAMT_ENTRY * (didx + 1)], &node->data[HAMT_ENTRY * didx],
+           (dc - didx) * ENTRY_BYTES);
     if (nc > 0) {
         n->nodes = (HamtNode**)reallocate(vm, NULL, 0, nc * sizeof(HamtNode*));
         memcpy(n->nodes, node->nodes, nc * sizeof(HamtNode*));
@@ -215,7 +393,7 @@ HamtNode* hamtDel(VM* vm, HamtNode* node, Value key, uint64_t hash, int depth) {
     // Case 2: collision node
     if (node->is_collision) {
         for (int i = 0; i < node->cnt; i++) {
-            if (valuesEqual(node->pairs[2 * i], key)) {
+            if (hamtKeysEqual(node->pairs[HAMT_ENTRY * i], key)) {
                 if (node->cnt == 1) {
                     return NULL;
                 }
@@ -223,10 +401,11 @@ HamtNode* hamtDel(VM* vm, HamtNode* node, Value key, uint64_t hash, int depth) {
                 push(vm, OBJ_VAL(n));
                 int new_cnt = node->cnt - 1;
                 n->pairs = (Value*)reallocate(vm, NULL, 0,
-                                              2 * new_cnt * sizeof(Value));
-                memcpy(n->pairs, node->pairs, i * 2 * sizeof(Value));
-                memcpy(&n->pairs[2 * i], &node->pairs[2 * (i + 1)],
-                       (node->cnt - i - 1) * 2 * sizeof(Value));
+                                              new_cnt * ENTRY_BYTES);
+                memcpy(n->pairs, node->pairs, i * ENTRY_BYTES);
+                memcpy(&n->pairs[HAMT_ENTRY * i],
+                       &node->pairs[HAMT_ENTRY * (i + 1)],
+                       (node->cnt - i - 1) * ENTRY_BYTES);
                 n->is_collision = true;
                 n->cnt = new_cnt;
                 pop(vm);
@@ -243,7 +422,7 @@ HamtNode* hamtDel(VM* vm, HamtNode* node, Value key, uint64_t hash, int depth) {
     // Case 3: slot has an inline pair
     if (node->data_map & bit) {
         int didx = hamt_idx(node->data_map, bit);
-        if (!valuesEqual(node->data[2 * didx], key)) {
+        if (!hamtKeysEqual(node->data[HAMT_ENTRY * didx], key)) {
             return node;  // not found
         }
         if (dc == 1 && nc == 0) {
@@ -252,11 +431,11 @@ HamtNode* hamtDel(VM* vm, HamtNode* node, Value key, uint64_t hash, int depth) {
         HamtNode* n = allocNode(vm);
         push(vm, OBJ_VAL(n));
         if (dc - 1 > 0) {
-            n->data =
-                (Value*)reallocate(vm, NULL, 0, (dc - 1) * 2 * sizeof(Value));
-            memcpy(n->data, node->data, didx * 2 * sizeof(Value));
-            memcpy(&n->data[2 * didx], &node->data[2 * (didx + 1)],
-                   (dc - didx - 1) * 2 * sizeof(Value));
+            n->data = (Value*)reallocate(vm, NULL, 0, (dc - 1) * ENTRY_BYTES);
+            memcpy(n->data, node->data, didx * ENTRY_BYTES);
+            memcpy(&n->data[HAMT_ENTRY * didx],
+                   &node->data[HAMT_ENTRY * (didx + 1)],
+                   (dc - didx - 1) * ENTRY_BYTES);
         }
         if (nc > 0) {
             n->nodes =
@@ -285,8 +464,8 @@ HamtNode* hamtDel(VM* vm, HamtNode* node, Value key, uint64_t hash, int depth) {
             push(vm, OBJ_VAL(n));
             if (dc > 0) {
                 n->data =
-                    (Value*)reallocate(vm, NULL, 0, dc * 2 * sizeof(Value));
-                memcpy(n->data, node->data, dc * 2 * sizeof(Value));
+                    (Value*)reallocate(vm, NULL, 0, dc * ENTRY_BYTES);
+                memcpy(n->data, node->data, dc * ENTRY_BYTES);
             }
             if (nc - 1 > 0) {
                 n->nodes = (HamtNode**)reallocate(vm, NULL, 0,
@@ -305,8 +484,8 @@ HamtNode* hamtDel(VM* vm, HamtNode* node, Value key, uint64_t hash, int depth) {
         HamtNode* n = allocNode(vm);
         push(vm, OBJ_VAL(n));
         if (dc > 0) {
-            n->data = (Value*)reallocate(vm, NULL, 0, dc * 2 * sizeof(Value));
-            memcpy(n->data, node->data, dc * 2 * sizeof(Value));
+            n->data = (Value*)reallocate(vm, NULL, 0, dc * ENTRY_BYTES);
+            memcpy(n->data, node->data, dc * ENTRY_BYTES);
         }
         n->nodes = (HamtNode**)reallocate(vm, NULL, 0, nc * sizeof(HamtNode*));
         memcpy(n->nodes, node->nodes, nc * sizeof(HamtNode*));
@@ -326,13 +505,14 @@ void hamtEach(HamtNode* node, void (*fn)(Value key, Value val, void* ctx),
     if (node == NULL) return;
     if (node->is_collision) {
         for (int i = 0; i < node->cnt; i++) {
-            fn(node->pairs[2 * i], node->pairs[2 * i + 1], ctx);
+            fn(node->pairs[HAMT_ENTRY * i], node->pairs[HAMT_ENTRY * i + 1],
+               ctx);
         }
         return;
     }
     int dc = __builtin_popcount(node->data_map);
     for (int i = 0; i < dc; i++) {
-        fn(node->data[2 * i], node->data[2 * i + 1], ctx);
+        fn(node->data[HAMT_ENTRY * i], node->data[HAMT_ENTRY * i + 1], ctx);
     }
     int nc = __builtin_popcount(node->node_map);
     for (int i = 0; i < nc; i++) {
@@ -344,15 +524,15 @@ void hamtMark(VM* vm, HamtNode* node) {
     if (node == NULL) return;
     if (node->is_collision) {
         for (int i = 0; i < node->cnt; i++) {
-            markValue(vm, node->pairs[2 * i]);
-            markValue(vm, node->pairs[2 * i + 1]);
+            markValue(vm, node->pairs[HAMT_ENTRY * i]);
+            markValue(vm, node->pairs[HAMT_ENTRY * i + 1]);
         }
         return;
     }
     int dc = __builtin_popcount(node->data_map);
     for (int i = 0; i < dc; i++) {
-        markValue(vm, node->data[2 * i]);
-        markValue(vm, node->data[2 * i + 1]);
+        markValue(vm, node->data[HAMT_ENTRY * i]);
+        markValue(vm, node->data[HAMT_ENTRY * i + 1]);
     }
     int nc = __builtin_popcount(node->node_map);
     for (int i = 0; i < nc; i++) {
@@ -362,11 +542,42 @@ void hamtMark(VM* vm, HamtNode* node) {
 
 void hamtFree(VM* vm, HamtNode* node) {
     if (node->is_collision) {
-        reallocate(vm, node->pairs, 2 * node->cnt * sizeof(Value), 0);
+        reallocate(vm, node->pairs, node->cnt * ENTRY_BYTES, 0);
     } else {
         int dc = __builtin_popcount(node->data_map);
         int nc = __builtin_popcount(node->node_map);
-        if (node->data) reallocate(vm, node->data, dc * 2 * sizeof(Value), 0);
+        if (node->data) reallocate(vm, node->data, dc * ENTRY_BYTES, 0);
         if (node->nodes) reallocate(vm, node->nodes, nc * sizeof(HamtNode*), 0);
     }
 }
+
+static void collectOrdered(HamtNode* node, HamtEntry* entries, int* cnt) {
+    if (node == NULL) return;
+    Value* slots = node->is_collision ? node->pairs : node->data;
+    int dc = node->is_collision ? node->cnt
+                                : __builtin_popcount(node->data_map);
+    for (int i = 0; i < dc; i++) {
+        Value* entry = &slots[HAMT_ENTRY * i];
+        entries[(*cnt)++] = (HamtEntry){entry[0], entry[1], AS_INT(entry[2])};
+    }
+    if (node->is_collision) return;
+    int nc = __builtin_popcount(node->node_map);
+    for (int i = 0; i < nc; i++) {
+        collectOrdered(node->nodes[i], entries, cnt);
+    }
+}
+
+static int cmpEntrySeq(const void* a, const void* b) {
+    int64_t x = ((const HamtEntry*)a)->seq;
+    int64_t y = ((const HamtEntry*)b)->seq;
+    return (x > y) - (x < y);
+}
+
+HamtEntry* hamtOrdered(HamtNode* node, int count) {
+    HamtEntry* entries = malloc(sizeof(HamtEntry) * (count > 0 ? count : 1));
+    if (entries == NULL) return NULL;
+    int cnt = 0;
+    collectOrdered(node, entries, &cnt);
+    qsort(entries, cnt, sizeof(HamtEntry), cmpEntrySeq);
+    return entries;
+}
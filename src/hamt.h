@@ -10,6 +10,13 @@
 #define HAMT_WIDTH (1u << HAMT_BITS)  // 32-way branching
 #define HAMT_MASK (HAMT_WIDTH - 1)
 #define HAMT_DEPTH_MAX 12  // 12 * 5 = 60 bits of 64-bit hash
+// Every entry takes three Values: the key, the value and the insertion
+// sequence number that orders the entries of a dict.
+#define HAMT_ENTRY 3
+// Lists and pairs make keys by value. A key may nest them this deep and hold
+// this many of them in total.
+#define HAMT_KEY_DEPTH_MAX 64
+#define HAMT_KEY_NODES_MAX 65536
 
 typedef struct HamtNode HamtNode;
 struct HamtNode {
@@ -19,16 +26,18 @@ struct HamtNode {
         struct {                // inner node
             uint32_t data_map;  // bit i: slot i has an inline kv pair here
             uint32_t node_map;  // bit i: slot i has a child subtrie
-            Value* data;        // 2 * popcount(node_map) children
+            Value* data;        // HAMT_ENTRY * popcount(data_map) Values
             HamtNode** nodes;
         };
         struct {
             int cnt;
-            Value* pairs;  // 2 * cnt Values [k, v, k, v, ...]
+            Value* pairs;  // HAMT_ENTRY * cnt Values [k, v, seq, ...]
         };
     };
 };
 
+uint64_t hamtHashComposite(Value v);
+
 static inline uint64_t hamtHash(Value v) {
     switch (v.type) {
         case VAL_NIL:
@@ -57,6 +66,13 @@ static inline uint64_t hamtHash(Value v) {
                 return AS_STRING(v)
                     ->hash;  // A string is fnv-1a-hashed. It is good enough to
                              // get a balanced hash value
+            if (OBJ_TYPE(v) == OBJ_BYTES) {
+                return hashString((const char*)AS_BYTES(v)->data,
+                                  (int)AS_BYTES(v)->len);
+            }
+            if (OBJ_TYPE(v) == OBJ_LIST || OBJ_TYPE(v) == OBJ_PAIR ||
+                OBJ_TYPE(v) == OBJ_TUPLE)
+                return hamtHashComposite(v);
             return (uint64_t)(uintptr_t)AS_OBJ(v);
         }
     }
@@ -73,14 +89,36 @@ static inline int hamt_idx(uint32_t bitmap, uint32_t bit) {
 
 struct VM;
 
+typedef struct {
+    Value key;
+    Value val;
+    int64_t seq;
+} HamtEntry;
+
+// The hash a dict of vm files key under: hamtHash mixed with the hash_seed of
+// its options, so that a seed reshapes every trie. Entries still list in the
+// order they were added, whatever the seed.
+uint64_t hamtKeyHash(struct VM* vm, Value key);
+
 HamtNode* hamtNew(struct VM* vm);
+// Key equality: valuesEqual, except that lists and pairs compare by contents.
+bool hamtKeysEqual(Value a, Value b);
+// Replaces a key that is or holds a list with a frozen copy, so that changing
+// the original later cannot strand the entry. Returns false if the key nests
+// too deeply or is too big, as a list that contains itself is.
+bool hamtFreezeKey(struct VM* vm, Value* key);
 Value* hamtGet(HamtNode* node, Value key, uint64_t hash, int depth);
+// Adds key or replaces its value. A new key takes seq as its place in the
+// insertion order; an existing one keeps its own.
 HamtNode* hamtPut(struct VM* vm, HamtNode* node, Value key, Value val,
-                  uint64_t hash, int depth);
+                  int64_t seq, uint64_t hash, int depth);
 HamtNode* hamtDel(struct VM* vm, HamtNode* node, Value key, uint64_t hash,
                   int depth);
 void hamtEach(HamtNode* node, void (*fn)(Value key, Value val, void* ctx),
               void* ctx);
+// Returns the count entries under node sorted by insertion order, or NULL if
+// out of memory. The caller frees the array.
+HamtEntry* hamtOrdered(HamtNode* node, int count);
 void hamtMark(struct VM* vm, HamtNode* node);
 void hamtFree(struct VM* vm, HamtNode* node);
 
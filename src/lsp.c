@@ -0,0 +1,746 @@
+#define _POSIX_C_SOURCE 200809L
+#include "lsp.h"
+
+#include <stdlib.h>
+#include <string.h>
+
+#include "diagnostic.h"
+#include "object.h"
+#include "symbols.h"
+#include "table.h"
+#include "utf8.h"
+
+#define LSP_PARSE_ERROR -32700
+#define LSP_METHOD_NOT_FOUND -32601
+
+#define LSP_SYMBOL_FUNCTION 12
+#define LSP_SYMBOL_VARIABLE 13
+#define LSP_SYMBOL_CONSTANT 14
+
+// Deepest nesting of arrays and objects a message may have.
+#define JSON_MAX_DEPTH 64
+
+typedef enum {
+    JSON_NULL,
+    JSON_BOOL,
+    JSON_NUMBER,
+    JSON_STRING,
+    JSON_ARRAY,
+    JSON_OBJECT,
+} JsonType;
+
+typedef struct Json Json;
+
+struct Json {
+    JsonType type;
+    char* key;  // Name of an object member, NULL otherwise
+    bool boolean;
+    double number;
+    char* string;
+    int count;    // Elements of an array or members of an object
+    Json* items;
+};
+
+static void freeJson(Json* json) {
+    free(json->key);
+    free(json->string);
+    for (int i = 0; i < json->count; i++) freeJson(&json->items[i]);
+    free(json->items);
+}
+
+static void skipSpace(const char** p) {
+    while (**p == ' ' || **p == '\t' || **p == '\n' || **p == '\r') (*p)++;
+}
+
+static bool parseHex4(const char** p, uint32_t* out) {
+    uint32_t value = 0;
+    for (int i = 0; i < 4; i++) {
+        char c = (*p)[i];
+        int digit;
+        if (c >= '0' && c <= '9') {
+            digit = c - '0';
+        } else if (c >= 'a' && c <= 'f') {
+            digit = c - 'a' + 10;
+        } else if (c >= 'A' && c <= 'F') {
+            digit = c - 'A' + 10;
+        } else {
+            return false;
+        }
+        value = value * 16 + (uint32_t)digit;
+    }
+    *p += 4;
+    *out = value;
+    return true;
+}
+
+// Appends rune to buf as UTF-8, which takes at most 4 bytes.
+static void appendRune(char* buf, size_t* len, uint32_t rune) {
+    if (rune < 0x80) {
+        buf[(*len)++] = (char)rune;
+    } else if (rune < 0x800) {
+        buf[(*len)++] = (char)(0xC0 | (rune >> 6));
+        buf[(*len)++] = (char)(0x80 | (rune & 0x3F));
+    } else if (rune < 0x10000) {
+        buf[(*len)++] = (char)(0xE0 | (rune >> 12));
+        buf[(*len)++] = (char)(0x80 | ((rune >> 6) & 0x3F));
+        buf[(*len)++] = (char)(0x80 | (rune & 0x3F));
+    } else {
+        buf[(*len)++] = (char)(0xF0 | (rune >> 18));
+        buf[(*len)++] = (char)(0x80 | ((rune >> 12) & 0x3F));
+        buf[(*len)++] = (char)(0x80 | ((rune >> 6) & 0x3F));
+        buf[(*len)++] = (char)(0x80 | (rune & 0x3F));
+    }
+}
+
+// Parses the string literal at *p into a new string in *out.
+static bool parseString(const char** p, char** out) {
+    (*p)++;  // The opening quote
+    size_t cap = 16;
+    size_t len = 0;
+    char* buf = malloc(cap);
+    for (;;) {
+        char c = **p;
+        if (c == '\0' || (unsigned char)c < ' ') {
+            free(buf);
+            return false;
+        }
+        (*p)++;
+        if (c == '"') break;
+        if (len + 5 >= cap) {
+            cap *= 2;
+            buf = realloc(buf, cap);
+        }
+        if (c != '\\') {
+            buf[len++] = c;
+            continue;
+        }
+        uint32_t rune;
+        switch (*(*p)++) {
+            case '"':  buf[len++] = '"'; break;
+            case '\\': buf[len++] = '\\'; break;
+            case '/':  buf[len++] = '/'; break;
+            case 'b':  buf[len++] = '\b'; break;
+            case 'f':  buf[len++] = '\f'; break;
+            case 'n':  buf[len++] = '\n'; break;
+            case 'r':  buf[len++] = '\r'; break;
+            case 't':  buf[len++] = '\t'; break;
+            case 'u':
+                if (!parseHex4(p, &rune)) {
+                    free(buf);
+                    return false;
+                }
+                // A surrogate pair stands for one character past U+FFFF.
+                if (rune >= 0xD800 && rune < 0xDC00 && (*p)[0] == '\\' &&
+                    (*p)[1] == 'u') {
+                    const char* low_at = *p + 2;
+                    uint32_t low;
+                    if (parseHex4(&low_at, &low) && low >= 0xDC00 &&
+                        low < 0xE000) {
+                        rune = 0x10000 + ((rune - 0xD800) << 10) +
+                               (low - 0xDC00);
+                        *p = low_at;
+                    }
+                }
+                appendRune(buf, &len, rune);
+                break;
+            default:
+                free(buf);
+                return false;
+        }
+    }
+    buf[len] = '\0';
+    *out = buf;
+    return true;
+}
+
+static bool parseValue(const char** p, Json* out, int depth);
+
+// Parses the elements of an array or the members of an object, up to close.
+static bool parseItems(const char** p, Json* out, char close, int depth) {
+    (*p)++;  // The opening bracket
+    skipSpace(p);
+    if (**p == close) {
+        (*p)++;
+        return true;
+    }
+    int cap = 0;
+    for (;;) {
+        if (out->count == cap) {
+            cap = cap < 4 ? 4 : cap * 2;
+            out->items = realloc(out->items, sizeof(Json) * cap);
+        }
+        Json* item = &out->items[out->count];
+        memset(item, 0, sizeof(Json));
+        out->count++;
+        skipSpace(p);
+        if (out->type == JSON_OBJECT) {
+            if (**p != '"' || !parseString(p, &item->key)) return false;
+            skipSpace(p);
+            if (**p != ':') return false;
+            (*p)++;
+        }
+        if (!parseValue(p, item, depth + 1)) return false;
+        skipSpace(p);
+        if (**p == close) {
+            (*p)++;
+            return true;
+        }
+        if (**p != ',') return false;
+        (*p)++;
+    }
+}
+
+// Parses the value at *p into out. On failure out may be partly filled, and
+// still has to be freed.
+static bool parseValue(const char** p, Json* out, int depth) {
+    skipSpace(p);
+    if (depth > JSON_MAX_DEPTH) return false;
+    switch (**p) {
+        case '{':
+            out->type = JSON_OBJECT;
+            return parseItems(p, out, '}', depth);
+        case '[':
+            out->type = JSON_ARRAY;
+            return parseItems(p, out, ']', depth);
+        case '"':
+            out->type = JSON_STRING;
+            return parseString(p, &out->string);
+        case 't':
+        case 'f':
+            out->type = JSON_BOOL;
+            out->boolean = **p == 't';
+            if (strncmp(*p, out->boolean ? "true" : "false",
+                        out->boolean ? 4 : 5) != 0) {
+                return false;
+            }
+            *p += out->boolean ? 4 : 5;
+            return true;
+        case 'n':
+            out->type = JSON_NULL;
+            if (strncmp(*p, "null", 4) != 0) return false;
+            *p += 4;
+            return true;
+        default: {
+            char* end;
+            out->type = JSON_NUMBER;
+            out->number = strtod(*p, &end);
+            if (end == *p) return false;
+            *p = end;
+            return true;
+        }
+    }
+}
+
+// Returns the member key of an object, or NULL if json is not an object or
+// has no such member.
+static const Json* jsonAt(const Json* json, const char* key) {
+    if (json == NULL || json->type != JSON_OBJECT) return NULL;
+    for (int i = 0; i < json->count; i++) {
+        if (strcmp(json->items[i].key, key) == 0) return &json->items[i];
+    }
+    return NULL;
+}
+
+static const char* jsonString(const Json* json) {
+    return json != NULL && json->type == JSON_STRING ? json->string : NULL;
+}
+
+static int jsonInt(const Json* json) {
+    return json != NULL && json->type == JSON_NUMBER ? (int)json->number : 0;
+}
+
+static void writeJsonString(FILE* out, const char* s) {
+    fputc('"', out);
+    for (; *s != '\0'; s++) {
+        if (*s == '"' || *s == '\\') {
+            fprintf(out, "\\%c", *s);
+        } else if (*s == '\n') {
+            fputs("\\n", out);
+        } else if ((unsigned char)*s < ' ') {
+            fprintf(out, "\\u%04x", *s);
+        } else {
+            fputc(*s, out);
+        }
+    }
+    fputc('"', out);
+}
+
+// Writes the id of a request back as it came, a number or a string.
+static void writeId(FILE* out, const Json* id) {
+    if (id != NULL && id->type == JSON_NUMBER) {
+        fprintf(out, "%.17g", id->number);
+    } else if (id != NULL && id->type == JSON_STRING) {
+        writeJsonString(out, id->string);
+    } else {
+        fputs("null", out);
+    }
+}
+
+typedef struct {
+    char* uri;
+    char* text;
+} Document;
+
+typedef struct {
+    FILE* out;
+    VMOptions options;
+    Document* docs;
+    int doc_cnt;
+    int doc_cap;
+    bool shutdown;  // The client asked for a shutdown
+} Server;
+
+// A message being written, sent whole by sendMessage once its length is
+// known.
+typedef struct {
+    char* text;
+    size_t len;
+    FILE* body;
+} Message;
+
+static void beginMessage(Message* msg) {
+    msg->text = NULL;
+    msg->len = 0;
+    msg->body = open_memstream(&msg->text, &msg->len);
+    fputs("{\"jsonrpc\": \"2.0\", ", msg->body);
+}
+
+static void sendMessage(Server* server, Message* msg) {
+    fputc('}', msg->body);
+    fclose(msg->body);
+    fprintf(server->out, "Content-Length: %zu\r\n\r\n", msg->len);
+    fwrite(msg->text, 1, msg->len, server->out);
+    fflush(server->out);
+    free(msg->text);
+}
+
+// Begins the response to the request with id, leaving its result to write.
+static void beginResult(Message* msg, const Json* id) {
+    beginMessage(msg);
+    fputs("\"id\": ", msg->body);
+    writeId(msg->body, id);
+    fputs(", \"result\": ", msg->body);
+}
+
+static void sendError(Server* server, const Json* id, int code,
+                      const char* text) {
+    Message msg;
+    beginMessage(&msg);
+    fputs("\"id\": ", msg.body);
+    writeId(msg.body, id);
+    fprintf(msg.body, ", \"error\": {\"code\": %d, \"message\": ", code);
+    writeJsonString(msg.body, text);
+    fputc('}', msg.body);
+    sendMessage(server, &msg);
+}
+
+static Document* findDocument(Server* server, const char* uri) {
+    if (uri == NULL) return NULL;
+    for (int i = 0; i < server->doc_cnt; i++) {
+        if (strcmp(server->docs[i].uri, uri) == 0) return &server->docs[i];
+    }
+    return NULL;
+}
+
+static Document* setDocument(Server* server, const char* uri,
+                             const char* text) {
+    Document* doc = findDocument(server, uri);
+    if (doc == NULL) {
+        if (server->doc_cnt == server->doc_cap) {
+            server->doc_cap = server->doc_cap < 4 ? 4 : server->doc_cap * 2;
+            server->docs =
+                realloc(server->docs, sizeof(Document) * server->doc_cap);
+        }
+        doc = &server->docs[server->doc_cnt++];
+        doc->uri = strdup(uri);
+        doc->text = NULL;
+    }
+    free(doc->text);
+    doc->text = strdup(text);
+    return doc;
+}
+
+static void closeDocument(Server* server, const char* uri) {
+    Document* doc = findDocument(server, uri);
+    if (doc == NULL) return;
+    free(doc->uri);
+    free(doc->text);
+    *doc = server->docs[--server->doc_cnt];
+}
+
+// Returns the start of line, counted from 1, in text, or NULL if text has
+// fewer lines.
+static const char* findLine(const char* text, int line) {
+    for (int i = 1; i < line && text != NULL; i++) {
+        text = strchr(text, '\n');
+        if (text != NULL) text++;
+    }
+    return text;
+}
+
+// Converts the byte column, counted from 1, of a line of text to the LSP
+// character, counted from 0 in UTF-16 code units.
+static int toCharacter(const char* text, int line, int column) {
+    const char* start = findLine(text, line);
+    if (start == NULL) return 0;
+    int len = (int)strcspn(start, "\n");
+    int units = 0;
+    for (int i = 0; i < column - 1 && i < len;) {
+        int n = runeLength(start + i, len - i);
+        units += n == 4 ? 2 : 1;
+        i += n;
+    }
+    return units;
+}
+
+// Converts an LSP character of a line of text to its byte column.
+static int toColumn(const char* text, int line, int character) {
+    const char* start = findLine(text, line);
+    if (start == NULL) return 1;
+    int len = (int)strcspn(start, "\n");
+    int units = 0;
+    int i = 0;
+    while (units < character && i < len) {
+        int n = runeLength(start + i, len - i);
+        units += n == 4 ? 2 : 1;
+        i += n;
+    }
+    return i + 1;
+}
+
+// Writes the range from the byte column of a line of text over len bytes.
+static void writeRange(FILE* out, const char* text, int line, int column,
+                       int len) {
+    fprintf(out,
+            "{\"start\": {\"line\": %d, \"character\": %d}, "
+            "\"end\": {\"line\": %d, \"character\": %d}}",
+            line - 1, toCharacter(text, line, column), line - 1,
+            toCharacter(text, line, column + len));
+}
+
+// A document compiled in a VM of its own, kept to look up what its names
+// refer to.
+typedef struct {
+    VM* vm;
+    SymbolIndex index;
+    bool ok;
+} Analysis;
+
+static bool analyze(Server* server, const char* text, Analysis* analysis) {
+    analysis->vm = newVM(server->options);
+    if (analysis->vm == NULL) return false;
+    analysis->index = (SymbolIndex){0};
+    analysis->ok = indexSymbols(analysis->vm, text, &analysis->index);
+    return true;
+}
+
+static void endAnalysis(Analysis* analysis) {
+    freeSymbols(&analysis->index);
+    destroyVM(analysis->vm);
+}
+
+// Publishes the compile errors of a document, none if it compiles. Errors in
+// the modules it imports are left out, as their lines are in other files.
+static void publishDiagnostics(Server* server, const char* uri,
+                               const char* text) {
+    Analysis analysis = {0};
+    bool analyzed = text != NULL && analyze(server, text, &analysis);
+    Message msg;
+    beginMessage(&msg);
+    fputs("\"method\": \"textDocument/publishDiagnostics\", \"params\": "
+          "{\"uri\": ",
+          msg.body);
+    writeJsonString(msg.body, uri);
+    fputs(", \"diagnostics\": [", msg.body);
+    if (analyzed && !analysis.ok) {
+        VM* vm = analysis.vm;
+        int kept = vm->diagnostic_cnt < DIAGNOSTICS_MAX ? vm->diagnostic_cnt
+                                                        : DIAGNOSTICS_MAX;
+        bool first = true;
+        for (int i = 0; i < kept; i++) {
+            Diagnostic* diag = &vm->diagnostics[i];
+            if (strcmp(diag->module, "main") != 0) continue;
+            fputs(first ? "{\"range\": " : ", {\"range\": ", msg.body);
+            first = false;
+            int column = diag->column > 0 ? diag->column : 1;
+            writeRange(msg.body, text, diag->line, column, 1);
+            fputs(", \"severity\": 1, \"source\": \"liss\", \"message\": ",
+                  msg.body);
+            writeJsonString(msg.body, diag->message);
+            fputc('}', msg.body);
+        }
+        if (first) {
+            // Not a located error, like one from the VM.
+            fputs("{\"range\": ", msg.body);
+            writeRange(msg.body, text, 1, 1, 0);
+            fputs(", \"severity\": 1, \"source\": \"liss\", \"message\": ",
+                  msg.body);
+            writeJsonString(msg.body, vm->error_msg);
+            fputc('}', msg.body);
+        }
+    }
+    fputs("]}", msg.body);
+    sendMessage(server, &msg);
+    if (analyzed) endAnalysis(&analysis);
+}
+
+static const char* kindLabel(SymbolKind kind) {
+    switch (kind) {
+        case SYMBOL_GLOBAL:    return "global";
+        case SYMBOL_CONST:     return "const";
+        case SYMBOL_FUNCTION:  return "function";
+        case SYMBOL_LOCAL:     return "local";
+        case SYMBOL_PARAM:     return "parameter";
+        case SYMBOL_REFERENCE: return "reference";
+    }
+    return "name";
+}
+
+// Returns the native a name of the document refers to: one of the core
+// module, or module:name of a module the document imports.
+static ObjNative* findNative(VM* vm, const char* name) {
+    ObjModule* module = vm->core_module;
+    const char* colon = strchr(name, ':');
+    if (colon != NULL) {
+        ObjString* module_name = copyString(vm, name, (int)(colon - name));
+        Value* found = tableGet(&vm->modules, OBJ_VAL(module_name));
+        if (found == NULL) return NULL;
+        module = AS_MODULE(*found);
+        name = colon + 1;
+    }
+    ObjString* key = copyString(vm, name, (int)strlen(name));
+    Value* value = tableGet(&module->symbols, OBJ_VAL(key));
+    if (value == NULL || !IS_NATIVE(*value)) return NULL;
+    return AS_NATIVE(*value);
+}
+
+// Writes what the symbol is as markdown: the usage and docs of a function or
+// native, and the kind of a definition and its line. Returns false if nothing
+// is known.
+static bool describeSymbol(FILE* out, Analysis* analysis,
+                           const Symbol* symbol) {
+    const Symbol* def = symbol;
+    if (symbol->kind == SYMBOL_REFERENCE) {
+        def = symbol->definition >= 0
+                  ? &analysis->index.symbols[symbol->definition]
+                  : NULL;
+    }
+    char text[1024];
+    if (def != NULL && def->usage != NULL) {
+        snprintf(text, sizeof(text),
+                 "```lisp\n%s\n```\n%s%s%s `%s`, defined on line %d",
+                 def->usage, def->doc != NULL ? def->doc : "",
+                 def->doc != NULL ? "\n\n" : "", kindLabel(def->kind),
+                 def->name, def->line);
+    } else if (def != NULL) {
+        snprintf(text, sizeof(text), "%s `%s`, defined on line %d",
+                 kindLabel(def->kind), def->name, def->line);
+    } else {
+        ObjNative* native = findNative(analysis->vm, symbol->name);
+        const char* usage;
+        const char* doc;
+        if (native == NULL ||
+            !functionDocs(OBJ_VAL(native), &usage, &doc)) {
+            return false;
+        }
+        snprintf(text, sizeof(text), "```lisp\n%s\n```\n%s",
+                 usage != NULL ? usage : symbol->name,
+                 doc != NULL ? doc : "");
+    }
+    writeJsonString(out, text);
+    return true;
+}
+
+static void hover(Server* server, const Json* id, const Json* params) {
+    const Json* position = jsonAt(params, "position");
+    Document* doc = findDocument(
+        server, jsonString(jsonAt(jsonAt(params, "textDocument"), "uri")));
+    Analysis analysis = {0};
+    Message msg;
+    beginResult(&msg, id);
+    if (doc == NULL || position == NULL ||
+        !analyze(server, doc->text, &analysis)) {
+        fputs("null", msg.body);
+        sendMessage(server, &msg);
+        return;
+    }
+    int line = jsonInt(jsonAt(position, "line")) + 1;
+    int column =
+        toColumn(doc->text, line, jsonInt(jsonAt(position, "character")));
+    const Symbol* found = NULL;
+    for (int i = 0; i < analysis.index.count && found == NULL; i++) {
+        const Symbol* symbol = &analysis.index.symbols[i];
+        int len = (int)strlen(symbol->name);
+        if (symbol->line == line && column >= symbol->column &&
+            column < symbol->column + len) {
+            found = symbol;
+        }
+    }
+    // Written to its own buffer, as the result is null if nothing is known.
+    char* value = NULL;
+    size_t value_len = 0;
+    FILE* out = open_memstream(&value, &value_len);
+    bool described = found != NULL && describeSymbol(out, &analysis, found);
+    fclose(out);
+    if (described) {
+        fputs("{\"contents\": {\"kind\": \"markdown\", \"value\": ", msg.body);
+        fputs(value, msg.body);
+        fputs("}, \"range\": ", msg.body);
+        writeRange(msg.body, doc->text, found->line, found->column,
+                   (int)strlen(found->name));
+        fputc('}', msg.body);
+    } else {
+        fputs("null", msg.body);
+    }
+    free(value);
+    sendMessage(server, &msg);
+    endAnalysis(&analysis);
+}
+
+static void documentSymbols(Server* server, const Json* id,
+                            const Json* params) {
+    Document* doc = findDocument(
+        server, jsonString(jsonAt(jsonAt(params, "textDocument"), "uri")));
+    Analysis analysis = {0};
+    Message msg;
+    beginResult(&msg, id);
+    fputc('[', msg.body);
+    if (doc != NULL && analyze(server, doc->text, &analysis)) {
+        bool first = true;
+        for (int i = 0; i < analysis.index.count; i++) {
+            const Symbol* symbol = &analysis.index.symbols[i];
+            int kind;
+            switch (symbol->kind) {
+                case SYMBOL_GLOBAL:   kind = LSP_SYMBOL_VARIABLE; break;
+                case SYMBOL_CONST:    kind = LSP_SYMBOL_CONSTANT; break;
+                case SYMBOL_FUNCTION: kind = LSP_SYMBOL_FUNCTION; break;
+                default:              continue;
+            }
+            fputs(first ? "{\"name\": " : ", {\"name\": ", msg.body);
+            first = false;
+            writeJsonString(msg.body, symbol->name);
+            fprintf(msg.body, ", \"kind\": %d, \"range\": ", kind);
+            int len = (int)strlen(symbol->name);
+            writeRange(msg.body, doc->text, symbol->line, symbol->column, len);
+            fputs(", \"selectionRange\": ", msg.body);
+            writeRange(msg.body, doc->text, symbol->line, symbol->column, len);
+            fputc('}', msg.body);
+        }
+        endAnalysis(&analysis);
+    }
+    fputc(']', msg.body);
+    sendMessage(server, &msg);
+}
+
+static void initialize(Server* server, const Json* id) {
+    Message msg;
+    beginResult(&msg, id);
+    fputs("{\"capabilities\": {"
+          "\"textDocumentSync\": {\"openClose\": true, \"change\": 1, "
+          "\"save\": {\"includeText\": true}}, "
+          "\"hoverProvider\": true, \"documentSymbolProvider\": true}, "
+          "\"serverInfo\": {\"name\": \"liss\"}}",
+          msg.body);
+    sendMessage(server, &msg);
+}
+
+static void handleMessage(Server* server, const Json* message) {
+    const char* method = jsonString(jsonAt(message, "method"));
+    const Json* id = jsonAt(message, "id");
+    const Json* params = jsonAt(message, "params");
+    const Json* doc = jsonAt(params, "textDocument");
+    const char* uri = jsonString(jsonAt(doc, "uri"));
+    if (method == NULL) return;  // A response to the server
+
+    if (strcmp(method, "initialize") == 0) {
+        initialize(server, id);
+    } else if (strcmp(method, "shutdown") == 0) {
+        server->shutdown = true;
+        Message msg;
+        beginResult(&msg, id);
+        fputs("null", msg.body);
+        sendMessage(server, &msg);
+    } else if (strcmp(method, "textDocument/didOpen") == 0 && uri != NULL) {
+        const char* text = jsonString(jsonAt(doc, "text"));
+        Document* opened = setDocument(server, uri, text != NULL ? text : "");
+        publishDiagnostics(server, uri, opened->text);
+    } else if (strcmp(method, "textDocument/didChange") == 0 && uri != NULL) {
+        // The server asked for the whole text on every change.
+        const Json* changes = jsonAt(params, "contentChanges");
+        if (changes != NULL && changes->type == JSON_ARRAY &&
+            changes->count > 0) {
+            const Json* last = &changes->items[changes->count - 1];
+            const char* text = jsonString(jsonAt(last, "text"));
+            if (text != NULL) setDocument(server, uri, text);
+        }
+    } else if (strcmp(method, "textDocument/didSave") == 0 && uri != NULL) {
+        const char* text = jsonString(jsonAt(params, "text"));
+        if (text != NULL) setDocument(server, uri, text);
+        Document* saved = findDocument(server, uri);
+        publishDiagnostics(server, uri, saved != NULL ? saved->text : NULL);
+    } else if (strcmp(method, "textDocument/didClose") == 0 && uri != NULL) {
+        closeDocument(server, uri);
+        publishDiagnostics(server, uri, NULL);
+    } else if (strcmp(method, "textDocument/hover") == 0) {
+        hover(server, id, params);
+    } else if (strcmp(method, "textDocument/documentSymbol") == 0) {
+        documentSymbols(server, id, params);
+    } else if (id != NULL) {
+        sendError(server, id, LSP_METHOD_NOT_FOUND, "method not found");
+    }
+}
+
+// Reads the body of the next message, after its headers. Returns NULL at the
+// end of in. The caller frees the body.
+static char* readMessage(FILE* in) {
+    char header[256];
+    long length = -1;
+    for (;;) {
+        if (fgets(header, sizeof(header), in) == NULL) return NULL;
+        if (strcmp(header, "\r\n") == 0 || strcmp(header, "\n") == 0) {
+            if (length >= 0) break;
+        } else if (strncmp(header, "Content-Length:", 15) == 0) {
+            length = strtol(header + 15, NULL, 10);
+        }
+    }
+    char* body = malloc((size_t)length + 1);
+    if (fread(body, 1, (size_t)length, in) != (size_t)length) {
+        free(body);
+        return NULL;
+    }
+    body[length] = '\0';
+    return body;
+}
+
+int runLsp(FILE* in, FILE* out, VMOptions options) {
+    Server server = {.out = out, .options = options};
+    int status = 1;
+    char* body;
+    while ((body = readMessage(in)) != NULL) {
+        Json message = {0};
+        const char* p = body;
+        bool parsed = parseValue(&p, &message, 0);
+        skipSpace(&p);
+        parsed = parsed && *p == '\0';
+        free(body);
+        if (!parsed) {
+            freeJson(&message);
+            sendError(&server, NULL, LSP_PARSE_ERROR, "invalid JSON");
+            continue;
+        }
+        const char* method = jsonString(jsonAt(&message, "method"));
+        if (method != NULL && strcmp(method, "exit") == 0) {
+            status = server.shutdown ? 0 : 1;
+            freeJson(&message);
+            break;
+        }
+        handleMessage(&server, &message);
+        freeJson(&message);
+    }
+    for (int i = 0; i < server.doc_cnt; i++) {
+        free(server.docs[i].uri);
+        free(server.docs[i].text);
+    }
+    free(server.docs);
+    return status;
+}
@@ -0,0 +1,20 @@
+#ifndef liss_lsp_h
+#define liss_lsp_h
+
+#include <stdio.h>
+
+#include "vm.h"
+
+// Serves the Language Server Protocol on in and out, as an editor runs it
+// over the stdio of `liss -lsp`, until the client sends exit or closes in.
+// Returns the exit status: 0 if the client asked for a shutdown first.
+//
+// Documents are kept as the client sends them, whole on every change. A
+// document is compiled in a VM of its own with options, never run, when it is
+// opened or saved, and the compile errors are published as its diagnostics.
+// Hover shows the usage and docs of a native, or where a name of the document
+// is defined, and the document symbols are its globals, consts and named
+// functions.
+int runLsp(FILE* in, FILE* out, VMOptions options);
+
+#endif
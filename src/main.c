@@ -1,34 +1,210 @@
 #include <signal.h>
+#include <stdatomic.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 
+#include "bench.h"
 #include "common.h"
+#include "embed.h"
+#include "explore.h"
+#include "fmt.h"
+#include "lsp.h"
 #include "repl.h"
+#include "testrun.h"
 #include "vm.h"
 
+#define USAGE                                                           \
+    "Usage: liss [-disasm | -explore | -fmt [-w] | -symbols]\n"         \
+    "            [script [args...]]\n"                                  \
+    "       liss -test dir\n"                                           \
+    "       liss -lsp\n"                                                \
+    "       liss -print-path [module]\n"                                \
+    "       liss -bench [--bench-time ms] [--bench-baseline file]\n"    \
+    "                   [--bench-save file] [filter]\n"
+
 void intHandler(int dummy) {
     (void)dummy;  // Suppress unused parameter warning
     printf("Exiting now...\n");
     exit(0);
 }
 
-static bool isFlag(const char* arg) { return arg[0] == '-' && arg[1] == '-'; }
+// Set by Ctrl+C while a script runs, which stops it as options.cancel.
+static atomic_bool interrupted = false;
+
+static void cancelHandler(int sig) {
+    (void)sig;
+    atomic_store(&interrupted, true);
+}
+
+// Set by -bench: run the benchmark suite, the script argument filtering it.
+static bool bench = false;
+// Set by the --bench-* flags.
+static BenchOptions bench_options;
+// Set by -disasm: print the bytecode of the script instead of running it.
+static bool disasm = false;
+// Set by -explore: browse the bytecode of the script in the terminal.
+static bool explore = false;
+// Set by -fmt: print the script in canonical layout instead of running it.
+static bool fmt = false;
+// Set by -lsp: serve the Language Server Protocol on stdin and stdout.
+static bool lsp = false;
+// Set by -print-path: list where imports are looked up, or where the module
+// given instead of a script is found.
+static bool print_path = false;
+// Set by -symbols: print the names the script defines and uses as JSON.
+static bool symbols = false;
+// Set by -test: run the *_test.liss files under the given directory.
+static bool test = false;
+// Set by --metrics: write the metrics of the run as JSON to stderr on exit.
+static bool metrics = false;
+// Set by -w: make -fmt rewrite the file rather than print it.
+static bool write_back = false;
 
-static VMOptions parseVMFlags(int argc, const char* argv[]) {
+static bool isFlag(const char* arg) {
+    return arg[0] == '-' &&
+           (arg[1] == '-' || strcmp(arg, "-W") == 0 ||
+            strcmp(arg, "-bench") == 0 || strcmp(arg, "-disasm") == 0 ||
+            strcmp(arg, "-explore") == 0 || strcmp(arg, "-fmt") == 0 ||
+            strcmp(arg, "-lsp") == 0 || strcmp(arg, "-symbols") == 0 ||
+            strcmp(arg, "-print-path") == 0 ||
+            strcmp(arg, "-test") == 0 || strcmp(arg, "-w") == 0);
+}
+
+// Flags followed by a value, which must not be mistaken for the script name.
+static bool takesValue(const char* arg) {
+    return strcmp(arg, "--stack-capacity") == 0 ||
+           strcmp(arg, "--gc-threshold") == 0 ||
+           strcmp(arg, "--heap-growth-factor") == 0 ||
+           strcmp(arg, "--max-nesting") == 0 ||
+           strcmp(arg, "--max-heap") == 0 ||
+           strcmp(arg, "--max-instrs") == 0 ||
+           strcmp(arg, "--timeout") == 0 ||
+           strcmp(arg, "--root") == 0 ||
+           strcmp(arg, "--regex-cache-size") == 0 ||
+           strcmp(arg, "--bench-time") == 0 ||
+           strcmp(arg, "--bench-baseline") == 0 ||
+           strcmp(arg, "--bench-save") == 0 ||
+           strcmp(arg, "--trace-filter") == 0 ||
+           strcmp(arg, "--lang") == 0 || strcmp(arg, "--seed") == 0 ||
+           strcmp(arg, "--hash-seed") == 0;
+}
+
+// Returns the value that follows the flag at argv[*i] and moves *i onto it.
+// Exits with the usage if the flag is the last argument.
+static const char* flagValue(int argc, const char* argv[], int* i) {
+    if (*i + 1 >= argc) {
+        fprintf(stderr, "%s expects a value\n" USAGE, argv[*i]);
+        exit(64);
+    }
+    return argv[++*i];
+}
+
+// Reads the flags in argv. When the script at script_ix is run, as opposed to
+// formatted, disassembled, explored, indexed or tested, what follows it are
+// arguments of the script rather than flags.
+static VMOptions parseVMFlags(int argc, const char* argv[], int script_ix) {
     VMOptions options = defaultVMOptions();
     for (int i = 1; i < argc; i++) {
+        if (i == script_ix &&
+            !(bench || disasm || explore || fmt || symbols || test)) {
+            options.args = argv + i + 1;
+            options.arg_cnt = argc - i - 1;
+            break;
+        }
         if (!isFlag(argv[i])) {
             continue;
         }
         if (strcmp(argv[i], "--stack-capacity") == 0) {
-            options.stack_capacity = (size_t)atoi(argv[++i]);
+            options.stack_capacity = (size_t)atoi(flagValue(argc, argv, &i));
         } else if (strcmp(argv[i], "--gc-threshold") == 0) {
-            options.gc_threshold = (size_t)atoi(argv[++i]);
+            options.gc_threshold = (size_t)atoi(flagValue(argc, argv, &i));
         } else if (strcmp(argv[i], "--heap-growth-factor") == 0) {
-            options.heap_growth_factor = atof(argv[++i]);
+            options.heap_growth_factor = atof(flagValue(argc, argv, &i));
+        } else if (strcmp(argv[i], "--max-nesting") == 0) {
+            options.max_nesting = atoi(flagValue(argc, argv, &i));
+        } else if (strcmp(argv[i], "--max-heap") == 0) {
+            options.max_heap =
+                (size_t)strtoull(flagValue(argc, argv, &i), NULL, 10);
+        } else if (strcmp(argv[i], "--max-instrs") == 0) {
+            options.max_instrs = strtoull(flagValue(argc, argv, &i), NULL, 10);
+        } else if (strcmp(argv[i], "--timeout") == 0) {
+            options.timeout_ms = strtoull(flagValue(argc, argv, &i), NULL, 10);
+        } else if (strcmp(argv[i], "--root") == 0) {
+            options.project_root = flagValue(argc, argv, &i);
+        } else if (strcmp(argv[i], "--plugins") == 0) {
+            options.plugins = true;
+        } else if (strcmp(argv[i], "--regex-cache-size") == 0) {
+            options.regex_cache_size = atoi(flagValue(argc, argv, &i));
         } else if (strcmp(argv[i], "--stress-gc") == 0) {
             options.stress_gc = true;
+        } else if (strcmp(argv[i], "--profile") == 0) {
+            options.profile = true;
+        } else if (strcmp(argv[i], "--metrics") == 0) {
+            // The opcode histogram is only counted while profiling.
+            options.profile = true;
+            metrics = true;
+        } else if (strcmp(argv[i], "--trace") == 0) {
+            options.trace = stderr;
+        } else if (strcmp(argv[i], "--trace-filter") == 0) {
+            // A filter alone implies tracing.
+            options.trace = stderr;
+            options.trace_filter = flagValue(argc, argv, &i);
+        } else if (strcmp(argv[i], "--strict") == 0) {
+            options.strict = true;
+        } else if (strcmp(argv[i], "-W") == 0 ||
+                   strcmp(argv[i], "--warnings") == 0) {
+            options.warnings = true;
+        } else if (strcmp(argv[i], "-bench") == 0 ||
+                   strcmp(argv[i], "--bench") == 0) {
+            bench = true;
+        } else if (strcmp(argv[i], "--bench-time") == 0) {
+            bench_options.time_ns =
+                atoll(flagValue(argc, argv, &i)) * 1000000LL;
+        } else if (strcmp(argv[i], "--bench-baseline") == 0) {
+            bench_options.baseline = flagValue(argc, argv, &i);
+        } else if (strcmp(argv[i], "--bench-save") == 0) {
+            bench_options.save = flagValue(argc, argv, &i);
+        } else if (strcmp(argv[i], "-disasm") == 0 ||
+                   strcmp(argv[i], "--disasm") == 0) {
+            disasm = true;
+        } else if (strcmp(argv[i], "-explore") == 0 ||
+                   strcmp(argv[i], "--explore") == 0) {
+            explore = true;
+        } else if (strcmp(argv[i], "-fmt") == 0 ||
+                   strcmp(argv[i], "--fmt") == 0) {
+            fmt = true;
+        } else if (strcmp(argv[i], "-lsp") == 0 ||
+                   strcmp(argv[i], "--lsp") == 0) {
+            lsp = true;
+        } else if (strcmp(argv[i], "-print-path") == 0 ||
+                   strcmp(argv[i], "--print-path") == 0) {
+            print_path = true;
+        } else if (strcmp(argv[i], "-symbols") == 0 ||
+                   strcmp(argv[i], "--symbols") == 0) {
+            symbols = true;
+        } else if (strcmp(argv[i], "-test") == 0 ||
+                   strcmp(argv[i], "--test") == 0) {
+            test = true;
+        } else if (strcmp(argv[i], "-w") == 0) {
+            write_back = true;
+        } else if (strcmp(argv[i], "--deterministic") == 0) {
+            options.deterministic = true;
+        } else if (strcmp(argv[i], "--seed") == 0) {
+            options.seeded = true;
+            options.seed = strtoull(flagValue(argc, argv, &i), NULL, 10);
+        } else if (strcmp(argv[i], "--hash-seed") == 0) {
+            options.hash_seed = strtoull(flagValue(argc, argv, &i), NULL, 10);
+        } else if (strcmp(argv[i], "--lang") == 0) {
+            const char* version = flagValue(argc, argv, &i);
+            if (!parseLangVersion(version, (int)strlen(version),
+                                  &options.lang_version) ||
+                options.lang_version < LANG_VERSION_MIN ||
+                options.lang_version > LANG_VERSION_LATEST) {
+                fprintf(stderr, "Unsupported language version: %s\n",
+                        version);
+                exit(64);
+            }
         } else {
             fprintf(stderr, "Unknown flag: %s\n", argv[i]);
             exit(64);
@@ -37,7 +213,7 @@ static VMOptions parseVMFlags(int argc, const char* argv[]) {
     return options;
 }
 
-static void runFile(const char* path, VMOptions options) {
+static char* readFile(const char* path) {
     FILE* file = fopen(path, "rb");
     if (file == NULL) {
         fprintf(stderr, "Could not open file \"%s\".\n", path);
@@ -62,26 +238,136 @@ static void runFile(const char* path, VMOptions options) {
 
     buffer[bytes_read] = '\0';
     fclose(file);
+    return buffer;
+}
+
+// Prints the formatted source of a file, or with -w writes it back in place.
+static void formatFile(const char* path) {
+    char* source = readFile(path);
+    const char* err_msg = NULL;
+    char* formatted = formatSource(source, &err_msg);
+    free(source);
+    if (formatted == NULL) {
+        fprintf(stderr, "%s: %s\n", path, err_msg);
+        exit(65);
+    }
+    if (!write_back) {
+        fputs(formatted, stdout);
+        free(formatted);
+        return;
+    }
+    FILE* file = fopen(path, "wb");
+    if (file == NULL || fputs(formatted, file) == EOF || fclose(file) != 0) {
+        fprintf(stderr, "Could not write file \"%s\".\n", path);
+        exit(74);
+    }
+    free(formatted);
+}
+
+// Compiles the script without running it and prints the names it defines
+// and uses as JSON, for editors to find definitions by. The names of the
+// forms that compile are printed even if others don't.
+static void printSymbols(const char* path, VMOptions options) {
+    char* buffer = readFile(path);
+    VM* vm = newVM(options);
+    if (vm == NULL) {
+        fprintf(stderr, "Could not create VM.\n");
+        exit(74);
+    }
+    SymbolIndex index = {0};
+    bool ok = indexSymbols(vm, buffer, &index);
+    free(buffer);
+    writeSymbolsJson(&index, stdout);
+    freeSymbols(&index);
+    if (!ok) {
+        printDiagnostics(vm, stderr, path);
+        destroyVM(vm);
+        exit(65);
+    }
+    destroyVM(vm);
+}
+
+// Prints the directories imports are looked up in, one per line, or if name
+// is given the file it is loaded from, or that the interpreter has it built
+// in. Exits with 1 if it is not found.
+static void printModulePath(const char* name, VMOptions options) {
+    char* search_path = options.module_path != NULL
+                            ? strdup(options.module_path)
+                            : defaultModulePath();
+    if (name == NULL) {
+        for (char* dir = strtok(search_path, ":"); dir != NULL;
+             dir = strtok(NULL, ":")) {
+            printf("%s\n", dir);
+        }
+        free(search_path);
+        return;
+    }
+    char* path = NULL;
+    char* source = findLissFile(search_path, name, &path);
+    if (source == NULL && embeddedModule(name) != NULL) {
+        printf("%s (built in)\n", name);
+        free(search_path);
+        return;
+    }
+    if (source == NULL) {
+        char searched[1024];
+        describeSearch(search_path, name, searched, sizeof(searched));
+        fprintf(stderr, "Module '%s' not found, searched: %s\n", name,
+                searched);
+        free(search_path);
+        exit(1);
+    }
+    printf("%s\n", path);
+    free(source);
+    free(path);
+    free(search_path);
+}
+
+static void runFile(const char* path, VMOptions options) {
+    char* buffer = readFile(path);
 
     VM* vm = newVM(options);
     if (vm == NULL) {
         fprintf(stderr, "Could not create VM.\n");
         exit(74);
     }
-    InterpretResult result = interpret(vm, buffer, NULL);
+    InterpretResult result;
+    if (disasm) {
+        char* listing = NULL;
+        result = disassemble(vm, buffer, &listing);
+        if (listing != NULL) {
+            fputs(listing, stdout);
+            free(listing);
+        }
+    } else {
+        // The script stops where it is, so the metrics are still written.
+        vm->options.cancel = &interrupted;
+        signal(SIGINT, cancelHandler);
+        result = interpret(vm, buffer, NULL);
+        signal(SIGINT, intHandler);
+    }
     free(buffer);
+    if (metrics && result != INTERPRET_COMPILE_ERROR) {
+        writeMetricsJson(vm, stderr);
+    } else if (options.profile && result != INTERPRET_COMPILE_ERROR) {
+        printMetrics(vm, stderr);
+    }
 
     if (result == INTERPRET_COMPILE_ERROR) {
-        fprintf(stderr, "%s\n", vm->error_msg);
+        printDiagnostics(vm, stderr, path);
         destroyVM(vm);
         exit(65);
     }
-    if (result == INTERPRET_RUNTIME_ERROR) {
+    if (result != INTERPRET_OK) {
         char* str = sprintValue(vm->raise_value);
         fprintf(stderr, "%s\n", str);
         free(str);
+        if (IS_ERROR(vm->raise_value) &&
+            AS_ERROR(vm->raise_value)->trace != NULL) {
+            fputs(AS_ERROR(vm->raise_value)->trace->chars, stderr);
+        }
         destroyVM(vm);
-        exit(70);
+        exit(result == INTERPRET_CANCELLED ? 130 : 70);
     }
     destroyVM(vm);
 }
@@ -90,23 +376,52 @@ int main(int argc, const char* argv[]) {
     signal(SIGINT, intHandler);
 
     const char* file_name = NULL;
+    int file_ix = 0;
     for (int i = 1; i < argc; i++) {
-        if (!isFlag(argv[i])) {
+        if (takesValue(argv[i])) {
+            i++;
+        } else if (!isFlag(argv[i])) {
             file_name = argv[i];
+            file_ix = i;
             break;
         }
     }
 
-    VMOptions options = parseVMFlags(argc, argv);
+    bench_options = defaultBenchOptions();
+    VMOptions options = parseVMFlags(argc, argv, file_ix);
 
-    if (file_name == NULL) {
+    if (lsp) {
+        exit(runLsp(stdin, stdout, options));
+    } else if (bench) {
+        bench_options.filter = file_name;
+        int status = runBenchmarks(bench_options, options, stdout);
+        if (status != 0) exit(status);
+    } else if ((fmt || disasm || explore || symbols || test) &&
+               file_name == NULL) {
+        fputs(USAGE, stderr);
+        exit(64);
+    } else if (print_path) {
+        printModulePath(file_name, options);
+    } else if (file_name == NULL) {
         // No file provided, run REPL
         runRepl(options);
+    } else if (fmt) {
+        formatFile(file_name);
+    } else if (symbols) {
+        printSymbols(file_name, options);
+    } else if (test) {
+        int status = runTests(file_name, options, stdout);
+        if (status != 0) exit(status);
+    } else if (explore) {
+        char* source = readFile(file_name);
+        int status = exploreFile(file_name, source, options);
+        free(source);
+        if (status != 0) exit(status);
     } else if (argc > 1) {
         // Run file
         runFile(file_name, options);
     } else {
-        fprintf(stderr, "Usage: liss [script]\n");
+        fputs(USAGE, stderr);
         exit(64);
     }
 
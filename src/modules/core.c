@@ -1,10 +1,14 @@
 #include "core.h"
 
+#include <inttypes.h>
 #include <stdio.h>
+#include <stdlib.h>
 #include <string.h>
 
 #include "hamt.h"
 #include "object.h"
+#include "repr.h"
+#include "utf8.h"
 #include "value.h"
 #include "vm.h"
 
@@ -29,6 +33,15 @@ static Value isErrNative(VM* vm, int argc, Value* argv) {
     return BOOL_VAL(IS_ERROR(argv[0]));
 }
 
+static Value errTraceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_ERROR(argv[0])) {
+        return raiseErr(vm, "err_trace expects an err value");
+    }
+    ObjString* trace = AS_ERROR(argv[0])->trace;
+    return trace != NULL ? OBJ_VAL(trace) : NIL_VAL;
+}
+
 static Value raiseNative(VM* vm, int argc, Value* argv) {
     (void)argc;
     if (!IS_ERROR(argv[0])) {
@@ -47,6 +60,57 @@ static Value noErrNative(VM* vm, int argc, Value* argv) {
     return NIL_VAL;
 }
 
+// Raises "<what> failed: " and the message, if the assertion was given one,
+// then the detail in parentheses, if there is one.
+static Value assertFailed(VM* vm, const char* what, const char* detail,
+                          int argc, Value* argv, int msg_ix) {
+    char* msg = NULL;
+    if (argc > msg_ix) {
+        Value arg = argv[msg_ix];
+        msg = IS_STRING(arg) ? strdup(AS_CSTRING(arg)) : sprintValue(arg);
+    }
+    if (msg != NULL && detail != NULL) {
+        RUNTIME_ERR(vm, "%s failed: %s (%s)", what, msg, detail);
+    } else if (msg != NULL || detail != NULL) {
+        RUNTIME_ERR(vm, "%s failed: %s", what, msg ? msg : detail);
+    } else {
+        RUNTIME_ERR(vm, "%s failed", what);
+    }
+    free(msg);
+    return NIL_VAL;
+}
+
+static Value assertNative(VM* vm, int argc, Value* argv) {
+    if (argc < 1 || argc > 2) {
+        return raiseErr(vm, "assert expects a value and an optional message");
+    }
+    if (!isFalsey(argv[0])) return NIL_VAL;
+    return assertFailed(vm, "assert", NULL, argc, argv, 1);
+}
+
+// Both sides of a failed assert_eq are shown as repr, so that "1" and 1 can
+// be told apart, or as str when they have none.
+static char* describe(Value value) {
+    char* s = reprValue(value);
+    return s != NULL ? s : sprintValue(value);
+}
+
+static Value assertEqNative(VM* vm, int argc, Value* argv) {
+    if (argc < 2 || argc > 3) {
+        return raiseErr(vm,
+                        "assert_eq expects two values and an optional message");
+    }
+    // Unlike `=`, lists and pairs are equal when their items are.
+    if (hamtKeysEqual(argv[0], argv[1])) return NIL_VAL;
+    char* got = describe(argv[0]);
+    char* want = describe(argv[1]);
+    char detail[400];
+    snprintf(detail, sizeof(detail), "%s != %s", got, want);
+    free(got);
+    free(want);
+    return assertFailed(vm, "assert_eq", detail, argc, argv, 2);
+}
+
 static Value lenNative(VM* vm, int argc, Value* argv) {
     (void)argc;
     Value arg = argv[0];
@@ -54,11 +118,30 @@ static Value lenNative(VM* vm, int argc, Value* argv) {
         return INT_VAL(AS_STRING(arg)->length);
     } else if (IS_LIST(arg)) {
         return INT_VAL(AS_LIST(arg)->len);
+    } else if (IS_TUPLE(arg)) {
+        return INT_VAL(AS_TUPLE(arg)->len);
+    } else if (IS_BYTES(arg)) {
+        return INT_VAL(AS_BYTES(arg)->len);
     } else if (IS_DICT(arg)) {
         return INT_VAL((int64_t)AS_DICT(arg)->count);
     }
 
-    return raiseErr(vm, "len takes a string, list, or dict argument");
+    return raiseErr(vm,
+                    "len takes a string, list, tuple, bytes or dict argument");
+}
+
+static Value runeLenNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "rune_len takes a string");
+    ObjString* str = AS_STRING(argv[0]);
+    return INT_VAL(runeCount(str->chars, str->length));
+}
+
+static Value byteLenNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (IS_STRING(argv[0])) return INT_VAL(AS_STRING(argv[0])->length);
+    if (IS_BYTES(argv[0])) return INT_VAL(AS_BYTES(argv[0])->len);
+    return raiseErr(vm, "byte_len takes a string or bytes");
 }
 
 static Value isEmptyNative(VM* vm, int argc, Value* argv) {
@@ -68,11 +151,16 @@ static Value isEmptyNative(VM* vm, int argc, Value* argv) {
         return BOOL_VAL(AS_STRING(arg)->length == 0);
     } else if (IS_LIST(arg)) {
         return BOOL_VAL(AS_LIST(arg)->len == 0);
+    } else if (IS_TUPLE(arg)) {
+        return BOOL_VAL(AS_TUPLE(arg)->len == 0);
+    } else if (IS_BYTES(arg)) {
+        return BOOL_VAL(AS_BYTES(arg)->len == 0);
     } else if (IS_DICT(arg)) {
         return BOOL_VAL(AS_DICT(arg)->count == 0);
     }
 
-    return raiseErr(vm, "is_empty? takes a string, list, or dict argument");
+    return raiseErr(
+        vm, "is_empty? takes a string, list, tuple, bytes or dict argument");
 }
 
 static Value pairNative(VM* vm, int argc, Value* argv) {
@@ -100,50 +188,152 @@ static Value dictNative(VM* vm, int argc, Value* argv) {
             pop(vm);
             return raiseErr(vm, "dict only accepts a list of pairs");
         }
-        ObjPair* pair = AS_PAIR(argv[i]);
-        uint64_t hash = hamtHash(pair->first);
-        bool is_new = hamtGet(dict->root, pair->first, hash, 0) == NULL;
-        dict->root =
-            hamtPut(vm, dict->root, pair->first, pair->second, hash, 0);
+        Value key = AS_PAIR(argv[i])->first;
+        uint64_t hash = hamtKeyHash(vm, key);
+        bool is_new = hamtGet(dict->root, key, hash, 0) == NULL;
+        if (is_new && !hamtFreezeKey(vm, &key)) {
+            pop(vm);
+            return raiseErr(vm, "dict: key is nested too deeply");
+        }
+        push(vm, key);
+        dict->root = hamtPut(vm, dict->root, key, AS_PAIR(argv[i])->second,
+                             dict->next_seq++, hash, 0);
+        pop(vm);
         if (is_new) dict->count++;
     }
     pop(vm);
     return OBJ_VAL(dict);
 }
 
-static Value getNative(VM* vm, int argc, Value* argv) {
-    (void)argc;
-    Value box = argv[0];
-    Value key = argv[1];
-
+Value getItem(VM* vm, Value box, Value key) {
     if (IS_DICT(box)) {
-        Value* val = hamtGet(AS_DICT(box)->root, key, hamtHash(key), 0);
+        Value* val = hamtGet(AS_DICT(box)->root, key, hamtKeyHash(vm, key), 0);
         return (val != NULL) ? *val : NIL_VAL;
     } else if (IS_LIST(box)) {
         if (!IS_INT(key)) {
             return raiseErr(vm, "list index must be an integer");
         }
-        int64_t ix = AS_INT(key);
         ObjList* list = AS_LIST(box);
+        int64_t ix = indexFromEnd(vm, AS_INT(key), list->len);
         if (ix < 0 || ix >= list->len) {
             return raiseErr(vm, "list index out of bounds");
         }
         Value curr = list->head;
         for (int i = 0; i < ix; i++) curr = AS_PAIR(curr)->second;
         return AS_PAIR(curr)->first;
+    } else if (IS_TUPLE(box)) {
+        if (!IS_INT(key)) {
+            return raiseErr(vm, "tuple index must be an integer");
+        }
+        ObjTuple* tuple = AS_TUPLE(box);
+        int64_t ix = indexFromEnd(vm, AS_INT(key), tuple->len);
+        if (ix < 0 || ix >= tuple->len) {
+            return raiseErr(vm, "tuple index out of bounds");
+        }
+        return tuple->items[ix];
     } else if (IS_STRING(box)) {
         if (!IS_INT(key)) {
             return raiseErr(vm, "string index must be an integer");
         }
-        int64_t ix = AS_INT(key);
         ObjString* str = AS_STRING(box);
+        int64_t ix = indexFromEnd(vm, AS_INT(key), str->length);
         if (ix < 0 || ix >= str->length) {
             return raiseErr(vm, "string index out of bounds");
         }
         return OBJ_VAL(copyString(vm, &str->chars[ix], 1));
     }
 
-    return raiseErr(vm, "get argument must be a dict, list or string");
+    return raiseErr(vm, "get argument must be a dict, list, tuple or string");
+}
+
+static Value getNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    return getItem(vm, argv[0], argv[1]);
+}
+
+// Returns #[value true] if get would find key in box, or #[null false] where
+// get would give null or an out of bounds error. Other errors are raised.
+static Value lookupNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    Value box = argv[0];
+    Value key = argv[1];
+    bool found = true;
+    if (IS_DICT(box)) {
+        found = hamtGet(AS_DICT(box)->root, key, hamtKeyHash(vm, key), 0) !=
+                NULL;
+    } else if (IS_INT(key) && (IS_LIST(box) || IS_TUPLE(box) ||
+                               IS_STRING(box))) {
+        int64_t length = IS_LIST(box)    ? (int64_t)AS_LIST(box)->len
+                         : IS_TUPLE(box) ? (int64_t)AS_TUPLE(box)->len
+                                         : (int64_t)AS_STRING(box)->length;
+        int64_t ix = indexFromEnd(vm, AS_INT(key), length);
+        found = ix >= 0 && ix < length;
+    }
+    Value value = found ? getItem(vm, box, key) : NIL_VAL;
+    if (vm->last_result != INTERPRET_OK) return NIL_VAL;
+    push(vm, value);
+    ObjTuple* result = newTuple(vm, 2);
+    result->items[0] = value;
+    result->items[1] = BOOL_VAL(found);
+    pop(vm);
+    return OBJ_VAL(result);
+}
+
+// Both indices are inclusive and count from the end when negative, as in get,
+// so (range xs 1 -1) drops the first item. An end before start gives an empty
+// slice.
+static Value rangeNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    Value box = argv[0];
+    int64_t length;
+    if (IS_LIST(box)) {
+        length = AS_LIST(box)->len;
+    } else if (IS_STRING(box)) {
+        length = AS_STRING(box)->length;
+    } else {
+        return raiseErr(vm, "range argument must be a list or string");
+    }
+    if (!IS_INT(argv[1]) || !IS_INT(argv[2])) {
+        return raiseErr(vm, "range indices must be integers");
+    }
+    int64_t start = indexFromEnd(vm, AS_INT(argv[1]), length);
+    int64_t end = indexFromEnd(vm, AS_INT(argv[2]), length);
+    // Strict mode rejects any negative index, as get does.
+    bool negative =
+        vm->options.strict && (AS_INT(argv[1]) < 0 || AS_INT(argv[2]) < 0);
+    if (negative || start < 0 || start > length || end < -1 ||
+        end >= length) {
+        return raiseErr(vm, "range index out of bounds");
+    }
+    int64_t count = end < start ? 0 : end - start + 1;
+
+    if (IS_STRING(box)) {
+        ObjString* str = AS_STRING(box);
+        return OBJ_VAL(copyString(vm, &str->chars[start], (int)count));
+    }
+    if (count == 0) return OBJ_VAL(newList(vm, 0, NIL_VAL));
+
+    Value cur = AS_LIST(box)->head;
+    for (int64_t i = 0; i < start; i++) cur = AS_PAIR(cur)->second;
+    // A slice that runs to the end shares the spine of the list.
+    if (end == length - 1) {
+        ObjList* result = newList(vm, count, cur);
+        AS_LIST(box)->shared = result->shared = true;
+        return OBJ_VAL(result);
+    }
+
+    // Build the copy front to back; its head stays rooted at stack_top[-1].
+    push(vm, OBJ_VAL(newPair(vm, AS_PAIR(cur)->first, NIL_VAL)));
+    ObjPair* tail = AS_PAIR(vm->stack_top[-1]);
+    for (int64_t i = 1; i < count; i++) {
+        cur = AS_PAIR(cur)->second;
+        ObjPair* next = newPair(vm, AS_PAIR(cur)->first, NIL_VAL);
+        tail->second = OBJ_VAL(next);
+        tail = next;
+    }
+    Value result = OBJ_VAL(newList(vm, count, vm->stack_top[-1]));
+    pop(vm);
+    return result;
 }
 
 static Value putNative(VM* vm, int argc, Value* argv) {
@@ -152,13 +342,20 @@ static Value putNative(VM* vm, int argc, Value* argv) {
         return raiseErr(vm, "put expects dict as the first argument");
     }
     ObjDict* old = AS_DICT(argv[0]);
-    uint64_t hash = hamtHash(argv[1]);
-    bool is_new = hamtGet(old->root, argv[1], hash, 0) == NULL;
-    HamtNode* new_root = hamtPut(vm, old->root, argv[1], argv[2], hash, 0);
-    push(vm, OBJ_VAL((Obj*)new_root));
+    Value key = argv[1];
+    uint64_t hash = hamtKeyHash(vm, key);
+    bool is_new = hamtGet(old->root, key, hash, 0) == NULL;
+    if (is_new && !hamtFreezeKey(vm, &key)) {
+        return raiseErr(vm, "put: key is nested too deeply");
+    }
+    push(vm, key);
+    HamtNode* new_root =
+        hamtPut(vm, old->root, key, argv[2], old->next_seq, hash, 0);
+    vm->stack_top[-1] = OBJ_VAL((Obj*)new_root);
     ObjDict* d = newDict(vm);
     d->root = (HamtNode*)AS_OBJ(pop(vm));
     d->count = old->count + (is_new ? 1 : 0);
+    d->next_seq = old->next_seq + 1;
 
     return OBJ_VAL(d);
 }
@@ -170,7 +367,8 @@ static Value hasNative(VM* vm, int argc, Value* argv) {
     }
 
     ObjDict* dict = AS_DICT(argv[0]);
-    return BOOL_VAL(hamtGet(dict->root, argv[1], hamtHash(argv[1]), 0) != NULL);
+    return BOOL_VAL(
+        hamtGet(dict->root, argv[1], hamtKeyHash(vm, argv[1]), 0) != NULL);
 }
 
 static Value delNative(VM* vm, int argc, Value* argv) {
@@ -179,26 +377,51 @@ static Value delNative(VM* vm, int argc, Value* argv) {
         return raiseErr(vm, "del expects a dict as the first argument");
     }
     ObjDict* old = AS_DICT(argv[0]);
-    uint64_t hash = hamtHash(argv[1]);
+    uint64_t hash = hamtKeyHash(vm, argv[1]);
     bool existed = hamtGet(old->root, argv[1], hash, 0) != NULL;
     HamtNode* new_root = hamtDel(vm, old->root, argv[1], hash, 0);
     push(vm, OBJ_VAL((Obj*)new_root));
     ObjDict* d = newDict(vm);
     d->root = (HamtNode*)AS_OBJ(pop(vm));
     d->count = old->count - (existed ? 1 : 0);
+    d->next_seq = old->next_seq;
     return OBJ_VAL(d);
 }
 
-static void keyCb(Value key, Value val, void* ctx) {
-    (void)val;
-    VM* vm = (VM*)ctx;
-    vm->stack_top[-1] = OBJ_VAL(newPair(vm, key, vm->stack_top[-1]));
+static Value keyItem(VM* vm, HamtEntry* entry) {
+    (void)vm;
+    return entry->key;
+}
+
+static Value valItem(VM* vm, HamtEntry* entry) {
+    (void)vm;
+    return entry->val;
 }
 
-static void valCb(Value key, Value val, void* ctx) {
-    (void)key;
-    VM* vm = (VM*)ctx;
-    vm->stack_top[-1] = OBJ_VAL(newPair(vm, val, vm->stack_top[-1]));
+static Value entryItem(VM* vm, HamtEntry* entry) {
+    push(vm, OBJ_VAL(newPair(vm, entry->val, NIL_VAL)));
+    vm->stack_top[-1] = OBJ_VAL(newPair(vm, entry->key, vm->stack_top[-1]));
+    Value item = OBJ_VAL(newList(vm, 2, vm->stack_top[-1]));
+    pop(vm);
+    return item;
+}
+
+// Lists one item per entry of dict, in the order the keys were first added.
+static Value orderedList(VM* vm, ObjDict* dict,
+                         Value (*item)(VM* vm, HamtEntry* entry)) {
+    HamtEntry* entries = hamtOrdered(dict->root, dict->count);
+    if (entries == NULL) return raiseErr(vm, "out of memory");
+    push(vm, NIL_VAL);
+    for (int i = (int)dict->count - 1; i >= 0; i--) {
+        push(vm, item(vm, &entries[i]));
+        Value pair = OBJ_VAL(newPair(vm, vm->stack_top[-1], vm->stack_top[-2]));
+        pop(vm);
+        vm->stack_top[-1] = pair;
+    }
+    free(entries);
+    Value result = OBJ_VAL(newList(vm, dict->count, vm->stack_top[-1]));
+    pop(vm);
+    return result;
 }
 
 static Value keysNative(VM* vm, int argc, Value* argv) {
@@ -206,12 +429,7 @@ static Value keysNative(VM* vm, int argc, Value* argv) {
     if (!IS_DICT(argv[0])) {
         return raiseErr(vm, "keys expects a dict as the first argument");
     }
-    ObjDict* dict = AS_DICT(argv[0]);
-    push(vm, NIL_VAL);
-    hamtEach(dict->root, keyCb, vm);
-    Value result = OBJ_VAL(newList(vm, dict->count, vm->stack_top[-1]));
-    pop(vm);
-    return result;
+    return orderedList(vm, AS_DICT(argv[0]), keyItem);
 }
 
 static Value valuesNative(VM* vm, int argc, Value* argv) {
@@ -219,12 +437,19 @@ static Value valuesNative(VM* vm, int argc, Value* argv) {
     if (!IS_DICT(argv[0])) {
         return raiseErr(vm, "values expects a dict as the first argument");
     }
-    ObjDict* dict = AS_DICT(argv[0]);
-    push(vm, NIL_VAL);
-    hamtEach(dict->root, valCb, vm);
-    Value result = OBJ_VAL(newList(vm, dict->count, vm->stack_top[-1]));
-    pop(vm);
-    return result;
+    return orderedList(vm, AS_DICT(argv[0]), valItem);
+}
+
+Value dictEntries(VM* vm, ObjDict* dict) {
+    return orderedList(vm, dict, entryItem);
+}
+
+static Value entriesNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_DICT(argv[0])) {
+        return raiseErr(vm, "entries expects a dict as the first argument");
+    }
+    return dictEntries(vm, AS_DICT(argv[0]));
 }
 
 static Value strNative(VM* vm, int argc, Value* argv) {
@@ -236,6 +461,150 @@ static Value strNative(VM* vm, int argc, Value* argv) {
     return result;
 }
 
+static Value sbNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    return OBJ_VAL(newBuilder(vm));
+}
+
+// Appends each value after the builder, a string as it is and anything else
+// as str prints it, and returns the builder.
+static Value sbAppendNative(VM* vm, int argc, Value* argv) {
+    if (argc < 1 || !IS_BUILDER(argv[0])) {
+        return raiseErr(vm, "sb_append! takes a builder and values to add");
+    }
+    ObjBuilder* builder = AS_BUILDER(argv[0]);
+    for (int i = 1; i < argc; i++) {
+        if (IS_STRING(argv[i])) {
+            ObjString* s = AS_STRING(argv[i]);
+            builderAppend(vm, builder, s->chars, s->length);
+        } else {
+            char* s = sprintValue(argv[i]);
+            builderAppend(vm, builder, s, (int)strlen(s));
+            free(s);
+        }
+    }
+    return argv[0];
+}
+
+static Value sbStrNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_BUILDER(argv[0])) return raiseErr(vm, "sb_str takes a builder");
+    return OBJ_VAL(builderString(vm, AS_BUILDER(argv[0])));
+}
+
+// Expands one directive of a format string into buf. The caller has already
+// checked that an argument is left for it.
+static bool formatDirective(VM* vm, const char* name, CharBuf* buf,
+                            char directive, int precision, Value arg) {
+    if (precision >= 0 && directive != 'f') {
+        RUNTIME_ERR(vm, "%s: a precision only applies to %%f", name);
+        return false;
+    }
+    switch (directive) {
+        case 'd': {
+            if (!IS_INT(arg)) {
+                RUNTIME_ERR(vm, "%s: %%d expects an int, got %s", name,
+                            valueTypeName(arg));
+                return false;
+            }
+            charBufAppendf(buf, "%" PRId64, AS_INT(arg));
+            return true;
+        }
+        case 'f':
+            if (!IS_INT(arg) && !IS_REAL(arg)) {
+                RUNTIME_ERR(vm, "%s: %%f expects a number, got %s", name,
+                            valueTypeName(arg));
+                return false;
+            }
+            charBufAppendf(buf, "%.*f", precision >= 0 ? precision : 6,
+                           IS_INT(arg) ? (double)AS_INT(arg) : AS_REAL(arg));
+            return true;
+        case 's':
+            if (!IS_STRING(arg)) {
+                RUNTIME_ERR(vm, "%s: %%s expects a string, got %s", name,
+                            valueTypeName(arg));
+                return false;
+            }
+            charBufAppend(buf, AS_CSTRING(arg), (size_t)AS_STRING(arg)->length);
+            return true;
+        case 'v':
+            if (IS_STRING(arg)) {
+                charBufAppend(buf, AS_CSTRING(arg),
+                              (size_t)AS_STRING(arg)->length);
+            } else {
+                char* str = sprintValue(arg);
+                charBufAppendStr(buf, str);
+                free(str);
+            }
+            return true;
+        default:
+            RUNTIME_ERR(vm, "%s: unknown directive '%%%c'", name, directive);
+            return false;
+    }
+}
+
+ObjString* formatValues(VM* vm, const char* name, int argc, Value* argv) {
+    if (argc < 1 || !IS_STRING(argv[0])) {
+        RUNTIME_ERR(vm, "%s: expected a format string", name);
+        return NULL;
+    }
+    ObjString* fmt = AS_STRING(argv[0]);
+    const char* p = fmt->chars;
+    const char* end = fmt->chars + fmt->length;
+    CharBuf buf = {0};
+    charBufAppend(&buf, "", 0);
+    int next_arg = 1;
+    while (p < end) {
+        const char* pct = memchr(p, '%', (size_t)(end - p));
+        if (pct == NULL) pct = end;
+        charBufAppend(&buf, p, (size_t)(pct - p));
+        if (pct == end) break;
+        p = pct + 1;
+        int precision = -1;
+        if (p < end && *p == '.') {
+            precision = 0;
+            for (p++; p < end && *p >= '0' && *p <= '9'; p++) {
+                if (precision < 100) precision = precision * 10 + (*p - '0');
+            }
+        }
+        if (p == end) {
+            RUNTIME_ERR(vm, "%s: incomplete directive at the end", name);
+            goto FAIL;
+        }
+        char directive = *p++;
+        if (directive == '%' && precision < 0) {
+            charBufAppend(&buf, "%", 1);
+            continue;
+        }
+        if (next_arg >= argc) {
+            RUNTIME_ERR(vm, "%s: not enough arguments for the format string",
+                        name);
+            goto FAIL;
+        }
+        if (!formatDirective(vm, name, &buf, directive, precision,
+                             argv[next_arg++])) {
+            goto FAIL;
+        }
+    }
+    if (next_arg < argc) {
+        RUNTIME_ERR(vm, "%s: too many arguments for the format string", name);
+        goto FAIL;
+    }
+    ObjString* result = copyString(vm, buf.chars, (int)buf.len);
+    free(buf.chars);
+    return result;
+
+FAIL:
+    free(buf.chars);
+    return NULL;
+}
+
+static Value formatNative(VM* vm, int argc, Value* argv) {
+    ObjString* result = formatValues(vm, "format", argc, argv);
+    return result != NULL ? OBJ_VAL(result) : NIL_VAL;
+}
+
 static Value toIntNative(VM* vm, int argc, Value* argv) {
     (void)argc;
     if (IS_INT(argv[0])) return argv[0];
@@ -250,29 +619,149 @@ static Value toRealNative(VM* vm, int argc, Value* argv) {
     return raiseErr(vm, "to_real: expected int or real");
 }
 
-static const char* valTypeName(Value v) {
-    switch (v.type) {
-        case VAL_INT:  return "int";
-        case VAL_REAL: return "real";
-        case VAL_BOOL: return "bool";
-        case VAL_NIL:  return "nil";
-        case VAL_OBJ:
-            switch (OBJ_TYPE(v)) {
-                case OBJ_STRING:   return "string";
-                case OBJ_LIST:     return "list";
-                case OBJ_PAIR:     return "pair";
-                case OBJ_DICT:     return "dict";
-                case OBJ_CLOSURE:
-                case OBJ_FUNCTION: return "fn";
-                case OBJ_NATIVE:   return "native-fn";
-                case OBJ_ERROR:    return "error";
-                case OBJ_RE:       return "re";
-                case OBJ_MODULE:   return "module";
-                case OBJ_FILE:     return "file";
-                default:           return "obj";
-            }
-        default: return "?";
+// The digits of n in base 1 << bits, after a minus if n is negative.
+static Value intInBase(VM* vm, int64_t n, int bits) {
+    char buf[65];  // A minus and 64 binary digits
+    int pos = sizeof(buf);
+    uint64_t u = n < 0 ? -(uint64_t)n : (uint64_t)n;
+    do {
+        buf[--pos] = "0123456789abcdef"[u & ((1u << bits) - 1)];
+        u >>= bits;
+    } while (u != 0);
+    if (n < 0) buf[--pos] = '-';
+    return OBJ_VAL(copyString(vm, buf + pos, (int)sizeof(buf) - pos));
+}
+
+static Value toHexNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0])) return raiseErr(vm, "to_hex: expected an int");
+    return intInBase(vm, AS_INT(argv[0]), 4);
+}
+
+static Value toBinNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0])) return raiseErr(vm, "to_bin: expected an int");
+    return intInBase(vm, AS_INT(argv[0]), 1);
+}
+
+static Value fmtFloatNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_NUMERIC(argv[0]) || !IS_INT(argv[1])) {
+        return raiseErr(vm, "fmt_float: expected a number and an int");
+    }
+    int64_t digits = AS_INT(argv[1]);
+    if (digits < 0 || digits > 99) {
+        return raiseErr(vm, "fmt_float: digits must be from 0 to 99");
+    }
+    double d = IS_INT(argv[0]) ? (double)AS_INT(argv[0]) : AS_REAL(argv[0]);
+    int n = snprintf(NULL, 0, "%.*f", (int)digits, d);
+    char* buf = malloc((size_t)n + 1);
+    snprintf(buf, (size_t)n + 1, "%.*f", (int)digits, d);
+    Value str = OBJ_VAL(copyString(vm, buf, n));
+    free(buf);
+    return str;
+}
+
+static Value tupleNative(VM* vm, int argc, Value* argv) {
+    // The arguments stay on the VM stack while the tuple is allocated.
+    ObjTuple* tuple = newTuple(vm, (uint32_t)argc);
+    memcpy(tuple->items, argv, sizeof(Value) * argc);
+    return OBJ_VAL(tuple);
+}
+
+static Value toTupleNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (IS_TUPLE(argv[0])) return argv[0];
+    if (!IS_LIST(argv[0])) {
+        return raiseErr(vm, "to_tuple: expected list or tuple");
+    }
+    ObjList* list = AS_LIST(argv[0]);
+    ObjTuple* tuple = newTuple(vm, list->len);
+    Value curr = list->head;
+    for (uint32_t i = 0; i < list->len; i++) {
+        tuple->items[i] = AS_PAIR(curr)->first;
+        curr = AS_PAIR(curr)->second;
     }
+    return OBJ_VAL(tuple);
+}
+
+static Value toListNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (IS_LIST(argv[0])) return argv[0];
+    if (!IS_TUPLE(argv[0])) {
+        return raiseErr(vm, "to_list: expected list or tuple");
+    }
+    ObjTuple* tuple = AS_TUPLE(argv[0]);
+    push(vm, NIL_VAL);  // head, built from the last item
+    for (uint32_t i = tuple->len; i > 0; i--) {
+        ObjPair* pair = newPair(vm, tuple->items[i - 1], vm->stack_top[-1]);
+        vm->stack_top[-1] = OBJ_VAL(pair);
+    }
+    Value result = OBJ_VAL(newList(vm, tuple->len, vm->stack_top[-1]));
+    pop(vm);
+    return result;
+}
+
+// Bytes of a string as they are, or of a list of ints from 0 to 255.
+static Value bytesNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    Value arg = argv[0];
+    if (IS_BYTES(arg)) return arg;
+    if (IS_STRING(arg)) {
+        ObjString* str = AS_STRING(arg);
+        return OBJ_VAL(
+            newBytes(vm, (const uint8_t*)str->chars, (uint32_t)str->length));
+    }
+    if (!IS_LIST(arg)) {
+        return raiseErr(vm, "bytes: expected string, list of ints or bytes");
+    }
+    ObjList* list = AS_LIST(arg);
+    uint8_t* data = malloc(list->len > 0 ? list->len : 1);
+    Value curr = list->head;
+    for (uint32_t i = 0; i < list->len; i++) {
+        Value item = AS_PAIR(curr)->first;
+        if (!IS_INT(item) || AS_INT(item) < 0 || AS_INT(item) > 255) {
+            free(data);
+            return raiseErr(vm, "bytes: list items must be ints from 0 to 255");
+        }
+        data[i] = (uint8_t)AS_INT(item);
+        curr = AS_PAIR(curr)->second;
+    }
+    ObjBytes* bytes = newBytes(vm, data, list->len);
+    free(data);
+    return OBJ_VAL(bytes);
+}
+
+static Value byteAtNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_BYTES(argv[0])) return raiseErr(vm, "byte_at: expected bytes");
+    if (!IS_INT(argv[1])) {
+        return raiseErr(vm, "byte_at: index must be an integer");
+    }
+    ObjBytes* bytes = AS_BYTES(argv[0]);
+    int64_t ix = indexFromEnd(vm, AS_INT(argv[1]), bytes->len);
+    if (ix < 0 || ix >= bytes->len) {
+        return raiseErr(vm, "byte_at: index out of bounds");
+    }
+    return INT_VAL(bytes->data[ix]);
+}
+
+static Value toStrNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (IS_STRING(argv[0])) return argv[0];
+    if (!IS_BYTES(argv[0])) return raiseErr(vm, "to_str: expected bytes");
+    ObjBytes* bytes = AS_BYTES(argv[0]);
+    return OBJ_VAL(
+        copyString(vm, (const char*)bytes->data, (int)bytes->len));
+}
+
+static Value fromHexNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "from_hex: expected string");
+    ObjBytes* bytes =
+        bytesFromHex(vm, AS_CSTRING(argv[0]), AS_STRING(argv[0])->length);
+    if (bytes == NULL) return raiseErr(vm, "from_hex: invalid hex string");
+    return OBJ_VAL(bytes);
 }
 
 static Value inspectNative(VM* vm, int argc, Value* argv) {
@@ -282,7 +771,7 @@ static Value inspectNative(VM* vm, int argc, Value* argv) {
     if (IS_NIL(v))
         return OBJ_VAL(copyString(vm, "nil", 3));
 
-    const char* type = valTypeName(v);
+    const char* type = valueTypeName(v);
     char* buf;
     int len;
 
@@ -318,20 +807,237 @@ static Value inspectNative(VM* vm, int argc, Value* argv) {
     return result;
 }
 
+static Value reprNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    char* s = reprValue(argv[0]);
+    if (s == NULL) {
+        // Like parse_repr, a value that cannot be read back gives an err.
+        char buf[128];
+        snprintf(buf, sizeof(buf), "repr: a %s value has no repr",
+                 valueTypeName(argv[0]));
+        return OBJ_VAL(newError(vm, buf));
+    }
+    Value result = OBJ_VAL(copyString(vm, s, strlen(s)));
+    free(s);
+    return result;
+}
+
+static Value parseReprNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) {
+        return raiseErr(vm, "parse_repr expects a string");
+    }
+    Value result;
+    const char* err_msg;
+    if (!parseRepr(vm, AS_CSTRING(argv[0]), &result, &err_msg)) {
+        char buf[128];
+        snprintf(buf, sizeof(buf), "parse_repr: %s", err_msg);
+        return OBJ_VAL(newError(vm, buf));
+    }
+    return result;
+}
+
+// Returns the docstring of a function or the description of a native, or
+// null if it has none.
+static Value docNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    const char* usage;
+    const char* doc;
+    if (!functionDocs(argv[0], &usage, &doc)) {
+        return raiseErr(vm, "doc expects a function");
+    }
+    return doc ? OBJ_VAL(copyString(vm, doc, strlen(doc))) : NIL_VAL;
+}
+
+void putField(VM* vm, const char* key, Value value) {
+    push(vm, value);
+    Value key_val = OBJ_VAL(copyString(vm, key, strlen(key)));
+    push(vm, key_val);
+    ObjDict* dict = AS_DICT(vm->stack_top[-3]);
+    dict->root = hamtPut(vm, dict->root, key_val, value, dict->next_seq++,
+                         hamtKeyHash(vm, key_val), 0);
+    dict->count++;
+    pop(vm);
+    pop(vm);
+}
+
+// Lists the live OS-backed objects, oldest first, as dicts with the id, kind
+// and name of each. Useful to track down handles that are never closed.
+static Value resourcesNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    push(vm, NIL_VAL);
+    int len = 0;
+    for (Resource* res = vm->resources; res != NULL; res = res->next) {
+        push(vm, OBJ_VAL(newDict(vm)));
+        putField(vm, "id", INT_VAL(res->id));
+        putField(vm, "kind",
+                 OBJ_VAL(copyString(vm, res->kind, strlen(res->kind))));
+        putField(vm, "name",
+                 OBJ_VAL(copyString(vm, res->name, strlen(res->name))));
+        Value pair =
+            OBJ_VAL(newPair(vm, vm->stack_top[-1], vm->stack_top[-2]));
+        pop(vm);
+        vm->stack_top[-1] = pair;
+        len++;
+    }
+    Value result = OBJ_VAL(newList(vm, len, vm->stack_top[-1]));
+    pop(vm);
+    return result;
+}
+
+static Value timeNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    return REAL_VAL((double)clockMs(vm) / 1000.0);
+}
+
+static Value timeMsNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    return INT_VAL(clockMs(vm));
+}
+
+// Runs the file of an imported Liss module again, see reloadModule.
+static Value moduleReloadNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!reloadModule(vm, AS_STRING(argv[0]))) return NIL_VAL;
+    return BOOL_VAL(true);
+}
+
 static const NativeReg core_functions[] = {
-    {"err", 1, errNative},      {"is_err?", 1, isErrNative},
-    {"raise!", 1, raiseNative}, {"noerr!", 1, noErrNative},
-    {"len", 1, lenNative},      {"is_empty?", 1, isEmptyNative},
-    {"pair", 2, pairNative},    {"fst", 1, fstNative},
-    {"snd", 1, sndNative},      {"dict", -1, dictNative},
-    {"get", 2, getNative},      {"put", 3, putNative},
-    {"has?", 2, hasNative},     {"del", 2, delNative},
-    {"keys", 1, keysNative},    {"values", 1, valuesNative},
-    {"str", 1, strNative},      {"to_int", 1, toIntNative},
-    {"to_real", 1, toRealNative}, {"inspect", 1, inspectNative},
-    {NULL, 0, NULL},  // Sentinel value
+    {"err", 1, errNative, NULL},
+    {"is_err?", 1, isErrNative, NULL},
+    {"err_trace", 1, errTraceNative, NULL},
+    {"raise!", 1, raiseNative, NULL},
+    {"noerr!", 1, noErrNative, NULL},
+    {"assert", -1, assertNative, NULL},
+    {"assert_eq", -1, assertEqNative, NULL},
+    {"len", 1, lenNative, "c"},
+    {"rune_len", 1, runeLenNative, "s"},
+    {"byte_len", 1, byteLenNative, NULL},
+    {"is_empty?", 1, isEmptyNative, "c"},
+    {"pair", 2, pairNative, NULL},
+    {"fst", 1, fstNative, NULL},
+    {"snd", 1, sndNative, NULL},
+    {"dict", -1, dictNative, NULL},
+    {"get", 2, getNative, NULL},
+    {"lookup", 2, lookupNative, NULL},
+    {"range", 3, rangeNative, ".ii"},
+    {"put", 3, putNative, "d.."},
+    {"has?", 2, hasNative, "d."},
+    {"del", 2, delNative, "d."},
+    {"keys", 1, keysNative, "d"},
+    {"values", 1, valuesNative, "d"},
+    {"entries", 1, entriesNative, "d"},
+    {"str", 1, strNative, NULL},
+    {"sb", 0, sbNative, NULL},
+    {"sb_append!", -1, sbAppendNative, NULL},
+    {"sb_str", 1, sbStrNative, NULL},
+    {"format", -1, formatNative, "s"},
+    {"to_int", 1, toIntNative, "n"},
+    {"to_real", 1, toRealNative, "n"},
+    {"to_hex", 1, toHexNative, "i"},
+    {"to_bin", 1, toBinNative, "i"},
+    {"fmt_float", 2, fmtFloatNative, "ni"},
+    {"tuple", -1, tupleNative, NULL},
+    {"to_tuple", 1, toTupleNative, NULL},
+    {"to_list", 1, toListNative, NULL},
+    {"bytes", 1, bytesNative, NULL},
+    {"byte_at", 2, byteAtNative, ".i"},
+    {"to_str", 1, toStrNative, NULL},
+    {"from_hex", 1, fromHexNative, "s"},
+    {"inspect", 1, inspectNative, NULL},
+    {"repr", 1, reprNative, NULL},
+    {"parse_repr", 1, parseReprNative, "s"},
+    {"doc", 1, docNative, NULL},
+    {NULL, 0, NULL, NULL},  // Sentinel value
+};
+
+// Natives that observe the host, left out of the sandbox.
+static const NativeReg core_host_functions[] = {
+    {"resources", 0, resourcesNative, NULL},
+    {"time", 0, timeNative, NULL},
+    {"time_ms", 0, timeMsNative, NULL},
+    {"module_reload", 1, moduleReloadNative, "s"},
+    {NULL, 0, NULL, NULL},
+};
+
+static const DocReg core_docs[] = {
+    {"err", "(err message)", "Creates an error value."},
+    {"is_err?", "(is_err? x)", "Tells whether x is an error."},
+    {"err_trace", "(err_trace e)",
+     "The innermost frames e was raised in, one \"  at module:fn [line n]\" "
+     "per line, if the VM raised it on a stack overflow. Null otherwise."},
+    {"raise!", "(raise! e)", "Raises e, unwinding to the nearest try."},
+    {"noerr!", "(noerr! x)", "Returns x, raising it if it is an error."},
+    {"assert", "(assert x [message])",
+     "Raises an error unless x is truthy."},
+    {"assert_eq", "(assert_eq a b [message])",
+     "Raises an error showing both values unless a and b are equal, "
+     "comparing lists, pairs and tuples item by item."},
+    {"len", "(len coll)",
+     "Number of items in a string, list, tuple, bytes or dict."},
+    {"rune_len", "(rune_len s)",
+     "Number of characters of a string, where len counts its bytes."},
+    {"byte_len", "(byte_len s)", "Number of bytes of a string or bytes."},
+    {"is_empty?", "(is_empty? coll)", "Tells whether coll has no items."},
+    {"pair", "(pair a b)", "Creates the pair (a . b)."},
+    {"fst", "(fst p)", "First element of a pair."},
+    {"snd", "(snd p)", "Second element of a pair."},
+    {"dict", "(dict (k . v) ...)", "Creates a dict from pairs."},
+    {"get", "(get coll key)",
+     "Item at an index of a list or string, or value of a dict key."},
+    {"lookup", "(lookup coll key)",
+     "#[item true] if get finds key in coll, #[null false] if not, so a "
+     "null value is told apart from a missing key: (let [v ok] (lookup d k))."},
+    {"range", "(range coll start end)",
+     "Items of a list or string from index start through end."},
+    {"put", "(put d key value)", "Copy of d with key set to value."},
+    {"has?", "(has? d key)", "Tells whether d contains key."},
+    {"del", "(del d key)", "Copy of d without key."},
+    {"keys", "(keys d)", "List of the keys of d in insertion order."},
+    {"values", "(values d)", "List of the values of d in insertion order."},
+    {"entries", "(entries d)",
+     "List of the [key value] entries of d in insertion order."},
+    {"str", "(str x)", "Converts x to a string."},
+    {"sb", "(sb)", "Creates an empty string builder."},
+    {"sb_append!", "(sb_append! b x ...)",
+     "Adds each x to the end of builder b, a string as it is and anything "
+     "else as str prints it, and returns b."},
+    {"sb_str", "(sb_str b)", "The text built up in b so far as a string."},
+    {"format", "(format fmt x ...)",
+     "Fills %d, %f, %.Nf, %s, %v and %% in fmt with the arguments."},
+    {"to_int", "(to_int n)", "Converts a number to an int."},
+    {"to_real", "(to_real n)", "Converts a number to a real."},
+    {"to_hex", "(to_hex n)",
+     "Lower case hex digits of an int, as in \"ff\", after a minus if n < 0."},
+    {"to_bin", "(to_bin n)",
+     "Binary digits of an int, after a minus if n < 0."},
+    {"fmt_float", "(fmt_float x digits)",
+     "A number with digits digits after the point, rounded."},
+    {"tuple", "(tuple x ...)", "Creates a tuple of the arguments."},
+    {"to_tuple", "(to_tuple xs)", "Tuple with the items of a list."},
+    {"to_list", "(to_list t)", "New list with the items of a tuple."},
+    {"bytes", "(bytes x)", "Bytes of a string or of a list of ints."},
+    {"byte_at", "(byte_at b i)", "The byte at index i of b as an int."},
+    {"to_str", "(to_str b)", "String with the bytes of b."},
+    {"from_hex", "(from_hex s)",
+     "Bytes written as hex digits, as in \"ff00\"."},
+    {"inspect", "(inspect x)", "Type and value of x as a string."},
+    {"repr", "(repr x)", "Machine-readable text of x, see parse_repr."},
+    {"parse_repr", "(parse_repr s)", "Parses text produced by repr."},
+    {"doc", "(doc f)", "Docstring of a function, or null."},
+    {"resources", "(resources)", "Live OS handles such as open files."},
+    {"time", "(time)", "Seconds since the Unix epoch as a real."},
+    {"time_ms", "(time_ms)", "Milliseconds since the Unix epoch."},
+    {"module_reload", "(module_reload name)",
+     "Runs the file of the imported module name again in place."},
+    {NULL, NULL, NULL},  // Sentinel value
 };
 
 void registerCoreNatives(VM* vm, ObjModule* module) {
     defineNatives(vm, module, core_functions);
+    if (!vm->options.sandbox) defineNatives(vm, module, core_host_functions);
+    defineDocs(vm, module, core_docs);
 }
@@ -6,4 +6,22 @@
 
 void registerCoreNatives(VM* vm, ObjModule* module);
 
+// Value of a dict key, or the item at an index of a list, tuple or string, as
+// returned by get. Raises an error and returns nil for a missing index.
+Value getItem(VM* vm, Value box, Value key);
+
+// Lists the entries of dict as [key value] lists, in the order the keys were
+// first added, as returned by entries.
+Value dictEntries(VM* vm, ObjDict* dict);
+
+// Fills the directives of the format string argv[0] with the arguments that
+// follow it: %d takes an int, %f (or %.2f) a number, %s a string, %v any
+// value, and %% is a literal percent. Raises an error prefixed with name and
+// returns NULL when the arguments don't match the directives.
+ObjString* formatValues(VM* vm, const char* name, int argc, Value* argv);
+
+// Adds key, a C string, with value to the dict on top of the stack, as the
+// natives that describe something as a dict of fields do.
+void putField(VM* vm, const char* key, Value value);
+
 #endif
@@ -0,0 +1,511 @@
+#define _POSIX_C_SOURCE 200809L
+
+#include "datetime.h"
+
+#include <stdlib.h>
+#include <string.h>
+#include <strings.h>
+#include <time.h>
+
+#include "core.h"
+#include "object.h"
+#include "value.h"
+#include "vm.h"
+
+#define MS_PER_DAY 86400000LL
+
+// A timestamp broken down into its fields, in UTC.
+typedef struct {
+    int64_t year;
+    int month;  // 1 to 12
+    int day;    // 1 to 31
+    int hour;
+    int minute;
+    int second;
+    int ms;
+    int weekday;  // 0 is Sunday
+    int yday;     // 1 is January 1
+} Civil;
+
+static const char* month_names[] = {
+    "January", "February", "March",     "April",   "May",      "June",
+    "July",    "August",   "September", "October", "November", "December",
+};
+
+static const char* day_names[] = {
+    "Sunday",   "Monday", "Tuesday",  "Wednesday",
+    "Thursday", "Friday", "Saturday",
+};
+
+// Units of time:add and time:diff in milliseconds.
+static const struct {
+    const char* name;
+    int64_t ms;
+} units[] = {
+    {"ms", 1},       {"s", 1000},        {"m", 60000},
+    {"h", 3600000},  {"d", MS_PER_DAY},  {"w", 7 * MS_PER_DAY},
+};
+
+// Days from 1970-01-01 to the date, in the proleptic Gregorian calendar.
+// See Howard Hinnant's "chrono-Compatible Low-Level Date Algorithms".
+static int64_t daysFromCivil(int64_t y, int m, int d) {
+    y -= m <= 2;
+    int64_t era = (y >= 0 ? y : y - 399) / 400;
+    int64_t yoe = y - era * 400;
+    int64_t doy = (153 * (m > 2 ? m - 3 : m + 9) + 2) / 5 + d - 1;
+    int64_t doe = yoe * 365 + yoe / 4 - yoe / 100 + doy;
+    return era * 146097 + doe - 719468;
+}
+
+static bool isLeapYear(int64_t y) {
+    return (y % 4 == 0 && y % 100 != 0) || y % 400 == 0;
+}
+
+static int daysInMonth(int64_t y, int m) {
+    static const int days[] = {31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31};
+    return m == 2 && isLeapYear(y) ? 29 : days[m - 1];
+}
+
+static Civil toCivil(int64_t ts) {
+    int64_t days = ts / MS_PER_DAY;
+    int64_t rem = ts % MS_PER_DAY;
+    if (rem < 0) {
+        days--;
+        rem += MS_PER_DAY;
+    }
+    Civil c = {0};
+    c.ms = (int)(rem % 1000);
+    c.second = (int)(rem / 1000 % 60);
+    c.minute = (int)(rem / 60000 % 60);
+    c.hour = (int)(rem / 3600000);
+    // 1970-01-01 was a Thursday.
+    c.weekday = (int)(((days + 4) % 7 + 7) % 7);
+
+    int64_t z = days + 719468;
+    int64_t era = (z >= 0 ? z : z - 146096) / 146097;
+    int64_t doe = z - era * 146097;
+    int64_t yoe = (doe - doe / 1460 + doe / 36524 - doe / 146096) / 365;
+    int64_t doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    int64_t mp = (5 * doy + 2) / 153;
+    c.day = (int)(doy - (153 * mp + 2) / 5 + 1);
+    c.month = (int)(mp < 10 ? mp + 3 : mp - 9);
+    c.year = yoe + era * 400 + (c.month <= 2);
+    c.yday = (int)(days - daysFromCivil(c.year, 1, 1)) + 1;
+    return c;
+}
+
+// The layout elements, after Go's reference time Mon Jan 2 15:04:05 2006.
+// Longer elements come first, so "January" is not read as "Jan" and "uary".
+typedef enum {
+    EL_LONG_MONTH,
+    EL_LONG_DAY,
+    EL_YEAR,
+    EL_NUM_ZONE,
+    EL_ISO_ZONE,
+    EL_MILLIS,
+    EL_MONTH_NAME,
+    EL_DAY_NAME,
+    EL_SPACE_DAY,
+    EL_ZERO_MONTH,
+    EL_ZERO_DAY,
+    EL_ZERO_HOUR12,
+    EL_ZERO_MINUTE,
+    EL_ZERO_SECOND,
+    EL_YEAR2,
+    EL_HOUR,
+    EL_PM,
+    EL_LOWER_PM,
+    EL_MONTH,
+    EL_DAY,
+    EL_HOUR12,
+    EL_MINUTE,
+    EL_SECOND,
+    EL_NONE,
+} Element;
+
+static const char* elements[] = {
+    "January", "Monday", "2006", "-0700", "Z07:00", ".000", "Jan", "Mon",
+    "_2",      "01",     "02",   "03",    "04",     "05",   "06",  "15",
+    "PM",      "pm",     "1",    "2",     "3",      "4",    "5",
+};
+
+// Returns the element the layout starts with, or EL_NONE, and its length.
+static Element nextElement(const char* layout, int* len) {
+    for (int i = 0; i < EL_NONE; i++) {
+        *len = (int)strlen(elements[i]);
+        if (strncmp(layout, elements[i], *len) == 0) return (Element)i;
+    }
+    *len = 1;
+    return EL_NONE;
+}
+
+static void formatElement(CharBuf* buf, Element el, const Civil* c) {
+    int hour12 = c->hour % 12 == 0 ? 12 : c->hour % 12;
+    switch (el) {
+        case EL_LONG_MONTH:
+            charBufAppendStr(buf, month_names[c->month - 1]);
+            break;
+        case EL_LONG_DAY:
+            charBufAppendStr(buf, day_names[c->weekday]);
+            break;
+        case EL_YEAR:
+            charBufAppendf(buf, "%04lld", (long long)c->year);
+            break;
+        case EL_NUM_ZONE:
+            charBufAppend(buf, "+0000", 5);
+            break;
+        case EL_ISO_ZONE:
+            charBufAppend(buf, "Z", 1);
+            break;
+        case EL_MILLIS:
+            charBufAppendf(buf, ".%03d", c->ms);
+            break;
+        case EL_MONTH_NAME:
+            charBufAppend(buf, month_names[c->month - 1], 3);
+            break;
+        case EL_DAY_NAME:
+            charBufAppend(buf, day_names[c->weekday], 3);
+            break;
+        case EL_SPACE_DAY:
+            charBufAppendf(buf, "%2d", c->day);
+            break;
+        case EL_ZERO_MONTH:
+            charBufAppendf(buf, "%02d", c->month);
+            break;
+        case EL_ZERO_DAY:
+            charBufAppendf(buf, "%02d", c->day);
+            break;
+        case EL_ZERO_HOUR12:
+            charBufAppendf(buf, "%02d", hour12);
+            break;
+        case EL_ZERO_MINUTE:
+            charBufAppendf(buf, "%02d", c->minute);
+            break;
+        case EL_ZERO_SECOND:
+            charBufAppendf(buf, "%02d", c->second);
+            break;
+        case EL_YEAR2:
+            charBufAppendf(buf, "%02d", (int)((c->year % 100 + 100) % 100));
+            break;
+        case EL_HOUR:
+            charBufAppendf(buf, "%02d", c->hour);
+            break;
+        case EL_PM:
+            charBufAppend(buf, c->hour >= 12 ? "PM" : "AM", 2);
+            break;
+        case EL_LOWER_PM:
+            charBufAppend(buf, c->hour >= 12 ? "pm" : "am", 2);
+            break;
+        case EL_MONTH:
+            charBufAppendf(buf, "%d", c->month);
+            break;
+        case EL_DAY:
+            charBufAppendf(buf, "%d", c->day);
+            break;
+        case EL_HOUR12:
+            charBufAppendf(buf, "%d", hour12);
+            break;
+        case EL_MINUTE:
+            charBufAppendf(buf, "%d", c->minute);
+            break;
+        case EL_SECOND:
+            charBufAppendf(buf, "%d", c->second);
+            break;
+        case EL_NONE:
+            break;
+    }
+}
+
+// Reads between min and max digits at *s into *n and moves *s past them.
+static bool readDigits(const char** s, int min, int max, int* n) {
+    int count = 0;
+    *n = 0;
+    while (count < max && **s >= '0' && **s <= '9') {
+        *n = *n * 10 + (**s - '0');
+        (*s)++;
+        count++;
+    }
+    return count >= min;
+}
+
+// Reads one of names, whole or by its first three letters, in any case.
+static bool readName(const char** s, const char** names, int count, bool whole,
+                     int* index) {
+    for (int i = 0; i < count; i++) {
+        int len = whole ? (int)strlen(names[i]) : 3;
+        if (strncasecmp(*s, names[i], len) == 0) {
+            *s += len;
+            *index = i;
+            return true;
+        }
+    }
+    return false;
+}
+
+// Reads a zone offset as +hhmm, or as +hh:mm or Z when iso, into *offset in
+// milliseconds east of UTC.
+static bool readZone(const char** s, bool iso, int64_t* offset) {
+    if (iso && **s == 'Z') {
+        (*s)++;
+        *offset = 0;
+        return true;
+    }
+    if (**s != '+' && **s != '-') return false;
+    int sign = **s == '-' ? -1 : 1;
+    (*s)++;
+    int hours;
+    int minutes;
+    if (!readDigits(s, 2, 2, &hours)) return false;
+    if (iso && *(*s)++ != ':') return false;
+    if (!readDigits(s, 2, 2, &minutes) || hours > 23 || minutes > 59) {
+        return false;
+    }
+    *offset = sign * (hours * 3600000LL + minutes * 60000LL);
+    return true;
+}
+
+// Parses text laid out as layout into *ts. Returns NULL on success, or why
+// the text does not match.
+static const char* parseTime(const char* text, const char* layout,
+                             int64_t* ts) {
+    int year = 1970;
+    int month = 1;
+    int day = 1;
+    int hour = 0;
+    int minute = 0;
+    int second = 0;
+    int ms = 0;
+    int pm = -1;  // 1 for PM, 0 for AM, -1 if the layout has neither
+    int64_t offset = 0;
+    const char* s = text;
+    int ignored;
+    for (const char* l = layout; *l != '\0';) {
+        int len;
+        Element el = nextElement(l, &len);
+        bool ok = true;
+        switch (el) {
+            case EL_LONG_MONTH:
+                ok = readName(&s, month_names, 12, true, &month);
+                month++;
+                break;
+            case EL_LONG_DAY:
+                ok = readName(&s, day_names, 7, true, &ignored);
+                break;
+            case EL_YEAR:
+                ok = readDigits(&s, 4, 4, &year);
+                break;
+            case EL_NUM_ZONE:
+            case EL_ISO_ZONE:
+                ok = readZone(&s, el == EL_ISO_ZONE, &offset);
+                break;
+            case EL_MILLIS:
+                ok = *s == '.';
+                if (ok) s++;
+                ok = ok && readDigits(&s, 3, 3, &ms);
+                break;
+            case EL_MONTH_NAME:
+                ok = readName(&s, month_names, 12, false, &month);
+                month++;
+                break;
+            case EL_DAY_NAME:
+                ok = readName(&s, day_names, 7, false, &ignored);
+                break;
+            case EL_SPACE_DAY:
+                if (*s == ' ') s++;
+                ok = readDigits(&s, 1, 2, &day);
+                break;
+            case EL_ZERO_MONTH:
+            case EL_MONTH:
+                ok = readDigits(&s, el == EL_MONTH ? 1 : 2, 2, &month);
+                break;
+            case EL_ZERO_DAY:
+            case EL_DAY:
+                ok = readDigits(&s, el == EL_DAY ? 1 : 2, 2, &day);
+                break;
+            case EL_ZERO_HOUR12:
+            case EL_HOUR12:
+                ok = readDigits(&s, el == EL_HOUR12 ? 1 : 2, 2, &hour) &&
+                     hour >= 1 && hour <= 12;
+                break;
+            case EL_ZERO_MINUTE:
+            case EL_MINUTE:
+                ok = readDigits(&s, el == EL_MINUTE ? 1 : 2, 2, &minute);
+                break;
+            case EL_ZERO_SECOND:
+            case EL_SECOND:
+                ok = readDigits(&s, el == EL_SECOND ? 1 : 2, 2, &second);
+                break;
+            case EL_YEAR2:
+                // As in Go, 69 to 99 are 1969 to 1999, the rest 2000 on.
+                ok = readDigits(&s, 2, 2, &year);
+                year += year >= 69 ? 1900 : 2000;
+                break;
+            case EL_HOUR:
+                ok = readDigits(&s, 2, 2, &hour);
+                break;
+            case EL_PM:
+            case EL_LOWER_PM:
+                if (strncasecmp(s, "AM", 2) == 0 ||
+                    strncasecmp(s, "PM", 2) == 0) {
+                    pm = (s[0] == 'P' || s[0] == 'p');
+                    s += 2;
+                } else {
+                    ok = false;
+                }
+                break;
+            case EL_NONE:
+                ok = *s++ == *l;
+                break;
+        }
+        if (!ok) return "text does not match the layout";
+        l += len;
+    }
+    if (*s != '\0') return "extra text after the layout";
+
+    if (pm == 1 && hour < 12) hour += 12;
+    if (pm == 0 && hour == 12) hour = 0;
+    if (month < 1 || month > 12 || day < 1 ||
+        day > daysInMonth(year, month) || hour > 23 || minute > 59 ||
+        second > 59) {
+        return "field out of range";
+    }
+    *ts = daysFromCivil(year, month, day) * MS_PER_DAY + hour * 3600000LL +
+          minute * 60000LL + second * 1000LL + ms - offset;
+    return NULL;
+}
+
+// Returns the milliseconds in a unit, or 0 if unit names none.
+static int64_t unitMs(ObjString* unit) {
+    for (size_t i = 0; i < sizeof(units) / sizeof(units[0]); i++) {
+        if (strcmp(unit->chars, units[i].name) == 0) return units[i].ms;
+    }
+    return 0;
+}
+
+static Value nowNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    return INT_VAL(clockMs(vm));
+}
+
+static Value sleepNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0]) || AS_INT(argv[0]) < 0) {
+        return raiseErr(vm, "time:sleep takes a non-negative int of ms");
+    }
+    // The virtual clock only moves with the instructions run.
+    if (vm->options.deterministic) return NIL_VAL;
+    int64_t ms = AS_INT(argv[0]);
+    struct timespec ts = {.tv_sec = ms / 1000, .tv_nsec = ms % 1000 * 1000000};
+    nanosleep(&ts, NULL);
+    return NIL_VAL;
+}
+
+static Value formatNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "time:format takes a timestamp and a layout");
+    }
+    Civil c = toCivil(AS_INT(argv[0]));
+    CharBuf buf = {0};
+    charBufAppend(&buf, "", 0);
+    for (const char* l = AS_CSTRING(argv[1]); *l != '\0';) {
+        int len;
+        Element el = nextElement(l, &len);
+        if (el == EL_NONE) {
+            charBufAppend(&buf, l, 1);
+        } else {
+            formatElement(&buf, el, &c);
+        }
+        l += len;
+    }
+    return OBJ_VAL(takeString(vm, buf.chars, (int)buf.len));
+}
+
+static Value parseNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "time:parse takes a string and a layout");
+    }
+    int64_t ts;
+    const char* reason = parseTime(AS_CSTRING(argv[0]), AS_CSTRING(argv[1]),
+                                   &ts);
+    if (reason != NULL) {
+        char msg[96];
+        snprintf(msg, sizeof(msg), "time:parse: %s", reason);
+        return OBJ_VAL(newError(vm, msg));
+    }
+    return INT_VAL(ts);
+}
+
+static Value addNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0]) || !IS_INT(argv[1]) || !IS_STRING(argv[2])) {
+        return raiseErr(vm, "time:add takes a timestamp, an int and a unit");
+    }
+    int64_t unit = unitMs(AS_STRING(argv[2]));
+    if (unit == 0) {
+        return raiseErr(vm, "time:add: unit must be ms, s, m, h, d or w");
+    }
+    int64_t delta;
+    int64_t ts;
+    if (__builtin_mul_overflow(AS_INT(argv[1]), unit, &delta) ||
+        __builtin_add_overflow(AS_INT(argv[0]), delta, &ts)) {
+        return raiseErr(vm, "time:add: timestamp out of range");
+    }
+    return INT_VAL(ts);
+}
+
+static Value diffNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0]) || !IS_INT(argv[1]) || !IS_STRING(argv[2])) {
+        return raiseErr(vm, "time:diff takes two timestamps and a unit");
+    }
+    int64_t unit = unitMs(AS_STRING(argv[2]));
+    if (unit == 0) {
+        return raiseErr(vm, "time:diff: unit must be ms, s, m, h, d or w");
+    }
+    int64_t ms;
+    if (__builtin_sub_overflow(AS_INT(argv[0]), AS_INT(argv[1]), &ms)) {
+        return raiseErr(vm, "time:diff: difference out of range");
+    }
+    return INT_VAL(ms / unit);
+}
+
+static Value partsNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0])) return raiseErr(vm, "time:parts takes a timestamp");
+    Civil c = toCivil(AS_INT(argv[0]));
+    push(vm, OBJ_VAL(newDict(vm)));
+    putField(vm, "year", INT_VAL(c.year));
+    putField(vm, "month", INT_VAL(c.month));
+    putField(vm, "day", INT_VAL(c.day));
+    putField(vm, "hour", INT_VAL(c.hour));
+    putField(vm, "minute", INT_VAL(c.minute));
+    putField(vm, "second", INT_VAL(c.second));
+    putField(vm, "ms", INT_VAL(c.ms));
+    putField(vm, "weekday", INT_VAL(c.weekday));
+    putField(vm, "yday", INT_VAL(c.yday));
+    return pop(vm);
+}
+
+static const NativeReg time_functions[] = {
+    {"format", 2, formatNative, "is"},
+    {"parse", 2, parseNative, "ss"},
+    {"add", 3, addNative, "iis"},
+    {"diff", 3, diffNative, "iis"},
+    {"parts", 1, partsNative, "i"},
+    {NULL, 0, NULL, NULL},
+};
+
+// Natives that read the clock or block, left out of the sandbox.
+static const NativeReg time_host_functions[] = {
+    {"now", 0, nowNative, NULL},
+    {"sleep", 1, sleepNative, "i"},
+    {NULL, 0, NULL, NULL},
+};
+
+void registerTimeNatives(VM* vm, ObjModule* module) {
+    defineNatives(vm, module, time_functions);
+    if (!vm->options.sandbox) defineNatives(vm, module, time_host_functions);
+}
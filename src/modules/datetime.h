@@ -0,0 +1,12 @@
+#ifndef liss_modules_datetime_h
+#define liss_modules_datetime_h
+
+#include "object.h"
+
+typedef struct VM VM;
+
+// Registers the natives of the time module. Timestamps are ints of
+// milliseconds since the Unix epoch, read and written in UTC.
+void registerTimeNatives(VM* vm, ObjModule* module);
+
+#endif
@@ -0,0 +1,240 @@
+#define _POSIX_C_SOURCE 200809L
+#include "fs.h"
+
+#include <dirent.h>
+#include <errno.h>
+#include <glob.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/stat.h>
+
+#include "core.h"
+#include "object.h"
+#include "vm.h"
+
+// Paths go to the OS as given, so relative ones are resolved against the
+// working directory of the process, as io:open resolves them.
+
+// Returns an error value "fs:name: reason" for the errno of a failed call.
+static Value osError(VM* vm, const char* name) {
+    char msg[128];
+    snprintf(msg, sizeof(msg), "fs:%s: %s", name, strerror(errno));
+    return OBJ_VAL(newError(vm, msg));
+}
+
+static int compareNames(const void* a, const void* b) {
+    return strcmp(*(char* const*)a, *(char* const*)b);
+}
+
+// Returns a list of count strings, in order.
+static Value stringList(VM* vm, char** strings, size_t count) {
+    // Build the chain right-to-left; head kept rooted at stack_top[-1].
+    push(vm, NIL_VAL);
+    for (size_t i = count; i > 0; i--) {
+        push(vm, OBJ_VAL(copyString(vm, strings[i - 1],
+                                    (int)strlen(strings[i - 1]))));
+        vm->stack_top[-1] =
+            OBJ_VAL(newPair(vm, vm->stack_top[-1], vm->stack_top[-2]));
+        vm->stack_top[-2] = vm->stack_top[-1];
+        pop(vm);
+    }
+    Value result = OBJ_VAL(newList(vm, (uint32_t)count, vm->stack_top[-1]));
+    pop(vm);
+    return result;
+}
+
+/**
+ * Lists the names of the entries of a directory, sorted, without "." and
+ * "..". Returns an error value if the directory can't be read.
+ *
+ * Arguments: [Path: String]
+ * Return type: List of Strings
+ */
+static Value lsNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "fs:ls: expect a path");
+    DIR* dir = opendir(AS_CSTRING(argv[0]));
+    if (dir == NULL) return osError(vm, "ls");
+    char** names = NULL;
+    size_t count = 0;
+    size_t capacity = 0;
+    struct dirent* entry;
+    while ((entry = readdir(dir)) != NULL) {
+        if (strcmp(entry->d_name, ".") == 0 ||
+            strcmp(entry->d_name, "..") == 0) {
+            continue;
+        }
+        if (count == capacity) {
+            capacity = capacity < 8 ? 8 : capacity * 2;
+            names = realloc(names, sizeof(char*) * capacity);
+        }
+        names[count++] = strdup(entry->d_name);
+    }
+    closedir(dir);
+    qsort(names, count, sizeof(char*), compareNames);
+    Value result = stringList(vm, names, count);
+    for (size_t i = 0; i < count; i++) free(names[i]);
+    free(names);
+    return result;
+}
+
+/**
+ * Tells whether anything exists at a path.
+ *
+ * Arguments: [Path: String]
+ * Return type: Bool
+ */
+static Value existsNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "fs:exists?: expect a path");
+    struct stat st;
+    return BOOL_VAL(stat(AS_CSTRING(argv[0]), &st) == 0);
+}
+
+/**
+ * Tells whether a path is a directory.
+ *
+ * Arguments: [Path: String]
+ * Return type: Bool
+ */
+static Value isDirNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "fs:is_dir?: expect a path");
+    struct stat st;
+    return BOOL_VAL(stat(AS_CSTRING(argv[0]), &st) == 0 &&
+                    S_ISDIR(st.st_mode));
+}
+
+/**
+ * Describes what is at a path as a dict: its size in bytes, mtime in
+ * milliseconds since the Unix epoch, permission bits as mode, and is_dir and
+ * is_file. Returns an error value if nothing is there.
+ *
+ * Arguments: [Path: String]
+ * Return type: Dict
+ */
+static Value statNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "fs:stat: expect a path");
+    struct stat st;
+    if (stat(AS_CSTRING(argv[0]), &st) != 0) return osError(vm, "stat");
+    int64_t mtime = (int64_t)st.st_mtim.tv_sec * 1000 +
+                    st.st_mtim.tv_nsec / 1000000;
+    push(vm, OBJ_VAL(newDict(vm)));
+    putField(vm, "size", INT_VAL((int64_t)st.st_size));
+    putField(vm, "mtime", INT_VAL(mtime));
+    putField(vm, "mode", INT_VAL(st.st_mode & 07777));
+    putField(vm, "is_dir", BOOL_VAL(S_ISDIR(st.st_mode)));
+    putField(vm, "is_file", BOOL_VAL(S_ISREG(st.st_mode)));
+    return pop(vm);
+}
+
+/**
+ * Creates a directory and any parents it lacks, like mkdir -p. A directory
+ * that exists already is fine. Returns an error value on failure.
+ *
+ * Arguments: [Path: String]
+ * Return type: Nil
+ */
+static Value mkdirAllNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) {
+        return raiseErr(vm, "fs:mkdir_all: expect a path");
+    }
+    if (AS_STRING(argv[0])->length == 0) {
+        errno = ENOENT;
+        return osError(vm, "mkdir_all");
+    }
+    char* path = strdup(AS_CSTRING(argv[0]));
+    // Make every prefix ending before a separator, then the whole path.
+    for (char* sep = path + 1;; sep++) {
+        if (*sep != '/' && *sep != '\0') continue;
+        char end = *sep;
+        *sep = '\0';
+        struct stat st;
+        bool made = mkdir(path, 0777) == 0 ||
+                    (errno == EEXIST && stat(path, &st) == 0 &&
+                     S_ISDIR(st.st_mode));
+        if (!made) {
+            if (errno == EEXIST) errno = ENOTDIR;
+            free(path);
+            return osError(vm, "mkdir_all");
+        }
+        *sep = end;
+        if (end == '\0') break;
+    }
+    free(path);
+    return NIL_VAL;
+}
+
+/**
+ * Removes a file or an empty directory. Returns an error value on failure.
+ *
+ * Arguments: [Path: String]
+ * Return type: Nil
+ */
+static Value removeNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "fs:remove: expect a path");
+    if (remove(AS_CSTRING(argv[0])) != 0) return osError(vm, "remove");
+    return NIL_VAL;
+}
+
+/**
+ * Moves a file or directory to a new path, replacing a file there. Returns an
+ * error value on failure.
+ *
+ * Arguments: [From: String, To: String]
+ * Return type: Nil
+ */
+static Value renameNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "fs:rename: expect two paths");
+    }
+    if (rename(AS_CSTRING(argv[0]), AS_CSTRING(argv[1])) != 0) {
+        return osError(vm, "rename");
+    }
+    return NIL_VAL;
+}
+
+/**
+ * Lists the paths that match a shell pattern, such as "*.liss", sorted. `*`
+ * and `?` don't match a "/" or a leading ".". No match gives an empty list.
+ *
+ * Arguments: [Pattern: String]
+ * Return type: List of Strings
+ */
+static Value globNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) return raiseErr(vm, "fs:glob: expect a pattern");
+    glob_t found;
+    int status = glob(AS_CSTRING(argv[0]), 0, NULL, &found);
+    Value result;
+    if (status == 0) {
+        result = stringList(vm, found.gl_pathv, found.gl_pathc);
+    } else if (status == GLOB_NOMATCH) {
+        result = stringList(vm, NULL, 0);
+    } else {
+        result = OBJ_VAL(newError(vm, "fs:glob: could not read a directory"));
+    }
+    globfree(&found);
+    return result;
+}
+
+static const NativeReg fs_functions[] = {
+    {"ls", 1, lsNative, "s"},
+    {"exists?", 1, existsNative, "s"},
+    {"is_dir?", 1, isDirNative, "s"},
+    {"stat", 1, statNative, "s"},
+    {"mkdir_all", 1, mkdirAllNative, "s"},
+    {"remove", 1, removeNative, "s"},
+    {"rename", 2, renameNative, "ss"},
+    {"glob", 1, globNative, "s"},
+    {NULL, 0, NULL, NULL},  // Sentinel value
+};
+
+void registerFSNatives(VM* vm, ObjModule* module) {
+    defineNatives(vm, module, fs_functions);
+}
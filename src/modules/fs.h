@@ -0,0 +1,9 @@
+#ifndef liss_modules_fs_h
+#define liss_modules_fs_h
+
+typedef struct VM VM;
+typedef struct ObjModule ObjModule;
+
+void registerFSNatives(VM* vm, ObjModule* module);
+
+#endif
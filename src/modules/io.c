@@ -4,6 +4,7 @@
 #include <stdlib.h>
 #include <string.h>
 
+#include "core.h"
 #include "object.h"
 #include "vm.h"
 
@@ -30,10 +31,13 @@ static Value printNative(VM* vm, int argc, Value* args) {
     }
 
     for (int i = start_ix; i < argc; i++) {
-        // Strings print as-is (no quotes). Everything else goes through
-        // sprintValue, which returns a malloc'd string we must free.
+        // Strings print as-is (no quotes) and bytes are written raw.
+        // Everything else goes through sprintValue, which returns a malloc'd
+        // string we must free.
         if (IS_STRING(args[i])) {
             fprintf(out, "%s", AS_CSTRING(args[i]));
+        } else if (IS_BYTES(args[i])) {
+            fwrite(AS_BYTES(args[i])->data, 1, AS_BYTES(args[i])->len, out);
         } else {
             char* str = sprintValue(args[i]);
             fprintf(out, "%s", str);
@@ -61,8 +65,61 @@ static Value printlnNative(VM* vm, int argc, Value* args) {
     return NIL_VAL;
 }
 
+/**
+ * Pretty-prints a value followed by a newline: dict keys are sorted, and
+ * lists, tuples and dicts too long for one line get one item per line.
+ * If the first argument is a file handle, prints to it.
+ *
+ * Arguments: [File handle (optional), Value]
+ * Return type: Nil
+ */
+static Value ppNative(VM* vm, int argc, Value* args) {
+    FILE* out = stdout;
+    if (argc == 2 && IS_FILE(args[0])) {
+        ObjFile* file = AS_FILE(args[0]);
+        if (file->is_closed) {
+            return raiseErr(vm, "io:pp: attempt to print to closed file");
+        }
+        out = file->file;
+        args++;
+        argc--;
+    }
+    if (argc != 1) {
+        return raiseErr(vm, "io:pp: expect a value and an optional file");
+    }
+    char* str = sprintPretty(args[0]);
+    fprintf(out, "%s\n", str);
+    free(str);
+    return NIL_VAL;
+}
+
+/**
+ * Prints a format string filled with the given values, see format.
+ * If the first argument is a file handle, prints to it.
+ *
+ * Arguments: [File handle (optional), Format: String, ...Values]
+ * Return type: Nil
+ */
+static Value printfNative(VM* vm, int argc, Value* args) {
+    FILE* out = stdout;
+    if (argc > 0 && IS_FILE(args[0])) {
+        ObjFile* file = AS_FILE(args[0]);
+        if (file->is_closed) {
+            return raiseErr(vm, "io:printf: attempt to print to closed file");
+        }
+        out = file->file;
+        args++;
+        argc--;
+    }
+    ObjString* str = formatValues(vm, "io:printf", argc, args);
+    if (str == NULL) return NIL_VAL;
+    fwrite(str->chars, 1, (size_t)str->length, out);
+    return NIL_VAL;
+}
+
 /**
  * Opens a file with the given path and optional mode (default: "r").
+ * Reads from a file opened with a "b" mode, such as "rb", give bytes.
  *
  * Arguments: [Path: String, Mode: String (optional)]
  * Return type: File Handle
@@ -78,7 +135,9 @@ static Value openNative(VM* vm, int argc, Value* argv) {
     if (file == NULL) {
         return OBJ_VAL(newError(vm, "io:open: could not open file"));
     }
-    return OBJ_VAL(newFile(vm, file));
+    ObjFile* file_obj = newFile(vm, file, AS_CSTRING(argv[0]), true);
+    file_obj->binary = strchr(mode, 'b') != NULL;
+    return OBJ_VAL(file_obj);
 }
 
 /**
@@ -96,14 +155,55 @@ static Value closeNative(VM* vm, int argc, Value* argv) {
 
     fclose(file->file);
     file->is_closed = true;
+    untrackResource(vm, &file->resource);
     return NIL_VAL;
 }
 
+// Returns the number of bytes between the current position and the end of a
+// seekable file, or -1 if the file can't seek (pipes, terminals).
+static long remainingBytes(FILE* file) {
+    long cur = ftell(file);
+    if (cur == -1 || fseek(file, 0, SEEK_END) != 0) return -1;
+    long total = ftell(file);
+    if (fseek(file, cur, SEEK_SET) != 0) return -1;
+    return total - cur;
+}
+
+// Reads what is left of a file, in chunks so that pipes and terminals, which
+// can't tell how much is left, work too. Returns NULL if out of memory, and
+// the number of bytes read in *len. The caller frees the result.
+static char* readToEnd(FILE* file, size_t* len) {
+    size_t cap = 4096;
+    char* buf = malloc(cap);
+    *len = 0;
+    while (buf != NULL) {
+        *len += fread(buf + *len, 1, cap - *len - 1, file);
+        if (*len < cap - 1) break;
+        cap *= 2;
+        char* grown = realloc(buf, cap);
+        if (grown == NULL) free(buf);
+        buf = grown;
+    }
+    if (buf != NULL) buf[*len] = '\0';
+    return buf;
+}
+
+// Wraps what was read from a file as bytes for a binary file and as a string
+// otherwise. Takes ownership of buf.
+static Value readResult(VM* vm, bool binary, char* buf, size_t len) {
+    if (!binary) return OBJ_VAL(takeString(vm, buf, (int)len));
+    ObjBytes* bytes = newBytes(vm, (const uint8_t*)buf, (uint32_t)len);
+    free(buf);
+    return OBJ_VAL(bytes);
+}
+
 /**
  * Reads from a file handle. If byte_size is omitted, reads until EOF.
+ * Otherwise reads at most byte_size bytes, so a large file can be consumed
+ * in fixed-size chunks; an empty result means the end of the file.
  *
  * Arguments: [Handle: File, byte_size: Int (optional)]
- * Return type: String
+ * Return type: String, or Bytes for a file opened in binary mode
  */
 static Value readNative(VM* vm, int argc, Value* argv) {
     if (argc < 1 || argc > 2 || !IS_FILE(argv[0])) {
@@ -117,16 +217,15 @@ static Value readNative(VM* vm, int argc, Value* argv) {
 
     long size = 0;
     if (argc == 1) {
-        long cur = ftell(file->file);
-        if (fseek(file->file, 0, SEEK_END) != 0) {
-            return raiseErr(vm, "io:read: seek failed");
-        }
-        long total = ftell(file->file);
-        if (fseek(file->file, cur, SEEK_SET) != 0) {
-            return raiseErr(vm, "io:read: seek failed");
+        size = remainingBytes(file->file);
+        if (size == -1) {
+            size_t len;
+            char* buf = readToEnd(file->file, &len);
+            if (buf == NULL) {
+                return raiseErr(vm, "io:read: memory allocation failed");
+            }
+            return readResult(vm, file->binary, buf, len);
         }
-        size = total - cur;
-        if (size <= 0) return OBJ_VAL(copyString(vm, "", 0));
     } else {
         if (!IS_INT(argv[1])) {
             return raiseErr(vm, "io:read: byte_size must be an integer");
@@ -135,6 +234,9 @@ static Value readNative(VM* vm, int argc, Value* argv) {
         if (size < 0) {
             return raiseErr(vm, "io:read: byte_size must be >= 0");
         }
+        // Don't allocate the whole chunk when less than that is left.
+        long remaining = remainingBytes(file->file);
+        if (remaining >= 0 && remaining < size) size = remaining;
     }
 
     char* buf = malloc(size + 1);
@@ -145,27 +247,15 @@ static Value readNative(VM* vm, int argc, Value* argv) {
     size_t bytes_read = fread(buf, 1, size, file->file);
     buf[bytes_read] = '\0';
 
-    return OBJ_VAL(takeString(vm, buf, (int)bytes_read));
+    return readResult(vm, file->binary, buf, bytes_read);
 }
 
-/**
- * Reads a single line from the given file handle.
- *
- * Arguments: [Handle: File]
- * Return type: String
- */
-static Value readLineNative(VM* vm, int argc, Value* argv) {
-    if (argc != 1 || !IS_FILE(argv[0])) {
-        return raiseErr(vm, "io:read-line: expect file handle");
-    }
-    ObjFile* file = AS_FILE(argv[0]);
-    if (file->is_closed) {
-        return raiseErr(vm, "io:read-line: read from closed file");
-    }
-
+// Reads a line from in without its line break. Returns an "eof" error value
+// at the end of the input.
+static Value readLine(VM* vm, FILE* in) {
     char* line = NULL;
     size_t cap = 0;
-    int len = getline(&line, &cap, file->file);
+    ssize_t len = getline(&line, &cap, in);
 
     if (len == -1) {
         free(line);
@@ -187,6 +277,64 @@ static Value readLineNative(VM* vm, int argc, Value* argv) {
     return res;
 }
 
+// Returns the stream io:stdin reads.
+static FILE* inputOf(VM* vm) {
+    return vm->options.input != NULL ? vm->options.input : stdin;
+}
+
+/**
+ * Reads a single line from the given file handle.
+ *
+ * Arguments: [Handle: File]
+ * Return type: String
+ */
+static Value readLineNative(VM* vm, int argc, Value* argv) {
+    if (argc != 1 || !IS_FILE(argv[0])) {
+        return raiseErr(vm, "io:read-line: expect file handle");
+    }
+    ObjFile* file = AS_FILE(argv[0]);
+    if (file->is_closed) {
+        return raiseErr(vm, "io:read-line: read from closed file");
+    }
+    return readLine(vm, file->file);
+}
+
+/**
+ * Reads a single line from the given file handle, or from stdin without one.
+ * At the end of the input it returns the error value "eof".
+ *
+ * Arguments: [Handle: File (optional)]
+ * Return type: String | err
+ */
+static Value readLineStdinNative(VM* vm, int argc, Value* argv) {
+    if (argc == 0) return readLine(vm, inputOf(vm));
+    if (argc != 1 || !IS_FILE(argv[0])) {
+        return raiseErr(vm, "io:read_line: expect an optional file handle");
+    }
+    ObjFile* file = AS_FILE(argv[0]);
+    if (file->is_closed) {
+        return raiseErr(vm, "io:read_line: read from closed file");
+    }
+    return readLine(vm, file->file);
+}
+
+/**
+ * Reads what is left of stdin, as piped into the script, up to its end.
+ *
+ * Arguments: []
+ * Return type: String
+ */
+static Value readAllStdinNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    size_t len;
+    char* buf = readToEnd(inputOf(vm), &len);
+    if (buf == NULL) {
+        return raiseErr(vm, "io:read_all_stdin: memory allocation failed");
+    }
+    return readResult(vm, false, buf, len);
+}
+
 /**
  * Seeks to a position in a file handle.
  *
@@ -227,16 +375,24 @@ static Value tellNative(VM* vm, int argc, Value* argv) {
 }
 
 /**
- * Opens a file, reads all content, closes it, and returns the string.
+ * Opens a file, reads all content, closes it, and returns the string. With
+ * the mode "rb" it returns bytes instead.
  *
- * Arguments: [path: String]
- * Return type: String | err
+ * Arguments: [path: String, mode: String (optional)]
+ * Return type: String | Bytes | err
  */
 static Value slurpNative(VM* vm, int argc, Value* argv) {
-    if (argc != 1 || !IS_STRING(argv[0])) {
-        return raiseErr(vm, "io:slurp: expect path string");
+    if ((argc != 1 && argc != 2) || !IS_STRING(argv[0]) ||
+        (argc == 2 && !IS_STRING(argv[1]))) {
+        return raiseErr(vm,
+                        "io:slurp: expect path and optional mode as strings");
     }
-    FILE* file = fopen(AS_CSTRING(argv[0]), "r");
+    const char* mode = (argc == 2) ? AS_CSTRING(argv[1]) : "r";
+    if (strcmp(mode, "r") != 0 && strcmp(mode, "rb") != 0) {
+        return raiseErr(vm, "io:slurp: mode must be \"r\" or \"rb\"");
+    }
+    bool binary = mode[1] == 'b';
+    FILE* file = fopen(AS_CSTRING(argv[0]), mode);
     if (file == NULL) {
         return OBJ_VAL(newError(vm, "io:slurp: could not open file"));
     }
@@ -249,10 +405,7 @@ static Value slurpNative(VM* vm, int argc, Value* argv) {
         fclose(file);
         return OBJ_VAL(newError(vm, "io:slurp: seek failed"));
     }
-    if (size <= 0) {
-        fclose(file);
-        return OBJ_VAL(copyString(vm, "", 0));
-    }
+    if (size < 0) size = 0;
     char* buf = malloc(size + 1);
     if (buf == NULL) {
         fclose(file);
@@ -261,31 +414,52 @@ static Value slurpNative(VM* vm, int argc, Value* argv) {
     size_t bytes_read = fread(buf, 1, size, file);
     fclose(file);
     buf[bytes_read] = '\0';
-    return OBJ_VAL(takeString(vm, buf, (int)bytes_read));
+    return readResult(vm, binary, buf, bytes_read);
 }
 
 
 static const NativeReg io_functions[] = {
-    {"print", -1, printNative}, {"println", -1, printlnNative},
-    {"open", -1, openNative},   {"close", 1, closeNative},
-    {"read", -1, readNative},   {"read-line", 1, readLineNative},
-    {"seek", 3, seekNative},    {"tell", 1, tellNative},
-    {"slurp", 1, slurpNative},  {NULL, 0, NULL},  // Sentinel value
+    {"print", -1, printNative, NULL}, {"println", -1, printlnNative, NULL},
+    {"printf", -1, printfNative, NULL}, {"pp", -1, ppNative, NULL},
+    {"open", -1, openNative, NULL},   {"close", 1, closeNative, NULL},
+    {"read", -1, readNative, NULL},
+    {"read-line", 1, readLineNative, NULL},
+    {"read_line", -1, readLineStdinNative, NULL},
+    {"read_all_stdin", 0, readAllStdinNative, NULL},
+    {"seek", 3, seekNative, NULL},    {"tell", 1, tellNative, NULL},
+    {"slurp", -1, slurpNative, NULL}, {NULL, 0, NULL, NULL},  // Sentinel value
 };
 
 void registerIONatives(VM* vm, ObjModule* module) {
     defineNatives(vm, module, io_functions);
 
-    // Standard pre-opened streams
-    defineConst(vm, module, "stdin", OBJ_VAL(newFile(vm, stdin)));
-    defineConst(vm, module, "stdout", OBJ_VAL(newFile(vm, stdout)));
-    defineConst(vm, module, "stderr", OBJ_VAL(newFile(vm, stderr)));
+    // Standard pre-opened streams. The VM doesn't own them, so they stay open
+    // when it shuts down.
+    defineConst(vm, module, "stdin",
+                OBJ_VAL(newFile(vm, inputOf(vm), "<stdin>", false)));
+    defineConst(vm, module, "stdout",
+                OBJ_VAL(newFile(vm, stdout, "<stdout>", false)));
+    defineConst(vm, module, "stderr",
+                OBJ_VAL(newFile(vm, stderr, "<stderr>", false)));
+
+    // The arguments given after the script on the command line.
+    push(vm, OBJ_VAL(newList(vm, 0, NIL_VAL)));
+    for (int i = vm->options.arg_cnt; i > 0; i--) {
+        const char* arg = vm->options.args[i - 1];
+        push(vm, OBJ_VAL(copyString(vm, arg, (int)strlen(arg))));
+        ObjList* list = AS_LIST(vm->stack_top[-2]);
+        list->head = OBJ_VAL(newPair(vm, vm->stack_top[-1], list->head));
+        list->len++;
+        pop(vm);
+    }
+    defineConst(vm, module, "args", pop(vm));
 
     // IO modes
     defineConst(vm, module, "R", OBJ_VAL(copyString(vm, "r", 1)));
     defineConst(vm, module, "W", OBJ_VAL(copyString(vm, "w", 1)));
     defineConst(vm, module, "A", OBJ_VAL(copyString(vm, "a", 1)));
     defineConst(vm, module, "RW", OBJ_VAL(copyString(vm, "r+", 2)));
+    defineConst(vm, module, "RB", OBJ_VAL(copyString(vm, "rb", 2)));
 
     // Seek constants
     defineConst(vm, module, "SET", INT_VAL(SEEK_SET));
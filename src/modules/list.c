@@ -3,7 +3,9 @@
 #include <stdlib.h>
 #include <string.h>
 
+#include "hamt.h"
 #include "object.h"
+#include "utf8.h"
 #include "value.h"
 #include "vm.h"
 
@@ -21,7 +23,9 @@ static Value tailNative(VM* vm, int argc, Value* argv) {
     ObjList* list = AS_LIST(argv[0]);
     if (list->len == 0) return raiseErr(vm, "list:tail: empty list");
     Value rest = AS_PAIR(list->head)->second;
-    return OBJ_VAL(newList(vm, list->len - 1, rest));
+    ObjList* result = newList(vm, list->len - 1, rest);
+    list->shared = result->shared = true;
+    return OBJ_VAL(result);
 }
 
 static Value lastNative(VM* vm, int argc, Value* argv) {
@@ -41,9 +45,10 @@ static Value consNative(VM* vm, int argc, Value* argv) {
     ObjList* list = AS_LIST(argv[0]);
     push(vm, NIL_VAL);
     vm->stack_top[-1] = OBJ_VAL(newPair(vm, argv[1], list->head));
-    Value result = OBJ_VAL(newList(vm, list->len + 1, vm->stack_top[-1]));
+    ObjList* result = newList(vm, list->len + 1, vm->stack_top[-1]);
     pop(vm);
-    return result;
+    list->shared = result->shared = true;
+    return OBJ_VAL(result);
 }
 
 // Rebuild the spine of 'list' with 'elem' appended at the end. O(n).
@@ -105,9 +110,10 @@ static Value appendNative(VM* vm, int argc, Value* argv) {
         pop(vm);
     }
 
-    Value result = OBJ_VAL(newList(vm, len1 + len2, vm->stack_top[-1]));
+    ObjList* result = newList(vm, len1 + len2, vm->stack_top[-1]);
     pop(vm);
-    return result;
+    list2->shared = result->shared = true;
+    return OBJ_VAL(result);
 }
 
 static Value mapNative(VM* vm, int argc, Value* argv) {
@@ -198,8 +204,11 @@ static int naturalCmp(Value a, Value b, bool* type_err) {
         double y = IS_INT(b) ? (double)AS_INT(b) : AS_REAL(b);
         return (x > y) - (x < y);
     }
-    if (IS_STRING(a) && IS_STRING(b))
-        return strcmp(AS_CSTRING(a), AS_CSTRING(b));
+    if (IS_STRING(a) && IS_STRING(b)) {
+        ObjString* x = AS_STRING(a);
+        ObjString* y = AS_STRING(b);
+        return compareText(x->chars, x->length, y->chars, y->length);
+    }
     *type_err = true;
     return 0;
 }
@@ -302,13 +311,256 @@ static Value sortByNative(VM* vm, int argc, Value* argv) {
     return sortImpl(vm, argv[0], fn, true);
 }
 
+// --- In-place mutation ---
+//
+// The builtins ending in ! change the list they are given, so every name
+// bound to that list sees the change. Lists made from it by tail, cons, append
+// or range share its pairs and are marked shared; a shared list gets a spine of
+// its own before its first in-place change, which is O(n) once.
+
+// Copies len pairs of a spine. The copy is only rooted through the returned
+// head, so callers must store it before allocating again.
+static Value copySpine(VM* vm, Value head, uint32_t len, ObjPair** last) {
+    *last = NULL;
+    if (len == 0) return NIL_VAL;
+    push(vm, OBJ_VAL(newPair(vm, AS_PAIR(head)->first, NIL_VAL)));
+    ObjPair* tail = AS_PAIR(vm->stack_top[-1]);
+    for (uint32_t i = 1; i < len; i++) {
+        head = AS_PAIR(head)->second;
+        ObjPair* next = newPair(vm, AS_PAIR(head)->first, NIL_VAL);
+        tail->second = OBJ_VAL(next);
+        tail = next;
+    }
+    *last = tail;
+    return pop(vm);
+}
+
+static void ownSpine(VM* vm, ObjList* list) {
+    if (!list->shared) return;
+    ObjPair* last;
+    list->head = copySpine(vm, list->head, list->len, &last);
+    list->last = last;
+    list->shared = false;
+}
+
+// Dict keys hold frozen copies of lists, which must not change under them.
+static Value frozenErr(VM* vm, const char* name) {
+    RUNTIME_ERR(vm, "%s: list is a frozen dict key", name);
+    return NIL_VAL;
+}
+
+static ObjPair* pairAt(ObjList* list, uint32_t ix) {
+    Value cur = list->head;
+    for (uint32_t i = 0; i < ix; i++) cur = AS_PAIR(cur)->second;
+    return AS_PAIR(cur);
+}
+
+static ObjPair* lastPair(ObjList* list) {
+    if (list->last == NULL) list->last = pairAt(list, list->len - 1);
+    return list->last;
+}
+
+// Appends a pair to an unshared list. The pair must hold a rooted value.
+static void appendPair(ObjList* list, ObjPair* pair) {
+    if (list->len == 0) {
+        list->head = OBJ_VAL(pair);
+    } else {
+        lastPair(list)->second = OBJ_VAL(pair);
+    }
+    list->last = pair;
+    list->len++;
+}
+
+static Value copyNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0])) return raiseErr(vm, "list:copy: expects a list");
+    ObjList* list = AS_LIST(argv[0]);
+    ObjPair* last;
+    ObjList* copy =
+        newList(vm, list->len, copySpine(vm, list->head, list->len, &last));
+    copy->last = last;
+    return OBJ_VAL(copy);
+}
+
+typedef struct {
+    ObjList* list;
+    bool found;
+} Reach;
+
+static bool reachesList(Value value, ObjList* list);
+
+static void reachEntry(Value key, Value val, void* ctx) {
+    (void)key;  // Keys are frozen copies, never the list itself.
+    Reach* reach = ctx;
+    if (!reach->found) reach->found = reachesList(val, reach->list);
+}
+
+// Returns true if value is list or holds it somewhere inside. Lists are the
+// only values that change in place, so refusing such a value in the ! natives
+// keeps every value free of cycles, which printing and equality rely on.
+static bool reachesList(Value value, ObjList* list) {
+    if (!IS_OBJ(value)) return false;
+    switch (OBJ_TYPE(value)) {
+        case OBJ_LIST: {
+            if (AS_LIST(value) == list) return true;
+            Value cur = AS_LIST(value)->head;
+            for (uint32_t i = 0; i < AS_LIST(value)->len; i++) {
+                if (reachesList(AS_PAIR(cur)->first, list)) return true;
+                cur = AS_PAIR(cur)->second;
+            }
+            return false;
+        }
+        case OBJ_TUPLE:
+            for (uint32_t i = 0; i < AS_TUPLE(value)->len; i++) {
+                if (reachesList(AS_TUPLE(value)->items[i], list)) return true;
+            }
+            return false;
+        case OBJ_PAIR:
+            return reachesList(AS_PAIR(value)->first, list) ||
+                   reachesList(AS_PAIR(value)->second, list);
+        case OBJ_DICT: {
+            Reach reach = {list, false};
+            hamtEach(AS_DICT(value)->root, reachEntry, &reach);
+            return reach.found;
+        }
+        default:
+            return false;
+    }
+}
+
+static Value cycleErr(VM* vm, const char* name) {
+    RUNTIME_ERR(vm, "%s: a list cannot contain itself", name);
+    return NIL_VAL;
+}
+
+static Value pushInPlaceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0]))
+        return raiseErr(vm, "list:push!: first argument must be a list");
+    ObjList* list = AS_LIST(argv[0]);
+    if (list->frozen) return frozenErr(vm, "list:push!");
+    if (reachesList(argv[1], list)) return cycleErr(vm, "list:push!");
+    ownSpine(vm, list);
+    appendPair(list, newPair(vm, argv[1], NIL_VAL));
+    return argv[0];
+}
+
+static Value popInPlaceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0])) return raiseErr(vm, "list:pop!: expects a list");
+    ObjList* list = AS_LIST(argv[0]);
+    if (list->frozen) return frozenErr(vm, "list:pop!");
+    if (list->len == 0) return raiseErr(vm, "list:pop!: empty list");
+    ownSpine(vm, list);
+    if (list->len == 1) {
+        Value elem = AS_PAIR(list->head)->first;
+        list->head = NIL_VAL;
+        list->last = NULL;
+        list->len = 0;
+        return elem;
+    }
+    ObjPair* prev = pairAt(list, list->len - 2);
+    Value elem = AS_PAIR(prev->second)->first;
+    prev->second = NIL_VAL;
+    list->last = prev;
+    list->len--;
+    return elem;
+}
+
+static Value insertInPlaceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0]))
+        return raiseErr(vm, "list:insert!: first argument must be a list");
+    if (!IS_INT(argv[1]))
+        return raiseErr(vm, "list:insert!: index must be an integer");
+    ObjList* list = AS_LIST(argv[0]);
+    if (list->frozen) return frozenErr(vm, "list:insert!");
+    if (reachesList(argv[2], list)) return cycleErr(vm, "list:insert!");
+    int64_t ix = indexFromEnd(vm, AS_INT(argv[1]), list->len);
+    if (ix < 0 || ix > list->len)
+        return raiseErr(vm, "list:insert!: index out of bounds");
+    ownSpine(vm, list);
+    ObjPair* pair = newPair(vm, argv[2], NIL_VAL);
+    if (ix == list->len) {
+        appendPair(list, pair);
+    } else if (ix == 0) {
+        pair->second = list->head;
+        list->head = OBJ_VAL(pair);
+        list->len++;
+    } else {
+        ObjPair* prev = pairAt(list, (uint32_t)ix - 1);
+        pair->second = prev->second;
+        prev->second = OBJ_VAL(pair);
+        list->len++;
+    }
+    return argv[0];
+}
+
+static Value removeAtInPlaceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0]))
+        return raiseErr(vm, "list:remove_at!: first argument must be a list");
+    if (!IS_INT(argv[1]))
+        return raiseErr(vm, "list:remove_at!: index must be an integer");
+    ObjList* list = AS_LIST(argv[0]);
+    if (list->frozen) return frozenErr(vm, "list:remove_at!");
+    int64_t ix = indexFromEnd(vm, AS_INT(argv[1]), list->len);
+    if (ix < 0 || ix >= list->len)
+        return raiseErr(vm, "list:remove_at!: index out of bounds");
+    ownSpine(vm, list);
+    Value elem;
+    if (ix == 0) {
+        elem = AS_PAIR(list->head)->first;
+        list->head = AS_PAIR(list->head)->second;
+    } else {
+        ObjPair* prev = pairAt(list, (uint32_t)ix - 1);
+        elem = AS_PAIR(prev->second)->first;
+        prev->second = AS_PAIR(prev->second)->second;
+        if (ix == list->len - 1) list->last = prev;
+    }
+    list->len--;
+    if (list->len == 0) list->last = NULL;
+    return elem;
+}
+
+static Value extendInPlaceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0]) || !IS_LIST(argv[1]))
+        return raiseErr(vm, "list:extend!: expects two lists");
+    ObjList* list = AS_LIST(argv[0]);
+    if (list->frozen) return frozenErr(vm, "list:extend!");
+    ownSpine(vm, list);
+    // Read the other list after the copy: it may be the same list, which
+    // then gets its own items appended once.
+    ObjList* other = AS_LIST(argv[1]);
+    uint32_t len = other->len;
+    Value cur = other->head;
+    for (uint32_t i = 0; i < len; i++) {
+        if (reachesList(AS_PAIR(cur)->first, list)) {
+            return cycleErr(vm, "list:extend!");
+        }
+        cur = AS_PAIR(cur)->second;
+    }
+    cur = other->head;
+    for (uint32_t i = 0; i < len; i++) {
+        appendPair(list, newPair(vm, AS_PAIR(cur)->first, NIL_VAL));
+        cur = AS_PAIR(cur)->second;
+    }
+    return argv[0];
+}
+
 static const NativeReg list_functions[] = {
-    {"head", 1, headNative}, {"tail", 1, tailNative},
-    {"last", 1, lastNative}, {"cons", 2, consNative},
-    {"push", 2, pushNative}, {"append", 2, appendNative},
-    {"map", 2, mapNative},   {"reduce", 3, reduceNative},
-    {"sort", 1, sortNative}, {"sort_by", 2, sortByNative},
-    {NULL, 0, NULL},
+    {"head", 1, headNative, NULL}, {"tail", 1, tailNative, NULL},
+    {"last", 1, lastNative, NULL}, {"cons", 2, consNative, NULL},
+    {"push", 2, pushNative, NULL}, {"append", 2, appendNative, NULL},
+    {"map", 2, mapNative, NULL},   {"reduce", 3, reduceNative, NULL},
+    {"sort", 1, sortNative, NULL}, {"sort_by", 2, sortByNative, NULL},
+    {"copy", 1, copyNative, NULL}, {"push!", 2, pushInPlaceNative, NULL},
+    {"pop!", 1, popInPlaceNative, NULL},
+    {"insert!", 3, insertInPlaceNative, NULL},
+    {"remove_at!", 2, removeAtInPlaceNative, NULL},
+    {"extend!", 2, extendInPlaceNative, NULL},
+    {NULL, 0, NULL, NULL},
 };
 
 void registerListNatives(VM* vm, ObjModule* module) {
@@ -331,19 +331,56 @@ static Value atan2Native(VM* vm, int argc, Value* argv) {
     return REAL_VAL(res);
 }
 
+/**
+ * Returns a pseudo-random number: a real in [0, 1) without arguments, or an
+ * int in [0, n) given n. Reproducible with --seed or --deterministic.
+ *
+ * Arguments: 0 or 1
+ * Argument types: Int
+ * Return type: Real or Int
+ */
+static Value randNative(VM* vm, int argc, Value* argv) {
+    if (argc > 1) {
+        return raiseErr(vm, "rand takes at most 1 argument");
+    }
+    if (argc == 0) {
+        // The top 53 bits fill the mantissa of a double exactly.
+        return REAL_VAL((double)(nextRand(vm) >> 11) * 0x1.0p-53);
+    }
+    if (!IS_INT(argv[0]) || AS_INT(argv[0]) <= 0) {
+        return raiseErr(vm, "rand takes a positive int argument");
+    }
+    return INT_VAL((int64_t)(nextRand(vm) % (uint64_t)AS_INT(argv[0])));
+}
+
 static const NativeReg math_functions[] = {
-    {"floor", 1, floorNative}, {"ceil", 1, ceilNative},
-    {"round", 1, roundNative}, {"abs", 1, absNative},
-    {"sqrt", 1, sqrtNative},   {"pow", 2, powNative},
-    {"fmod", 2, fmodNative},   {"log", 1, logNative},
-    {"log2", 1, log2Native},   {"log10",1, log10Native},
-    {"exp", 1, expNative},     {"sin", 1, sinNative},
-    {"cos", 1, cosNative},     {"tan", 1, tanNative},
-    {"atan2", 2, atan2Native}, {NULL, 0, NULL},  // Sentinel value
+    {"floor", 1, floorNative, "n"},
+    {"ceil", 1, ceilNative, "n"},
+    {"round", 1, roundNative, "n"},
+    {"abs", 1, absNative, "n"},
+    {"sqrt", 1, sqrtNative, "n"},
+    {"pow", 2, powNative, "nn"},
+    {"fmod", 2, fmodNative, "nn"},
+    {"log", 1, logNative, "n"},
+    {"log2", 1, log2Native, "n"},
+    {"log10", 1, log10Native, "n"},
+    {"exp", 1, expNative, "n"},
+    {"sin", 1, sinNative, "n"},
+    {"cos", 1, cosNative, "n"},
+    {"tan", 1, tanNative, "n"},
+    {"atan2", 2, atan2Native, "nn"},
+    {NULL, 0, NULL, NULL},  // Sentinel value
+};
+
+// Natives that observe the host, left out of the sandbox.
+static const NativeReg math_host_functions[] = {
+    {"rand", -1, randNative, "i"},
+    {NULL, 0, NULL, NULL},
 };
 
 void registerMathNatives(VM* vm, ObjModule* module) {
     defineNatives(vm, module, math_functions);
+    if (!vm->options.sandbox) defineNatives(vm, module, math_host_functions);
 
     defineConst(vm, module, "PI", REAL_VAL(M_PI));
     defineConst(vm, module, "E", REAL_VAL(M_E));
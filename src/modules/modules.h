@@ -2,12 +2,16 @@
 #define liss_modules_modules_h
 
 #include "core.h"
+#include "datetime.h"
+#include "fs.h"
 #include "io.h"
 #include "list.h"
 #include "math.h"
 #include "object.h"
+#include "rand.h"
 #include "re.h"
 #include "str.h"
+#include "test.h"
 #include "vm.h"
 
 typedef void (*NativeModuleLoader)(VM* vm, ObjModule* module);
@@ -15,16 +19,21 @@ typedef void (*NativeModuleLoader)(VM* vm, ObjModule* module);
 typedef struct {
     const char* name;
     NativeModuleLoader loader;
+    bool pure;  // If true, the module is available in the sandbox
 } NativeModuleEntry;
 
 static const NativeModuleEntry native_module_registry[] = {
-    {"core", registerCoreNatives},
-    {"list", registerListNatives},
-    {"math", registerMathNatives},
-    {"io", registerIONatives},
-    {"re", registerRENatives},
-    {"str", registerStrNatives},
-    {NULL, NULL},
+    {"core", registerCoreNatives, true},
+    {"list", registerListNatives, true},
+    {"math", registerMathNatives, true},
+    {"io", registerIONatives, false},
+    {"fs", registerFSNatives, false},
+    {"rand", registerRandNatives, false},
+    {"re", registerRENatives, true},
+    {"str", registerStrNatives, true},
+    {"test", registerTestNatives, true},
+    {"time", registerTimeNatives, true},
+    {NULL, NULL, false},
 };
 
 #endif
@@ -0,0 +1,155 @@
+#include "rand.h"
+
+#include <stdlib.h>
+
+#include "object.h"
+#include "vm.h"
+
+// Returns a uniform real in [0, 1). The top 53 bits fill the mantissa of a
+// double exactly.
+static double randUnit(VM* vm) {
+    return (double)(nextRand(vm) >> 11) * 0x1.0p-53;
+}
+
+// Returns a uniform number in [0, n) for n > 0, rejecting the few draws that
+// would make the low numbers likelier than the rest.
+static uint64_t randBelow(VM* vm, uint64_t n) {
+    uint64_t threshold = -n % n;
+    for (;;) {
+        uint64_t r = nextRand(vm);
+        if (r >= threshold) return r % n;
+    }
+}
+
+/**
+ * Restarts the generator from n, as --seed n does for the whole run, so the
+ * numbers that follow are the same on every run and machine.
+ *
+ * Arguments: 1
+ * Argument types: Int
+ * Return type: Null
+ */
+static Value seedNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_INT(argv[0])) return raiseErr(vm, "rand:seed takes an int");
+    vm->rand_state = (uint64_t)AS_INT(argv[0]);
+    return NIL_VAL;
+}
+
+/**
+ * Returns a pseudo-random real in [0, 1).
+ *
+ * Arguments: 0
+ * Return type: Real
+ */
+static Value floatNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    (void)argv;
+    return REAL_VAL(randUnit(vm));
+}
+
+/**
+ * Returns a pseudo-random number in [a, b): an int if both bounds are ints,
+ * otherwise a real.
+ *
+ * Arguments: 2
+ * Argument types: Int or Real
+ * Return type: Int or Real
+ */
+static Value rangeNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    Value a = argv[0];
+    Value b = argv[1];
+    if (!(IS_INT(a) || IS_REAL(a)) || !(IS_INT(b) || IS_REAL(b))) {
+        return raiseErr(vm, "rand:range takes int or real bounds");
+    }
+    if (IS_INT(a) && IS_INT(b)) {
+        if (AS_INT(a) >= AS_INT(b)) {
+            return raiseErr(vm, "rand:range: a must be less than b");
+        }
+        uint64_t span = (uint64_t)AS_INT(b) - (uint64_t)AS_INT(a);
+        return INT_VAL((int64_t)((uint64_t)AS_INT(a) + randBelow(vm, span)));
+    }
+    double lo = IS_INT(a) ? (double)AS_INT(a) : AS_REAL(a);
+    double hi = IS_INT(b) ? (double)AS_INT(b) : AS_REAL(b);
+    if (!(lo < hi)) return raiseErr(vm, "rand:range: a must be less than b");
+    double x = lo + randUnit(vm) * (hi - lo);
+    // Rounding can land on hi when the bounds are far apart.
+    return REAL_VAL(x < hi ? x : lo);
+}
+
+/**
+ * Returns an element of a non-empty list, each as likely as the others.
+ *
+ * Arguments: 1
+ * Argument types: List
+ * Return type: Any
+ */
+static Value choiceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0])) return raiseErr(vm, "rand:choice takes a list");
+    ObjList* list = AS_LIST(argv[0]);
+    if (list->len == 0) {
+        return raiseErr(vm, "rand:choice: the list is empty");
+    }
+    uint64_t index = randBelow(vm, list->len);
+    Value cur = list->head;
+    for (uint64_t i = 0; i < index; i++) cur = AS_PAIR(cur)->second;
+    return AS_PAIR(cur)->first;
+}
+
+/**
+ * Returns a new list with the elements of a list in a random order; the list
+ * given is left as it is.
+ *
+ * Arguments: 1
+ * Argument types: List
+ * Return type: List
+ */
+static Value shuffleNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_LIST(argv[0])) return raiseErr(vm, "rand:shuffle takes a list");
+    ObjList* list = AS_LIST(argv[0]);
+    uint32_t len = list->len;
+    Value* elems = malloc(len * sizeof(Value) + 1);  // Not NULL when empty
+    if (elems == NULL) return raiseErr(vm, "rand:shuffle: allocation failed");
+    Value cur = list->head;
+    for (uint32_t i = 0; i < len; i++) {
+        elems[i] = AS_PAIR(cur)->first;
+        cur = AS_PAIR(cur)->second;
+    }
+    // Fisher-Yates; the elements stay rooted through the list given.
+    for (uint32_t i = len; i > 1; i--) {
+        uint32_t j = (uint32_t)randBelow(vm, i);
+        Value tmp = elems[i - 1];
+        elems[i - 1] = elems[j];
+        elems[j] = tmp;
+    }
+
+    // Rebuild chain right-to-left; head kept rooted at stack_top[-1].
+    push(vm, NIL_VAL);
+    for (int64_t i = (int64_t)len - 1; i >= 0; i--) {
+        push(vm, elems[i]);
+        vm->stack_top[-1] =
+            OBJ_VAL(newPair(vm, vm->stack_top[-1], vm->stack_top[-2]));
+        vm->stack_top[-2] = vm->stack_top[-1];
+        pop(vm);
+    }
+    Value result = OBJ_VAL(newList(vm, len, vm->stack_top[-1]));
+    pop(vm);
+    free(elems);
+    return result;
+}
+
+static const NativeReg rand_functions[] = {
+    {"seed", 1, seedNative, "i"},
+    {"float", 0, floatNative, NULL},
+    {"range", 2, rangeNative, "nn"},
+    {"choice", 1, choiceNative, "l"},
+    {"shuffle", 1, shuffleNative, "l"},
+    {NULL, 0, NULL, NULL},  // Sentinel value
+};
+
+void registerRandNatives(VM* vm, ObjModule* module) {
+    defineNatives(vm, module, rand_functions);
+}
@@ -0,0 +1,11 @@
+#ifndef liss_modules_rand_h
+#define liss_modules_rand_h
+
+typedef struct VM VM;
+typedef struct ObjModule ObjModule;
+
+// Registers the natives of the rand module. They draw from the generator of
+// math:rand, which --seed, --deterministic and rand:seed make reproducible.
+void registerRandNatives(VM* vm, ObjModule* module);
+
+#endif
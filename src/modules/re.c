@@ -1,46 +1,99 @@
 #include "re.h"
 
+#include <stdlib.h>
+#include <string.h>
+
+#include "core.h"
 #include "object.h"
 #include "regex.h"
 #include "vm.h"
 
-static Value reNative(VM* vm, int argc, Value* argv) {
-    (void)argc;
-    if (!IS_STRING(argv[0])) {
-        return raiseErr(vm, "re:re expects a pattern string");
+// Returns the compiled regex for pattern, from the VM's cache when it was
+// compiled recently, or NULL if the pattern is invalid. A hit moves the
+// regex to the front; a miss adds it there and drops the least recently used
+// one if the cache is full.
+static ObjRe* cachedRe(VM* vm, ObjString* pattern) {
+    ValueArray* cache = &vm->re_cache;
+    for (int i = 0; i < cache->count; i++) {
+        Value re = cache->values[i];
+        if (valuesEqual(OBJ_VAL(AS_RE(re)->pattern), OBJ_VAL(pattern))) {
+            memmove(&cache->values[1], &cache->values[0], sizeof(Value) * i);
+            cache->values[0] = re;
+            return AS_RE(re);
+        }
     }
-    ObjString* pattern = AS_STRING(argv[0]);
-    ReProgram* prog = compilePattern(pattern->chars);
-    if (!prog) return raiseErr(vm, "Invalid regex pattern");
 
+    ReProgram* prog = compilePattern(pattern->chars);
+    if (prog == NULL) return NULL;
     ObjRe* re_obj = newRe(vm, pattern);
     re_obj->program = prog;
 
+    int size = vm->options.regex_cache_size;
+    if (size <= 0) return re_obj;
+    if (cache->count < size) {
+        push(vm, OBJ_VAL(re_obj));  // Growing the cache may collect
+        writeValueArray(vm, cache, NIL_VAL);
+        pop(vm);
+    }
+    memmove(&cache->values[1], &cache->values[0],
+            sizeof(Value) * (cache->count - 1));
+    cache->values[0] = OBJ_VAL(re_obj);
+    return re_obj;
+}
+
+// Tells whether a value can be the regex argument of a native: a compiled
+// regex or a pattern string.
+static bool isRegexArg(Value value) {
+    return IS_RE(value) || IS_STRING(value);
+}
+
+// Returns the regex argument of a native, compiling a pattern string through
+// the cache, or NULL if the pattern is invalid. The regex replaces the
+// pattern in the argument slot, which keeps it alive while the native runs
+// even if the cache is off.
+static ObjRe* regexArg(VM* vm, Value* arg) {
+    if (IS_STRING(*arg)) {
+        ObjRe* re = cachedRe(vm, AS_STRING(*arg));
+        if (re == NULL) return NULL;
+        *arg = OBJ_VAL(re);
+    }
+    return AS_RE(*arg);
+}
+
+static Value reNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0])) {
+        return raiseErr(vm, "re:re expects a pattern string");
+    }
+    ObjRe* re_obj = cachedRe(vm, AS_STRING(argv[0]));
+    if (re_obj == NULL) return raiseErr(vm, "Invalid regex pattern");
     return OBJ_VAL(re_obj);
 }
 
 static Value matchQuestNative(VM* vm, int argc, Value* argv) {
     (void)argc;
-    if (!IS_RE(argv[0]) || !IS_STRING(argv[1])) {
-        return raiseErr(vm, "re:match? expects a regex object and a string");
+    if (!isRegexArg(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "re:match? expects a regex and a string");
     }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
 
-    ObjRe* re_obj = AS_RE(argv[0]);
     const char* text = AS_CSTRING(argv[1]);
 
-    bool result = match((ReProgram*)re_obj->program, text);
+    bool result = match((ReProgram*)re->program, text);
     return BOOL_VAL(result);
 }
 
 static Value matchNative(VM* vm, int argc, Value* argv) {
     (void)argc;
-    if (!IS_RE(argv[0]) || !IS_STRING(argv[1])) {
-        return raiseErr(vm, "re:match expects a regex object and a string");
+    if (!isRegexArg(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "re:match expects a regex and a string");
     }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
 
-    ObjRe* re_obj = AS_RE(argv[0]);
     const char* text = AS_CSTRING(argv[1]);
-    ReProgram* prog = (ReProgram*)re_obj->program;
+    ReProgram* prog = (ReProgram*)re->program;
 
     const char* submatch[MAX_GROUPS * 2];
     bool result = matchGroups(prog, text, submatch);
@@ -74,13 +127,224 @@ static Value matchNative(VM* vm, int argc, Value* argv) {
     return OBJ_VAL(list);
 }
 
+// Returns the leftmost match as (index . matched-string), or null.
+static Value searchNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!isRegexArg(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "re:search expects a regex and a string");
+    }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
+
+    ReProgram* prog = (ReProgram*)re->program;
+    const char* text = AS_CSTRING(argv[1]);
+
+    const char* submatch[MAX_GROUPS * 2];
+    if (!matchGroups(prog, text, submatch)) return NIL_VAL;
+
+    ObjString* str =
+        copyString(vm, submatch[0], (int)(submatch[1] - submatch[0]));
+    push(vm, OBJ_VAL(str));
+    ObjPair* pair = newPair(vm, INT_VAL(submatch[0] - text), OBJ_VAL(str));
+    pop(vm);
+    return OBJ_VAL(pair);
+}
+
+typedef struct {
+    int cnt;
+    int cap;
+    const char** bounds;  // Start and end of every span
+} Spans;
+
+static void addSpan(Spans* spans, const char* start, const char* end) {
+    if (spans->cnt == spans->cap) {
+        spans->cap = spans->cap < 8 ? 8 : spans->cap * 2;
+        spans->bounds =
+            realloc(spans->bounds, sizeof(const char*) * 2 * spans->cap);
+    }
+    spans->bounds[2 * spans->cnt] = start;
+    spans->bounds[2 * spans->cnt + 1] = end;
+    spans->cnt++;
+}
+
+// Returns a list of the substrings the spans cover, in order, and frees them.
+static Value spansToList(VM* vm, Spans* spans) {
+    Value head = NIL_VAL;
+    push(vm, head);
+    for (int i = spans->cnt - 1; i >= 0; i--) {
+        const char* start = spans->bounds[2 * i];
+        int len = spans->bounds[2 * i + 1] - start;
+        Value str = OBJ_VAL(copyString(vm, start, len));
+        push(vm, str);
+        ObjPair* pair = newPair(vm, str, head);
+        pop(vm);
+
+        head = OBJ_VAL(pair);
+        *(vm->stack_top - 1) = head;
+    }
+    free(spans->bounds);
+
+    ObjList* list = newList(vm, spans->cnt, head);
+    pop(vm);
+    return OBJ_VAL(list);
+}
+
+// Returns a list of all non-overlapping matches, left to right.
+static Value findAllNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!isRegexArg(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "re:find_all expects a regex and a string");
+    }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
+
+    ReProgram* prog = (ReProgram*)re->program;
+    ObjString* subject = AS_STRING(argv[1]);
+    const char* text = subject->chars;
+
+    Spans spans = {0};
+    const char* from = text;
+    const char* submatch[MAX_GROUPS * 2];
+    while (from <= text + subject->length &&
+           matchGroupsFrom(prog, text, from, submatch)) {
+        addSpan(&spans, submatch[0], submatch[1]);
+        // An empty match must still make progress.
+        from = submatch[1] > submatch[0] ? submatch[1] : submatch[1] + 1;
+    }
+    return spansToList(vm, &spans);
+}
+
+// Returns the list of the pieces of the string between matches, so n matches
+// make n + 1 pieces. Empty matches do not split.
+static Value splitNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!isRegexArg(argv[0]) || !IS_STRING(argv[1])) {
+        return raiseErr(vm, "re:split expects a regex and a string");
+    }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
+
+    ReProgram* prog = (ReProgram*)re->program;
+    ObjString* subject = AS_STRING(argv[1]);
+    const char* text = subject->chars;
+    const char* end = text + subject->length;
+
+    Spans spans = {0};
+    const char* piece = text;
+    const char* from = text;
+    const char* submatch[MAX_GROUPS * 2];
+    while (from <= end && matchGroupsFrom(prog, text, from, submatch)) {
+        if (submatch[1] > submatch[0]) {
+            addSpan(&spans, piece, submatch[0]);
+            piece = from = submatch[1];
+        } else {
+            from = submatch[1] + 1;
+        }
+    }
+    addSpan(&spans, piece, end);
+    return spansToList(vm, &spans);
+}
+
+// Replaces every match in the string with repl. In repl, $0..$9 refer to the
+// match groups (an unmatched group expands to nothing) and $$ is a dollar.
+static Value replaceNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!isRegexArg(argv[0]) || !IS_STRING(argv[1]) || !IS_STRING(argv[2])) {
+        return raiseErr(vm, "re:replace expects a regex and two strings");
+    }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
+
+    ReProgram* prog = (ReProgram*)re->program;
+    ObjString* subject = AS_STRING(argv[1]);
+    ObjString* repl = AS_STRING(argv[2]);
+    const char* text = subject->chars;
+    const char* end = text + subject->length;
+
+    CharBuf out = {0};
+    charBufAppend(&out, "", 0);
+
+    const char* from = text;
+    const char* submatch[MAX_GROUPS * 2];
+    while (from <= end && matchGroupsFrom(prog, text, from, submatch)) {
+        charBufAppend(&out, from, (size_t)(submatch[0] - from));
+        for (int i = 0; i < repl->length; i++) {
+            char c = repl->chars[i];
+            if (c == '$' && i + 1 < repl->length) {
+                char d = repl->chars[i + 1];
+                if (d == '$') {
+                    charBufAppend(&out, "$", 1);
+                    i++;
+                    continue;
+                }
+                if (d >= '0' && d <= '9') {
+                    int g = d - '0';
+                    if (g < prog->num_grps && submatch[2 * g] != NULL &&
+                        submatch[2 * g + 1] != NULL) {
+                        charBufAppend(
+                            &out, submatch[2 * g],
+                            (size_t)(submatch[2 * g + 1] - submatch[2 * g]));
+                    }
+                    i++;
+                    continue;
+                }
+            }
+            charBufAppend(&out, &c, 1);
+        }
+        if (submatch[1] > submatch[0]) {
+            from = submatch[1];
+        } else {
+            // An empty match: copy one char through and move on.
+            if (submatch[1] < end) charBufAppend(&out, submatch[1], 1);
+            from = submatch[1] + 1;
+        }
+    }
+    if (from < end) charBufAppend(&out, from, (size_t)(end - from));
+
+    return OBJ_VAL(takeString(vm, out.chars, (int)out.len));
+}
+
+// Describes a compiled regex for debugging: a dict with its pattern, the
+// number of capture groups and the listing of its program.
+static Value inspectNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!isRegexArg(argv[0])) {
+        return raiseErr(vm, "re:inspect expects a regex");
+    }
+    ObjRe* re = regexArg(vm, &argv[0]);
+    if (re == NULL) return raiseErr(vm, "Invalid regex pattern");
+
+    ReProgram* prog = (ReProgram*)re->program;
+    push(vm, OBJ_VAL(newDict(vm)));
+    putField(vm, "pattern", OBJ_VAL(re->pattern));
+    // Group 0, the whole match, is not a capture group.
+    putField(vm, "groups", INT_VAL(prog->num_grps - 1));
+    char* listing = sprintProgram(prog);
+    putField(vm, "program",
+             OBJ_VAL(copyString(vm, listing, (int)strlen(listing))));
+    free(listing);
+    return pop(vm);
+}
+
 static const NativeReg re_functions[] = {
-    {"re", 1, reNative},
-    {"match?", 2, matchQuestNative},
-    {"match", 2, matchNative},
-    {NULL, 0, NULL},
+    {"re", 1, reNative, NULL},
+    {"match?", 2, matchQuestNative, NULL},
+    {"match", 2, matchNative, NULL},
+    {"search", 2, searchNative, NULL},
+    {"find_all", 2, findAllNative, NULL},
+    {"replace", 3, replaceNative, NULL},
+    {"split", 2, splitNative, NULL},
+    {"inspect", 1, inspectNative, NULL},
+    {NULL, 0, NULL, NULL},
+};
+
+// Names the natives had before they were renamed.
+static const AliasReg re_aliases[] = {
+    {"capture", "match"},
+    {NULL, NULL},
 };
 
 void registerRENatives(VM* vm, ObjModule* module) {
     defineNatives(vm, module, re_functions);
+    defineAliases(vm, module, re_aliases);
 }
@@ -1,10 +1,12 @@
 #include "str.h"
 
 #include <ctype.h>
+#include <errno.h>
 #include <stdlib.h>
 #include <string.h>
 
 #include "object.h"
+#include "utf8.h"
 #include "value.h"
 #include "vm.h"
 
@@ -121,7 +123,7 @@ static Value substrNative(VM* vm, int argc, Value* argv) {
         return NIL_VAL;
     }
     ObjString* s = AS_STRING(argv[0]);
-    int64_t start = AS_INT(argv[1]);
+    int64_t start = indexFromEnd(vm, AS_INT(argv[1]), s->length);
     int64_t len = AS_INT(argv[2]);
     if (start < 0 || start > s->length) {
         return OBJ_VAL(newError(vm, "substr: start out of bounds"));
@@ -321,18 +323,28 @@ static Value joinNative(VM* vm, int argc, Value* argv) {
 }
 
 static Value parseIntNative(VM* vm, int argc, Value* argv) {
-    (void)argc;
-    if (!IS_STRING(argv[0])) {
-        RUNTIME_ERR(vm, "parse_int expects a string");
+    if (argc < 1 || argc > 2 || !IS_STRING(argv[0])) {
+        RUNTIME_ERR(vm, "parse_int expects a string and an optional base");
         return NIL_VAL;
     }
+    int base = 10;
+    if (argc == 2) {
+        if (!IS_INT(argv[1]) || AS_INT(argv[1]) < 2 || AS_INT(argv[1]) > 36) {
+            RUNTIME_ERR(vm, "parse_int: base must be an int from 2 to 36");
+            return NIL_VAL;
+        }
+        base = (int)AS_INT(argv[1]);
+    }
     ObjString* s = AS_STRING(argv[0]);
     if (s->length == 0) return OBJ_VAL(newError(vm, "parse_int: empty string"));
 
     char* end;
-    long long val = strtoll(s->chars, &end, 10);
+    errno = 0;
+    long long val = strtoll(s->chars, &end, base);
     if (end != s->chars + s->length)
         return OBJ_VAL(newError(vm, "parse_int: invalid integer"));
+    if (errno == ERANGE)
+        return OBJ_VAL(newError(vm, "parse_int: integer out of range"));
     return INT_VAL((int64_t)val);
 }
 
@@ -352,22 +364,46 @@ static Value parseRealNative(VM* vm, int argc, Value* argv) {
     return REAL_VAL((double)val);
 }
 
+static Value compareNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0]) || !IS_STRING(argv[1])) {
+        RUNTIME_ERR(vm, "compare expects exactly 2 strings");
+        return NIL_VAL;
+    }
+    ObjString* a = AS_STRING(argv[0]);
+    ObjString* b = AS_STRING(argv[1]);
+    return INT_VAL(compareText(a->chars, a->length, b->chars, b->length));
+}
+
+static Value collateNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    if (!IS_STRING(argv[0]) || !IS_STRING(argv[1])) {
+        RUNTIME_ERR(vm, "collate expects exactly 2 strings");
+        return NIL_VAL;
+    }
+    ObjString* a = AS_STRING(argv[0]);
+    ObjString* b = AS_STRING(argv[1]);
+    return INT_VAL(collateText(a->chars, a->length, b->chars, b->length));
+}
+
 static const NativeReg str_functions[] = {
-    {"upper", 1, upperNative},
-    {"lower", 1, lowerNative},
-    {"trim", 1, trimNative},
-    {"contains?", 2, containsNative},
-    {"starts_with?", 2, startsWithNative},
-    {"ends_with?", 2, endsWithNative},
-    {"index_of", 2, indexOfNative},
-    {"substr", 3, substrNative},
-    {"replace", 3, replaceNative},
-    {"replace_all", 3, replaceAllNative},
-    {"split", 2, splitNative},
-    {"join", 2, joinNative},
-    {"parse_int", 1, parseIntNative},
-    {"parse_real", 1, parseRealNative},
-    {NULL, 0, NULL},
+    {"upper", 1, upperNative, "s"},
+    {"lower", 1, lowerNative, "s"},
+    {"trim", 1, trimNative, "s"},
+    {"contains?", 2, containsNative, "ss"},
+    {"starts_with?", 2, startsWithNative, "ss"},
+    {"ends_with?", 2, endsWithNative, "ss"},
+    {"index_of", 2, indexOfNative, "ss"},
+    {"substr", 3, substrNative, "sii"},
+    {"replace", 3, replaceNative, "sss"},
+    {"replace_all", 3, replaceAllNative, "sss"},
+    {"split", 2, splitNative, "ss"},
+    {"join", 2, joinNative, "ls"},
+    {"parse_int", -1, parseIntNative, "si"},
+    {"parse_real", 1, parseRealNative, "s"},
+    {"compare", 2, compareNative, "ss"},
+    {"collate", 2, collateNative, "ss"},
+    {NULL, 0, NULL, NULL},
 };
 
 void registerStrNatives(VM* vm, ObjModule* module) {
@@ -0,0 +1,39 @@
+#include "test.h"
+
+#include "object.h"
+#include "value.h"
+#include "vm.h"
+
+// Registers a test for `liss -test` to run once the file has loaded.
+static Value deftestNative(VM* vm, int argc, Value* argv) {
+    (void)argc;
+    bool callable = (IS_CLOSURE(argv[1]) &&
+                     AS_CLOSURE(argv[1])->function->min_arity == 0) ||
+                    IS_NATIVE(argv[1]);
+    if (!IS_STRING(argv[0]) || !callable) {
+        RUNTIME_ERR(vm, "deftest expects a name and a function of no "
+                        "arguments");
+        return NIL_VAL;
+    }
+    ObjPair* test = newPair(vm, argv[0], argv[1]);
+    push(vm, OBJ_VAL(test));
+    writeValueArray(vm, &vm->tests, OBJ_VAL(test));
+    pop(vm);
+    return NIL_VAL;
+}
+
+static const NativeReg test_functions[] = {
+    {"deftest", 2, deftestNative, NULL},
+    {NULL, 0, NULL, NULL},
+};
+
+static const DocReg test_docs[] = {
+    {"deftest", "(deftest name (fn [] ...))",
+     "Registers a test; it fails if it raises or returns an error."},
+    {NULL, NULL, NULL},
+};
+
+void registerTestNatives(VM* vm, ObjModule* module) {
+    defineNatives(vm, module, test_functions);
+    defineDocs(vm, module, test_docs);
+}
@@ -0,0 +1,9 @@
+#ifndef liss_modules_test_h
+#define liss_modules_test_h
+
+typedef struct VM VM;
+typedef struct ObjModule ObjModule;
+
+void registerTestNatives(VM* vm, ObjModule* module);
+
+#endif
@@ -1,5 +1,6 @@
 #include "object.h"
 
+#include <stddef.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
@@ -35,6 +36,7 @@ ObjError* newError(VM* vm, const char* message) {
     ObjError* error =
         (ObjError*)allocateObject(vm, sizeof(ObjError), OBJ_ERROR);
     error->message = msg_str;
+    error->trace = NULL;
     pop(vm);  // Pop after allocation
     return error;
 }
@@ -45,11 +47,17 @@ ObjFunction* newFunction(VM* vm, ObjModule* module) {
     ObjFunction* function =
         (ObjFunction*)allocateObject(vm, sizeof(ObjFunction), OBJ_FUNCTION);
     function->arity = 0;
+    function->min_arity = 0;
     function->upvalue_cnt = 0;
     function->name = NULL;
     initChunk(vm, &function->chunk);
     function->loaded_code = NULL;
     function->loaded_code_size = 0;
+    function->code_offsets = NULL;
+    function->call_cnt = 0;
+    function->usage = NULL;
+    function->doc = NULL;
+    function->params = NULL;
     function->module = module;
     return function;
 }
@@ -78,6 +86,11 @@ ObjNative* newNative(VM* vm, const char* name, int arity, NativeFn function) {
     native->name = AS_STRING(pop(vm));
     native->arity = arity;
     native->function = function;
+    native->params = NULL;
+    native->deprecated = NULL;
+    native->deprecation_warned = false;
+    native->usage = NULL;
+    native->doc = NULL;
     return native;
 }
 
@@ -95,9 +108,85 @@ ObjList* newList(VM* vm, uint32_t len, Value head) {
     ObjList* list = (ObjList*)allocateObject(vm, sizeof(ObjList), OBJ_LIST);
     list->len = len;
     list->head = pop(vm);
+    list->shared = false;
+    list->last = NULL;
+    list->frozen = false;
     return list;
 }
 
+ObjTuple* newTuple(VM* vm, uint32_t len) {
+    ObjTuple* tuple = (ObjTuple*)allocateObject(
+        vm, sizeof(ObjTuple) + sizeof(Value) * len, OBJ_TUPLE);
+    tuple->len = len;
+    for (uint32_t i = 0; i < len; i++) tuple->items[i] = NIL_VAL;
+    return tuple;
+}
+
+ObjBytes* newBytes(VM* vm, const uint8_t* data, uint32_t len) {
+    ObjBytes* bytes =
+        (ObjBytes*)allocateObject(vm, sizeof(ObjBytes) + len, OBJ_BYTES);
+    bytes->len = len;
+    if (len > 0) memcpy(bytes->data, data, len);
+    return bytes;
+}
+
+ObjBuilder* newBuilder(VM* vm) {
+    ObjBuilder* builder =
+        (ObjBuilder*)allocateObject(vm, sizeof(ObjBuilder), OBJ_BUILDER);
+    builder->chars = NULL;
+    builder->length = 0;
+    builder->capacity = 0;
+    builder->str = NULL;
+    return builder;
+}
+
+void builderAppend(VM* vm, ObjBuilder* builder, const char* chars,
+                   int length) {
+    if (length == 0) return;
+    if (builder->length + length > builder->capacity) {
+        int old_capacity = builder->capacity;
+        int capacity = GROW_CAPACITY(old_capacity);
+        while (capacity < builder->length + length) capacity *= 2;
+        builder->chars = GROW_ARRAY(char, vm, builder->chars, old_capacity,
+                                    capacity);
+        builder->capacity = capacity;
+    }
+    memcpy(builder->chars + builder->length, chars, (size_t)length);
+    builder->length += length;
+    builder->str = NULL;
+}
+
+ObjString* builderString(VM* vm, ObjBuilder* builder) {
+    if (builder->str == NULL) {
+        builder->str = copyString(vm, builder->chars != NULL ? builder->chars
+                                                             : "",
+                                  builder->length);
+    }
+    return builder->str;
+}
+
+static int hexDigit(char c) {
+    if (c >= '0' && c <= '9') return c - '0';
+    if (c >= 'a' && c <= 'f') return c - 'a' + 10;
+    if (c >= 'A' && c <= 'F') return c - 'A' + 10;
+    return -1;
+}
+
+ObjBytes* bytesFromHex(VM* vm, const char* hex, int len) {
+    if (len % 2 != 0) return NULL;
+    for (int i = 0; i < len; i++) {
+        if (hexDigit(hex[i]) < 0) return NULL;
+    }
+    ObjBytes* bytes = (ObjBytes*)allocateObject(
+        vm, sizeof(ObjBytes) + len / 2, OBJ_BYTES);
+    bytes->len = (uint32_t)(len / 2);
+    for (int i = 0; i < len; i += 2) {
+        bytes->data[i / 2] =
+            (uint8_t)(hexDigit(hex[i]) << 4 | hexDigit(hex[i + 1]));
+    }
+    return bytes;
+}
+
 ObjPair* newPair(VM* vm, Value first, Value second) {
     push(vm, first);
     push(vm, second);
@@ -110,6 +199,7 @@ ObjPair* newPair(VM* vm, Value first, Value second) {
 ObjDict* newDict(VM* vm) {
     ObjDict* dict = (ObjDict*)allocateObject(vm, sizeof(ObjDict), OBJ_DICT);
     dict->count = 0;
+    dict->next_seq = 0;
     dict->root = NULL;
     return dict;
 }
@@ -122,16 +212,85 @@ ObjModule* newModule(VM* vm, const char* name) {
     module->name = AS_STRING(pop(vm));
     initTableWithCapacity(&module->symbols, MAX_MODULE_SYMBOLS);
     initTableWithCapacity(&module->imports, 64);
+    initTable(&module->import_from);
+    initTable(&module->consts);
+    module->lang_version = vm->options.lang_version;
     return module;
 }
 
-ObjFile* newFile(VM* vm, FILE* file) {
+ObjModule* copyModule(VM* vm, ObjModule* module) {
+    ObjModule* copy =
+        (ObjModule*)allocateObject(vm, sizeof(ObjModule), OBJ_MODULE);
+    copy->name = module->name;
+    initTableWithCapacity(&copy->symbols, MAX_MODULE_SYMBOLS);
+    initTableWithCapacity(&copy->imports, 64);
+    initTable(&copy->import_from);
+    initTable(&copy->consts);
+    tableAddAll(&module->symbols, &copy->symbols);
+    tableAddAll(&module->imports, &copy->imports);
+    tableAddAll(&module->import_from, &copy->import_from);
+    tableAddAll(&module->consts, &copy->consts);
+    copy->lang_version = module->lang_version;
+    return copy;
+}
+
+static void closeFileResource(Resource* resource) {
+    ObjFile* file =
+        (ObjFile*)((char*)resource - offsetof(ObjFile, resource));
+    if (!file->is_closed) {
+        fclose(file->file);
+        file->is_closed = true;
+    }
+}
+
+ObjFile* newFile(VM* vm, FILE* file, const char* name, bool owned) {
     ObjFile* file_obj = (ObjFile*)allocateObject(vm, sizeof(ObjFile), OBJ_FILE);
     file_obj->file = file;
     file_obj->is_closed = false;
+    file_obj->binary = false;
+    trackResource(vm, &file_obj->resource, "file", name, owned,
+                  closeFileResource);
     return file_obj;
 }
 
+void trackResource(VM* vm, Resource* resource, const char* kind,
+                   const char* name, bool owned,
+                   void (*close)(Resource* resource)) {
+    resource->id = ++vm->resource_id;
+    resource->kind = kind;
+    resource->name = strdup(name);
+    resource->owned = owned;
+    resource->close = close;
+    resource->prev = NULL;
+    resource->next = vm->resources;
+    if (vm->resources != NULL) vm->resources->prev = resource;
+    vm->resources = resource;
+}
+
+void untrackResource(VM* vm, Resource* resource) {
+    if (resource->name == NULL) return;  // Already untracked
+    if (resource->prev != NULL) {
+        resource->prev->next = resource->next;
+    } else {
+        vm->resources = resource->next;
+    }
+    if (resource->next != NULL) resource->next->prev = resource->prev;
+    resource->prev = NULL;
+    resource->next = NULL;
+    free(resource->name);
+    resource->name = NULL;
+}
+
+void releaseResources(VM* vm) {
+    Resource* resource = vm->resources;
+    while (resource != NULL) {
+        Resource* next = resource->next;
+        if (resource->owned) resource->close(resource);
+        untrackResource(vm, resource);
+        resource = next;
+    }
+}
+
 ObjRe* newRe(VM* vm, ObjString* pattern) {
     ObjString* pattern_cp = copyString(vm, pattern->chars, pattern->length);
     push(vm, OBJ_VAL(pattern_cp));
@@ -194,8 +353,8 @@ Value raiseErr(VM* vm, const char* message) {
     return NIL_VAL;
 }
 
-void defineNative(VM* vm, ObjModule* module, const char* name, int arity,
-                  NativeFn function) {
+ObjNative* defineNative(VM* vm, ObjModule* module, const char* name,
+                        int arity, NativeFn function) {
     ObjString* str = copyString(vm, name, (int)strlen(name));
     push(vm, OBJ_VAL(str));
     ObjNative* native = newNative(vm, name, arity, function);
@@ -203,20 +362,77 @@ void defineNative(VM* vm, ObjModule* module, const char* name, int arity,
     tableInsert(&module->symbols, OBJ_VAL(str), OBJ_VAL(native));
     pop(vm);
     pop(vm);
+    return native;
 }
 
 void defineNatives(VM* vm, ObjModule* module, const NativeReg* registry) {
     for (int i = 0; registry[i].name != NULL; i++) {
-        defineNative(vm, module, registry[i].name, registry[i].arity,
-                     registry[i].fn);
+        ObjNative* native = defineNative(vm, module, registry[i].name,
+                                         registry[i].arity, registry[i].fn);
+        native->params = registry[i].params;
     }
 }
 
 void defineConst(VM* vm, ObjModule* module, const char* name, Value value) {
+    // The value may be new, so it is rooted before the name is allocated.
+    push(vm, value);
     ObjString* name_obj = copyString(vm, name, (int)strlen(name));
     push(vm, OBJ_VAL(name_obj));
-    push(vm, value);
     tableInsert(&module->symbols, OBJ_VAL(name_obj), value);
-    pop(vm);  // pop value
     pop(vm);  // pop name_obj
+    pop(vm);  // pop value
+}
+
+void defineAliases(VM* vm, ObjModule* module, const AliasReg* registry) {
+    for (int i = 0; registry[i].alias != NULL; i++) {
+        ObjString* name = copyString(vm, registry[i].name,
+                                     (int)strlen(registry[i].name));
+        Value* target = tableGet(&module->symbols, OBJ_VAL(name));
+        if (target == NULL || !IS_NATIVE(*target)) continue;
+        ObjNative* canonical = AS_NATIVE(*target);
+        ObjNative* alias = defineNative(vm, module, registry[i].alias,
+                                        canonical->arity, canonical->function);
+        alias->params = canonical->params;
+        alias->usage = canonical->usage;
+        alias->doc = canonical->doc;
+        alias->deprecated = registry[i].name;
+    }
+}
+
+void defineDocs(VM* vm, ObjModule* module, const DocReg* registry) {
+    for (int i = 0; registry[i].name != NULL; i++) {
+        ObjString* name = copyString(vm, registry[i].name,
+                                     (int)strlen(registry[i].name));
+        Value* target = tableGet(&module->symbols, OBJ_VAL(name));
+        if (target == NULL || !IS_NATIVE(*target)) continue;
+        AS_NATIVE(*target)->usage = registry[i].usage;
+        AS_NATIVE(*target)->doc = registry[i].doc;
+    }
+}
+
+bool functionDocs(Value value, const char** usage, const char** doc) {
+    *usage = NULL;
+    *doc = NULL;
+    if (IS_CLOSURE(value)) value = OBJ_VAL(AS_CLOSURE(value)->function);
+    if (IS_FUNCTION(value)) {
+        ObjFunction* function = AS_FUNCTION(value);
+        if (function->usage != NULL) *usage = function->usage->chars;
+        if (function->doc != NULL) *doc = function->doc->chars;
+        return true;
+    }
+    if (IS_NATIVE(value)) {
+        *usage = AS_NATIVE(value)->usage;
+        *doc = AS_NATIVE(value)->doc;
+        return true;
+    }
+    return false;
+}
+
+bool deprecateNative(VM* vm, ObjModule* module, const char* name,
+                     const char* replacement) {
+    ObjString* name_obj = copyString(vm, name, (int)strlen(name));
+    Value* value = tableGet(&module->symbols, OBJ_VAL(name_obj));
+    if (value == NULL || !IS_NATIVE(*value)) return false;
+    AS_NATIVE(*value)->deprecated = replacement;
+    return true;
 }
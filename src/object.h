@@ -29,9 +29,12 @@ typedef enum {
     OBJ_PAIR,
     OBJ_DICT,
     OBJ_LIST,
+    OBJ_TUPLE,
+    OBJ_BYTES,
     OBJ_MODULE,
     OBJ_FILE,
     OBJ_RE,
+    OBJ_BUILDER,
     OBJ_HAMT_NODE,
 } ObjType;
 
@@ -44,9 +47,10 @@ struct Obj {
 };
 
 // --- Function Object ---
-typedef struct {
+typedef struct ObjFunction {
     Obj obj;
     int arity;
+    int min_arity;  // Parameters before the first one with a default
     int upvalue_cnt;
     Chunk chunk;
     ObjString* name;
@@ -54,6 +58,11 @@ typedef struct {
         module;  // The module this function belongs to (for error reporting)
     void** loaded_code;
     size_t loaded_code_size;
+    int* code_offsets;  // Chunk offset of the instruction of each loaded slot
+    uint64_t call_cnt;  // Number of times the function has been entered
+    ObjString* usage;   // How to call it, e.g. "(fib n)"
+    ObjString* doc;     // Docstring from the function body, NULL if none
+    struct ObjTuple* params;  // Parameter names, for keyword arguments
 } ObjFunction;
 
 // --- String Object ---
@@ -85,6 +94,7 @@ typedef struct ObjClosure {
 typedef struct ObjError {
     Obj obj;
     ObjString* message;
+    ObjString* trace;  // Frames it was raised in, innermost first, or NULL
 } ObjError;
 
 typedef struct ObjNative {
@@ -94,6 +104,17 @@ typedef struct ObjNative {
     ObjString* name;
     int arity;  // -1 for variadic functions
     NativeFn function;
+    // Optional argument kinds, one char per positional argument, used by the
+    // compiler to reject obvious literal mismatches: 'n' int or real, 'i' int,
+    // 's' string, 'l' list, 'd' dict, 'c' string, list, tuple or dict, '.'
+    // anything.
+    const char* params;
+    // Name of the replacement if this native is deprecated, NULL otherwise.
+    // Calls to it make the compiler print a warning.
+    const char* deprecated;
+    bool deprecation_warned;  // Set once the warning was shown
+    const char* usage;        // How to call it, e.g. "(len coll)", or NULL
+    const char* doc;          // One-line description, or NULL
 } ObjNative;
 
 typedef struct ObjPair {
@@ -105,6 +126,7 @@ typedef struct ObjPair {
 typedef struct ObjDict {
     Obj obj;
     uint32_t count;
+    int64_t next_seq;  // insertion order given to the next new key
     HamtNode* root;
 } ObjDict;
 
@@ -112,25 +134,89 @@ typedef struct ObjList {
     Obj obj;
     uint32_t len;
     Value head;
+    // Set once another list may reach pairs of the spine, as after tail or
+    // cons. The in-place builtins copy a shared spine before changing it.
+    bool shared;
+    ObjPair* last;  // Cached last pair of an unshared spine, NULL if unknown
+    bool frozen;    // A dict key copy that the in-place builtins refuse
 } ObjList;
 
+// An immutable sequence, #[1 2 3]. Nothing changes the items once the tuple
+// is built, so it can be shared freely and used as a dict key as it is.
+typedef struct ObjTuple {
+    Obj obj;
+    uint32_t len;
+    Value items[];
+} ObjTuple;
+
+// Raw bytes, such as the contents of a binary file. Unlike a string they are
+// not text: nothing stops at a zero byte, and they print as hex. Immutable.
+typedef struct ObjBytes {
+    Obj obj;
+    uint32_t len;
+    uint8_t data[];
+} ObjBytes;
+
+// Text being built up by sb_append!, in a buffer that grows by doubling, so a
+// long string is made in linear time rather than by concatenating ever longer
+// ones. Mutable.
+typedef struct ObjBuilder {
+    Obj obj;
+    char* chars;
+    int length;
+    int capacity;
+    ObjString* str;  // What sb_str last made of it, NULL once it has grown
+} ObjBuilder;
+
 typedef struct ObjModule {
     Obj obj;
     ObjString* name;
     Table symbols;
     Table imports;
+    Table import_from;  // Imported names, to the module they come from
+    Table consts;  // Names bound by const, to their inlined values
+    int lang_version;  // See LANG_VERSION_LATEST
 } ObjModule;
 
 typedef struct {
     const char* name;
     int arity;
     NativeFn fn;
+    const char* params;  // see ObjNative.params, NULL if unchecked
 } NativeReg;
 
+// Maps an old name of a native to its current one. See defineAliases.
+typedef struct {
+    const char* alias;
+    const char* name;
+} AliasReg;
+
+// Usage and description of a native, shown by (doc f) and the REPL.
+typedef struct {
+    const char* name;
+    const char* usage;
+    const char* doc;
+} DocReg;
+
+// Bookkeeping for objects that hold an OS handle. Live resources are linked
+// into VM.resources, so they can be listed with (resources) and released when
+// the VM shuts down.
+typedef struct Resource {
+    int id;
+    const char* kind;  // "file", ...
+    char* name;        // Where the handle came from, e.g. a path. Owned
+    bool owned;        // False for handles the VM didn't open, like stdout
+    void (*close)(struct Resource* resource);
+    struct Resource* prev;
+    struct Resource* next;
+} Resource;
+
 typedef struct {
     Obj obj;
+    Resource resource;
     FILE* file;
     bool is_closed;
+    bool binary;  // Opened with a "b" mode, so reads give bytes
 } ObjFile;
 
 typedef struct {
@@ -157,11 +243,14 @@ static inline bool isObjType(Value value, ObjType type) {
 #define IS_ERROR(value) isObjType(value, OBJ_ERROR)
 #define IS_NATIVE(value) isObjType(value, OBJ_NATIVE)
 #define IS_LIST(value) isObjType(value, OBJ_LIST)
+#define IS_TUPLE(value) isObjType(value, OBJ_TUPLE)
+#define IS_BYTES(value) isObjType(value, OBJ_BYTES)
 #define IS_PAIR(value) isObjType(value, OBJ_PAIR)
 #define IS_DICT(value) isObjType(value, OBJ_DICT)
 #define IS_MODULE(value) isObjType(value, OBJ_MODULE)
 #define IS_FILE(value) isObjType(value, OBJ_FILE)
 #define IS_RE(value) isObjType(value, OBJ_RE)
+#define IS_BUILDER(value) isObjType(value, OBJ_BUILDER)
 
 // Macros for casting a Value to a specific object type pointer.
 #define AS_FUNCTION(value) ((ObjFunction*)AS_OBJ(value))
@@ -172,11 +261,14 @@ static inline bool isObjType(Value value, ObjType type) {
 #define AS_ERROR(value) ((ObjError*)AS_OBJ(value))
 #define AS_NATIVE(value) ((ObjNative*)AS_OBJ(value))
 #define AS_LIST(value) ((ObjList*)AS_OBJ(value))
+#define AS_TUPLE(value) ((ObjTuple*)AS_OBJ(value))
+#define AS_BYTES(value) ((ObjBytes*)AS_OBJ(value))
 #define AS_PAIR(value) ((ObjPair*)AS_OBJ(value))
 #define AS_DICT(value) ((ObjDict*)AS_OBJ(value))
 #define AS_MODULE(value) ((ObjModule*)AS_OBJ(value))
 #define AS_FILE(value) ((ObjFile*)AS_OBJ(value))
 #define AS_RE(value) ((ObjRe*)AS_OBJ(value))
+#define AS_BUILDER(value) ((ObjBuilder*)AS_OBJ(value))
 
 // Helper function to compute the hash of a string.
 uint32_t hashString(const char* key, int length);
@@ -189,10 +281,44 @@ ObjUpvalue* newUpvalue(VM* vm, Value* slot);
 ObjError* newError(VM* vm, const char* message);
 ObjNative* newNative(VM* vm, const char* name, int arity, NativeFn function);
 ObjList* newList(VM* vm, uint32_t len, Value head);
+// The items start out null; the caller fills them in before the tuple is
+// seen by anything else.
+ObjTuple* newTuple(VM* vm, uint32_t len);
+// Copies len bytes from data, which may be NULL when len is 0.
+ObjBytes* newBytes(VM* vm, const uint8_t* data, uint32_t len);
+// Decodes a string of hex digit pairs, such as "ff00", or returns NULL if it
+// is not one.
+ObjBytes* bytesFromHex(VM* vm, const char* hex, int len);
+// An empty string builder.
+ObjBuilder* newBuilder(VM* vm);
+// Adds length chars to the end of builder, which must be reachable by the GC.
+void builderAppend(VM* vm, ObjBuilder* builder, const char* chars,
+                   int length);
+// The text of builder as a string, made once for every state it is in.
+ObjString* builderString(VM* vm, ObjBuilder* builder);
 ObjPair* newPair(VM* vm, Value first, Value second);
 ObjDict* newDict(VM* vm);
 ObjModule* newModule(VM* vm, const char* name);
-ObjFile* newFile(VM* vm, FILE* file);
+// Returns a module with the globals, consts and imports module has now, under
+// the same name but not registered with the VM. A script run in the copy sees
+// those definitions and may redefine them, but what it defines stays in the
+// copy: module and the functions defined in it are left as they were.
+ObjModule* copyModule(VM* vm, ObjModule* module);
+// Wraps an open FILE. Files the VM doesn't own are never closed by it, only
+// by an explicit io:close.
+ObjFile* newFile(VM* vm, FILE* file, const char* name, bool owned);
+
+// Assigns the next id to a resource and links it into VM.resources. close
+// releases the handle; it is called on shutdown for owned resources only.
+void trackResource(VM* vm, Resource* resource, const char* kind,
+                   const char* name, bool owned,
+                   void (*close)(Resource* resource));
+
+// Unlinks a resource once its handle is released. Safe to call twice.
+void untrackResource(VM* vm, Resource* resource);
+
+// Closes every owned resource that is still open.
+void releaseResources(VM* vm);
 ObjRe* newRe(VM* vm, ObjString* pattern);
 
 // Allocates an ObjString on the heap and returns a pointer to it.
@@ -202,13 +328,33 @@ ObjString* takeString(VM* vm, char* chars, int length);
 ObjString* copyString(VM* vm, const char* chars, int length);
 
 // Registers a native function with the VM
-void defineNative(VM* vm, ObjModule* module, const char* name, int arity,
-                  NativeFn function);
+ObjNative* defineNative(VM* vm, ObjModule* module, const char* name,
+                        int arity, NativeFn function);
 
 void defineNatives(VM* vm, ObjModule* module, const NativeReg* registry);
 
 void defineConst(VM* vm, ObjModule* module, const char* name, Value value);
 
+// Registers old names of renamed natives so existing scripts keep running.
+// Each alias behaves like the native it points to, but calling it through the
+// old name triggers a deprecation warning. Must run after defineNatives.
+void defineAliases(VM* vm, ObjModule* module, const AliasReg* registry);
+
+// Attaches usage lines and descriptions to natives of the module. Names the
+// module doesn't define are skipped. Must run before defineAliases so aliases
+// share the docs of the natives they point to.
+void defineDocs(VM* vm, ObjModule* module, const DocReg* registry);
+
+// Finds how to call a function, closure or native and its docstring, as
+// (doc f) returns them. Either is NULL if there is none. Returns false if
+// value is none of those.
+bool functionDocs(Value value, const char** usage, const char** doc);
+
+// Marks a native of the module as deprecated in favour of replacement.
+// Returns false if the module has no native with that name.
+bool deprecateNative(VM* vm, ObjModule* module, const char* name,
+                     const char* replacement);
+
 // A helper to create an error and set it as the current raise value
 Value raiseErr(VM* vm, const char* message);
 
@@ -21,8 +21,22 @@ const char* opcodeToString(OpCode opcode) {
             return "OP_MULTIPLY";
         case OP_DIVIDE:
             return "OP_DIVIDE";
+        case OP_MODULO:
+            return "OP_MODULO";
         case OP_NEGATE:
             return "OP_NEGATE";
+        case OP_BAND:
+            return "OP_BAND";
+        case OP_BOR:
+            return "OP_BOR";
+        case OP_BXOR:
+            return "OP_BXOR";
+        case OP_BNOT:
+            return "OP_BNOT";
+        case OP_LSHIFT:
+            return "OP_LSHIFT";
+        case OP_RSHIFT:
+            return "OP_RSHIFT";
         case OP_TRUE:
             return "OP_TRUE";
         case OP_FALSE:
@@ -55,12 +69,56 @@ const char* opcodeToString(OpCode opcode) {
             return "OP_SET_UPVALUE";
         case OP_TAIL_CALL:
             return "OP_TAIL_CALL";
+        case OP_TRY_START:
+            return "OP_TRY_START";
+        case OP_TRY_END:
+            return "OP_TRY_END";
         case OP_LIST:
             return "OP_LIST";
         case OP_PAIR:
             return "OP_PAIR";
         case OP_GET_MODULE_GLOBAL:
             return "OP_GET_MODULE_GLOBAL";
+        case OP_DUP:
+            return "OP_DUP";
+        case OP_IS_ERROR:
+            return "OP_IS_ERROR";
+        case OP_ERROR_MSG:
+            return "OP_ERROR_MSG";
+        case OP_IS_PAIR:
+            return "OP_IS_PAIR";
+        case OP_UNPACK_PAIR:
+            return "OP_UNPACK_PAIR";
+        case OP_UNPACK:
+            return "OP_UNPACK";
+        case OP_ARG_MISSING:
+            return "OP_ARG_MISSING";
+        case OP_CALL_KW:
+            return "OP_CALL_KW";
+        case OP_TAIL_CALL_KW:
+            return "OP_TAIL_CALL_KW";
+        case OP_SLIDE:
+            return "OP_SLIDE";
+        case OP_SWAP:
+            return "OP_SWAP";
+        case OP_JUMP_IF_ERR:
+            return "OP_JUMP_IF_ERR";
+        case OP_APPROX_EQUAL:
+            return "OP_APPROX_EQUAL";
+        case OP_ACCESS:
+            return "OP_ACCESS";
+        case OP_SWITCH_TABLE:
+            return "OP_SWITCH_TABLE";
+        case OP_TUPLE:
+            return "OP_TUPLE";
+        case OP_LIST_APPEND:
+            return "OP_LIST_APPEND";
+        case OP_LOOP:
+            return "OP_LOOP";
+        case OP_FOR_INIT:
+            return "OP_FOR_INIT";
+        case OP_FOR_NEXT:
+            return "OP_FOR_NEXT";
         default:
             return "UNKNOWN_OPCODE";
     }
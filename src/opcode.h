@@ -54,10 +54,26 @@ typedef enum {
     OP_ERROR_MSG,
     OP_IS_PAIR,
     OP_UNPACK_PAIR,
+    OP_UNPACK,
+    OP_ARG_MISSING,
+    OP_CALL_KW,
+    OP_TAIL_CALL_KW,
     OP_SLIDE,
 
     OP_SWAP,
     OP_JUMP_IF_ERR,
+    OP_APPROX_EQUAL,
+    OP_ACCESS,
+    OP_SWITCH_TABLE,
+    OP_TUPLE,
+    OP_LIST_APPEND,
+    OP_LOOP,
+    OP_FOR_INIT,
+    OP_FOR_NEXT,
+
+    OPCODE_CNT,  // Not an opcode: the number of opcodes. Keep it last.
 } OpCode;
 
+const char* opcodeToString(OpCode opcode);
+
 #endif
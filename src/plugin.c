@@ -0,0 +1,44 @@
+#include "plugin.h"
+
+#include <dlfcn.h>
+#include <stdlib.h>
+
+#include "common.h"
+
+bool loadPlugin(VM* vm, const char* path, ObjModule* module) {
+    void* handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+    if (handle == NULL) {
+        RUNTIME_ERR(vm, "Could not open plugin %s: %s", path, dlerror());
+        return false;
+    }
+    // ISO C has no cast from an object pointer to a function pointer, POSIX
+    // guarantees this one works.
+    PluginLoader loader;
+    *(void**)(&loader) = dlsym(handle, LISS_PLUGIN_ENTRY);
+    if (loader == NULL) {
+        dlclose(handle);
+        RUNTIME_ERR(vm, "Plugin %s has no %s function", path,
+                    LISS_PLUGIN_ENTRY);
+        return false;
+    }
+
+    if (vm->plugin_cnt == vm->plugin_cap) {
+        vm->plugin_cap = vm->plugin_cap < 4 ? 4 : vm->plugin_cap * 2;
+        vm->plugins =
+            realloc(vm->plugins, sizeof(void*) * (size_t)vm->plugin_cap);
+        if (vm->plugins == NULL) exit(1);
+    }
+    vm->plugins[vm->plugin_cnt++] = handle;
+    loader(vm, module);
+    return true;
+}
+
+void closePlugins(VM* vm) {
+    for (int i = 0; i < vm->plugin_cnt; i++) {
+        dlclose(vm->plugins[i]);
+    }
+    free(vm->plugins);
+    vm->plugins = NULL;
+    vm->plugin_cnt = 0;
+    vm->plugin_cap = 0;
+}
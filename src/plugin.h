@@ -0,0 +1,26 @@
+#ifndef liss_plugin_h
+#define liss_plugin_h
+
+#include "object.h"
+#include "vm.h"
+
+// Extension of the shared libraries imported as native modules.
+#define LISS_PLUGIN_EXT ".so"
+
+// Name of the function a plugin exports to define the natives of its module,
+// with the signature of PluginLoader.
+#define LISS_PLUGIN_ENTRY "liss_module"
+
+// Defines the natives of a plugin in module, like the loaders of the modules
+// built into the interpreter, e.g. with defineNatives.
+typedef void (*PluginLoader)(VM* vm, ObjModule* module);
+
+// Opens the shared library at path and calls its liss_module to fill module.
+// Returns false and raises an error if it cannot be opened or has no
+// liss_module. The library stays open until destroyVM.
+bool loadPlugin(VM* vm, const char* path, ObjModule* module);
+
+// Closes the plugins vm opened. Their natives must be freed by then.
+void closePlugins(VM* vm);
+
+#endif
@@ -1,9 +1,12 @@
 #include "regex.h"
 
 #include <ctype.h>
+#include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 
+#include "value.h"
+
 typedef struct PtrList PtrList;
 struct PtrList {
     int* field;
@@ -41,6 +44,14 @@ static void patch(PtrList* list, int target) {
     }
 }
 
+static void freeList(PtrList* list) {
+    PtrList* next;
+    for (; list; list = next) {
+        next = list->next;
+        free(list);
+    }
+}
+
 static PtrList* append(PtrList* list1, PtrList* list2) {
     if (!list1) return list2;
     PtrList* tmp = list1;
@@ -54,6 +65,9 @@ static int getPrecedence(char op) {
         case '*':
         case '+':
         case '?':
+        case RE_LAZY_STAR:
+        case RE_LAZY_PLUS:
+        case RE_LAZY_QUEST:
             return 3;
         case '@':
             return 2;
@@ -73,6 +87,12 @@ char* addConcat(const char* re) {
         char c1 = re[i];
         char emit;
 
+        if (c1 == '(' && i + 2 < len && re[i + 1] == '?' && re[i + 2] == ':') {
+            res[j++] = RE_NONCAP_OPEN;
+            i += 2;
+            continue;
+        }
+
         if (c1 == '\\' && i + 1 < len) {
             switch (re[i + 1]) {
                 case 'd':
@@ -107,6 +127,12 @@ char* addConcat(const char* re) {
                     emit = c1;
                     break;
             }
+        } else if (strchr("*+?", c1) != NULL && i + 1 < len &&
+                   re[i + 1] == '?') {
+            emit = c1 == '*'   ? RE_LAZY_STAR
+                   : c1 == '+' ? RE_LAZY_PLUS
+                               : RE_LAZY_QUEST;
+            i++;
         } else {
             emit = c1;
         }
@@ -154,6 +180,10 @@ char* infixToPostfix(const char* infix) {
                 }
                 stack[++top] = c;
                 break;
+            case RE_NONCAP_OPEN:
+                group_stack[++group_stack_top] = 0;
+                stack[++top] = '(';
+                break;
             case ')':
                 while (top >= 0 && stack[top] != '(') {
                     postfix[j++] = stack[top--];
@@ -174,6 +204,9 @@ char* infixToPostfix(const char* infix) {
             case '*':
             case '+':
             case '?':
+            case RE_LAZY_STAR:
+            case RE_LAZY_PLUS:
+            case RE_LAZY_QUEST:
             case '@':
                 while (top >= 0 && stack[top] != '(' &&
                        getPrecedence(stack[top]) >= getPrecedence(c)) {
@@ -197,68 +230,301 @@ char* infixToPostfix(const char* infix) {
     return postfix;
 }
 
+static void setCharBit(ReCharset* cs, unsigned char c) {
+    cs->bits[c / 8] |= 1u << (c % 8);
+}
+
+static unsigned char unescapeChar(char c) {
+    switch (c) {
+        case 't':
+            return '\t';
+        case 'n':
+            return '\n';
+        case 'r':
+            return '\r';
+        default:
+            return (unsigned char)c;
+    }
+}
+
+// Adds the members of an escape class (\d, \w, \s and their negations) to
+// cs. Returns false if esc does not name a class.
+static bool addEscapeClass(ReCharset* cs, char esc) {
+    bool negate = isupper((unsigned char)esc);
+    for (int c = 1; c < 256; c++) {
+        bool in;
+        switch (tolower((unsigned char)esc)) {
+            case 'd':
+                in = isdigit(c);
+                break;
+            case 'w':
+                in = isalnum(c) || c == '_';
+                break;
+            case 's':
+                in = isspace(c);
+                break;
+            default:
+                return false;
+        }
+        if (in != negate) setCharBit(cs, (unsigned char)c);
+    }
+    return true;
+}
+
+// Stores cs in prog->charsets, reusing an identical set if there is one.
+// Returns the charset index or -1 if the program ran out of charsets.
+static int internCharset(ReProgram* prog, const ReCharset* cs) {
+    for (int i = 0; i < prog->num_charsets; i++) {
+        if (memcmp(prog->charsets[i].bits, cs->bits, sizeof(cs->bits)) == 0) {
+            return i;
+        }
+    }
+    if (prog->num_charsets >= MAX_CHARSETS) return -1;
+    prog->charsets[prog->num_charsets] = *cs;
+    return prog->num_charsets++;
+}
+
 // Replaces each [...] in re with a sentinel byte (128 + charset_index),
-// parsing the charset bitmap into prog->charsets.  Returns a malloc'd string
-// the caller must free; returns NULL on parse error.
+// parsing the charset bitmap into prog->charsets. Escaped metacharacters
+// outside of brackets (\., \*, \[ ...), the bare '@' (used internally as
+// the concatenation operator), control chars and bytes >= 128 (the later
+// phases use those values as sentinels) become single-char charsets the same
+// way.
+// Returns a malloc'd string the caller must free; returns NULL on parse error.
 static char* replaceBrackets(const char* re, ReProgram* prog) {
     int len = strlen(re);
     char* out = malloc(len + 1);
     int j = 0;
 
     for (int i = 0; i < len; i++) {
-        if (re[i] != '[') {
+        ReCharset cs;
+        memset(cs.bits, 0, sizeof(cs.bits));
+
+        if (re[i] == '\\' && i + 1 < len) {
+            if (isalnum((unsigned char)re[i + 1])) {
+                // \d, \w, \t etc. are handled by addConcat
+                out[j++] = re[i++];
+                out[j++] = re[i];
+                continue;
+            }
+            setCharBit(&cs, (unsigned char)re[++i]);
+        } else if (re[i] == '@' || (unsigned char)re[i] < 32 ||
+                   (unsigned char)re[i] >= 128) {
+            setCharBit(&cs, (unsigned char)re[i]);
+        } else if (re[i] != '[') {
             out[j++] = re[i];
             continue;
+        } else {
+            // find matching ']', skipping escaped chars
+            int k = i + 1;
+            bool negate = (k < len && re[k] == '^');
+            if (negate) k++;
+            int first = k;
+            int end = k;
+            // ']' as first char after '[' or '[^' is treated as literal
+            if (end < len && re[end] == ']') end++;
+            while (end < len && re[end] != ']') {
+                end += (re[end] == '\\' && end + 1 < len) ? 2 : 1;
+            }
+            if (end >= len) {  // unterminated
+                free(out);
+                return NULL;
+            }
+
+            // A leading ']' is read like any other char below, so it can
+            // also start a range such as []-^].
+            k = first;
+            while (k < end) {
+                unsigned char lo;
+                if (re[k] == '\\' && k + 1 < end) {
+                    if (addEscapeClass(&cs, re[k + 1])) {
+                        k += 2;
+                        continue;
+                    }
+                    lo = unescapeChar(re[k + 1]);
+                    k += 2;
+                } else {
+                    lo = (unsigned char)re[k++];
+                }
+
+                // A '-' that is first or last in the class is a literal.
+                if (k + 1 < end && re[k] == '-') {
+                    k++;
+                    unsigned char hi;
+                    if (re[k] == '\\' && k + 1 < end) {
+                        hi = unescapeChar(re[k + 1]);
+                        k += 2;
+                    } else {
+                        hi = (unsigned char)re[k++];
+                    }
+                    if (hi < lo) {
+                        free(out);
+                        return NULL;
+                    }
+                    for (int c = lo; c <= hi; c++) setCharBit(&cs, c);
+                } else {
+                    setCharBit(&cs, lo);
+                }
+            }
+
+            if (negate) {
+                for (int b = 0; b < 32; b++) cs.bits[b] ^= 0xFF;
+                cs.bits[0] &= ~1u;  // never match '\0'
+            }
+            i = end;  // skip past ']'
         }
-        // find matching ']'
-        int end = i + 1;
-        while (end < len && re[end] != ']') end++;
-        if (end >= len) {
-            free(out);
-            return NULL;
-        }  // unterminated
 
-        if (prog->num_charsets >= MAX_CHARSETS) {
+        int ix = internCharset(prog, &cs);
+        if (ix < 0) {
             free(out);
             return NULL;
         }
+        out[j++] = (char)(128 + ix);
+    }
+    out[j] = '\0';
+    return out;
+}
 
-        ReCharset* cs = &prog->charsets[prog->num_charsets];
-        memset(cs->bits, 0, sizeof(cs->bits));
-
-        int k = i + 1;
-        bool negate = (k < end && re[k] == '^');
-        if (negate) k++;
-
-        // ']' as first char after '[' or '[^' is treated as literal
-        if (k < end && re[k] == ']') {
-            cs->bits[(unsigned char)']' / 8] |= 1u << (']' % 8);
+// Appends atom with every capturing group turned into a non-capturing one, so
+// repeated copies of an atom do not shift the numbering of later groups.
+static void appendNonCapturing(CharBuf* buf, const char* atom, int n) {
+    for (int k = 0; k < n; k++) {
+        if (atom[k] == '\\' && k + 1 < n) {
+            charBufAppend(buf, atom + k, 2);
             k++;
+        } else if (atom[k] == '(' &&
+                   !(k + 2 < n && atom[k + 1] == '?' && atom[k + 2] == ':')) {
+            charBufAppend(buf, "(?:", 3);
+        } else {
+            charBufAppend(buf, atom + k, 1);
         }
+    }
+}
 
-        while (k < end) {
-            unsigned char lo = (unsigned char)re[k];
-            if (k + 2 < end && re[k + 1] == '-') {
-                unsigned char hi = (unsigned char)re[k + 2];
-                for (int c = lo; c <= hi; c++) cs->bits[c / 8] |= 1u << (c % 8);
-                k += 3;
-            } else {
-                cs->bits[lo / 8] |= 1u << (lo % 8);
-                k++;
+// Parses a {m}, {m,} or {m,n} quantifier starting at re[i]. Returns the number
+// of chars consumed, 0 if re[i] does not start a quantifier (the brace is then
+// a literal) or -1 if the bounds are invalid. max is -1 for {m,}; {0} and
+// {0,0} are valid and leave the atom out.
+static int parseRepeat(const char* re, int i, int* min, int* max) {
+    int k = i + 1;
+    if (!isdigit((unsigned char)re[k])) return 0;
+    int m = 0;
+    while (isdigit((unsigned char)re[k])) {
+        m = m * 10 + (re[k++] - '0');
+        if (m > RE_MAX_REPEAT) return -1;
+    }
+    int n = m;
+    if (re[k] == ',') {
+        k++;
+        if (isdigit((unsigned char)re[k])) {
+            n = 0;
+            while (isdigit((unsigned char)re[k])) {
+                n = n * 10 + (re[k++] - '0');
+                if (n > RE_MAX_REPEAT) return -1;
             }
+        } else {
+            n = -1;
         }
+    }
+    if (re[k] != '}') return 0;
+    if (n != -1 && n < m) return -1;
+    *min = m;
+    *max = n;
+    return k - i + 1;
+}
 
-        if (negate) {
-            for (int b = 0; b < 32; b++) cs->bits[b] ^= 0xFF;
-            cs->bits[0] &= ~1u;  // never match '\0'
-        }
+// Rewrites atom{m,n} into plain concatenations of the atom with ?, * and +.
+// The capturing copy of the atom is kept exactly once; all other copies are
+// non-capturing. Returns a malloc'd string or NULL on a malformed quantifier.
+static char* expandRepeats(const char* re) {
+    CharBuf out = {0};
+    charBufAppend(&out, "", 0);
+
+    int groups[100];
+    int depth = 0;
+    int atom = -1;  // start offset in out of the last complete atom
+    bool quantified = false;  // the previous char ended a quantifier
+
+    for (int i = 0; re[i] != '\0'; i++) {
+        char c = re[i];
+        bool after_quantifier = quantified;
+        quantified = false;
+        if (c == '\\' && re[i + 1] != '\0') {
+            atom = out.len;
+            charBufAppend(&out, re + i, 2);
+            i++;
+        } else if (c == '(') {
+            if (depth >= 100) goto EXPAND_ERROR;
+            groups[depth++] = out.len;
+            int n = (re[i + 1] == '?' && re[i + 2] == ':') ? 3 : 1;
+            charBufAppend(&out, re + i, n);
+            i += n - 1;
+            atom = -1;
+        } else if (c == ')') {
+            atom = depth > 0 ? groups[--depth] : -1;
+            charBufAppend(&out, &c, 1);
+        } else if (c == '{') {
+            int min, max;
+            int consumed = parseRepeat(re, i, &min, &max);
+            if (consumed < 0) goto EXPAND_ERROR;
+            // x{2}{2} or x*{2} repeats a quantifier, not an atom.
+            if (consumed > 0 && after_quantifier) goto EXPAND_ERROR;
+            if (consumed == 0 || atom < 0) {
+                atom = out.len;
+                charBufAppend(&out, &c, 1);
+                continue;
+            }
+
+            int n = out.len - atom;
+            char* copy = malloc(n);
+            memcpy(copy, out.chars + atom, n);
+            out.len = atom;
+
+            // {m,n}? makes every generated quantifier lazy.
+            bool lazy = re[i + consumed] == '?';
+            int qlen = lazy ? 2 : 1;
 
-        out[j++] = (char)(128 + prog->num_charsets);
-        prog->num_charsets++;
-        i = end;  // skip past ']'
+            if (max == 0) {
+                // a{0} -> (?:.^a)?, which never takes the atom: ^ cannot
+                // match after a char. The copy keeps its groups numbered.
+                charBufAppend(&out, "(?:.^", 5);
+                charBufAppend(&out, copy, n);
+                charBufAppend(&out, ")??", 1 + qlen);
+            } else if (min == 0) {
+                // a{0,} -> a*, a{0,n} -> a?(?:a)?...
+                charBufAppend(&out, copy, n);
+                charBufAppend(&out, max == -1 ? "*?" : "??", qlen);
+                for (int k = 1; k < max; k++) {
+                    appendNonCapturing(&out, copy, n);
+                    charBufAppend(&out, "??", qlen);
+                }
+            } else {
+                // a{m,} -> (?:a)...a+, a{m,n} -> (?:a)...a(?:a)?...
+                for (int k = 1; k < min; k++) {
+                    appendNonCapturing(&out, copy, n);
+                }
+                charBufAppend(&out, copy, n);
+                if (max == -1) charBufAppend(&out, "+?", qlen);
+                for (int k = min; k < max; k++) {
+                    appendNonCapturing(&out, copy, n);
+                    charBufAppend(&out, "??", qlen);
+                }
+            }
+            free(copy);
+            i += consumed - 1 + (lazy ? 1 : 0);
+            atom = -1;
+            quantified = true;
+        } else {
+            atom = strchr("|*+?^$", c) != NULL ? -1 : out.len;
+            quantified = strchr("*+?", c) != NULL;
+            charBufAppend(&out, &c, 1);
+        }
     }
-    out[j] = '\0';
-    return out;
+    return out.chars;
+
+EXPAND_ERROR:
+    free(out.chars);
+    return NULL;
 }
 
 char* re2postfix(const char* re) {
@@ -268,24 +534,29 @@ char* re2postfix(const char* re) {
     return postfix;
 }
 
-// All-in-one: handles [...], escape classes, anchors, groups.
+// All-in-one: handles [...], {m,n}, escape classes, anchors, groups.
 ReProgram* compilePattern(const char* re) {
     // Phase 1: parse [...] into charsets, replace with sentinel bytes.
     // We need a temp ReProgram shell just to collect charsets.
     ReProgram tmp;
     memset(&tmp, 0, sizeof(tmp));
 
-    char* expanded = replaceBrackets(re, &tmp);
+    char* bracketed = replaceBrackets(re, &tmp);
+    if (bracketed == NULL) return NULL;
+
+    // Phase 2: expand bounded repetitions {m,n} into plain quantifiers.
+    char* expanded = expandRepeats(bracketed);
+    free(bracketed);
     if (expanded == NULL) return NULL;
 
-    // Phase 2: normal pipeline on the expanded string.
+    // Phase 3: normal pipeline on the expanded string.
     char* dotted = addConcat(expanded);
     free(expanded);
     char* postfix = infixToPostfix(dotted);
     free(dotted);
     if (postfix == NULL) return NULL;
 
-    // Phase 3: compile — compileRegex emits RE_BRACKET for sentinel bytes.
+    // Phase 4: compile — compileRegex emits RE_BRACKET for sentinel bytes.
     ReProgram* prog = compileRegex(postfix);
     free(postfix);
     if (prog == NULL) return NULL;
@@ -346,6 +617,24 @@ static void addstate(ThreadList* list, int i, ReProgram* prog, int generation,
     }
 }
 
+// Returns how many fragments a postfix token pops off the compile stack.
+static int operandCount(char c) {
+    switch (c) {
+        case '@':
+        case '|':
+            return 2;
+        case '*':
+        case '+':
+        case '?':
+        case RE_LAZY_STAR:
+        case RE_LAZY_PLUS:
+        case RE_LAZY_QUEST:
+            return 1;
+        default:
+            return c >= 1 && c <= 9 ? 1 : 0;  // group end pops its body
+    }
+}
+
 ReProgram* compileRegex(const char* postfix) {
     int len = strlen(postfix);
     ReProgram* prog = malloc(sizeof(ReProgram));
@@ -365,6 +654,10 @@ ReProgram* compileRegex(const char* postfix) {
     int top = -1;
 
     for (const char* p = postfix; *p; p++) {
+        // Operators with missing operands come from patterns such as "a|",
+        // "()" or "*a"; reject them instead of reading past the stack.
+        if (top + 1 < operandCount(*p)) goto COMPILE_ERROR;
+
         switch (*p) {
             case '@': {
                 Frag e2 = stack[top--];
@@ -446,6 +739,32 @@ ReProgram* compileRegex(const char* postfix) {
                     (Frag){i, append(e.out, list1(&prog->instrs[i].s2))};
                 break;
             }
+            // The lazy forms are the same fragments with the SPLIT branches
+            // swapped: the thread that skips the atom gets priority.
+            case RE_LAZY_STAR: {
+                Frag e = stack[top--];
+                int i = prog->size++;
+                prog->instrs[i] = (ReInstr){RE_SPLIT, 0, 0, e.start};
+                patch(e.out, i);
+                stack[++top] = (Frag){i, list1(&prog->instrs[i].s1)};
+                break;
+            }
+            case RE_LAZY_PLUS: {
+                Frag e = stack[top--];
+                int i = prog->size++;
+                prog->instrs[i] = (ReInstr){RE_SPLIT, 0, 0, e.start};
+                patch(e.out, i);
+                stack[++top] = (Frag){e.start, list1(&prog->instrs[i].s1)};
+                break;
+            }
+            case RE_LAZY_QUEST: {
+                Frag e = stack[top--];
+                int i = prog->size++;
+                prog->instrs[i] = (ReInstr){RE_SPLIT, 0, 0, e.start};
+                stack[++top] =
+                    (Frag){i, append(list1(&prog->instrs[i].s1), e.out)};
+                break;
+            }
             default: {
                 unsigned char uc = (unsigned char)*p;
                 if (uc >= 128) {
@@ -474,22 +793,63 @@ ReProgram* compileRegex(const char* postfix) {
         }
     }
 
-    Frag final = stack[top--];
+    if (top > 0) goto COMPILE_ERROR;
+
     int start_save = prog->size++;
     int end_save = prog->size++;
     int match_idx = prog->size++;
 
-    prog->instrs[start_save] = (ReInstr){RE_SAVE, 0, final.start, 0};
+    prog->instrs[start_save] = (ReInstr){RE_SAVE, 0, end_save, 0};
     prog->instrs[end_save] = (ReInstr){RE_SAVE, 1, match_idx, 0};
     prog->instrs[match_idx] = (ReInstr){RE_MATCH, 0, 0, 0};
 
-    patch(final.out, end_save);
+    if (top == 0) {  // an empty pattern matches the empty string
+        Frag final = stack[top--];
+        prog->instrs[start_save].s1 = final.start;
+        patch(final.out, end_save);
+    }
     prog->start = start_save;
     return prog;
+
+COMPILE_ERROR:
+    for (; top >= 0; top--) freeList(stack[top].out);
+    free(prog->instrs);
+    free(prog);
+    return NULL;
 }
 
-bool matchGroups(ReProgram* prog, const char* text,
-                 const char* submatch[MAX_GROUPS * 2]) {
+// Tells whether a thread at instr moves past the char c.
+static bool consumes(const ReProgram* prog, const ReInstr* instr, char c) {
+    unsigned char ch = (unsigned char)c;
+    bool is_word = isalnum(ch) || c == '_';
+    switch (instr->type) {
+        case RE_ANY:
+            return true;
+        case RE_CHAR:
+            return instr->c == c;
+        case RE_CLASS:
+            switch (instr->c) {
+                case 'd':
+                    return isdigit(ch);
+                case 'w':
+                    return is_word;
+                case 'W':
+                    return !is_word;
+                case 's':
+                    return isspace(ch);
+                case 'S':
+                    return !isspace(ch);
+            }
+            return false;
+        case RE_BRACKET:
+            return prog->charsets[instr->c].bits[ch / 8] >> (ch % 8) & 1;
+        default:
+            return false;
+    }
+}
+
+bool matchGroupsFrom(ReProgram* prog, const char* text, const char* from,
+                     const char* submatch[MAX_GROUPS * 2]) {
     int n_instr = prog->size;
     int* last_visited = calloc(n_instr, sizeof(int));
     int generation = 1;
@@ -500,7 +860,7 @@ bool matchGroups(ReProgram* prog, const char* text,
     ThreadList clist = {malloc(sizeof(Thread) * n_instr), 0};
     ThreadList nlist = {malloc(sizeof(Thread) * n_instr), 0};
 
-    const char* sp = text;
+    const char* sp = from;
     addstate(&clist, prog->start, prog, generation++, last_visited,
              init_submatch, sp, text);
 
@@ -508,11 +868,15 @@ bool matchGroups(ReProgram* prog, const char* text,
     bool matched = false;
 
     for (;;) {
+        // Threads are kept in priority order. Once a thread matches, the
+        // lower-priority threads behind it can only produce less preferred
+        // matches, so they are cut off.
         for (int j = 0; j < clist.size; j++) {
             if (prog->instrs[clist.thread[j].instr_ix].type == RE_MATCH) {
                 memcpy(matched_submatch, clist.thread[j].submatch,
                        sizeof(matched_submatch));
                 matched = true;
+                clist.size = j;
                 break;
             }
         }
@@ -522,29 +886,19 @@ bool matchGroups(ReProgram* prog, const char* text,
         nlist.size = 0;
         for (int j = 0; j < clist.size; j++) {
             ReInstr* instr = &prog->instrs[clist.thread[j].instr_ix];
-            unsigned char ch = (unsigned char)*sp;
-            bool is_word = isalnum(ch) || *sp == '_';
-            bool advance =
-                instr->type == RE_ANY ||
-                (instr->type == RE_CHAR && instr->c == *sp) ||
-                (instr->type == RE_CLASS && instr->c == 'd' && isdigit(ch)) ||
-                (instr->type == RE_CLASS && instr->c == 'w' && is_word) ||
-                (instr->type == RE_CLASS && instr->c == 'W' && !is_word) ||
-                (instr->type == RE_CLASS && instr->c == 's' && isspace(ch)) ||
-                (instr->type == RE_CLASS && instr->c == 'S' && !isspace(ch)) ||
-                (instr->type == RE_BRACKET &&
-                 (prog->charsets[instr->c].bits[ch / 8] >> (ch % 8) & 1));
-            if (advance) {
+            if (consumes(prog, instr, *sp)) {
                 addstate(&nlist, instr->s1, prog, generation, last_visited,
                          clist.thread[j].submatch, sp + 1, text);
             }
         }
-        // Always try starting a fresh match at the next position so the
-        // engine finds matches that don't begin at offset 0. Existing
-        // threads are advanced first (above), so leftmost wins when two
-        // threads compete for the same NFA state in this generation.
-        addstate(&nlist, prog->start, prog, generation, last_visited,
-                 init_submatch, sp + 1, text);
+        // Until something matched, keep starting a fresh attempt at the next
+        // position so the engine finds matches that don't begin at `from`.
+        // Existing threads are advanced first (above), so the leftmost start
+        // wins when two threads compete for the same NFA state.
+        if (!matched) {
+            addstate(&nlist, prog->start, prog, generation, last_visited,
+                     init_submatch, sp + 1, text);
+        }
 
         ThreadList tmp = clist;
         clist = nlist;
@@ -552,7 +906,10 @@ bool matchGroups(ReProgram* prog, const char* text,
         generation++;
         sp++;
 
-        if (clist.size == 0) break;
+        // Without live threads only a fresh attempt can still match, and none
+        // is started once something matched. Zero-width patterns such as `$`
+        // have no live threads until the position where they match.
+        if (clist.size == 0 && matched) break;
     }
 
     if (matched) {
@@ -566,7 +923,189 @@ bool matchGroups(ReProgram* prog, const char* text,
     return matched;
 }
 
+bool matchGroups(ReProgram* prog, const char* text,
+                 const char* submatch[MAX_GROUPS * 2]) {
+    return matchGroupsFrom(prog, text, text, submatch);
+}
+
+// Like addstate, for match: threads are bare instruction indexes, so a
+// capture is just stepped over.
+static void addpc(int* list, int* size, int i, ReProgram* prog,
+                  int generation, int* last_visited, const char* sp,
+                  const char* text_start) {
+    if (last_visited[i] == generation) return;
+    last_visited[i] = generation;
+
+    ReInstr* instr = &prog->instrs[i];
+    switch (instr->type) {
+        case RE_SPLIT:
+            addpc(list, size, instr->s1, prog, generation, last_visited, sp,
+                  text_start);
+            addpc(list, size, instr->s2, prog, generation, last_visited, sp,
+                  text_start);
+            break;
+        case RE_JMP:
+        case RE_SAVE:
+            addpc(list, size, instr->s1, prog, generation, last_visited, sp,
+                  text_start);
+            break;
+        case RE_BOL:
+            if (sp == text_start) {
+                addpc(list, size, instr->s1, prog, generation, last_visited,
+                      sp, text_start);
+            }
+            break;
+        case RE_EOL:
+            if (*sp == '\0') {
+                addpc(list, size, instr->s1, prog, generation, last_visited,
+                      sp, text_start);
+            }
+            break;
+        default:
+            list[(*size)++] = i;
+            break;
+    }
+}
+
+// Without captures to report, which match is found doesn't matter: the first
+// thread to reach RE_MATCH ends the search, and no submatch arrays are
+// copied along the way.
 bool match(ReProgram* prog, const char* text) {
-    const char* submatch[MAX_GROUPS * 2];
-    return matchGroups(prog, text, submatch);
+    int n_instr = prog->size;
+    int* last_visited = calloc(n_instr, sizeof(int));
+    int* threads = malloc(sizeof(int) * n_instr * 2);
+    int* clist = threads;
+    int* nlist = threads + n_instr;
+    int csize = 0;
+    int generation = 1;
+
+    const char* sp = text;
+    addpc(clist, &csize, prog->start, prog, generation++, last_visited, sp,
+          text);
+
+    bool matched = false;
+    for (;;) {
+        for (int j = 0; j < csize && !matched; j++) {
+            matched = prog->instrs[clist[j]].type == RE_MATCH;
+        }
+        if (matched || *sp == '\0') break;
+
+        int nsize = 0;
+        for (int j = 0; j < csize; j++) {
+            ReInstr* instr = &prog->instrs[clist[j]];
+            if (consumes(prog, instr, *sp)) {
+                addpc(nlist, &nsize, instr->s1, prog, generation, last_visited,
+                      sp + 1, text);
+            }
+        }
+        addpc(nlist, &nsize, prog->start, prog, generation, last_visited,
+              sp + 1, text);
+
+        int* tmp = clist;
+        clist = nlist;
+        nlist = tmp;
+        csize = nsize;
+        generation++;
+        sp++;
+    }
+
+    free(threads);
+    free(last_visited);
+    return matched;
 }
+
+#define APPEND_TO_BUFFER(fmt, ...)                                     \
+    do {                                                               \
+        int needed = snprintf(NULL, 0, fmt, ##__VA_ARGS__);            \
+        while (offset + needed + 1 > buffer_size) {                    \
+            buffer_size = (buffer_size == 0) ? 128 : buffer_size * 2;  \
+            buffer = realloc(buffer, buffer_size);                     \
+        }                                                              \
+        offset += snprintf(buffer + offset, buffer_size - offset, fmt, \
+                           ##__VA_ARGS__);                             \
+    } while (0)
+
+// Writes c as it would appear in a pattern, escaping the chars in special.
+static void sprintChar(char out[8], int c, const char* special) {
+    if (c == '\n') {
+        snprintf(out, 8, "\\n");
+    } else if (c == '\t') {
+        snprintf(out, 8, "\\t");
+    } else if (c < ' ' || c > '~') {
+        snprintf(out, 8, "\\x%02x", c);
+    } else if (c == '\\' || strchr(special, c) != NULL) {
+        snprintf(out, 8, "\\%c", c);
+    } else {
+        snprintf(out, 8, "%c", c);
+    }
+}
+
+char* sprintProgram(const ReProgram* prog) {
+    char* buffer = NULL;
+    size_t buffer_size = 0;
+    size_t offset = 0;
+    char ch[8];
+    char hi[8];
+
+    APPEND_TO_BUFFER("start %d\n", prog->start);
+    for (int i = 0; i < prog->size; i++) {
+        const ReInstr* instr = &prog->instrs[i];
+        APPEND_TO_BUFFER("%4d  ", i);
+        switch (instr->type) {
+            case RE_CHAR:
+                sprintChar(ch, (unsigned char)instr->c, "'");
+                APPEND_TO_BUFFER("char '%s' -> %d\n", ch, instr->s1);
+                break;
+            case RE_MATCH:
+                APPEND_TO_BUFFER("match\n");
+                break;
+            case RE_JMP:
+                APPEND_TO_BUFFER("jmp %d\n", instr->s1);
+                break;
+            case RE_SPLIT:
+                APPEND_TO_BUFFER("split %d, %d\n", instr->s1, instr->s2);
+                break;
+            case RE_ANY:
+                APPEND_TO_BUFFER("any -> %d\n", instr->s1);
+                break;
+            case RE_SAVE:
+                APPEND_TO_BUFFER("save %d -> %d\n", instr->c, instr->s1);
+                break;
+            case RE_CLASS:
+                APPEND_TO_BUFFER("class \\%c -> %d\n", instr->c, instr->s1);
+                break;
+            case RE_BOL:
+                APPEND_TO_BUFFER("bol -> %d\n", instr->s1);
+                break;
+            case RE_EOL:
+                APPEND_TO_BUFFER("eol -> %d\n", instr->s1);
+                break;
+            case RE_BRACKET: {
+                // The set is listed as runs of chars, whatever its syntax.
+                const ReCharset* set = &prog->charsets[instr->c];
+                APPEND_TO_BUFFER("set [");
+                for (int c = 0; c < 256; c++) {
+                    if (!(set->bits[c / 8] >> (c % 8) & 1)) continue;
+                    int end = c;
+                    while (end + 1 < 256 &&
+                           (set->bits[(end + 1) / 8] >> ((end + 1) % 8) & 1)) {
+                        end++;
+                    }
+                    sprintChar(ch, c, "]^-");
+                    if (end == c) {
+                        APPEND_TO_BUFFER("%s", ch);
+                    } else {
+                        sprintChar(hi, end, "]^-");
+                        APPEND_TO_BUFFER("%s-%s", ch, hi);
+                    }
+                    c = end;
+                }
+                APPEND_TO_BUFFER("] -> %d\n", instr->s1);
+                break;
+            }
+        }
+    }
+    return buffer;
+}
+
+#undef APPEND_TO_BUFFER
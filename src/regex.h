@@ -32,6 +32,13 @@ typedef struct {
 #define RE_ESC_NONSPACE 16
 #define RE_ESC_TAB 17
 #define RE_ESC_NEWLINE 18
+#define RE_NONCAP_OPEN 19  // "(?:" — opens a non-capturing group
+#define RE_LAZY_STAR 20    // *?
+#define RE_LAZY_PLUS 21    // +?
+#define RE_LAZY_QUEST 22   // ??
+
+// Upper bound for m and n in a bounded repetition {m,n}.
+#define RE_MAX_REPEAT 255
 
 typedef struct {
     ReInstrType type;
@@ -53,8 +60,17 @@ char* re2postfix(const char* re);
 ReProgram* compileRegex(const char* postfix);
 ReProgram* compilePattern(
     const char* re);  // handles [...] in addition to the above
+// Tells whether prog matches anywhere in text. Cheaper than matchGroups, as
+// it keeps no captures.
 bool match(ReProgram* prog, const char* text);
 bool matchGroups(ReProgram* prog, const char* text,
                  const char* submatch[MAX_GROUPS * 2]);
+// Finds the leftmost match starting at or after `from`. `text` is the start of
+// the whole subject, so ^ only matches there.
+bool matchGroupsFrom(ReProgram* prog, const char* text, const char* from,
+                     const char* submatch[MAX_GROUPS * 2]);
+// Returns the listing of prog, one instruction per line after the start
+// index, for debugging. The caller frees it.
+char* sprintProgram(const ReProgram* prog);
 
 #endif
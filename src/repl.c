@@ -7,6 +7,10 @@
 #include <unistd.h>
 
 #include "common.h"
+#include "eval.h"
+#include "object.h"
+#include "regex.h"
+#include "table.h"
 #include "value.h"
 #include "vm.h"
 
@@ -88,7 +92,87 @@ static void historyAdd(History* hist, const char* line) {
     hist->entries[hist->cnt++] = strdup(line);
 }
 
-static char* lineRead(History* hist) {
+typedef struct {
+    const char* prefix;
+    ObjString** items;
+    size_t cnt;
+    size_t cap;
+} Completions;
+
+static bool collectCompletions(Table* scope, ScopeKind kind, void* ctx) {
+    (void)kind;
+    Completions* comp = ctx;
+    size_t n;
+    ObjString** found = tableFindByPrefix(scope, comp->prefix, false, &n);
+    for (size_t i = 0; i < n; i++) {
+        bool seen = false;  // names are interned, so shadowed ones repeat
+        for (size_t j = 0; j < comp->cnt && !seen; j++) {
+            seen = comp->items[j] == found[i];
+        }
+        if (seen) continue;
+        if (comp->cnt == comp->cap) {
+            comp->cap = comp->cap * 2 + 8;
+            comp->items = realloc(comp->items, sizeof(ObjString*) * comp->cap);
+        }
+        comp->items[comp->cnt++] = found[i];
+    }
+    free(found);
+    return true;
+}
+
+static bool isSymbolChar(char c) { return strchr(" ()[]\"", c) == NULL; }
+
+// Completes the symbol under the cursor against the names visible in the
+// main module. Extends it by the longest common prefix of the candidates, or
+// lists them if that adds nothing.
+static void lineComplete(Line* l, VM* vm) {
+    int start = l->cur;
+    while (start > 0 && isSymbolChar(l->buf[start - 1])) start--;
+    if (start == l->cur) return;
+
+    char prefix[REPL_LINE_MAX];
+    int plen = l->cur - start;
+    memcpy(prefix, &l->buf[start], plen);
+    prefix[plen] = '\0';
+
+    Completions comp = {prefix, NULL, 0, 0};
+    // Before the first line is run only the built-ins exist.
+    ObjModule* module = vm->main_module ? vm->main_module : vm->core_module;
+    walkScopes(vm, module, collectCompletions, &comp);
+    if (comp.cnt == 0) {
+        write(STDOUT_FILENO, "\a", 1);
+        return;
+    }
+
+    int common = comp.items[0]->length;
+    for (size_t i = 1; i < comp.cnt; i++) {
+        int k = 0;
+        while (k < common && k < comp.items[i]->length &&
+               comp.items[i]->chars[k] == comp.items[0]->chars[k]) {
+            k++;
+        }
+        common = k;
+    }
+
+    int extra = common - plen;
+    if (extra > 0 && l->len + extra < REPL_LINE_MAX) {
+        memmove(&l->buf[l->cur + extra], &l->buf[l->cur], l->len - l->cur);
+        memcpy(&l->buf[l->cur], comp.items[0]->chars + plen, extra);
+        l->cur += extra;
+        l->len += extra;
+    } else if (comp.cnt > 1) {
+        write(STDOUT_FILENO, "\r\n", 2);
+        for (size_t i = 0; i < comp.cnt; i++) {
+            write(STDOUT_FILENO, comp.items[i]->chars, comp.items[i]->length);
+            write(STDOUT_FILENO, "  ", 2);
+        }
+        write(STDOUT_FILENO, "\r\n", 2);
+    }
+    free(comp.items);
+    lineRefresh(l);
+}
+
+static char* lineRead(History* hist, VM* vm) {
     Line l = {.len = 0, .cur = 0};
 
     char saved[REPL_LINE_MAX] = {0};
@@ -153,6 +237,8 @@ static char* lineRead(History* hist) {
                     lineRefresh(&l);
                 }
             }
+        } else if (c == '\t') {
+            lineComplete(&l, vm);
         } else if (c == '\x03') {
             write(STDOUT_FILENO, "\n", 1);
             return NULL;  // EOF/exit
@@ -166,7 +252,139 @@ static char* lineRead(History* hist) {
     }
 }
 
+// Prints the usage line and docs of a function value below its repr, so
+// typing a function name at the prompt works as a quick help.
+static void printDoc(Value value) {
+    const char* usage;
+    const char* doc;
+    if (!functionDocs(value, &usage, &doc)) return;
+    if (usage != NULL) PRINTF("  %s\n", usage);
+    if (doc != NULL) PRINTF("  %s\n", doc);
+}
+
+// Prints text with the span of the match in reverse video and the parts any
+// capture group covers underlined, then each capture group.
+static void printHighlighted(const char* text, const ReProgram* prog,
+                             const char* submatch[MAX_GROUPS * 2]) {
+    PRINTF("  ");
+    bool was_matched = false;
+    bool was_captured = false;
+    for (const char* p = text; *p != '\0'; p++) {
+        bool matched = p >= submatch[0] && p < submatch[1];
+        bool captured = false;
+        for (int g = 1; g < prog->num_grps && !captured; g++) {
+            captured = submatch[2 * g] != NULL && p >= submatch[2 * g] &&
+                       p < submatch[2 * g + 1];
+        }
+        if (matched != was_matched || captured != was_captured) {
+            PRINTF("\x1b[0m%s%s", matched ? "\x1b[7m" : "",
+                   captured ? "\x1b[4m" : "");
+            was_matched = matched;
+            was_captured = captured;
+        }
+        PRINTF("%c", *p);
+    }
+    PRINTF("\x1b[0m\n");
+    for (int g = 1; g < prog->num_grps; g++) {
+        if (submatch[2 * g] == NULL || submatch[2 * g + 1] == NULL) {
+            PRINTF("    %d: unset\n", g);
+        } else {
+            PRINTF("    %d: \"%.*s\"\n", g,
+                   (int)(submatch[2 * g + 1] - submatch[2 * g]),
+                   submatch[2 * g]);
+        }
+    }
+}
+
+// Handles `:re "pattern" "sample" ...`, which prints the program of the
+// pattern and then tries it on every sample. The arguments are Liss strings,
+// so escapes work as in re natives.
+static void reCommand(VM* vm, const char* args) {
+    size_t len = strlen(args);
+    char* src = malloc(len + 3);
+    snprintf(src, len + 3, "[%s]", args);
+    InterpretResult result = interpret(vm, src, NULL);
+    free(src);
+    Value list = vm->last_popped_value;
+    if (result != INTERPRET_OK || !IS_LIST(list) || AS_LIST(list)->len == 0 ||
+        !IS_STRING(AS_PAIR(AS_LIST(list)->head)->first)) {
+        PRINTF("usage: :re \"pattern\" \"sample\" ...\n");
+        return;
+    }
+
+    Value item = AS_LIST(list)->head;
+    ReProgram* prog = compilePattern(AS_CSTRING(AS_PAIR(item)->first));
+    if (prog == NULL) {
+        PRINTF("Invalid regex pattern\n");
+        return;
+    }
+    char* listing = sprintProgram(prog);
+    PRINTF("%s", listing);
+    free(listing);
+    for (item = AS_PAIR(item)->second; IS_PAIR(item);
+         item = AS_PAIR(item)->second) {
+        Value sample = AS_PAIR(item)->first;
+        if (!IS_STRING(sample)) continue;
+        const char* submatch[MAX_GROUPS * 2] = {0};
+        if (matchGroups(prog, AS_CSTRING(sample), submatch)) {
+            printHighlighted(AS_CSTRING(sample), prog, submatch);
+        } else {
+            PRINTF("  %s: no match\n", AS_CSTRING(sample));
+        }
+    }
+    free(prog->instrs);
+    free(prog);
+}
+
+// Handles `:reload name`, which runs the file of a loaded module again so
+// that edits to it take effect without leaving the REPL.
+static void reloadCommand(VM* vm, const char* name) {
+    while (*name == ' ') name++;
+    if (*name == '\0') {
+        PRINTF("usage: :reload module\n");
+        return;
+    }
+    vmRecover(vm);
+    ObjString* name_obj = copyString(vm, name, (int)strlen(name));
+    push(vm, OBJ_VAL(name_obj));
+    bool ok = reloadModule(vm, name_obj);
+    pop(vm);
+    if (ok) {
+        PRINTF("reloaded %s\n", name);
+        return;
+    }
+    char* str = sprintValue(vm->raise_value);
+    ERROR_LOG("%s", str);
+    free(str);
+}
+
+// Handles `:eval expr`, which evaluates a pure expression by walking it
+// rather than compiling it, to check what the VM makes of it.
+static void evalCommand(VM* vm, const char* src) {
+    Value value;
+    char error[256];
+    switch (evalSource(vm, src, &value, error, sizeof(error))) {
+        case EVAL_OK: {
+            char* str = sprintValue(value);
+            PRINTF("%s\n", str);
+            free(str);
+            break;
+        }
+        case EVAL_ERROR:
+            ERROR_LOG("%s", error);
+            break;
+        case EVAL_UNSUPPORTED:
+            PRINTF("not a pure expression: %s\n", error);
+            break;
+    }
+}
+
 void runRepl(VMOptions options) {
+    // Every line is compiled separately, so a deprecated native used over
+    // and over would otherwise warn on each line.
+    options.warn_deprecated_once = true;
+    // Show what a definition bound, as in `(let x 5)` => 5.
+    options.defs_yield_value = true;
     VM* vm = newVM(options);
 
     enableRawMode();
@@ -176,22 +394,45 @@ void runRepl(VMOptions options) {
     History* hist = calloc(1, sizeof(History));
 
     for (;;) {
-        char* line = lineRead(hist);
+        char* line = lineRead(hist, vm);
         if (line == NULL) break;
 
         historyAdd(hist, line);
 
+        if (strncmp(line, ":re ", 4) == 0 || strcmp(line, ":re") == 0) {
+            reCommand(vm, line + 3);
+            fflush(stdout);
+            continue;
+        }
+        if (strncmp(line, ":reload ", 8) == 0 ||
+            strcmp(line, ":reload") == 0) {
+            reloadCommand(vm, line + 7);
+            fflush(stdout);
+            continue;
+        }
+        if (strncmp(line, ":eval ", 6) == 0) {
+            evalCommand(vm, line + 6);
+            fflush(stdout);
+            continue;
+        }
+
         InterpretResult result = interpret(vm, line, NULL);
         if (result == INTERPRET_COMPILE_ERROR) {
-            ERROR_LOG("%s", vm->error_msg);
-        } else if (result == INTERPRET_RUNTIME_ERROR) {
+            printDiagnostics(vm, stdout, "<repl>");
+        } else if (result == INTERPRET_RUNTIME_ERROR ||
+                   result == INTERPRET_BUDGET_EXCEEDED) {
             char* str = sprintValue(vm->raise_value);
             ERROR_LOG("%s", str);
             free(str);
+            if (IS_ERROR(vm->raise_value) &&
+                AS_ERROR(vm->raise_value)->trace != NULL) {
+                fputs(AS_ERROR(vm->raise_value)->trace->chars, stdout);
+            }
         } else if (result == INTERPRET_OK) {
             // Print the last popped value
             char* str = sprintValue(vm->last_popped_value);
             PRINTF("%s\n", str);
+            printDoc(vm->last_popped_value);
             fflush(stdout);
             free(str);
         }
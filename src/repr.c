@@ -0,0 +1,540 @@
+#include "repr.h"
+
+#include <ctype.h>
+#include <errno.h>
+#include <inttypes.h>
+#include <math.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "hamt.h"
+#include "vm.h"
+
+// --- Printing ---
+
+static void reprReal(CharBuf* buf, double d) {
+    if (isnan(d)) {
+        charBufAppendStr(buf, "nan");
+        return;
+    }
+    if (isinf(d)) {
+        charBufAppendStr(buf, d < 0 ? "-inf" : "inf");
+        return;
+    }
+
+    // Use the shortest precision that reads back to the same double.
+    char tmp[32];
+    for (int prec = 15; prec <= 17; prec++) {
+        snprintf(tmp, sizeof(tmp), "%.*g", prec, d);
+        if (strtod(tmp, NULL) == d) break;
+    }
+    charBufAppendStr(buf, tmp);
+    // Keep the value a real when it reads back: 1 -> 1.0.
+    if (strpbrk(tmp, ".e") == NULL) charBufAppendStr(buf, ".0");
+}
+
+static void reprString(CharBuf* buf, ObjString* str) {
+    charBufAppend(buf, "\"", 1);
+    for (int i = 0; i < str->length; i++) {
+        char c = str->chars[i];
+        switch (c) {
+            case '"':
+                charBufAppend(buf, "\\\"", 2);
+                break;
+            case '\\':
+                charBufAppend(buf, "\\\\", 2);
+                break;
+            case '\n':
+                charBufAppend(buf, "\\n", 2);
+                break;
+            case '\t':
+                charBufAppend(buf, "\\t", 2);
+                break;
+            case '\r':
+                charBufAppend(buf, "\\r", 2);
+                break;
+            default:
+                charBufAppend(buf, &c, 1);
+        }
+    }
+    charBufAppend(buf, "\"", 1);
+}
+
+typedef struct {
+    Value key;
+    Value val;
+    char* key_repr;
+} ReprEntry;
+
+typedef struct {
+    ReprEntry* entries;
+    int count;
+    int cap;
+} ReprEntries;
+
+static void collectEntry(Value key, Value val, void* ctx_) {
+    ReprEntries* ctx = ctx_;
+    if (ctx->count == ctx->cap) {
+        ctx->cap = ctx->cap * 2 + 8;
+        ctx->entries = realloc(ctx->entries, sizeof(ReprEntry) * ctx->cap);
+    }
+    ctx->entries[ctx->count++] = (ReprEntry){key, val, NULL};
+}
+
+static bool isOrderedByValue(Value v) {
+    return !IS_OBJ(v) || IS_STRING(v);
+}
+
+// Keys of the same container type (lists, pairs, ...) would otherwise be
+// ordered by address; compare their text instead so the output is stable.
+static int cmpEntries(const void* a, const void* b) {
+    const ReprEntry* x = a;
+    const ReprEntry* y = b;
+    if (isOrderedByValue(x->key) || isOrderedByValue(y->key) ||
+        OBJ_TYPE(x->key) != OBJ_TYPE(y->key)) {
+        return compareValues(x->key, y->key);
+    }
+    return strcmp(x->key_repr, y->key_repr);
+}
+
+static bool reprInto(CharBuf* buf, Value value);
+
+static bool reprDict(CharBuf* buf, ObjDict* dict) {
+    ReprEntries ctx = {NULL, 0, 0};
+    hamtEach(dict->root, collectEntry, &ctx);
+
+    bool ok = true;
+    for (int i = 0; i < ctx.count && ok; i++) {
+        ctx.entries[i].key_repr = reprValue(ctx.entries[i].key);
+        ok = ctx.entries[i].key_repr != NULL;
+    }
+    if (ok) {
+        if (ctx.count > 1) {
+            qsort(ctx.entries, ctx.count, sizeof(ReprEntry), cmpEntries);
+        }
+        charBufAppendStr(buf, "(dict");
+        for (int i = 0; i < ctx.count && ok; i++) {
+            charBufAppendStr(buf, " (");
+            charBufAppendStr(buf, ctx.entries[i].key_repr);
+            charBufAppendStr(buf, " . ");
+            ok = reprInto(buf, ctx.entries[i].val);
+            charBufAppendStr(buf, ")");
+        }
+        charBufAppendStr(buf, ")");
+    }
+
+    for (int i = 0; i < ctx.count; i++) free(ctx.entries[i].key_repr);
+    free(ctx.entries);
+    return ok;
+}
+
+static bool reprInto(CharBuf* buf, Value value) {
+    switch (value.type) {
+        case VAL_BOOL:
+            charBufAppendStr(buf, AS_BOOL(value) ? "true" : "false");
+            return true;
+        case VAL_NIL:
+            charBufAppendStr(buf, "null");
+            return true;
+        case VAL_INT:
+            charBufAppendf(buf, "%" PRId64, AS_INT(value));
+            return true;
+        case VAL_REAL:
+            reprReal(buf, AS_REAL(value));
+            return true;
+        case VAL_OBJ:
+            break;
+    }
+
+    switch (OBJ_TYPE(value)) {
+        case OBJ_STRING:
+            reprString(buf, AS_STRING(value));
+            return true;
+        case OBJ_ERROR:
+            charBufAppendStr(buf, "(err ");
+            reprString(buf, AS_ERROR(value)->message);
+            charBufAppendStr(buf, ")");
+            return true;
+        case OBJ_PAIR: {
+            ObjPair* pair = AS_PAIR(value);
+            charBufAppendStr(buf, "(");
+            if (!reprInto(buf, pair->first)) return false;
+            charBufAppendStr(buf, " . ");
+            if (!reprInto(buf, pair->second)) return false;
+            charBufAppendStr(buf, ")");
+            return true;
+        }
+        case OBJ_LIST: {
+            ObjList* list = AS_LIST(value);
+            Value curr = list->head;
+            charBufAppendStr(buf, "[");
+            for (uint32_t i = 0; i < list->len; i++) {
+                if (i > 0) charBufAppendStr(buf, " ");
+                if (!reprInto(buf, AS_PAIR(curr)->first)) return false;
+                curr = AS_PAIR(curr)->second;
+            }
+            charBufAppendStr(buf, "]");
+            return true;
+        }
+        case OBJ_TUPLE: {
+            ObjTuple* tuple = AS_TUPLE(value);
+            charBufAppendStr(buf, "#[");
+            for (uint32_t i = 0; i < tuple->len; i++) {
+                if (i > 0) charBufAppendStr(buf, " ");
+                if (!reprInto(buf, tuple->items[i])) return false;
+            }
+            charBufAppendStr(buf, "]");
+            return true;
+        }
+        case OBJ_BYTES: {
+            ObjBytes* bytes = AS_BYTES(value);
+            charBufAppendStr(buf, "(from_hex \"");
+            for (uint32_t i = 0; i < bytes->len; i++) {
+                charBufAppendf(buf, "%02x", bytes->data[i]);
+            }
+            charBufAppendStr(buf, "\")");
+            return true;
+        }
+        case OBJ_DICT:
+            return reprDict(buf, AS_DICT(value));
+        default:
+            return false;
+    }
+}
+
+char* reprValue(Value value) {
+    CharBuf buf = {0};
+    charBufAppend(&buf, "", 0);
+    if (!reprInto(&buf, value)) {
+        free(buf.chars);
+        return NULL;
+    }
+    return buf.chars;
+}
+
+// --- Parsing ---
+
+typedef struct {
+    VM* vm;
+    const char* curr;
+    int depth;
+    const char* err_msg;
+} ReprParser;
+
+static bool fail(ReprParser* p, const char* msg) {
+    if (p->err_msg == NULL) p->err_msg = msg;
+    return false;
+}
+
+static void skipSpace(ReprParser* p) {
+    while (isspace((unsigned char)*p->curr)) p->curr++;
+}
+
+static bool isDelim(char c) {
+    return c == '\0' || isspace((unsigned char)c) || c == '(' || c == ')' ||
+           c == '[' || c == ']' || c == '"';
+}
+
+// Consumes word if it appears at the cursor as a whole token.
+static bool matchWord(ReprParser* p, const char* word) {
+    size_t n = strlen(word);
+    if (strncmp(p->curr, word, n) != 0 || !isDelim(p->curr[n])) return false;
+    p->curr += n;
+    return true;
+}
+
+static bool expectChar(ReprParser* p, char c, const char* msg) {
+    skipSpace(p);
+    if (*p->curr != c) return fail(p, msg);
+    p->curr++;
+    return true;
+}
+
+// Every container level keeps at most three values on the VM stack.
+static bool reserveStack(ReprParser* p) {
+    size_t used = (size_t)(p->vm->stack_top - p->vm->stack);
+    if (used + 3 > p->vm->options.stack_capacity) {
+        return fail(p, "input is nested too deeply");
+    }
+    return true;
+}
+
+static bool parseString(ReprParser* p, Value* out) {
+    p->curr++;  // opening quote
+    size_t cap = 16;
+    size_t len = 0;
+    char* chars = malloc(cap);
+    for (;;) {
+        char c = *p->curr;
+        if (c == '\0') {
+            free(chars);
+            return fail(p, "unterminated string");
+        }
+        p->curr++;
+        if (c == '"') break;
+        if (c == '\\') {
+            char e = *p->curr;
+            if (e == '\0') {
+                free(chars);
+                return fail(p, "unterminated string");
+            }
+            p->curr++;
+            switch (e) {
+                case 'n':
+                    c = '\n';
+                    break;
+                case 't':
+                    c = '\t';
+                    break;
+                case 'r':
+                    c = '\r';
+                    break;
+                default:
+                    c = e;
+            }
+        }
+        if (len + 1 >= cap) {
+            cap *= 2;
+            chars = realloc(chars, cap);
+        }
+        chars[len++] = c;
+    }
+    chars[len] = '\0';
+    *out = OBJ_VAL(copyString(p->vm, chars, (int)len));
+    free(chars);
+    return true;
+}
+
+static bool parseNumber(ReprParser* p, Value* out) {
+    const char* start = p->curr;
+    while (!isDelim(*p->curr)) p->curr++;
+    size_t len = (size_t)(p->curr - start);
+    char tmp[64];
+    if (len == 0 || len >= sizeof(tmp)) return fail(p, "invalid number");
+    memcpy(tmp, start, len);
+    tmp[len] = '\0';
+
+    char* end;
+    errno = 0;
+    if (strpbrk(tmp, ".eEni") != NULL) {
+        double d = strtod(tmp, &end);
+        if (*end != '\0') return fail(p, "invalid real");
+        *out = REAL_VAL(d);
+    } else {
+        long long i = strtoll(tmp, &end, 10);
+        if (*end != '\0' || errno == ERANGE) {
+            return fail(p, "invalid integer");
+        }
+        *out = INT_VAL((int64_t)i);
+    }
+    return true;
+}
+
+static bool parseValue(ReprParser* p, Value* out);
+
+static bool parseList(ReprParser* p, Value* out) {
+    p->curr++;  // [
+    VM* vm = p->vm;
+    uint32_t len = 0;
+    ObjPair* tail = NULL;
+    push(vm, NIL_VAL);  // head
+    for (;;) {
+        skipSpace(p);
+        if (*p->curr == ']') break;
+        Value elem;
+        if (!parseValue(p, &elem)) {
+            pop(vm);
+            return false;
+        }
+        push(vm, elem);
+        ObjPair* pair = newPair(vm, elem, NIL_VAL);
+        pop(vm);
+        if (tail == NULL) {
+            vm->stack_top[-1] = OBJ_VAL(pair);
+        } else {
+            tail->second = OBJ_VAL(pair);
+        }
+        tail = pair;
+        len++;
+    }
+    p->curr++;
+    *out = OBJ_VAL(newList(vm, len, vm->stack_top[-1]));
+    pop(vm);
+    return true;
+}
+
+// Reads the items as a list first, which keeps them reachable while parsing.
+static bool parseTuple(ReprParser* p, Value* out) {
+    p->curr++;  // #
+    Value list;
+    if (!parseList(p, &list)) return false;
+    push(p->vm, list);
+    uint32_t len = AS_LIST(list)->len;
+    ObjTuple* tuple = newTuple(p->vm, len);
+    Value curr = AS_LIST(list)->head;
+    for (uint32_t i = 0; i < len; i++) {
+        tuple->items[i] = AS_PAIR(curr)->first;
+        curr = AS_PAIR(curr)->second;
+    }
+    pop(p->vm);
+    *out = OBJ_VAL(tuple);
+    return true;
+}
+
+// Parses "k . v)" after the opening paren of a pair, leaving k and v on the
+// VM stack.
+static bool parsePairBody(ReprParser* p) {
+    Value v;
+    if (!parseValue(p, &v)) return false;
+    push(p->vm, v);
+    skipSpace(p);
+    if (!matchWord(p, ".")) {
+        pop(p->vm);
+        return fail(p, "expected '.' in pair");
+    }
+    if (!parseValue(p, &v)) {
+        pop(p->vm);
+        return false;
+    }
+    push(p->vm, v);
+    if (!expectChar(p, ')', "expected ')' after pair")) {
+        pop(p->vm);
+        pop(p->vm);
+        return false;
+    }
+    return true;
+}
+
+static bool parseDict(ReprParser* p, Value* out) {
+    VM* vm = p->vm;
+    ObjDict* dict = newDict(vm);
+    push(vm, OBJ_VAL(dict));
+    for (;;) {
+        skipSpace(p);
+        if (*p->curr == ')') break;
+        if (*p->curr != '(') {
+            pop(vm);
+            return fail(p, "expected (key . value) in dict");
+        }
+        p->curr++;
+        if (!parsePairBody(p)) {
+            pop(vm);
+            return false;
+        }
+        Value key = peek(vm, 1);
+        uint64_t hash = hamtKeyHash(vm, key);
+        bool is_new = hamtGet(dict->root, key, hash, 0) == NULL;
+        if (is_new && !hamtFreezeKey(vm, &key)) {
+            pop(vm);
+            pop(vm);
+            pop(vm);
+            return fail(p, "dict key is nested too deeply");
+        }
+        vm->stack_top[-2] = key;
+        dict->root = hamtPut(vm, dict->root, key, peek(vm, 0),
+                             dict->next_seq++, hash, 0);
+        if (is_new) dict->count++;
+        pop(vm);
+        pop(vm);
+    }
+    p->curr++;
+    *out = pop(vm);
+    return true;
+}
+
+static bool parseError(ReprParser* p, Value* out) {
+    skipSpace(p);
+    Value msg;
+    if (*p->curr != '"' || !parseString(p, &msg)) {
+        return fail(p, "expected message string in err");
+    }
+    push(p->vm, msg);
+    if (!expectChar(p, ')', "expected ')' after err")) {
+        pop(p->vm);
+        return false;
+    }
+    *out = OBJ_VAL(newError(p->vm, AS_CSTRING(msg)));
+    pop(p->vm);
+    return true;
+}
+
+static bool parseHex(ReprParser* p, Value* out) {
+    skipSpace(p);
+    Value hex;
+    if (*p->curr != '"' || !parseString(p, &hex)) {
+        return fail(p, "expected hex string in from_hex");
+    }
+    push(p->vm, hex);
+    if (!expectChar(p, ')', "expected ')' after from_hex")) {
+        pop(p->vm);
+        return false;
+    }
+    ObjBytes* bytes =
+        bytesFromHex(p->vm, AS_CSTRING(hex), AS_STRING(hex)->length);
+    pop(p->vm);
+    if (bytes == NULL) return fail(p, "invalid hex string in from_hex");
+    *out = OBJ_VAL(bytes);
+    return true;
+}
+
+static bool parseParen(ReprParser* p, Value* out) {
+    p->curr++;  // (
+    skipSpace(p);
+    if (matchWord(p, "dict")) return parseDict(p, out);
+    if (matchWord(p, "err")) return parseError(p, out);
+    if (matchWord(p, "from_hex")) return parseHex(p, out);
+    if (!parsePairBody(p)) return false;
+    *out = OBJ_VAL(newPair(p->vm, peek(p->vm, 1), peek(p->vm, 0)));
+    pop(p->vm);
+    pop(p->vm);
+    return true;
+}
+
+static bool parseValue(ReprParser* p, Value* out) {
+    skipSpace(p);
+    char c = *p->curr;
+    if (c == '"') return parseString(p, out);
+    bool is_tuple = c == '#' && p->curr[1] == '[';
+    if (c == '[' || c == '(' || is_tuple) {
+        if (p->depth >= REPR_MAX_DEPTH) {
+            return fail(p, "input is nested too deeply");
+        }
+        if (!reserveStack(p)) return false;
+        p->depth++;
+        bool ok = is_tuple   ? parseTuple(p, out)
+                  : c == '[' ? parseList(p, out)
+                             : parseParen(p, out);
+        p->depth--;
+        return ok;
+    }
+    if (matchWord(p, "null")) {
+        *out = NIL_VAL;
+        return true;
+    }
+    if (matchWord(p, "true")) {
+        *out = BOOL_VAL(true);
+        return true;
+    }
+    if (matchWord(p, "false")) {
+        *out = BOOL_VAL(false);
+        return true;
+    }
+    if (isdigit((unsigned char)c) || c == '-' || c == '+' || c == 'i' ||
+        c == 'n') {
+        return parseNumber(p, out);
+    }
+    if (c == '\0') return fail(p, "unexpected end of input");
+    return fail(p, "unexpected character");
+}
+
+bool parseRepr(VM* vm, const char* src, Value* out, const char** err_msg) {
+    ReprParser p = {vm, src, 0, NULL};
+    bool ok = parseValue(&p, out);
+    if (ok) {
+        skipSpace(&p);
+        if (*p.curr != '\0') ok = fail(&p, "trailing characters after value");
+    }
+    if (!ok) *err_msg = p.err_msg;
+    return ok;
+}
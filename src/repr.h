@@ -0,0 +1,26 @@
+#ifndef liss_repr_h
+#define liss_repr_h
+
+#include "object.h"
+#include "value.h"
+
+// Maximum nesting of lists, pairs and dicts accepted by parseRepr.
+#define REPR_MAX_DEPTH 64
+
+// Returns the canonical, machine-readable text of a value, or NULL if the
+// value (or anything nested in it) has no repr: functions, natives, modules,
+// files and regexes. The caller owns the returned buffer.
+//
+// The format reads like Liss source: null, true, false, integers, reals
+// (always with a '.' or exponent), escaped double-quoted strings, [a b c]
+// lists, (a . b) pairs, (dict (k . v) ...) dicts with sorted keys and
+// (err "message") errors. Reals that are not finite print as inf, -inf and
+// nan, which parseRepr reads back but Liss source does not, so only text
+// without them is valid source.
+char* reprValue(Value value);
+
+// Parses text produced by reprValue back into a value. On malformed input
+// returns false and points *err_msg at a static description.
+bool parseRepr(VM* vm, const char* src, Value* out, const char** err_msg);
+
+#endif
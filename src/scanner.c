@@ -14,6 +14,13 @@
 // https://craftinginterpreters.com/scanning-on-demand.html
 
 void initScanner(Scanner* scanner, const char* source) {
+    scanner->source = source;
+    scanner->line_start = source;
+    // A "#!" first line lets the file run as a Unix script; it is left out
+    // but still counts as line 1.
+    if (source[0] == '#' && source[1] == '!') {
+        source += strcspn(source, "\n");
+    }
     scanner->start = source;
     scanner->current = source;
     scanner->line = 1;
@@ -24,6 +31,12 @@ static char advance(Scanner* scanner) {
     return scanner->current[-1];
 }
 
+// Called once the newline itself has been consumed.
+static void newLine(Scanner* scanner) {
+    scanner->line++;
+    scanner->line_start = scanner->current;
+}
+
 static bool isAtEnd(Scanner* scanner) { return *(scanner->current) == '\0'; }
 
 static char peek(Scanner* scanner) { return *(scanner->current); }
@@ -38,11 +51,12 @@ static bool isHexDigit(Scanner* scanner) {
            (c >= 'A' && c <= 'F');
 }
 
-static bool isAlpha(Scanner* scanner) {
-    char c = peek(scanner);
+static bool isAlphaChar(char c) {
     return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_';
 }
 
+static bool isAlpha(Scanner* scanner) { return isAlphaChar(peek(scanner)); }
+
 static char peekNext(Scanner* scanner) { return *(scanner->current + 1); }
 
 // A hyphen is valid mid-identifier (Lisp convention) when followed by a letter.
@@ -85,6 +99,7 @@ static Token mkToken(Scanner* scanner, TokenType type) {
     token.start = scanner->start;
     token.length = (int)(scanner->current - scanner->start);
     token.line = scanner->line;
+    token.column = (int)(scanner->start - scanner->line_start) + 1;
     return token;
 }
 
@@ -94,10 +109,33 @@ static Token errToken(Scanner* scanner, const char* message) {
     token.start = message;
     token.length = (int)strlen(message);
     token.line = scanner->line;
+    token.column = (int)(scanner->start - scanner->line_start) + 1;
     return token;
 }
 
-static void eatWhiteSpace(Scanner* scanner) {
+// Skips a #| ... |# block comment, which may nest. The opening #| has already
+// been consumed. Returns false if the source ends before the comment closes.
+static bool eatBlockComment(Scanner* scanner) {
+    int depth = 1;
+    while (depth > 0) {
+        if (isAtEnd(scanner)) return false;
+        char c = advance(scanner);
+        if (c == '\n') {
+            newLine(scanner);
+        } else if (c == '#' && peek(scanner) == '|') {
+            advance(scanner);
+            depth++;
+        } else if (c == '|' && peek(scanner) == '#') {
+            advance(scanner);
+            depth--;
+        }
+    }
+    return true;
+}
+
+// Returns false if an unterminated block comment runs into the end of the
+// source.
+static bool eatWhiteSpace(Scanner* scanner) {
     for (;;) {
         char c = peek(scanner);
         switch (c) {
@@ -107,8 +145,8 @@ static void eatWhiteSpace(Scanner* scanner) {
                 advance(scanner);
                 break;
             case '\n':
-                scanner->line++;
                 advance(scanner);
+                newLine(scanner);
                 break;
             case ';':
                 // A comment goes until the end of the line.
@@ -116,8 +154,14 @@ static void eatWhiteSpace(Scanner* scanner) {
                     advance(scanner);
                 }
                 break;
+            case '#':
+                if (peekNext(scanner) != '|') return true;
+                advance(scanner);
+                advance(scanner);
+                if (!eatBlockComment(scanner)) return false;
+                break;
             default:
-                return;
+                return true;
         }
     }
 }
@@ -145,6 +189,37 @@ static Token identifier(Scanner* scanner) {
     return mkToken(scanner, type);
 }
 
+// Scans an accessor path like .users[0].name. The leading '.' is already
+// consumed and a name follows it.
+static Token accessor(Scanner* scanner) {
+    bool at_name = true;
+    for (;;) {
+        if (at_name) {
+            while (isAlpha(scanner) || isDigit(scanner) ||
+                   isMidHyphen(scanner)) {
+                advance(scanner);
+            }
+            at_name = false;
+        }
+        if (peek(scanner) == '[') {
+            advance(scanner);
+            if (!isDigit(scanner)) {
+                return errToken(scanner, "Expect an index after '['.");
+            }
+            while (isDigit(scanner)) advance(scanner);
+            if (peek(scanner) != ']') {
+                return errToken(scanner, "Expect ']' after an index.");
+            }
+            advance(scanner);
+        } else if (peek(scanner) == '.' && isAlphaChar(peekNext(scanner))) {
+            advance(scanner);
+            at_name = true;
+        } else {
+            return mkToken(scanner, TOKEN_ACCESSOR);
+        }
+    }
+}
+
 static Token number(Scanner* scanner) {
     bool is_real = false;
     while (isDigit(scanner)) advance(scanner);
@@ -175,6 +250,9 @@ static Token number(Scanner* scanner) {
 }
 
 static Token string(Scanner* scanner) {
+    // A string may span lines but is reported where it starts.
+    int line = scanner->line;
+    int column = (int)(scanner->start - scanner->line_start) + 1;
     size_t bptr = 0;
     size_t bufsize = STRING_LITERAL_INIT_BUF_SIZE;
     char* buf = malloc(bufsize);
@@ -182,6 +260,7 @@ static Token string(Scanner* scanner) {
 
     while (!isAtEnd(scanner)) {
         char c = advance(scanner);
+        if (c == '\n') newLine(scanner);
         if (bptr + 1 >= bufsize) {
             bufsize *= 2;
             buf = realloc(buf, bufsize);
@@ -218,6 +297,8 @@ static Token string(Scanner* scanner) {
                 Token token = mkToken(scanner, TOKEN_STRING);
                 token.start = buf;  // Set the new processed string
                 token.length = bptr;
+                token.line = line;
+                token.column = column;
                 return token;
             } else {
                 buf[bptr++] = c;
@@ -226,11 +307,16 @@ static Token string(Scanner* scanner) {
     }
 
     free(buf);
-    return errToken(scanner, "Unterminated string.");
+    Token token = errToken(scanner, "Unterminated string.");
+    token.line = line;
+    token.column = column;
+    return token;
 }
 
 Token scanToken(Scanner* scanner) {
-    eatWhiteSpace(scanner);
+    if (!eatWhiteSpace(scanner)) {
+        return errToken(scanner, "Unterminated block comment.");
+    }
 
     scanner->start = scanner->current;
 
@@ -250,7 +336,14 @@ Token scanToken(Scanner* scanner) {
             return mkToken(scanner, TOKEN_LBRAKET);
         case ']':
             return mkToken(scanner, TOKEN_RBRAKET);
+        case '#':
+            if (peek(scanner) == '[') {
+                advance(scanner);
+                return mkToken(scanner, TOKEN_HASH_LBRAKET);
+            }
+            break;
         case '.':
+            if (isAlpha(scanner)) return accessor(scanner);
             return mkToken(scanner, TOKEN_DOT);
         case '+':
             return mkToken(scanner, TOKEN_PLUS_OP);
@@ -318,9 +411,22 @@ Token scanToken(Scanner* scanner) {
                 return mkToken(scanner, TOKEN_OR_OP);
             }
         case '~':
+            if (peek(scanner) == '=') {
+                advance(scanner);
+                return mkToken(scanner, TOKEN_APPROX_EQUAL_OP);
+            }
             return mkToken(scanner, TOKEN_BNOT_OP);
         case '"':
             return string(scanner);
+        case ':':
+            if (isAlpha(scanner)) {
+                while (isAlpha(scanner) || isDigit(scanner) ||
+                       isMidHyphen(scanner)) {
+                    advance(scanner);
+                }
+                return mkToken(scanner, TOKEN_KEYWORD);
+            }
+            break;
     }
 
     return errToken(scanner, "Unexpected character.");
@@ -0,0 +1,119 @@
+#include "symbols.h"
+
+#include <stdlib.h>
+#include <string.h>
+
+#include "vm.h"
+
+static const char* kindName(SymbolKind kind) {
+    switch (kind) {
+        case SYMBOL_GLOBAL:    return "global";
+        case SYMBOL_CONST:     return "const";
+        case SYMBOL_FUNCTION:  return "function";
+        case SYMBOL_LOCAL:     return "local";
+        case SYMBOL_PARAM:     return "param";
+        case SYMBOL_REFERENCE: return "reference";
+    }
+    return "unknown";
+}
+
+int addSymbol(SymbolIndex* index, Token token, SymbolKind kind,
+              int definition) {
+    if (index->count == index->capacity) {
+        index->capacity = index->capacity < 16 ? 16 : index->capacity * 2;
+        index->symbols =
+            realloc(index->symbols, sizeof(Symbol) * index->capacity);
+    }
+    Symbol* symbol = &index->symbols[index->count];
+    symbol->name = strndup(token.start, token.length);
+    symbol->kind = kind;
+    symbol->line = token.line;
+    symbol->column = token.column;
+    symbol->definition = definition;
+    symbol->usage = NULL;
+    symbol->doc = NULL;
+    return index->count++;
+}
+
+void addSymbolDocs(SymbolIndex* index, int symbol, Value function) {
+    const char* usage;
+    const char* doc;
+    if (!functionDocs(function, &usage, &doc)) return;
+    Symbol* def = &index->symbols[symbol];
+    if (usage != NULL) def->usage = strdup(usage);
+    if (doc != NULL) def->doc = strdup(doc);
+}
+
+// Points the unresolved references to a global of the module at its first
+// definition, which may come after them.
+static void resolveGlobals(SymbolIndex* index) {
+    for (int i = 0; i < index->count; i++) {
+        Symbol* ref = &index->symbols[i];
+        if (ref->kind != SYMBOL_REFERENCE || ref->definition != -1) continue;
+        for (int j = 0; j < index->count; j++) {
+            Symbol* def = &index->symbols[j];
+            bool global = def->kind == SYMBOL_GLOBAL ||
+                          def->kind == SYMBOL_CONST ||
+                          def->kind == SYMBOL_FUNCTION;
+            if (global && strcmp(def->name, ref->name) == 0) {
+                ref->definition = j;
+                break;
+            }
+        }
+    }
+}
+
+bool indexSymbols(VM* vm, const char* source, SymbolIndex* index) {
+    vm->symbols = index;
+    ObjFunction* function = compileMain(vm, source);
+    vm->symbols = NULL;
+    resolveGlobals(index);
+    return function != NULL;
+}
+
+void freeSymbols(SymbolIndex* index) {
+    for (int i = 0; i < index->count; i++) {
+        free(index->symbols[i].name);
+        free(index->symbols[i].usage);
+        free(index->symbols[i].doc);
+    }
+    free(index->symbols);
+    index->symbols = NULL;
+    index->count = 0;
+    index->capacity = 0;
+}
+
+// Writes a name as a JSON string.
+static void writeJsonName(const char* name, FILE* out) {
+    fputc('"', out);
+    for (const char* c = name; *c != '\0'; c++) {
+        if (*c == '"' || *c == '\\') {
+            fprintf(out, "\\%c", *c);
+        } else if ((unsigned char)*c < ' ') {
+            fprintf(out, "\\u%04x", *c);
+        } else {
+            fputc(*c, out);
+        }
+    }
+    fputc('"', out);
+}
+
+void writeSymbolsJson(const SymbolIndex* index, FILE* out) {
+    fputs("{\n  \"symbols\": [", out);
+    for (int i = 0; i < index->count; i++) {
+        const Symbol* symbol = &index->symbols[i];
+        fprintf(out, "%s\n    {\"name\": ", i > 0 ? "," : "");
+        writeJsonName(symbol->name, out);
+        fprintf(out, ", \"kind\": \"%s\", \"line\": %d, \"column\": %d",
+                kindName(symbol->kind), symbol->line, symbol->column);
+        if (symbol->kind == SYMBOL_REFERENCE) {
+            if (symbol->definition >= 0) {
+                fprintf(out, ", \"definition\": %d", symbol->definition);
+            } else {
+                fputs(", \"definition\": null", out);
+            }
+        }
+        fputc('}', out);
+    }
+    fprintf(out, "%s]\n}\n", index->count > 0 ? "\n  " : "");
+}
@@ -0,0 +1,63 @@
+#ifndef liss_symbols_h
+#define liss_symbols_h
+
+#include <stdbool.h>
+#include <stdio.h>
+
+#include "token.h"
+#include "value.h"
+
+typedef struct VM VM;
+
+typedef enum {
+    SYMBOL_GLOBAL,     // A let at the top level
+    SYMBOL_CONST,      // A const
+    SYMBOL_FUNCTION,   // A named fn at the top level
+    SYMBOL_LOCAL,      // A let, named fn, for or try binding in a function
+    SYMBOL_PARAM,      // A parameter of a function
+    SYMBOL_REFERENCE,  // A use of a name, or the target of a set!
+} SymbolKind;
+
+// A name where it is defined or used in the source.
+typedef struct {
+    char* name;
+    SymbolKind kind;
+    int line;
+    int column;
+    // For a reference, the index of the definition it resolves to, or -1 if
+    // that is not in the source, like a native or a global of another module.
+    int definition;
+    // For a function, how to call it and its docstring, NULL if unknown.
+    char* usage;
+    char* doc;
+} Symbol;
+
+typedef struct {
+    int count;
+    int capacity;
+    Symbol* symbols;  // In the order the compiler met them
+} SymbolIndex;
+
+// Compiles source as the main module of vm, without running it, and adds every
+// definition and reference of a name in it to index. A reference to a global
+// resolves to its definition even when it comes first, as in a recursive
+// function. Returns false on a compile error, described in vm->error_msg;
+// the symbols of the forms that compiled are in index anyway.
+bool indexSymbols(VM* vm, const char* source, SymbolIndex* index);
+
+void freeSymbols(SymbolIndex* index);
+
+// Keeps the usage and docstring of function, the value symbol defines, as
+// (doc f) finds them.
+void addSymbolDocs(SymbolIndex* index, int symbol, Value function);
+
+// Appends a symbol named by token to index and returns its position in it.
+int addSymbol(SymbolIndex* index, Token token, SymbolKind kind,
+              int definition);
+
+// Writes index as a JSON object with a "symbols" array, each element holding
+// the name, kind, line and column of a symbol, and for a reference the index
+// of its definition in the array or null.
+void writeSymbolsJson(const SymbolIndex* index, FILE* out);
+
+#endif
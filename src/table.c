@@ -2,6 +2,7 @@
 
 #include <stdlib.h>
 #include <string.h>
+#include <strings.h>
 
 #include "common.h"
 #include "object.h"
@@ -205,3 +206,80 @@ ObjString* tableFindString(Table* table, const char* chars, int length,
 }
 
 void tableNoRehash(Table* table) { table->no_rehash = true; }
+
+void tableAddAll(Table* from, Table* to) {
+    for (size_t i = 0; i < from->bucket_count; i++) {
+        for (TableEntry* entry = from->buckets[i]; entry != NULL;
+             entry = entry->next) {
+            tableInsert(to, entry->key, entry->value);
+        }
+    }
+}
+
+void tableRemoveWhite(Table* table) {
+    for (size_t i = 0; i < table->bucket_count; i++) {
+        TableEntry** link = &table->buckets[i];
+        while (*link != NULL) {
+            TableEntry* entry = *link;
+            if (IS_OBJ(entry->key) && !AS_OBJ(entry->key)->isMarked) {
+                *link = entry->next;
+                free(entry);
+                table->size--;
+            } else {
+                link = &entry->next;
+            }
+        }
+    }
+}
+
+void tableEach(Table* table, void (*fn)(TableEntry* entry, void* ctx),
+               void* ctx) {
+    for (size_t i = 0; i < table->bucket_count; i++) {
+        for (TableEntry* entry = table->buckets[i]; entry != NULL;
+             entry = entry->next) {
+            fn(entry, ctx);
+        }
+    }
+}
+
+TableEntry* tableFindIgnoreCase(Table* table, const char* name) {
+    size_t len = strlen(name);
+    for (size_t i = 0; i < table->bucket_count; i++) {
+        for (TableEntry* entry = table->buckets[i]; entry != NULL;
+             entry = entry->next) {
+            if (!IS_STRING(entry->key)) continue;
+            ObjString* key = AS_STRING(entry->key);
+            if ((size_t)key->length == len &&
+                strncasecmp(key->chars, name, len) == 0) {
+                return entry;
+            }
+        }
+    }
+    return NULL;
+}
+
+static int cmpStrings(const void* a, const void* b) {
+    return strcmp((*(ObjString* const*)a)->chars,
+                  (*(ObjString* const*)b)->chars);
+}
+
+ObjString** tableFindByPrefix(Table* table, const char* prefix,
+                              bool ignore_case, size_t* count) {
+    size_t len = strlen(prefix);
+    ObjString** found = malloc(sizeof(ObjString*) * (table->size + 1));
+    size_t n = 0;
+    for (size_t i = 0; i < table->bucket_count; i++) {
+        for (TableEntry* entry = table->buckets[i]; entry != NULL;
+             entry = entry->next) {
+            if (!IS_STRING(entry->key)) continue;
+            ObjString* key = AS_STRING(entry->key);
+            if ((size_t)key->length < len) continue;
+            int diff = ignore_case ? strncasecmp(key->chars, prefix, len)
+                                   : strncmp(key->chars, prefix, len);
+            if (diff == 0) found[n++] = key;
+        }
+    }
+    qsort(found, n, sizeof(ObjString*), cmpStrings);
+    *count = n;
+    return found;
+}
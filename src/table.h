@@ -34,4 +34,26 @@ ObjString* tableFindString(Table* table, const char* chars, int length,
 
 void tableNoRehash(Table* table);
 
+// Inserts every entry of from into to, replacing the values of keys to has.
+void tableAddAll(Table* from, Table* to);
+
+// Drops the entries whose key is an object the GC has not marked, so that the
+// table does not keep its keys alive. Called between tracing and sweeping.
+void tableRemoveWhite(Table* table);
+
+// Calls fn for every entry, in no particular order. fn must not modify the
+// table.
+void tableEach(Table* table, void (*fn)(TableEntry* entry, void* ctx),
+               void* ctx);
+
+// Looks up a string key ignoring ASCII case. When several keys differ only in
+// case, any one of them may be returned.
+TableEntry* tableFindIgnoreCase(Table* table, const char* name);
+
+// Returns the string keys that start with prefix, sorted, and stores their
+// number in *count. An empty prefix lists every string key. The caller frees
+// the array (not the strings).
+ObjString** tableFindByPrefix(Table* table, const char* prefix,
+                              bool ignore_case, size_t* count);
+
 #endif
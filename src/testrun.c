@@ -0,0 +1,174 @@
+#define _POSIX_C_SOURCE 200809L
+
+#include "testrun.h"
+
+#include <dirent.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/stat.h>
+
+#include "common.h"
+#include "memory.h"
+#include "object.h"
+#include "value.h"
+
+#define TEST_FILE_SUFFIX "_test" LISS_FILE_EXT
+
+typedef struct {
+    int count;
+    int capacity;
+    char** paths;
+} PathList;
+
+typedef struct {
+    int passed;
+    int failed;
+} Tally;
+
+static void addPath(PathList* list, char* path) {
+    if (list->count == list->capacity) {
+        list->capacity = GROW_CAPACITY(list->capacity);
+        list->paths = realloc(list->paths, sizeof(char*) * list->capacity);
+    }
+    list->paths[list->count++] = path;
+}
+
+static bool isTestFile(const char* name) {
+    size_t len = strlen(name);
+    size_t suffix = strlen(TEST_FILE_SUFFIX);
+    return len > suffix && strcmp(name + len - suffix, TEST_FILE_SUFFIX) == 0;
+}
+
+// Adds the test files under dir to list. Returns false if dir cannot be read.
+static bool findTests(const char* dir, PathList* list) {
+    DIR* d = opendir(dir);
+    if (d == NULL) return false;
+    for (struct dirent* entry; (entry = readdir(d)) != NULL;) {
+        if (entry->d_name[0] == '.') continue;
+        size_t len = strlen(dir) + strlen(entry->d_name) + 2;
+        char* path = malloc(len);
+        snprintf(path, len, "%s/%s", dir, entry->d_name);
+        struct stat st;
+        if (stat(path, &st) == 0 && S_ISDIR(st.st_mode)) {
+            findTests(path, list);
+            free(path);
+        } else if (isTestFile(entry->d_name)) {
+            addPath(list, path);
+        } else {
+            free(path);
+        }
+    }
+    closedir(d);
+    return true;
+}
+
+static int comparePaths(const void* a, const void* b) {
+    return strcmp(*(char* const*)a, *(char* const*)b);
+}
+
+static char* readSource(const char* path) {
+    FILE* file = fopen(path, "rb");
+    if (file == NULL) return NULL;
+    fseek(file, 0L, SEEK_END);
+    long size = ftell(file);
+    rewind(file);
+    char* source = malloc(size + 1);
+    size_t read = fread(source, 1, size, file);
+    source[read] = '\0';
+    fclose(file);
+    return source;
+}
+
+// Prints a failure with the message of what was raised or returned.
+static void reportFailure(FILE* out, const char* name, Value error) {
+    if (IS_ERROR(error)) {
+        fprintf(out, "  FAIL %s: %s\n", name, AS_ERROR(error)->message->chars);
+        return;
+    }
+    char* str = sprintValue(error);
+    fprintf(out, "  FAIL %s: %s\n", name, str);
+    free(str);
+}
+
+static void runFileTests(const char* path, VMOptions options, FILE* out,
+                         Tally* tally) {
+    fprintf(out, "%s\n", path);
+    char* source = readSource(path);
+    if (source == NULL) {
+        fprintf(out, "  FAIL <load>: could not read the file\n");
+        tally->failed++;
+        return;
+    }
+    VM* vm = newVM(options);
+    if (vm == NULL) {
+        fprintf(out, "  FAIL <load>: could not create VM\n");
+        free(source);
+        tally->failed++;
+        return;
+    }
+    InterpretResult result = interpret(vm, source, NULL);
+    free(source);
+    if (result == INTERPRET_COMPILE_ERROR) {
+        fprintf(out, "  FAIL <load>: %s\n", vm->error_msg);
+        tally->failed++;
+        destroyVM(vm);
+        return;
+    }
+    if (result != INTERPRET_OK) {
+        reportFailure(out, "<load>", vm->raise_value);
+        tally->failed++;
+        destroyVM(vm);
+        return;
+    }
+
+    for (int i = 0; i < vm->tests.count; i++) {
+        ObjPair* test = AS_PAIR(vm->tests.values[i]);
+        const char* name = AS_CSTRING(test->first);
+        vmRecover(vm);
+        Value value = callFromNative(vm, test->second, 0, NULL);
+        if (vm->last_result != INTERPRET_OK) {
+            reportFailure(out, name, vm->raise_value);
+            tally->failed++;
+        } else if (IS_ERROR(value)) {
+            reportFailure(out, name, value);
+            tally->failed++;
+        } else {
+            fprintf(out, "  ok   %s\n", name);
+            tally->passed++;
+        }
+    }
+    destroyVM(vm);
+}
+
+int runTests(const char* path, VMOptions options, FILE* out) {
+    PathList list = {0};
+    struct stat st;
+    if (stat(path, &st) != 0) {
+        fprintf(stderr, "Could not open \"%s\".\n", path);
+        return 74;
+    }
+    if (S_ISDIR(st.st_mode)) {
+        if (!findTests(path, &list)) {
+            fprintf(stderr, "Could not open directory \"%s\".\n", path);
+            return 74;
+        }
+    } else {
+        addPath(&list, strdup(path));
+    }
+    if (list.count == 0) {
+        fprintf(stderr, "No *%s files under \"%s\".\n", TEST_FILE_SUFFIX,
+                path);
+        free(list.paths);
+        return 1;
+    }
+    qsort(list.paths, list.count, sizeof(char*), comparePaths);
+
+    Tally tally = {0};
+    for (int i = 0; i < list.count; i++) {
+        runFileTests(list.paths[i], options, out, &tally);
+        free(list.paths[i]);
+    }
+    free(list.paths);
+    fprintf(out, "%d passed, %d failed\n", tally.passed, tally.failed);
+    return tally.failed > 0 ? 1 : 0;
+}
@@ -0,0 +1,17 @@
+#ifndef liss_testrun_h
+#define liss_testrun_h
+
+#include <stdio.h>
+
+#include "vm.h"
+
+// Runs every *_test.liss file under path, a directory searched recursively
+// or a single file, in sorted order and each in a fresh VM. Loading a file
+// registers its tests with test:deftest; they then run one by one and a test
+// fails if it raises or returns an error. A file that does not load counts as
+// one failure. Prints a line per test and a summary to out. Returns the exit
+// status for the process: 0 when every test passed, 1 when one failed or no
+// test file was found, 74 when path cannot be read.
+int runTests(const char* path, VMOptions options, FILE* out);
+
+#endif
@@ -108,6 +108,10 @@ const char* printTokenType(TokenType type) {
             return "TOKEN_NOT_EQUAL_OP";
         case TOKEN_NOT_EQUAL_KW:
             return "TOKEN_NOT_EQUAL_KW";
+        case TOKEN_APPROX_EQUAL_OP:
+            return "TOKEN_APPROX_EQUAL_OP";
+        case TOKEN_APPROX_EQUAL_KW:
+            return "TOKEN_APPROX_EQUAL_KW";
         case TOKEN_LPAREN:
             return "TOKEN_LPAREN";
         case TOKEN_RPAREN:
@@ -116,12 +120,30 @@ const char* printTokenType(TokenType type) {
             return "TOKEN_LBRAKET";
         case TOKEN_RBRAKET:
             return "TOKEN_RBRAKET";
+        case TOKEN_HASH_LBRAKET:
+            return "TOKEN_HASH_LBRAKET";
         case TOKEN_ERROR:
             return "TOKEN_ERROR";
         case TOKEN_EOF:
             return "TOKEN_EOF";
         case TOKEN_ARROW_KW:
             return "TOKEN_ARROW_KW";
+        case TOKEN_PRAGMA_KW:
+            return "TOKEN_PRAGMA_KW";
+        case TOKEN_KEYWORD:
+            return "TOKEN_KEYWORD";
+        case TOKEN_ACCESSOR:
+            return "TOKEN_ACCESSOR";
+        case TOKEN_SET_KW:
+            return "TOKEN_SET_KW";
+        case TOKEN_WHILE_KW:
+            return "TOKEN_WHILE_KW";
+        case TOKEN_FOR_KW:
+            return "TOKEN_FOR_KW";
+        case TOKEN_CONST_KW:
+            return "TOKEN_CONST_KW";
+        case TOKEN_RETURN_KW:
+            return "TOKEN_RETURN_KW";
         default:
             return "UNKNOWN_TOKEN";
     }
@@ -13,6 +13,7 @@ typedef enum {
     TOKEN_RPAREN,
     TOKEN_LBRAKET,
     TOKEN_RBRAKET,
+    TOKEN_HASH_LBRAKET,  // #[ opening a tuple literal
     TOKEN_DOT,
 
     TOKEN_PLUS_OP,
@@ -36,6 +37,8 @@ typedef enum {
     TOKEN_EQUAL_KW,
     TOKEN_NOT_EQUAL_OP,
     TOKEN_NOT_EQUAL_KW,
+    TOKEN_APPROX_EQUAL_OP,
+    TOKEN_APPROX_EQUAL_KW,
     TOKEN_LESS_OP,
     TOKEN_LESS_KW,
     TOKEN_LESS_EQUAL_OP,
@@ -73,6 +76,14 @@ typedef enum {
     TOKEN_AS_KW,
     TOKEN_BREAKPOINT_KW,
     TOKEN_ARROW_KW,
+    TOKEN_PRAGMA_KW,
+    TOKEN_KEYWORD,  // :name, only valid as a pragma name for now
+    TOKEN_ACCESSOR,  // A path like .users[0].name
+    TOKEN_SET_KW,
+    TOKEN_WHILE_KW,
+    TOKEN_FOR_KW,
+    TOKEN_CONST_KW,
+    TOKEN_RETURN_KW,
 } TokenType;
 
 typedef struct {
@@ -80,6 +91,7 @@ typedef struct {
     const char* start;
     int length;
     int line;
+    int column;  // 1-based, 0 for tokens the compiler makes up
 } Token;
 
 const char* printTokenType(TokenType type);
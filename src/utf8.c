@@ -0,0 +1,526 @@
+#include "utf8.h"
+
+#include <stdlib.h>
+#include <string.h>
+
+// A canonical decomposition: rune stands for first followed by second, or
+// for first alone when second is 0. first may decompose further.
+typedef struct {
+    int32_t rune;
+    int32_t first;
+    int32_t second;
+} Decomposition;
+
+// The canonical decompositions of the Latin, Greek and Cyrillic letters, as
+// in UnicodeData.txt of Unicode 14.0, sorted by rune.
+static const Decomposition decompositions[] = {
+    {0x00C0, 0x0041, 0x0300}, {0x00C1, 0x0041, 0x0301},
+    {0x00C2, 0x0041, 0x0302}, {0x00C3, 0x0041, 0x0303},
+    {0x00C4, 0x0041, 0x0308}, {0x00C5, 0x0041, 0x030A},
+    {0x00C7, 0x0043, 0x0327}, {0x00C8, 0x0045, 0x0300},
+    {0x00C9, 0x0045, 0x0301}, {0x00CA, 0x0045, 0x0302},
+    {0x00CB, 0x0045, 0x0308}, {0x00CC, 0x0049, 0x0300},
+    {0x00CD, 0x0049, 0x0301}, {0x00CE, 0x0049, 0x0302},
+    {0x00CF, 0x0049, 0x0308}, {0x00D1, 0x004E, 0x0303},
+    {0x00D2, 0x004F, 0x0300}, {0x00D3, 0x004F, 0x0301},
+    {0x00D4, 0x004F, 0x0302}, {0x00D5, 0x004F, 0x0303},
+    {0x00D6, 0x004F, 0x0308}, {0x00D9, 0x0055, 0x0300},
+    {0x00DA, 0x0055, 0x0301}, {0x00DB, 0x0055, 0x0302},
+    {0x00DC, 0x0055, 0x0308}, {0x00DD, 0x0059, 0x0301},
+    {0x00E0, 0x0061, 0x0300}, {0x00E1, 0x0061, 0x0301},
+    {0x00E2, 0x0061, 0x0302}, {0x00E3, 0x0061, 0x0303},
+    {0x00E4, 0x0061, 0x0308}, {0x00E5, 0x0061, 0x030A},
+    {0x00E7, 0x0063, 0x0327}, {0x00E8, 0x0065, 0x0300},
+    {0x00E9, 0x0065, 0x0301}, {0x00EA, 0x0065, 0x0302},
+    {0x00EB, 0x0065, 0x0308}, {0x00EC, 0x0069, 0x0300},
+    {0x00ED, 0x0069, 0x0301}, {0x00EE, 0x0069, 0x0302},
+    {0x00EF, 0x0069, 0x0308}, {0x00F1, 0x006E, 0x0303},
+    {0x00F2, 0x006F, 0x0300}, {0x00F3, 0x006F, 0x0301},
+    {0x00F4, 0x006F, 0x0302}, {0x00F5, 0x006F, 0x0303},
+    {0x00F6, 0x006F, 0x0308}, {0x00F9, 0x0075, 0x0300},
+    {0x00FA, 0x0075, 0x0301}, {0x00FB, 0x0075, 0x0302},
+    {0x00FC, 0x0075, 0x0308}, {0x00FD, 0x0079, 0x0301},
+    {0x00FF, 0x0079, 0x0308}, {0x0100, 0x0041, 0x0304},
+    {0x0101, 0x0061, 0x0304}, {0x0102, 0x0041, 0x0306},
+    {0x0103, 0x0061, 0x0306}, {0x0104, 0x0041, 0x0328},
+    {0x0105, 0x0061, 0x0328}, {0x0106, 0x0043, 0x0301},
+    {0x0107, 0x0063, 0x0301}, {0x0108, 0x0043, 0x0302},
+    {0x0109, 0x0063, 0x0302}, {0x010A, 0x0043, 0x0307},
+    {0x010B, 0x0063, 0x0307}, {0x010C, 0x0043, 0x030C},
+    {0x010D, 0x0063, 0x030C}, {0x010E, 0x0044, 0x030C},
+    {0x010F, 0x0064, 0x030C}, {0x0112, 0x0045, 0x0304},
+    {0x0113, 0x0065, 0x0304}, {0x0114, 0x0045, 0x0306},
+    {0x0115, 0x0065, 0x0306}, {0x0116, 0x0045, 0x0307},
+    {0x0117, 0x0065, 0x0307}, {0x0118, 0x0045, 0x0328},
+    {0x0119, 0x0065, 0x0328}, {0x011A, 0x0045, 0x030C},
+    {0x011B, 0x0065, 0x030C}, {0x011C, 0x0047, 0x0302},
+    {0x011D, 0x0067, 0x0302}, {0x011E, 0x0047, 0x0306},
+    {0x011F, 0x0067, 0x0306}, {0x0120, 0x0047, 0x0307},
+    {0x0121, 0x0067, 0x0307}, {0x0122, 0x0047, 0x0327},
+    {0x0123, 0x0067, 0x0327}, {0x0124, 0x0048, 0x0302},
+    {0x0125, 0x0068, 0x0302}, {0x0128, 0x0049, 0x0303},
+    {0x0129, 0x0069, 0x0303}, {0x012A, 0x0049, 0x0304},
+    {0x012B, 0x0069, 0x0304}, {0x012C, 0x0049, 0x0306},
+    {0x012D, 0x0069, 0x0306}, {0x012E, 0x0049, 0x0328},
+    {0x012F, 0x0069, 0x0328}, {0x0130, 0x0049, 0x0307},
+    {0x0134, 0x004A, 0x0302}, {0x0135, 0x006A, 0x0302},
+    {0x0136, 0x004B, 0x0327}, {0x0137, 0x006B, 0x0327},
+    {0x0139, 0x004C, 0x0301}, {0x013A, 0x006C, 0x0301},
+    {0x013B, 0x004C, 0x0327}, {0x013C, 0x006C, 0x0327},
+    {0x013D, 0x004C, 0x030C}, {0x013E, 0x006C, 0x030C},
+    {0x0143, 0x004E, 0x0301}, {0x0144, 0x006E, 0x0301},
+    {0x0145, 0x004E, 0x0327}, {0x0146, 0x006E, 0x0327},
+    {0x0147, 0x004E, 0x030C}, {0x0148, 0x006E, 0x030C},
+    {0x014C, 0x004F, 0x0304}, {0x014D, 0x006F, 0x0304},
+    {0x014E, 0x004F, 0x0306}, {0x014F, 0x006F, 0x0306},
+    {0x0150, 0x004F, 0x030B}, {0x0151, 0x006F, 0x030B},
+    {0x0154, 0x0052, 0x0301}, {0x0155, 0x0072, 0x0301},
+    {0x0156, 0x0052, 0x0327}, {0x0157, 0x0072, 0x0327},
+    {0x0158, 0x0052, 0x030C}, {0x0159, 0x0072, 0x030C},
+    {0x015A, 0x0053, 0x0301}, {0x015B, 0x0073, 0x0301},
+    {0x015C, 0x0053, 0x0302}, {0x015D, 0x0073, 0x0302},
+    {0x015E, 0x0053, 0x0327}, {0x015F, 0x0073, 0x0327},
+    {0x0160, 0x0053, 0x030C}, {0x0161, 0x0073, 0x030C},
+    {0x0162, 0x0054, 0x0327}, {0x0163, 0x0074, 0x0327},
+    {0x0164, 0x0054, 0x030C}, {0x0165, 0x0074, 0x030C},
+    {0x0168, 0x0055, 0x0303}, {0x0169, 0x0075, 0x0303},
+    {0x016A, 0x0055, 0x0304}, {0x016B, 0x0075, 0x0304},
+    {0x016C, 0x0055, 0x0306}, {0x016D, 0x0075, 0x0306},
+    {0x016E, 0x0055, 0x030A}, {0x016F, 0x0075, 0x030A},
+    {0x0170, 0x0055, 0x030B}, {0x0171, 0x0075, 0x030B},
+    {0x0172, 0x0055, 0x0328}, {0x0173, 0x0075, 0x0328},
+    {0x0174, 0x0057, 0x0302}, {0x0175, 0x0077, 0x0302},
+    {0x0176, 0x0059, 0x0302}, {0x0177, 0x0079, 0x0302},
+    {0x0178, 0x0059, 0x0308}, {0x0179, 0x005A, 0x0301},
+    {0x017A, 0x007A, 0x0301}, {0x017B, 0x005A, 0x0307},
+    {0x017C, 0x007A, 0x0307}, {0x017D, 0x005A, 0x030C},
+    {0x017E, 0x007A, 0x030C}, {0x01A0, 0x004F, 0x031B},
+    {0x01A1, 0x006F, 0x031B}, {0x01AF, 0x0055, 0x031B},
+    {0x01B0, 0x0075, 0x031B}, {0x01CD, 0x0041, 0x030C},
+    {0x01CE, 0x0061, 0x030C}, {0x01CF, 0x0049, 0x030C},
+    {0x01D0, 0x0069, 0x030C}, {0x01D1, 0x004F, 0x030C},
+    {0x01D2, 0x006F, 0x030C}, {0x01D3, 0x0055, 0x030C},
+    {0x01D4, 0x0075, 0x030C}, {0x01D5, 0x00DC, 0x0304},
+    {0x01D6, 0x00FC, 0x0304}, {0x01D7, 0x00DC, 0x0301},
+    {0x01D8, 0x00FC, 0x0301}, {0x01D9, 0x00DC, 0x030C},
+    {0x01DA, 0x00FC, 0x030C}, {0x01DB, 0x00DC, 0x0300},
+    {0x01DC, 0x00FC, 0x0300}, {0x01DE, 0x00C4, 0x0304},
+    {0x01DF, 0x00E4, 0x0304}, {0x01E0, 0x0226, 0x0304},
+    {0x01E1, 0x0227, 0x0304}, {0x01E2, 0x00C6, 0x0304},
+    {0x01E3, 0x00E6, 0x0304}, {0x01E6, 0x0047, 0x030C},
+    {0x01E7, 0x0067, 0x030C}, {0x01E8, 0x004B, 0x030C},
+    {0x01E9, 0x006B, 0x030C}, {0x01EA, 0x004F, 0x0328},
+    {0x01EB, 0x006F, 0x0328}, {0x01EC, 0x01EA, 0x0304},
+    {0x01ED, 0x01EB, 0x0304}, {0x01EE, 0x01B7, 0x030C},
+    {0x01EF, 0x0292, 0x030C}, {0x01F0, 0x006A, 0x030C},
+    {0x01F4, 0x0047, 0x0301}, {0x01F5, 0x0067, 0x0301},
+    {0x01F8, 0x004E, 0x0300}, {0x01F9, 0x006E, 0x0300},
+    {0x01FA, 0x00C5, 0x0301}, {0x01FB, 0x00E5, 0x0301},
+    {0x01FC, 0x00C6, 0x0301}, {0x01FD, 0x00E6, 0x0301},
+    {0x01FE, 0x00D8, 0x0301}, {0x01FF, 0x00F8, 0x0301},
+    {0x0200, 0x0041, 0x030F}, {0x0201, 0x0061, 0x030F},
+    {0x0202, 0x0041, 0x0311}, {0x0203, 0x0061, 0x0311},
+    {0x0204, 0x0045, 0x030F}, {0x0205, 0x0065, 0x030F},
+    {0x0206, 0x0045, 0x0311}, {0x0207, 0x0065, 0x0311},
+    {0x0208, 0x0049, 0x030F}, {0x0209, 0x0069, 0x030F},
+    {0x020A, 0x0049, 0x0311}, {0x020B, 0x0069, 0x0311},
+    {0x020C, 0x004F, 0x030F}, {0x020D, 0x006F, 0x030F},
+    {0x020E, 0x004F, 0x0311}, {0x020F, 0x006F, 0x0311},
+    {0x0210, 0x0052, 0x030F}, {0x0211, 0x0072, 0x030F},
+    {0x0212, 0x0052, 0x0311}, {0x0213, 0x0072, 0x0311},
+    {0x0214, 0x0055, 0x030F}, {0x0215, 0x0075, 0x030F},
+    {0x0216, 0x0055, 0x0311}, {0x0217, 0x0075, 0x0311},
+    {0x0218, 0x0053, 0x0326}, {0x0219, 0x0073, 0x0326},
+    {0x021A, 0x0054, 0x0326}, {0x021B, 0x0074, 0x0326},
+    {0x021E, 0x0048, 0x030C}, {0x021F, 0x0068, 0x030C},
+    {0x0226, 0x0041, 0x0307}, {0x0227, 0x0061, 0x0307},
+    {0x0228, 0x0045, 0x0327}, {0x0229, 0x0065, 0x0327},
+    {0x022A, 0x00D6, 0x0304}, {0x022B, 0x00F6, 0x0304},
+    {0x022C, 0x00D5, 0x0304}, {0x022D, 0x00F5, 0x0304},
+    {0x022E, 0x004F, 0x0307}, {0x022F, 0x006F, 0x0307},
+    {0x0230, 0x022E, 0x0304}, {0x0231, 0x022F, 0x0304},
+    {0x0232, 0x0059, 0x0304}, {0x0233, 0x0079, 0x0304},
+    {0x0374, 0x02B9, 0x0000}, {0x037E, 0x003B, 0x0000},
+    {0x0385, 0x00A8, 0x0301}, {0x0386, 0x0391, 0x0301},
+    {0x0387, 0x00B7, 0x0000}, {0x0388, 0x0395, 0x0301},
+    {0x0389, 0x0397, 0x0301}, {0x038A, 0x0399, 0x0301},
+    {0x038C, 0x039F, 0x0301}, {0x038E, 0x03A5, 0x0301},
+    {0x038F, 0x03A9, 0x0301}, {0x0390, 0x03CA, 0x0301},
+    {0x03AA, 0x0399, 0x0308}, {0x03AB, 0x03A5, 0x0308},
+    {0x03AC, 0x03B1, 0x0301}, {0x03AD, 0x03B5, 0x0301},
+    {0x03AE, 0x03B7, 0x0301}, {0x03AF, 0x03B9, 0x0301},
+    {0x03B0, 0x03CB, 0x0301}, {0x03CA, 0x03B9, 0x0308},
+    {0x03CB, 0x03C5, 0x0308}, {0x03CC, 0x03BF, 0x0301},
+    {0x03CD, 0x03C5, 0x0301}, {0x03CE, 0x03C9, 0x0301},
+    {0x03D3, 0x03D2, 0x0301}, {0x03D4, 0x03D2, 0x0308},
+    {0x0400, 0x0415, 0x0300}, {0x0401, 0x0415, 0x0308},
+    {0x0403, 0x0413, 0x0301}, {0x0407, 0x0406, 0x0308},
+    {0x040C, 0x041A, 0x0301}, {0x040D, 0x0418, 0x0300},
+    {0x040E, 0x0423, 0x0306}, {0x0419, 0x0418, 0x0306},
+    {0x0439, 0x0438, 0x0306}, {0x0450, 0x0435, 0x0300},
+    {0x0451, 0x0435, 0x0308}, {0x0453, 0x0433, 0x0301},
+    {0x0457, 0x0456, 0x0308}, {0x045C, 0x043A, 0x0301},
+    {0x045D, 0x0438, 0x0300}, {0x045E, 0x0443, 0x0306},
+    {0x0476, 0x0474, 0x030F}, {0x0477, 0x0475, 0x030F},
+    {0x04C1, 0x0416, 0x0306}, {0x04C2, 0x0436, 0x0306},
+    {0x04D0, 0x0410, 0x0306}, {0x04D1, 0x0430, 0x0306},
+    {0x04D2, 0x0410, 0x0308}, {0x04D3, 0x0430, 0x0308},
+    {0x04D6, 0x0415, 0x0306}, {0x04D7, 0x0435, 0x0306},
+    {0x04DA, 0x04D8, 0x0308}, {0x04DB, 0x04D9, 0x0308},
+    {0x04DC, 0x0416, 0x0308}, {0x04DD, 0x0436, 0x0308},
+    {0x04DE, 0x0417, 0x0308}, {0x04DF, 0x0437, 0x0308},
+    {0x04E2, 0x0418, 0x0304}, {0x04E3, 0x0438, 0x0304},
+    {0x04E4, 0x0418, 0x0308}, {0x04E5, 0x0438, 0x0308},
+    {0x04E6, 0x041E, 0x0308}, {0x04E7, 0x043E, 0x0308},
+    {0x04EA, 0x04E8, 0x0308}, {0x04EB, 0x04E9, 0x0308},
+    {0x04EC, 0x042D, 0x0308}, {0x04ED, 0x044D, 0x0308},
+    {0x04EE, 0x0423, 0x0304}, {0x04EF, 0x0443, 0x0304},
+    {0x04F0, 0x0423, 0x0308}, {0x04F1, 0x0443, 0x0308},
+    {0x04F2, 0x0423, 0x030B}, {0x04F3, 0x0443, 0x030B},
+    {0x04F4, 0x0427, 0x0308}, {0x04F5, 0x0447, 0x0308},
+    {0x04F8, 0x042B, 0x0308}, {0x04F9, 0x044B, 0x0308},
+    {0x1E00, 0x0041, 0x0325}, {0x1E01, 0x0061, 0x0325},
+    {0x1E02, 0x0042, 0x0307}, {0x1E03, 0x0062, 0x0307},
+    {0x1E04, 0x0042, 0x0323}, {0x1E05, 0x0062, 0x0323},
+    {0x1E06, 0x0042, 0x0331}, {0x1E07, 0x0062, 0x0331},
+    {0x1E08, 0x00C7, 0x0301}, {0x1E09, 0x00E7, 0x0301},
+    {0x1E0A, 0x0044, 0x0307}, {0x1E0B, 0x0064, 0x0307},
+    {0x1E0C, 0x0044, 0x0323}, {0x1E0D, 0x0064, 0x0323},
+    {0x1E0E, 0x0044, 0x0331}, {0x1E0F, 0x0064, 0x0331},
+    {0x1E10, 0x0044, 0x0327}, {0x1E11, 0x0064, 0x0327},
+    {0x1E12, 0x0044, 0x032D}, {0x1E13, 0x0064, 0x032D},
+    {0x1E14, 0x0112, 0x0300}, {0x1E15, 0x0113, 0x0300},
+    {0x1E16, 0x0112, 0x0301}, {0x1E17, 0x0113, 0x0301},
+    {0x1E18, 0x0045, 0x032D}, {0x1E19, 0x0065, 0x032D},
+    {0x1E1A, 0x0045, 0x0330}, {0x1E1B, 0x0065, 0x0330},
+    {0x1E1C, 0x0228, 0x0306}, {0x1E1D, 0x0229, 0x0306},
+    {0x1E1E, 0x0046, 0x0307}, {0x1E1F, 0x0066, 0x0307},
+    {0x1E20, 0x0047, 0x0304}, {0x1E21, 0x0067, 0x0304},
+    {0x1E22, 0x0048, 0x0307}, {0x1E23, 0x0068, 0x0307},
+    {0x1E24, 0x0048, 0x0323}, {0x1E25, 0x0068, 0x0323},
+    {0x1E26, 0x0048, 0x0308}, {0x1E27, 0x0068, 0x0308},
+    {0x1E28, 0x0048, 0x0327}, {0x1E29, 0x0068, 0x0327},
+    {0x1E2A, 0x0048, 0x032E}, {0x1E2B, 0x0068, 0x032E},
+    {0x1E2C, 0x0049, 0x0330}, {0x1E2D, 0x0069, 0x0330},
+    {0x1E2E, 0x00CF, 0x0301}, {0x1E2F, 0x00EF, 0x0301},
+    {0x1E30, 0x004B, 0x0301}, {0x1E31, 0x006B, 0x0301},
+    {0x1E32, 0x004B, 0x0323}, {0x1E33, 0x006B, 0x0323},
+    {0x1E34, 0x004B, 0x0331}, {0x1E35, 0x006B, 0x0331},
+    {0x1E36, 0x004C, 0x0323}, {0x1E37, 0x006C, 0x0323},
+    {0x1E38, 0x1E36, 0x0304}, {0x1E39, 0x1E37, 0x0304},
+    {0x1E3A, 0x004C, 0x0331}, {0x1E3B, 0x006C, 0x0331},
+    {0x1E3C, 0x004C, 0x032D}, {0x1E3D, 0x006C, 0x032D},
+    {0x1E3E, 0x004D, 0x0301}, {0x1E3F, 0x006D, 0x0301},
+    {0x1E40, 0x004D, 0x0307}, {0x1E41, 0x006D, 0x0307},
+    {0x1E42, 0x004D, 0x0323}, {0x1E43, 0x006D, 0x0323},
+    {0x1E44, 0x004E, 0x0307}, {0x1E45, 0x006E, 0x0307},
+    {0x1E46, 0x004E, 0x0323}, {0x1E47, 0x006E, 0x0323},
+    {0x1E48, 0x004E, 0x0331}, {0x1E49, 0x006E, 0x0331},
+    {0x1E4A, 0x004E, 0x032D}, {0x1E4B, 0x006E, 0x032D},
+    {0x1E4C, 0x00D5, 0x0301}, {0x1E4D, 0x00F5, 0x0301},
+    {0x1E4E, 0x00D5, 0x0308}, {0x1E4F, 0x00F5, 0x0308},
+    {0x1E50, 0x014C, 0x0300}, {0x1E51, 0x014D, 0x0300},
+    {0x1E52, 0x014C, 0x0301}, {0x1E53, 0x014D, 0x0301},
+    {0x1E54, 0x0050, 0x0301}, {0x1E55, 0x0070, 0x0301},
+    {0x1E56, 0x0050, 0x0307}, {0x1E57, 0x0070, 0x0307},
+    {0x1E58, 0x0052, 0x0307}, {0x1E59, 0x0072, 0x0307},
+    {0x1E5A, 0x0052, 0x0323}, {0x1E5B, 0x0072, 0x0323},
+    {0x1E5C, 0x1E5A, 0x0304}, {0x1E5D, 0x1E5B, 0x0304},
+    {0x1E5E, 0x0052, 0x0331}, {0x1E5F, 0x0072, 0x0331},
+    {0x1E60, 0x0053, 0x0307}, {0x1E61, 0x0073, 0x0307},
+    {0x1E62, 0x0053, 0x0323}, {0x1E63, 0x0073, 0x0323},
+    {0x1E64, 0x015A, 0x0307}, {0x1E65, 0x015B, 0x0307},
+    {0x1E66, 0x0160, 0x0307}, {0x1E67, 0x0161, 0x0307},
+    {0x1E68, 0x1E62, 0x0307}, {0x1E69, 0x1E63, 0x0307},
+    {0x1E6A, 0x0054, 0x0307}, {0x1E6B, 0x0074, 0x0307},
+    {0x1E6C, 0x0054, 0x0323}, {0x1E6D, 0x0074, 0x0323},
+    {0x1E6E, 0x0054, 0x0331}, {0x1E6F, 0x0074, 0x0331},
+    {0x1E70, 0x0054, 0x032D}, {0x1E71, 0x0074, 0x032D},
+    {0x1E72, 0x0055, 0x0324}, {0x1E73, 0x0075, 0x0324},
+    {0x1E74, 0x0055, 0x0330}, {0x1E75, 0x0075, 0x0330},
+    {0x1E76, 0x0055, 0x032D}, {0x1E77, 0x0075, 0x032D},
+    {0x1E78, 0x0168, 0x0301}, {0x1E79, 0x0169, 0x0301},
+    {0x1E7A, 0x016A, 0x0308}, {0x1E7B, 0x016B, 0x0308},
+    {0x1E7C, 0x0056, 0x0303}, {0x1E7D, 0x0076, 0x0303},
+    {0x1E7E, 0x0056, 0x0323}, {0x1E7F, 0x0076, 0x0323},
+    {0x1E80, 0x0057, 0x0300}, {0x1E81, 0x0077, 0x0300},
+    {0x1E82, 0x0057, 0x0301}, {0x1E83, 0x0077, 0x0301},
+    {0x1E84, 0x0057, 0x0308}, {0x1E85, 0x0077, 0x0308},
+    {0x1E86, 0x0057, 0x0307}, {0x1E87, 0x0077, 0x0307},
+    {0x1E88, 0x0057, 0x0323}, {0x1E89, 0x0077, 0x0323},
+    {0x1E8A, 0x0058, 0x0307}, {0x1E8B, 0x0078, 0x0307},
+    {0x1E8C, 0x0058, 0x0308}, {0x1E8D, 0x0078, 0x0308},
+    {0x1E8E, 0x0059, 0x0307}, {0x1E8F, 0x0079, 0x0307},
+    {0x1E90, 0x005A, 0x0302}, {0x1E91, 0x007A, 0x0302},
+    {0x1E92, 0x005A, 0x0323}, {0x1E93, 0x007A, 0x0323},
+    {0x1E94, 0x005A, 0x0331}, {0x1E95, 0x007A, 0x0331},
+    {0x1E96, 0x0068, 0x0331}, {0x1E97, 0x0074, 0x0308},
+    {0x1E98, 0x0077, 0x030A}, {0x1E99, 0x0079, 0x030A},
+    {0x1E9B, 0x017F, 0x0307}, {0x1EA0, 0x0041, 0x0323},
+    {0x1EA1, 0x0061, 0x0323}, {0x1EA2, 0x0041, 0x0309},
+    {0x1EA3, 0x0061, 0x0309}, {0x1EA4, 0x00C2, 0x0301},
+    {0x1EA5, 0x00E2, 0x0301}, {0x1EA6, 0x00C2, 0x0300},
+    {0x1EA7, 0x00E2, 0x0300}, {0x1EA8, 0x00C2, 0x0309},
+    {0x1EA9, 0x00E2, 0x0309}, {0x1EAA, 0x00C2, 0x0303},
+    {0x1EAB, 0x00E2, 0x0303}, {0x1EAC, 0x1EA0, 0x0302},
+    {0x1EAD, 0x1EA1, 0x0302}, {0x1EAE, 0x0102, 0x0301},
+    {0x1EAF, 0x0103, 0x0301}, {0x1EB0, 0x0102, 0x0300},
+    {0x1EB1, 0x0103, 0x0300}, {0x1EB2, 0x0102, 0x0309},
+    {0x1EB3, 0x0103, 0x0309}, {0x1EB4, 0x0102, 0x0303},
+    {0x1EB5, 0x0103, 0x0303}, {0x1EB6, 0x1EA0, 0x0306},
+    {0x1EB7, 0x1EA1, 0x0306}, {0x1EB8, 0x0045, 0x0323},
+    {0x1EB9, 0x0065, 0x0323}, {0x1EBA, 0x0045, 0x0309},
+    {0x1EBB, 0x0065, 0x0309}, {0x1EBC, 0x0045, 0x0303},
+    {0x1EBD, 0x0065, 0x0303}, {0x1EBE, 0x00CA, 0x0301},
+    {0x1EBF, 0x00EA, 0x0301}, {0x1EC0, 0x00CA, 0x0300},
+    {0x1EC1, 0x00EA, 0x0300}, {0x1EC2, 0x00CA, 0x0309},
+    {0x1EC3, 0x00EA, 0x0309}, {0x1EC4, 0x00CA, 0x0303},
+    {0x1EC5, 0x00EA, 0x0303}, {0x1EC6, 0x1EB8, 0x0302},
+    {0x1EC7, 0x1EB9, 0x0302}, {0x1EC8, 0x0049, 0x0309},
+    {0x1EC9, 0x0069, 0x0309}, {0x1ECA, 0x0049, 0x0323},
+    {0x1ECB, 0x0069, 0x0323}, {0x1ECC, 0x004F, 0x0323},
+    {0x1ECD, 0x006F, 0x0323}, {0x1ECE, 0x004F, 0x0309},
+    {0x1ECF, 0x006F, 0x0309}, {0x1ED0, 0x00D4, 0x0301},
+    {0x1ED1, 0x00F4, 0x0301}, {0x1ED2, 0x00D4, 0x0300},
+    {0x1ED3, 0x00F4, 0x0300}, {0x1ED4, 0x00D4, 0x0309},
+    {0x1ED5, 0x00F4, 0x0309}, {0x1ED6, 0x00D4, 0x0303},
+    {0x1ED7, 0x00F4, 0x0303}, {0x1ED8, 0x1ECC, 0x0302},
+    {0x1ED9, 0x1ECD, 0x0302}, {0x1EDA, 0x01A0, 0x0301},
+    {0x1EDB, 0x01A1, 0x0301}, {0x1EDC, 0x01A0, 0x0300},
+    {0x1EDD, 0x01A1, 0x0300}, {0x1EDE, 0x01A0, 0x0309},
+    {0x1EDF, 0x01A1, 0x0309}, {0x1EE0, 0x01A0, 0x0303},
+    {0x1EE1, 0x01A1, 0x0303}, {0x1EE2, 0x01A0, 0x0323},
+    {0x1EE3, 0x01A1, 0x0323}, {0x1EE4, 0x0055, 0x0323},
+    {0x1EE5, 0x0075, 0x0323}, {0x1EE6, 0x0055, 0x0309},
+    {0x1EE7, 0x0075, 0x0309}, {0x1EE8, 0x01AF, 0x0301},
+    {0x1EE9, 0x01B0, 0x0301}, {0x1EEA, 0x01AF, 0x0300},
+    {0x1EEB, 0x01B0, 0x0300}, {0x1EEC, 0x01AF, 0x0309},
+    {0x1EED, 0x01B0, 0x0309}, {0x1EEE, 0x01AF, 0x0303},
+    {0x1EEF, 0x01B0, 0x0303}, {0x1EF0, 0x01AF, 0x0323},
+    {0x1EF1, 0x01B0, 0x0323}, {0x1EF2, 0x0059, 0x0300},
+    {0x1EF3, 0x0079, 0x0300}, {0x1EF4, 0x0059, 0x0323},
+    {0x1EF5, 0x0079, 0x0323}, {0x1EF6, 0x0059, 0x0309},
+    {0x1EF7, 0x0079, 0x0309}, {0x1EF8, 0x0059, 0x0303},
+    {0x1EF9, 0x0079, 0x0303},
+};
+
+// Combining classes of the combining marks, in ranges of runes. Runes not in
+// the table have class 0, which makes them starters.
+typedef struct {
+    int32_t lo;
+    int32_t hi;
+    uint8_t ccc;
+} CombiningRange;
+
+static const CombiningRange combining_classes[] = {
+    {0x0300, 0x0314, 230}, {0x0315, 0x0315, 232}, {0x0316, 0x0319, 220},
+    {0x031A, 0x031A, 232}, {0x031B, 0x031B, 216}, {0x031C, 0x0320, 220},
+    {0x0321, 0x0322, 202}, {0x0323, 0x0326, 220}, {0x0327, 0x0328, 202},
+    {0x0329, 0x0333, 220}, {0x0334, 0x0338, 1}, {0x0339, 0x033C, 220},
+    {0x033D, 0x0344, 230}, {0x0345, 0x0345, 240}, {0x0346, 0x0346, 230},
+    {0x0347, 0x0349, 220}, {0x034A, 0x034C, 230}, {0x034D, 0x034E, 220},
+    {0x0350, 0x0352, 230}, {0x0353, 0x0356, 220}, {0x0357, 0x0357, 230},
+    {0x0358, 0x0358, 232}, {0x0359, 0x035A, 220}, {0x035B, 0x035B, 230},
+    {0x035C, 0x035C, 233}, {0x035D, 0x035E, 234}, {0x035F, 0x035F, 233},
+    {0x0360, 0x0361, 234}, {0x0362, 0x0362, 233}, {0x0363, 0x036F, 230},
+    {0x0483, 0x0487, 230}, {0x1DC0, 0x1DC1, 230}, {0x1DC2, 0x1DC2, 220},
+    {0x1DC3, 0x1DC9, 230}, {0x1DCA, 0x1DCA, 220}, {0x1DCB, 0x1DCC, 230},
+    {0x1DCD, 0x1DCD, 234}, {0x1DCE, 0x1DCE, 214}, {0x1DCF, 0x1DCF, 220},
+    {0x1DD0, 0x1DD0, 202}, {0x1DD1, 0x1DF5, 230}, {0x1DF6, 0x1DF6, 232},
+    {0x1DF7, 0x1DF8, 228}, {0x1DF9, 0x1DF9, 220}, {0x1DFA, 0x1DFA, 218},
+    {0x1DFB, 0x1DFB, 230}, {0x1DFC, 0x1DFC, 233}, {0x1DFD, 0x1DFD, 220},
+    {0x1DFE, 0x1DFE, 230}, {0x1DFF, 0x1DFF, 220}, {0x20D0, 0x20D1, 230},
+    {0x20D2, 0x20D3, 1}, {0x20D4, 0x20D7, 230}, {0x20D8, 0x20DA, 1},
+    {0x20DB, 0x20DC, 230}, {0x20E1, 0x20E1, 230}, {0x20E5, 0x20E6, 1},
+    {0x20E7, 0x20E7, 230}, {0x20E8, 0x20E8, 220}, {0x20E9, 0x20E9, 230},
+    {0x20EA, 0x20EB, 1}, {0x20EC, 0x20EF, 220}, {0x20F0, 0x20F0, 230},
+    {0xFE20, 0xFE26, 230}, {0xFE27, 0xFE2D, 220}, {0xFE2E, 0xFE2F, 230},
+};
+
+int runeLength(const char* s, int len) {
+    unsigned char c = (unsigned char)s[0];
+    int n = c < 0x80          ? 1
+            : (c >> 5) == 0x6 ? 2
+            : (c >> 4) == 0xe ? 3
+            : (c >> 3) == 0x1e ? 4
+                               : 1;
+    if (n > len) return 1;
+    for (int i = 1; i < n; i++) {
+        if (((unsigned char)s[i] & 0xc0) != 0x80) return 1;
+    }
+    return n;
+}
+
+int decodeRune(const char* s, int len, int32_t* rune) {
+    int n = runeLength(s, len);
+    unsigned char c = (unsigned char)s[0];
+    if (n == 1) {
+        // Stray bytes map into the low surrogates, which no text encodes.
+        *rune = c < 0x80 ? c : 0xDC00 | c;
+        return 1;
+    }
+    int32_t r = c & (0x7f >> n);
+    for (int i = 1; i < n; i++) r = (r << 6) | (s[i] & 0x3f);
+    *rune = r;
+    return n;
+}
+
+int runeCount(const char* s, int len) {
+    int count = 0;
+    for (int i = 0; i < len; i += runeLength(s + i, len - i)) count++;
+    return count;
+}
+
+static const Decomposition* findDecomposition(int32_t rune) {
+    int lo = 0;
+    int hi = sizeof(decompositions) / sizeof(decompositions[0]) - 1;
+    while (lo <= hi) {
+        int mid = (lo + hi) / 2;
+        if (decompositions[mid].rune == rune) return &decompositions[mid];
+        if (decompositions[mid].rune < rune) {
+            lo = mid + 1;
+        } else {
+            hi = mid - 1;
+        }
+    }
+    return NULL;
+}
+
+static int combiningClass(int32_t rune) {
+    int lo = 0;
+    int hi = sizeof(combining_classes) / sizeof(combining_classes[0]) - 1;
+    while (lo <= hi) {
+        int mid = (lo + hi) / 2;
+        if (rune < combining_classes[mid].lo) {
+            hi = mid - 1;
+        } else if (rune > combining_classes[mid].hi) {
+            lo = mid + 1;
+        } else {
+            return combining_classes[mid].ccc;
+        }
+    }
+    return 0;
+}
+
+// Appends the full decomposition of rune to runes and returns the new count.
+static int decomposeRune(int32_t rune, int32_t* runes, int count) {
+    const Decomposition* d = findDecomposition(rune);
+    if (d == NULL) {
+        runes[count++] = rune;
+        return count;
+    }
+    count = decomposeRune(d->first, runes, count);
+    if (d->second != 0) count = decomposeRune(d->second, runes, count);
+    return count;
+}
+
+// Decomposes the len bytes at s into a new array of runes in canonical
+// order, and returns its length. The caller frees *runes.
+static int decompose(const char* s, int len, int32_t** runes) {
+    // No rune of the table decomposes into more than twice as many runes as
+    // it has bytes.
+    *runes = malloc(sizeof(int32_t) * (len > 0 ? 2 * len : 1));
+    int count = 0;
+    for (int i = 0; i < len;) {
+        int32_t rune;
+        i += decodeRune(s + i, len - i, &rune);
+        count = decomposeRune(rune, *runes, count);
+    }
+    // Marks that follow a starter are sorted by class, keeping the order of
+    // marks of one class.
+    for (int i = 1; i < count; i++) {
+        int32_t rune = (*runes)[i];
+        int ccc = combiningClass(rune);
+        if (ccc == 0) continue;
+        int j = i;
+        while (j > 0 && combiningClass((*runes)[j - 1]) > ccc) {
+            (*runes)[j] = (*runes)[j - 1];
+            j--;
+        }
+        (*runes)[j] = rune;
+    }
+    return count;
+}
+
+// Lower case of the letters of the Latin, Greek and Cyrillic alphabets
+// that are left after decomposition; other runes are returned as they are.
+static int32_t foldCase(int32_t r) {
+    if ((r >= 'A' && r <= 'Z') || (r >= 0xC0 && r <= 0xDE && r != 0xD7) ||
+        (r >= 0x391 && r <= 0x3AB && r != 0x3A2) ||
+        (r >= 0x410 && r <= 0x42F)) {
+        return r + 0x20;
+    }
+    if (r >= 0x400 && r <= 0x40F) return r + 0x50;
+    bool even_upper = (r >= 0x100 && r <= 0x137) || (r >= 0x14A && r <= 0x177);
+    bool odd_upper = (r >= 0x139 && r <= 0x148) || (r >= 0x179 && r <= 0x17E);
+    if ((even_upper && r % 2 == 0) || (odd_upper && r % 2 == 1)) return r + 1;
+    return r;
+}
+
+typedef enum {
+    LEVEL_LETTERS,  // Base letters only, in either case
+    LEVEL_MARKS,    // Letters and marks, in either case
+    LEVEL_CASE,     // The runes as they are
+} CompareLevel;
+
+static int compareRunes(const int32_t* a, int a_cnt, const int32_t* b,
+                        int b_cnt, CompareLevel level) {
+    int i = 0;
+    int j = 0;
+    for (;;) {
+        if (level == LEVEL_LETTERS) {
+            while (i < a_cnt && combiningClass(a[i]) != 0) i++;
+            while (j < b_cnt && combiningClass(b[j]) != 0) j++;
+        }
+        if (i == a_cnt || j == b_cnt) return (i < a_cnt) - (j < b_cnt);
+        int32_t x = level == LEVEL_CASE ? a[i] : foldCase(a[i]);
+        int32_t y = level == LEVEL_CASE ? b[j] : foldCase(b[j]);
+        if (x != y) return x < y ? -1 : 1;
+        i++;
+        j++;
+    }
+}
+
+// Breaks a tie between texts that are equal at every level by their bytes,
+// so that only equal strings compare equal.
+static int compareBytes(const char* a, int a_len, const char* b, int b_len) {
+    int n = memcmp(a, b, a_len < b_len ? a_len : b_len);
+    if (n != 0) return n < 0 ? -1 : 1;
+    return (a_len > b_len) - (a_len < b_len);
+}
+
+int compareText(const char* a, int a_len, const char* b, int b_len) {
+    // Where the texts first differ in an ASCII character, or one ends and
+    // the other goes on with one, the bytes decide: the runes before are the
+    // same in both, and an ASCII character is its own decomposition and ends
+    // any run of marks before it.
+    int i = 0;
+    while (i < a_len && i < b_len && a[i] == b[i]) i++;
+    bool a_ascii = i == a_len || (unsigned char)a[i] < 0x80;
+    bool b_ascii = i == b_len || (unsigned char)b[i] < 0x80;
+    if (a_ascii && b_ascii) return compareBytes(a, a_len, b, b_len);
+
+    int32_t* x;
+    int32_t* y;
+    int x_cnt = decompose(a, a_len, &x);
+    int y_cnt = decompose(b, b_len, &y);
+    int cmp = compareRunes(x, x_cnt, y, y_cnt, LEVEL_CASE);
+    free(x);
+    free(y);
+    return cmp != 0 ? cmp : compareBytes(a, a_len, b, b_len);
+}
+
+int collateText(const char* a, int a_len, const char* b, int b_len) {
+    int32_t* x;
+    int32_t* y;
+    int x_cnt = decompose(a, a_len, &x);
+    int y_cnt = decompose(b, b_len, &y);
+    int cmp = 0;
+    for (CompareLevel level = LEVEL_LETTERS; level <= LEVEL_CASE && cmp == 0;
+         level++) {
+        cmp = compareRunes(x, x_cnt, y, y_cnt, level);
+    }
+    free(x);
+    free(y);
+    return cmp != 0 ? cmp : compareBytes(a, a_len, b, b_len);
+}
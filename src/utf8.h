@@ -0,0 +1,27 @@
+#ifndef liss_utf8_h
+#define liss_utf8_h
+
+#include <stdint.h>
+
+// Bytes in the UTF-8 sequence at the start of s, which has len bytes left. A
+// byte that doesn't start a whole sequence counts on its own.
+int runeLength(const char* s, int len);
+
+// Decodes the sequence at the start of s into *rune and returns its length
+// as runeLength does.
+int decodeRune(const char* s, int len, int32_t* rune);
+
+// Number of characters in the len bytes at s.
+int runeCount(const char* s, int len);
+
+// Three-way comparison of two texts by their characters in canonical
+// decomposition, so an accented letter written as one character and as a
+// letter and a combining mark sort alike. Texts equal in that form are
+// ordered by their bytes, so 0 means the bytes are the same.
+int compareText(const char* a, int a_len, const char* b, int b_len);
+
+// Three-way comparison that orders texts by their letters first, then by
+// their accents, then by case, the way a dictionary orders words.
+int collateText(const char* a, int a_len, const char* b, int b_len);
+
+#endif
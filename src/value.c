@@ -1,5 +1,6 @@
 #include "value.h"
 
+#include <stdarg.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
@@ -7,11 +8,14 @@
 #include "common.h"
 #include "hamt.h"
 #include "object.h"
+#include "utf8.h"
 
 // forward-declaration
 typedef struct {
     Value key;
     Value val;
+    char* key_str;  // printed key, set for keys ordered by their text
+    int64_t seq;    // place in the insertion order, which breaks ties
 } DictPair;
 
 bool valuesEqual(Value a, Value b) {
@@ -38,6 +42,24 @@ bool valuesEqual(Value a, Value b) {
                         if (strA->length != strB->length) return false;
                         return memcmp(strA->chars, strB->chars, strA->length) ==
                                0;
+                    case OBJ_TUPLE: {
+                        // Tuples are values: equal when their items are.
+                        ObjTuple* x = AS_TUPLE(a);
+                        ObjTuple* y = AS_TUPLE(b);
+                        if (x->len != y->len) return false;
+                        for (uint32_t i = 0; i < x->len; i++) {
+                            if (!valuesEqual(x->items[i], y->items[i])) {
+                                return false;
+                            }
+                        }
+                        return true;
+                    }
+                    case OBJ_BYTES: {
+                        ObjBytes* x = AS_BYTES(a);
+                        ObjBytes* y = AS_BYTES(b);
+                        return x->len == y->len &&
+                               memcmp(x->data, y->data, x->len) == 0;
+                    }
                     default:
                         break;
                 }
@@ -49,7 +71,35 @@ bool valuesEqual(Value a, Value b) {
     return false;  // Unreachable.
 }
 
-static int cmpValues(Value a, Value b) {
+const char* valueTypeName(Value value) {
+    switch (value.type) {
+        case VAL_INT:  return "int";
+        case VAL_REAL: return "real";
+        case VAL_BOOL: return "bool";
+        case VAL_NIL:  return "nil";
+        case VAL_OBJ:
+            switch (OBJ_TYPE(value)) {
+                case OBJ_STRING:   return "string";
+                case OBJ_LIST:     return "list";
+                case OBJ_TUPLE:    return "tuple";
+                case OBJ_BYTES:    return "bytes";
+                case OBJ_PAIR:     return "pair";
+                case OBJ_DICT:     return "dict";
+                case OBJ_CLOSURE:
+                case OBJ_FUNCTION: return "fn";
+                case OBJ_NATIVE:   return "native-fn";
+                case OBJ_ERROR:    return "error";
+                case OBJ_RE:       return "re";
+                case OBJ_MODULE:   return "module";
+                case OBJ_FILE:     return "file";
+                case OBJ_BUILDER:  return "builder";
+                default:           return "obj";
+            }
+        default: return "?";
+    }
+}
+
+int compareValues(Value a, Value b) {
     // Sort by the type tag
     if (a.type != b.type) return a.type - b.type;
 
@@ -65,7 +115,9 @@ static int cmpValues(Value a, Value b) {
         case VAL_OBJ: {
             if (OBJ_TYPE(a) != OBJ_TYPE(b)) return OBJ_TYPE(a) - OBJ_TYPE(b);
             if (IS_STRING(a)) {
-                return strcmp(AS_CSTRING(a), AS_CSTRING(b));
+                ObjString* x = AS_STRING(a);
+                ObjString* y = AS_STRING(b);
+                return compareText(x->chars, x->length, y->chars, y->length);
             }
             return (AS_OBJ(a) < AS_OBJ(b)) ? -1 : (AS_OBJ(a) > AS_OBJ(b));
         }
@@ -73,23 +125,40 @@ static int cmpValues(Value a, Value b) {
     return 0;
 }
 
+// Non-string objects would otherwise be ordered by address, which changes
+// from run to run; order same-typed ones by their printed form instead.
+static bool orderedByText(Value v) {
+    return IS_OBJ(v) && !IS_STRING(v);
+}
+
 static int cmpDictPairs(const void* a, const void* b) {
-    return cmpValues(((const DictPair*)a)->key, ((const DictPair*)b)->key);
+    const DictPair* x = a;
+    const DictPair* y = b;
+    int cmp;
+    if (orderedByText(x->key) && orderedByText(y->key) &&
+        OBJ_TYPE(x->key) == OBJ_TYPE(y->key)) {
+        cmp = strcmp(x->key_str, y->key_str);
+    } else {
+        cmp = compareValues(x->key, y->key);
+    }
+    return cmp != 0 ? cmp : (x->seq > y->seq) - (x->seq < y->seq);
 }
 
-typedef struct {
-    DictPair* entries;
-    int count;
-    int cap;
-} CollectCtx;
-
-static void collectPair(Value key, Value val, void* ctx_) {
-    CollectCtx* ctx = ctx_;
-    if (ctx->count == ctx->cap) {
-        ctx->cap *= 2;
-        ctx->entries = realloc(ctx->entries, sizeof(DictPair) * ctx->cap);
+// Returns the entries of dict sorted by key for printing. Keys that print
+// alike, like two anonymous functions, keep the order they were added in, so
+// the output does not depend on where the trie put them. The caller frees
+// the key_str of each and the array.
+static DictPair* sortedPairs(ObjDict* dict) {
+    HamtEntry* entries = hamtOrdered(dict->root, (int)dict->count);
+    DictPair* pairs = malloc(sizeof(DictPair) * (dict->count + 1));
+    for (uint32_t i = 0; i < dict->count; i++) {
+        Value key = entries[i].key;
+        char* key_str = orderedByText(key) ? sprintValue(key) : NULL;
+        pairs[i] = (DictPair){key, entries[i].val, key_str, entries[i].seq};
     }
-    ctx->entries[ctx->count++] = (DictPair){key, val};
+    free(entries);
+    qsort(pairs, dict->count, sizeof(DictPair), cmpDictPairs);
+    return pairs;
 }
 
 char* sprintValue(Value value) {
@@ -101,7 +170,9 @@ char* sprintValue(Value value) {
     do {                                                               \
         int needed = snprintf(NULL, 0, fmt, ##__VA_ARGS__);            \
         if (offset + needed + 1 > buffer_size) {                       \
-            buffer_size = (buffer_size == 0) ? 256 : buffer_size * 2;  \
+            while (offset + needed + 1 > buffer_size) {                \
+                buffer_size = buffer_size ? buffer_size * 2 : 256;     \
+            }                                                          \
             buffer = realloc(buffer, buffer_size);                     \
         }                                                              \
         offset += snprintf(buffer + offset, buffer_size - offset, fmt, \
@@ -116,7 +187,7 @@ char* sprintValue(Value value) {
             APPEND_TO_BUFFER("null");
             break;
         case VAL_INT: {
-            APPEND_TO_BUFFER("%i", AS_INT(value));
+            APPEND_TO_BUFFER("%lld", (long long)AS_INT(value));
             break;
         }
         case VAL_REAL: {
@@ -142,6 +213,10 @@ char* sprintValue(Value value) {
                     APPEND_TO_BUFFER("<error: %s>",
                                      AS_ERROR(value)->message->chars);
                     break;
+                case OBJ_BUILDER:
+                    APPEND_TO_BUFFER("<builder of %d bytes>",
+                                     AS_BUILDER(value)->length);
+                    break;
                 case OBJ_LIST: {
                     APPEND_TO_BUFFER("[");
                     ObjList* list = AS_LIST(value);
@@ -169,6 +244,27 @@ char* sprintValue(Value value) {
                     APPEND_TO_BUFFER("]");
                     break;
                 }
+                case OBJ_TUPLE: {
+                    ObjTuple* tuple = AS_TUPLE(value);
+                    APPEND_TO_BUFFER("#[");
+                    for (uint32_t i = 0; i < tuple->len; i++) {
+                        char* elem_str = sprintValue(tuple->items[i]);
+                        APPEND_TO_BUFFER(i == 0 ? "%s" : " %s", elem_str);
+                        free(elem_str);
+                    }
+                    APPEND_TO_BUFFER("]");
+                    break;
+                }
+                case OBJ_BYTES: {
+                    // The same text reads back as these bytes.
+                    ObjBytes* bytes = AS_BYTES(value);
+                    APPEND_TO_BUFFER("(from_hex \"");
+                    for (uint32_t i = 0; i < bytes->len; i++) {
+                        APPEND_TO_BUFFER("%02x", bytes->data[i]);
+                    }
+                    APPEND_TO_BUFFER("\")");
+                    break;
+                }
                 case OBJ_PAIR: {
                     ObjPair* pair = AS_PAIR(value);
                     char* first_str = sprintValue(pair->first);
@@ -181,18 +277,16 @@ char* sprintValue(Value value) {
                 case OBJ_DICT: {
                     ObjDict* dict = AS_DICT(value);
                     APPEND_TO_BUFFER("(dict");
-                    CollectCtx ctx = {malloc(sizeof(DictPair) * 8), 0, 8};
-                    hamtEach(dict->root, collectPair, &ctx);
-                    qsort(ctx.entries, ctx.count, sizeof(DictPair),
-                          cmpDictPairs);
-                    for (int i = 0; i < ctx.count; i++) {
-                        char* k = sprintValue(ctx.entries[i].key);
-                        char* v = sprintValue(ctx.entries[i].val);
+                    DictPair* pairs = sortedPairs(dict);
+                    for (uint32_t i = 0; i < dict->count; i++) {
+                        char* k = sprintValue(pairs[i].key);
+                        char* v = sprintValue(pairs[i].val);
                         APPEND_TO_BUFFER(" (%s . %s)", k, v);
                         free(k);
                         free(v);
+                        free(pairs[i].key_str);
                     }
-                    free(ctx.entries);
+                    free(pairs);
                     APPEND_TO_BUFFER(")");
                     break;
                 }
@@ -208,6 +302,102 @@ char* sprintValue(Value value) {
     return buffer;
 }
 
+void charBufAppend(CharBuf* buf, const char* s, size_t n) {
+    if (buf->len + n + 1 > buf->cap) {
+        while (buf->len + n + 1 > buf->cap) buf->cap = buf->cap * 2 + 64;
+        buf->chars = realloc(buf->chars, buf->cap);
+    }
+    memcpy(buf->chars + buf->len, s, n);
+    buf->len += n;
+    buf->chars[buf->len] = '\0';
+}
+
+void charBufAppendStr(CharBuf* buf, const char* s) {
+    charBufAppend(buf, s, strlen(s));
+}
+
+void charBufAppendf(CharBuf* buf, const char* fmt, ...) {
+    va_list args;
+    va_start(args, fmt);
+    va_list copy;
+    va_copy(copy, args);
+    int n = vsnprintf(NULL, 0, fmt, copy);
+    va_end(copy);
+    // Make room for the text, then print it in place.
+    if (buf->len + (size_t)n + 1 > buf->cap) {
+        while (buf->len + (size_t)n + 1 > buf->cap) {
+            buf->cap = buf->cap * 2 + 64;
+        }
+        buf->chars = realloc(buf->chars, buf->cap);
+    }
+    vsnprintf(buf->chars + buf->len, (size_t)n + 1, fmt, args);
+    buf->len += (size_t)n;
+    va_end(args);
+}
+
+static void prettyNewline(CharBuf* buf, int indent) {
+    charBufAppendStr(buf, "\n");
+    for (int i = 0; i < indent; i++) charBufAppendStr(buf, " ");
+}
+
+// Prints value starting at column col; nested items go at indent + 4.
+static void prettyInto(CharBuf* buf, Value value, int indent, int col) {
+    char* flat = sprintValue(value);
+    bool composite = IS_LIST(value) || IS_TUPLE(value) || IS_DICT(value);
+    if (!composite || col + (int)strlen(flat) <= PP_WIDTH) {
+        charBufAppendStr(buf, flat);
+        free(flat);
+        return;
+    }
+    free(flat);
+
+    int inner = indent + 4;
+    if (IS_LIST(value)) {
+        charBufAppendStr(buf, "[");
+        Value curr = AS_LIST(value)->head;
+        for (uint32_t i = 0; i < AS_LIST(value)->len; i++) {
+            prettyNewline(buf, inner);
+            prettyInto(buf, AS_PAIR(curr)->first, inner, inner);
+            curr = AS_PAIR(curr)->second;
+        }
+        charBufAppendStr(buf, "]");
+    } else if (IS_TUPLE(value)) {
+        ObjTuple* tuple = AS_TUPLE(value);
+        charBufAppendStr(buf, "#[");
+        for (uint32_t i = 0; i < tuple->len; i++) {
+            prettyNewline(buf, inner);
+            prettyInto(buf, tuple->items[i], inner, inner);
+        }
+        charBufAppendStr(buf, "]");
+    } else {
+        charBufAppendStr(buf, "(dict");
+        ObjDict* dict = AS_DICT(value);
+        DictPair* pairs = sortedPairs(dict);
+        for (uint32_t i = 0; i < dict->count; i++) {
+            prettyNewline(buf, inner);
+            char* k = sprintValue(pairs[i].key);
+            charBufAppendStr(buf, "(");
+            charBufAppendStr(buf, k);
+            charBufAppendStr(buf, " . ");
+            // The value starts after "(key . " and may still break.
+            prettyInto(buf, pairs[i].val, inner,
+                       inner + (int)strlen(k) + 4);
+            charBufAppendStr(buf, ")");
+            free(k);
+            free(pairs[i].key_str);
+        }
+        free(pairs);
+        charBufAppendStr(buf, ")");
+    }
+}
+
+char* sprintPretty(Value value) {
+    CharBuf buf = {0};
+    charBufAppend(&buf, "", 0);
+    prettyInto(&buf, value, 0, 0);
+    return buf.chars;
+}
+
 bool isFalsey(Value value) {
     return (IS_NIL(value) || (IS_BOOL(value) && !AS_BOOL(value)));
 }
@@ -33,6 +33,7 @@ typedef struct {
 #define IS_INT(value) ((value).type == VAL_INT)
 #define IS_REAL(value) ((value).type == VAL_REAL)
 #define IS_OBJ(value) ((value).type == VAL_OBJ)
+#define IS_ABSENT(value) (IS_NIL(value) && (value).as.integer == 1)
 
 #define IS_NUMERIC(value) (IS_INT(value) || IS_REAL(value))
 
@@ -45,6 +46,8 @@ typedef struct {
 // Macros to create a Value from a C type
 #define BOOL_VAL(value) ((Value){VAL_BOOL, {.boolean = value}})
 #define NIL_VAL ((Value){VAL_NIL, {.integer = 0}})  // Payload is irrelevant
+// A nil that stands for an argument left out between keyword arguments.
+#define ABSENT_VAL ((Value){VAL_NIL, {.integer = 1}})
 #define INT_VAL(value) ((Value){VAL_INT, {.integer = value}})
 #define REAL_VAL(value) ((Value){VAL_REAL, {.real = value}})
 #define OBJ_VAL(object) ((Value){VAL_OBJ, {.obj = (Obj*)object}})
@@ -58,8 +61,34 @@ typedef struct {
 
 bool valuesEqual(Value a, Value b);
 
+// Orders values by type tag first, then by value. Strings compare by
+// contents; other objects by address.
+int compareValues(Value a, Value b);
+
+// Returns the user-facing name of a value's type, e.g. "int" or "dict".
+const char* valueTypeName(Value value);
+
 char* sprintValue(Value value);
 
+// A growable string for building text piece by piece. Start from {0}; after
+// the first append, chars is always NUL-terminated and the caller frees it.
+typedef struct {
+    char* chars;
+    size_t len;
+    size_t cap;
+} CharBuf;
+
+void charBufAppend(CharBuf* buf, const char* s, size_t n);
+void charBufAppendStr(CharBuf* buf, const char* s);
+// Appends printf-style formatted text.
+void charBufAppendf(CharBuf* buf, const char* fmt, ...);
+
+// Like sprintValue, but a list, tuple or dict that doesn't fit in PP_WIDTH
+// columns is laid out one item per line, indented by four spaces per level.
+char* sprintPretty(Value value);
+
+#define PP_WIDTH 80
+
 bool isFalsey(Value value);
 
 #endif
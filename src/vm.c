@@ -5,39 +5,129 @@
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <time.h>
 
 #include "chunk.h"
 #include "common.h"
 #include "compiler.h"
+#include "embed.h"
 #include "gc.h"
+#include "hamt.h"
 #include "memory.h"
 #include "modules/modules.h"
 #include "object.h"
 #include "opcode.h"
+#include "plugin.h"
 #include "table.h"
+#include "utf8.h"
 #include "value.h"
 
 typedef void (*NativeModuleLoader)(VM* vm, ObjModule* module);
 
 // --- Forward Declarations ---
 static InterpretResult run(VM* vm);
+typedef struct {
+    VM* vm;
+    HamtNode* root;
+    int* byte_to_slot_map;
+    int base_byte;
+    int base_slot;
+    bool ok;
+} SwitchTablePatch;
+
+// Rewrites one switch table target from a byte offset into a slot offset,
+// both relative to the end of the OP_SWITCH_TABLE instruction. The table is a
+// constant only this instruction sees, so it is safe to update in place.
+static void patchSwitchTarget(Value key, Value val, void* ctx) {
+    SwitchTablePatch* patch = (SwitchTablePatch*)ctx;
+    int slot_ix = patch->byte_to_slot_map[patch->base_byte + AS_INT(val)];
+    if (slot_ix == -1) {
+        patch->ok = false;
+        return;
+    }
+    *hamtGet(patch->root, key, hamtKeyHash(patch->vm, key), 0) =
+        INT_VAL(slot_ix - patch->base_slot);
+}
+
 static int loadThreadedCode(VM* vm, ObjFunction* function,
                             void* dispatch_table[]);
+static void ensureFrameCap(VM* vm);
 
 // Cached dispatch table pointer set by run() on entry; used by callFromNative.
 static void** g_dispatch_table = NULL;
 
+// Maps a threaded-code handler address back to its opcode and bumps the
+// counter. Only used when options.profile is set, so the linear scan is fine.
+static inline void countOp(VM* vm, void* handler) {
+    for (int i = 0; i < OPCODE_CNT; i++) {
+        if (g_dispatch_table[i] == handler) {
+            vm->metrics.op_cnt[i]++;
+            break;
+        }
+    }
+    vm->metrics.instr_cnt++;
+}
+
+// Raises once the current evaluation runs past options.max_instrs,
+// options.timeout_ms or options.max_alloc, the heap grows past
+// options.max_heap with its garbage collected, or the host cancels it.
+// Allocations are checked between instructions and the clock and
+// options.cancel every POLL_INSTRS of them, so a single native call may
+// overshoot the budget before it is stopped.
+static inline bool overBudget(VM* vm) {
+    uint64_t instrs = vm->metrics.instr_cnt - vm->instrs_mark;
+    if (vm->options.max_instrs > 0 && instrs > vm->options.max_instrs) {
+        RUNTIME_ERR(vm, "Instruction budget of %llu exceeded",
+                    (unsigned long long)vm->options.max_instrs);
+        vm->last_result = INTERPRET_BUDGET_EXCEEDED;
+        return true;
+    }
+    if (instrs % POLL_INSTRS == 0) {
+        uint64_t timeout = vm->options.timeout_ms;
+        if (timeout > 0 &&
+            (uint64_t)(clockMs(vm) - vm->clock_mark) > timeout) {
+            RUNTIME_ERR(vm, "Time budget of %llu ms exceeded",
+                        (unsigned long long)timeout);
+            vm->last_result = INTERPRET_BUDGET_EXCEEDED;
+            return true;
+        }
+        if (vm->options.cancel != NULL &&
+            atomic_load_explicit(vm->options.cancel, memory_order_relaxed)) {
+            RUNTIME_ERR(vm, "Evaluation cancelled");
+            vm->last_result = INTERPRET_CANCELLED;
+            return true;
+        }
+    }
+    uint64_t bytes = vm->alloc_bytes - vm->alloc_mark;
+    if (vm->options.max_alloc > 0 && bytes > vm->options.max_alloc) {
+        RUNTIME_ERR(vm, "Memory budget of %zu bytes exceeded",
+                    vm->options.max_alloc);
+        return true;
+    }
+    size_t max_heap = vm->options.max_heap;
+    if (max_heap > 0 && vm->bytes_allocated > max_heap) {
+        gc(vm);
+        if (vm->bytes_allocated > max_heap) {
+            RUNTIME_ERR(vm, "Memory limit of %zu bytes exceeded", max_heap);
+            return true;
+        }
+    }
+    return false;
+}
+
 // --- VM Lifecycle ---
 
 VM* newVM(VMOptions options) {
     VM* vm = (VM*)reallocate(
-        NULL, NULL, 0, sizeof(VM) + sizeof(Value) * options.stack_capacity);
+        NULL, NULL, 0,
+        sizeof(VM) + sizeof(Value) * (options.stack_capacity + STACK_SLACK));
 
     // Initialize all GC-scanned fields before any allocation that can trigger
     // GC. If the VM struct reuses freed memory (e.g., second test run), stale
     // pointers in these fields would cause markRoots to dereference freed
     // memory → SIGBUS.
     vm->compiler = NULL;
+    vm->symbols = NULL;
     vm->core_module = NULL;
     vm->main_module = NULL;
     vm->objects = NULL;
@@ -45,13 +135,50 @@ VM* newVM(VMOptions options) {
     vm->open_upvalues = NULL;
     vm->raise_value = NIL_VAL;
     vm->last_popped_value = NIL_VAL;
+    vm->overflowing = false;
     initTable(&vm->strings);
+    initValueArray(vm, &vm->pinned);
+    initValueArray(vm, &vm->re_cache);
+    initValueArray(vm, &vm->tests);
+    vm->gray_stack = NULL;
+    vm->gray_cnt = 0;
+    vm->gray_cap = 0;
+    // push and pop call the hooks, so they are set before the first push.
+    memset(&vm->hooks, 0, sizeof(vm->hooks));
+    memset(&vm->step, 0, sizeof(vm->step));
 
     vm->options = options;
+    if (vm->options.lang_version == 0) {
+        vm->options.lang_version = LANG_VERSION_LATEST;
+    }
+    if (vm->options.max_nesting <= 0) {
+        vm->options.max_nesting = DEFAULT_MAX_NESTING;
+    }
     vm->bytes_allocated = 0;
+    vm->alloc_cnt = 0;
+    vm->alloc_bytes = 0;
+    vm->heap_peak = 0;
     vm->next_gc = options.gc_threshold;
     vm->last_result = INTERPRET_OK;
     vm->try_cnt = 0;
+    vm->try_base = 0;
+    memset(&vm->metrics, 0, sizeof(vm->metrics));
+    vm->real_eq_warned = false;
+    vm->warning_cnt = 0;
+    vm->diagnostic_cnt = 0;
+    vm->resources = NULL;
+    vm->resource_id = 0;
+    vm->plugins = NULL;
+    vm->plugin_cnt = 0;
+    vm->plugin_cap = 0;
+    vm->module_path = options.module_path != NULL
+                          ? strdup(options.module_path)
+                          : defaultModulePath();
+    if (options.seeded || options.deterministic) {
+        vm->rand_state = options.seed;
+    } else {
+        vm->rand_state = (uint64_t)time(NULL) ^ (uint64_t)clock();
+    }
     vm->frame_cnt = 0;
     vm->frame_cap = 8;
     vm->frames = reallocate(NULL, NULL, 0, sizeof(CallFrame) * vm->frame_cap);
@@ -69,6 +196,10 @@ VM* newVM(VMOptions options) {
 
 void destroyVM(VM* vm) {
     if (vm == NULL) return;
+    releaseResources(vm);
+    freeValueArray(vm, &vm->pinned);
+    freeValueArray(vm, &vm->re_cache);
+    freeValueArray(vm, &vm->tests);
     freeTable(&vm->strings);
     freeTable(&vm->modules);
     Obj* object = vm->objects;
@@ -78,23 +209,128 @@ void destroyVM(VM* vm) {
         object = next;
     }
     reallocate(vm, vm->frames, sizeof(CallFrame) * vm->frame_cap, 0);
+    free(vm->gray_stack);
+    free(vm->module_path);
+    closePlugins(vm);
     // Correctly free the VM struct and its flexible array member
     reallocate(NULL, vm,
-               sizeof(VM) + sizeof(Value) * vm->options.stack_capacity, 0);
+               sizeof(VM) +
+                   sizeof(Value) * (vm->options.stack_capacity + STACK_SLACK),
+               0);
 }
 
 // --- Public API ---
 
+int64_t clockMs(VM* vm) {
+    if (vm->options.deterministic) {
+        return (int64_t)(vm->metrics.instr_cnt / VIRTUAL_INSTRS_PER_MS);
+    }
+    struct timespec ts;
+    timespec_get(&ts, TIME_UTC);
+    return (int64_t)ts.tv_sec * 1000 + ts.tv_nsec / 1000000;
+}
+
+uint64_t nextRand(VM* vm) {
+    uint64_t z = (vm->rand_state += 0x9e3779b97f4a7c15ULL);
+    z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9ULL;
+    z = (z ^ (z >> 27)) * 0x94d049bb133111ebULL;
+    return z ^ (z >> 31);
+}
+
+bool parseLangVersion(const char* text, int length, int* version) {
+    int major = 0, minor = 0;
+    int i = 0;
+    int digits = 0;
+    for (; i < length && text[i] >= '0' && text[i] <= '9'; i++, digits++) {
+        if (digits == 3) return false;
+        major = major * 10 + (text[i] - '0');
+    }
+    if (digits == 0 || i == length || text[i] != '.') return false;
+    i++;
+    digits = 0;
+    for (; i < length && text[i] >= '0' && text[i] <= '9'; i++, digits++) {
+        if (digits == 2) return false;
+        minor = minor * 10 + (text[i] - '0');
+    }
+    if (digits == 0 || i != length) return false;
+    *version = major * 100 + minor;
+    return true;
+}
+
 void vmRecover(VM* vm) {
     vm->raise_value = NIL_VAL;
     vm->last_result = INTERPRET_OK;
     vm->error_msg[0] = '\0';
+    vm->diagnostic_cnt = 0;
+    vm->last_popped_value = NIL_VAL;
+    vm->step.last_fn = NULL;
+    // A script started by a native of a running one shares its open upvalues
+    // and budgets, and must not reach its handlers.
+    if (vm->frame_cnt > 0) {
+        vm->try_base = vm->try_cnt;
+        return;
+    }
     vm->try_cnt = 0;
+    vm->try_base = 0;
     vm->open_upvalues = NULL;
-    vm->last_popped_value = NIL_VAL;
+    vm->instrs_mark = vm->metrics.instr_cnt;
+    vm->alloc_mark = vm->alloc_bytes;
+    if (vm->options.timeout_ms > 0) vm->clock_mark = clockMs(vm);
+}
+
+static bool isNativeModule(const char* name) {
+    for (int i = 0; native_module_registry[i].name != NULL; i++) {
+        if (strcmp(name, native_module_registry[i].name) == 0) return true;
+    }
+    return false;
+}
+
+void resetVM(VM* vm) {
+    vmRecover(vm);
+    vm->stack_top = vm->stack;
+    vm->frame_cnt = 0;
+    vm->compiler = NULL;
+    vm->real_eq_warned = false;
+    vm->warning_cnt = 0;
+    vm->tests.count = 0;
+
+    // Native modules only hold natives and constants, so they are kept; the
+    // main module and Liss files go with the globals they define.
+    size_t count;
+    ObjString** names = tableFindByPrefix(&vm->modules, "", false, &count);
+    for (size_t i = 0; i < count; i++) {
+        if (!isNativeModule(names[i]->chars)) {
+            tableRemove(&vm->modules, OBJ_VAL(names[i]));
+        }
+    }
+    free(names);
+    vm->main_module = NULL;
 }
 
 ObjModule* loadModule(VM* vm, ObjString* module_name) {
+    // A module is cached under its cleaned name, so "./a/b" and "a/c/../b"
+    // are the same module as "a/b".
+    char clean[256];
+    int climbs = cleanModuleName(module_name->chars, clean, sizeof(clean));
+    if (climbs < 0) {
+        RUNTIME_ERR(vm, "Module name '%s' is too long", module_name->chars);
+        return NULL;
+    }
+    if (climbs > 0 && vm->options.project_root == NULL) {
+        RUNTIME_ERR(vm,
+                    "Module '%s' is outside the search path, set a project "
+                    "root to import it",
+                    module_name->chars);
+        return NULL;
+    }
+    if (strcmp(clean, module_name->chars) != 0) {
+        ObjString* clean_name = copyString(vm, clean, (int)strlen(clean));
+        push(vm, OBJ_VAL(clean_name));
+        ObjModule* module = loadModule(vm, clean_name);
+        pop(vm);
+        return module;
+    }
+
     // Step 1: check cache
     Value* cached = tableGet(&vm->modules, OBJ_VAL(module_name));
     if (cached != NULL) {
@@ -104,6 +340,11 @@ ObjModule* loadModule(VM* vm, ObjString* module_name) {
     // Step 2: check native modules
     for (int i = 0; native_module_registry[i].name != NULL; i++) {
         if (strcmp(module_name->chars, native_module_registry[i].name) == 0) {
+            if (vm->options.sandbox && !native_module_registry[i].pure) {
+                RUNTIME_ERR(vm, "Module '%s' is not available in the sandbox",
+                            module_name->chars);
+                return NULL;
+            }
             ObjModule* module = newModule(vm, native_module_registry[i].name);
             push(vm, OBJ_VAL(module));
             tableInsert(&vm->modules, OBJ_VAL(module_name), OBJ_VAL(module));
@@ -114,9 +355,56 @@ ObjModule* loadModule(VM* vm, ObjString* module_name) {
     }
 
     // Step 3: check files
-    char* source = readLissFile(module_name->chars);
+    if (vm->options.sandbox) {
+        RUNTIME_ERR(vm, "Module '%s' is not available in the sandbox",
+                    module_name->chars);
+        return NULL;
+    }
+    char* path = NULL;
+    char* source = findLissFile(vm->module_path, module_name->chars, &path);
+    if (source != NULL && climbs > 0 &&
+        !isInsideDir(vm->options.project_root, path)) {
+        RUNTIME_ERR(vm, "Module '%s' is outside the project root %s",
+                    module_name->chars, vm->options.project_root);
+        free(source);
+        free(path);
+        return NULL;
+    }
+    free(path);
+    if (source == NULL && vm->options.plugins) {
+        char* plugin = findModuleFile(vm->module_path, module_name->chars,
+                                      LISS_PLUGIN_EXT);
+        if (plugin != NULL && climbs > 0 &&
+            !isInsideDir(vm->options.project_root, plugin)) {
+            RUNTIME_ERR(vm, "Module '%s' is outside the project root %s",
+                        module_name->chars, vm->options.project_root);
+            free(plugin);
+            return NULL;
+        }
+        if (plugin != NULL) {
+            ObjModule* module = newModule(vm, module_name->chars);
+            push(vm, OBJ_VAL(module));
+            bool loaded = loadPlugin(vm, plugin, module);
+            if (loaded) {
+                tableInsert(&vm->modules, OBJ_VAL(module_name),
+                            OBJ_VAL(module));
+            }
+            pop(vm);
+            free(plugin);
+            return loaded ? module : NULL;
+        }
+    }
     if (source == NULL) {
-        RUNTIME_ERR(vm, "Could not load module '%s'", module_name->chars);
+        // A file on the path wins over the copy built into the interpreter.
+        const char* embedded = embeddedModule(module_name->chars);
+        if (embedded != NULL) source = strdup(embedded);
+    }
+    if (source == NULL) {
+        char searched[400];
+        describeSearch(vm->module_path, module_name->chars, searched,
+                       sizeof(searched));
+        RUNTIME_ERR(vm, "Module '%s' not found, searched: %s",
+                    module_name->chars, searched);
         return NULL;
     }
     ObjModule* module = newModule(vm, module_name->chars);
@@ -135,22 +423,130 @@ ObjModule* loadModule(VM* vm, ObjString* module_name) {
     return module;
 }
 
-InterpretResult interpret(VM* vm, const char* source, ObjModule* module) {
+// Puts back the values of the globals and consts module had when saved was
+// copied from it. Globals defined since are kept, as code may point at them.
+static void restoreGlobals(ObjModule* module, ObjModule* saved) {
+    tableAddAll(&saved->symbols, &module->symbols);
+    freeTable(&module->consts);
+    initTable(&module->consts);
+    tableAddAll(&saved->consts, &module->consts);
+}
+
+bool reloadModule(VM* vm, ObjString* module_name) {
+    Value* cached = tableGet(&vm->modules, OBJ_VAL(module_name));
+    if (cached == NULL) {
+        RUNTIME_ERR(vm, "Module '%s' is not loaded", module_name->chars);
+        return false;
+    }
+    ObjModule* module = AS_MODULE(*cached);
+    if (isNativeModule(module_name->chars) || module == vm->main_module) {
+        RUNTIME_ERR(vm, "Module '%s' has no file to reload",
+                    module_name->chars);
+        return false;
+    }
+    char* source = findLissFile(vm->module_path, module_name->chars, NULL);
+    if (source == NULL) {
+        const char* embedded = embeddedModule(module_name->chars);
+        if (embedded != NULL) source = strdup(embedded);
+    }
+    if (source == NULL) {
+        RUNTIME_ERR(vm, "Could not load module '%s'", module_name->chars);
+        return false;
+    }
+
+    // If the file fails to compile or run, the globals and consts go back to
+    // the values they had, from a copy that keeps them alive meanwhile.
+    ObjModule* saved = copyModule(vm, module);
+    push(vm, OBJ_VAL(saved));
+    // The source declares its consts again, maybe with other values.
+    freeTable(&module->consts);
+    initTable(&module->consts);
+
+    ObjFunction* function = recompile(vm, source, module);
+    free(source);
+    if (function == NULL) {
+        restoreGlobals(module, saved);
+        pop(vm);
+        RUNTIME_ERR(vm, "Failed to reload module '%s': %s",
+                    module_name->chars, vm->error_msg);
+        return false;
+    }
+
+    push(vm, OBJ_VAL(function));
+    ObjClosure* closure = newClosure(vm, function);
+    pop(vm);
+    callFromNative(vm, OBJ_VAL(closure), 0, NULL);
+    if (vm->last_result != INTERPRET_OK) restoreGlobals(module, saved);
+    pop(vm);
+    return vm->last_result == INTERPRET_OK;
+}
+
+void pinValue(VM* vm, Value value) {
+    writeValueArray(vm, &vm->pinned, value);
+}
+
+void unpinValue(VM* vm, Value value) {
+    ValueArray* pinned = &vm->pinned;
+    for (int i = pinned->count - 1; i >= 0; i--) {
+        if (valuesEqual(pinned->values[i], value)) {
+            pinned->values[i] = pinned->values[--pinned->count];
+            return;
+        }
+    }
+}
+
+InterpretResult callExpr(VM* vm, ObjClosure* expr, Value* result) {
     vmRecover(vm);
+    *result = callFromNative(vm, OBJ_VAL(expr), 0, NULL);
+    return vm->last_result;
+}
+
+void freeExpr(VM* vm, ObjClosure* expr) { unpinValue(vm, OBJ_VAL(expr)); }
+
+static ObjModule* mainModule(VM* vm) {
+    if (vm->main_module == NULL) {
+        vm->main_module = newModule(vm, "main");
+        tableInsert(
+            &vm->modules, OBJ_VAL(vm->main_module->name),
+            OBJ_VAL(vm->main_module));  // Cache main module in modules table
+    }
+    return vm->main_module;
+}
+
+ObjFunction* compileMain(VM* vm, const char* source) {
+    vmRecover(vm);
+    ObjModule* module = mainModule(vm);
+    push(vm, OBJ_VAL(module));
+    ObjFunction* function = compile(vm, source, module);
+    pop(vm);
+    return function;
+}
+
+InterpretResult disassemble(VM* vm, const char* source, char** listing) {
+    ObjFunction* function = compileMain(vm, source);
+    if (function == NULL) return INTERPRET_COMPILE_ERROR;
+    *listing = sprintFunction(function);
+    return INTERPRET_OK;
+}
 
-    if (module == NULL) {
-        if (vm->main_module == NULL) {
-            vm->main_module = newModule(vm, "main");
-            tableInsert(
-                &vm->modules, OBJ_VAL(vm->main_module->name),
-                OBJ_VAL(
-                    vm->main_module));  // Cache main module in modules table
+// Lowers the stack top to top, telling the pop hook about each value.
+static inline void dropTo(VM* vm, Value* top) {
+    if (vm->hooks.on_pop != NULL) {
+        while (vm->stack_top > top) {
+            vm->stack_top--;
+            vm->hooks.on_pop(vm, *vm->stack_top, vm->hooks.ctx);
         }
-        module = vm->main_module;
-        push(vm, OBJ_VAL(module));  // Push for GC safety during compilation
-    } else {
-        push(vm, OBJ_VAL(module));
     }
+    vm->stack_top = top;
+}
+
+// Compiles source and pushes a frame that runs it from the start.
+static InterpretResult enterScript(VM* vm, const char* source,
+                                   ObjModule* module) {
+    vmRecover(vm);
+
+    if (module == NULL) module = mainModule(vm);
+    push(vm, OBJ_VAL(module));  // Push for GC safety during compilation
 
     ObjFunction* function = compile(vm, source, module);
     if (function == NULL) {
@@ -162,38 +558,141 @@ InterpretResult interpret(VM* vm, const char* source, ObjModule* module) {
     pop(vm);  // Pop the function after creating the closure
     pop(vm);  // Pop the main module after compilation
 
-    Value* old_stack_top = vm->stack_top;
-    int old_frame_cnt = vm->frame_cnt;
-
     push(vm, OBJ_VAL(closure));
     if (vm->frame_cnt >= vm->options.frames_max) {
         RUNTIME_ERR(vm, "Call stack overflow");
         return INTERPRET_RUNTIME_ERROR;
     }
+    ensureFrameCap(vm);
 
+    closure->function->call_cnt++;
     CallFrame* frame = &vm->frames[vm->frame_cnt++];
     frame->closure = closure;
     frame->slots = vm->stack_top - 1;  // point at the closure we've just pushed
     frame->ip = NULL;
+    frame->arg_cnt = 0;
+    return INTERPRET_OK;
+}
 
-    InterpretResult result = run(vm);
+InterpretResult interpret(VM* vm, const char* source, ObjModule* module) {
+    Value* old_stack_top = vm->stack_top;
+    int old_frame_cnt = vm->frame_cnt;
+    int old_try_cnt = vm->try_cnt;
+    int old_try_base = vm->try_base;
 
-    vm->stack_top = old_stack_top;
+    InterpretResult result = enterScript(vm, source, module);
+    if (result == INTERPRET_OK) result = run(vm);
+
+    // A native that ran the script goes on with its caller's state.
+    dropTo(vm, old_stack_top);
     vm->frame_cnt = old_frame_cnt;
+    vm->try_cnt = old_try_cnt;
+    vm->try_base = old_try_base;
 
     return result;
 }
 
+InterpretResult stepStart(VM* vm, const char* source, ObjModule* module) {
+    vm->step.active = false;
+    vm->step.stack_top = vm->stack_top;
+    vm->step.frame_cnt = vm->frame_cnt;
+    InterpretResult result = enterScript(vm, source, module);
+    if (result != INTERPRET_OK) return result;
+    vm->step.sentinel_frame_cnt = vm->frame_cnt - 1;
+    vm->step.active = true;
+    return INTERPRET_OK;
+}
+
+InterpretResult stepNext(VM* vm) {
+    if (!vm->step.active) return INTERPRET_OK;
+    vm->step.pending = true;
+    vm->step.paused = false;
+    InterpretResult result = run(vm);
+    if (!vm->step.paused) {
+        dropTo(vm, vm->step.stack_top);
+        vm->frame_cnt = vm->step.frame_cnt;
+        vm->step.active = false;
+    }
+    return result;
+}
+
+int interpretMany(VM* vm, const char* const* sources, int count,
+                  InterpretResult* results) {
+    int ok = 0;
+    for (int i = 0; i < count; i++) {
+        resetVM(vm);
+        results[i] = interpret(vm, sources[i], NULL);
+        if (results[i] == INTERPRET_OK) ok++;
+    }
+    return ok;
+}
+
 // --- Stack Operations ---
 
+static const char* functionName(const ObjFunction* fn) {
+    return fn->name != NULL ? fn->name->chars : "<script>";
+}
+
+// Returns the source line frame is at: that of the instruction it runs or,
+// in a caller, of the call it waits on. 0 if it has not started.
+static int frameLine(const CallFrame* frame) {
+    const ObjFunction* fn = frame->closure->function;
+    if (frame->ip == NULL || fn->loaded_code == NULL) return 0;
+    int slot = (int)(frame->ip - fn->loaded_code);
+    if (slot > 0) slot--;  // The ip is past the instruction being run
+    return fn->chunk.lines[fn->code_offsets[slot]];
+}
+
+// Raises message with the innermost STACK_TRACE_FRAMES frames as its trace,
+// so that a try can catch an overflow and still tell where it happened.
+static void raiseOverflow(VM* vm, const char* message) {
+    vm->overflowing = true;
+    raiseErr(vm, message);
+    char trace[2048];
+    int len = 0;
+    int shown = 0;
+    for (int i = vm->frame_cnt - 1; i >= 0; i--) {
+        if (len >= (int)sizeof(trace)) break;
+        if (shown == STACK_TRACE_FRAMES) {
+            len += snprintf(trace + len, sizeof(trace) - len,
+                            "  ... %d more frames\n", i + 1);
+            break;
+        }
+        const CallFrame* frame = &vm->frames[i];
+        const ObjFunction* fn = frame->closure->function;
+        len += snprintf(trace + len, sizeof(trace) - len,
+                        "  at %s:%s [line %d]\n",
+                        fn->module != NULL ? fn->module->name->chars : "?",
+                        functionName(fn), frameLine(frame));
+        shown++;
+    }
+    if (len >= (int)sizeof(trace)) len = sizeof(trace) - 1;
+    if (len > 0) {
+        AS_ERROR(vm->raise_value)->trace = copyString(vm, trace, len);
+    }
+    vm->overflowing = false;
+}
+
 void push(VM* vm, Value value) {
-    if ((size_t)(vm->stack_top - vm->stack) >= vm->options.stack_capacity) {
-        ERROR_LOG("Stack overflow");
-        vm->last_result = INTERPRET_RUNTIME_ERROR;
+    size_t limit =
+        vm->options.stack_capacity + (vm->overflowing ? STACK_SLACK : 0);
+    if ((size_t)(vm->stack_top - vm->stack) >= limit) {
+        if (vm->overflowing || vm->frame_cnt == 0) {
+            ERROR_LOG("Stack overflow");
+            vm->last_result = INTERPRET_RUNTIME_ERROR;
+            return;
+        }
+        char message[256];
+        snprintf(message, sizeof(message),
+                 "Stack overflow: more than %zu values on the stack in %s",
+                 vm->options.stack_capacity,
+                 functionName(vm->frames[vm->frame_cnt - 1].closure->function));
+        raiseOverflow(vm, message);
         return;
     }
     *vm->stack_top = value;
     vm->stack_top++;
+    if (vm->hooks.on_push != NULL) vm->hooks.on_push(vm, value, vm->hooks.ctx);
 }
 
 Value pop(VM* vm) {
@@ -203,11 +702,51 @@ Value pop(VM* vm) {
         return NIL_VAL;
     }
     vm->stack_top--;
+    if (vm->hooks.on_pop != NULL) {
+        vm->hooks.on_pop(vm, *vm->stack_top, vm->hooks.ctx);
+    }
     return *vm->stack_top;
 }
 
 Value peek(VM* vm, int distance) { return vm->stack_top[-1 - distance]; }
 
+void walkScopes(VM* vm, ObjModule* module, ScopeVisitor visit, void* ctx) {
+    if (!visit(&module->symbols, SCOPE_MODULE, ctx)) return;
+    if (!visit(&module->imports, SCOPE_IMPORTS, ctx)) return;
+    if (module != vm->core_module) {
+        visit(&vm->core_module->symbols, SCOPE_CORE, ctx);
+    }
+}
+
+typedef struct {
+    Value name;
+    Value* found;
+    ScopeKind kind;
+} ResolveCtx;
+
+static bool resolveIn(Table* scope, ScopeKind kind, void* ctx_) {
+    ResolveCtx* ctx = ctx_;
+    ctx->found = tableGet(scope, ctx->name);
+    ctx->kind = kind;
+    return ctx->found == NULL;
+}
+
+Value* resolveGlobal(VM* vm, ObjModule* module, Value name) {
+    ResolveCtx ctx = {name, NULL, SCOPE_MODULE};
+    walkScopes(vm, module, resolveIn, &ctx);
+    if (ctx.found != NULL && ctx.kind == SCOPE_IMPORTS) {
+        // The imports table holds a copy taken at import time. Reading the
+        // global of the source module instead lets the importer see a set!
+        // or a reload there, as mod:name does.
+        Value* from = tableGet(&module->import_from, name);
+        if (from != NULL) {
+            Value* live = tableGet(&AS_MODULE(*from)->symbols, name);
+            if (live != NULL) return live;
+        }
+    }
+    return ctx.found;
+}
+
 typedef bool (*BinaryOpFn)(VM* vm, Value a, Value b);
 
 static bool concatStrings(VM* vm, Value a, Value b) {
@@ -328,6 +867,138 @@ void printStack(VM* vm) {
     }
 }
 
+typedef struct {
+    const char* name;
+    uint64_t cnt;
+} MetricsEntry;
+
+static int cmpMetricsEntries(const void* a, const void* b) {
+    uint64_t ca = ((const MetricsEntry*)a)->cnt;
+    uint64_t cb = ((const MetricsEntry*)b)->cnt;
+    return (ca < cb) - (ca > cb);  // descending
+}
+
+VMMetrics vmMetrics(VM* vm) {
+    VMMetrics metrics = vm->metrics;
+    metrics.bytes_allocated = vm->bytes_allocated;
+    metrics.alloc_cnt = vm->alloc_cnt;
+    metrics.alloc_bytes = vm->alloc_bytes;
+    metrics.heap_peak = vm->heap_peak;
+    return metrics;
+}
+
+// Fills ops with the opcodes that ran, most frequent first, and returns how
+// many there are.
+static int sortedOps(VM* vm, MetricsEntry ops[OPCODE_CNT]) {
+    int op_cnt = 0;
+    for (int i = 0; i < OPCODE_CNT; i++) {
+        if (vm->metrics.op_cnt[i] == 0) continue;
+        ops[op_cnt++] = (MetricsEntry){opcodeToString((OpCode)i),
+                                       vm->metrics.op_cnt[i]};
+    }
+    qsort(ops, op_cnt, sizeof(MetricsEntry), cmpMetricsEntries);
+    return op_cnt;
+}
+
+// Returns the functions that were called, most frequent first, and sets
+// *count. The caller frees the array, which is NULL when out of memory.
+static MetricsEntry* sortedFunctions(VM* vm, int* count) {
+    int fn_cap = 0;
+    for (Obj* obj = vm->objects; obj != NULL; obj = obj->next) {
+        if (obj->type == OBJ_FUNCTION && ((ObjFunction*)obj)->call_cnt > 0) {
+            fn_cap++;
+        }
+    }
+    *count = 0;
+    MetricsEntry* fns = malloc(sizeof(MetricsEntry) * (fn_cap + 1));
+    if (fns == NULL) return NULL;
+    for (Obj* obj = vm->objects; obj != NULL; obj = obj->next) {
+        if (obj->type != OBJ_FUNCTION) continue;
+        ObjFunction* fn = (ObjFunction*)obj;
+        if (fn->call_cnt == 0) continue;
+        fns[(*count)++] = (MetricsEntry){
+            fn->name != NULL ? fn->name->chars : "<script>", fn->call_cnt};
+    }
+    qsort(fns, *count, sizeof(MetricsEntry), cmpMetricsEntries);
+    return fns;
+}
+
+// Top-N functions to report; the long tail is rarely interesting.
+#define METRICS_MAX_FUNCTIONS 20
+
+void printMetrics(VM* vm, FILE* out) {
+    VMMetrics* metrics = &vm->metrics;
+    MetricsEntry ops[OPCODE_CNT];
+    int op_cnt = sortedOps(vm, ops);
+
+    fprintf(out, "--- Opcode histogram (%llu instructions) ---\n",
+            (unsigned long long)metrics->instr_cnt);
+    for (int i = 0; i < op_cnt; i++) {
+        double share = 100.0 * (double)ops[i].cnt / (double)metrics->instr_cnt;
+        fprintf(out, "%-22s %12llu %6.2f%%\n", ops[i].name,
+                (unsigned long long)ops[i].cnt, share);
+    }
+    fprintf(out, "--- Heap (%zu bytes, %zu at peak) ---\n",
+            vm->bytes_allocated, vm->heap_peak);
+
+    int fn_cnt;
+    MetricsEntry* fns = sortedFunctions(vm, &fn_cnt);
+    if (fns == NULL) return;
+    fprintf(out, "--- Hot functions (calls) ---\n");
+    for (int i = 0; i < fn_cnt && i < METRICS_MAX_FUNCTIONS; i++) {
+        fprintf(out, "%-22s %12llu\n", fns[i].name,
+                (unsigned long long)fns[i].cnt);
+    }
+    free(fns);
+}
+
+#undef METRICS_MAX_FUNCTIONS
+
+// Writes a function name as a JSON string.
+static void writeJsonName(const char* name, FILE* out) {
+    fputc('"', out);
+    for (const char* c = name; *c != '\0'; c++) {
+        if (*c == '"' || *c == '\\') {
+            fprintf(out, "\\%c", *c);
+        } else if ((unsigned char)*c < ' ') {
+            fprintf(out, "\\u%04x", *c);
+        } else {
+            fputc(*c, out);
+        }
+    }
+    fputc('"', out);
+}
+
+void writeMetricsJson(VM* vm, FILE* out) {
+    VMMetrics metrics = vmMetrics(vm);
+    fprintf(out,
+            "{\n  \"instructions\": %llu,\n  \"gc_cycles\": %llu,\n"
+            "  \"heap_bytes\": %zu,\n  \"allocations\": %llu,\n"
+            "  \"allocated_bytes\": %llu,\n  \"heap_peak_bytes\": %zu,\n"
+            "  \"opcodes\": {",
+            (unsigned long long)metrics.instr_cnt,
+            (unsigned long long)metrics.gc_cnt, metrics.bytes_allocated,
+            (unsigned long long)metrics.alloc_cnt,
+            (unsigned long long)metrics.alloc_bytes, metrics.heap_peak);
+    MetricsEntry ops[OPCODE_CNT];
+    int op_cnt = sortedOps(vm, ops);
+    for (int i = 0; i < op_cnt; i++) {
+        fprintf(out, "%s\n    \"%s\": %llu", i > 0 ? "," : "", ops[i].name,
+                (unsigned long long)ops[i].cnt);
+    }
+    fprintf(out, "%s},\n  \"functions\": [", op_cnt > 0 ? "\n  " : "");
+
+    int fn_cnt;
+    MetricsEntry* fns = sortedFunctions(vm, &fn_cnt);
+    for (int i = 0; i < fn_cnt; i++) {
+        fprintf(out, "%s\n    {\"name\": ", i > 0 ? "," : "");
+        writeJsonName(fns[i].name, out);
+        fprintf(out, ", \"calls\": %llu}", (unsigned long long)fns[i].cnt);
+    }
+    free(fns);
+    fprintf(out, "%s]\n}\n", fn_cnt > 0 ? "\n  " : "");
+}
+
 void printConsts(Chunk* chunk) {
     DEBUG_LOG("Constants:");
     for (int i = 0; i < chunk->constants.count; i++) {
@@ -337,6 +1008,100 @@ void printConsts(Chunk* chunk) {
     }
 }
 
+// Raises the error of calling fn with arg_cnt arguments unless it takes that
+// many, which is from its required parameters up to all of them.
+static bool checkArity(VM* vm, ObjFunction* fn, int arg_cnt) {
+    if (arg_cnt >= fn->min_arity && arg_cnt <= fn->arity) return true;
+    if (fn->min_arity == fn->arity) {
+        RUNTIME_ERR(
+            vm, "Function %s: runtime error: expected %d arguments but got %d",
+            functionName(fn), fn->arity, arg_cnt);
+    } else {
+        RUNTIME_ERR(vm,
+                    "Function %s: runtime error: expected %d to %d arguments "
+                    "but got %d",
+                    functionName(fn), fn->min_arity, fn->arity, arg_cnt);
+    }
+    return false;
+}
+
+// Gives the parameters the caller left out a slot each, holding null until
+// the function's entry code computes their defaults.
+static void padArgs(VM* vm, ObjFunction* fn, int arg_cnt) {
+    for (int i = arg_cnt; i < fn->arity; i++) push(vm, NIL_VAL);
+}
+
+// Finds the parameter of fn that a keyword argument names, or -1.
+static int keywordParam(ObjFunction* fn, ObjString* name) {
+    for (int i = 0; i < fn->arity; i++) {
+        ObjString* param = AS_STRING(fn->params->items[i]);
+        if (param->length == name->length &&
+            memcmp(param->chars, name->chars, name->length) == 0) {
+            return i;
+        }
+    }
+    return -1;
+}
+
+// Lays out the keyword arguments of a call for its callee. The stack holds
+// the callee, pos_cnt positional arguments, and kw_cnt names each followed by
+// its value. When the callee is a function with a parameter of each name,
+// the values move to the positions of those parameters, and a parameter with
+// a default left out in between gets an absent value, which makes the callee
+// use the default. Any other callee gets the keyword arguments as one
+// trailing dict. Returns the number of arguments of the call.
+static int spreadKeywords(VM* vm, int pos_cnt, int kw_cnt) {
+    Value* kw = vm->stack_top - 2 * kw_cnt;
+    Value callee = kw[-pos_cnt - 1];
+    ObjFunction* fn =
+        IS_CLOSURE(callee) ? AS_CLOSURE(callee)->function : NULL;
+    int params[MAX_ARITY];
+    int last = pos_cnt;
+    for (int i = 0; i < kw_cnt && fn != NULL && fn->params != NULL; i++) {
+        params[i] = keywordParam(fn, AS_STRING(kw[2 * i]));
+        if (params[i] == -1) fn = NULL;
+        else if (params[i] + 1 > last) last = params[i] + 1;
+    }
+
+    if (fn == NULL || fn->params == NULL) {
+        ObjDict* dict = newDict(vm);
+        push(vm, OBJ_VAL(dict));
+        for (int i = 0; i < kw_cnt; i++) {
+            Value key = kw[2 * i];
+            dict->root = hamtPut(vm, dict->root, key, kw[2 * i + 1],
+                                 dict->next_seq++, hamtKeyHash(vm, key), 0);
+            dict->count++;
+        }
+        dropTo(vm, kw);
+        push(vm, OBJ_VAL(dict));
+        return pos_cnt + 1;
+    }
+
+    Value args[MAX_LOCALS];
+    bool given[MAX_LOCALS] = {false};
+    for (int i = 0; i < kw_cnt; i++) {
+        if (params[i] < pos_cnt) {
+            RUNTIME_ERR(vm, "'%s' of '%s' is given by position already",
+                        AS_STRING(kw[2 * i])->chars, functionName(fn));
+            return 0;
+        }
+        args[params[i]] = kw[2 * i + 1];
+        given[params[i]] = true;
+    }
+    for (int i = pos_cnt; i < last; i++) {
+        if (given[i]) continue;
+        if (i < fn->min_arity) {
+            RUNTIME_ERR(vm, "call to '%s' leaves out '%s'", functionName(fn),
+                        AS_STRING(fn->params->items[i])->chars);
+            return 0;
+        }
+        args[i] = ABSENT_VAL;
+    }
+    dropTo(vm, kw);
+    for (int i = pos_cnt; i < last; i++) push(vm, args[i]);
+    return last;
+}
+
 static void ensureFrameCap(VM* vm) {
     if (vm->frame_cnt + 1 <= vm->frame_cap) return;
     int new_cap = vm->frame_cap * 2;
@@ -349,6 +1114,22 @@ static void ensureFrameCap(VM* vm) {
                             sizeof(CallFrame) * vm->frame_cap);
 }
 
+// Dicts, lists, tuples and strings can be called with a single key or index,
+// as a shorthand for get: (xs 0) or (d "key").
+static bool isCallableCollection(Value callee) {
+    return IS_DICT(callee) || IS_LIST(callee) || IS_TUPLE(callee) ||
+           IS_STRING(callee);
+}
+
+static Value callCollection(VM* vm, Value callee, int argc, Value* argv) {
+    if (argc != 1) {
+        RUNTIME_ERR(vm, "Calling a %s takes 1 argument but got %d",
+                    valueTypeName(callee), argc);
+        return NIL_VAL;
+    }
+    return getItem(vm, callee, argv[0]);
+}
+
 // Call a Liss value (closure or native) from within a C native function.
 // Saves/restores stack, frame count, try state, and last_result.
 // On error, sets vm->last_result and returns NIL_VAL.
@@ -357,6 +1138,7 @@ Value callFromNative(VM* vm, Value callee, int argc, Value* argv) {
     Value old_last_popped = vm->last_popped_value;
     int old_frame_cnt = vm->frame_cnt;
     int saved_try_cnt = vm->try_cnt;
+    int saved_try_base = vm->try_base;
 
     push(vm, callee);
     for (int i = 0; i < argc; i++) push(vm, argv[i]);
@@ -364,58 +1146,82 @@ Value callFromNative(VM* vm, Value callee, int argc, Value* argv) {
     if (IS_OBJ(callee) && OBJ_TYPE(callee) == OBJ_NATIVE) {
         ObjNative* native = AS_NATIVE(callee);
         if (native->arity != -1 && argc != native->arity) {
-            vm->stack_top = old_stack_top;
+            dropTo(vm, old_stack_top);
             vm->last_popped_value = old_last_popped;
             return raiseErr(vm, "callFromNative: native arity mismatch");
         }
         Value result = native->function(vm, argc, vm->stack_top - argc);
-        vm->stack_top = old_stack_top;
+        dropTo(vm, old_stack_top);
+        vm->last_popped_value = old_last_popped;
+        return result;
+    }
+
+    if (isCallableCollection(callee)) {
+        Value result = callCollection(vm, callee, argc, vm->stack_top - argc);
+        dropTo(vm, old_stack_top);
         vm->last_popped_value = old_last_popped;
         return result;
     }
 
     if (!IS_OBJ(callee) || OBJ_TYPE(callee) != OBJ_CLOSURE) {
-        vm->stack_top = old_stack_top;
+        dropTo(vm, old_stack_top);
         vm->last_popped_value = old_last_popped;
         return raiseErr(vm, "callFromNative: not callable");
     }
 
     ObjClosure* closure = AS_CLOSURE(callee);
-    if (argc != closure->function->arity) {
-        vm->stack_top = old_stack_top;
+    if (argc < closure->function->min_arity ||
+        argc > closure->function->arity) {
+        dropTo(vm, old_stack_top);
         vm->last_popped_value = old_last_popped;
         return raiseErr(vm, "callFromNative: arity mismatch");
     }
 
     if (vm->frame_cnt >= (int)vm->options.frames_max) {
-        vm->stack_top = old_stack_top;
+        dropTo(vm, old_stack_top);
         vm->last_popped_value = old_last_popped;
-        return raiseErr(vm, "callFromNative: call stack overflow");
+        char message[256];
+        snprintf(message, sizeof(message),
+                 "Stack overflow: more than %zu call frames calling %s",
+                 vm->options.frames_max, functionName(closure->function));
+        raiseOverflow(vm, message);
+        return NIL_VAL;
     }
 
     ensureFrameCap(vm);
 
     if (closure->function->loaded_code == NULL && g_dispatch_table != NULL) {
         if (loadThreadedCode(vm, closure->function, g_dispatch_table) != 0) {
-            vm->stack_top = old_stack_top;
+            dropTo(vm, old_stack_top);
             vm->last_popped_value = old_last_popped;
+            // Keep the loader's error, e.g. an undefined variable.
+            if (vm->last_result != INTERPRET_OK) return NIL_VAL;
             return raiseErr(vm, "callFromNative: failed to load threaded code");
         }
     }
 
+    padArgs(vm, closure->function, argc);
+    if (vm->last_result != INTERPRET_OK) {
+        dropTo(vm, old_stack_top);
+        vm->last_popped_value = old_last_popped;
+        return NIL_VAL;
+    }
+    closure->function->call_cnt++;
     CallFrame* frame = &vm->frames[vm->frame_cnt++];
     frame->closure = closure;
-    frame->slots = vm->stack_top - argc - 1;
+    frame->slots = vm->stack_top - closure->function->arity - 1;
     frame->ip = closure->function->loaded_code;
+    frame->arg_cnt = argc;
 
-    vm->try_cnt = 0;
+    vm->try_base = vm->try_cnt;
     vm->last_result = INTERPRET_OK;
 
     InterpretResult r = run(vm);
     Value ret = vm->last_popped_value;
-    vm->stack_top = old_stack_top;
+    dropTo(vm, old_stack_top);
     vm->last_popped_value = old_last_popped;
     vm->try_cnt = saved_try_cnt;
+    vm->try_base = saved_try_base;
     vm->frame_cnt = old_frame_cnt;
     vm->last_result = r;
 
@@ -432,6 +1238,7 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
         return 0;  // Already loaded
     }
     Chunk* chunk = &function->chunk;
+    int* code_offsets = NULL;
 
     DEBUG_LOG(
         "Loading function '%s' with %d bytecode instructions and %d "
@@ -458,10 +1265,20 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
     for (int i = 0; i < chunk->count; i++) {
         byte_to_slot_map[i] = -1;
     }
+    code_offsets = malloc(sizeof(int) * chunk->count);
+    if (code_offsets == NULL) {
+        RUNTIME_ERR(vm, "Memory error allocating code offsets");
+        result = -1;
+        goto LOADER_CLEANUP;
+    }
 
     int* jumps_to_patch = NULL;
     int jump_count = 0;
     int jumps_capacity = 0;
+    // Pairs of (operand slot, byte after the instruction) per switch table.
+    int* tables_to_patch = NULL;
+    int table_count = 0;
+    int tables_capacity = 0;
 
     uint8_t* bytecode = chunk->code;
     int loaded_idx = 0;
@@ -475,7 +1292,8 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
         loaded_code[loaded_idx++] = dispatch_table[opcode];
 
         switch (opcode) {
-            case OP_CONSTANT: {
+            case OP_CONSTANT:
+            case OP_ACCESS: {
                 uint16_t const_index =
                     (uint16_t)(bytecode[0] << 8) | bytecode[1];
                 bytecode += 2;
@@ -486,14 +1304,18 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
             case OP_JUMP:
             case OP_JUMP_IF_FALSE:
             case OP_JUMP_IF_ERR:
-            case OP_TRY_START: {
+            case OP_TRY_START:
+            case OP_LOOP:
+            case OP_FOR_NEXT: {
                 // Read the relative offset from the original bytecode
                 uint16_t relative_byte_offset =
                     (uint16_t)(bytecode[0] << 8) | bytecode[1];
                 // The offset is relative to the byte after the jump
-                // operands
+                // operands, and OP_LOOP jumps backwards
                 int target_byte_addr =
-                    (bytecode - chunk->code) + 2 + relative_byte_offset;
+                    (bytecode - chunk->code) + 2 +
+                    (opcode == OP_LOOP ? -relative_byte_offset
+                                       : relative_byte_offset);
 
                 loaded_code[loaded_idx] = (void*)(uintptr_t)target_byte_addr;
                 if (jumps_capacity < jump_count + 1) {
@@ -515,6 +1337,30 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
                 loaded_idx++;
                 break;
             }
+            case OP_SWITCH_TABLE: {
+                uint16_t const_index =
+                    (uint16_t)(bytecode[0] << 8) | bytecode[1];
+                bytecode += 2;
+                if (tables_capacity < table_count + 2) {
+                    int old_capacity = tables_capacity;
+                    tables_capacity =
+                        tables_capacity < 8 ? 8 : tables_capacity * 2;
+                    tables_to_patch = (int*)reallocate(
+                        NULL, tables_to_patch, sizeof(int) * old_capacity,
+                        sizeof(int) * tables_capacity);
+                    if (tables_to_patch == NULL) {
+                        RUNTIME_ERR(vm,
+                                    "Memory error allocating switch tables");
+                        result = -1;
+                        goto LOADER_CLEANUP;
+                    }
+                }
+                tables_to_patch[table_count++] = loaded_idx;
+                tables_to_patch[table_count++] = bytecode - chunk->code;
+                loaded_code[loaded_idx++] =
+                    (void*)&chunk->constants.values[const_index];
+                break;
+            }
             case OP_SET_GLOBAL: {
                 uint16_t const_index =
                     (uint16_t)(bytecode[0] << 8) | bytecode[1];
@@ -539,13 +1385,7 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
                 bytecode += 2;
                 Value symbol_name = chunk->constants.values[const_index];
                 Value* symbol =
-                    tableGet(&function->module->symbols, symbol_name);
-                if (symbol == NULL) {
-                    symbol = tableGet(&function->module->imports, symbol_name);
-                }
-                if (symbol == NULL) {
-                    symbol = tableGet(&vm->core_module->symbols, symbol_name);
-                }
+                    resolveGlobal(vm, function->module, symbol_name);
                 if (symbol == NULL) {
                     RUNTIME_ERR(vm, "Undefined variable '%.*s'",
                                 AS_STRING(symbol_name)->length,
@@ -596,11 +1436,22 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
                 loaded_code[loaded_idx++] = (void*)(uintptr_t)arg_cnt;
                 break;
             }
-            case OP_LIST: {
+            case OP_CALL_KW:
+            case OP_TAIL_CALL_KW: {
+                uint8_t pos_cnt = *bytecode++;
+                uint8_t kw_cnt = *bytecode++;
+                loaded_code[loaded_idx++] = (void*)(uintptr_t)pos_cnt;
+                loaded_code[loaded_idx++] = (void*)(uintptr_t)kw_cnt;
+                break;
+            }
+            case OP_LIST:
+            case OP_TUPLE: {
                 uint8_t len = *bytecode++;
                 loaded_code[loaded_idx++] = (void*)(uintptr_t)len;
                 break;
             }
+            case OP_UNPACK:
+            case OP_ARG_MISSING:
             case OP_SLIDE: {
                 uint8_t n = *bytecode++;
                 loaded_code[loaded_idx++] = (void*)(uintptr_t)n;
@@ -653,9 +1504,14 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
             default:
                 break;  // No operands
         }
+        for (int i = byte_to_slot_map[byte_offset]; i < loaded_idx; i++) {
+            code_offsets[i] = byte_offset;
+        }
     }
     loaded_code = reallocate(NULL, loaded_code, sizeof(void*) * chunk->count,
                              sizeof(void*) * loaded_idx);
+    code_offsets = reallocate(NULL, code_offsets, sizeof(int) * chunk->count,
+                              sizeof(int) * loaded_idx);
     if (loaded_code == NULL) {
         RUNTIME_ERR(vm, "Memory error resizing loaded code");
         result = -1;
@@ -673,8 +1529,28 @@ static int loadThreadedCode(VM* vm, ObjFunction* function,
             goto LOADER_CLEANUP;
         }
 
+        // Negative for OP_LOOP; it reads the slot back as an intptr_t.
         int relative_slot_offset = target_slot_ix - (operand_slot_ix + 1);
-        loaded_code[operand_slot_ix] = (void*)(uintptr_t)relative_slot_offset;
+        loaded_code[operand_slot_ix] = (void*)(intptr_t)relative_slot_offset;
+    }
+
+    DEBUG_LOG("Loader second pass: Patching switch tables");
+    for (int i = 0; i < table_count; i += 2) {
+        int operand_slot_ix = tables_to_patch[i];
+        SwitchTablePatch patch = {
+            .vm = vm,
+            .root = AS_DICT(*(Value*)loaded_code[operand_slot_ix])->root,
+            .byte_to_slot_map = byte_to_slot_map,
+            .base_byte = tables_to_patch[i + 1],
+            .base_slot = operand_slot_ix + 1,
+            .ok = true,
+        };
+        hamtEach(patch.root, patchSwitchTarget, &patch);
+        if (!patch.ok) {
+            RUNTIME_ERR(vm, "Invalid switch target during patching");
+            result = -1;
+            goto LOADER_CLEANUP;
+        }
     }
 
 LOADER_CLEANUP:
@@ -682,16 +1558,89 @@ LOADER_CLEANUP:
         free(byte_to_slot_map);
     }
     reallocate(NULL, jumps_to_patch, sizeof(int) * jumps_capacity, 0);
+    reallocate(NULL, tables_to_patch, sizeof(int) * tables_capacity, 0);
     if (result != 0) {
         reallocate(NULL, loaded_code, sizeof(void*) * loaded_idx, 0);
+        reallocate(NULL, code_offsets, sizeof(int) * loaded_idx, 0);
         function->loaded_code = NULL;
     } else {
         function->loaded_code_size = loaded_idx;
         function->loaded_code = loaded_code;
+        function->code_offsets = code_offsets;
     }
     return result;
 }
 
+// Raises a type error for the first operand of a binary op that is not what
+// it expected. left_ok tells whether the blame goes to the right operand.
+static void operandTypeErr(VM* vm, const char* op, Value left, Value right,
+                           bool left_ok, const char* expected) {
+    RUNTIME_ERR(vm, "Type error: %s operand of %s must be %s, got %s",
+                left_ok ? "right" : "left", op, expected,
+                valueTypeName(left_ok ? right : left));
+}
+
+// Returns true if options.trace_filter lets instructions of the function
+// name of module be traced.
+static bool traced(VM* vm, const char* module, const char* name) {
+    const char* filter = vm->options.trace_filter;
+    if (filter == NULL) return true;
+    const char* colon = strchr(filter, ':');
+    if (colon == NULL) {
+        return strcmp(filter, name) == 0 || strcmp(filter, module) == 0;
+    }
+    size_t len = (size_t)(colon - filter);
+    return strlen(module) == len && strncmp(filter, module, len) == 0 &&
+           strcmp(colon + 1, name) == 0;
+}
+
+// Logs the instruction at offset of fn to options.trace as
+// "module:function [frames/stack] listing line".
+static void traceInstr(VM* vm, ObjFunction* fn, int offset) {
+    const char* module = fn->module != NULL ? fn->module->name->chars : "?";
+    const char* name = fn->name != NULL ? fn->name->chars : "<script>";
+    if (!traced(vm, module, name)) return;
+    char* instr = sprintInstruction(&fn->chunk, offset);
+    fprintf(vm->options.trace, "%s:%s [%d/%d] %s", module, name,
+            vm->frame_cnt, (int)(vm->stack_top - vm->stack), instr);
+    free(instr);
+}
+
+// Tells the step hooks about the instruction at frame->ip, and about the jump
+// to it if the one before ran in the same frame and did not lead to it. The
+// instruction is also logged to options.trace if set.
+static void watchInstr(VM* vm, CallFrame* frame) {
+    ObjFunction* fn = frame->closure->function;
+    StepState* step = &vm->step;
+    int slot = (int)(frame->ip - fn->loaded_code);
+    int offset = fn->code_offsets[slot];
+    if (vm->hooks.on_jump != NULL && step->last_fn == fn &&
+        step->last_frame_cnt == vm->frame_cnt && slot != step->next_slot) {
+        vm->hooks.on_jump(vm, fn->code_offsets[step->last_slot], offset,
+                          vm->hooks.ctx);
+    }
+    step->last_fn = fn;
+    step->last_frame_cnt = vm->frame_cnt;
+    step->last_slot = slot;
+    // Operands share the offset of their instruction.
+    int next = slot + 1;
+    while (next < (int)fn->loaded_code_size &&
+           fn->code_offsets[next] == offset) {
+        next++;
+    }
+    step->next_slot = next;
+    if (vm->hooks.on_instr != NULL) {
+        StepInstr instr = {
+            .op = (OpCode)fn->chunk.code[offset],
+            .function = fn,
+            .offset = offset,
+            .depth = vm->frame_cnt,
+        };
+        vm->hooks.on_instr(vm, &instr, vm->hooks.ctx);
+    }
+    if (vm->options.trace != NULL) traceInstr(vm, fn, offset);
+}
+
 static InterpretResult run(VM* vm) {
 #define BINARY_OP(op)                                                         \
     do {                                                                      \
@@ -706,21 +1655,20 @@ static InterpretResult run(VM* vm) {
         } else if (IS_REAL(a) && IS_INT(b)) {                                 \
             push(vm, REAL_VAL(AS_REAL(a) op(double) AS_INT(b)));              \
         } else {                                                              \
-            RUNTIME_ERR(                                                      \
-                vm,                                                           \
-                "Type error: operands must be numbers for binary operation"); \
-            result = INTERPRET_RUNTIME_ERROR;                                 \
-            goto RETURN;                                                      \
+            operandTypeErr(vm, #op, a, b, IS_NUMERIC(a), "a number");         \
+            goto RESCUE;                                                      \
         }                                                                     \
     } while (false)
 
-#define BINARY_BITWISE_OP(op)                          \
-    do {                                               \
-        Value b = pop(vm);                             \
-        Value a = pop(vm);                             \
-        if (IS_INT(a) && IS_INT(b)) {                  \
-            push(vm, INT_VAL(AS_INT(a) op AS_INT(b))); \
-        }                                              \
+#define BINARY_BITWISE_OP(op, name)                              \
+    do {                                                         \
+        Value b = pop(vm);                                       \
+        Value a = pop(vm);                                       \
+        if (!IS_INT(a) || !IS_INT(b)) {                          \
+            operandTypeErr(vm, name, a, b, IS_INT(a), "an int"); \
+            goto RESCUE;                                         \
+        }                                                        \
+        push(vm, INT_VAL(AS_INT(a) op AS_INT(b)));               \
     } while (false)
 
 #define COMPARISON_OP(op)                                        \
@@ -740,6 +1688,12 @@ static InterpretResult run(VM* vm) {
             push(vm, BOOL_VAL((double)AS_INT(a) op AS_REAL(b))); \
         } else if (IS_REAL(a) && IS_INT(b)) {                    \
             push(vm, BOOL_VAL(AS_REAL(a) op(double) AS_INT(b))); \
+        } else if (IS_STRING(a) && IS_STRING(b)) {               \
+            ObjString* x = AS_STRING(a);                         \
+            ObjString* y = AS_STRING(b);                         \
+            int cmp = compareText(x->chars, x->length, y->chars, \
+                                  y->length);                    \
+            push(vm, BOOL_VAL(cmp op 0));                        \
         } else {                                                 \
             RUNTIME_ERR(vm, "Unsupported comparison type");   \
             result = INTERPRET_RUNTIME_ERROR;                    \
@@ -798,15 +1752,51 @@ static InterpretResult run(VM* vm) {
         &&OP_ERROR_MSG_IMPL,
         &&OP_IS_PAIR_IMPL,
         &&OP_UNPACK_PAIR_IMPL,
+        &&OP_UNPACK_IMPL,
+        &&OP_ARG_MISSING_IMPL,
+        &&OP_CALL_KW_IMPL,
+        &&OP_TAIL_CALL_KW_IMPL,
         &&OP_SLIDE_IMPL,
 
         &&OP_SWAP_IMPL,
         &&OP_JUMP_IF_ERR_IMPL,
+        &&OP_APPROX_EQUAL_IMPL,
+        &&OP_ACCESS_IMPL,
+        &&OP_SWITCH_TABLE_IMPL,
+        &&OP_TUPLE_IMPL,
+        &&OP_LIST_APPEND_IMPL,
+        &&OP_LOOP_IMPL,
+        &&OP_FOR_INIT_IMPL,
+        &&OP_FOR_NEXT_IMPL,
     };
+    static_assert(sizeof(dispatch_table) / sizeof(dispatch_table[0]) ==
+                      OPCODE_CNT,
+                  "dispatch table must cover every opcode");
     g_dispatch_table = dispatch_table;
 
-    int sentinel_frame_cnt = vm->frame_cnt - 1;
+    // A stepped program runs one instruction per call, see stepNext. Runs
+    // nested in it, like those of callFromNative, go to the end.
+    const bool stepping = vm->step.pending;
+    vm->step.pending = false;
+    bool stepped = false;
+    const bool watched = vm->hooks.on_instr != NULL ||
+                         vm->hooks.on_jump != NULL || vm->options.trace != NULL;
+    int sentinel_frame_cnt =
+        stepping ? vm->step.sentinel_frame_cnt : vm->frame_cnt - 1;
     InterpretResult result = INTERPRET_OK;
+    // The number of arguments of the call being made, see CALL_VALUE.
+    int call_cnt = 0;
+    // Instructions are counted for the virtual clock and the budget.
+    const bool polled =
+        vm->options.timeout_ms > 0 || vm->options.cancel != NULL;
+    const bool counted = vm->options.deterministic ||
+                         vm->options.max_instrs > 0 || polled;
+    const bool metered = vm->options.max_instrs > 0 || polled ||
+                         vm->options.max_alloc > 0 || vm->options.max_heap > 0;
+    // Any of the above sends every dispatch through SLOW_DISPATCH, so a plain
+    // run tests one flag per instruction.
+    const bool slow =
+        stepping || watched || vm->options.profile || counted || metered;
     CallFrame* frame = &vm->frames[vm->frame_cnt - 1];
     if (frame->closure->function->loaded_code == NULL) {
         if (loadThreadedCode(vm, frame->closure->function, dispatch_table) !=
@@ -822,12 +1812,15 @@ static InterpretResult run(VM* vm) {
 #define DISPATCH()                             \
     do {                                       \
         if (vm->last_result != INTERPRET_OK) { \
-            if (vm->try_cnt > 0) {             \
+            if (vm->try_cnt > vm->try_base) {  \
                 goto RESCUE;                   \
             }                                  \
             result = vm->last_result;          \
             goto RETURN;                       \
         }                                      \
+        if (slow) {                            \
+            goto SLOW_DISPATCH;                \
+        }                                      \
         goto*(*frame->ip++);                   \
     } while (0)
 
@@ -840,6 +1833,26 @@ static InterpretResult run(VM* vm) {
 
     DISPATCH();
 
+SLOW_DISPATCH:
+    // What a run pays for only when it steps, is watched, counted or metered.
+    if (stepping && stepped) {
+        vm->step.paused = true;
+        return INTERPRET_OK;
+    }
+    stepped = true;
+    if (watched) {
+        watchInstr(vm, frame);
+    }
+    if (vm->options.profile) {
+        countOp(vm, *frame->ip);
+    } else if (counted) {
+        vm->metrics.instr_cnt++;
+    }
+    if (metered && overBudget(vm)) {
+        goto RESCUE;
+    }
+    goto*(*frame->ip++);
+
     // --- Opcode Implementations ---
 
 OP_RETURN_IMPL: {
@@ -857,7 +1870,7 @@ OP_RETURN_IMPL: {
         goto RETURN;
     }
 
-    vm->stack_top = frame->slots;
+    dropTo(vm, frame->slots);
     push(vm, res);
     vm->last_popped_value = NIL_VAL;  // Clear it once pushed back
     frame = &vm->frames[vm->frame_cnt - 1];
@@ -900,12 +1913,15 @@ OP_ADD_IMPL: {
             result = INTERPRET_RUNTIME_ERROR;
             goto RETURN;
         }
+    } else if (IS_NUMERIC(a)) {
+        operandTypeErr(vm, "+", a, b, true, "a number");
+        goto RESCUE;
+    } else if (IS_STRING(a)) {
+        operandTypeErr(vm, "+", a, b, true, "a string");
+        goto RESCUE;
     } else {
-        RUNTIME_ERR(vm,
-                    "Runtime error: operands must be two numbers or two "
-                    "strings for addition");
-        result = INTERPRET_RUNTIME_ERROR;
-        goto RETURN;
+        operandTypeErr(vm, "+", a, b, false, "a number or a string");
+        goto RESCUE;
     }
     DISPATCH();
 }
@@ -926,17 +1942,25 @@ OP_MULTIPLY_IMPL: {
             result = INTERPRET_RUNTIME_ERROR;
             goto RETURN;
         }
+    } else if (IS_NUMERIC(a)) {
+        operandTypeErr(vm, "*", a, b, true, "a number");
+        goto RESCUE;
+    } else if (IS_STRING(a)) {
+        operandTypeErr(vm, "*", a, b, true, "an int");
+        goto RESCUE;
     } else {
-        RUNTIME_ERR(vm,
-                    "Runtime error: operands must be two numbers or a string "
-                    "and a number for multiplication");
-        result = INTERPRET_RUNTIME_ERROR;
-        goto RETURN;
+        operandTypeErr(vm, "*", a, b, false, "a number or a string");
+        goto RESCUE;
     }
     DISPATCH();
 }
 
 OP_DIVIDE_IMPL: {
+    if (IS_INT(peek(vm, 1)) && IS_INT(peek(vm, 0)) &&
+        AS_INT(peek(vm, 0)) == 0) {
+        RUNTIME_ERR(vm, "Arithmetic error: integer division by zero");
+        goto RESCUE;
+    }
     BINARY_OP(/);
     DISPATCH();
 }
@@ -945,9 +1969,12 @@ OP_MODULO_IMPL: {
     Value b = pop(vm);
     Value a = pop(vm);
     if (!IS_INT(a) || !IS_INT(b)) {
-        RUNTIME_ERR(vm, "Type error: modulo requires integer operands");
-        result = INTERPRET_RUNTIME_ERROR;
-        goto RETURN;
+        operandTypeErr(vm, "%", a, b, IS_INT(a), "an int");
+        goto RESCUE;
+    }
+    if (AS_INT(b) == 0) {
+        RUNTIME_ERR(vm, "Arithmetic error: integer modulo by zero");
+        goto RESCUE;
     }
     push(vm, INT_VAL(AS_INT(a) % AS_INT(b)));
     DISPATCH();
@@ -956,9 +1983,9 @@ OP_MODULO_IMPL: {
 OP_NEGATE_IMPL: {
     Value value = pop(vm);
     if (!IS_NUMERIC(value)) {
-        RUNTIME_ERR(vm, "Runtime error: negation operand must be a number");
-        result = INTERPRET_RUNTIME_ERROR;
-        goto RETURN;
+        RUNTIME_ERR(vm, "Type error: operand of - must be a number, got %s",
+                    valueTypeName(value));
+        goto RESCUE;
     }
     if (IS_INT(value)) {
         push(vm, INT_VAL(-AS_INT(value)));
@@ -969,37 +1996,37 @@ OP_NEGATE_IMPL: {
 }
 
 OP_BAND_IMPL: {
-    BINARY_BITWISE_OP(&);
+    BINARY_BITWISE_OP(&, "&&");
     DISPATCH();
 }
 
 OP_BOR_IMPL: {
-    BINARY_BITWISE_OP(|);
+    BINARY_BITWISE_OP(|, "||");
     DISPATCH();
 }
 
 OP_BXOR_IMPL: {
-    BINARY_BITWISE_OP(^);
+    BINARY_BITWISE_OP(^, "^");
     DISPATCH();
 }
 
 OP_BNOT_IMPL: {
     if (!IS_INT(peek(vm, 0))) {
-        RUNTIME_ERR(vm, "Runtime error: bitwise operand must be an integer");
-        result = INTERPRET_RUNTIME_ERROR;
-        goto RETURN;
+        RUNTIME_ERR(vm, "Type error: operand of ~ must be an int, got %s",
+                    valueTypeName(peek(vm, 0)));
+        goto RESCUE;
     }
     push(vm, INT_VAL(~AS_INT(pop(vm))));
     DISPATCH();
 }
 
 OP_LSHIFT_IMPL: {
-    BINARY_BITWISE_OP(<<);
+    BINARY_BITWISE_OP(<<, "<<");
     DISPATCH();
 }
 
 OP_RSHIFT_IMPL: {
-    BINARY_BITWISE_OP(>>);
+    BINARY_BITWISE_OP(>>, ">>");
     DISPATCH();
 }
 
@@ -1027,6 +2054,13 @@ OP_NOT_IMPL: {
 OP_EQUAL_IMPL: {
     Value b = pop(vm);
     Value a = pop(vm);
+    if (vm->options.strict && !vm->real_eq_warned && IS_REAL(a) &&
+        IS_REAL(b)) {
+        fprintf(stderr,
+                "warning: `=` compares two reals exactly; use `~=` for "
+                "approximate equality\n");
+        vm->real_eq_warned = true;
+    }
     push(vm, BOOL_VAL(valuesEqual(a, b)));
     DISPATCH();
 }
@@ -1054,8 +2088,10 @@ OP_GET_GLOBAL_IMPL: {
     DISPATCH();
 }
 
-OP_CALL_IMPL: {
-    int arg_count = (int)READ_ARG();
+OP_CALL_IMPL:
+    call_cnt = (int)READ_ARG();
+CALL_VALUE: {
+    int arg_count = call_cnt;
     Value callee = peek(vm, arg_count);
     DEBUG_LOG(
         "[DEBUG] OP_CALL: FrameCount=%d, arg_count=%d, callee_type=%d, "
@@ -1075,7 +2111,7 @@ OP_CALL_IMPL: {
         }
         Value value =
             native->function(vm, arg_count, vm->stack_top - arg_count);
-        vm->stack_top -= arg_count + 1;  // Pop arguments and the native
+        dropTo(vm, vm->stack_top - arg_count - 1);  // Pop args and native
         frame =
             &vm->frames[vm->frame_cnt -
                         1];  // refresh: callFromNative may reallocate frames
@@ -1087,6 +2123,15 @@ OP_CALL_IMPL: {
         DISPATCH();
     }
 
+    if (isCallableCollection(callee)) {
+        Value value = callCollection(vm, callee, arg_count,
+                                     vm->stack_top - arg_count);
+        dropTo(vm, vm->stack_top - arg_count - 1);
+        if (vm->last_result != INTERPRET_OK) goto RESCUE;
+        push(vm, value);
+        DISPATCH();
+    }
+
     if (!IS_OBJ(callee) || OBJ_TYPE(callee) != OBJ_CLOSURE) {
         ERROR_LOG("Runtime error: can only call functions");
         printStack(vm);
@@ -1096,19 +2141,18 @@ OP_CALL_IMPL: {
     }
 
     ObjClosure* closure = AS_CLOSURE(callee);
-    if (arg_count != closure->function->arity) {
-        RUNTIME_ERR(
-            vm, "Function %s: runtime error: expected %d arguments but got %d",
-            closure->function->name->chars, closure->function->arity,
-            arg_count);
+    if (!checkArity(vm, closure->function, arg_count)) {
         result = INTERPRET_RUNTIME_ERROR;
         goto RETURN;
     }
 
     if (vm->frame_cnt >= vm->options.frames_max) {
-        RUNTIME_ERR(vm, "Runtime error: stack overflow (too many call frames)");
-        result = INTERPRET_RUNTIME_ERROR;
-        goto RETURN;
+        char message[256];
+        snprintf(message, sizeof(message),
+                 "Stack overflow: more than %zu call frames calling %s",
+                 vm->options.frames_max, functionName(closure->function));
+        raiseOverflow(vm, message);
+        goto RESCUE;
     }
     ensureFrameCap(vm);
     // Refresh the pointer because ensureFrameCap might have reallocated the
@@ -1121,10 +2165,14 @@ OP_CALL_IMPL: {
             goto RETURN;
         }
     }
+    closure->function->call_cnt++;
+    padArgs(vm, closure->function, arg_count);
+    if (vm->last_result != INTERPRET_OK) goto RESCUE;
     frame = &vm->frames[vm->frame_cnt++];
     frame->closure = closure;
-    frame->slots = vm->stack_top - arg_count - 1;
+    frame->slots = vm->stack_top - closure->function->arity - 1;
     frame->ip = closure->function->loaded_code;
+    frame->arg_cnt = arg_count;
 
     DISPATCH();
 }
@@ -1172,8 +2220,10 @@ OP_SET_UPVALUE_IMPL: {
     DISPATCH();
 }
 
-OP_TAIL_CALL_IMPL: {
-    int arg_cnt = (int)READ_ARG();
+OP_TAIL_CALL_IMPL:
+    call_cnt = (int)READ_ARG();
+TAIL_CALL_VALUE: {
+    int arg_cnt = call_cnt;
     Value callee = peek(vm, arg_cnt);
 
     if (IS_OBJ(callee) && OBJ_TYPE(callee) == OBJ_NATIVE) {
@@ -1187,7 +2237,7 @@ OP_TAIL_CALL_IMPL: {
             goto RETURN;
         }
         Value value = native->function(vm, arg_cnt, vm->stack_top - arg_cnt);
-        vm->stack_top -= arg_cnt + 1;  // Pop arguments and the native
+        dropTo(vm, vm->stack_top - arg_cnt - 1);  // Pop args and native
         frame =
             &vm->frames[vm->frame_cnt -
                         1];  // refresh: callFromNative may reallocate frames
@@ -1195,6 +2245,15 @@ OP_TAIL_CALL_IMPL: {
         DISPATCH();
     }
 
+    if (isCallableCollection(callee)) {
+        Value value =
+            callCollection(vm, callee, arg_cnt, vm->stack_top - arg_cnt);
+        dropTo(vm, vm->stack_top - arg_cnt - 1);
+        if (vm->last_result != INTERPRET_OK) goto RESCUE;
+        push(vm, value);
+        DISPATCH();
+    }
+
     if (!IS_OBJ(callee) || OBJ_TYPE(callee) != OBJ_CLOSURE) {
         RUNTIME_ERR(vm, "Runtime error: can only call functions");
         result = INTERPRET_RUNTIME_ERROR;
@@ -1202,10 +2261,7 @@ OP_TAIL_CALL_IMPL: {
     }
 
     ObjClosure* closure = AS_CLOSURE(callee);
-    if (arg_cnt != closure->function->arity) {
-        RUNTIME_ERR(
-            vm, "Function %s: runtime error: expected %d arguments but got %d",
-            closure->function->name->chars, closure->function->arity, arg_cnt);
+    if (!checkArity(vm, closure->function, arg_cnt)) {
         result = INTERPRET_RUNTIME_ERROR;
         goto RETURN;
     }
@@ -1216,22 +2272,26 @@ OP_TAIL_CALL_IMPL: {
     Value* dest = frame->slots;
 
     memmove(dest, src, sizeof(Value) * (arg_cnt + 1));
-    vm->stack_top = dest + arg_cnt + 1;
+    dropTo(vm, dest + arg_cnt + 1);
+    padArgs(vm, closure->function, arg_cnt);
+    if (vm->last_result != INTERPRET_OK) goto RESCUE;
 
     frame->closure = closure;
+    frame->arg_cnt = arg_cnt;
     if (closure->function->loaded_code == NULL) {
         if (loadThreadedCode(vm, closure->function, dispatch_table) != 0) {
             result = INTERPRET_RUNTIME_ERROR;
             goto RETURN;
         }
     }
+    closure->function->call_cnt++;
     frame->ip = closure->function->loaded_code;
     DISPATCH();
 }
 
 OP_TRY_START_IMPL: {
     uint16_t offset = (uint16_t)READ_ARG();
-    if (vm->try_cnt > TRY_MAX) {
+    if (vm->try_cnt >= TRY_MAX) {
         RUNTIME_ERR(vm, "Runtime error: too many nested try blocks");
         result = INTERPRET_RUNTIME_ERROR;
         goto RETURN;
@@ -1246,7 +2306,7 @@ OP_TRY_START_IMPL: {
 }
 
 OP_TRY_END_IMPL: {
-    if (vm->try_cnt == 0) {
+    if (vm->try_cnt == vm->try_base) {
         RUNTIME_ERR(vm,
                     "Runtime error: OP_TRY_END without matching OP_TRY_START");
         result = INTERPRET_RUNTIME_ERROR;
@@ -1269,7 +2329,7 @@ OP_LIST_IMPL: {
         // Remove the item we just used from the stack, but keep head on
         // top.
         *(vm->stack_top - 2) = head;
-        vm->stack_top--;
+        dropTo(vm, vm->stack_top - 1);
     }
     ObjList* list = newList(vm, len, head);
     pop(vm);  // Pop the head
@@ -1277,6 +2337,115 @@ OP_LIST_IMPL: {
     DISPATCH();
 }
 
+OP_LIST_APPEND_IMPL: {
+    // Both lists come straight from OP_LIST, so nothing else can see them and
+    // the chunk's spine is linked onto the list as it is.
+    ObjList* chunk = AS_LIST(pop(vm));
+    ObjList* list = AS_LIST(peek(vm, 0));
+    if (chunk->len > 0) {
+        ObjPair* last = list->last;
+        if (last == NULL && list->len > 0) {
+            last = AS_PAIR(list->head);
+            while (!IS_NIL(last->second)) last = AS_PAIR(last->second);
+        }
+        if (last == NULL) {
+            list->head = chunk->head;
+        } else {
+            last->second = chunk->head;
+        }
+        ObjPair* tail = AS_PAIR(chunk->head);
+        while (!IS_NIL(tail->second)) tail = AS_PAIR(tail->second);
+        list->last = tail;
+        list->len += chunk->len;
+    }
+    DISPATCH();
+}
+
+OP_LOOP_IMPL: {
+    intptr_t offset = (intptr_t)(*frame->ip++);
+    frame->ip += offset;
+    DISPATCH();
+}
+
+// The loop state of a for sits in two slots below its result. A dict is
+// replaced with its entries first. A list keeps the number of items left in
+// the first slot and the rest of its spine in the second, while a string,
+// tuple or integer stays in the first slot with an index in the second.
+OP_FOR_INIT_IMPL: {
+    Value coll = peek(vm, 0);
+    if (IS_DICT(coll)) {
+        coll = dictEntries(vm, AS_DICT(coll));
+        if (vm->last_result != INTERPRET_OK) goto RESCUE;
+        vm->stack_top[-1] = coll;
+    }
+    if (IS_LIST(coll)) {
+        vm->stack_top[-1] = INT_VAL(AS_LIST(coll)->len);
+        push(vm, AS_LIST(coll)->head);
+    } else if (IS_STRING(coll) || IS_TUPLE(coll) || IS_INT(coll)) {
+        push(vm, INT_VAL(0));
+    } else {
+        RUNTIME_ERR(vm,
+                    "Type error: for expects a list, tuple, string, dict or "
+                    "integer, got %s",
+                    valueTypeName(coll));
+        goto RESCUE;
+    }
+    DISPATCH();
+}
+
+OP_FOR_NEXT_IMPL: {
+    uint16_t offset = (uint16_t)(uintptr_t)(*frame->ip++);
+    Value coll = peek(vm, 2);
+    Value state = peek(vm, 1);
+    if (!IS_INT(state)) {
+        // A list: the count of items left and the spine.
+        if (AS_INT(coll) == 0) {
+            frame->ip += offset;
+            DISPATCH();
+        }
+        vm->stack_top[-3] = INT_VAL(AS_INT(coll) - 1);
+        vm->stack_top[-2] = AS_PAIR(state)->second;
+        push(vm, AS_PAIR(state)->first);
+        DISPATCH();
+    }
+    int64_t ix = AS_INT(state);
+    if (IS_INT(coll)) {
+        if (ix >= AS_INT(coll)) {
+            frame->ip += offset;
+            DISPATCH();
+        }
+        vm->stack_top[-2] = INT_VAL(ix + 1);
+        push(vm, INT_VAL(ix));
+    } else if (IS_TUPLE(coll)) {
+        if (ix >= AS_TUPLE(coll)->len) {
+            frame->ip += offset;
+            DISPATCH();
+        }
+        vm->stack_top[-2] = INT_VAL(ix + 1);
+        push(vm, AS_TUPLE(coll)->items[ix]);
+    } else {
+        ObjString* str = AS_STRING(coll);
+        if (ix >= str->length) {
+            frame->ip += offset;
+            DISPATCH();
+        }
+        int n = runeLength(&str->chars[ix], str->length - (int)ix);
+        vm->stack_top[-2] = INT_VAL(ix + n);
+        push(vm, OBJ_VAL(copyString(vm, &str->chars[ix], n)));
+    }
+    DISPATCH();
+}
+
+OP_TUPLE_IMPL: {
+    int len = (int)READ_ARG();
+    // The items stay on the stack, and so reachable, until they are copied.
+    ObjTuple* tuple = newTuple(vm, (uint32_t)len);
+    memcpy(tuple->items, vm->stack_top - len, sizeof(Value) * len);
+    dropTo(vm, vm->stack_top - len);
+    push(vm, OBJ_VAL(tuple));
+    DISPATCH();
+}
+
 OP_PAIR_IMPL: {
     Value second = pop(vm);
     Value first = pop(vm);
@@ -1320,8 +2489,67 @@ OP_UNPACK_PAIR_IMPL: {
     DISPATCH();
 }
 
+OP_UNPACK_IMPL: {
+    int n = (int)READ_ARG();
+    Value v = peek(vm, 0);
+    int64_t len = IS_TUPLE(v)  ? (int64_t)AS_TUPLE(v)->len
+                  : IS_LIST(v) ? (int64_t)AS_LIST(v)->len
+                  : IS_PAIR(v) ? 2
+                               : -1;
+    if (len < 0) {
+        RUNTIME_ERR(vm, "let [...] expects a tuple, list or pair, got %s",
+                    valueTypeName(v));
+        goto RESCUE;
+    }
+    if (len != n) {
+        RUNTIME_ERR(vm, "let [...] binds %d names, got %lld items", n,
+                    (long long)len);
+        goto RESCUE;
+    }
+    pop(vm);
+    Value cur = IS_LIST(v) ? AS_LIST(v)->head : NIL_VAL;
+    for (int i = 0; i < n; i++) {
+        if (IS_TUPLE(v)) {
+            push(vm, AS_TUPLE(v)->items[i]);
+        } else if (IS_PAIR(v)) {
+            push(vm, i == 0 ? AS_PAIR(v)->first : AS_PAIR(v)->second);
+        } else {
+            push(vm, AS_PAIR(cur)->first);
+            cur = AS_PAIR(cur)->second;
+        }
+        // Raising an overflow allocates, and v is no longer on the stack.
+        if (vm->last_result != INTERPRET_OK) goto RESCUE;
+    }
+    DISPATCH();
+}
+
+OP_ARG_MISSING_IMPL: {
+    int slot = (int)READ_ARG();
+    push(vm, BOOL_VAL(frame->arg_cnt < slot ||
+                      IS_ABSENT(frame->slots[slot])));
+    DISPATCH();
+}
+
+OP_CALL_KW_IMPL: {
+    int pos_cnt = (int)READ_ARG();
+    int kw_cnt = (int)READ_ARG();
+    call_cnt = spreadKeywords(vm, pos_cnt, kw_cnt);
+    if (vm->last_result != INTERPRET_OK) goto RESCUE;
+    goto CALL_VALUE;
+}
+
+OP_TAIL_CALL_KW_IMPL: {
+    int pos_cnt = (int)READ_ARG();
+    int kw_cnt = (int)READ_ARG();
+    call_cnt = spreadKeywords(vm, pos_cnt, kw_cnt);
+    if (vm->last_result != INTERPRET_OK) goto RESCUE;
+    goto TAIL_CALL_VALUE;
+}
+
 OP_SLIDE_IMPL: {
     uint8_t n = (uint8_t)READ_ARG();
+    // Block locals captured by closures must outlive their stack slots.
+    closeUpvalue(vm, vm->stack_top - 1 - n);
     Value res = pop(vm);
     for (uint8_t i = 0; i < n; i++) pop(vm);
     push(vm, res);
@@ -1344,8 +2572,85 @@ OP_JUMP_IF_ERR_IMPL: {
     DISPATCH();
 }
 
+OP_APPROX_EQUAL_IMPL: {
+    Value eps = pop(vm);
+    Value b = pop(vm);
+    Value a = pop(vm);
+    if (!IS_NUMERIC(a) || !IS_NUMERIC(b) || !IS_NUMERIC(eps)) {
+        RUNTIME_ERR(vm, "Type error: `~=` operands must be numbers");
+        goto RESCUE;
+    }
+    double x = IS_INT(a) ? (double)AS_INT(a) : AS_REAL(a);
+    double y = IS_INT(b) ? (double)AS_INT(b) : AS_REAL(b);
+    double e = IS_INT(eps) ? (double)AS_INT(eps) : AS_REAL(eps);
+    // The tolerance is absolute for small magnitudes and relative otherwise.
+    double scale = fmax(1.0, fmax(fabs(x), fabs(y)));
+    push(vm, BOOL_VAL(fabs(x - y) <= e * scale));
+    DISPATCH();
+}
+
+// Looks up one accessor path segment: a string key in a dict or an int index
+// in a list. Accessing anything on null gives null, so a missing key ends the
+// path quietly.
+OP_ACCESS_IMPL: {
+    Value key = *READ_CONSTANT();
+    Value box = pop(vm);
+    if (IS_NIL(box)) {
+        push(vm, NIL_VAL);
+        DISPATCH();
+    }
+    if (IS_STRING(key)) {
+        if (!IS_DICT(box)) {
+            RUNTIME_ERR(vm, "Type error: cannot access .%s on a %s",
+                        AS_CSTRING(key), valueTypeName(box));
+            goto RESCUE;
+        }
+        Value* val = hamtGet(AS_DICT(box)->root, key, hamtKeyHash(vm, key), 0);
+        push(vm, (val != NULL) ? *val : NIL_VAL);
+        DISPATCH();
+    }
+    int64_t ix = AS_INT(key);
+    if (!IS_LIST(box)) {
+        RUNTIME_ERR(vm, "Type error: cannot access [%lld] on a %s",
+                    (long long)ix, valueTypeName(box));
+        goto RESCUE;
+    }
+    ObjList* list = AS_LIST(box);
+    // A missing index ends the path with null, just like a missing key.
+    if (ix >= list->len) {
+        push(vm, NIL_VAL);
+        DISPATCH();
+    }
+    Value curr = list->head;
+    for (int64_t i = 0; i < ix; i++) curr = AS_PAIR(curr)->second;
+    push(vm, AS_PAIR(curr)->first);
+    DISPATCH();
+}
+
+// Looks an int or string subject up in the switch's table of literal arms
+// and jumps straight to the matching arm's body. Anything else falls through
+// to the compare chain with the subject still on the stack.
+OP_SWITCH_TABLE_IMPL: {
+    Value* table = READ_CONSTANT();
+    Value subject = peek(vm, 0);
+    if (!IS_INT(subject) && !IS_STRING(subject)) DISPATCH();
+    Value* target =
+        hamtGet(AS_DICT(*table)->root, subject, hamtKeyHash(vm, subject), 0);
+    if (target == NULL) DISPATCH();
+    pop(vm);
+    frame->ip += AS_INT(*target);
+    DISPATCH();
+}
+
 RESCUE: {
-    if (vm->try_cnt == 0) {
+    // A script that caught its budget running out or its cancellation could
+    // go on regardless.
+    if (vm->last_result == INTERPRET_BUDGET_EXCEEDED ||
+        vm->last_result == INTERPRET_CANCELLED) {
+        result = vm->last_result;
+        goto RETURN;
+    }
+    if (vm->try_cnt == vm->try_base) {
         result = INTERPRET_RUNTIME_ERROR;
         goto RETURN;
     }
@@ -1355,7 +2660,7 @@ RESCUE: {
         vm->frame_cnt--;
     }
 
-    vm->stack_top = try_block.stack_top;
+    dropTo(vm, try_block.stack_top);
     frame = &vm->frames[vm->frame_cnt - 1];
     frame->ip = try_block.handler_ip;
 
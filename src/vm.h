@@ -1,9 +1,14 @@
 #ifndef liss_vm_h
 #define liss_vm_h
 
+#include <stdatomic.h>
+
 #include "chunk.h"  // Include for Chunk definition
 #include "common.h"
+#include "diagnostic.h"
 #include "object.h"
+#include "opcode.h"
+#include "symbols.h"
 #include "table.h"
 #include "value.h"
 
@@ -13,16 +18,28 @@
 #define MAX_MODULE_SYMBOLS \
     128  // We need to limit this to avoid module table rehashing
 
+// Language versions are encoded as major * 100 + minor, so "1.2" is 102. A
+// module picks its version with (pragma :lang "1.2") or the --lang flag.
+#define LANG_VERSION_MIN 100
+#define LANG_VERSION_LATEST 100
+
 typedef enum {
     INTERPRET_OK,
     INTERPRET_COMPILE_ERROR,
-    INTERPRET_RUNTIME_ERROR
+    INTERPRET_RUNTIME_ERROR,
+    // The evaluation ran past max_instrs or timeout_ms. The error is in
+    // raise_value like a runtime error's, but try does not catch it.
+    INTERPRET_BUDGET_EXCEEDED,
+    // The host set options.cancel while the evaluation ran. As with a budget,
+    // try does not catch it.
+    INTERPRET_CANCELLED
 } InterpretResult;
 
 typedef struct {
     ObjClosure* closure;
     void** ip;
     Value* slots;
+    int arg_cnt;  // Passed by the caller, below the arity if defaults fill in
 } CallFrame;
 
 typedef struct {
@@ -38,11 +55,152 @@ typedef struct {
     size_t heap_growth_factor;
     size_t frames_max;
     bool stress_gc;  // If true, trigger GC on every allocation (for testing)
+    bool profile;    // If true, count executed opcodes in vm->metrics
+    bool strict;     // If true, report error-prone constructs at runtime
+    bool warnings;   // If true, report unused lets and unreachable code
+    int lang_version;  // Default language version of modules, 0 for latest
+    bool warn_deprecated_once;  // Report each deprecated native only once
+    // If true, time and rand are derived from the instruction counter and the
+    // seed, so runs produce identical output on every machine.
+    bool deterministic;
+    bool seeded;    // If true, rand starts from seed instead of the clock
+    uint64_t seed;  // Seed of rand, used when seeded or deterministic
+    // Mixed into the hash of every dict key, 0 for none. It changes where
+    // entries sit in a dict, never the order they list or print in.
+    uint64_t hash_seed;
+    // If true, only pure natives are available: the io module, Liss file
+    // imports and natives reading the clock, rand state or OS handles are not.
+    bool sandbox;
+    // Budgets of one evaluation, 0 for unlimited. Running past max_alloc
+    // raises a runtime error, past max_instrs or timeout_ms ends the
+    // evaluation with INTERPRET_BUDGET_EXCEEDED.
+    uint64_t max_instrs;  // Executed instructions
+    size_t max_alloc;     // Bytes allocated, whether or not freed since
+    uint64_t timeout_ms;  // Milliseconds of clockMs, virtual if deterministic
+    // Set by the host, from any thread or a signal handler, to stop the
+    // evaluation running with INTERPRET_CANCELLED, NULL for none. The VM never
+    // clears it, so later evaluations stop too until the host does.
+    atomic_bool* cancel;
+    // Bytes the heap of the VM may hold at once, its natives and modules
+    // included, 0 for unlimited. Past it the garbage is collected, and if that
+    // does not make room a runtime error is raised, which try catches.
+    size_t max_heap;
+    // Directories separated by ':' that Liss file imports are looked up in, in
+    // order, or NULL for defaultModulePath.
+    const char* module_path;
+    // Directory a module name may climb into with "..", past the directories
+    // of module_path, or NULL to refuse any name that climbs out of them.
+    const char* project_root;
+    // If true, an import with no Liss file may load a shared library plugin
+    // of the same name from the path, see plugin.h.
+    bool plugins;
+    // Deepest expression nesting the compiler accepts, 0 for the default.
+    int max_nesting;
+    // If true, a script ending in a let, const or named fn evaluates to the
+    // value bound, as the REPL shows it. Otherwise it evaluates to null.
+    bool defs_yield_value;
+    // Compiled regexes the re natives keep for patterns given as strings,
+    // least recently used dropped first. 0 compiles the pattern every call.
+    int regex_cache_size;
+    // If set, every executed instruction is logged here with its operands,
+    // the stack depth and the function running it.
+    FILE* trace;
+    // Only instructions of functions or modules of this name are traced, or
+    // of the one function if given as "module:function". NULL traces all.
+    const char* trace_filter;
+    // Stream behind io:stdin, which io:read_line and io:read_all_stdin read
+    // by default. NULL for the stdin of the process.
+    FILE* input;
+    // Command-line arguments that follow the script, as io:args lists them.
+    const char* const* args;
+    int arg_cnt;
 } VMOptions;
 
-typedef struct VM {
+// Default of options.max_nesting. The one-pass compiler recurses into every
+// nested expression, and a nested fn costs a whole Compiler, so this keeps
+// malformed input from overflowing the C stack. Raise it with care.
+#define DEFAULT_MAX_NESTING 256
+
+// Default of options.regex_cache_size.
+#define DEFAULT_REGEX_CACHE_SIZE 64
+
+// Slots past options.stack_capacity kept for raising the error of a value
+// stack overflow, which takes a few pushes of its own.
+#define STACK_SLACK 8
+
+// Innermost frames listed in the trace of a stack overflow error.
+#define STACK_TRACE_FRAMES 8
+
+// Instructions that make up one millisecond of the virtual clock used in
+// deterministic mode.
+#define VIRTUAL_INSTRS_PER_MS 1000
+
+// Instructions run between two looks at the clock for options.timeout_ms and
+// at options.cancel.
+#define POLL_INSTRS 1024
+
+// Budgets of formulaVMOptions.
+#define FORMULA_MAX_INSTRS 100000
+#define FORMULA_MAX_ALLOC (1024 * 1024)  // 1MB
+
+// Execution counters. The opcode histogram and instruction count are
+// collected when options.profile is set, the rest always. Per-function call
+// counts live on ObjFunction itself.
+typedef struct {
+    uint64_t op_cnt[OPCODE_CNT];
+    uint64_t instr_cnt;
+    uint64_t gc_cnt;         // Collections run
+    size_t bytes_allocated;  // The heap fields are filled in by vmMetrics
+    uint64_t alloc_cnt;
+    uint64_t alloc_bytes;
+    size_t heap_peak;
+} VMMetrics;
+
+// An instruction about to run, as told to StepHooks.on_instr.
+typedef struct {
+    OpCode op;
+    ObjFunction* function;  // Whose chunk holds the instruction
+    int offset;             // Of the instruction in the chunk, as disassembled
+    int depth;              // Call frames, the instruction's included
+} StepInstr;
+
+// Callbacks through which a host watches the stack machine, e.g. to animate
+// it in a teaching UI. Any of them may be NULL, and ctx is passed to all.
+// on_instr runs before each instruction. on_push and on_pop run for each
+// value put on or taken off the stack, natives and the compiler included;
+// the few instructions that update a value in place, like the counters of a
+// for loop, report nothing. on_jump runs when an instruction moves on to
+// another than the next one of its function, with the byte offsets of both.
+// Set them before the code to watch starts.
+typedef struct {
+    void (*on_instr)(VM* vm, const StepInstr* instr, void* ctx);
+    void (*on_push)(VM* vm, Value value, void* ctx);
+    void (*on_pop)(VM* vm, Value value, void* ctx);
+    void (*on_jump)(VM* vm, int from, int to, void* ctx);
+    void* ctx;
+} StepHooks;
+
+// A program run an instruction at a time, see stepStart.
+typedef struct {
+    bool active;   // Started and not yet ended
+    bool pending;  // The next run executes one instruction
+    bool paused;   // The last run stopped after it
+    int sentinel_frame_cnt;
+    Value* stack_top;  // Restored when the program ends
+    int frame_cnt;
+    // The instruction before, to tell a jump from moving on.
+    ObjFunction* last_fn;
+    int last_frame_cnt;
+    int last_slot;
+    int next_slot;
+} StepState;
+
+struct VM {
     VMOptions options;
     size_t bytes_allocated;
+    uint64_t alloc_cnt;    // Allocations and growing reallocations of the heap
+    uint64_t alloc_bytes;  // Bytes they added, never taken back by frees
+    size_t heap_peak;      // Most bytes_allocated has been
     size_t next_gc;
 
     CallFrame* frames;
@@ -63,15 +221,50 @@ typedef struct VM {
     ObjUpvalue* open_upvalues;  // Linked list of open upvalues
 
     void* compiler;  // Current compiler (if any) to help GC mark its roots
+    SymbolIndex* symbols;  // Where the compiler records names, see indexSymbols
 
     TryBlock try_stack[TRY_MAX];
     int try_cnt;
+    // Handlers below it belong to a run that called into the current one from
+    // a native, which must not catch errors of the inner run.
+    int try_base;
     Value raise_value;
-    char error_msg[512];
+    char error_msg[2048];  // One line per error of a failed compile
+    // The first errors of the last failed compile, and how many it had.
+    Diagnostic diagnostics[DIAGNOSTICS_MAX];
+    int diagnostic_cnt;
+    bool overflowing;  // Raising a stack overflow, allowed into STACK_SLACK
+
+    VMMetrics metrics;
+    bool real_eq_warned;  // Strict mode reports `=` on two reals only once
+    int warning_cnt;      // Compile warnings reported so far
+
+    char* module_path;     // Copy of options.module_path, or the default
+    void** plugins;        // Handles of the plugins loaded, see plugin.h
+    int plugin_cnt;
+    int plugin_cap;
+    Resource* resources;   // Live OS-backed objects, newest first
+    int resource_id;       // Last id handed out to a resource
+    uint64_t rand_state;   // State of the rand generator
+    ValueArray pinned;     // Values held by the host, kept alive by the GC
+    ValueArray re_cache;   // Compiled regexes, most recently used first
+    ValueArray tests;      // (name . fn) pairs from test:deftest, in order
+    uint64_t instrs_mark;  // instr_cnt when the current evaluation started
+    uint64_t alloc_mark;   // alloc_bytes when it started
+    int64_t clock_mark;    // clockMs when it started, if timeout_ms is set
+    // Objects the GC has marked but whose references it has not traced yet.
+    // Tracing from a worklist rather than recursively keeps long chains of
+    // closures or pairs from overflowing the C stack.
+    Obj** gray_stack;
+    int gray_cnt;
+    int gray_cap;
+
+    StepHooks hooks;
+    StepState step;
 
     // (!!!) Flexible Array Member for the stack. Keep at the end.
     Value stack[];
-} VM;
+};
 
 static inline VMOptions defaultVMOptions() {
     VMOptions options = {
@@ -80,10 +273,70 @@ static inline VMOptions defaultVMOptions() {
         .heap_growth_factor = 2,
         .stack_capacity = 256,
         .stress_gc = false,
+        .profile = false,
+        .strict = false,
+        .warnings = false,
+        .lang_version = LANG_VERSION_LATEST,
+        .warn_deprecated_once = false,
+        .deterministic = false,
+        .seeded = false,
+        .seed = 0,
+        .sandbox = false,
+        .max_instrs = 0,
+        .max_alloc = 0,
+        .timeout_ms = 0,
+        .cancel = NULL,
+        .max_heap = 0,
+        .module_path = NULL,
+        .project_root = NULL,
+        .plugins = false,
+        .max_nesting = DEFAULT_MAX_NESTING,
+        .defs_yield_value = false,
+        .regex_cache_size = DEFAULT_REGEX_CACHE_SIZE,
+        .trace = NULL,
+        .trace_filter = NULL,
+        .input = NULL,
+        .args = NULL,
+        .arg_cnt = 0,
     };
     return options;
 }
 
+// Options for evaluating untrusted formulas with compileExpr: pure natives
+// only, and budgets that stop a runaway expression.
+static inline VMOptions formulaVMOptions() {
+    VMOptions options = defaultVMOptions();
+    options.sandbox = true;
+    options.max_instrs = FORMULA_MAX_INSTRS;
+    options.max_alloc = FORMULA_MAX_ALLOC;
+    return options;
+}
+
+// Parses a "major.minor" language version, e.g. "1.2" into 102. Returns false
+// if the text is not a well-formed version.
+bool parseLangVersion(const char* text, int length, int* version);
+
+// Returns true if the module opted into the given language version or a
+// later one. Semantics that change between versions are gated on this.
+static inline bool langAtLeast(ObjModule* module, int version) {
+    return module->lang_version >= version;
+}
+
+// Maps a negative index to a position counted from the end of a sequence of
+// the given length, so -1 is the last element. Strict mode keeps negative
+// indices as they are, and callers reject them as out of bounds.
+static inline int64_t indexFromEnd(VM* vm, int64_t index, int64_t length) {
+    if (index < 0 && !vm->options.strict) return index + length;
+    return index;
+}
+
+// Milliseconds since the Unix epoch, or since the start of the VM on the
+// virtual clock in deterministic mode.
+int64_t clockMs(VM* vm);
+
+// Returns the next pseudo-random 64-bit number (splitmix64).
+uint64_t nextRand(VM* vm);
+
 // Creates and initializes a new VM with a given stack capacity.
 VM* newVM(VMOptions options);
 
@@ -92,19 +345,99 @@ void destroyVM(VM* vm);
 
 void vmRecover(VM* vm);
 
+// Returns the VM to the state newVM left it in so it can run another script
+// without being rebuilt: the main module and imported Liss files are dropped
+// along with the stack, frames and errors, while interned strings, native
+// modules and the stack and frame memory are kept. Host values on the stack
+// are dropped too; pin the ones that must survive.
+void resetVM(VM* vm);
+
 ObjModule* loadModule(VM* vm, ObjString* module_name);
 
+// Runs the file of a loaded Liss module again in the same module, so mod:name
+// and the names imported from it see the new definitions. Globals the new
+// source no longer defines keep their values. Called from a running program
+// or by the host after vmRecover. On failure the globals and consts get their
+// old values back, and the error is raised and false returned.
+bool reloadModule(VM* vm, ObjString* module_name);
+
+// The tables a global name is looked up in, innermost first.
+typedef enum {
+    SCOPE_MODULE,   // the module's own definitions
+    SCOPE_IMPORTS,  // symbols imported into the module
+    SCOPE_CORE,     // built-ins of the core module
+} ScopeKind;
+
+typedef bool (*ScopeVisitor)(Table* scope, ScopeKind kind, void* ctx);
+
+// Visits the scopes of module in lookup order until visit returns false.
+void walkScopes(VM* vm, ObjModule* module, ScopeVisitor visit, void* ctx);
+
+// Resolves a global name in module the way the loader does, or returns NULL.
+Value* resolveGlobal(VM* vm, ObjModule* module, Value name);
+
+// Keeps a value alive while only host code refers to it. Values pinned twice
+// must be unpinned twice.
+void pinValue(VM* vm, Value value);
+void unpinValue(VM* vm, Value value);
+
+// Evaluates an expression compiled with compileExpr and stores its value in
+// *result. On a runtime error the raised value is in vm->raise_value.
+InterpretResult callExpr(VM* vm, ObjClosure* expr, Value* result);
+
+// Releases an expression compiled with compileExpr.
+void freeExpr(VM* vm, ObjClosure* expr);
+
 // The main entry point for running source code.
 InterpretResult interpret(VM* vm, const char* source, ObjModule* module);
 
+// Compiles source like interpret, but stops before its first instruction so
+// that stepNext can run it one at a time. Nothing else may run on the VM
+// until the program ends.
+InterpretResult stepStart(VM* vm, const char* source, ObjModule* module);
+
+// Runs the next instruction of the program begun by stepStart, and the calls
+// it makes into natives whole. vm->step.active is false once the program has
+// ended, with its value in vm->last_popped_value as after interpret.
+InterpretResult stepNext(VM* vm);
+
+// Runs every source as a fresh main module, resetting the VM before each one,
+// and stores the outcomes in results. Returns how many sources ran without
+// an error.
+int interpretMany(VM* vm, const char* const* sources, int count,
+                  InterpretResult* results);
+
+// Compiles source as the main module without running it and returns its
+// top-level function, or NULL with vm->error_msg set. Imported modules are
+// still loaded, so Liss files they name run their top level.
+ObjFunction* compileMain(VM* vm, const char* source);
+
+// Compiles source as the main module without running it and points *listing
+// at its disassembly, which the caller frees. Imported modules are still
+// loaded, so Liss files they name run their top level.
+InterpretResult disassemble(VM* vm, const char* source, char** listing);
+
 // Stack operations
 void push(VM* vm, Value value);
 Value pop(VM* vm);
 Value peek(VM* vm, int distance);
 
-// Call a Liss closure or native from a C native function.
+// Call a Liss closure or native from a C native function. If the call fails,
+// vm->last_result is set and the native should return at once, its value is
+// then ignored.
 Value callFromNative(VM* vm, Value callee, int argc, Value* argv);
 
+// Returns the counters of the VM with the heap fields filled in.
+VMMetrics vmMetrics(VM* vm);
+
+// Prints the opcode histogram and the hottest functions, most frequent first.
+void printMetrics(VM* vm, FILE* out);
+
+// Writes the metrics of the VM as a JSON object: the instruction, collection
+// and heap counters, every opcode that ran with its count and every function
+// called with its calls, most frequent first.
+void writeMetricsJson(VM* vm, FILE* out);
+
 void printStack(VM* vm);
 void printConsts(Chunk* chunk);
 
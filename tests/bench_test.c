@@ -0,0 +1,57 @@
+#define _POSIX_C_SOURCE 200809L
+
+#include "bench.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+
+#include "minunit.h"
+#include "vm.h"
+
+// Runs a workload briefly, saves the results and compares a second run with
+// them as the baseline.
+static char* test_bench_baseline() {
+    char path[] = "/tmp/liss_bench_XXXXXX";
+    int fd = mkstemp(path);
+    mu_assert("Could not create a temporary file.", fd >= 0);
+    close(fd);
+
+    BenchOptions bench = defaultBenchOptions();
+    bench.filter = "regex";
+    bench.time_ns = 1000000;
+    bench.save = path;
+    char* output = NULL;
+    size_t len = 0;
+    FILE* out = open_memstream(&output, &len);
+    int status = runBenchmarks(bench, defaultVMOptions(), out);
+    fclose(out);
+    bool listed = strstr(output, "\nregex ") != NULL &&
+                  strstr(output, "\nfib ") == NULL;
+    free(output);
+    mu_assert("The first run failed.", status == 0);
+    mu_assert("The filter should pick the regex workload only.", listed);
+
+    bench.save = NULL;
+    bench.baseline = path;
+    out = open_memstream(&output, &len);
+    status = runBenchmarks(bench, defaultVMOptions(), out);
+    fclose(out);
+    bool compared = strstr(output, "base ns/op") != NULL &&
+                    strchr(strstr(output, "\nregex "), '%') != NULL;
+    free(output);
+    remove(path);
+    mu_assert("The second run failed.", status == 0);
+    mu_assert("The run should be compared with the baseline.", compared);
+
+    bench.baseline = "/nonexistent/baseline.json";
+    mu_assert("A missing baseline should make the status 74.",
+              runBenchmarks(bench, defaultVMOptions(), stdout) == 74);
+    return NULL;
+}
+
+void bench_suite() {
+    printf("\n--- Bench Suite ---\n");
+    mu_run_test(test_bench_baseline);
+}
@@ -225,8 +225,9 @@ static char* test_compile(void) {
             .name = "compile AND expression with 2 operands",
             .src = "(and true false)",
             .expected_instructions = (uint8_t[]){OP_TRUE, OP_JUMP_IF_FALSE, 0,
-                                                 1, OP_FALSE, OP_RETURN},
-            .expected_instruction_count = 6,
+                                                 2, OP_POP, OP_FALSE,
+                                                 OP_RETURN},
+            .expected_instruction_count = 7,
             .expected_constants = NULL,
             .expected_constant_size = 0,
         },
@@ -234,9 +235,10 @@ static char* test_compile(void) {
             .name = "compile AND expression with more operands",
             .src = "(and true true false)",
             .expected_instructions =
-                (uint8_t[]){OP_TRUE, OP_JUMP_IF_FALSE, 0, 5, OP_TRUE,
-                            OP_JUMP_IF_FALSE, 0, 1, OP_FALSE, OP_RETURN},
-            .expected_instruction_count = 10,
+                (uint8_t[]){OP_TRUE, OP_JUMP_IF_FALSE, 0, 7, OP_POP, OP_TRUE,
+                            OP_JUMP_IF_FALSE, 0, 2, OP_POP, OP_FALSE,
+                            OP_RETURN},
+            .expected_instruction_count = 12,
             .expected_constants = NULL,
             .expected_constant_size = 0,
         },
@@ -244,9 +246,10 @@ static char* test_compile(void) {
             .name = "compile AND with all true",
             .src = "(and true true true)",
             .expected_instructions =
-                (uint8_t[]){OP_TRUE, OP_JUMP_IF_FALSE, 0, 5, OP_TRUE,
-                            OP_JUMP_IF_FALSE, 0, 1, OP_TRUE, OP_RETURN},
-            .expected_instruction_count = 10,
+                (uint8_t[]){OP_TRUE, OP_JUMP_IF_FALSE, 0, 7, OP_POP, OP_TRUE,
+                            OP_JUMP_IF_FALSE, 0, 2, OP_POP, OP_TRUE,
+                            OP_RETURN},
+            .expected_instruction_count = 12,
             .expected_constants = NULL,
             .expected_constant_size = 0,
         },
@@ -254,9 +257,10 @@ static char* test_compile(void) {
             .name = "compile AND with all false",
             .src = "(and false false false)",
             .expected_instructions =
-                (uint8_t[]){OP_FALSE, OP_JUMP_IF_FALSE, 0, 5, OP_FALSE,
-                            OP_JUMP_IF_FALSE, 0, 1, OP_FALSE, OP_RETURN},
-            .expected_instruction_count = 10,
+                (uint8_t[]){OP_FALSE, OP_JUMP_IF_FALSE, 0, 7, OP_POP,
+                            OP_FALSE, OP_JUMP_IF_FALSE, 0, 2, OP_POP,
+                            OP_FALSE, OP_RETURN},
+            .expected_instruction_count = 12,
             .expected_constants = NULL,
             .expected_constant_size = 0,
         },
@@ -668,8 +672,9 @@ static char* test_compile(void) {
             .name = "compile let expression",
             .src = "(let x 42)",
             .expected_instructions =
-                (uint8_t[]){OP_CONSTANT, 0, 0, OP_SET_GLOBAL, 0, 1, OP_RETURN},
-            .expected_instruction_count = 7,
+                (uint8_t[]){OP_CONSTANT, 0, 0, OP_SET_GLOBAL, 0, 1, OP_POP,
+                            OP_NULL, OP_RETURN},
+            .expected_instruction_count = 9,
             .expected_constants =
                 (ExpectedConstant[]){
                     {EXPECT_INT, .as.integer = 42},
@@ -682,8 +687,8 @@ static char* test_compile(void) {
             .src = "(let b (+ a 1))",
             .expected_instructions =
                 (uint8_t[]){OP_GET_GLOBAL, 0, 0, OP_CONSTANT, 0, 1, OP_ADD,
-                            OP_SET_GLOBAL, 0, 2, OP_RETURN},
-            .expected_instruction_count = 11,
+                            OP_SET_GLOBAL, 0, 2, OP_POP, OP_NULL, OP_RETURN},
+            .expected_instruction_count = 13,
             .expected_constants =
                 (ExpectedConstant[]){
                     {EXPECT_OBJ_STRING, .as.obj_string = "a"},
@@ -727,9 +732,11 @@ static char* test_compile(void) {
                     OP_SET_GLOBAL,
                     0,
                     1,
+                    OP_POP,
+                    OP_NULL,
                     OP_RETURN,
                 },
-            .expected_instruction_count = 7,
+            .expected_instruction_count = 9,
             .expected_constant_size = 2,
             .expected_constants =
                 (ExpectedConstant[]){
@@ -748,9 +755,11 @@ static char* test_compile(void) {
                     OP_SET_GLOBAL,
                     0,
                     1,
+                    OP_POP,
+                    OP_NULL,
                     OP_RETURN,
                 },
-            .expected_instruction_count = 7,
+            .expected_instruction_count = 9,
             .expected_constants =
                 (ExpectedConstant[]){
                     {EXPECT_OBJ_FUNCTION, .as.obj_function = "addOne"},
@@ -991,6 +1000,27 @@ static char* test_compile(void) {
                 },
             .expected_constant_size = 3,
         },
+        {
+            .name = "compile a tuple",
+            .src = "#[1 []]",
+            .expected_instructions =
+                (uint8_t[]){
+                    OP_CONSTANT,
+                    0,
+                    0,
+                    OP_LIST,
+                    0,
+                    OP_TUPLE,
+                    2,
+                    OP_RETURN,
+                },
+            .expected_instruction_count = 8,
+            .expected_constants =
+                (ExpectedConstant[]){
+                    {EXPECT_INT, .as.integer = 1},
+                },
+            .expected_constant_size = 1,
+        },
         {
             .name = "compile an empty dict",
             .src = "(dict)",
@@ -1065,7 +1095,813 @@ static char* test_compile(void) {
     return NULL;
 }
 
+typedef struct {
+    const char* src;
+    const char* expected_error;  // NULL if the source must compile
+} NativeCallTest;
+
+static char* test_native_call_checks(void) {
+    NativeCallTest tests[] = {
+        {"(len)",
+         "[line 1] Native function 'len': expected 1 arguments but got 0"},
+        {"(len \"a\" \"b\")",
+         "[line 1] Native function 'len': expected 1 arguments but got 2"},
+        {"(len 42)",
+         "[line 1] Native function 'len': argument 1 must be a string, list, "
+         "tuple or dict, got int"},
+        {"(keys #[1])",
+         "[line 1] Native function 'keys': argument 1 must be a dict, got "
+         "tuple"},
+        {"(keys\n  [1 2])",
+         "[line 2] Native function 'keys': argument 1 must be a dict, got "
+         "list"},
+        {"(to_int \"1\")",
+         "[line 1] Native function 'to_int': argument 1 must be an int or "
+         "real, got string"},
+        {"(import str)\n(str:upper 1)",
+         "[line 2] Native function 'upper': argument 1 must be a string, got "
+         "int"},
+        {"(import str as s)\n(s:substr \"abc\" 0)",
+         "[line 2] Native function 'substr': expected 3 arguments but got 2"},
+        {"(len \"abc\")", NULL},
+        {"(len [1 2])", NULL},
+        {"(let x 42) (len x)", NULL},
+        {"(dict)", NULL},
+        {"(put (dict) \"k\" 1)", NULL},
+        {"(let len (fn [a b] a)) (len 1 2)", NULL},
+        {"(fn [len] (len))", NULL},
+        {"(fn [len] (fn [] (len 1 2)))", NULL},
+        {"(fn g [] (len 1 2)) (fn len [a b] a)", NULL},
+        {"(fn g [] (keys 1)) (let keys (fn [x] x))", NULL},
+        {"(fn g [] (len 1 2)) (fn f [] (let [len n] [(fn [a b] a) 0]))",
+         NULL},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        VM* vm = newVM(defaultVMOptions());
+        ObjModule* test_module = newModule(vm, "test_module");
+        ObjFunction* function = compile(vm, tests[i].src, test_module);
+        if (tests[i].expected_error == NULL) {
+            if (function == NULL) {
+                printf("Failed test: %s: %s\n", tests[i].src, vm->error_msg);
+                mu_assert("Compiler should not fail.", false);
+            }
+        } else {
+            if (function != NULL ||
+                strcmp(vm->error_msg, tests[i].expected_error) != 0) {
+                printf("Failed test: %s\n  expected: %s\n  got: %s\n",
+                       tests[i].src, tests[i].expected_error, vm->error_msg);
+                mu_assert("Unexpected compile error.", false);
+            }
+        }
+        destroyVM(vm);
+    }
+
+    return NULL;
+}
+
+typedef struct {
+    const char* src;
+    int expected_warnings;
+} WarningTest;
+
+static char* test_warnings(void) {
+    WarningTest tests[] = {
+        {"((let x 1) 2)", 1},
+        {"((let x 1) (+ x 1))", 0},
+        {"((let _x 1) 2)", 0},
+        {"((let x 1))", 0},
+        {"((let x 1) (let y 2) 3)", 2},
+        {"(let x 1) 2", 0},
+        {"(fn [] (let y 2) 3)", 1},
+        {"(fn [a] (let y a) (fn [] y))", 0},
+        {"(fn [unused] 1)", 0},
+        {"((raise! (err \"x\")) 1 2)", 1},
+        {"(fn [] (raise! (err \"x\")) 1)", 1},
+        {"(raise! (err \"x\"))\n1", 1},
+        {"(fn [raise!] (raise! 1) 2)", 0},
+        {"(cond true (raise! (err \"x\")) 1)\n2", 0},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        VMOptions options = defaultVMOptions();
+        options.warnings = true;
+        VM* vm = newVM(options);
+        ObjModule* test_module = newModule(vm, "test_module");
+        ObjFunction* function = compile(vm, tests[i].src, test_module);
+        mu_assert("Compiler should not fail.", function != NULL);
+        if (vm->warning_cnt != tests[i].expected_warnings) {
+            printf("Failed test: %s\n  expected %d warnings, got %d\n",
+                   tests[i].src, tests[i].expected_warnings, vm->warning_cnt);
+            mu_assert("Unexpected warning count.", false);
+        }
+        destroyVM(vm);
+    }
+
+    // Without the option nothing is reported.
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* test_module = newModule(vm, "test_module");
+    mu_assert("Compiler should not fail.",
+              compile(vm, "((let x 1) 2)", test_module) != NULL);
+    mu_assert("Warnings should be off by default.", vm->warning_cnt == 0);
+    destroyVM(vm);
+
+    return NULL;
+}
+
+static char* test_pragma(void) {
+    NativeCallTest tests[] = {
+        {"(pragma :lang \"1.0\")\n(+ 1 2)", NULL},
+        {"(pragma :lang \"1.0\") (pragma :lang \"1.0\") 1", NULL},
+        {"1 (pragma :lang \"1.0\")",
+         "[line 1] pragma must precede all other expressions in a module"},
+        {"(fn [] (pragma :lang \"1.0\"))",
+         "[line 1] pragma must precede all other expressions in a module"},
+        {"(pragma :speed \"1.0\")", "[line 1] Unknown pragma ':speed'"},
+        {"(pragma :lang \"9.9\")",
+         "[line 1] Unsupported language version \"9.9\""},
+        {"(pragma :lang \"1\")",
+         "[line 1] Unsupported language version \"1\""},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        VM* vm = newVM(defaultVMOptions());
+        ObjModule* test_module = newModule(vm, "test_module");
+        ObjFunction* function = compile(vm, tests[i].src, test_module);
+        bool ok = function != NULL;
+        if (tests[i].expected_error != NULL) {
+            ok = function == NULL &&
+                 strcmp(vm->error_msg, tests[i].expected_error) == 0;
+        }
+        if (!ok) {
+            printf("Failed test: %s\n  got: %s\n", tests[i].src,
+                   vm->error_msg);
+            mu_assert("Unexpected pragma result.", false);
+        }
+        if (function != NULL) {
+            mu_assert("Module should record the language version.",
+                      test_module->lang_version == 100);
+        }
+        destroyVM(vm);
+    }
+
+    int version = 0;
+    mu_assert("1.2 parses", parseLangVersion("1.2", 3, &version));
+    mu_assert("1.2 is 102", version == 102);
+    mu_assert("2.15 parses", parseLangVersion("2.15", 4, &version));
+    mu_assert("2.15 is 215", version == 215);
+    mu_assert("1. is invalid", !parseLangVersion("1.", 2, &version));
+    mu_assert(".1 is invalid", !parseLangVersion(".1", 2, &version));
+    mu_assert("1.2.3 is invalid", !parseLangVersion("1.2.3", 5, &version));
+    mu_assert("1.100 is invalid", !parseLangVersion("1.100", 5, &version));
+
+    return NULL;
+}
+
+static int countDeprecationWarnings(VMOptions options, const char* srcs[],
+                                    size_t count) {
+    VM* vm = newVM(options);
+    deprecateNative(vm, vm->core_module, "len", "size");
+    for (size_t i = 0; i < count; i++) {
+        ObjModule* test_module = newModule(vm, "test_module");
+        if (compile(vm, srcs[i], test_module) == NULL) {
+            destroyVM(vm);
+            return -1;
+        }
+    }
+    int warnings = vm->warning_cnt;
+    destroyVM(vm);
+    return warnings;
+}
+
+// Each top-level form that fails reports its first error, on a line of its
+// own, and the compiler goes on from the next form.
+static char* test_error_recovery(void) {
+    NativeCallTest tests[] = {
+        {"(- )\n(+ 1 2)\n(let x (/ ))",
+         "[line 1] `-` expects 1 or 2 operands, got 0\n"
+         "[line 3] `/` expects 2 operands, got 0"},
+        {"(let a (- ))\n(let b (- ))\n(fn f [] (- ))",
+         "[line 1] `-` expects 1 or 2 operands, got 0\n"
+         "[line 2] `-` expects 1 or 2 operands, got 0\n"
+         "[line 3] `-` expects 1 or 2 operands, got 0"},
+        {"1)\n(+ 1 2)\n(- )",
+         "[line 1] Expected expression\n"
+         "[line 3] `-` expects 1 or 2 operands, got 0"},
+        {"(let x [1 (- ) 3])\n(let y [1 2 3]) (- )",
+         "[line 1] `-` expects 1 or 2 operands, got 0\n"
+         "[line 2] `-` expects 1 or 2 operands, got 0"},
+        // An unclosed form runs to the end of the source.
+        {"(let x (- ) \n(+ 1 2)",
+         "[line 1] `-` expects 1 or 2 operands, got 0"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        VM* vm = newVM(defaultVMOptions());
+        ObjModule* test_module = newModule(vm, "test_module");
+        ObjFunction* function = compile(vm, tests[i].src, test_module);
+        bool ok = function == NULL &&
+                  strcmp(vm->error_msg, tests[i].expected_error) == 0;
+        if (!ok) {
+            printf("Failed test: %s\n  got: %s\n", tests[i].src,
+                   vm->error_msg);
+            mu_assert("Unexpected compile errors.", false);
+        }
+        mu_assert("The stack should be left as it was.",
+                  vm->stack_top == vm->stack);
+        destroyVM(vm);
+    }
+
+    // The globals of a failed compile are removed, the good forms' too.
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* test_module = newModule(vm, "test_module");
+    mu_assert("Compiler should fail.",
+              compile(vm, "(let good 1)\n(let bad (- ))", test_module) == NULL);
+    mu_assert("Compiler should succeed.",
+              compile(vm, "(let good 2) (let bad 3)", test_module) != NULL);
+    destroyVM(vm);
+
+    return NULL;
+}
+
+// Each error keeps its module, line, column and source line, which
+// printDiagnostics shows with a caret under the column.
+static char* test_diagnostics(void) {
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* test_module = newModule(vm, "test_module");
+    const char* src = "(let a 1)\n(let b (- ))\n(let s \"open";
+    mu_assert("Compiler should fail.", compile(vm, src, test_module) == NULL);
+    mu_assert("Both errors should be kept.", vm->diagnostic_cnt == 2);
+
+    Diagnostic* diag = &vm->diagnostics[0];
+    mu_assert("Wrong module.", strcmp(diag->module, "test_module") == 0);
+    mu_assert("Wrong place.", diag->line == 2 && diag->column == 11);
+    mu_assert("Wrong source line.",
+              strcmp(diag->source_line, "(let b (- ))") == 0);
+    mu_assert("Wrong message.",
+              strcmp(diag->message, "`-` expects 1 or 2 operands, got 0") == 0);
+    // An unterminated string is reported where it starts.
+    diag = &vm->diagnostics[1];
+    mu_assert("Wrong scanner error place.",
+              diag->line == 3 && diag->column == 8 &&
+                  strcmp(diag->message, "Unterminated string.") == 0);
+
+    char* text = NULL;
+    size_t len = 0;
+    FILE* out = open_memstream(&text, &len);
+    printDiagnostics(vm, out, "script.liss");
+    fclose(out);
+    const char* expected =
+        "error: `-` expects 1 or 2 operands, got 0\n"
+        " --> test_module.liss:2:11\n"
+        "  |\n"
+        "2 | (let b (- ))\n"
+        "  |           ^\n"
+        "\n"
+        "error: Unterminated string.\n"
+        " --> test_module.liss:3:8\n"
+        "  |\n"
+        "3 | (let s \"open\n"
+        "  |        ^\n";
+    bool ok = strcmp(text, expected) == 0;
+    if (!ok) printf("Got:\n%s", text);
+    free(text);
+    destroyVM(vm);
+    mu_assert("Unexpected rendering.", ok);
+
+    // The main module is named by the file given, and tabs are kept so the
+    // caret lines up under them.
+    vm = newVM(defaultVMOptions());
+    mu_assert("Script should fail.",
+              interpret(vm, "\t(- )", NULL) == INTERPRET_COMPILE_ERROR);
+    text = NULL;
+    out = open_memstream(&text, &len);
+    printDiagnostics(vm, out, "script.liss");
+    fclose(out);
+    ok = strstr(text, " --> script.liss:1:5\n") != NULL &&
+         strstr(text, "1 | \t(- )\n  | \t   ^\n") != NULL;
+    if (!ok) printf("Got:\n%s", text);
+    free(text);
+    destroyVM(vm);
+    mu_assert("Unexpected rendering of the main module.", ok);
+
+    return NULL;
+}
+
+static char* test_deprecated_natives(void) {
+    VM* vm = newVM(defaultVMOptions());
+    mu_assert("len is a native",
+              deprecateNative(vm, vm->core_module, "len", "size"));
+    mu_assert("no such native",
+              !deprecateNative(vm, vm->core_module, "nope", "size"));
+    destroyVM(vm);
+
+    const char* srcs[] = {"(len [1]) (len [2])", "(len \"a\")", "len"};
+    VMOptions options = defaultVMOptions();
+    mu_assert("every call site warns",
+              countDeprecationWarnings(options, srcs, 3) == 3);
+
+    options.warn_deprecated_once = true;
+    mu_assert("warns once per session",
+              countDeprecationWarnings(options, srcs, 3) == 1);
+
+    const char* shadowed[] = {"(fn [len] (len 1 2))"};
+    mu_assert("locals are not deprecated",
+              countDeprecationWarnings(defaultVMOptions(), shadowed, 1) == 0);
+
+    return NULL;
+}
+
+// The listing names globals, resolves jump targets and includes nested
+// functions, and nothing is run.
+static char* test_disassemble(void) {
+    const char* src = "(fn pick [x] (cond x 1 2)) (let y (pick true)) y";
+    const char* expected[] = {
+        "== <script> ==",
+        "OP_SET_GLOBAL 1 'pick'",
+        "OP_GET_GLOBAL 1 'pick'",
+        "== (pick x) ==",
+        "0002 OP_JUMP_IF_FALSE 7 -> 0012",
+        "0009 OP_JUMP 4 -> 0016",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected disassembly.", false);
+        }
+    }
+    free(listing);
+    mu_assert("Nothing should run.", IS_NIL(vm->last_popped_value));
+
+    mu_assert("Compile errors should be reported.",
+              disassemble(vm, "(let", &listing) == INTERPRET_COMPILE_ERROR);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A while loop checks its condition, slides the body's value over the last
+// result and jumps back to the condition.
+static char* test_while_loop(void) {
+    const char* src = "(fn spin [n] (while (> n 0) (set! n (- n 1))))";
+    const char* expected[] = {
+        "0000 OP_NULL",
+        "0007 OP_JUMP_IF_FALSE 14 -> 0024",
+        "0019 OP_SLIDE 1",
+        "0021 OP_LOOP 23 -> 0001",
+        "0024 OP_POP",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected while loop code.", false);
+        }
+    }
+    free(listing);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A for loop keeps the collection, the loop state and the result below the
+// loop variable, and leaves only the result when the items run out.
+static char* test_for_loop(void) {
+    const char* src =
+        "(fn total [xs] (let acc 0) (for [x xs] (set! acc (+ acc x))))";
+    const char* expected[] = {
+        "0005 OP_FOR_INIT\n0006 OP_NULL",
+        "0007 OP_FOR_NEXT 14 -> 0024",
+        "0012 OP_GET_LOCAL 6",
+        "0021 OP_LOOP 17 -> 0007",
+        "0024 OP_SLIDE 2",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected for loop code.", false);
+        }
+    }
+    free(listing);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A let in a call argument lives above the callee and the arguments before
+// it, which are on the stack too.
+static char* test_call_argument_locals(void) {
+    const char* src = "(fn f [a] (format \"%d%d\" a ((let b 2) b)))";
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    bool found = strstr(listing, "0011 OP_GET_LOCAL 5") != NULL;
+    if (!found) printf("Unexpected slot in:\n%s\n", listing);
+    free(listing);
+    destroyVM(vm);
+    mu_assert("b should be read from the slot above the arguments.", found);
+    return NULL;
+}
+
+// A const is folded into a single constant, and references to it load that
+// constant instead of a global.
+static char* test_const_inlining(void) {
+    const char* src = "(const TAU (* 2 3.5)) (fn f [r] (* TAU r))";
+    const char* expected[] = {
+        "0000 OP_CONSTANT 0 '7'\n0003 OP_POP",
+        "== (f r) ==\nconstants:\n     0 7\ncode:\n0000 OP_CONSTANT 0 '7'",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected const code.", false);
+        }
+    }
+    bool loads_global = strstr(listing, "OP_GET_GLOBAL") != NULL;
+    free(listing);
+    destroyVM(vm);
+    mu_assert("A const should not be loaded as a global.", !loads_global);
+    return NULL;
+}
+
+// An anonymous function bound by let takes the let's name, and inside a
+// function it captures its own slot to call itself.
+static char* test_let_bound_fn(void) {
+    const char* src = "(let f (fn [n] n)) (fn outer [] (let g (fn [] (g))) g)";
+    const char* expected[] = {
+        "0000 OP_CLOSURE 0 <fn f>",
+        "== (f n) ==",
+        "0000 OP_CLOSURE 0 <fn g>\n    Upvalue 0: is_local=1, index=1",
+        "0005 OP_GET_LOCAL 1",
+        "== (g) ==\ncode:\n0000 OP_GET_UPVALUE 0",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected let-bound fn code.", false);
+        }
+    }
+    free(listing);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_param_defaults(void) {
+    const char* src = "(fn greet [name (greeting \"hello\")] greeting)";
+    const char* expected[] = {
+        "== (greet name [greeting]) ==",
+        "0000 OP_ARG_MISSING 2\n"
+        "0002 OP_JUMP_IF_FALSE 10 -> 0015\n"
+        "0005 OP_POP\n"
+        "0006 OP_CONSTANT 0 '\"hello\"'\n"
+        "0009 OP_SET_LOCAL 2\n"
+        "0011 OP_POP\n"
+        "0012 OP_JUMP 1 -> 0016\n"
+        "0015 OP_POP\n"
+        "0016 OP_GET_LOCAL 2",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected default initialization.", false);
+        }
+    }
+    free(listing);
+
+    const char* invalid[] = {
+        "(fn f [(a 1) b] a)",
+        "(fn f [a (b (let c 1))] a)",
+        "(fn f [a (b 1 2)] a)",
+        "(fn f [a (b] a)",
+    };
+    for (size_t i = 0; i < sizeof(invalid) / sizeof(invalid[0]); i++) {
+        resetVM(vm);
+        if (interpret(vm, invalid[i], NULL) != INTERPRET_COMPILE_ERROR) {
+            printf("Compiled: %s\n", invalid[i]);
+            mu_assert("Invalid parameters should not compile.", false);
+        }
+    }
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_keyword_args(void) {
+    const char* src =
+        "(fn f [a (b 1) (c 2)] c)\n"
+        "(f 0 :c 3)\n"
+        "(fn g [h] (h 1 :x 2))";
+    const char* expected[] = {
+        "0007 OP_GET_GLOBAL 1 'f'\n"
+        "0010 OP_CONSTANT 2 '0'\n"
+        "0013 OP_CONSTANT 3 '\"c\"'\n"
+        "0016 OP_CONSTANT 4 '3'\n"
+        "0019 OP_CALL_KW 1 1",
+        "0000 OP_GET_LOCAL 1\n"
+        "0002 OP_CONSTANT 0 '1'\n"
+        "0005 OP_CONSTANT 1 '\"x\"'\n"
+        "0008 OP_CONSTANT 2 '2'\n"
+        "0011 OP_TAIL_CALL_KW 1 1",
+    };
+
+    VM* vm = newVM(defaultVMOptions());
+    char* listing = NULL;
+    mu_assert("Disassembly should not fail.",
+              disassemble(vm, src, &listing) == INTERPRET_OK);
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(listing, expected[i]) == NULL) {
+            printf("Missing '%s' in:\n%s\n", expected[i], listing);
+            free(listing);
+            mu_assert("Unexpected keyword arguments.", false);
+        }
+    }
+    free(listing);
+
+    const char* invalid[] = {
+        "(fn f [a] a) (f :b 1)",
+        "(fn f [a] a) (f 1 :a 2)",
+        "(fn f [a (b 1)] a) (f :b 2)",
+        "(fn f [a] a) (f :a 1 :a 2)",
+        "(fn f [a] a) (f :a 1 2)",
+        "(fn f [a] a) (f :a)",
+        "(dict 1 :a)",
+    };
+    for (size_t i = 0; i < sizeof(invalid) / sizeof(invalid[0]); i++) {
+        resetVM(vm);
+        if (interpret(vm, invalid[i], NULL) != INTERPRET_COMPILE_ERROR) {
+            printf("Compiled: %s\n", invalid[i]);
+            mu_assert("Invalid keyword arguments should not compile.", false);
+        }
+    }
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_nesting_limit(void) {
+    // Builds count copies of open, then closes them all with close.
+    char src[8192];
+    struct {
+        const char* open;
+        const char* close;
+        int count;
+        int max_nesting;        // 0 keeps the default
+        size_t stack_capacity;  // 0 keeps the default
+        const char* expected_error;
+    } tests[] = {
+        {"(+ 1 ", ")", DEFAULT_MAX_NESTING - 1, 0, 0, NULL},
+        {"(+ 1 ", ")", DEFAULT_MAX_NESTING, 0, 0,
+         "[line 1] expression at column 1279 nested more than 256 levels"},
+        {"[", "]", 4 * DEFAULT_MAX_NESTING, 0, 0, "nested more than 256"},
+        {"(+ 1 ", ")", 10, 8, 0, "column 39 nested more than 8 levels"},
+        {"(+ 1 ", ")", 500, 1000, 0, NULL},
+        {"(fn [] ", ")", 100, 0, 0, NULL},
+        {"(fn [] ", ")", 8, 0, 16, NULL},
+        {"(fn [] ", ")", 20, 0, 16, "functions nested too deeply"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        size_t len = 0;
+        for (int j = 0; j < tests[i].count; j++) {
+            len += sprintf(src + len, "%s", tests[i].open);
+        }
+        len += sprintf(src + len, "1");
+        for (int j = 0; j < tests[i].count; j++) {
+            len += sprintf(src + len, "%s", tests[i].close);
+        }
+
+        VMOptions options = defaultVMOptions();
+        if (tests[i].max_nesting > 0) {
+            options.max_nesting = tests[i].max_nesting;
+        }
+        if (tests[i].stack_capacity > 0) {
+            options.stack_capacity = tests[i].stack_capacity;
+        }
+        VM* vm = newVM(options);
+        ObjModule* test_module = newModule(vm, "test_module");
+        ObjFunction* function = compile(vm, src, test_module);
+        if (tests[i].expected_error == NULL) {
+            mu_assert("Compiler should not fail.", function != NULL);
+        } else if (function != NULL ||
+                   strstr(vm->error_msg, tests[i].expected_error) == NULL) {
+            printf("Failed test: %d x %s\n  expected '%s', got '%s'\n",
+                   tests[i].count, tests[i].open, tests[i].expected_error,
+                   vm->error_msg);
+            destroyVM(vm);
+            mu_assert("Unexpected nesting error.", false);
+        }
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+// Local slots are one byte wide, so a function has room for 255 lets and
+// parameters on top of the callee slot. Going over is a compile error that
+// names the variable.
+static char* test_local_limits(void) {
+    struct {
+        const char* params;  // NULL for no parameters
+        int lets;
+        const char* expected_error;
+    } tests[] = {
+        {NULL, 255, NULL},
+        {NULL, 256,
+         "Too many local variables in function: 'v255' at column 8 is over "
+         "the limit of 255"},
+        {"a b", 253, NULL},
+        {"a b", 254, "'v253' at column 8 is over the limit of 255"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        char* src = malloc(32 * (size_t)tests[i].lets + 64);
+        size_t len = sprintf(src, "(fn f [%s]\n",
+                             tests[i].params ? tests[i].params : "");
+        for (int j = 0; j < tests[i].lets; j++) {
+            len += sprintf(src + len, "  (let v%d %d)\n", j, j);
+        }
+        sprintf(src + len, "  v0)");
+
+        VM* vm = newVM(defaultVMOptions());
+        ObjModule* test_module = newModule(vm, "test_module");
+        ObjFunction* function = compile(vm, src, test_module);
+        free(src);
+        if (tests[i].expected_error == NULL) {
+            if (function == NULL) printf("Failed test: %s\n", vm->error_msg);
+            mu_assert("Compiler should not fail.", function != NULL);
+        } else if (function != NULL ||
+                   strstr(vm->error_msg, tests[i].expected_error) == NULL) {
+            printf("Failed test: %d lets\n  expected '%s', got '%s'\n",
+                   tests[i].lets, tests[i].expected_error, vm->error_msg);
+            destroyVM(vm);
+            mu_assert("Unexpected local limit error.", false);
+        }
+        destroyVM(vm);
+    }
+
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* test_module = newModule(vm, "test_module");
+    char* src = malloc(8 * 256 + 64);
+    size_t len = sprintf(src, "(fn g [");
+    for (int j = 0; j < 256; j++) len += sprintf(src + len, " p%d", j);
+    sprintf(src + len, "] 1)");
+    mu_assert("256 parameters should not compile",
+              compile(vm, src, test_module) == NULL);
+    mu_assert("Expected the parameter limit",
+              strstr(vm->error_msg,
+                     "Too many function parameters, the limit is 255") !=
+                  NULL);
+    free(src);
+    destroyVM(vm);
+    return NULL;
+}
+
+// Switches with enough int or string literal arms look the subject up in a
+// table; smaller ones keep only the compare chain.
+static size_t countOccurrences(const char* haystack, const char* needle) {
+    size_t count = 0;
+    for (const char* p = strstr(haystack, needle); p != NULL;
+         p = strstr(p + 1, needle)) {
+        count++;
+    }
+    return count;
+}
+
+static char* test_list_literal_chunks(void) {
+    char src[4096];
+    struct {
+        int count;
+        size_t appends;
+    } tests[] = {
+        {0, 0},
+        {LIST_LITERAL_CHUNK, 0},
+        {LIST_LITERAL_CHUNK + 1, 1},
+        {3 * LIST_LITERAL_CHUNK, 2},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        size_t len = sprintf(src, "[");
+        for (int j = 0; j < tests[i].count; j++) {
+            len += sprintf(src + len, "%d ", j);
+        }
+        sprintf(src + len, "]");
+
+        VM* vm = newVM(defaultVMOptions());
+        char* listing = NULL;
+        InterpretResult result = disassemble(vm, src, &listing);
+        mu_assert("Disassembly should not fail.", result == INTERPRET_OK);
+        size_t appends = countOccurrences(listing, "OP_LIST_APPEND");
+        if (appends != tests[i].appends) {
+            printf("Failed test: %d items\n%s\n", tests[i].count, listing);
+        }
+        free(listing);
+        destroyVM(vm);
+        mu_assert("Unexpected number of list chunks.",
+                  appends == tests[i].appends);
+    }
+    return NULL;
+}
+
+static char* test_switch_table(void) {
+    char src[4096];
+    struct {
+        const char* arm;  // formatted with the arm's number
+        int count;
+        bool has_table;
+        const char* expected_error;
+    } tests[] = {
+        {"[%d 0]", SWITCH_TABLE_MIN_ARMS - 1, false, NULL},
+        {"[%d 0]", SWITCH_TABLE_MIN_ARMS, true, NULL},
+        {"[\"s%d\" 0]", SWITCH_TABLE_MIN_ARMS, true, NULL},
+        {"[%d.5 0]", SWITCH_TABLE_MIN_ARMS, false, NULL},
+        {"[%d 0]", MAX_SWITCH_ARMS, true, NULL},
+        {"[%d 0]", MAX_SWITCH_ARMS + 1, false, "switch has more than 64 arms"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        size_t len = sprintf(src, "(switch 1 ");
+        for (int j = 0; j < tests[i].count; j++) {
+            len += sprintf(src + len, tests[i].arm, j);
+        }
+        sprintf(src + len, ")");
+
+        VM* vm = newVM(defaultVMOptions());
+        char* listing = NULL;
+        InterpretResult result = disassemble(vm, src, &listing);
+        if (tests[i].expected_error != NULL) {
+            bool ok = result == INTERPRET_COMPILE_ERROR &&
+                      strstr(vm->error_msg, tests[i].expected_error) != NULL;
+            if (!ok) {
+                printf("Failed test: %d x %s\n  expected '%s', got '%s'\n",
+                       tests[i].count, tests[i].arm, tests[i].expected_error,
+                       vm->error_msg);
+            }
+            destroyVM(vm);
+            mu_assert("Unexpected switch error.", ok);
+            continue;
+        }
+        mu_assert("Disassembly should not fail.", result == INTERPRET_OK);
+        bool has_table = strstr(listing, "OP_SWITCH_TABLE") != NULL;
+        if (has_table != tests[i].has_table) {
+            printf("Failed test: %d x %s\n%s\n", tests[i].count,
+                   tests[i].arm, listing);
+        }
+        free(listing);
+        destroyVM(vm);
+        mu_assert("Unexpected switch table.", has_table == tests[i].has_table);
+    }
+    return NULL;
+}
+
 void compiler_suite(void) {
     printf("--- Compiler Suite ---\n");
     mu_run_test(test_compile);
+    mu_run_test(test_native_call_checks);
+    mu_run_test(test_warnings);
+    mu_run_test(test_pragma);
+    mu_run_test(test_error_recovery);
+    mu_run_test(test_diagnostics);
+    mu_run_test(test_deprecated_natives);
+    mu_run_test(test_disassemble);
+    mu_run_test(test_while_loop);
+    mu_run_test(test_let_bound_fn);
+    mu_run_test(test_for_loop);
+    mu_run_test(test_call_argument_locals);
+    mu_run_test(test_const_inlining);
+    mu_run_test(test_nesting_limit);
+    mu_run_test(test_local_limits);
+    mu_run_test(test_switch_table);
+    mu_run_test(test_list_literal_chunks);
+    mu_run_test(test_param_defaults);
+    mu_run_test(test_keyword_args);
 }
@@ -0,0 +1,278 @@
+#include "eval.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "common.h"
+#include "minunit.h"
+#include "vm.h"
+
+// Runs src on the VM and in the evaluator, each with a VM of its own, and
+// tells whether they agree: on the printed value if both succeed, or that
+// both fail. Prints both sides of a disagreement.
+static bool agree(const char* src) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    InterpretResult result = interpret(vm, src, NULL);
+    char* want = result == INTERPRET_OK ? sprintValue(vm->last_popped_value)
+                                        : NULL;
+    destroyVM(vm);
+
+    vm = newVM(options);
+    Value value;
+    char error[256];
+    EvalResult eval = evalSource(vm, src, &value, error, sizeof(error));
+    char* got = eval == EVAL_OK ? sprintValue(value) : NULL;
+    destroyVM(vm);
+
+    bool ok = eval != EVAL_UNSUPPORTED &&
+              (want == NULL ? got == NULL
+                            : got != NULL && strcmp(want, got) == 0);
+    if (!ok) {
+        printf("Disagree on %s: VM %s, evaluator %s\n", src,
+               want ? want : "<failed>", got ? got : error);
+    }
+    free(want);
+    free(got);
+    return ok;
+}
+
+// The evaluator and the VM agree on the pure expressions, including the
+// forms that fail to compile or raise.
+static char* test_eval_agrees() {
+    const char* sources[] = {
+        "42",
+        "0x1f",
+        "(+ 1 2 3.5)",
+        "(+)",
+        "(*)",
+        "(+ \"a\")",
+        "(- 7)",
+        "(- 7 2)",
+        "(- 7 2 1)",
+        "(-)",
+        "(/ 7 2)",
+        "(/ 7.0 2)",
+        "(/ 1 0)",
+        "(/ 1.0 0)",
+        "(% 7 3)",
+        "(% 7 0)",
+        "(% 7.0 3)",
+        "(+ \"ab\" \"cd\")",
+        "(* \"ab\" 3)",
+        "(* \"ab\" -1)",
+        "(+ \"a\" 1)",
+        "(- \"a\")",
+        "(= 1 1.0)",
+        "(!= \"a\" \"b\")",
+        "(< 1 2)",
+        "(< 1 1.0)",
+        "(<= \"b\" \"a\")",
+        "(gte 2 2)",
+        "(> 3 2 1)",
+        "(band 12 10)",
+        "(bor 12 10)",
+        "(bxor 12 10)",
+        "(bsl 1 4)",
+        "(bsr 256 4)",
+        "(bnot 5)",
+        "(bnot 5.0)",
+        "(not 0)",
+        "(not null)",
+        "(and)",
+        "(and 1 false (/ 1 0))",
+        "(and 1 2)",
+        "(or)",
+        "(or false null)",
+        "(or false 3 (/ 1 0))",
+        "(cond true 1 2)",
+        "(cond false 1)",
+        "(cond null 1 (+ 2 3))",
+        "(~= 1 1.0000000001)",
+        "(~= 1 1.1 0.2)",
+        "(~= 1 \"a\")",
+        "(1 2 3)",
+        "(\"a\" (+ 1 1))",
+        "(let x 5)",
+        "(let x 5) (* x x)",
+        "(let x 5) (let x 6)",
+        "(let x (let y 5))",
+        "(+ (let x 1) x)",
+        "((let x 2) (let y (+ x 1)) (* x y))",
+        "((let x 1) (let x 2) x)",
+        "(let x 1) ((let x 2) x)",
+        "((let x 1) ((let x (+ x 1)) x))",
+        "((let x 1) x) ((let x 3) x)",
+        "(let s \"ab\") (let t (+ s s)) (+ t s)",
+        "(+ 1",
+        "(+ 1))",
+        "\"open",
+    };
+    for (size_t i = 0; i < sizeof(sources) / sizeof(sources[0]); i++) {
+        mu_assert("The evaluator and the VM disagree.", agree(sources[i]));
+    }
+    return NULL;
+}
+
+// Calls, collections and names the source does not bind are left to the VM,
+// and the globals of the VM are not touched.
+static char* test_eval_unsupported() {
+    const char* sources[] = {
+        "(print 1)",
+        "[1 2]",
+        "(+ 1 x)",
+        "((let x 1) x) x",
+        "(fn [] 1)",
+        "((let f 1) (f))",
+        "(let [a b] [1 2])",
+        "()",
+    };
+    VM* vm = newVM(defaultVMOptions());
+    for (size_t i = 0; i < sizeof(sources) / sizeof(sources[0]); i++) {
+        Value value;
+        char error[256];
+        EvalResult result =
+            evalSource(vm, sources[i], &value, error, sizeof(error));
+        if (result != EVAL_UNSUPPORTED) {
+            printf("Evaluated %s: %d %s\n", sources[i], result, error);
+        }
+        mu_assert("Evaluated more than a pure expression.",
+                  result == EVAL_UNSUPPORTED);
+    }
+    Value value;
+    char error[256];
+    mu_assert("Could not evaluate a let.",
+              evalSource(vm, "(let x 1) x", &value, error, sizeof(error)) ==
+                  EVAL_OK);
+    mu_assert("A let of the evaluator defined a global.",
+              interpret(vm, "x", NULL) != INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A generator of pure expressions, seeded so that a failure reproduces.
+typedef struct {
+    unsigned long long state;
+    char* buf;
+    size_t len;
+    size_t cap;
+} Gen;
+
+static unsigned genNext(Gen* gen, unsigned n) {
+    gen->state = gen->state * 6364136223846793005ULL + 1442695040888963407ULL;
+    return (unsigned)(gen->state >> 33) % n;
+}
+
+static void genPut(Gen* gen, const char* s) {
+    size_t n = strlen(s);
+    if (gen->len + n + 1 > gen->cap) {
+        while (gen->len + n + 1 > gen->cap) gen->cap = gen->cap * 2 + 64;
+        gen->buf = realloc(gen->buf, gen->cap);
+    }
+    memcpy(gen->buf + gen->len, s, n + 1);
+    gen->len += n;
+}
+
+typedef enum { GEN_INT, GEN_NUM, GEN_BOOL, GEN_STR } GenType;
+
+static void genExpr(Gen* gen, GenType type, int depth);
+
+// Puts (op a b...) with operands of one type.
+static void genForm(Gen* gen, const char* op, GenType type, int operands,
+                    int depth) {
+    genPut(gen, "(");
+    genPut(gen, op);
+    for (int i = 0; i < operands; i++) {
+        genPut(gen, " ");
+        genExpr(gen, type, depth - 1);
+    }
+    genPut(gen, ")");
+}
+
+// Puts an expression of the type, though now and then an operand of the
+// wrong type, so that the failures are compared too.
+static void genExpr(Gen* gen, GenType type, int depth) {
+    static const char* atoms[][4] = {
+        [GEN_INT] = {"0", "1", "-3", "7"},
+        [GEN_NUM] = {"2.5", "0.0", "-1.5", "3"},
+        [GEN_BOOL] = {"true", "false", "null", "0"},
+        [GEN_STR] = {"\"a\"", "\"bc\"", "\"\"", "\"b\""},
+    };
+    if (genNext(gen, 12) == 0) type = (GenType)genNext(gen, 4);
+    if (depth == 0 || genNext(gen, 4) == 0) {
+        genPut(gen, atoms[type][genNext(gen, 4)]);
+        return;
+    }
+    static const char* int_ops[] = {"+", "-", "*", "/", "%",
+                                    "band", "bor", "bxor"};
+    static const char* compare_ops[] = {"<", "<=", ">", ">=", "=", "!="};
+    switch (type) {
+        case GEN_INT:
+        case GEN_NUM: {
+            int pick = (int)genNext(gen, type == GEN_INT ? 9 : 5);
+            if (pick == 8 || pick == 4) {
+                genPut(gen, "(cond ");
+                genExpr(gen, GEN_BOOL, depth - 1);
+                genPut(gen, " ");
+                genExpr(gen, type, depth - 1);
+                genPut(gen, " ");
+                genExpr(gen, type, depth - 1);
+                genPut(gen, ")");
+            } else if (pick <= 2) {
+                // + takes any count, - one or two. Products of two keep the
+                // ints from overflowing.
+                int operands = pick == 0   ? (int)genNext(gen, 4)
+                               : pick == 1 ? 1 + (int)genNext(gen, 2)
+                                           : (int)genNext(gen, 3);
+                genForm(gen, int_ops[pick], type, operands, depth);
+            } else {
+                genForm(gen, int_ops[pick], type, 2, depth);
+            }
+            break;
+        }
+        case GEN_BOOL: {
+            int pick = (int)genNext(gen, 4);
+            if (pick == 0) {
+                genForm(gen, "not", GEN_BOOL, 1, depth);
+            } else if (pick == 1) {
+                genForm(gen, genNext(gen, 2) ? "and" : "or", GEN_BOOL,
+                        (int)genNext(gen, 4), depth);
+            } else {
+                const char* op = compare_ops[genNext(gen, 6)];
+                genForm(gen, op, pick == 2 ? GEN_INT : GEN_STR, 2, depth);
+            }
+            break;
+        }
+        case GEN_STR:
+            if (genNext(gen, 2)) {
+                genForm(gen, "+", GEN_STR, 2, depth);
+            } else {
+                genPut(gen, "(* ");
+                genExpr(gen, GEN_STR, depth - 1);
+                genPut(gen, genNext(gen, 2) ? " 2)" : " 0)");
+            }
+            break;
+    }
+}
+
+// Expressions made up at random come out the same from the evaluator and
+// the VM, which catches the compiler emitting the wrong code for a form.
+static char* test_eval_differential() {
+    Gen gen = {.state = 20261016};
+    for (int i = 0; i < 500; i++) {
+        gen.len = 0;
+        genExpr(&gen, (GenType)genNext(&gen, 4), 4);
+        mu_assert("The evaluator and the VM disagree.", agree(gen.buf));
+    }
+    free(gen.buf);
+    return NULL;
+}
+
+void eval_suite() {
+    printf("\n--- Eval Suite ---\n");
+    mu_run_test(test_eval_agrees);
+    mu_run_test(test_eval_unsupported);
+    mu_run_test(test_eval_differential);
+}
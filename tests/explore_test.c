@@ -0,0 +1,57 @@
+#include "explore.h"
+
+#include <string.h>
+
+#include "common.h"
+#include "minunit.h"
+#include "vm.h"
+
+// Lists a script with a nested function and checks every instruction row is
+// anchored to the source line it came from.
+static char* test_explore_anchors() {
+    const char* src =
+        "(let x 1)\n"
+        "(fn add [a b]\n"
+        "    (+ a b))\n"
+        "(add x 2)\n";
+    VM* vm = newVM(defaultVMOptions());
+    ObjFunction* function = compileMain(vm, src);
+    mu_assert("Script did not compile.", function != NULL);
+
+    ExploreListing listing = {0};
+    listFunction(function, &listing);
+    struct {
+        const char* text;
+        int line;
+    } expected[] = {
+        {"== <script> ==", 0},
+        {"0000 OP_CONSTANT 0 '1'", 1},
+        {"0003 OP_SET_GLOBAL 1 'x'", 1},
+        {"0014 OP_GET_GLOBAL 3 'add'", 4},
+        {"== (add a b) ==", 0},
+        {"0000 OP_GET_LOCAL 1", 3},
+    };
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        int found = -1;
+        for (int j = 0; j < listing.count && found < 0; j++) {
+            if (strcmp(listing.rows[j].text, expected[i].text) == 0) found = j;
+        }
+        if (found < 0 || listing.rows[found].line != expected[i].line) {
+            printf("Row '%s': expected line %d, got %d\n", expected[i].text,
+                   expected[i].line,
+                   found < 0 ? -1 : listing.rows[found].line);
+            mu_assert("Unexpected source anchor.", false);
+        }
+    }
+    mu_assert("Nested rows should show the nested constants.",
+              listing.rows[listing.count - 1].function != function);
+
+    freeListing(&listing);
+    destroyVM(vm);
+    return NULL;
+}
+
+void explore_suite() {
+    printf("\n--- Explore Suite ---\n");
+    mu_run_test(test_explore_anchors);
+}
@@ -0,0 +1,74 @@
+#include "fmt.h"
+
+#include <stdlib.h>
+#include <string.h>
+
+#include "common.h"
+#include "minunit.h"
+
+typedef struct {
+    const char* src;
+    const char* expected;
+} FmtTest;
+
+// Formats each source, checks the layout and that formatting it again
+// changes nothing.
+static char* test_fmt_layout(void) {
+    FmtTest tests[] = {
+        {"", ""},
+        {"(add  1\t2)", "(add 1 2)\n"},
+        {"( f [ a  b ] )", "(f [a b])\n"},
+        {"\n\n(a)\n\n\n\n(b)\n", "(a)\n\n(b)\n"},
+        {"(fn f [x]\n(g x)\n  )", "(fn f [x]\n    (g x))\n"},
+        {"(a\n(b\n(c)))", "(a\n    (b\n        (c)))\n"},
+        {"(a ; why\n)", "(a ; why\n)\n"},
+        {"; top\n(a)   ; tail  ", "; top\n(a) ; tail\n"},
+        {"(a #| x\n  y |# b)", "(a #| x\n  y |# b)\n"},
+        {"(f \"a  (b\\\" c\")", "(f \"a  (b\\\" c\")\n"},
+        {"(.users[0].name  d)", "(.users[0].name d)\n"},
+        {"((1 . 2))", "((1 . 2))\n"},
+        {"(f  #[1  2] #[ ])", "(f #[1 2] #[])\n"},
+        {"(let t #[\n1\n#[2]])", "(let t #[\n        1\n        #[2]])\n"},
+        {"#!/usr/bin/env liss  \n(a  b)", "#!/usr/bin/env liss\n(a b)\n"},
+        {"#!/usr/bin/env liss\n\n\n(a)", "#!/usr/bin/env liss\n\n(a)\n"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        const char* err_msg = NULL;
+        char* got = formatSource(tests[i].src, &err_msg);
+        if (got == NULL || strcmp(got, tests[i].expected) != 0) {
+            printf("Failed test: %s\n  expected: %s\n  got: %s\n",
+                   tests[i].src, tests[i].expected, got ? got : err_msg);
+            mu_assert("Unexpected formatting.", false);
+        }
+        char* again = formatSource(got, &err_msg);
+        mu_assert("Formatting is not idempotent.",
+                  again != NULL && strcmp(again, got) == 0);
+        free(again);
+        free(got);
+    }
+    return NULL;
+}
+
+static char* test_fmt_errors(void) {
+    const char* srcs[] = {
+        "(a", "a)", "(a]", "\"open", "#| open", "#[1)",
+    };
+    for (size_t i = 0; i < sizeof(srcs) / sizeof(srcs[0]); i++) {
+        const char* err_msg = NULL;
+        char* got = formatSource(srcs[i], &err_msg);
+        if (got != NULL) {
+            printf("Failed test: '%s' formatted\n", srcs[i]);
+            free(got);
+            mu_assert("Expected a formatting error.", false);
+        }
+        mu_assert("Missing error message.", err_msg != NULL);
+    }
+    return NULL;
+}
+
+void fmt_suite(void) {
+    printf("\n--- Fmt Suite ---\n");
+    mu_run_test(test_fmt_layout);
+    mu_run_test(test_fmt_errors);
+}
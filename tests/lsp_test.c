@@ -0,0 +1,129 @@
+#define _POSIX_C_SOURCE 200809L
+#include "lsp.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "common.h"
+#include "minunit.h"
+#include "vm.h"
+
+// Appends body to the session as a message with its header.
+static void addMessage(char* session, size_t size, const char* body) {
+    size_t len = strlen(session);
+    snprintf(session + len, size - len, "Content-Length: %zu\r\n\r\n%s",
+             strlen(body), body);
+}
+
+// Runs the server over the messages, which end with a NULL, and returns what
+// it wrote. The caller frees it.
+static char* runSession(const char** messages, int* status) {
+    char session[4096] = "";
+    for (int i = 0; messages[i] != NULL; i++) {
+        addMessage(session, sizeof(session), messages[i]);
+    }
+    FILE* in = fmemopen(session, strlen(session), "r");
+    char* output = NULL;
+    size_t len = 0;
+    FILE* out = open_memstream(&output, &len);
+    *status = runLsp(in, out, defaultVMOptions());
+    fclose(in);
+    fclose(out);
+    return output;
+}
+
+// A document is checked when opened and saved, and hover and document
+// symbols answer from its latest text.
+static char* test_lsp_session() {
+    const char* messages[] = {
+        "{\"jsonrpc\": \"2.0\", \"id\": 1, \"method\": \"initialize\", "
+        "\"params\": {}}",
+        "{\"jsonrpc\": \"2.0\", \"method\": \"textDocument/didOpen\", "
+        "\"params\": {\"textDocument\": {\"uri\": \"file:///a.liss\", "
+        "\"text\": \"(let x 1)\\n(- )\\n(len \\\"\\u00e9\\\" x)\"}}}",
+        "{\"jsonrpc\": \"2.0\", \"id\": 2, \"method\": "
+        "\"textDocument/hover\", \"params\": {\"textDocument\": {\"uri\": "
+        "\"file:///a.liss\"}, \"position\": {\"line\": 2, \"character\": 2}}}",
+        "{\"jsonrpc\": \"2.0\", \"id\": 3, \"method\": "
+        "\"textDocument/hover\", \"params\": {\"textDocument\": {\"uri\": "
+        "\"file:///a.liss\"}, \"position\": {\"line\": 2, \"character\": 9}}}",
+        "{\"jsonrpc\": \"2.0\", \"method\": \"textDocument/didOpen\", "
+        "\"params\": {\"textDocument\": {\"uri\": \"file:///b.liss\", "
+        "\"text\": \"(sq 2)\\n(fn sq [n] \\\"Squares n.\\\" (* n n))\"}}}",
+        "{\"jsonrpc\": \"2.0\", \"id\": 6, \"method\": "
+        "\"textDocument/hover\", \"params\": {\"textDocument\": {\"uri\": "
+        "\"file:///b.liss\"}, \"position\": {\"line\": 0, \"character\": 1}}}",
+        "{\"jsonrpc\": \"2.0\", \"id\": \"syms\", \"method\": "
+        "\"textDocument/documentSymbol\", \"params\": {\"textDocument\": "
+        "{\"uri\": \"file:///a.liss\"}}}",
+        "{\"jsonrpc\": \"2.0\", \"method\": \"textDocument/didChange\", "
+        "\"params\": {\"textDocument\": {\"uri\": \"file:///a.liss\"}, "
+        "\"contentChanges\": [{\"text\": \"(let y 2)\"}]}}",
+        "{\"jsonrpc\": \"2.0\", \"method\": \"textDocument/didSave\", "
+        "\"params\": {\"textDocument\": {\"uri\": \"file:///a.liss\"}}}",
+        "{\"jsonrpc\": \"2.0\", \"id\": 4, \"method\": \"unknown\"}",
+        "{\"jsonrpc\": \"2.0\", \"id\": 5, \"method\": \"shutdown\"}",
+        "{\"jsonrpc\": \"2.0\", \"method\": \"exit\"}",
+        NULL,
+    };
+    int status;
+    char* output = runSession(messages, &status);
+    const char* expected[] = {
+        "\"id\": 1, \"result\": {\"capabilities\": ",
+        "\"diagnostics\": [{\"range\": {\"start\": {\"line\": 1, "
+        "\"character\": 3}, \"end\": {\"line\": 1, \"character\": 4}}, "
+        "\"severity\": 1, \"source\": \"liss\", \"message\": \"`-` expects 1 "
+        "or 2 operands, got 0\"}",
+        "\"id\": 2, \"result\": {\"contents\": {\"kind\": \"markdown\", "
+        "\"value\": \"```lisp\\n(len coll)\\n```\\n",
+        // The é before x is one character.
+        "\"id\": 3, \"result\": {\"contents\": {\"kind\": \"markdown\", "
+        "\"value\": \"global `x`, defined on line 1\"}, \"range\": "
+        "{\"start\": {\"line\": 2, \"character\": 9}",
+        // A function defined after the call shows its usage and docstring.
+        "\"id\": 6, \"result\": {\"contents\": {\"kind\": \"markdown\", "
+        "\"value\": \"```lisp\\n(sq n)\\n```\\nSquares n.\\n\\nfunction `sq`, "
+        "defined on line 2\"}",
+        "\"id\": \"syms\", \"result\": [{\"name\": \"x\", \"kind\": 13, ",
+        "\"diagnostics\": []",
+        "\"id\": 4, \"error\": {\"code\": -32601",
+        "\"id\": 5, \"result\": null",
+    };
+    bool ok = status == 0;
+    for (size_t i = 0; i < sizeof(expected) / sizeof(expected[0]); i++) {
+        if (strstr(output, expected[i]) == NULL) {
+            printf("Missing: %s\n", expected[i]);
+            ok = false;
+        }
+    }
+    if (!ok) printf("Got:\n%s\n", output);
+    free(output);
+    mu_assert("Unexpected LSP session.", ok);
+    return NULL;
+}
+
+// Without a shutdown first, exit fails, and a message that is not JSON gets
+// a parse error.
+static char* test_lsp_errors() {
+    const char* messages[] = {
+        "{\"id\": 1, \"method\": ",
+        "{\"jsonrpc\": \"2.0\", \"method\": \"exit\"}",
+        NULL,
+    };
+    int status;
+    char* output = runSession(messages, &status);
+    bool ok = status == 1 &&
+              strstr(output, "\"id\": null, \"error\": {\"code\": -32700") !=
+                  NULL;
+    if (!ok) printf("Got:\n%s\n", output);
+    free(output);
+    mu_assert("Unexpected LSP errors.", ok);
+    return NULL;
+}
+
+void lsp_suite() {
+    printf("\n--- LSP Suite ---\n");
+    mu_run_test(test_lsp_session);
+    mu_run_test(test_lsp_errors);
+}
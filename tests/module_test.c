@@ -1,6 +1,7 @@
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <sys/stat.h>
 #include <unistd.h>
 
 #include "common.h"
@@ -49,13 +50,37 @@ static ModuleTestCase module_tests[] = {
         .module =
             {
                 .name = "test_module",
-                .src = "(let const 42)",
+                .src = "(const answer 42)",
             },
         .src = "(import test_module)\
-                test_module:const",
+                test_module:answer",
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_INT, .as.integer = 42},
     },
+    {
+        .name = "an imported global follows a set! in its module",
+        .module =
+            {
+                .name = "test_module",
+                .src = "(let n 0) (fn bump [] (set! n (+ n 1)))",
+            },
+        .src = "(import test_module [\"n\" \"bump\"])\
+                (bump) (test_module:bump)\
+                [n test_module:n]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[2 2]"},
+    },
+    {
+        .name = "an imported global is read-only in the importer",
+        .module =
+            {
+                .name = "test_module",
+                .src = "(let n 0)",
+            },
+        .src = "(import test_module [\"n\"])\
+                (set! n 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
 };
 
 static char* test_modules(void) {
@@ -125,9 +150,261 @@ static char* test_modules(void) {
     return NULL;
 }
 
+static char* test_module_reload(void) {
+    VM* vm = newVM(defaultVMOptions());
+    write_test_module("test_module",
+                      "(let n 0) (fn bump [] (set! n (+ n 1))) (const k 1)");
+    InterpretResult result = interpret(
+        vm,
+        "(import test_module [\"n\" \"bump\" \"k\"])"
+        "(fn read [] [n k test_module:n test_module:k])"
+        "(bump)",
+        NULL);
+    mu_assert("Interpretation failed", result == INTERPRET_OK);
+
+    write_test_module("test_module",
+                      "(let n 10) (fn bump [] (set! n (* n 2))) (const k 2)");
+    result = interpret(vm, "(module_reload \"test_module\") (bump) (read)",
+                       NULL);
+    mu_assert("Reload failed", result == INTERPRET_OK);
+    mu_assert("Reload updates the shared globals",
+              assert_list(vm->last_popped_value, "[20 2 20 2]") == NULL);
+
+    write_test_module("test_module", "(let n ");
+    result = interpret(vm, "(module_reload \"test_module\")", NULL);
+    mu_assert("A broken module fails to reload",
+              result == INTERPRET_RUNTIME_ERROR);
+    result = interpret(vm, "(read)", NULL);
+    mu_assert("Globals survive a failed reload",
+              result == INTERPRET_OK &&
+                  assert_list(vm->last_popped_value, "[20 2 20 2]") == NULL);
+
+    write_test_module("test_module",
+                      "(let n 99) (const k 3) (raise! (err \"half way\"))");
+    result = interpret(vm, "(module_reload \"test_module\")", NULL);
+    mu_assert("A failing module fails to reload",
+              result == INTERPRET_RUNTIME_ERROR);
+    result = interpret(vm, "[(read) (test_module:bump) test_module:k]", NULL);
+    mu_assert("Globals and consts are put back after a failed run",
+              result == INTERPRET_OK &&
+                  assert_list(vm->last_popped_value, "[[20 2 20 2] 40 2]") ==
+                      NULL);
+
+    result = interpret(vm, "(module_reload \"list\")", NULL);
+    mu_assert("Native modules are not reloaded",
+              result == INTERPRET_RUNTIME_ERROR);
+
+    clean_test_module("test_module");
+    destroyVM(vm);
+    return NULL;
+}
+
+typedef struct {
+    ScopeKind kinds[4];
+    int cnt;
+} ScopeTrace;
+
+static bool traceScope(Table* scope, ScopeKind kind, void* ctx) {
+    (void)scope;
+    ScopeTrace* trace = ctx;
+    trace->kinds[trace->cnt++] = kind;
+    return kind != SCOPE_IMPORTS;
+}
+
+static Value* lookup(VM* vm, const char* name) {
+    Value key = OBJ_VAL(copyString(vm, name, (int)strlen(name)));
+    return resolveGlobal(vm, vm->main_module, key);
+}
+
+static char* test_module_path(void) {
+    mkdir("test_path_dir", 0755);
+    write_test_module("test_path_dir/path_module", "(const answer 42)");
+    VMOptions options = defaultVMOptions();
+    options.module_path = "no_such_dir:test_path_dir";
+    VM* vm = newVM(options);
+    InterpretResult result =
+        interpret(vm, "(import path_module) path_module:answer", NULL);
+    mu_assert("Module is found along the path",
+              result == INTERPRET_OK &&
+                  assert_int(vm->last_popped_value, 42) == NULL);
+
+    result = interpret(vm, "(import missing_module)", NULL);
+    mu_assert("A missing module fails to compile",
+              result == INTERPRET_COMPILE_ERROR);
+    mu_assert("The error lists the files it looked for",
+              vm->diagnostic_cnt > 0 &&
+                  strstr(vm->diagnostics[0].message,
+                         "no_such_dir/missing_module.liss, "
+                         "test_path_dir/missing_module.liss") != NULL);
+    destroyVM(vm);
+    clean_test_module("test_path_dir/path_module");
+    rmdir("test_path_dir");
+    return NULL;
+}
+
+static char* test_embedded_module(void) {
+    VMOptions options = defaultVMOptions();
+    options.module_path = "no_such_dir";
+    VM* vm = newVM(options);
+    InterpretResult result =
+        interpret(vm,
+                  "(import seq [\"filter\" \"sum\"])"
+                  "(sum (filter (fn [x] (> x 1)) [1 2 3]))",
+                  NULL);
+    mu_assert("The bundled seq loads without its file",
+              result == INTERPRET_OK &&
+                  assert_int(vm->last_popped_value, 5) == NULL);
+    destroyVM(vm);
+
+    // A file on the path shadows the bundled module.
+    write_test_module("seq", "(fn sum [xs] 42)");
+    vm = newVM(defaultVMOptions());
+    result = interpret(vm, "(import seq) (seq:sum [1 2])", NULL);
+    mu_assert("A seq.liss on the path wins",
+              result == INTERPRET_OK &&
+                  assert_int(vm->last_popped_value, 42) == NULL);
+    destroyVM(vm);
+    clean_test_module("seq");
+    return NULL;
+}
+
+static char* test_nested_modules(void) {
+    mkdir("test_pkg_a", 0755);
+    mkdir("test_pkg_b", 0755);
+    mkdir("test_pkg_app", 0755);
+    write_test_module("test_pkg_a/helpers", "(const who \"a\")");
+    write_test_module("test_pkg_b/helpers", "(const who \"b\")");
+    VM* vm = newVM(defaultVMOptions());
+    InterpretResult result = interpret(
+        vm,
+        "(import \"test_pkg_a/helpers\")"
+        "(import \"./test_pkg_b/helpers\" as bh)"
+        "(import \"test_pkg_b/../test_pkg_a/helpers\" [\"who\"])"
+        "[helpers:who bh:who who]",
+        NULL);
+    mu_assert("Modules in subdirectories are told apart by their path",
+              result == INTERPRET_OK &&
+                  assert_list(vm->last_popped_value, "[\"a\" \"b\" \"a\"]") ==
+                      NULL);
+    mu_assert("A cleaned name loads the module once",
+              tableGet(&vm->modules,
+                       OBJ_VAL(copyString(vm, "test_pkg_b/helpers", 18))) !=
+                  NULL);
+    resetVM(vm);
+    result = interpret(vm,
+                       "(import \"test_pkg_a/helpers\")"
+                       "(import \"test_pkg_b/helpers\")",
+                       NULL);
+    mu_assert("Two modules cannot both go by helpers",
+              result == INTERPRET_COMPILE_ERROR &&
+                  strstr(vm->diagnostics[0].message, "`as`") != NULL);
+    destroyVM(vm);
+
+    VMOptions options = defaultVMOptions();
+    options.module_path = "test_pkg_app";
+    vm = newVM(options);
+    result = interpret(vm, "(import \"../test_pkg_a/helpers\")", NULL);
+    mu_assert("'..' out of the search path is refused without a root",
+              result == INTERPRET_COMPILE_ERROR &&
+                  strstr(vm->diagnostics[0].message,
+                         "outside the search path") != NULL);
+    destroyVM(vm);
+
+    options.project_root = ".";
+    vm = newVM(options);
+    result = interpret(
+        vm, "(import \"../test_pkg_a/helpers\") helpers:who", NULL);
+    mu_assert("'..' inside the project root is allowed",
+              result == INTERPRET_OK &&
+                  assert_string(vm->last_popped_value, "a") == NULL);
+    destroyVM(vm);
+
+    options.project_root = "test_pkg_app";
+    vm = newVM(options);
+    result = interpret(vm, "(import \"../test_pkg_a/helpers\")", NULL);
+    mu_assert("'..' out of the project root is refused",
+              result == INTERPRET_COMPILE_ERROR &&
+                  strstr(vm->diagnostics[0].message,
+                         "outside the project root") != NULL);
+    destroyVM(vm);
+
+    clean_test_module("test_pkg_a/helpers");
+    clean_test_module("test_pkg_b/helpers");
+    rmdir("test_pkg_a");
+    rmdir("test_pkg_b");
+    rmdir("test_pkg_app");
+    return NULL;
+}
+
+static char* test_plugin_module(void) {
+    // make test builds bin/test_plugin.so from tests/plugin.
+    VMOptions options = defaultVMOptions();
+    options.module_path = "bin";
+    VM* vm = newVM(options);
+    InterpretResult result = interpret(vm, "(import test_plugin)", NULL);
+    mu_assert("Plugins are off by default",
+              result == INTERPRET_COMPILE_ERROR);
+    destroyVM(vm);
+
+    options.plugins = true;
+    vm = newVM(options);
+    result = interpret(
+        vm, "(import test_plugin [\"add\"]) [(add 2 3) (test_plugin:add 1 1)]",
+        NULL);
+    mu_assert("A plugin defines the natives of its module",
+              result == INTERPRET_OK &&
+                  assert_list(vm->last_popped_value, "[5 2]") == NULL);
+    result = interpret(vm, "(test_plugin:add 1 \"x\")", NULL);
+    mu_assert("Plugin natives get their arguments checked",
+              result == INTERPRET_COMPILE_ERROR);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_scope_chain(void) {
+    VM* vm = newVM(defaultVMOptions());
+    InterpretResult result = interpret(
+        vm, "(import str [upper]) (let len_sq 4) (let len 2)", NULL);
+    mu_assert("Interpretation failed", result == INTERPRET_OK);
+
+    Value* v = lookup(vm, "len_sq");
+    mu_assert("module symbol resolves", v != NULL && AS_INT(*v) == 4);
+    v = lookup(vm, "len");
+    mu_assert("module symbol shadows core", v != NULL && IS_INT(*v));
+    v = lookup(vm, "upper");
+    mu_assert("imported symbol resolves", v != NULL && IS_NATIVE(*v));
+    v = lookup(vm, "keys");
+    mu_assert("core symbol resolves", v != NULL && IS_NATIVE(*v));
+    mu_assert("unknown symbol is NULL", lookup(vm, "nope") == NULL);
+
+    ScopeTrace trace = {.cnt = 0};
+    walkScopes(vm, vm->main_module, traceScope, &trace);
+    mu_assert("walk stops when the visitor returns false", trace.cnt == 2);
+    mu_assert("module scope first", trace.kinds[0] == SCOPE_MODULE);
+    mu_assert("imports second", trace.kinds[1] == SCOPE_IMPORTS);
+
+    size_t cnt;
+    ObjString** names =
+        tableFindByPrefix(&vm->main_module->symbols, "len", false, &cnt);
+    mu_assert("prefix search finds both", cnt == 2);
+    mu_assert("prefix results are sorted",
+              strcmp(names[0]->chars, "len") == 0 &&
+                  strcmp(names[1]->chars, "len_sq") == 0);
+    free(names);
+
+    destroyVM(vm);
+    return NULL;
+}
+
 // --- Suite ---
 
 void module_suite() {
     printf("\n--- Module Suite ---\n");
     mu_run_test(test_modules);
+    mu_run_test(test_module_reload);
+    mu_run_test(test_module_path);
+    mu_run_test(test_embedded_module);
+    mu_run_test(test_nested_modules);
+    mu_run_test(test_plugin_module);
+    mu_run_test(test_scope_chain);
 }
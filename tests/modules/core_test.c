@@ -44,6 +44,18 @@ static char *test_core_containers(void) {
        .src = "(dict (\"a\" . 1) (\"b\" . 2))",
        .expected_str = "(dict (\"a\" . 1) (\"b\" . 2))",
        .expected_type = EXPECT_DICT},
+      {.name = "dict prints keys sorted regardless of insertion order",
+       .src = "(dict (\"c\" . 3) (\"a\" . 1) (\"b\" . 2))",
+       .expected_str = "(dict (\"a\" . 1) (\"b\" . 2) (\"c\" . 3))",
+       .expected_type = EXPECT_DICT},
+      {.name = "dict prints mixed keys grouped by type",
+       .src = "(dict (\"x\" . 1) (2.5 . 2) (10 . 3) (9 . 4))",
+       .expected_str = "(dict (9 . 4) (10 . 3) (2.5 . 2) (\"x\" . 1))",
+       .expected_type = EXPECT_DICT},
+      {.name = "dict orders container keys by their text",
+       .src = "(dict ([2] . 0) ([1 2] . 0) ((1 . 2) . 0) ([1] . 0))",
+       .expected_str = "(dict ((1 . 2) . 0) ([1 2] . 0) ([1] . 0) ([2] . 0))",
+       .expected_type = EXPECT_DICT},
       {.name = "get list",
        .src = "(get [10 20] 1)",
        .expected_str = "20",
@@ -56,6 +68,50 @@ static char *test_core_containers(void) {
        .src = "(get \"abc\" 0)",
        .expected_str = "\"a\"",
        .expected_type = EXPECT_STRING},
+      {.name = "get list from the end",
+       .src = "(get [10 20 30] -1)",
+       .expected_str = "30",
+       .expected_type = EXPECT_INT},
+      {.name = "get list first from the end",
+       .src = "(get [10 20 30] -3)",
+       .expected_str = "10",
+       .expected_type = EXPECT_INT},
+      {.name = "get string from the end",
+       .src = "(get \"abc\" -2)",
+       .expected_str = "\"b\"",
+       .expected_type = EXPECT_STRING},
+      {.name = "range of a list",
+       .src = "(range [10 20 30 40] 1 2)",
+       .expected_str = "[20 30]",
+       .expected_type = EXPECT_LIST},
+      {.name = "range of a list to the end",
+       .src = "(range [10 20 30 40] 2 -1)",
+       .expected_str = "[30 40]",
+       .expected_type = EXPECT_LIST},
+      {.name = "range of a list from the end",
+       .src = "(range [10 20 30 40] -3 -2)",
+       .expected_str = "[20 30]",
+       .expected_type = EXPECT_LIST},
+      {.name = "range of a list with end before start",
+       .src = "(range [10 20 30] 2 1)",
+       .expected_str = "[]",
+       .expected_type = EXPECT_LIST},
+      {.name = "range of an empty list",
+       .src = "(range [] 0 -1)",
+       .expected_str = "[]",
+       .expected_type = EXPECT_LIST},
+      {.name = "range of a string",
+       .src = "(range \"hello\" 1 3)",
+       .expected_str = "\"ell\"",
+       .expected_type = EXPECT_STRING},
+      {.name = "range of a string to the end",
+       .src = "(range \"hello\" 2 -1)",
+       .expected_str = "\"llo\"",
+       .expected_type = EXPECT_STRING},
+      {.name = "range of a string past its end",
+       .src = "(range \"hello\" 5 -1)",
+       .expected_str = "\"\"",
+       .expected_type = EXPECT_STRING},
       {.name = "dict put",
        .src = "(let d (put (dict) \"k\" 7)) (get d \"k\")",
        .expected_str = "7",
@@ -85,6 +141,128 @@ static char *test_core_containers(void) {
            "(let d (dict (1 . \"a\") (2 . \"b\"))) (let v (values d)) (len v)",
        .expected_str = "2",
        .expected_type = EXPECT_INT},
+      {.name = "dict keys in insertion order",
+       .src = "(keys (dict (\"c\" . 1) (7 . 2) (\"a\" . 3) (-1 . 4) (2.5 . 5)"
+              " (\"b\" . 6) (40 . 7) (3 . 8)))",
+       .expected_str = "[\"c\" 7 \"a\" -1 2.5 \"b\" 40 3]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict values in insertion order",
+       .src = "(values (put (put (dict (\"z\" . 1) (\"y\" . 2)) \"x\" 3)"
+              " \"w\" 4))",
+       .expected_str = "[1 2 3 4]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict entries",
+       .src = "(entries (dict (\"b\" . 1) (\"a\" . 2)))",
+       .expected_str = "[[\"b\" 1] [\"a\" 2]]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict entries of empty dict",
+       .src = "(entries (dict))",
+       .expected_str = "[]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict put keeps the position of an existing key",
+       .src = "(keys (put (dict (1 . 1) (2 . 2) (3 . 3)) 1 10))",
+       .expected_str = "[1 2 3]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict key added again after del goes last",
+       .src = "(keys (put (del (dict (1 . 1) (2 . 2) (3 . 3)) 1) 1 1))",
+       .expected_str = "[2 3 1]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict keeps insertion order across subtries",
+       .src = "(fn fill [d i] (cond (= i 0) d (fill (put d (* i 7919) i)"
+              " (- i 1))))"
+              "(import list)"
+              "(fn down [xs i] (cond (> i 500) xs (down (list:cons xs i)"
+              " (+ i 1))))"
+              "(= (str (values (fill (dict) 500))) (str (down [] 1)))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
+      {.name = "dict versions keep their own order",
+       .src = "(let d (dict (1 . 1) (2 . 2))) (put d 3 3) (keys (put d 0 0))",
+       .expected_str = "[1 2 0]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict list key is found by value",
+       .src = "(get (put (dict) [1 2] \"v\") [1 2])",
+       .expected_str = "\"v\"",
+       .expected_type = EXPECT_STRING},
+      {.name = "dict list key survives mutating the original",
+       .src = "(import list) (let xs [1 2]) (let d (put (dict) xs 1))"
+              " (list:push! xs 3) (get d [1 2])",
+       .expected_str = "1",
+       .expected_type = EXPECT_INT},
+      {.name = "dict nested list and pair keys",
+       .src = "(let d (dict ([1 [2 3]] . \"a\") ((1 . [2]) . \"b\")))"
+              " [(get d [1 [2 3]]) (get d (1 . [2]))]",
+       .expected_str = "[\"a\" \"b\"]",
+       .expected_type = EXPECT_LIST},
+      {.name = "dict list keys compare element types",
+       .src = "(has? (dict ([1 2] . 0)) [1 2.0])",
+       .expected_str = "false",
+       .expected_type = EXPECT_BOOL},
+      {.name = "dict del with a list key",
+       .src = "(len (del (dict ([1] . 1) ([2] . 2)) [1]))",
+       .expected_str = "1",
+       .expected_type = EXPECT_INT},
+      {.name = "tuple literal",
+       .src = "#[1 \"a\" [2] #[]]",
+       .expected_str = "#[1 \"a\" [2] #[]]",
+       .expected_type = EXPECT_TUPLE},
+      {.name = "tuple builtin",
+       .src = "(tuple 1 (+ 1 1))",
+       .expected_str = "#[1 2]",
+       .expected_type = EXPECT_TUPLE},
+      {.name = "tuple len and get",
+       .src = "[(len #[1 2 3]) (get #[1 2 3] 0) (get #[1 2 3] -1)"
+              " (is_empty? (tuple))]",
+       .expected_str = "[3 1 3 true]",
+       .expected_type = EXPECT_LIST},
+      {.name = "tuples compare by value",
+       .src = "(= #[1 #[\"a\"]] (tuple 1 (tuple \"a\")))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
+      {.name = "a tuple is not a list",
+       .src = "(= #[1] [1])",
+       .expected_str = "false",
+       .expected_type = EXPECT_BOOL},
+      {.name = "tuple as a dict key",
+       .src = "(let d (dict (#[1 [2]] . \"x\")))"
+              " [(get d (tuple 1 [2])) (get d [1 [2]])]",
+       .expected_str = "[\"x\" null]",
+       .expected_type = EXPECT_LIST},
+      {.name = "to_tuple of a list",
+       .src = "(to_tuple [1 2])",
+       .expected_str = "#[1 2]",
+       .expected_type = EXPECT_TUPLE},
+      {.name = "to_list of a tuple",
+       .src = "(to_list #[1 2])",
+       .expected_str = "[1 2]",
+       .expected_type = EXPECT_LIST},
+      {.name = "a list from to_list does not change the tuple",
+       .src = "(import list [push!]) (let t #[1]) (push! (to_list t) 2) t",
+       .expected_str = "#[1]",
+       .expected_type = EXPECT_TUPLE},
+      {.name = "bytes from a string and a list",
+       .src = "[(bytes \"hi\") (bytes [0 255]) (bytes [])]",
+       .expected_str =
+           "[(from_hex \"6869\") (from_hex \"00ff\") (from_hex \"\")]",
+       .expected_type = EXPECT_LIST},
+      {.name = "len and byte_at of bytes",
+       .src = "(let b (from_hex \"0a80ff\"))"
+              " [(len b) (byte_at b 1) (byte_at b -1) (is_empty? b)]",
+       .expected_str = "[3 128 255 false]",
+       .expected_type = EXPECT_LIST},
+      {.name = "to_str of bytes",
+       .src = "(to_str (from_hex \"6869\"))",
+       .expected_str = "\"hi\"",
+       .expected_type = EXPECT_STRING},
+      {.name = "bytes compare by value",
+       .src = "[(= (bytes \"ab\") (from_hex \"6162\"))"
+              " (= (bytes \"ab\") \"ab\")]",
+       .expected_str = "[true false]",
+       .expected_type = EXPECT_LIST},
+      {.name = "bytes as a dict key",
+       .src = "(let d (dict ((bytes \"k\") . 1))) [(get d (from_hex \"6b\"))]",
+       .expected_str = "[1]",
+       .expected_type = EXPECT_LIST},
   };
 
   for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
@@ -116,6 +294,12 @@ static char *test_core_containers(void) {
     case EXPECT_DICT:
       assert_msg = assert_dict(val, tests[i].expected_str);
       break;
+    case EXPECT_LIST:
+      assert_msg = assert_list(val, tests[i].expected_str);
+      break;
+    case EXPECT_TUPLE:
+      assert_msg = assert_tuple(val, tests[i].expected_str);
+      break;
     case EXPECT_INT:
       assert_msg = assert_int(val, atoll(tests[i].expected_str));
       break;
@@ -170,6 +354,87 @@ static char *test_core_conversions(void) {
        .src = "(to_real 7)",
        .expected_str = "7",
        .expected_type = EXPECT_REAL},
+      {.name = "to_hex of an int",
+       .src = "(to_hex 255)",
+       .expected_str = "ff",
+       .expected_type = EXPECT_STRING},
+      {.name = "to_hex of a negative int",
+       .src = "(to_hex -4096)",
+       .expected_str = "-1000",
+       .expected_type = EXPECT_STRING},
+      {.name = "to_bin of an int",
+       .src = "(to_bin 10)",
+       .expected_str = "1010",
+       .expected_type = EXPECT_STRING},
+      {.name = "to_bin of zero",
+       .src = "(to_bin 0)",
+       .expected_str = "0",
+       .expected_type = EXPECT_STRING},
+      {.name = "fmt_float rounds to the digits",
+       .src = "(fmt_float 3.14159 2)",
+       .expected_str = "3.14",
+       .expected_type = EXPECT_STRING},
+      {.name = "fmt_float of an int",
+       .src = "(fmt_float 2 3)",
+       .expected_str = "2.000",
+       .expected_type = EXPECT_STRING},
+      {.name = "fmt_float with no digits",
+       .src = "(fmt_float 2.5 0)",
+       .expected_str = "2",
+       .expected_type = EXPECT_STRING},
+      {.name = "repr of a list",
+       .src = "(repr [1 2.0 \"a\"])",
+       .expected_str = "[1 2.0 \"a\"]",
+       .expected_type = EXPECT_STRING},
+      {.name = "format fills directives in order",
+       .src = "(format \"x=%d y=%.2f name=%s\" 1 2.5 \"bob\")",
+       .expected_str = "x=1 y=2.50 name=bob",
+       .expected_type = EXPECT_STRING},
+      {.name = "format %v prints any value and %% a percent",
+       .src = "(format \"%v %v %f 100%%\" [1 \"a\"] \"s\" 3)",
+       .expected_str = "[1 \"a\"] s 3.000000 100%",
+       .expected_type = EXPECT_STRING},
+      {.name = "parse_repr reads an int back",
+       .src = "(parse_repr (repr 42))",
+       .expected_str = "42",
+       .expected_type = EXPECT_INT},
+      {.name = "parse_repr keeps reals real",
+       .src = "(parse_repr (repr 2.0))",
+       .expected_str = "2.0",
+       .expected_type = EXPECT_REAL},
+      {.name = "repr survives a parse_repr round trip",
+       .src = "(let s (repr (dict (\"b\" . [1 2]) (\"a\" . (1 . null))))) "
+              "(= s (repr (parse_repr s)))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
+      {.name = "parse_repr returns err on malformed input",
+       .src = "(is_err? (parse_repr \"[1 2\"))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
+      {.name = "repr returns err on functions",
+       .src = "(is_err? (repr (fn [x] x)))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
+      {.name = "doc returns the docstring of a function",
+       .src = "(fn twice [x] \"Doubles x.\" (mul x 2)) (doc twice)",
+       .expected_str = "Doubles x.",
+       .expected_type = EXPECT_STRING},
+      {.name = "a docstring is not part of the body",
+       .src = "(fn twice [x] \"Doubles x.\" (mul x 2)) (twice 4)",
+       .expected_str = "8",
+       .expected_type = EXPECT_INT},
+      {.name = "a lone string is the body, not a docstring",
+       .src = "(fn hi [] \"hi\") (and (= (doc hi) null) (= (hi) \"hi\"))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
+      {.name = "doc describes natives",
+       .src = "(doc fst)",
+       .expected_str = "First element of a pair.",
+       .expected_type = EXPECT_STRING},
+      {.name = "doc raises on non-functions",
+       .src = "(is_err? (try (doc 1)))",
+       .expected_str = "true",
+       .expected_type = EXPECT_BOOL},
   };
   for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
     VMOptions options = defaultVMOptions();
@@ -188,6 +453,12 @@ static char *test_core_conversions(void) {
     case EXPECT_REAL:
       assert_msg = assert_real(val, atof(tests[i].expected_str));
       break;
+    case EXPECT_BOOL:
+      assert_msg = assert_bool(val, strcmp(tests[i].expected_str, "true") == 0);
+      break;
+    case EXPECT_STRING:
+      assert_msg = assert_string(val, tests[i].expected_str);
+      break;
     default:
       break;
     }
@@ -200,8 +471,216 @@ static char *test_core_conversions(void) {
   return NULL;
 }
 
+// Indices past the start stay out of bounds, and strict mode rejects every
+// negative index.
+static char *test_core_negative_index_errors(void) {
+  struct {
+    const char *src;
+    bool strict;
+    const char *expected_err;
+  } tests[] = {
+      {"(try (get [1 2] -3))", false, "list index out of bounds"},
+      {"(try (get \"ab\" -3))", false, "string index out of bounds"},
+      {"(try (get [1 2] -1))", true, "list index out of bounds"},
+      {"(try (get \"ab\" -1))", true, "string index out of bounds"},
+      {"(try (range [1 2] 0 2))", false, "range index out of bounds"},
+      {"(try (range [1 2] -3 -1))", false, "range index out of bounds"},
+      {"(try (range \"ab\" 3 -1))", false, "range index out of bounds"},
+      {"(try (range [1 2] 1 -1))", true, "range index out of bounds"},
+      {"(try (range (dict) 0 1))", false,
+       "range argument must be a list or string"},
+  };
+
+  for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+    VMOptions options = defaultVMOptions();
+    options.strict = tests[i].strict;
+    VM *vm = newVM(options);
+    InterpretResult result = interpret(vm, tests[i].src, NULL);
+    mu_assert("Interpretation failed", result == INTERPRET_OK);
+    char *assert_msg =
+        assert_error(vm->last_popped_value, tests[i].expected_err);
+    if (assert_msg != NULL) {
+      printf("Failed test: %s\n", tests[i].src);
+      mu_assert(assert_msg, false);
+    }
+    destroyVM(vm);
+  }
+  return NULL;
+}
+
+// Failed assertions raise errors whose message names the assertion, then the
+// message it was given and, for assert_eq, both values.
+static char *test_core_asserts(void) {
+  struct {
+    const char *src;
+    const char *expected_err;
+  } tests[] = {
+      {"(try (assert (= 1 2)))", "assert failed"},
+      {"(try (assert null \"no value\"))", "assert failed: no value"},
+      {"(try (assert_eq (+ 1 2) 4))", "assert_eq failed: 3 != 4"},
+      {"(try (assert_eq 1 \"1\" \"types\"))",
+       "assert_eq failed: types (1 != \"1\")"},
+      {"(try (assert_eq [1 2] [1 3]))", "assert_eq failed: [1 2] != [1 3]"},
+      {"(try (assert))", "assert expects a value and an optional message"},
+  };
+
+  for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+    VM *vm = newVM(defaultVMOptions());
+    InterpretResult result = interpret(vm, tests[i].src, NULL);
+    mu_assert("Interpretation failed", result == INTERPRET_OK);
+    char *assert_msg =
+        assert_error(vm->last_popped_value, tests[i].expected_err);
+    if (assert_msg != NULL) {
+      printf("Failed test: %s\n", tests[i].src);
+      mu_assert(assert_msg, false);
+    }
+    destroyVM(vm);
+  }
+
+  const char *passing =
+      "(assert true) (assert 0 \"zero is truthy\") (assert_eq [1 2] [1 2])";
+  VM *vm = newVM(defaultVMOptions());
+  mu_assert("Passing assertions raised",
+            interpret(vm, passing, NULL) == INTERPRET_OK);
+  destroyVM(vm);
+  return NULL;
+}
+
+static char *test_core_lookup(void) {
+  const char *src =
+      "(let d (dict (\"a\" . null) (\"b\" . 2)))\n"
+      "(assert_eq (lookup d \"a\") #[null true])\n"
+      "(assert_eq (lookup d \"z\") #[null false])\n"
+      "(assert_eq (lookup [1 2] -1) #[2 true])\n"
+      "(assert_eq (lookup #[1 2] 2) #[null false])\n"
+      "(assert_eq (lookup \"ab\" 0) #[\"a\" true])\n"
+      "(let [v ok] (lookup d \"b\"))\n"
+      "(assert_eq [v ok] [2 true])\n"
+      "(try (lookup [1] \"x\"))";
+  VM *vm = newVM(defaultVMOptions());
+  InterpretResult result = interpret(vm, src, NULL);
+  if (result != INTERPRET_OK) {
+    char *str = sprintValue(vm->raise_value);
+    printf("Got: %s\n", str);
+    free(str);
+  }
+  mu_assert("lookup should pair items with whether they were found",
+            result == INTERPRET_OK);
+  mu_assert("lookup should still raise on a bad index",
+            assert_error(vm->last_popped_value,
+                         "list index must be an integer") == NULL);
+  destroyVM(vm);
+  return NULL;
+}
+
+static char *test_core_rune_len(void) {
+  const char *src =
+      "(assert_eq (rune_len \"h\xc3\xa9llo\") 5)\n"
+      "(assert_eq (byte_len \"h\xc3\xa9llo\") 6)\n"
+      "(assert_eq (len \"h\xc3\xa9llo\") 6)\n"
+      "(assert_eq (rune_len \"e\xcc\x81\") 2)\n"
+      "(assert_eq (rune_len \"\") 0)\n"
+      "(assert_eq (byte_len (bytes [1 2 3])) 3)\n"
+      "(byte_len [1])";
+  VM *vm = newVM(defaultVMOptions());
+  InterpretResult result = interpret(vm, src, NULL);
+  mu_assert("byte_len should raise on a list",
+            result == INTERPRET_RUNTIME_ERROR);
+  mu_assert("byte_len should say what it takes",
+            strcmp(AS_ERROR(vm->raise_value)->message->chars,
+                   "byte_len takes a string or bytes") == 0);
+  destroyVM(vm);
+  return NULL;
+}
+
+static char *test_core_string_builder(void) {
+  const char *src =
+      "(let b (sb))\n"
+      "(assert_eq (sb_str b) \"\")\n"
+      "(assert_eq (sb_append! b \"a\" 1 2.5 [1 2] null) b)\n"
+      "(assert_eq (sb_str b) \"a12.5[1 2]null\")\n"
+      "(let s (sb_str b))\n"
+      "(let i 0)\n"
+      "(while (< i 1000) (sb_append! b \"xy,\") (set! i (+ i 1)))\n"
+      "(assert_eq s \"a12.5[1 2]null\" \"strings made before stay\")\n"
+      "(assert_eq (len (sb_str b)) 3014)\n"
+      "(sb_append! \"b\" \"x\")";
+  // Stress the GC so the builder's buffer and cached string are moved and
+  // swept while it grows.
+  VMOptions options = defaultVMOptions();
+  options.stress_gc = true;
+  VM *vm = newVM(options);
+  InterpretResult result = interpret(vm, src, NULL);
+  mu_assert("sb_append! should raise on a string",
+            result == INTERPRET_RUNTIME_ERROR);
+  mu_assert("sb_append! should say what it takes",
+            strcmp(AS_ERROR(vm->raise_value)->message->chars,
+                   "sb_append! takes a builder and values to add") == 0);
+  destroyVM(vm);
+  return NULL;
+}
+
+// Open files are listed by (resources) until they are closed. The standard
+// streams are listed too, but the VM leaves them open on shutdown, so the
+// suites that run after this one can still print.
+static char *test_core_resources(void) {
+  const char *srcs[] = {
+      "(import io [\"open\"]) (let f (open \"/dev/null\")) "
+      "(.name (get (resources) -1))",
+      "(import io [\"open\" \"close\"]) (let f (open \"/dev/null\")) "
+      "(close f) (len (resources))",
+  };
+  const char *expected[] = {"\"/dev/null\"", "3"};
+  for (size_t i = 0; i < sizeof(srcs) / sizeof(srcs[0]); i++) {
+    VM *vm = newVM(defaultVMOptions());
+    InterpretResult result = interpret(vm, srcs[i], NULL);
+    mu_assert("Interpretation failed", result == INTERPRET_OK);
+    char *got = sprintValue(vm->last_popped_value);
+    if (strcmp(got, expected[i]) != 0) {
+      printf("Failed test: %s\n  got: %s\n", srcs[i], got);
+      free(got);
+      mu_assert("unexpected resources", false);
+    }
+    free(got);
+    destroyVM(vm);
+  }
+  return NULL;
+}
+
+// In deterministic mode the clock only moves with executed instructions.
+static char *test_core_virtual_clock(void) {
+  const char *src = "(fn spin [n] (cond (lt n 1) 0 (spin (- n 1)))) "
+                    "(let t0 (time_ms)) (spin 5000) [t0 (time_ms)]";
+  char *got[2];
+  for (int i = 0; i < 2; i++) {
+    VMOptions options = defaultVMOptions();
+    options.deterministic = true;
+    VM *vm = newVM(options);
+    mu_assert("Interpretation failed",
+              interpret(vm, src, NULL) == INTERPRET_OK);
+    got[i] = sprintValue(vm->last_popped_value);
+    destroyVM(vm);
+  }
+  bool same = strcmp(got[0], got[1]) == 0;
+  bool starts_at_zero = strncmp(got[0], "[0 ", 3) == 0;
+  bool advanced = strcmp(got[0], "[0 0]") != 0;
+  free(got[0]);
+  free(got[1]);
+  mu_assert("virtual clock differs between runs", same);
+  mu_assert("virtual clock doesn't start at 0", starts_at_zero);
+  mu_assert("virtual clock didn't advance", advanced);
+  return NULL;
+}
+
 void modules_core_suite(void) {
   printf("--- Core Module Suite ---\n");
   mu_run_test(test_core_containers);
   mu_run_test(test_core_conversions);
+  mu_run_test(test_core_negative_index_errors);
+  mu_run_test(test_core_asserts);
+  mu_run_test(test_core_lookup);
+  mu_run_test(test_core_rune_len);
+  mu_run_test(test_core_string_builder);
+  mu_run_test(test_core_resources);
+  mu_run_test(test_core_virtual_clock);
 }
@@ -0,0 +1,100 @@
+#define _POSIX_C_SOURCE 200809L
+#include "common.h"
+#include "minunit.h"
+#include "test_common.h"
+#include "value.h"
+#include "vm.h"
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+// Runs src with every %s replaced by dir and returns the printed result.
+// The caller frees it.
+static char *run_in(const char *dir, const char *src) {
+    char buf[1024];
+    // The sources use dir at most four times.
+    snprintf(buf, sizeof(buf), src, dir, dir, dir, dir);
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM *vm = newVM(options);
+    InterpretResult result = interpret(vm, buf, NULL);
+    char *got = result == INTERPRET_OK ? sprintValue(vm->last_popped_value)
+                                       : strdup("<failed>");
+    destroyVM(vm);
+    return got;
+}
+
+static char *test_fs_dirs(void) {
+    char dir[] = "/tmp/liss_fs_XXXXXX";
+    mu_assert("could not make a temporary directory", mkdtemp(dir) != NULL);
+
+    struct {
+        const char *name;
+        const char *src;
+        const char *expected;
+    } cases[] = {
+        {"mkdir_all makes the parents",
+         "(import fs) (fs:mkdir_all \"%s/a/b\") "
+         "[(fs:is_dir? \"%s/a\") (fs:is_dir? \"%s/a/b\")]",
+         "[true true]"},
+        {"mkdir_all of an existing directory",
+         "(import fs) (fs:mkdir_all \"%s/a\")", "null"},
+        {"exists? of a missing path",
+         "(import fs) [(fs:exists? \"%s/a\") (fs:exists? \"%s/none\")]",
+         "[true false]"},
+        {"ls is sorted",
+         "(import fs) (import io) "
+         "(io:close (io:open \"%s/a/z.txt\" \"w\")) "
+         "(io:close (io:open \"%s/a/c.txt\" \"w\")) (fs:ls \"%s/a\")",
+         "[\"b\" \"c.txt\" \"z.txt\"]"},
+        {"glob matches names", "(import fs) (len (fs:glob \"%s/a/*.txt\"))",
+         "2"},
+        {"glob without a match", "(import fs) (fs:glob \"%s/a/*.none\")",
+         "[]"},
+        {"stat of a file",
+         "(import fs) (import io) (let f (io:open \"%s/a/c.txt\" \"w\")) "
+         "(io:print f \"hello\") (io:close f) (let s (fs:stat \"%s/a/c.txt\")) "
+         "[(get s \"size\") (get s \"is_file\") (get s \"is_dir\")]",
+         "[5 true false]"},
+        {"rename moves a file",
+         "(import fs) (fs:rename \"%s/a/c.txt\" \"%s/a/d.txt\") "
+         "[(fs:exists? \"%s/a/c.txt\") (fs:exists? \"%s/a/d.txt\")]",
+         "[false true]"},
+        {"remove refuses a full directory",
+         "(import fs) (is_err? (fs:remove \"%s/a\"))", "true"},
+        {"mkdir_all under a file",
+         "(import fs) (is_err? (fs:mkdir_all \"%s/a/d.txt/e\"))", "true"},
+        {"ls of a missing directory",
+         "(import fs) (is_err? (fs:ls \"%s/none\"))", "true"},
+        {"remove empties the tree",
+         "(import fs) (fs:remove \"%s/a/d.txt\") (fs:remove \"%s/a/z.txt\") "
+         "(fs:remove \"%s/a/b\") (fs:remove \"%s/a\")",
+         "null"},
+    };
+    for (size_t i = 0; i < sizeof(cases) / sizeof(cases[0]); i++) {
+        char *got = run_in(dir, cases[i].src);
+        bool ok = strcmp(got, cases[i].expected) == 0;
+        if (!ok) printf("Failed test: %s (got %s)\n", cases[i].name, got);
+        free(got);
+        mu_assert("fs result mismatch", ok);
+    }
+    mu_assert("could not remove the temporary directory", remove(dir) == 0);
+    return NULL;
+}
+
+// The sandbox refuses the module like io.
+static char *test_fs_sandbox(void) {
+    VMOptions options = defaultVMOptions();
+    options.sandbox = true;
+    VM *vm = newVM(options);
+    mu_assert("fs should be refused in the sandbox",
+              interpret(vm, "(import fs)", NULL) != INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+void modules_fs_suite(void) {
+    printf("--- FS Module Suite ---\n");
+    mu_run_test(test_fs_dirs);
+    mu_run_test(test_fs_sandbox);
+}
@@ -0,0 +1,104 @@
+#define _POSIX_C_SOURCE 200809L
+#include "common.h"
+#include "minunit.h"
+#include "test_common.h"
+#include "value.h"
+#include "vm.h"
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+
+// Runs src with input as its stdin and returns the printed result. The
+// caller frees it.
+static char *run_with_input(FILE *input, const char *src) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    options.input = input;
+    VM *vm = newVM(options);
+    InterpretResult result = interpret(vm, src, NULL);
+    char *got = result == INTERPRET_OK ? sprintValue(vm->last_popped_value)
+                                       : strdup("<failed>");
+    destroyVM(vm);
+    return got;
+}
+
+static char *test_io_stdin(void) {
+    struct {
+        const char *name;
+        const char *src;
+        const char *expected;
+    } cases[] = {
+        {"read_line reads stdin by default",
+         "(import io) [(io:read_line) (io:read_line)]", "[\"one\" \"two\"]"},
+        {"read_line takes a file",
+         "(import io) (io:read_line io:stdin)", "\"one\""},
+        {"read_all_stdin reads the rest",
+         "(import io) (io:read_line) (io:read_all_stdin)",
+         "\"two\r\nthree\""},
+        {"read_line at the end",
+         "(import io) (io:read_all_stdin) (io:read_line)", "<error: eof>"},
+        {"read_all_stdin at the end",
+         "(import io) (io:read_all_stdin) (io:read_all_stdin)", "\"\""},
+    };
+    const char text[] = "one\ntwo\r\nthree";
+    for (size_t i = 0; i < sizeof(cases) / sizeof(cases[0]); i++) {
+        FILE *input = fmemopen((void *)text, strlen(text), "r");
+        mu_assert("could not open the input", input != NULL);
+        char *got = run_with_input(input, cases[i].src);
+        fclose(input);
+        bool ok = strcmp(got, cases[i].expected) == 0;
+        if (!ok) printf("Failed test: %s (got %s)\n", cases[i].name, got);
+        free(got);
+        mu_assert("stdin result mismatch", ok);
+    }
+    return NULL;
+}
+
+// A pipe can't tell how much is left, so io:read reads it to the end.
+static char *test_io_read_pipe(void) {
+    int fds[2];
+    mu_assert("could not make a pipe", pipe(fds) == 0);
+    const char text[] = "piped\ninput\n";
+    mu_assert("could not write the pipe",
+              write(fds[1], text, strlen(text)) == (ssize_t)strlen(text));
+    close(fds[1]);
+    FILE *input = fdopen(fds[0], "r");
+    char *got = run_with_input(input, "(import io) (io:read io:stdin)");
+    fclose(input);
+    bool ok = strcmp(got, "\"piped\ninput\n\"") == 0;
+    if (!ok) printf("Failed test: read a pipe (got %s)\n", got);
+    free(got);
+    mu_assert("pipe result mismatch", ok);
+    return NULL;
+}
+
+// The command-line arguments after the script are io:args.
+static char *test_io_args(void) {
+    const char *args[] = {"one", "--two", "three four"};
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    options.args = args;
+    options.arg_cnt = 3;
+    VM *vm = newVM(options);
+    mu_assert("Interpretation failed",
+              interpret(vm, "(import io) io:args", NULL) == INTERPRET_OK);
+    char *got = sprintValue(vm->last_popped_value);
+    bool ok = strcmp(got, "[\"one\" \"--two\" \"three four\"]") == 0;
+    free(got);
+    destroyVM(vm);
+    mu_assert("io:args mismatch", ok);
+
+    char *none = run_with_input(NULL, "(import io) io:args");
+    ok = strcmp(none, "[]") == 0;
+    free(none);
+    mu_assert("io:args without arguments isn't empty", ok);
+    return NULL;
+}
+
+void modules_io_suite(void) {
+    printf("--- IO Module Suite ---\n");
+    mu_run_test(test_io_stdin);
+    mu_run_test(test_io_read_pipe);
+    mu_run_test(test_io_args);
+}
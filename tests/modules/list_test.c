@@ -259,6 +259,17 @@ static char *test_list_sort(void) {
          .src = "(import list [sort]) (sort [2 2 2])",
          .expected_str = "[2 2 2]",
          .expected_type = EXPECT_LIST},
+        {.name = "sort strings by their decomposed characters",
+         .src = "(import list [sort]) "
+                "(sort [\"f\" \"\xc3\xa9mile\" \"e\xcc\x81" "clair\"])",
+         .expected_str = "[\"e\xcc\x81" "clair\" \"\xc3\xa9mile\" \"f\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "sort agrees with <",
+         .src = "(import list [sort sort_by]) "
+                "(let xs [\"b\" \"\xc3\xa9\" \"e\xcc\x81\" \"a\" \"f\"]) "
+                "(= (str (sort xs)) (str (sort_by xs (fn [a b] (< a b)))))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
         {.name = "sort_by ascending",
          .src = "(import list [sort_by]) (sort_by [3 1 2] (fn [a b] (< a b)))",
          .expected_str = "[1 2 3]",
@@ -275,6 +286,133 @@ static char *test_list_sort(void) {
     return run_list_tests(tests, sizeof(tests) / sizeof(tests[0]));
 }
 
+// The ! builtins change the list every alias of it sees, but not the lists
+// that tail, cons, append or range made from it, nor copies.
+static char *test_list_in_place(void) {
+    ListTestCase tests[] = {
+        {.name = "push! appends in place",
+         .src = "(import list [push!]) (let xs [1 2]) (push! xs 3) (push! xs 4)"
+                " xs",
+         .expected_str = "[1 2 3 4]",
+         .expected_type = EXPECT_LIST},
+        {.name = "push! onto an empty list",
+         .src = "(import list [push!]) (let xs []) (push! xs 1) xs",
+         .expected_str = "[1]",
+         .expected_type = EXPECT_LIST},
+        {.name = "pop! returns the last item",
+         .src = "(import list [pop!]) (let xs [1 2 3]) (pop! xs)",
+         .expected_str = "3",
+         .expected_type = EXPECT_INT},
+        {.name = "pop! removes the last item",
+         .src = "(import list [pop! push!]) (let xs [1 2 3]) (pop! xs)"
+                " (push! xs 4) xs",
+         .expected_str = "[1 2 4]",
+         .expected_type = EXPECT_LIST},
+        {.name = "pop! down to empty",
+         .src = "(import list [pop! push!]) (let xs [1]) (pop! xs) (push! xs 2)"
+                " xs",
+         .expected_str = "[2]",
+         .expected_type = EXPECT_LIST},
+        {.name = "pop! of an empty list raises",
+         .src = "(import list [pop!]) (try (pop! []))",
+         .expected_str = "list:pop!: empty list",
+         .expected_type = EXPECT_ERROR},
+        {.name = "insert! at the front, middle and end",
+         .src = "(import list [insert!]) (let xs [2 4]) (insert! xs 0 1)"
+                " (insert! xs 2 3) (insert! xs 4 5) xs",
+         .expected_str = "[1 2 3 4 5]",
+         .expected_type = EXPECT_LIST},
+        {.name = "insert! counts negative indices from the end",
+         .src = "(import list [insert!]) (insert! [1 3] -1 2)",
+         .expected_str = "[1 2 3]",
+         .expected_type = EXPECT_LIST},
+        {.name = "insert! past the end raises",
+         .src = "(import list [insert!]) (try (insert! [1] 2 0))",
+         .expected_str = "list:insert!: index out of bounds",
+         .expected_type = EXPECT_ERROR},
+        {.name = "remove_at! returns the item",
+         .src = "(import list [remove_at!]) (remove_at! [1 2 3] 1)",
+         .expected_str = "2",
+         .expected_type = EXPECT_INT},
+        {.name = "remove_at! the last item keeps push! working",
+         .src = "(import list [remove_at! push!]) (let xs [1 2 3])"
+                " (remove_at! xs -1) (remove_at! xs 0) (push! xs 4) xs",
+         .expected_str = "[2 4]",
+         .expected_type = EXPECT_LIST},
+        {.name = "remove_at! out of bounds raises",
+         .src = "(import list [remove_at!]) (try (remove_at! [] 0))",
+         .expected_str = "list:remove_at!: index out of bounds",
+         .expected_type = EXPECT_ERROR},
+        {.name = "extend! appends every item",
+         .src = "(import list [extend!]) (let xs [1]) (extend! xs [2 3]) xs",
+         .expected_str = "[1 2 3]",
+         .expected_type = EXPECT_LIST},
+        {.name = "extend! with itself",
+         .src = "(import list [extend!]) (let xs [1 2]) (extend! xs xs)",
+         .expected_str = "[1 2 1 2]",
+         .expected_type = EXPECT_LIST},
+        {.name = "push! refuses the list itself",
+         .src = "(import list [push!]) (let xs [1]) (try (push! xs xs))",
+         .expected_str = "list:push!: a list cannot contain itself",
+         .expected_type = EXPECT_ERROR},
+        {.name = "insert! refuses a value holding the list",
+         .src = "(import list [insert!]) (let xs [1])"
+                " (try (insert! xs 0 (dict (\"a\" . [(tuple xs)]))))",
+         .expected_str = "list:insert!: a list cannot contain itself",
+         .expected_type = EXPECT_ERROR},
+        {.name = "extend! refuses a list holding the list",
+         .src = "(import list [extend!]) (let xs [1]) (try (extend! xs [2 xs]))",
+         .expected_str = "list:extend!: a list cannot contain itself",
+         .expected_type = EXPECT_ERROR},
+        {.name = "a refused extend! leaves the list as it was",
+         .src = "(import list [extend!]) (let xs [1]) (try (extend! xs [2 xs]))"
+                " xs",
+         .expected_str = "[1]",
+         .expected_type = EXPECT_LIST},
+        {.name = "a dict key list is frozen",
+         .src = "(import list [push! head]) (let d (dict ([1] . 0)))"
+                " (try (push! (head (keys d)) 2))",
+         .expected_str = "list:push!: list is a frozen dict key",
+         .expected_type = EXPECT_ERROR},
+        {.name = "aliases see the change",
+         .src = "(import list [push!]) (let xs [1]) (let ys xs) (push! ys 2)"
+                " xs",
+         .expected_str = "[1 2]",
+         .expected_type = EXPECT_LIST},
+        {.name = "copy does not",
+         .src = "(import list [push! copy]) (let xs [1]) (let ys (copy xs))"
+                " (push! ys 2) xs",
+         .expected_str = "[1]",
+         .expected_type = EXPECT_LIST},
+        {.name = "tail does not see changes to its source",
+         .src = "(import list [tail insert!]) (let xs [1 2 3])"
+                " (let ys (tail xs)) (insert! xs 2 9) ys",
+         .expected_str = "[2 3]",
+         .expected_type = EXPECT_LIST},
+        {.name = "the source does not see changes to its tail",
+         .src = "(import list [tail push! pop!]) (let xs [1 2 3])"
+                " (let ys (tail xs)) (pop! ys) (push! ys 7) xs",
+         .expected_str = "[1 2 3]",
+         .expected_type = EXPECT_LIST},
+        {.name = "cons does not see changes to its source",
+         .src = "(import list [cons remove_at!]) (let xs [1 2])"
+                " (let ys (cons xs 0)) (remove_at! xs 1) ys",
+         .expected_str = "[0 1 2]",
+         .expected_type = EXPECT_LIST},
+        {.name = "append does not see changes to its second list",
+         .src = "(import list [append push!]) (let xs [3])"
+                " (let ys (append [1 2] xs)) (push! xs 4) (push! ys 5) xs",
+         .expected_str = "[3 4]",
+         .expected_type = EXPECT_LIST},
+        {.name = "range does not see changes to its source",
+         .src = "(import list [pop!]) (let xs [1 2 3]) (let ys (range xs 1 -1))"
+                " (pop! xs) ys",
+         .expected_str = "[2 3]",
+         .expected_type = EXPECT_LIST},
+    };
+    return run_list_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
 void modules_list_suite(void) {
     printf("--- List Module Suite ---\n");
     mu_run_test(test_list_head_tail_last);
@@ -284,4 +422,5 @@ void modules_list_suite(void) {
     mu_run_test(test_list_reduce);
     mu_run_test(test_list_composition);
     mu_run_test(test_list_sort);
+    mu_run_test(test_list_in_place);
 }
@@ -243,6 +243,53 @@ static char *test_math_constants(void) {
     return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
 }
 
+static char *test_math_rand(void) {
+    TestCase tests[] = {
+        {.name = "rand is in [0, 1)",
+         .src = "(import math [\"rand\"]) (let r (rand)) "
+                "(and (gte r 0.0) (lt r 1.0))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "rand n is in [0, n)",
+         .src = "(import math [\"rand\"]) (let r (rand 3)) "
+                "(and (gte r 0) (lt r 3))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "rand 1 is always 0",
+         .src = "(import math [\"rand\"]) (rand 1)",
+         .expected_str = "0",
+         .expected_type = EXPECT_INT},
+        {.name = "rand rejects a non-positive bound",
+         .src = "(import math [\"rand\"]) (is_err? (try (rand 0)))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+// The same seed gives the same sequence, and a different one doesn't.
+static char *test_math_rand_seed(void) {
+    const char *src = "(import math [\"rand\"]) [(rand 1000000) (rand)]";
+    uint64_t seeds[] = {42, 42, 43};
+    char *got[3];
+    for (int i = 0; i < 3; i++) {
+        VMOptions options = defaultVMOptions();
+        options.seeded = true;
+        options.seed = seeds[i];
+        VM *vm = newVM(options);
+        mu_assert("Interpretation failed",
+                  interpret(vm, src, NULL) == INTERPRET_OK);
+        got[i] = sprintValue(vm->last_popped_value);
+        destroyVM(vm);
+    }
+    bool same = strcmp(got[0], got[1]) == 0;
+    bool differs = strcmp(got[0], got[2]) != 0;
+    for (int i = 0; i < 3; i++) free(got[i]);
+    mu_assert("same seed gave different numbers", same);
+    mu_assert("different seeds gave the same numbers", differs);
+    return NULL;
+}
+
 void modules_math_suite(void) {
     printf("--- Math Module Suite ---\n");
     mu_run_test(test_math_floor_ceil_round);
@@ -251,4 +298,6 @@ void modules_math_suite(void) {
     mu_run_test(test_math_log);
     mu_run_test(test_math_trig);
     mu_run_test(test_math_constants);
+    mu_run_test(test_math_rand);
+    mu_run_test(test_math_rand_seed);
 }
@@ -0,0 +1,162 @@
+#include "common.h"
+#include "minunit.h"
+#include "test_common.h"
+#include "value.h"
+#include "vm.h"
+#include <stdlib.h>
+#include <string.h>
+
+typedef struct {
+    const char *name;
+    const char *src;
+    const char *expected_str;
+    ExpectedValueType expected_type;
+} RandTestCase;
+
+static char *run_rand_tests(RandTestCase *tests, size_t count) {
+    for (size_t i = 0; i < count; i++) {
+        VMOptions options = defaultVMOptions();
+        options.stress_gc = true;
+        VM *vm = newVM(options);
+
+        InterpretResult result = interpret(vm, tests[i].src, NULL);
+        if (result != INTERPRET_OK) {
+            printf("Failed test: %s (InterpretResult: %d)\n", tests[i].name,
+                   result);
+            mu_assert("Interpretation failed", false);
+        }
+
+        Value val = vm->last_popped_value;
+        char *assert_msg = NULL;
+
+        switch (tests[i].expected_type) {
+        case EXPECT_INT:
+            assert_msg = assert_int(val, atoll(tests[i].expected_str));
+            break;
+        case EXPECT_BOOL:
+            assert_msg =
+                assert_bool(val, strcmp(tests[i].expected_str, "true") == 0);
+            break;
+        case EXPECT_LIST:
+            assert_msg = assert_list(val, tests[i].expected_str);
+            break;
+        case EXPECT_ERROR:
+            assert_msg = assert_error(val, tests[i].expected_str);
+            break;
+        default:
+            break;
+        }
+
+        if (assert_msg != NULL) {
+            printf("Failed test: %s\n", tests[i].name);
+            mu_assert(assert_msg, false);
+        }
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+static char *test_rand_draws(void) {
+    RandTestCase tests[] = {
+        {.name = "float is in [0, 1)",
+         .src = "(import rand) (let r (rand:float)) "
+                "(and (gte r 0.0) (lt r 1.0))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "range of ints is in [a, b)",
+         .src = "(import rand) (let r (rand:range -3 3)) "
+                "(and (gte r -3) (lt r 3))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "range of one int",
+         .src = "(import rand) (rand:range 7 8)",
+         .expected_str = "7",
+         .expected_type = EXPECT_INT},
+        {.name = "range with a real bound",
+         .src = "(import rand) (let r (rand:range 1 1.5)) "
+                "(and (gte r 1.0) (lt r 1.5))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "range rejects empty bounds",
+         .src = "(import rand) (try (rand:range 2 2))",
+         .expected_str = "rand:range: a must be less than b",
+         .expected_type = EXPECT_ERROR},
+        {.name = "choice of one element",
+         .src = "(import rand) (= (rand:choice [\"only\"]) \"only\")",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "choice of an empty list",
+         .src = "(import rand) (try (rand:choice []))",
+         .expected_str = "rand:choice: the list is empty",
+         .expected_type = EXPECT_ERROR},
+        {.name = "shuffle keeps the elements",
+         .src = "(import rand) (import list [sort]) "
+                "(sort (rand:shuffle [5 3 1 4 2]))",
+         .expected_str = "[1 2 3 4 5]",
+         .expected_type = EXPECT_LIST},
+        {.name = "shuffle leaves the list given",
+         .src = "(import rand) (let l [1 2 3]) (rand:shuffle l) l",
+         .expected_str = "[1 2 3]",
+         .expected_type = EXPECT_LIST},
+        {.name = "shuffle of an empty list",
+         .src = "(import rand) (rand:shuffle [])",
+         .expected_str = "[]",
+         .expected_type = EXPECT_LIST},
+    };
+    return run_rand_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+// rand:seed restarts the generator where --seed would start it.
+static char *test_rand_seed(void) {
+    const char *draws = "[(rand:float) (rand:range 0 1000000) "
+                        "(rand:choice [1 2 3 4 5 6 7 8 9]) "
+                        "(rand:shuffle [1 2 3 4 5 6 7 8 9])]";
+    char src[256];
+    snprintf(src, sizeof(src), "(import rand) (rand:seed 42) %s", draws);
+    char *got[2];
+    for (int i = 0; i < 2; i++) {
+        VMOptions options = defaultVMOptions();
+        VM *vm = newVM(options);
+        mu_assert("Interpretation failed",
+                  interpret(vm, src, NULL) == INTERPRET_OK);
+        got[i] = sprintValue(vm->last_popped_value);
+        destroyVM(vm);
+    }
+    VMOptions options = defaultVMOptions();
+    options.seeded = true;
+    options.seed = 42;
+    VM *vm = newVM(options);
+    snprintf(src, sizeof(src), "(import rand) %s", draws);
+    mu_assert("Interpretation failed",
+              interpret(vm, src, NULL) == INTERPRET_OK);
+    char *seeded = sprintValue(vm->last_popped_value);
+    destroyVM(vm);
+
+    bool same = strcmp(got[0], got[1]) == 0;
+    bool as_option = strcmp(got[0], seeded) == 0;
+    free(got[0]);
+    free(got[1]);
+    free(seeded);
+    mu_assert("same seed gave different draws", same);
+    mu_assert("rand:seed differs from the seed option", as_option);
+    return NULL;
+}
+
+// The generator starts from the clock unless seeded, so the sandbox refuses
+// the module.
+static char *test_rand_sandbox(void) {
+    VMOptions options = defaultVMOptions();
+    options.sandbox = true;
+    VM *vm = newVM(options);
+    mu_assert("rand should be refused in the sandbox",
+              interpret(vm, "(import rand)", NULL) != INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+void modules_rand_suite(void) {
+    printf("--- Rand Module Suite ---\n");
+    mu_run_test(test_rand_draws);
+    mu_run_test(test_rand_seed);
+    mu_run_test(test_rand_sandbox);
+}
@@ -1,5 +1,6 @@
 #include "common.h"
 #include "minunit.h"
+#include "object.h"
 #include "test_common.h"
 #include "value.h"
 #include "vm.h"
@@ -37,9 +38,18 @@ static char *run_tests(TestCase *tests, size_t count) {
         case EXPECT_NIL:
             assert_msg = assert_nil(val);
             break;
+        case EXPECT_INT:
+            assert_msg = assert_int(val, atoll(tests[i].expected_str));
+            break;
+        case EXPECT_STRING:
+            assert_msg = assert_string(val, tests[i].expected_str);
+            break;
         case EXPECT_LIST:
             assert_msg = assert_list(val, tests[i].expected_str);
             break;
+        case EXPECT_PAIR:
+            assert_msg = assert_pair(val, tests[i].expected_str);
+            break;
         case EXPECT_ERROR:
             assert_msg = assert_error(val, tests[i].expected_str);
             break;
@@ -112,6 +122,15 @@ static char *test_re_match(void) {
          .src = "(import re [\"re\" \"match\"]) (match (re \"(a)(b)\") \"ab\")",
          .expected_str = "[\"ab\" \"a\" \"b\"]",
          .expected_type = EXPECT_LIST},
+        {.name = "capture is an alias of match",
+         .src = "(import re [\"re\" \"capture\"]) "
+                "(capture (re \"(a)(b)\") \"ab\")",
+         .expected_str = "[\"ab\" \"a\" \"b\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "module-qualified alias",
+         .src = "(import re) (re:capture (re:re \"a(b+)\") \"abb\")",
+         .expected_str = "[\"abb\" \"bb\"]",
+         .expected_type = EXPECT_LIST},
         {.name = "match with group between literals",
          .src =
              "(import re [\"re\" \"match\"]) (match (re \"(a)b(c)\") \"abc\")",
@@ -129,8 +148,202 @@ static char *test_re_match(void) {
     return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
 }
 
+static char *test_re_search(void) {
+    TestCase tests[] = {
+        {.name = "search finds match past the start",
+         .src = "(import re [\"re\" \"search\"]) "
+                "(search (re \"[0-9]+\") \"abc 123 def\")",
+         .expected_str = "(4 . \"123\")",
+         .expected_type = EXPECT_PAIR},
+        {.name = "search prefers the leftmost match",
+         .src = "(import re [\"re\" \"search\"]) "
+                "(search (re \"b+|a\") \"xabbb\")",
+         .expected_str = "(1 . \"a\")",
+         .expected_type = EXPECT_PAIR},
+        {.name = "search returns null when nothing matches",
+         .src = "(import re [\"re\" \"search\"]) "
+                "(search (re \"z\") \"abc\")",
+         .expected_str = "null",
+         .expected_type = EXPECT_NIL},
+        {.name = "search honours ^ anchor",
+         .src = "(import re [\"re\" \"search\"]) "
+                "(search (re \"^b\") \"ab\")",
+         .expected_str = "null",
+         .expected_type = EXPECT_NIL},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_re_find_all(void) {
+    TestCase tests[] = {
+        {.name = "find_all returns all matches",
+         .src = "(import re [\"re\" \"find_all\"]) "
+                "(find_all (re \"[0-9]+\") \"a1 b22 c333\")",
+         .expected_str = "[\"1\" \"22\" \"333\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "find_all with no matches is empty",
+         .src = "(import re [\"re\" \"find_all\"]) "
+                "(find_all (re \"[0-9]+\") \"abc\")",
+         .expected_str = "[]",
+         .expected_type = EXPECT_LIST},
+        {.name = "find_all steps over empty matches",
+         .src = "(import re [\"re\" \"find_all\"]) "
+                "(find_all (re \"a*\") \"baa\")",
+         .expected_str = "[\"\" \"aa\" \"\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "find_all anchors only at the real start",
+         .src = "(import re [\"re\" \"find_all\"]) "
+                "(find_all (re \"^a\") \"aaa\")",
+         .expected_str = "[\"a\"]",
+         .expected_type = EXPECT_LIST},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_re_replace(void) {
+    TestCase tests[] = {
+        {.name = "replace substitutes every match",
+         .src = "(import re [\"re\" \"replace\"]) "
+                "(replace (re \"o\") \"foo boo\" \"0\")",
+         .expected_str = "f00 b00",
+         .expected_type = EXPECT_STRING},
+        {.name = "replace expands group references",
+         .src = "(import re [\"re\" \"replace\"]) "
+                "(replace (re \"([a-z]+)=([0-9]+)\") \"a=1, b=2\" "
+                "\"$2:$1\")",
+         .expected_str = "1:a, 2:b",
+         .expected_type = EXPECT_STRING},
+        {.name = "replace with $$ inserts a dollar",
+         .src = "(import re [\"re\" \"replace\"]) "
+                "(replace (re \"[0-9]+\") \"cost 5\" \"$$$0\")",
+         .expected_str = "cost $5",
+         .expected_type = EXPECT_STRING},
+        {.name = "replace without matches returns the input",
+         .src = "(import re [\"re\" \"replace\"]) "
+                "(replace (re \"x\") \"abc\" \"y\")",
+         .expected_str = "abc",
+         .expected_type = EXPECT_STRING},
+        {.name = "replace handles empty matches",
+         .src = "(import re [\"re\" \"replace\"]) "
+                "(replace (re \"x*\") \"ab\" \"-\")",
+         .expected_str = "-a-b-",
+         .expected_type = EXPECT_STRING},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_re_split(void) {
+    TestCase tests[] = {
+        {.name = "split returns the pieces between matches",
+         .src = "(import re [\"split\"]) (split \", *\" \"a, b,c\")",
+         .expected_str = "[\"a\" \"b\" \"c\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "split keeps empty pieces at the ends",
+         .src = "(import re) (re:split \"-\" \"-a--b-\")",
+         .expected_str = "[\"\" \"a\" \"\" \"b\" \"\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "split without matches returns the whole string",
+         .src = "(import re) (re:split (re:re \"x\") \"abc\")",
+         .expected_str = "[\"abc\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "empty matches do not split",
+         .src = "(import re) (re:split \"x*\" \"axxb\")",
+         .expected_str = "[\"a\" \"b\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "split of an empty string is one empty piece",
+         .src = "(import re) (re:split \",\" \"\")",
+         .expected_str = "[\"\"]",
+         .expected_type = EXPECT_LIST},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_re_inspect(void) {
+    TestCase tests[] = {
+        {.name = "inspect counts the capture groups",
+         .src = "(import re) (get (re:inspect \"(a)(b(c))\") \"groups\")",
+         .expected_str = "3",
+         .expected_type = EXPECT_INT},
+        {.name = "inspect keeps the pattern",
+         .src = "(import re) (get (re:inspect (re:re \"a+\")) \"pattern\")",
+         .expected_str = "a+",
+         .expected_type = EXPECT_STRING},
+        {.name = "inspect lists the program",
+         .src = "(import re) (get (re:inspect \"a\") \"program\")",
+         .expected_str = "start 1\n"
+                         "   0  char 'a' -> 2\n"
+                         "   1  save 0 -> 0\n"
+                         "   2  save 1 -> 3\n"
+                         "   3  match\n",
+         .expected_type = EXPECT_STRING},
+        {.name = "inspect raises on an invalid pattern",
+         .src = "(import re) (try (re:inspect \"(\"))",
+         .expected_str = "Invalid regex pattern",
+         .expected_type = EXPECT_ERROR},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_re_pattern_strings(void) {
+    TestCase tests[] = {
+        {.name = "natives take a pattern string",
+         .src = "(import re) [(re:match? \"[0-9]+\" \"a1\") "
+                "(re:search \"b\" \"abc\") (re:find_all \"a\" \"aba\") "
+                "(re:replace \"a\" \"aba\" \"x\")]",
+         .expected_str = "[true (1 . \"b\") [\"a\" \"a\"] \"xbx\"]",
+         .expected_type = EXPECT_LIST},
+        {.name = "an invalid pattern string raises",
+         .src = "(import re) (try (re:match? \"(\" \"x\"))",
+         .expected_str = "Invalid regex pattern",
+         .expected_type = EXPECT_ERROR},
+        {.name = "re returns the cached regex for a pattern",
+         .src = "(import re) (= (re:re \"a+\") (re:re \"a+\"))",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+    };
+    return run_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+// Patterns used as strings are compiled once and kept most recently used
+// first, dropping the least recently used beyond regex_cache_size.
+static char *test_re_cache(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    options.regex_cache_size = 2;
+    VM *vm = newVM(options);
+    InterpretResult result = interpret(
+        vm,
+        "(import re) (re:match? \"a\" \"a\") (re:match? \"b\" \"a\") "
+        "(re:match? \"a\" \"a\") (re:match? \"c\" \"a\")",
+        NULL);
+    mu_assert("Cache script failed", result == INTERPRET_OK);
+    mu_assert("Cache should be full", vm->re_cache.count == 2);
+    ObjRe *first = AS_RE(vm->re_cache.values[0]);
+    ObjRe *second = AS_RE(vm->re_cache.values[1]);
+    mu_assert("Newest pattern should come first",
+              strcmp(first->pattern->chars, "c") == 0);
+    mu_assert("Least recently used pattern should be dropped",
+              strcmp(second->pattern->chars, "a") == 0);
+    destroyVM(vm);
+
+    options.regex_cache_size = 0;
+    vm = newVM(options);
+    result = interpret(vm, "(import re) (re:find_all \"a\" \"aaaa\")", NULL);
+    mu_assert("Uncached pattern failed", result == INTERPRET_OK);
+    mu_assert("Cache should stay empty", vm->re_cache.count == 0);
+    destroyVM(vm);
+    return NULL;
+}
+
 void modules_re_suite(void) {
     printf("--- RE Module Suite ---\n");
     mu_run_test(test_re_match_quest);
     mu_run_test(test_re_match);
+    mu_run_test(test_re_search);
+    mu_run_test(test_re_find_all);
+    mu_run_test(test_re_replace);
+    mu_run_test(test_re_split);
+    mu_run_test(test_re_inspect);
+    mu_run_test(test_re_pattern_strings);
+    mu_run_test(test_re_cache);
 }
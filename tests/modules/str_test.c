@@ -217,6 +217,10 @@ static char *test_str_substr(void) {
          .src = "(import str [\"substr\"]) (substr \"hello\" 2 0)",
          .expected_str = "\"\"",
          .expected_type = EXPECT_STRING},
+        {.name = "substr start from the end",
+         .src = "(import str [\"substr\"]) (substr \"hello\" -3 2)",
+         .expected_str = "\"ll\"",
+         .expected_type = EXPECT_STRING},
         {.name = "substr start out of bounds",
          .src = "(import str [\"substr\"]) (substr \"hello\" 10 2)",
          .expected_str = "substr: start out of bounds",
@@ -348,6 +352,22 @@ static char *test_str_convert(void) {
          .src = "(import str [\"parse_int\"]) (parse_int \"0\")",
          .expected_str = "0",
          .expected_type = EXPECT_INT},
+        {.name = "parse_int in base 16",
+         .src = "(import str [\"parse_int\"]) (parse_int \"ff\" 16)",
+         .expected_str = "255",
+         .expected_type = EXPECT_INT},
+        {.name = "parse_int in base 2",
+         .src = "(import str [\"parse_int\"]) (parse_int \"-101\" 2)",
+         .expected_str = "-5",
+         .expected_type = EXPECT_INT},
+        {.name = "parse_int reads back to_hex",
+         .src = "(import str [\"parse_int\"]) (parse_int (to_hex 48879) 16)",
+         .expected_str = "48879",
+         .expected_type = EXPECT_INT},
+        {.name = "parse_int digit out of base",
+         .src = "(import str [\"parse_int\"]) (parse_int \"12\" 2)",
+         .expected_str = "parse_int: invalid integer",
+         .expected_type = EXPECT_ERROR},
         {.name = "parse_int invalid string",
          .src = "(import str [\"parse_int\"]) (parse_int \"abc\")",
          .expected_str = "parse_int: invalid integer",
@@ -360,6 +380,16 @@ static char *test_str_convert(void) {
          .src = "(import str [\"parse_int\"]) (parse_int \"42abc\")",
          .expected_str = "parse_int: invalid integer",
          .expected_type = EXPECT_ERROR},
+        {.name = "parse_int out of range",
+         .src = "(import str [\"parse_int\"])"
+                " (parse_int \"99999999999999999999\")",
+         .expected_str = "parse_int: integer out of range",
+         .expected_type = EXPECT_ERROR},
+        {.name = "parse_int out of range below",
+         .src = "(import str [\"parse_int\"])"
+                " (parse_int \"-99999999999999999999\")",
+         .expected_str = "parse_int: integer out of range",
+         .expected_type = EXPECT_ERROR},
         {.name = "parse_real valid float",
          .src = "(import str [\"parse_real\"]) (parse_real \"1.5\")",
          .expected_str = "1.5",
@@ -384,6 +414,59 @@ static char *test_str_convert(void) {
     return run_str_tests(tests, sizeof(tests) / sizeof(tests[0]));
 }
 
+// "\xc3\xa9" is e with an acute accent as one character, "e\xcc\x81" the
+// same as e and a combining acute.
+static char *test_str_compare(void) {
+    StrTestCase tests[] = {
+        {.name = "< on strings",
+         .src = "(< \"apple\" \"banana\")",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "> on strings",
+         .src = "(> \"apple\" \"banana\")",
+         .expected_str = "false",
+         .expected_type = EXPECT_BOOL},
+        {.name = "a composed letter sorts by its base letter",
+         .src = "(< \"\xc3\xa9\" \"f\")",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "a decomposed letter sorts by its base letter",
+         .src = "(< \"e\xcc\x81\" \"f\")",
+         .expected_str = "true",
+         .expected_type = EXPECT_BOOL},
+        {.name = "composed and decomposed forms are not equal",
+         .src = "(= \"\xc3\xa9\" \"e\xcc\x81\")",
+         .expected_str = "false",
+         .expected_type = EXPECT_BOOL},
+        {.name = "marks are ordered by combining class",
+         .src = "(import str) "
+                "(str:compare \"a\xcc\xa3\xcc\x82x\" \"\xe1\xba\xadz\")",
+         .expected_str = "-1",
+         .expected_type = EXPECT_INT},
+        {.name = "compare equal strings",
+         .src = "(import str) (str:compare \"abc\" \"abc\")",
+         .expected_str = "0",
+         .expected_type = EXPECT_INT},
+        {.name = "collate ignores case first",
+         .src = "(import str) (str:collate \"apple\" \"Banana\")",
+         .expected_str = "-1",
+         .expected_type = EXPECT_INT},
+        {.name = "collate ignores accents first",
+         .src = "(import str) (str:collate \"\xc3\xa9" "clair\" \"eve\")",
+         .expected_str = "-1",
+         .expected_type = EXPECT_INT},
+        {.name = "collate orders accents before case",
+         .src = "(import str) (str:collate \"Ete\" \"\xc3\xa9te\")",
+         .expected_str = "-1",
+         .expected_type = EXPECT_INT},
+        {.name = "collate orders upper case first",
+         .src = "(import str) (str:collate \"e\" \"E\")",
+         .expected_str = "1",
+         .expected_type = EXPECT_INT},
+    };
+    return run_str_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
 static char *test_core_str(void) {
     StrTestCase tests[] = {
         {.name = "str passthrough on string",
@@ -420,5 +503,6 @@ void str_suite(void) {
     mu_run_test(test_str_split);
     mu_run_test(test_str_join);
     mu_run_test(test_str_convert);
+    mu_run_test(test_str_compare);
     mu_run_test(test_core_str);
 }
@@ -0,0 +1,205 @@
+#include "common.h"
+#include "minunit.h"
+#include "test_common.h"
+#include "value.h"
+#include "vm.h"
+#include <stdlib.h>
+#include <string.h>
+
+typedef struct {
+    const char *name;
+    const char *src;
+    const char *expected_str;
+    ExpectedValueType expected_type;
+} TimeTestCase;
+
+static char *run_time_tests(TimeTestCase *tests, size_t count) {
+    for (size_t i = 0; i < count; i++) {
+        VMOptions options = defaultVMOptions();
+        options.stress_gc = true;
+        VM *vm = newVM(options);
+
+        InterpretResult result = interpret(vm, tests[i].src, NULL);
+        if (result != INTERPRET_OK) {
+            printf("Failed test: %s (InterpretResult: %d)\n", tests[i].name,
+                   result);
+            mu_assert("Interpretation failed", false);
+        }
+
+        Value val = vm->last_popped_value;
+        char *assert_msg = NULL;
+
+        switch (tests[i].expected_type) {
+        case EXPECT_INT:
+            assert_msg = assert_int(val, atoll(tests[i].expected_str));
+            break;
+        case EXPECT_BOOL:
+            assert_msg =
+                assert_bool(val, strcmp(tests[i].expected_str, "true") == 0);
+            break;
+        case EXPECT_NIL:
+            assert_msg = assert_nil(val);
+            break;
+        case EXPECT_STRING:
+            assert_msg = assert_string(val, tests[i].expected_str);
+            break;
+        case EXPECT_LIST:
+            assert_msg = assert_list(val, tests[i].expected_str);
+            break;
+        case EXPECT_ERROR:
+            assert_msg = assert_error(val, tests[i].expected_str);
+            break;
+        default:
+            break;
+        }
+
+        if (assert_msg != NULL) {
+            printf("Failed test: %s\n", tests[i].name);
+            mu_assert(assert_msg, false);
+        }
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+static char *test_time_format(void) {
+    TimeTestCase tests[] = {
+        {.name = "format the epoch",
+         .src = "(import time) (time:format 0 \"2006-01-02 15:04:05.000\")",
+         .expected_str = "1970-01-01 00:00:00.000",
+         .expected_type = EXPECT_STRING},
+        {.name = "format before the epoch",
+         .src = "(import time) (time:format -1 \"2006-01-02 15:04:05.000\")",
+         .expected_str = "1969-12-31 23:59:59.999",
+         .expected_type = EXPECT_STRING},
+        {.name = "format names and a 12-hour clock",
+         .src = "(import time) "
+                "(time:format 1709214307250 \"Mon Jan _2 03:04 PM 2006\")",
+         .expected_str = "Thu Feb 29 01:45 PM 2024",
+         .expected_type = EXPECT_STRING},
+        {.name = "format long names and unpadded fields",
+         .src = "(import time) "
+                "(time:format 1709214307250 \"Monday, January 2, 06 3:4:5\")",
+         .expected_str = "Thursday, February 29, 24 1:45:7",
+         .expected_type = EXPECT_STRING},
+        {.name = "format zones as UTC",
+         .src = "(import time) (time:format 0 \"-0700 Z07:00\")",
+         .expected_str = "+0000 Z",
+         .expected_type = EXPECT_STRING},
+        {.name = "format buckets by hour",
+         .src = "(import time) "
+                "(time:format 1709214307250 \"2006-01-02T15\")",
+         .expected_str = "2024-02-29T13",
+         .expected_type = EXPECT_STRING},
+    };
+    return run_time_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_time_parse(void) {
+    TimeTestCase tests[] = {
+        {.name = "parse a date and time",
+         .src = "(import time) "
+                "(time:parse \"2024-02-29 13:45:07.250\" "
+                "\"2006-01-02 15:04:05.000\")",
+         .expected_str = "1709214307250",
+         .expected_type = EXPECT_INT},
+        {.name = "parse an offset",
+         .src = "(import time) "
+                "(time:parse \"2024-01-02T10:00:00+02:00\" "
+                "\"2006-01-02T15:04:05Z07:00\")",
+         .expected_str = "1704182400000",
+         .expected_type = EXPECT_INT},
+        {.name = "parse round trips format",
+         .src = "(import time) (let l \"Jan _2 2006 03:04:05 PM\") "
+                "(time:parse (time:format 951782400000 l) l)",
+         .expected_str = "951782400000",
+         .expected_type = EXPECT_INT},
+        {.name = "parse a day out of range",
+         .src = "(import time) (time:parse \"2023-02-29\" \"2006-01-02\")",
+         .expected_str = "time:parse: field out of range",
+         .expected_type = EXPECT_ERROR},
+        {.name = "parse text that does not match",
+         .src = "(import time) (time:parse \"2024-02-2\" \"2006-01-02\")",
+         .expected_str = "time:parse: text does not match the layout",
+         .expected_type = EXPECT_ERROR},
+    };
+    return run_time_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_time_arithmetic(void) {
+    TimeTestCase tests[] = {
+        {.name = "add a day over a leap day",
+         .src = "(import time) "
+                "(time:format (time:add 1709214307250 1 \"d\") \"2006-01-02\")",
+         .expected_str = "2024-03-01",
+         .expected_type = EXPECT_STRING},
+        {.name = "add a negative count",
+         .src = "(import time) (time:add 0 -2 \"h\")",
+         .expected_str = "-7200000",
+         .expected_type = EXPECT_INT},
+        {.name = "diff counts whole units",
+         .src = "(import time) (time:diff (time:add 0 36 \"h\") 0 \"d\")",
+         .expected_str = "1",
+         .expected_type = EXPECT_INT},
+        {.name = "diff in weeks",
+         .src = "(import time) (time:diff 0 (time:add 0 15 \"d\") \"w\")",
+         .expected_str = "-2",
+         .expected_type = EXPECT_INT},
+        {.name = "add rejects an unknown unit",
+         .src = "(import time) (try (time:add 0 1 \"y\"))",
+         .expected_str = "time:add: unit must be ms, s, m, h, d or w",
+         .expected_type = EXPECT_ERROR},
+        {.name = "add stops at the range of ints",
+         .src = "(import time) (try (time:add 0 9223372036854775807 \"s\"))",
+         .expected_str = "time:add: timestamp out of range",
+         .expected_type = EXPECT_ERROR},
+    };
+    return run_time_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+static char *test_time_parts(void) {
+    TimeTestCase tests[] = {
+        {.name = "parts of a leap day",
+         .src = "(import time) (let p (time:parts 1709214307250)) "
+                "[(get p \"year\") (get p \"month\") (get p \"day\") "
+                "(get p \"hour\") (get p \"minute\") (get p \"second\") "
+                "(get p \"ms\") (get p \"weekday\") (get p \"yday\")]",
+         .expected_str = "[2024 2 29 13 45 7 250 4 60]",
+         .expected_type = EXPECT_LIST},
+        {.name = "parts before the epoch",
+         .src = "(import time) (get (time:parts -1) \"year\")",
+         .expected_str = "1969",
+         .expected_type = EXPECT_INT},
+    };
+    return run_time_tests(tests, sizeof(tests) / sizeof(tests[0]));
+}
+
+// now and sleep reach the host, so the sandbox leaves them out.
+static char *test_time_host(void) {
+    VMOptions options = defaultVMOptions();
+    options.deterministic = true;
+    VM *vm = newVM(options);
+    mu_assert("Interpretation failed",
+              interpret(vm, "(import time) (time:sleep 10) (time:now)",
+                        NULL) == INTERPRET_OK);
+    mu_assert("now is not an int", IS_INT(vm->last_popped_value));
+    destroyVM(vm);
+
+    options = defaultVMOptions();
+    options.sandbox = true;
+    vm = newVM(options);
+    mu_assert("now should be left out of the sandbox",
+              interpret(vm, "(import time) (time:now)", NULL) !=
+                  INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+void modules_time_suite(void) {
+    printf("--- Time Module Suite ---\n");
+    mu_run_test(test_time_format);
+    mu_run_test(test_time_parse);
+    mu_run_test(test_time_arithmetic);
+    mu_run_test(test_time_parts);
+    mu_run_test(test_time_host);
+}
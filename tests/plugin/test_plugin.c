@@ -0,0 +1,21 @@
+// A plugin for the module tests: (import test_plugin) with plugins enabled
+// finds bin/test_plugin.so and calls liss_module below.
+#include "object.h"
+#include "plugin.h"
+#include "value.h"
+#include "vm.h"
+
+static Value addNative(VM* vm, int argc, Value* argv) {
+    (void)vm;
+    (void)argc;
+    return INT_VAL(AS_INT(argv[0]) + AS_INT(argv[1]));
+}
+
+static const NativeReg plugin_functions[] = {
+    {"add", 2, addNative, "ii"},
+    {NULL, 0, NULL, NULL},
+};
+
+void liss_module(VM* vm, ObjModule* module) {
+    defineNatives(vm, module, plugin_functions);
+}
@@ -1,5 +1,7 @@
 #include "regex.h"
 
+#include <regex.h>  // POSIX regex, the reference of the differential test
+
 #include "common.h"
 #include "minunit.h"
 #include "test_common.h"
@@ -194,6 +196,7 @@ static char* test_char_classes() {
         {.pattern = "^foo$", .text = "foobar", .expected = false},
         {.pattern = "^\\d+$", .text = "123", .expected = true},
         {.pattern = "^\\d+$", .text = "12x", .expected = false},
+        {.pattern = "$", .text = "abc", .expected = true},
         // unanchored substring matching
         {.pattern = "\\d+", .text = "L55", .expected = true},
         {.pattern = "\\d+", .text = "no!", .expected = false},
@@ -243,6 +246,9 @@ static char* test_bracket_classes() {
         {.pattern = "[a-zA-Z]", .text = "Z", .expected = true},
         {.pattern = "[a-zA-Z]", .text = "5", .expected = false},
         {.pattern = "[a-zA-Z0-9]", .text = "9", .expected = true},
+        // a leading ']' can start a range
+        {.pattern = "^[]-^]$", .text = "^", .expected = true},
+        {.pattern = "^[]-^]$", .text = "-", .expected = false},
         // with quantifiers
         {.pattern = "[a-z]+", .text = "hello", .expected = true},
         {.pattern = "[a-z]+", .text = "HELLO", .expected = false},
@@ -275,10 +281,471 @@ static char* test_bracket_classes() {
     return NULL;
 }
 
+static char* test_escaped_metachars() {
+    ClassMatchTest tests[] = {
+        // identifier-like classes
+        {.pattern = "^[a-z0-9_]+$", .text = "foo_42", .expected = true},
+        {.pattern = "^[a-z0-9_]+$", .text = "foo-42", .expected = false},
+        // escapes inside classes
+        {.pattern = "^[\\]]$", .text = "]", .expected = true},
+        {.pattern = "^[a\\-z]+$", .text = "a-z", .expected = true},
+        {.pattern = "^[a\\-z]+$", .text = "b", .expected = false},
+        {.pattern = "^[\\\\/]+$", .text = "\\/", .expected = true},
+        {.pattern = "^[\\^x]$", .text = "^", .expected = true},
+        {.pattern = "^[\\[\\]]+$", .text = "[]", .expected = true},
+        {.pattern = "^[\\t ]+$", .text = " \t", .expected = true},
+        // escape classes inside brackets
+        {.pattern = "^[\\d.]+$", .text = "3.14", .expected = true},
+        {.pattern = "^[\\d.]+$", .text = "3,14", .expected = false},
+        {.pattern = "^[\\s\\w]+$", .text = "a b_c", .expected = true},
+        {.pattern = "^[^\\d]+$", .text = "abc", .expected = true},
+        {.pattern = "^[^\\d]+$", .text = "a1c", .expected = false},
+        // literal '-' at the edges of a class
+        {.pattern = "^[-a]+$", .text = "-a-", .expected = true},
+        {.pattern = "^[a-]+$", .text = "a-", .expected = true},
+        // escaped metacharacters outside of classes
+        {.pattern = "^a\\.b$", .text = "a.b", .expected = true},
+        {.pattern = "^a\\.b$", .text = "axb", .expected = false},
+        {.pattern = "^\\(\\d+\\)$", .text = "(42)", .expected = true},
+        {.pattern = "^a\\*$", .text = "a*", .expected = true},
+        {.pattern = "^a\\*$", .text = "aa", .expected = false},
+        {.pattern = "^\\$\\d+$", .text = "$10", .expected = true},
+        {.pattern = "^\\w+@\\w+\\.com$",
+         .text = "me@site.com",
+         .expected = true},
+        // control chars and bytes >= 128, such as UTF-8 text, are literals
+        {.pattern = "^a\tb$", .text = "a\tb", .expected = true},
+        {.pattern = "^a\x10$", .text = "ab", .expected = false},
+        {.pattern = "^caf\xc3\xa9$", .text = "caf\xc3\xa9", .expected = true},
+        {.pattern = "^caf\xc3\xa9$", .text = "cafe", .expected = false},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        ReProgram* prog = compilePattern(tests[i].pattern);
+        mu_assert("compilePattern returned NULL", prog != NULL);
+
+        bool got = match(prog, tests[i].text);
+        if (got != tests[i].expected) {
+            printf("FAIL: pattern='%s' text='%s' expected=%s got=%s\n",
+                   tests[i].pattern, tests[i].text,
+                   tests[i].expected ? "match" : "no-match",
+                   got ? "match" : "no-match");
+            mu_assert("escaped metachar match mismatch", false);
+        }
+
+        free(prog->instrs);
+        free(prog);
+    }
+
+    mu_assert("reversed range should not compile",
+              compilePattern("[z-a]") == NULL);
+    mu_assert("unterminated class should not compile",
+              compilePattern("[a\\]") == NULL);
+    return NULL;
+}
+
+static char* test_quantifiers() {
+    ClassMatchTest tests[] = {
+        // + and ?
+        {.pattern = "^ab+c$", .text = "abbc", .expected = true},
+        {.pattern = "^ab+c$", .text = "ac", .expected = false},
+        {.pattern = "^ab?c$", .text = "ac", .expected = true},
+        {.pattern = "^ab?c$", .text = "abbc", .expected = false},
+        // {m}
+        {.pattern = "^a{3}$", .text = "aaa", .expected = true},
+        {.pattern = "^a{3}$", .text = "aa", .expected = false},
+        {.pattern = "^a{3}$", .text = "aaaa", .expected = false},
+        // {m,n}
+        {.pattern = "^a{2,4}$", .text = "a", .expected = false},
+        {.pattern = "^a{2,4}$", .text = "aa", .expected = true},
+        {.pattern = "^a{2,4}$", .text = "aaaa", .expected = true},
+        {.pattern = "^a{2,4}$", .text = "aaaaa", .expected = false},
+        {.pattern = "^a{0,2}b$", .text = "b", .expected = true},
+        {.pattern = "^a{0,2}b$", .text = "aab", .expected = true},
+        {.pattern = "^a{0,2}b$", .text = "aaab", .expected = false},
+        // {m,}
+        {.pattern = "^a{2,}$", .text = "a", .expected = false},
+        {.pattern = "^a{2,}$", .text = "aaaaaa", .expected = true},
+        {.pattern = "^a{0,}$", .text = "", .expected = true},
+        // {0} and {0,0} leave the atom out
+        {.pattern = "^ba{0}c$", .text = "bc", .expected = true},
+        {.pattern = "^ba{0}c$", .text = "bac", .expected = false},
+        {.pattern = "^ba{0,0}c$", .text = "bc", .expected = true},
+        {.pattern = "^b(a){0}c$", .text = "bac", .expected = false},
+        {.pattern = "^a{0}$", .text = "", .expected = true},
+        // groups, classes and escapes as atoms
+        {.pattern = "^(ab){2}$", .text = "abab", .expected = true},
+        {.pattern = "^(ab){2}$", .text = "ab", .expected = false},
+        {.pattern = "^[0-9]{4}-[0-9]{2}$", .text = "2024-05", .expected = true},
+        {.pattern = "^[0-9]{4}-[0-9]{2}$", .text = "202-05", .expected = false},
+        {.pattern = "^\\d{2,3}$", .text = "123", .expected = true},
+        {.pattern = "^\\d{2,3}$", .text = "1234", .expected = false},
+        // braces that are not a quantifier are literals
+        {.pattern = "^a{x}$", .text = "a{x}", .expected = true},
+        {.pattern = "^{1}$", .text = "{1}", .expected = true},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        ReProgram* prog = compilePattern(tests[i].pattern);
+        mu_assert("compilePattern returned NULL", prog != NULL);
+
+        bool got = match(prog, tests[i].text);
+        if (got != tests[i].expected) {
+            printf("FAIL: pattern='%s' text='%s' expected=%s got=%s\n",
+                   tests[i].pattern, tests[i].text,
+                   tests[i].expected ? "match" : "no-match",
+                   got ? "match" : "no-match");
+            mu_assert("quantifier match mismatch", false);
+        }
+
+        free(prog->instrs);
+        free(prog);
+    }
+
+    const char* invalid[] = {"a{3,2}", "a{1000}", "x{2}{2}", "x{1,2}?{2}",
+                             "x*{2}", "x+{2}"};
+    for (size_t i = 0; i < sizeof(invalid) / sizeof(invalid[0]); i++) {
+        mu_assert("invalid repetition should not compile",
+                  compilePattern(invalid[i]) == NULL);
+    }
+    return NULL;
+}
+
+static char* test_repetition_groups() {
+    // Only one copy of a repeated group captures, so group numbering of the
+    // groups that follow is unaffected by the expansion.
+    const char* text = "ababx";
+    ReProgram* prog = compilePattern("(ab){1,3}(x)");
+    mu_assert("compilePattern returned NULL", prog != NULL);
+    mu_assert("repetition must not add groups", prog->num_grps == 3);
+
+    const char* submatch[MAX_GROUPS * 2];
+    mu_assert("expected a match", matchGroups(prog, text, submatch));
+    mu_assert("group 0 start", submatch[0] - text == 0);
+    mu_assert("group 0 end", submatch[1] - text == 5);
+    mu_assert("group 2 start", submatch[4] - text == 4);
+    mu_assert("group 2 end", submatch[5] - text == 5);
+
+    free(prog->instrs);
+    free(prog);
+
+    // A group left out by {0} still takes its number and never captures.
+    const char* skipped = "xb";
+    prog = compilePattern("(a){0}(b)");
+    mu_assert("compilePattern returned NULL", prog != NULL);
+    mu_assert("{0} must keep the group numbered", prog->num_grps == 3);
+    mu_assert("expected a match", matchGroups(prog, skipped, submatch));
+    mu_assert("group 1 is unset", submatch[2] == NULL);
+    mu_assert("group 2 start", submatch[4] - skipped == 1);
+    mu_assert("group 2 end", submatch[5] - skipped == 2);
+
+    free(prog->instrs);
+    free(prog);
+    return NULL;
+}
+
+static char* test_lazy_and_alternation() {
+    RegexGroupTest tests[] = {
+        {.pattern = "<(.+?)>",
+         .text = "<a><b>",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 3}, {1, 2}}},
+        {.pattern = "<(.+)>",
+         .text = "<a><b>",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 6}, {1, 5}}},
+        {.pattern = "a(b*?)",
+         .text = "abbb",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 1}, {1, 1}}},
+        {.pattern = "a(b*?)c",
+         .text = "abbbc",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 5}, {1, 4}}},
+        {.pattern = "a(b?\?)",
+         .text = "ab",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 1}, {1, 1}}},
+        {.pattern = "\"(.*?)\"",
+         .text = "say \"hi\" and \"bye\"",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{4, 8}, {5, 7}}},
+        {.pattern = "(a{2,3}?)",
+         .text = "aaaa",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 2}, {0, 2}}},
+        // The first alternative that leads to a match wins.
+        {.pattern = "(ab|a)b",
+         .text = "abb",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 3}, {0, 2}}},
+        {.pattern = "(ab|a)b",
+         .text = "ab",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 2}, {0, 1}}},
+        {.pattern = "(a|ab)(c|bcd)",
+         .text = "abcd",
+         .expected_match = true,
+         .expected_groups = 3,
+         .groups = {{0, 4}, {0, 1}, {1, 4}}},
+        {.pattern = "x(a|bc)+y",
+         .text = "xabcay",
+         .expected_match = true,
+         .expected_groups = 2,
+         .groups = {{0, 6}, {4, 5}}},
+        {.pattern = "ab|cd",
+         .text = "cd",
+         .expected_match = true,
+         .expected_groups = 1,
+         .groups = {{0, 2}}},
+        {.pattern = "",
+         .text = "abc",
+         .expected_match = true,
+         .expected_groups = 1,
+         .groups = {{0, 0}}},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        ReProgram* prog = compilePattern(tests[i].pattern);
+        mu_assert("compilePattern returned NULL", prog != NULL);
+
+        const char* submatch[MAX_GROUPS * 2];
+        bool got_match = matchGroups(prog, tests[i].text, submatch);
+        if (got_match != tests[i].expected_match) {
+            DEBUG_LOG("pattern '%s' on '%s'", tests[i].pattern,
+                      tests[i].text);
+            mu_assert("match result mismatch", false);
+        }
+        mu_assert("number of groups mismatch",
+                  prog->num_grps == tests[i].expected_groups);
+
+        for (int g = 0; g < tests[i].expected_groups; g++) {
+            int got_start = submatch[2 * g] - tests[i].text;
+            int got_end = submatch[2 * g + 1] - tests[i].text;
+            if (got_start != tests[i].groups[g].start ||
+                got_end != tests[i].groups[g].end) {
+                DEBUG_LOG("pattern '%s' group %d: got [%d, %d)",
+                          tests[i].pattern, g, got_start, got_end);
+                mu_assert("group span mismatch", false);
+            }
+        }
+
+        free(prog->instrs);
+        free(prog);
+    }
+    return NULL;
+}
+
+static char* test_missing_operands() {
+    const char* patterns[] = {"a|", "|a", "(|a)", "()", "*a", "a(*)"};
+    for (size_t i = 0; i < sizeof(patterns) / sizeof(patterns[0]); i++) {
+        ReProgram* prog = compilePattern(patterns[i]);
+        if (prog != NULL) {
+            DEBUG_LOG("pattern '%s' should not compile", patterns[i]);
+            free(prog->instrs);
+            free(prog);
+            mu_assert("expected compilePattern to fail", false);
+        }
+    }
+    return NULL;
+}
+
+// --- Differential test against POSIX regex ---
+//
+// Random patterns in the syntax both engines share run against random
+// subjects, and the engines must agree on whether there is a match and where
+// the leftmost one starts. Match ends are not compared: POSIX picks the
+// longest match, this engine the first one by priority. Anchors only appear
+// at the top level and repeat bounds are never 0, which this engine rejects.
+
+#define DIFF_PATTERN_MAX 8192
+#define DIFF_PATTERNS 3000
+#define DIFF_SUBJECTS 16
+
+typedef struct {
+    uint64_t rng;
+    char ours[DIFF_PATTERN_MAX];
+    char posix[DIFF_PATTERN_MAX];
+} DiffGen;
+
+static unsigned diffRand(DiffGen* gen, unsigned n) {
+    gen->rng = gen->rng * 6364136223846793005ULL + 1442695040888963407ULL;
+    return (unsigned)((gen->rng >> 33) % n);
+}
+
+// Appends the same construct to both patterns in each engine's spelling.
+static void diffEmit(DiffGen* gen, const char* ours, const char* posix) {
+    strcat(gen->ours, ours);
+    strcat(gen->posix, posix);
+}
+
+static void diffAlt(DiffGen* gen, int depth, bool top);
+
+static void diffAtom(DiffGen* gen, int depth) {
+    static const char* const atoms[][2] = {
+        {"a", "a"},
+        {"b", "b"},
+        {"1", "1"},
+        {".", "."},
+        {"\\.", "\\."},
+        {"\\d", "[0-9]"},
+        {"\\w", "[[:alnum:]_]"},
+        {"\\W", "[^[:alnum:]_]"},
+        {"\\s", "[[:space:]]"},
+        {"\\S", "[^[:space:]]"},
+        {"[ab]", "[ab]"},
+        {"[^a]", "[^a]"},
+        {"[a-c1]", "[a-c1]"},
+        {"[^ 1]", "[^ 1]"},
+    };
+    if (depth > 0 && diffRand(gen, 5) == 0) {
+        diffEmit(gen, "(", "(");
+        diffAlt(gen, depth - 1, false);
+        diffEmit(gen, ")", ")");
+    } else {
+        int k = diffRand(gen, sizeof(atoms) / sizeof(atoms[0]));
+        diffEmit(gen, atoms[k][0], atoms[k][1]);
+    }
+
+    char bounds[16];
+    int m = diffRand(gen, 3);
+    switch (diffRand(gen, 9)) {
+        case 0:
+            diffEmit(gen, "*", "*");
+            break;
+        case 1:
+            diffEmit(gen, "+", "+");
+            break;
+        case 2:
+            diffEmit(gen, "?", "?");
+            break;
+        case 3:
+            snprintf(bounds, sizeof(bounds), "{%d}", m + 1);
+            diffEmit(gen, bounds, bounds);
+            break;
+        case 4:
+            snprintf(bounds, sizeof(bounds), "{%d,}", m);
+            diffEmit(gen, bounds, bounds);
+            break;
+        case 5:
+            snprintf(bounds, sizeof(bounds), "{%d,%d}", m,
+                     m + 1 + (int)diffRand(gen, 2));
+            diffEmit(gen, bounds, bounds);
+            break;
+        default:
+            break;
+    }
+}
+
+static void diffAlt(DiffGen* gen, int depth, bool top) {
+    for (int alt = 0; alt == 0 || (alt < 2 && diffRand(gen, 4) == 0); alt++) {
+        if (alt > 0) diffEmit(gen, "|", "|");
+        if (top && diffRand(gen, 6) == 0) diffEmit(gen, "^", "^");
+        int n = 1 + diffRand(gen, 3);
+        for (int i = 0; i < n; i++) diffAtom(gen, depth);
+        if (top && diffRand(gen, 6) == 0) diffEmit(gen, "$", "$");
+    }
+}
+
+// The listing names every instruction with its operands and targets, and
+// shows a bracket set as the runs of chars in it.
+static char* test_sprint_program() {
+    ReProgram* prog = compilePattern("^a[b-dx]*\\d$");
+    mu_assert("Pattern should compile", prog != NULL);
+    char* listing = sprintProgram(prog);
+    const char* expected =
+        "start 6\n"
+        "   0  bol -> 1\n"
+        "   1  char 'a' -> 3\n"
+        "   2  set [b-dx] -> 3\n"
+        "   3  split 2, 4\n"
+        "   4  class \\d -> 5\n"
+        "   5  eol -> 7\n"
+        "   6  save 0 -> 0\n"
+        "   7  save 1 -> 8\n"
+        "   8  match\n";
+    bool same = strcmp(listing, expected) == 0;
+    if (!same) printf("Got:\n%s", listing);
+    free(listing);
+    free(prog->instrs);
+    free(prog);
+    mu_assert("Unexpected program listing", same);
+    return NULL;
+}
+
+static char* test_posix_differential() {
+    static const char alphabet[] = "ab1 _.c";
+    DiffGen gen = {.rng = 42};
+    for (int i = 0; i < DIFF_PATTERNS; i++) {
+        gen.ours[0] = '\0';
+        gen.posix[0] = '\0';
+        diffAlt(&gen, 2, true);
+
+        regex_t posix;
+        mu_assert("POSIX rejected a generated pattern",
+                  regcomp(&posix, gen.posix, REG_EXTENDED) == 0);
+        ReProgram* prog = compilePattern(gen.ours);
+        if (prog == NULL) {
+            DEBUG_LOG("pattern '%s' should compile", gen.ours);
+            regfree(&posix);
+            return "Generated pattern rejected";
+        }
+
+        char* failure = NULL;
+        for (int t = 0; t < DIFF_SUBJECTS && failure == NULL; t++) {
+            char text[16];
+            int len = diffRand(&gen, 9);
+            for (int j = 0; j < len; j++) {
+                text[j] = alphabet[diffRand(&gen, sizeof(alphabet) - 1)];
+            }
+            text[len] = '\0';
+
+            regmatch_t want;
+            bool want_match = regexec(&posix, text, 1, &want, 0) == 0;
+            const char* submatch[MAX_GROUPS * 2] = {0};
+            bool got_match = matchGroups(prog, text, submatch);
+            if (got_match != want_match ||
+                (got_match && submatch[0] - text != want.rm_so)) {
+                DEBUG_LOG("pattern '%s' (POSIX '%s') on '%s': match %d at %d,"
+                          " want %d at %d",
+                          gen.ours, gen.posix, text, got_match,
+                          got_match ? (int)(submatch[0] - text) : -1,
+                          want_match, want_match ? (int)want.rm_so : -1);
+                failure = "Engine disagrees with POSIX regex";
+            } else if (match(prog, text) != got_match) {
+                DEBUG_LOG("pattern '%s' on '%s': match and matchGroups differ",
+                          gen.ours, text);
+                failure = "match disagrees with matchGroups";
+            }
+        }
+        regfree(&posix);
+        free(prog->instrs);
+        free(prog);
+        if (failure != NULL) return failure;
+    }
+    return NULL;
+}
+
 void regex_suite() {
     printf("\n--- Regex Suite ---\n");
     mu_run_test(test_re2postfix);
     mu_run_test(test_match_groups);
     mu_run_test(test_char_classes);
     mu_run_test(test_bracket_classes);
+    mu_run_test(test_escaped_metachars);
+    mu_run_test(test_quantifiers);
+    mu_run_test(test_repetition_groups);
+    mu_run_test(test_lazy_and_alternation);
+    mu_run_test(test_missing_operands);
+    mu_run_test(test_sprint_program);
+    mu_run_test(test_posix_differential);
 }
@@ -0,0 +1,213 @@
+#include "repr.h"
+
+#include "common.h"
+#include "minunit.h"
+#include "test_common.h"
+#include "vm.h"
+
+typedef struct {
+    const char* src;
+    const char* expected;
+} ReprTest;
+
+static bool sameKind(Value a, Value b) {
+    if (a.type != b.type) return false;
+    return !IS_OBJ(a) || OBJ_TYPE(a) == OBJ_TYPE(b);
+}
+
+// Evaluates src, checks its repr and that parsing the repr gives back a value
+// of the same kind with the same repr.
+static char* test_repr_round_trip() {
+    ReprTest tests[] = {
+        {"null", "null"},
+        {"true", "true"},
+        {"false", "false"},
+        {"0", "0"},
+        {"42", "42"},
+        {"-7", "-7"},
+        {"9223372036854775807", "9223372036854775807"},
+        {"1.5", "1.5"},
+        {"-0.25", "-0.25"},
+        {"1.0", "1.0"},
+        {"0.1", "0.1"},
+        {"(div 1.0 3.0)", "0.3333333333333333"},
+        {"1e300", "1e+300"},
+        {"\"\"", "\"\""},
+        {"\"hello\"", "\"hello\""},
+        {"\"a\\\"b\"", "\"a\\\"b\""},
+        {"\"back\\\\slash\"", "\"back\\\\slash\""},
+        {"\"tab\\tnl\\ncr\\r\"", "\"tab\\tnl\\ncr\\r\""},
+        {"[]", "[]"},
+        {"[1 2 3]", "[1 2 3]"},
+        {"[1 \"two\" 3.0 null [true]]", "[1 \"two\" 3.0 null [true]]"},
+        {"#[]", "#[]"},
+        {"(from_hex \"00ff\")", "(from_hex \"00ff\")"},
+        {"(from_hex \"\")", "(from_hex \"\")"},
+        {"#[1 [2 #[\"x\"]] (3 . 4)]", "#[1 [2 #[\"x\"]] (3 . 4)]"},
+        {"(dict (#[2] . 0) (#[1] . 0))", "(dict (#[1] . 0) (#[2] . 0))"},
+        {"(1 . 2)", "(1 . 2)"},
+        {"(\"k\" . [1 (2 . 3)])", "(\"k\" . [1 (2 . 3)])"},
+        {"(dict)", "(dict)"},
+        {"(dict (\"b\" . 2) (\"a\" . 1) (\"c\" . 3))",
+         "(dict (\"a\" . 1) (\"b\" . 2) (\"c\" . 3))"},
+        {"(dict (10 . \"x\") (9 . \"y\") (\"k\" . null))",
+         "(dict (9 . \"y\") (10 . \"x\") (\"k\" . null))"},
+        {"(dict ([2] . 0) ([1] . 0))", "(dict ([1] . 0) ([2] . 0))"},
+        {"(dict (\"d\" . (dict (\"e\" . []))))",
+         "(dict (\"d\" . (dict (\"e\" . []))))"},
+        {"(err \"boom\")", "(err \"boom\")"},
+        {"[(err \"a\\\"b\") (1 . (err \"c\"))]",
+         "[(err \"a\\\"b\") (1 . (err \"c\"))]"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        VMOptions options = defaultVMOptions();
+        options.stress_gc = true;
+        VM* vm = newVM(options);
+
+        InterpretResult result = interpret(vm, tests[i].src, NULL);
+        if (result != INTERPRET_OK) {
+            printf("Failed test: %s\n", tests[i].src);
+            mu_assert("Interpretation failed", false);
+        }
+
+        Value val = vm->last_popped_value;
+        char* got = reprValue(val);
+        if (got == NULL || strcmp(got, tests[i].expected) != 0) {
+            printf("Failed test: %s\n  expected: %s\n  got: %s\n",
+                   tests[i].src, tests[i].expected, got ? got : "(null)");
+            mu_assert("repr mismatch", false);
+        }
+        free(got);
+
+        Value parsed;
+        const char* err_msg = NULL;
+        push(vm, val);
+        bool ok = parseRepr(vm, tests[i].expected, &parsed, &err_msg);
+        pop(vm);
+        if (!ok) {
+            printf("Failed test: %s: %s\n", tests[i].expected, err_msg);
+            mu_assert("parse_repr failed", false);
+        }
+        mu_assert("parsed value kind mismatch", sameKind(val, parsed));
+
+        char* again = reprValue(parsed);
+        mu_assert("round-trip repr mismatch",
+                  strcmp(again, tests[i].expected) == 0);
+        free(again);
+
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+static char* test_repr_special_reals() {
+    VM* vm = newVM(defaultVMOptions());
+    const char* src = "[inf -inf nan]";
+    Value val;
+    const char* err_msg = NULL;
+    mu_assert("parse special reals", parseRepr(vm, src, &val, &err_msg));
+    char* got = reprValue(val);
+    mu_assert("special reals repr", strcmp(got, src) == 0);
+    free(got);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_repr_unsupported() {
+    const char* srcs[] = {
+        "(fn [x] x)",
+        "len",
+        "[1 (fn [] 2)]",
+        "(dict (\"f\" . len))",
+    };
+    for (size_t i = 0; i < sizeof(srcs) / sizeof(srcs[0]); i++) {
+        VM* vm = newVM(defaultVMOptions());
+        mu_assert("Interpretation failed",
+                  interpret(vm, srcs[i], NULL) == INTERPRET_OK);
+        char* got = reprValue(vm->last_popped_value);
+        if (got != NULL) {
+            printf("Failed test: %s got %s\n", srcs[i], got);
+            mu_assert("expected no repr", false);
+        }
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+static char* test_repr_parse_errors() {
+    const char* srcs[] = {
+        "",
+        "[1 2",
+        "\"open",
+        "(1 2)",
+        "(1 . 2",
+        "(dict 1)",
+        "(err 1)",
+        "1 2",
+        "99999999999999999999",
+        "abc",
+        "[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[["
+        "]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]",
+    };
+    for (size_t i = 0; i < sizeof(srcs) / sizeof(srcs[0]); i++) {
+        VM* vm = newVM(defaultVMOptions());
+        Value val;
+        const char* err_msg = NULL;
+        if (parseRepr(vm, srcs[i], &val, &err_msg)) {
+            printf("Failed test: '%s' parsed\n", srcs[i]);
+            mu_assert("expected a parse error", false);
+        }
+        mu_assert("missing error message", err_msg != NULL);
+        mu_assert("stack not balanced", vm->stack_top == vm->stack);
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+// sprintPretty keeps short values on one line and breaks long ones one item
+// per line, with dict keys sorted.
+static char* test_pretty_print() {
+    ReprTest tests[] = {
+        {"42", "42"},
+        {"[1 2 3]", "[1 2 3]"},
+        {"(dict (\"b\" . 1) (\"a\" . 2))", "(dict (\"a\" . 2) (\"b\" . 1))"},
+        {"[\"item\" \"item\" \"item\" \"item\" \"item\" \"item\""
+         " \"item\" \"item\" \"item\" \"item\" \"item\" \"item\"]",
+         "[\n"
+         "    \"item\"\n    \"item\"\n    \"item\"\n    \"item\"\n"
+         "    \"item\"\n    \"item\"\n    \"item\"\n    \"item\"\n"
+         "    \"item\"\n    \"item\"\n    \"item\"\n    \"item\"]"},
+        {"(dict (\"users\" . [(dict (\"name\" . \"bob\") (\"age\" . 30))"
+         " (dict (\"name\" . \"eve\") (\"age\" . 25))]) (\"version\" . 2))",
+         "(dict\n"
+         "    (\"users\" . [\n"
+         "        (dict (\"age\" . 30) (\"name\" . \"bob\"))\n"
+         "        (dict (\"age\" . 25) (\"name\" . \"eve\"))])\n"
+         "    (\"version\" . 2))"},
+    };
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        VM* vm = newVM(defaultVMOptions());
+        mu_assert("Interpretation failed",
+                  interpret(vm, tests[i].src, NULL) == INTERPRET_OK);
+        char* got = sprintPretty(vm->last_popped_value);
+        if (strcmp(got, tests[i].expected) != 0) {
+            printf("Failed test: %s\n  expected: %s\n  got: %s\n",
+                   tests[i].src, tests[i].expected, got);
+            mu_assert("pretty print mismatch", false);
+        }
+        free(got);
+        destroyVM(vm);
+    }
+    return NULL;
+}
+
+void repr_suite() {
+    printf("\n--- Repr Suite ---\n");
+    mu_run_test(test_repr_round_trip);
+    mu_run_test(test_repr_special_reals);
+    mu_run_test(test_repr_unsupported);
+    mu_run_test(test_repr_parse_errors);
+    mu_run_test(test_pretty_print);
+}
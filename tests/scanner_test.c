@@ -20,14 +20,16 @@ static char* test_scanner_whitespace(void) {
 }
 
 static char* test_scanner_keywords(void) {
-    const char* source = "fn let true false null as cond switch try";
+    const char* source =
+        "fn let true false null as cond switch try while for const return";
     Scanner scanner;
     initScanner(&scanner, source);
 
     TokenType expected_types[] = {
-        TOKEN_FN_KW,   TOKEN_LET_KW, TOKEN_TRUE_KW, TOKEN_FALSE_KW,
-        TOKEN_NULL_KW, TOKEN_AS_KW,  TOKEN_COND_KW, TOKEN_SWITCH_KW,
-        TOKEN_TRY_KW,  TOKEN_EOF};
+        TOKEN_FN_KW,   TOKEN_LET_KW,   TOKEN_TRUE_KW,  TOKEN_FALSE_KW,
+        TOKEN_NULL_KW, TOKEN_AS_KW,    TOKEN_COND_KW,  TOKEN_SWITCH_KW,
+        TOKEN_TRY_KW,  TOKEN_WHILE_KW, TOKEN_FOR_KW,   TOKEN_CONST_KW,
+        TOKEN_RETURN_KW, TOKEN_EOF};
 
     for (size_t i = 0; i < sizeof(expected_types) / sizeof(expected_types[0]);
          i++) {
@@ -39,7 +41,8 @@ static char* test_scanner_keywords(void) {
 }
 
 static char* test_scanner_operators(void) {
-    const char* source = "+ - * / % ! != = == < <= > >= & | ^ ~ || && << >>";
+    const char* source =
+        "+ - * / % ! != = == < <= > >= & | ^ ~ || && << >> ~=";
     Scanner scanner;
     initScanner(&scanner, source);
 
@@ -64,6 +67,7 @@ static char* test_scanner_operators(void) {
                                   TOKEN_BAND_OP,
                                   TOKEN_LSHIFT_OP,
                                   TOKEN_RSHIFT_OP,
+                                  TOKEN_APPROX_EQUAL_OP,
                                   TOKEN_EOF};
 
     for (size_t i = 0; i < sizeof(expected_types) / sizeof(expected_types[0]);
@@ -206,6 +210,139 @@ static char* test_scanner_identifier_with_namespace(void) {
     return NULL;
 }
 
+static char* test_scanner_pragma(void) {
+    const char* source = "(pragma :lang \"1.0\")";
+    Scanner scanner;
+    initScanner(&scanner, source);
+
+    TokenType expected_types[] = {TOKEN_LPAREN,  TOKEN_PRAGMA_KW,
+                                  TOKEN_KEYWORD, TOKEN_STRING,
+                                  TOKEN_RPAREN,  TOKEN_EOF};
+    for (size_t i = 0; i < sizeof(expected_types) / sizeof(expected_types[0]);
+         i++) {
+        Token token = scanToken(&scanner);
+        mu_assert("Unexpected token type", token.type == expected_types[i]);
+        if (token.type == TOKEN_KEYWORD) {
+            mu_assert("Unexpected lexeme",
+                      token.length == 5 &&
+                          strncmp(token.start, ":lang", 5) == 0);
+        }
+    }
+
+    return NULL;
+}
+
+static char* test_scanner_accessor(void) {
+    const char* source = "(.users[0].name data) (a . b)";
+    Scanner scanner;
+    initScanner(&scanner, source);
+
+    TokenType expected_types[] = {
+        TOKEN_LPAREN, TOKEN_ACCESSOR,   TOKEN_IDENTIFIER, TOKEN_RPAREN,
+        TOKEN_LPAREN, TOKEN_IDENTIFIER, TOKEN_DOT,        TOKEN_IDENTIFIER,
+        TOKEN_RPAREN, TOKEN_EOF};
+    for (size_t i = 0; i < sizeof(expected_types) / sizeof(expected_types[0]);
+         i++) {
+        Token token = scanToken(&scanner);
+        mu_assert("Unexpected token type", token.type == expected_types[i]);
+        if (token.type == TOKEN_ACCESSOR) {
+            mu_assert("Unexpected lexeme",
+                      token.length == 14 &&
+                          strncmp(token.start, ".users[0].name", 14) == 0);
+        }
+    }
+
+    return NULL;
+}
+
+static char* test_scanner_comments(void) {
+    const char* source =
+        "; a line comment\n"
+        "(a #| a block\n"
+        "      comment |# b) ; trailing\n"
+        "#| outer #| nested |# still outer |# c";
+    Scanner scanner;
+    initScanner(&scanner, source);
+
+    TokenType expected_types[] = {TOKEN_LPAREN,     TOKEN_IDENTIFIER,
+                                  TOKEN_IDENTIFIER, TOKEN_RPAREN,
+                                  TOKEN_IDENTIFIER, TOKEN_EOF};
+    int expected_lines[] = {2, 2, 3, 3, 4, 4};
+    int expected_columns[] = {1, 2, 18, 19, 38, 39};
+    for (size_t i = 0; i < sizeof(expected_types) / sizeof(expected_types[0]);
+         i++) {
+        Token token = scanToken(&scanner);
+        mu_assert("Unexpected token type", token.type == expected_types[i]);
+        mu_assert("Unexpected line", token.line == expected_lines[i]);
+        mu_assert("Unexpected column", token.column == expected_columns[i]);
+    }
+
+    // A string spanning lines is reported where it starts, and the lines it
+    // spans still count.
+    initScanner(&scanner, "  \"one\ntwo\" x");
+    Token token = scanToken(&scanner);
+    mu_assert("Expected a string at 1:3", token.type == TOKEN_STRING &&
+                                              token.line == 1 &&
+                                              token.column == 3);
+    free((void*)token.start);
+    token = scanToken(&scanner);
+    mu_assert("Expected an identifier at 2:6",
+              token.line == 2 && token.column == 6);
+
+    initScanner(&scanner, "a #| never closed\n b");
+    mu_assert("Expected identifier",
+              scanToken(&scanner).type == TOKEN_IDENTIFIER);
+    mu_assert("Expected an unterminated comment error",
+              scanToken(&scanner).type == TOKEN_ERROR);
+
+    return NULL;
+}
+
+// A "#!" first line is skipped but counted, and "#!" anywhere else is not
+// a comment.
+static char* test_scanner_shebang(void) {
+    Scanner scanner;
+    initScanner(&scanner, "#!/usr/bin/env liss -W\n(a)");
+    Token token = scanToken(&scanner);
+    mu_assert("Expected '(' at 2:1", token.type == TOKEN_LPAREN &&
+                                         token.line == 2 &&
+                                         token.column == 1);
+
+    initScanner(&scanner, "#!/usr/bin/env liss");
+    mu_assert("Expected EOF after the #! line",
+              scanToken(&scanner).type == TOKEN_EOF);
+
+    initScanner(&scanner, "a\n#!b");
+    mu_assert("Expected identifier",
+              scanToken(&scanner).type == TOKEN_IDENTIFIER);
+    mu_assert("Expected an error for a later '#!'",
+              scanToken(&scanner).type == TOKEN_ERROR);
+
+    return NULL;
+}
+
+static char* test_scanner_tuple(void) {
+    const char* source = "#[1 [2]] #|c|# #[]";
+    Scanner scanner;
+    initScanner(&scanner, source);
+
+    TokenType expected_types[] = {
+        TOKEN_HASH_LBRAKET, TOKEN_INT,     TOKEN_LBRAKET,      TOKEN_INT,
+        TOKEN_RBRAKET,      TOKEN_RBRAKET, TOKEN_HASH_LBRAKET, TOKEN_RBRAKET,
+        TOKEN_EOF};
+    for (size_t i = 0; i < sizeof(expected_types) / sizeof(expected_types[0]);
+         i++) {
+        Token token = scanToken(&scanner);
+        mu_assert("Unexpected token type", token.type == expected_types[i]);
+    }
+
+    initScanner(&scanner, "# [1]");
+    mu_assert("Expected an error for a lone '#'",
+              scanToken(&scanner).type == TOKEN_ERROR);
+
+    return NULL;
+}
+
 void scanner_suite(void) {
     printf("--- Scanner Suite ---\n");
     mu_run_test(test_scanner_whitespace);
@@ -218,5 +355,10 @@ void scanner_suite(void) {
     mu_run_test(test_scanner_nested_expression);
     mu_run_test(test_scanner_unary_minus);
     mu_run_test(test_scanner_identifier_with_namespace);
+    mu_run_test(test_scanner_pragma);
+    mu_run_test(test_scanner_accessor);
+    mu_run_test(test_scanner_comments);
+    mu_run_test(test_scanner_shebang);
+    mu_run_test(test_scanner_tuple);
     // TODO: add more tests below
 }
@@ -0,0 +1,114 @@
+#include "symbols.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "common.h"
+#include "minunit.h"
+#include "vm.h"
+
+// Returns the index of the symbol of that kind at line and column, or -1.
+static int findSymbol(const SymbolIndex* index, SymbolKind kind, int line,
+                      int column) {
+    for (int i = 0; i < index->count; i++) {
+        const Symbol* symbol = &index->symbols[i];
+        if (symbol->kind == kind && symbol->line == line &&
+            symbol->column == column) {
+            return i;
+        }
+    }
+    return -1;
+}
+
+// Every kind of definition is found where its name is, and each reference
+// points at the definition it resolves to.
+static char* test_symbols_resolve() {
+    const char* src =
+        "(const limit 10)\n"
+        "(fn fact [n] (if (lt n 2) 1 (* n (fact (- n 1)))))\n"
+        "(let total 0)\n"
+        "(fn add [x]\n"
+        "    (let sum (+ x limit))\n"
+        "    (set! total sum)\n"
+        "    (fn [] sum))\n";
+    VM* vm = newVM(defaultVMOptions());
+    SymbolIndex index = {0};
+    mu_assert("Script did not compile.", indexSymbols(vm, src, &index));
+
+    int limit = findSymbol(&index, SYMBOL_CONST, 1, 8);
+    int fact = findSymbol(&index, SYMBOL_FUNCTION, 2, 5);
+    int n = findSymbol(&index, SYMBOL_PARAM, 2, 11);
+    int total = findSymbol(&index, SYMBOL_GLOBAL, 3, 6);
+    int sum = findSymbol(&index, SYMBOL_LOCAL, 5, 10);
+    mu_assert("A definition is missing.",
+              limit >= 0 && fact >= 0 && n >= 0 && total >= 0 && sum >= 0);
+    mu_assert("Wrong name.", strcmp(index.symbols[sum].name, "sum") == 0);
+
+    struct {
+        int line;
+        int column;
+        int definition;
+    } refs[] = {
+        {2, 22, n},       // A parameter
+        {2, 35, fact},    // A global used before its definition ends
+        {2, 15, -1},      // A native
+        {5, 19, limit},   // A const
+        {6, 11, total},   // The target of a set!
+        {6, 17, sum},     // A local
+        {7, 12, sum},     // A captured local
+    };
+    for (size_t i = 0; i < sizeof(refs) / sizeof(refs[0]); i++) {
+        int ref = findSymbol(&index, SYMBOL_REFERENCE, refs[i].line,
+                             refs[i].column);
+        if (ref < 0 || index.symbols[ref].definition != refs[i].definition) {
+            printf("Reference at %d:%d: expected %d, got %d\n", refs[i].line,
+                   refs[i].column, refs[i].definition,
+                   ref < 0 ? -2 : index.symbols[ref].definition);
+            mu_assert("Unexpected reference.", false);
+        }
+    }
+
+    freeSymbols(&index);
+    destroyVM(vm);
+    return NULL;
+}
+
+// The forms that compile are indexed even if another fails, and the JSON
+// lists the symbols in order.
+static char* test_symbols_json() {
+    VM* vm = newVM(defaultVMOptions());
+    SymbolIndex index = {0};
+    mu_assert("Script should not compile.",
+              !indexSymbols(vm, "(let a 1)\n(- )\n(print a)", &index));
+
+    char* json = NULL;
+    size_t len = 0;
+    FILE* out = open_memstream(&json, &len);
+    writeSymbolsJson(&index, out);
+    fclose(out);
+    const char* expected =
+        "{\n"
+        "  \"symbols\": [\n"
+        "    {\"name\": \"a\", \"kind\": \"global\", \"line\": 1, "
+        "\"column\": 6},\n"
+        "    {\"name\": \"print\", \"kind\": \"reference\", \"line\": 3, "
+        "\"column\": 2, \"definition\": null},\n"
+        "    {\"name\": \"a\", \"kind\": \"reference\", \"line\": 3, "
+        "\"column\": 8, \"definition\": 0}\n"
+        "  ]\n"
+        "}\n";
+    bool ok = strcmp(json, expected) == 0;
+    if (!ok) printf("Got:\n%s", json);
+    free(json);
+    freeSymbols(&index);
+    destroyVM(vm);
+    mu_assert("Unexpected JSON.", ok);
+    return NULL;
+}
+
+void symbols_suite() {
+    printf("\n--- Symbols Suite ---\n");
+    mu_run_test(test_symbols_resolve);
+    mu_run_test(test_symbols_json);
+}
@@ -117,10 +117,53 @@ static char* test_table_remove_key(void) {
     return NULL;
 }
 
+static char* test_table_find_by_prefix(void) {
+    Table table;
+    initTable(&table);
+
+    const char* names[] = {"str", "Strip", "string?", "len", "s"};
+    ObjString* keys[5];
+    for (int i = 0; i < 5; i++) {
+        keys[i] = newObjString(names[i], strlen(names[i]));
+        tableInsert(&table, newObjValue((Obj*)keys[i]), INT_VAL(i));
+    }
+    tableInsert(&table, INT_VAL(7), INT_VAL(7));  // non-string keys are skipped
+
+    size_t cnt;
+    ObjString** found = tableFindByPrefix(&table, "str", false, &cnt);
+    mu_assert("case-sensitive prefix count", cnt == 2);
+    mu_assert("results are sorted",
+              strcmp(found[0]->chars, "str") == 0 &&
+                  strcmp(found[1]->chars, "string?") == 0);
+    free(found);
+
+    found = tableFindByPrefix(&table, "STR", true, &cnt);
+    mu_assert("case-insensitive prefix count", cnt == 3);
+    free(found);
+
+    found = tableFindByPrefix(&table, "", false, &cnt);
+    mu_assert("empty prefix lists all string keys", cnt == 5);
+    free(found);
+
+    TableEntry* entry = tableFindIgnoreCase(&table, "STRIP");
+    mu_assert("case-insensitive lookup finds key",
+              entry != NULL && AS_INT(entry->value) == 1);
+    mu_assert("case-insensitive lookup misses prefix",
+              tableFindIgnoreCase(&table, "stri") == NULL);
+
+    for (int i = 0; i < 5; i++) {
+        free(keys[i]->chars);
+        free(keys[i]);
+    }
+    freeTable(&table);
+    return NULL;
+}
+
 void table_suite(void) {
     printf("--- Table Suite ---\n");
     mu_run_test(test_table_initTable);
     mu_run_test(test_table_insert_and_get);
     mu_run_test(test_table_insert_and_get_nonexistent_key);
     mu_run_test(test_table_remove_key);
+    mu_run_test(test_table_find_by_prefix);
 }
@@ -14,6 +14,7 @@ typedef enum {
     EXPECT_REAL,
     EXPECT_STRING,
     EXPECT_LIST,
+    EXPECT_TUPLE,
     EXPECT_PAIR,
     EXPECT_DICT,
     EXPECT_ERROR,
@@ -79,6 +80,17 @@ static char* assert_list(Value value, const char* expected_str) {
     return NULL;
 }
 
+static char* assert_tuple(Value value, const char* expected_str) {
+    mu_assert("Value is not an object.", IS_OBJ(value));
+    mu_assert("Object is not a tuple.", OBJ_TYPE(value) == OBJ_TUPLE);
+
+    char* str = sprintValue(value);
+    mu_assert("Tuple string representation does not match expected.",
+              strcmp(str, expected_str) == 0);
+    free(str);
+    return NULL;
+}
+
 static char* assert_pair(Value value, const char* expected_str) {
     mu_assert("Value is not an object.", IS_OBJ(value));
     mu_assert("Object is not a pair.", OBJ_TYPE(value) == OBJ_PAIR);
@@ -16,10 +16,22 @@ void vm_suite(void);
 void module_suite(void);
 void modules_core_suite(void);
 void modules_list_suite(void);
+void modules_fs_suite(void);
+void modules_io_suite(void);
 void modules_math_suite(void);
+void modules_rand_suite(void);
 void modules_re_suite(void);
+void modules_time_suite(void);
 void str_suite(void);
 void regex_suite(void);
+void repr_suite(void);
+void fmt_suite(void);
+void explore_suite(void);
+void symbols_suite(void);
+void lsp_suite(void);
+void eval_suite(void);
+void testrun_suite(void);
+void bench_suite(void);
 
 int main(int argc, char** argv) {
     (void)argc;
@@ -37,8 +49,20 @@ int main(int argc, char** argv) {
     modules_list_suite();
     str_suite();
     modules_math_suite();
+    modules_fs_suite();
+    modules_io_suite();
+    modules_rand_suite();
     modules_re_suite();
+    modules_time_suite();
     regex_suite();
+    repr_suite();
+    fmt_suite();
+    explore_suite();
+    symbols_suite();
+    lsp_suite();
+    eval_suite();
+    testrun_suite();
+    bench_suite();
 
     printf("\n---------------------------\n");
     if (result == 0) {
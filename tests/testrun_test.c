@@ -0,0 +1,78 @@
+#define _POSIX_C_SOURCE 200809L
+
+#include "testrun.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+#include "minunit.h"
+#include "vm.h"
+
+static void writeFile(const char* dir, const char* name, const char* src) {
+    char path[256];
+    snprintf(path, sizeof(path), "%s/%s", dir, name);
+    FILE* file = fopen(path, "w");
+    fputs(src, file);
+    fclose(file);
+}
+
+static void removeFile(const char* dir, const char* name) {
+    char path[256];
+    snprintf(path, sizeof(path), "%s/%s", dir, name);
+    remove(path);
+}
+
+// Runs a directory holding a passing and failing file, a nested file that
+// does not load and a file that is not a test, and checks the report.
+static char* test_testrun_report() {
+    char dir[] = "/tmp/liss_testrun_XXXXXX";
+    mu_assert("Could not create a temporary directory.",
+              mkdtemp(dir) != NULL);
+    char sub[64];
+    snprintf(sub, sizeof(sub), "%s/sub", dir);
+    mkdir(sub, 0700);
+    writeFile(dir, "a_test.liss",
+              "(import test [\"deftest\"])\n"
+              "(deftest \"adds\" (fn [] (assert_eq (+ 1 2) 3)))\n"
+              "(deftest \"compares\" (fn [] (assert_eq 1 2 \"one\")))\n"
+              "(deftest \"errs\" (fn [] (err \"returned\")))\n");
+    writeFile(dir, "helper.liss", "(raise! (err \"not a test\"))\n");
+    writeFile(sub, "b_test.liss", "(raise! (err \"broken\"))\n");
+
+    char* output = NULL;
+    size_t len = 0;
+    FILE* out = open_memstream(&output, &len);
+    int status = runTests(dir, defaultVMOptions(), out);
+    fclose(out);
+
+    char expected[1024];
+    snprintf(expected, sizeof(expected),
+             "%s/a_test.liss\n"
+             "  ok   adds\n"
+             "  FAIL compares: assert_eq failed: one (1 != 2)\n"
+             "  FAIL errs: returned\n"
+             "%s/b_test.liss\n"
+             "  FAIL <load>: broken\n"
+             "1 passed, 3 failed\n",
+             dir, sub);
+    bool same = strcmp(output, expected) == 0;
+    if (!same) printf("Got:\n%s", output);
+    free(output);
+
+    removeFile(sub, "b_test.liss");
+    rmdir(sub);
+    removeFile(dir, "a_test.liss");
+    removeFile(dir, "helper.liss");
+    rmdir(dir);
+    mu_assert("Unexpected test report.", same);
+    mu_assert("Failures should make the status 1.", status == 1);
+    return NULL;
+}
+
+void testrun_suite() {
+    printf("\n--- Test Runner Suite ---\n");
+    mu_run_test(test_testrun_report);
+}
@@ -1,10 +1,14 @@
 #include "vm.h"
 
+#include <pthread.h>
 #include <signal.h>
 #include <stdlib.h>
 #include <string.h>
+#include <time.h>
 
 #include "common.h"
+#include "compiler.h"
+#include "gc.h"
 #include "minunit.h"
 #include "test_common.h"
 #include "value.h"
@@ -39,6 +43,11 @@ static char* test_vm_stack(void) {
     return NULL;
 }
 
+// Nested data shared by the accessor tests.
+#define ACCESS_DATA                                         \
+    "(let data (dict (\"users\" . [(dict (\"name\" . \"ann\") " \
+    "(\"tags\" . [\"a\" \"b\"]))]))) "
+
 static VMTestCase interpret_tests[] = {
     {
         .name = "literal number",
@@ -64,12 +73,129 @@ static VMTestCase interpret_tests[] = {
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_REAL, .as.real = 3.5},
     },
+    {
+        .name = "int + real promotes regardless of operand order",
+        .src = "(let i 1) (let r 2.5) (+ (+ i r) (+ r i))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_REAL, .as.real = 7.0},
+    },
+    {
+        .name = "variadic addition promotes once a real appears",
+        .src = "(+ 1 2 3.5 4)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_REAL, .as.real = 10.5},
+    },
+    {
+        .name = "variadic addition starting with a real",
+        .src = "(+ 0.5 2 3)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_REAL, .as.real = 5.5},
+    },
+    {
+        .name = "variadic addition of ints stays int",
+        .src = "(+ 1 2 3 4)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 10},
+    },
     {
         .name = "real + real",
         .src = "(+ 1.5 2.5)",
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_REAL, .as.real = 4.0},
     },
+    {
+        .name = "adding a string to a number names the right operand",
+        .src = "(try (+ 1 \"a\"))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: right operand of + must "
+                                        "be a number, got string"},
+    },
+    {
+        .name = "adding a number to a string names the right operand",
+        .src = "(try (+ \"a\" 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: right operand of + must "
+                                        "be a string, got int"},
+    },
+    {
+        .name = "adding to null names the left operand",
+        .src = "(try (+ null 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: left operand of + must "
+                                        "be a number or a string, got nil"},
+    },
+    {
+        .name = "repeating a string needs an int count",
+        .src = "(try (* \"ab\" 1.5))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: right operand of * must "
+                                        "be an int, got real"},
+    },
+    {
+        .name = "subtracting a bool",
+        .src = "(try (- 1 true))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: right operand of - must "
+                                        "be a number, got bool"},
+    },
+    {
+        .name = "dividing a list",
+        .src = "(try (/ [1] 2))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: left operand of / must "
+                                        "be a number, got list"},
+    },
+    {
+        .name = "modulo needs ints",
+        .src = "(try (% 5 2.0))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: right operand of % must "
+                                        "be an int, got real"},
+    },
+    {
+        .name = "bitwise and needs ints",
+        .src = "(try (&& 1 2.0))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: right operand of && must "
+                                        "be an int, got real"},
+    },
+    {
+        .name = "negating a string",
+        .src = "(let s \"a\") (try -s)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: operand of - must be a "
+                                        "number, got string"},
+    },
+    {
+        .name = "integer division by zero",
+        .src = "(try (/ 1 0))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Arithmetic error: integer division "
+                                        "by zero"},
+    },
+    {
+        .name = "integer modulo by zero",
+        .src = "(try (% 1 0))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Arithmetic error: integer modulo by "
+                                        "zero"},
+    },
+    {
+        .name = "arithmetic type error without try",
+        .src = "(+ 1 \"a\")",
+        .expected_result = INTERPRET_RUNTIME_ERROR,
+    },
     {
         .name = "int * int",
         .src = "(* 3 4)",
@@ -172,6 +298,75 @@ static VMTestCase interpret_tests[] = {
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_BOOL, .as.boolean = true},
     },
+    {
+        .name = "empty AND is true",
+        .src = "(and)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_BOOL, .as.boolean = true},
+    },
+    {
+        .name = "empty OR is false",
+        .src = "(or)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_BOOL, .as.boolean = false},
+    },
+    {
+        .name = "AND leaves only its result on the stack",
+        .src = "(+ (and true 1) (and 2 3) (or false 4))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 8},
+    },
+    {
+        .name = "AND stops at the first falsy operand",
+        .src = "(and 1 null 3)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "empty addition is 0",
+        .src = "(+)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 0},
+    },
+    {
+        .name = "empty multiplication is 1",
+        .src = "(*)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 1},
+    },
+    {
+        .name = "single operand addition",
+        .src = "(+ 2.5)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_REAL, .as.real = 2.5},
+    },
+    {
+        .name = "single operand multiplication",
+        .src = "(* 7)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 7},
+    },
+    {
+        .name = "single operand subtraction negates",
+        .src = "(- 7)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = -7},
+    },
+    {
+        .name = "empty subtraction does not compile",
+        .src = "(-)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "single operand division does not compile",
+        .src = "(/ 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "single operand comparison does not compile",
+        .src = "(< 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
     {
         .name = "BAND kw expression",
         .src = "(band 3 7)",
@@ -383,23 +578,41 @@ static VMTestCase interpret_tests[] = {
         .name = "basic let expression",
         .src = "(let x 10)",
         .expected_result = INTERPRET_OK,
-        .expected_value = {EXPECT_INT, .as.integer = 10},
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "a script ending in a named fn is null",
+        .src = "(fn f [] 1)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "a script ending in a const is null",
+        .src = "(const k 1)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "a named fn keeps its value inside an expression",
+        .src = "(let g (fn f [] 7)) (g)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 7},
     },
     {
         .name = "let expression with arithmetic",
-        .src = "(let y (+ 5 5))",
+        .src = "(let y (+ 5 5)) y",
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_INT, .as.integer = 10},
     },
     {
         .name = "let expression with boolean",
-        .src = "(let flag true)",
+        .src = "(let flag true) flag",
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_BOOL, .as.boolean = true},
     },
     {
         .name = "let expression with get global",
-        .src = "(let a 42) (let b (+ a 1))",
+        .src = "(let a 42) (let b (+ a 1)) b",
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_INT, .as.integer = 43},
     },
@@ -557,6 +770,41 @@ static VMTestCase interpret_tests[] = {
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_STRING, .as.string = "done"},
     },
+    {
+        .name = "anonymous fn bound by a local let calls itself",
+        .src = "(fn outer []"
+               "  (let count (fn [n] (cond (= n 0) 0 (+ 1 (count (- n 1))))))"
+               "  (count 4))"
+               "(outer)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 4},
+    },
+    {
+        .name = "anonymous fn bound by a local let tail-calls itself",
+        .src = "(fn outer []"
+               "  (let down (fn [n] (cond (= n 0) \"done\" (down (- n 1)))))"
+               "  (down 100000))"
+               "(outer)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "done"},
+    },
+    {
+        .name = "named fn bound by a local let",
+        .src = "(fn outer [] (let g (fn h [] 1)) [(g) (h)]) (outer)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[1 1]"},
+    },
+    {
+        .name = "local let bound to a let",
+        .src = "(fn f [] (let x (let y 5)) [x y]) (f)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[5 5]"},
+    },
+    {
+        .name = "local let cannot rebind a name by a recursive fn",
+        .src = "(fn f [] (let g 1) (let g (fn [] (g))))",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
     {
         .name = "unhandled raise! should cause a runtime error",
         .src = "(raise! \"did you miss me?\")",
@@ -580,6 +828,245 @@ static VMTestCase interpret_tests[] = {
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_ERROR, .as.string = "oops"},
     },
+    {
+        .name = "try unwinds nested calls",
+        .src = "(fn boom [n] (cond (= n 0) (raise! (err \"deep\"))"
+               " (+ 1 (boom (- n 1))))) (try (boom 5))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR, .as.string = "deep"},
+    },
+    {
+        .name = "nested try catches in the inner handler",
+        .src = "(try [(try (raise! (err \"inner\"))) 1])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[<error: inner> 1]"},
+    },
+    {
+        .name = "try restores the stack below the handler",
+        .src = "[1 (try (+ 2 (raise! (err \"x\")))) 3]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[1 <error: x> 3]"},
+    },
+    {
+        .name = "a function defined later shadows a native",
+        .src = "(fn g [] (len 1 2)) (fn len [a b] a) (g)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 1},
+    },
+    {
+        .name = "try catches a native's error from nested calls",
+        .src = "(fn deep [n] (cond (= n 0) (get [1] 5)"
+               " (+ 1 (deep (- n 1))))) (try (deep 4))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "list index out of bounds"},
+    },
+    {
+        .name = "try closes the upvalues of the frames it unwinds",
+        .src = "(fn deep [n] (cond (= n 0) (get [1] 5)"
+               " (+ 1 (deep (- n 1)))))"
+               "(let saved null)"
+               "(fn outer [] (let x 5) (set! saved (fn [] x)) (deep 3))"
+               "[(is_err? (try (outer))) (saved)]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[true 5]"},
+    },
+    {
+        .name = "a finished try no longer handles raises",
+        .src = "(try 1) (raise! (err \"after\"))",
+        .expected_result = INTERPRET_RUNTIME_ERROR,
+    },
+    {
+        .name = "return leaves a function early",
+        .src = "(fn sign [n] (cond (< n 0) (return \"neg\"))"
+               "(cond (= n 0) (return \"zero\")) \"pos\")"
+               "[(sign -2) (sign 0) (sign 7)]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST,
+                           .as.string = "[\"neg\" \"zero\" \"pos\"]"},
+    },
+    {
+        .name = "return from inside a loop and an expression",
+        .src = "(fn has [xs x] (for [v xs] (cond (= v x) (return true))) false)"
+               "(fn mid [] (+ 1 (return 2)))"
+               "[(has [1 2 3] 2) (has [1 2 3] 9) (mid)]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[true false 2]"},
+    },
+    {
+        .name = "return without a value gives null",
+        .src = "(fn f [] (return) 1) (f)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "return closes the try blocks it leaves",
+        .src = "(fn f [] (try (return 1))) (fn g [] (f) (raise! (err \"out\")))"
+               "(try (g))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR, .as.string = "out"},
+    },
+    {
+        .name = "return of a call is a tail call",
+        .src = "(fn count [n acc] (cond (= n 0) (return acc))"
+               "(return (count (- n 1) (+ acc 1)))) (count 100000 0)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 100000},
+    },
+    {
+        .name = "return outside of a function does not compile",
+        .src = "(return 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "try inside a function called twice",
+        .src = "(fn safe [x] (try (/ 10 x))) [(safe 0) (safe 5)]",
+        .expected_result = INTERPRET_OK,
+        .expected_value =
+            {EXPECT_LIST,
+             .as.string =
+                 "[<error: Arithmetic error: integer division by zero> 2]"},
+    },
+    {
+        .name = "while sums a counter",
+        .src = "(let i 0) (let total 0)"
+               " (while (< i 5) (set! total (+ total i)) (set! i (+ i 1)))"
+               " total",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 10},
+    },
+    {
+        .name = "while evaluates to the last body value",
+        .src = "(let i 0) [(while (< i 3) (set! i (+ i 1)) (* i 10))"
+               " (while false 1)]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[30 null]"},
+    },
+    {
+        .name = "while ending a script whose jump operand looks like a call",
+        .src = "(let i 0) (while (< i 3) (set! i (+ i 1)))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 3},
+    },
+    {
+        .name = "while with lets in a function",
+        .src = "(fn squares [n] (let acc 0)"
+               " (while (> n 0) (let sq (* n n)) (set! acc (+ acc sq))"
+               " (set! n (- n 1)))"
+               " acc) (squares 3)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 14},
+    },
+    {
+        .name = "nested while loops",
+        .src = "(fn grid [] (let out 0) (let a 0)"
+               " (while (< a 3) (let b 0)"
+               " (while (< b 4) (set! out (+ out 1)) (set! b (+ b 1)))"
+               " (set! a (+ a 1)))"
+               " out) (grid)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 12},
+    },
+    {
+        .name = "for over a list",
+        .src = "(let total 0) (for [x [1 2 3]] (set! total (+ total x))) total",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 6},
+    },
+    {
+        .name = "for over a string by character",
+        .src = "(import list) (let out [])"
+               " (for [c \"h\xc3\xa9!\"] (set! out (list:cons out c))) out",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST,
+                           .as.string = "[\"!\" \"\xc3\xa9\" \"h\"]"},
+    },
+    {
+        .name = "for over dict entries, a tuple and an integer",
+        .src = "[(for [e (dict (\"a\" . 1) (\"b\" . 2))] e)"
+               " (for [t #[7 8]] t) (for [i 4] (* i i))]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[[\"b\" 2] 8 9]"},
+    },
+    {
+        .name = "for over an empty collection evaluates to null",
+        .src = "[(for [x []] x) (for [c \"\"] c) (for [i 0] i)]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[null null null]"},
+    },
+    {
+        .name = "for with lets in a function",
+        .src = "(fn sum [xs] (let acc 0)"
+               " (for [x xs] (let d (* x 2)) (set! acc (+ acc d)))"
+               " acc) (sum [1 2 3])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 12},
+    },
+    {
+        .name = "for binds a fresh variable per iteration",
+        .src = "(import list) (fn mk [] (let fs [])"
+               " (for [i 3] (set! fs (list:cons fs (fn [] i)))) fs)"
+               " (let out []) (for [f (mk)] (set! out (list:cons out (f))))"
+               " out",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[0 1 2]"},
+    },
+    {
+        .name = "for as a call argument in a function",
+        .src = "(fn f [n] (+ n (for [x [1 2 3]] (* x n)))) (f 10)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 40},
+    },
+    {
+        .name = "block lets inside call arguments",
+        .src = "(fn f [a] [a ((let b 2) (+ a b)) (+ a ((let c 3) c))]) (f 1)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[1 3 4]"},
+    },
+    {
+        .name = "for over a non-collection is a runtime error",
+        .src = "(try (for [x 1.5] x))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: for expects a list, "
+                                        "tuple, string, dict or integer, got "
+                                        "real"},
+    },
+    {
+        .name = "const values are evaluated at compile time",
+        .src = "(const PI 3.5) (const TAU (* 2 PI))"
+               " (const NAME (+ \"li\" \"ss\")) (const MASK (bor (bsl 1 4) 1))"
+               " (const OK (not (< TAU PI)))"
+               " (fn area [r] (* PI r r)) [(area 2) TAU NAME MASK OK]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST,
+                           .as.string = "[14 7 \"liss\" 17 true]"},
+    },
+    {
+        .name = "const rejects a value that needs the VM",
+        .src = "(const N (len \"abc\"))",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "const rejects a failing operation",
+        .src = "(const N (/ 1 0))",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "const is only allowed at the top level",
+        .src = "(fn f [] (const N 1))",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "const cannot be set!",
+        .src = "(const N 1) (set! N 2)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "const cannot redeclare a global",
+        .src = "(let N 1) (const N 2)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
     {
         .name = "empty list expression",
         .src = "[]",
@@ -738,42 +1225,147 @@ static VMTestCase interpret_tests[] = {
         .expected_value = {EXPECT_STRING, .as.string = "many"},
     },
     {
-        .name = "switch with deconstructing err match",
-        .src = "(switch (err \"oops\")"
-               "[(err msg) msg]"
-               "[* \"ok\"])",
+        .name = "switch evaluates its subject once",
+        .src = "(let n 0) (fn next [] (set! n (+ n 1)))"
+               "[(switch (next) [5 \"five\"] [2 \"two\"] [1 \"one\"]"
+               "[* \"many\"]) n]",
         .expected_result = INTERPRET_OK,
-        .expected_value = {EXPECT_STRING, .as.string = "oops"},
+        .expected_value = {EXPECT_LIST, .as.string = "[\"one\" 1]"},
     },
     {
-        .name = "switch with deconstructing err wildcard match",
-        .src = "(switch null"
-               "[(err msg) msg]"
-               "[* \"ok\"])",
+        .name = "switch arms with several values and comparisons",
+        .src = "(fn f [n] (switch n [(1 2 3) \"small\"] [(< 10) \"lt ten\"]"
+               "[(>= 100) \"huge\"] [(10 (* 2 10)) \"round\"]"
+               "[* \"other\"]))"
+               "[(f 2) (f 5) (f 10) (f 20) (f 500) (f 50)]",
         .expected_result = INTERPRET_OK,
-        .expected_value = {EXPECT_STRING, .as.string = "ok"},
+        .expected_value = {EXPECT_LIST,
+                           .as.string = "[\"small\" \"lt ten\" \"round\" "
+                                        "\"round\" \"huge\" \"other\"]"},
     },
     {
-        .name = "switch with deconstructing pair match",
-        .src = "(switch (1 . 2)"
-               "[(pair a b) (+ a b)]"
-               "[* 0])",
+        .name = "switch table holds every value of an arm",
+        .src = "(fn f [x] (switch x [(1 2) \"a\"] [(2 3) \"b\"] [4 \"c\"]"
+               "[5 \"d\"] [* null])) [(f 2) (f 3) (f 5) (f 6)]",
         .expected_result = INTERPRET_OK,
-        .expected_value = {EXPECT_INT, .as.integer = 3},
+        .expected_value = {EXPECT_LIST,
+                           .as.string = "[\"a\" \"b\" \"d\" null]"},
     },
     {
-        .name = "switch with deconstructing pair wildcard match",
-        .src = "(switch null"
-               "[(pair a b) (+ a b)]"
-               "[* 0])",
-        .expected_result = INTERPRET_OK,
-        .expected_value = {EXPECT_INT, .as.integer = 0},
+        .name = "switch arm without values does not compile",
+        .src = "(switch 1 [() 0])",
+        .expected_result = INTERPRET_COMPILE_ERROR,
     },
     {
-        .name = "pipe single step",
-        .src = "(import str)(-> \"  hello  \" (str:trim))",
+        .name = "switch table jumps to the first matching arm",
+        .src = "(switch \"c\""
+               "[1 \"one\"] [\"b\" \"bee\"] [\"c\" \"sea\"] [4 \"four\"]"
+               "[\"c\" \"again\"] [* \"many\"])",
         .expected_result = INTERPRET_OK,
-        .expected_value = {EXPECT_STRING, .as.string = "hello"},
+        .expected_value = {EXPECT_STRING, .as.string = "sea"},
+    },
+    {
+        .name = "switch table falls through on a miss",
+        .src = "(switch 7 [1 1] [2 2] [3 3] [4 4] [n (* n 10)])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 70},
+    },
+    {
+        .name = "switch table keeps arms after a real literal in order",
+        .src = "(fn f [x] (switch x [1 \"a\"] [2 \"b\"] [3 \"c\"] [4 \"d\"]"
+               "[5.0 \"real\"] [5 \"int\"] [* null]))"
+               "[(f 5) (f 5.0) (f 3) (f \"3\")]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST,
+                           .as.string = "[\"int\" \"real\" \"c\" null]"},
+    },
+    {
+        .name = "nested switch tables",
+        .src = "(switch 2 [1 0] [2 (switch \"y\" [\"w\" 1] [\"x\" 2]"
+               "[\"y\" 3] [\"z\" 4])] [3 0] [4 0])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 3},
+    },
+    {
+        .name = "switch table without a default gives null",
+        .src = "(switch \"q\" [1 1] [2 2] [3 3] [4 4])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "switch with deconstructing err match",
+        .src = "(switch (err \"oops\")"
+               "[(err msg) msg]"
+               "[* \"ok\"])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "oops"},
+    },
+    {
+        .name = "switch with deconstructing err wildcard match",
+        .src = "(switch null"
+               "[(err msg) msg]"
+               "[* \"ok\"])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "ok"},
+    },
+    {
+        .name = "switch with deconstructing pair match",
+        .src = "(switch (1 . 2)"
+               "[(pair a b) (+ a b)]"
+               "[* 0])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 3},
+    },
+    {
+        .name = "switch with deconstructing pair wildcard match",
+        .src = "(switch null"
+               "[(pair a b) (+ a b)]"
+               "[* 0])",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 0},
+    },
+    {
+        .name = "approx equal with default epsilon",
+        .src = "(~= (+ 0.1 0.2) 0.3)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_BOOL, .as.boolean = true},
+    },
+    {
+        .name = "approx equal rejects distant values",
+        .src = "(~= 1.0 1.001)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_BOOL, .as.boolean = false},
+    },
+    {
+        .name = "approx equal with explicit epsilon",
+        .src = "(approx 1.0 1.001 0.01)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_BOOL, .as.boolean = true},
+    },
+    {
+        .name = "approx equal mixes ints and reals",
+        .src = "(~= 3 3.0000000001)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_BOOL, .as.boolean = true},
+    },
+    {
+        .name = "approx equal on non-numbers is an error",
+        .src = "(~= \"a\" 1)",
+        .expected_result = INTERPRET_RUNTIME_ERROR,
+    },
+    {
+        .name = "approx equal on non-numbers can be caught",
+        .src = "(try (~= \"a\" 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: `~=` operands must be "
+                                        "numbers"},
+    },
+    {
+        .name = "pipe single step",
+        .src = "(import str)(-> \"  hello  \" (str:trim))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "hello"},
     },
     {
         .name = "pipe multi step",
@@ -794,6 +1386,275 @@ static VMTestCase interpret_tests[] = {
         .expected_result = INTERPRET_OK,
         .expected_value = {EXPECT_ERROR, .as.string = "bad"},
     },
+    {
+        .name = "set! a global",
+        .src = "(let x 1) (set! x 2) x",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 2},
+    },
+    {
+        .name = "set! returns the new value",
+        .src = "(let x 1) (set! x 5)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 5},
+    },
+    {
+        .name = "set! a local",
+        .src = "((let x 1) (set! x (+ x 1)) x)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 2},
+    },
+    {
+        .name = "set! a captured variable",
+        .src = "(fn mk [] (let n 0) (fn [] (set! n (+ n 1)))) "
+               "(let c (mk)) (c) (c)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 2},
+    },
+    {
+        .name = "set! a block variable captured by a closure",
+        .src = "(let c ((let n 0) (fn [] (set! n (+ n 1))))) (c) (c) (c)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 3},
+    },
+    {
+        .name = "closure sees a later set! in its defining frame",
+        .src = "(fn f [] (let x 1) (fn g [] x) (set! x 5) (g)) (f)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 5},
+    },
+    {
+        .name = "closures share a captured variable after their frame returns",
+        .src = "(fn two [] (let n 1) [(fn [] (set! n (+ n 1)))"
+               "(fn [] (set! n (* n 10)))])"
+               "(let p (two)) ((get p 0)) [((get p 1)) ((get p 0))]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[20 21]"},
+    },
+    {
+        .name = "set! through an enclosing closure reaches the defining frame",
+        .src = "(fn outer [] (let n 0) (fn mid [] (fn [] (set! n (+ n 10))))"
+               "(let bump (mid)) (bump) (set! n (+ n 1)) (bump) n) (outer)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 21},
+    },
+    {
+        .name = "set! an undefined variable",
+        .src = "(set! y 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "set! a core function",
+        .src = "(set! len 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "set! a module-qualified name",
+        .src = "(import str) (set! str:upper 1)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "let cannot rebind a global",
+        .src = "(let x 1) (let x 2)",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "accessor on a dict key",
+        .src = "(.name (dict (\"name\" . \"ann\")))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "ann"},
+    },
+    {
+        .name = "accessor path through dicts and lists",
+        .src = ACCESS_DATA "(.users[0].tags[1] data)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "b"},
+    },
+    {
+        .name = "accessor bound with let",
+        .src = ACCESS_DATA "(let v (.users[0].name data)) v",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "ann"},
+    },
+    {
+        .name = "accessor on a missing key is null",
+        .src = ACCESS_DATA "(.users[0].address.city data)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "accessor path must start with a name",
+        .src = "(.[1] [1 2])",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "accessor index out of bounds is null",
+        .src = ACCESS_DATA "(.users[1] data)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "accessor path goes on past a missing index",
+        .src = ACCESS_DATA "(.users[5].name data)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_NIL},
+    },
+    {
+        .name = "accessor name on a list",
+        .src = ACCESS_DATA "(.users.name data)",
+        .expected_result = INTERPRET_RUNTIME_ERROR,
+    },
+    {
+        .name = "accessor error is caught by try",
+        .src = "(try (.name 42))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "Type error: cannot access .name on "
+                                        "a int"},
+    },
+    {
+        .name = "tuple index out of bounds",
+        .src = "(try (get #[1 2] 2))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "tuple index out of bounds"},
+    },
+    {
+        .name = "to_tuple of a non-list",
+        .src = "(try (to_tuple 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "to_tuple: expected list or tuple"},
+    },
+    {
+        .name = "unclosed tuple literal",
+        .src = "#[1 2",
+        .expected_result = INTERPRET_COMPILE_ERROR,
+    },
+    {
+        .name = "deeply nested list as a dict key",
+        .src = "(let xs [1]) (let i 0)"
+               " (while (< i 70) (set! xs [xs]) (set! i (+ i 1)))"
+               " (try (put (dict) xs 0))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "put: key is nested too deeply"},
+    },
+    {
+        .name = "from_hex rejects an odd number of digits",
+        .src = "(try (from_hex \"abc\"))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "from_hex: invalid hex string"},
+    },
+    {
+        .name = "bytes rejects list items above 255",
+        .src = "(try (bytes [1 256]))",
+        .expected_result = INTERPRET_OK,
+        .expected_value =
+            {EXPECT_ERROR,
+             .as.string = "bytes: list items must be ints from 0 to 255"},
+    },
+    {
+        .name = "byte_at out of bounds",
+        .src = "(try (byte_at (bytes \"a\") 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "byte_at: index out of bounds"},
+    },
+    {
+        .name = "format checks argument types",
+        .src = "(try (format \"%d\" 1.5))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "format: %d expects an int, got real"},
+    },
+    {
+        .name = "format checks the argument count",
+        .src = "(try (format \"%s and %s\" \"a\"))",
+        .expected_result = INTERPRET_OK,
+        .expected_value =
+            {EXPECT_ERROR,
+             .as.string = "format: not enough arguments for the format string"},
+    },
+    {
+        .name = "format rejects extra arguments",
+        .src = "(try (format \"%v\" 1 2))",
+        .expected_result = INTERPRET_OK,
+        .expected_value =
+            {EXPECT_ERROR,
+             .as.string = "format: too many arguments for the format string"},
+    },
+    {
+        .name = "format rejects unknown directives",
+        .src = "(try (format \"%x\" 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "format: unknown directive '%x'"},
+    },
+    {
+        .name = "calling a list indexes it",
+        .src = "(let xs [10 20 30]) (+ (xs 0) (xs -1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 40},
+    },
+    {
+        .name = "calling a dict looks up a key",
+        .src = "(let d (dict (\"a\" . 1))) [(d \"a\") (d \"b\")]",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_LIST, .as.string = "[1 null]"},
+    },
+    {
+        .name = "calling a string in tail position",
+        .src = "(let s \"abc\") (fn at [i] (s i)) (at 1)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_STRING, .as.string = "b"},
+    },
+    {
+        .name = "calling a list out of bounds",
+        .src = "(let xs [1]) (try (xs 1))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_ERROR,
+                           .as.string = "list index out of bounds"},
+    },
+    {
+        .name = "calling a dict with two arguments",
+        .src = "(let d (dict)) (try (d 1 2))",
+        .expected_result = INTERPRET_OK,
+        .expected_value =
+            {EXPECT_ERROR,
+             .as.string = "Calling a dict takes 1 argument but got 2"},
+    },
+    {
+        .name = "call a function looked up in a dict",
+        .src = "(let h (dict (\"x\" . (fn [a] (* a 2))))) ((get h \"x\") 21)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 42},
+    },
+    {
+        .name = "call the result of a call",
+        .src = "(fn adder [n] (fn [m] (+ n m))) ((adder 1) 2)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 3},
+    },
+    {
+        .name = "call a call result in tail position",
+        .src = "(fn adder [n] (fn [m] (+ n m)))"
+               " (fn f [x] ((adder x) 10)) (f 5)",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 15},
+    },
+    {
+        .name = "a pair with a call as its head",
+        .src = "(fn one [] 1) (fst ((one) . 2))",
+        .expected_result = INTERPRET_OK,
+        .expected_value = {EXPECT_INT, .as.integer = 1},
+    },
+    {
+        .name = "calling a call result that is not a function",
+        .src = "(fn one [] 1) ((one) 2)",
+        .expected_result = INTERPRET_RUNTIME_ERROR,
+    },
 };
 
 static char* test_vm_interpret(void) {
@@ -844,6 +1705,9 @@ static char* test_vm_interpret(void) {
                 case EXPECT_LIST:
                     assert_msg = assert_list(actual, expected.as.string);
                     break;
+                case EXPECT_TUPLE:
+                    assert_msg = assert_tuple(actual, expected.as.string);
+                    break;
                 case EXPECT_PAIR:
                     assert_msg = assert_pair(actual, expected.as.string);
                     break;
@@ -865,9 +1729,969 @@ static char* test_vm_interpret(void) {
     return NULL;
 }
 
+static char* test_vm_metrics(void) {
+    VMOptions options = defaultVMOptions();
+    options.profile = true;
+    VM* vm = newVM(options);
+    mu_assert("Failed to create VM", vm != NULL);
+
+    InterpretResult result =
+        interpret(vm, "(fn inc [x] (+ x 1)) (inc 1) (inc 2) (inc 3)", NULL);
+    mu_assert("Interpretation failed", result == INTERPRET_OK);
+
+    VMMetrics* metrics = &vm->metrics;
+    mu_assert("OP_ADD should run once per call", metrics->op_cnt[OP_ADD] == 3);
+    // The last top-level call is compiled as a tail call.
+    mu_assert("Every call should be counted",
+              metrics->op_cnt[OP_CALL] + metrics->op_cnt[OP_TAIL_CALL] == 3);
+    uint64_t total = 0;
+    for (int i = 0; i < OPCODE_CNT; i++) total += metrics->op_cnt[i];
+    mu_assert("Histogram should sum to the instruction count",
+              total == metrics->instr_cnt);
+
+    bool found = false;
+    for (Obj* obj = vm->objects; obj != NULL; obj = obj->next) {
+        if (obj->type != OBJ_FUNCTION) continue;
+        ObjFunction* fn = (ObjFunction*)obj;
+        if (fn->name != NULL && strcmp(fn->name->chars, "inc") == 0) {
+            mu_assert("inc should be called 3 times", fn->call_cnt == 3);
+            found = true;
+        }
+    }
+    mu_assert("inc function not found", found);
+
+    destroyVM(vm);
+    return NULL;
+}
+
+// A formula is compiled once and evaluated again after the globals it reads
+// change.
+static char* test_vm_compile_expr(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    ObjModule* env = newModule(vm, "sheet");
+    push(vm, OBJ_VAL(env));
+    defineConst(vm, env, "price", INT_VAL(3));
+    defineConst(vm, env, "qty", INT_VAL(4));
+
+    ObjClosure* expr = compileExpr(vm, "(+ (mul price qty) 1)", env);
+    mu_assert("Formula should compile", expr != NULL);
+
+    int64_t qtys[] = {4, 10, 0};
+    int64_t expected[] = {13, 31, 1};
+    for (size_t i = 0; i < sizeof(qtys) / sizeof(qtys[0]); i++) {
+        defineConst(vm, env, "qty", INT_VAL(qtys[i]));
+        Value result;
+        mu_assert("Formula should run",
+                  callExpr(vm, expr, &result) == INTERPRET_OK);
+        mu_assert("Unexpected formula value",
+                  IS_INT(result) && AS_INT(result) == expected[i]);
+    }
+    freeExpr(vm, expr);
+
+    mu_assert("Only one expression is allowed",
+              compileExpr(vm, "price qty", env) == NULL);
+    mu_assert("Compile errors are reported",
+              compileExpr(vm, "(+ price", env) == NULL);
+
+    ObjClosure* failing = compileExpr(vm, "(raise! (err \"bad\"))", env);
+    mu_assert("Raising formula should compile", failing != NULL);
+    Value result;
+    mu_assert("Raised errors are reported",
+              callExpr(vm, failing, &result) == INTERPRET_RUNTIME_ERROR);
+    mu_assert("The raised error is kept", IS_ERROR(vm->raise_value));
+    freeExpr(vm, failing);
+    mu_assert("Freed formulas are unpinned", vm->pinned.count == 0);
+
+    pop(vm);
+    destroyVM(vm);
+    return NULL;
+}
+
+// The formula preset refuses anything that reaches the host and stops runaway
+// formulas without ending the VM.
+static char* test_vm_formula_sandbox(void) {
+    VM* vm = newVM(formulaVMOptions());
+    ObjModule* env = newModule(vm, "sheet");
+    push(vm, OBJ_VAL(env));
+
+    mu_assert("io is not available",
+              compileExpr(vm, "(import io)", env) == NULL);
+    mu_assert("Liss files are not available",
+              compileExpr(vm, "(import \"tests/data\")", env) == NULL);
+
+    struct {
+        const char* src;
+        InterpretResult status;
+        const char* msg;
+    } cases[] = {
+        {"(time)", INTERPRET_RUNTIME_ERROR, "Undefined variable 'time'"},
+        {"(resources)", INTERPRET_RUNTIME_ERROR,
+         "Undefined variable 'resources'"},
+        {"((fn spin [n] (spin (+ n 1))) 0)", INTERPRET_BUDGET_EXCEEDED,
+         "Instruction budget"},
+        {"((fn grow [s] (grow (+ s s))) \"ab\")", INTERPRET_RUNTIME_ERROR,
+         "Memory budget"},
+    };
+    for (size_t i = 0; i < sizeof(cases) / sizeof(cases[0]); i++) {
+        ObjClosure* expr = compileExpr(vm, cases[i].src, env);
+        mu_assert("Formula should compile", expr != NULL);
+        Value result;
+        mu_assert("Formula should fail",
+                  callExpr(vm, expr, &result) == cases[i].status);
+        mu_assert("Formula should raise an error",
+                  IS_ERROR(vm->raise_value));
+        mu_assert("Unexpected error message",
+                  strstr(AS_ERROR(vm->raise_value)->message->chars,
+                         cases[i].msg) != NULL);
+        freeExpr(vm, expr);
+    }
+
+    // Budgets apply to one evaluation at a time.
+    ObjClosure* expr = compileExpr(vm, "(+ 1 2)", env);
+    Value result;
+    mu_assert("Formula should run after a budget error",
+              callExpr(vm, expr, &result) == INTERPRET_OK);
+    mu_assert("Unexpected formula value", AS_INT(result) == 3);
+    freeExpr(vm, expr);
+
+    pop(vm);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A script that never ends is stopped by the time budget, which try does not
+// catch. Deterministic mode makes the clock count instructions.
+static char* test_vm_timeout(void) {
+    VMOptions options = defaultVMOptions();
+    options.deterministic = true;
+    options.timeout_ms = 50;
+    VM* vm = newVM(options);
+
+    const char* srcs[] = {
+        "(fn loop [] (loop)) (loop)",
+        "(fn loop [] (loop)) (try (loop))",
+    };
+    for (size_t i = 0; i < sizeof(srcs) / sizeof(srcs[0]); i++) {
+        mu_assert("Script should run out of time",
+                  interpret(vm, srcs[i], NULL) == INTERPRET_BUDGET_EXCEEDED);
+        mu_assert("Unexpected error message",
+                  IS_ERROR(vm->raise_value) &&
+                      strstr(AS_ERROR(vm->raise_value)->message->chars,
+                             "Time budget of 50 ms") != NULL);
+        mu_assert("Script should stop soon after the budget",
+                  vm->metrics.instr_cnt - vm->instrs_mark <
+                      (options.timeout_ms + 2) * VIRTUAL_INSTRS_PER_MS);
+    }
+    mu_assert("Next script should get a fresh budget",
+              interpret(vm, "(+ 1 2)", NULL) == INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+static void* cancelSoon(void* flag) {
+    struct timespec delay = {.tv_sec = 0, .tv_nsec = 20 * 1000 * 1000};
+    nanosleep(&delay, NULL);
+    atomic_store((atomic_bool*)flag, true);
+    return NULL;
+}
+
+// Another thread stops a script that never ends, and try does not catch it.
+static char* test_vm_cancel(void) {
+    atomic_bool cancel = false;
+    VMOptions options = defaultVMOptions();
+    options.cancel = &cancel;
+    VM* vm = newVM(options);
+
+    pthread_t thread;
+    pthread_create(&thread, NULL, cancelSoon, &cancel);
+    InterpretResult result =
+        interpret(vm, "(fn loop [] (loop)) (try (loop))", NULL);
+    pthread_join(thread, NULL);
+    mu_assert("Script should be cancelled", result == INTERPRET_CANCELLED);
+    mu_assert("Unexpected error message",
+              IS_ERROR(vm->raise_value) &&
+                  strcmp(AS_ERROR(vm->raise_value)->message->chars,
+                         "Evaluation cancelled") == 0);
+
+    mu_assert("Cancellation should last until the host clears it",
+              interpret(vm, "(fn loop [] (loop)) (loop)", NULL) ==
+                  INTERPRET_CANCELLED);
+    atomic_store(&cancel, false);
+    mu_assert("Script should run once cleared",
+              interpret(vm, "(+ 1 2)", NULL) == INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+// Applies its first argument to the second twice.
+static Value hostTwice(VM* vm, int argc, Value* args) {
+    (void)argc;
+    Value once = callFromNative(vm, args[0], 1, &args[1]);
+    if (vm->last_result != INTERPRET_OK) return NIL_VAL;
+    return callFromNative(vm, args[0], 1, &once);
+}
+
+// A native defined by the host calls back into Liss, and errors raised there
+// unwind through it to the script's try.
+static char* test_vm_host_native(void) {
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* env = newModule(vm, "host");
+    push(vm, OBJ_VAL(env));
+    defineNative(vm, env, "twice", 2, hostTwice);
+
+    struct {
+        const char* src;
+        int64_t want;
+    } cases[] = {
+        {"(twice (fn [x] (+ x 1)) 1)", 3},
+        {"(twice (fn [x] (twice (fn [y] (* y 2)) x)) 1)", 16},
+        {"(is_err? (try (twice (fn [x] (raise! (err \"no\"))) 1)))", 1},
+    };
+    for (size_t i = 0; i < sizeof(cases) / sizeof(cases[0]); i++) {
+        mu_assert("Script should run",
+                  interpret(vm, cases[i].src, env) == INTERPRET_OK);
+        Value got = vm->last_popped_value;
+        bool ok = IS_BOOL(got) ? AS_BOOL(got) == (cases[i].want == 1)
+                               : IS_INT(got) && AS_INT(got) == cases[i].want;
+        mu_assert("Unexpected result of the host native", ok);
+    }
+
+    pop(vm);
+    destroyVM(vm);
+    return NULL;
+}
+
+// Runs its argument as a script on the VM that called it.
+static Value hostRun(VM* vm, int argc, Value* args) {
+    (void)argc;
+    if (interpret(vm, AS_STRING(args[0])->chars, NULL) != INTERPRET_OK) {
+        return NIL_VAL;
+    }
+    return vm->last_popped_value;
+}
+
+// Runs its argument as a script on a VM of its own.
+static Value hostSubRun(VM* vm, int argc, Value* args) {
+    (void)argc;
+    VM* sub = newVM(defaultVMOptions());
+    InterpretResult result = interpret(sub, AS_STRING(args[0])->chars, NULL);
+    Value value = sub->last_popped_value;
+    int64_t n = result == INTERPRET_OK && IS_INT(value) ? AS_INT(value) : -1;
+    destroyVM(sub);
+    if (n < 0) return raiseErr(vm, "sub-VM failed");
+    return INT_VAL(n);
+}
+
+// Scripts run from natives, on the same VM or another, keep the handlers,
+// open upvalues and stack of the run that called them intact.
+static char* test_vm_nested_runs(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    ObjModule* core = vm->core_module;
+    defineNative(vm, core, "run", 1, hostRun);
+    defineNative(vm, core, "sub_run", 1, hostSubRun);
+
+    struct {
+        const char* src;
+        const char* want;
+    } cases[] = {
+        // A try inside a callback must not take the place of the outer one.
+        {"(import list [\"map\"])"
+         "(fn f [] (map (fn [x] (try x)) [1]) (raise! (err \"outer\")))"
+         "(is_err? (try (f)))",
+         "true"},
+        {"(fn f [] (let y 5) (let g (fn [] y)) (run \"(+ 1 2)\") g) ((f))",
+         "5"},
+        {"(+ (run \"(+ 2 3)\")"
+         "   (run \"(cond (is_err? (try (raise! (err 0)))) 1 0)\"))",
+         "6"},
+        {"(is_err? (try (run \"(raise! (err 1))\")))", "true"},
+        {"(let m \"in\") (try (run \"(try (run \\\"(raise! (err m))\\\"))\"))",
+         "<error: in>"},
+        {"(+ 1 (sub_run \"(fn sq [x] (* x x)) (sq 4)\"))", "17"},
+        {"(is_err? (try (sub_run \"(raise! (err 1))\")))", "true"},
+    };
+    for (size_t i = 0; i < sizeof(cases) / sizeof(cases[0]); i++) {
+        InterpretResult result = interpret(vm, cases[i].src, NULL);
+        char* got = sprintValue(vm->last_popped_value);
+        bool ok = result == INTERPRET_OK && strcmp(got, cases[i].want) == 0;
+        if (!ok) printf("%s\nGot: %s\n", cases[i].src, got);
+        free(got);
+        mu_assert("Nested run should keep the outer one intact", ok);
+    }
+    mu_assert("Stack should be back where it started",
+              vm->stack_top == vm->stack && vm->frame_cnt == 0);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A host sets definitions up once and runs every request in a fresh copy of
+// them, so what one request defines is gone for the next.
+static char* test_vm_copy_module(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    ObjModule* base = newModule(vm, "app");
+    push(vm, OBJ_VAL(base));
+    mu_assert("Setup should run",
+              interpret(vm,
+                        "(const tax 2) (let rate 10)"
+                        "(fn price [x] (+ (* x rate) tax))",
+                        base) == INTERPRET_OK);
+
+    struct {
+        const char* src;
+        InterpretResult status;
+        int64_t want;
+    } requests[] = {
+        {"(let seen 1) (price 3)", INTERPRET_OK, 32},
+        {"seen", INTERPRET_RUNTIME_ERROR, 0},
+        {"(set! rate 100) (+ rate tax)", INTERPRET_OK, 102},
+        {"(+ (price 1) rate)", INTERPRET_OK, 22},
+    };
+    for (size_t i = 0; i < sizeof(requests) / sizeof(requests[0]); i++) {
+        ObjModule* env = copyModule(vm, base);
+        push(vm, OBJ_VAL(env));
+        InterpretResult result = interpret(vm, requests[i].src, env);
+        mu_assert("Unexpected outcome of a request",
+                  result == requests[i].status);
+        if (result == INTERPRET_OK) {
+            mu_assert("Unexpected value of a request",
+                      AS_INT(vm->last_popped_value) == requests[i].want);
+        }
+        pop(vm);
+    }
+
+    pop(vm);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A reset VM runs the next script with fresh globals but keeps its memory.
+static char* test_vm_reset(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    CallFrame* frames = vm->frames;
+
+    mu_assert("Script should run",
+              interpret(vm, "(let x 1) (fn f [] x) (f)", NULL) == INTERPRET_OK);
+    resetVM(vm);
+    mu_assert("Globals should be gone after a reset",
+              interpret(vm, "x", NULL) == INTERPRET_RUNTIME_ERROR);
+    resetVM(vm);
+    mu_assert("Errors should be gone after a reset",
+              interpret(vm, "(let x 2) x", NULL) == INTERPRET_OK);
+    mu_assert("Unexpected value after a reset",
+              IS_INT(vm->last_popped_value) &&
+                  AS_INT(vm->last_popped_value) == 2);
+    mu_assert("Frames should be reused", vm->frames == frames);
+    mu_assert("Stack should be empty", vm->stack_top == vm->stack);
+
+    const char* sources[] = {
+        "(let y 1)",
+        "(let y 2)",
+        "(+ y",
+        "(raise! (err \"boom\"))",
+    };
+    InterpretResult results[4];
+    mu_assert("Two scripts should succeed",
+              interpretMany(vm, sources, 4, results) == 2);
+    mu_assert("Unexpected batch results",
+              results[0] == INTERPRET_OK && results[1] == INTERPRET_OK &&
+                  results[2] == INTERPRET_COMPILE_ERROR &&
+                  results[3] == INTERPRET_RUNTIME_ERROR);
+
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_deep_closures(void) {
+    // Every closure captures the previous one, so marking the chain used to
+    // recurse once per link and overflow the C stack.
+    const char* src =
+        "(fn wrap [f] (fn [] f))\n"
+        "(fn build [n acc] (cond (= n 0) acc (build (- n 1) (wrap acc))))\n"
+        "(let chain (build 200000 null))\n"
+        "(((chain)))";
+    VM* vm = newVM(defaultVMOptions());
+    mu_assert("Script should run", interpret(vm, src, NULL) == INTERPRET_OK);
+    gc(vm);
+    mu_assert("The chain should survive a collection",
+              interpret(vm, "(((((chain)))))", NULL) == INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
+// A long list literal is built a chunk at a time, so it fits a stack far
+// smaller than the number of its items.
+static char* test_vm_long_list_literal(void) {
+    const int count = 100000;
+    size_t cap = (size_t)count * 4 + 64;
+    char* src = malloc(cap);
+    size_t len = sprintf(src, "(let xs [");
+    for (int i = 0; i < count; i++) {
+        len += sprintf(src + len, "%d ", i % 100);
+    }
+    sprintf(src + len, "]) [(len xs) (get xs 12345) (get xs -1)]");
+
+    VMOptions options = defaultVMOptions();
+    options.stack_capacity = 64;
+    VM* vm = newVM(options);
+    InterpretResult result = interpret(vm, src, NULL);
+    free(src);
+    mu_assert("Script should run", result == INTERPRET_OK);
+    mu_assert("Unexpected items",
+              assert_list(vm->last_popped_value, "[100000 45 99]") == NULL);
+    destroyVM(vm);
+    return NULL;
+}
+
+// Builds a script that declares count globals named prefix0, prefix1, ...
+// followed by tail.
+static char* globalsScript(const char* prefix, int count, const char* tail) {
+    size_t cap = (size_t)count * 32 + strlen(tail) + 1;
+    char* src = malloc(cap);
+    size_t len = 0;
+    for (int i = 0; i < count; i++) {
+        len += sprintf(src + len, "(let %s%d %d)\n", prefix, i, i);
+    }
+    sprintf(src + len, "%s", tail);
+    return src;
+}
+
+// One compilation may declare any number of globals, and a failed one
+// removes all that it declared.
+static char* test_vm_many_globals(void) {
+    const int count = 3000;
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* env = newModule(vm, "many");
+    push(vm, OBJ_VAL(env));
+
+    char* src = globalsScript("g", count, "(+ g0 g2999)");
+    InterpretResult result = interpret(vm, src, env);
+    free(src);
+    mu_assert("Script should run", result == INTERPRET_OK);
+    mu_assert("Unexpected sum", IS_INT(vm->last_popped_value) &&
+                                    AS_INT(vm->last_popped_value) == 2999);
+
+    size_t symbols = env->symbols.size;
+    src = globalsScript("h", count, "(let");
+    result = interpret(vm, src, env);
+    free(src);
+    mu_assert("Script should not compile", result == INTERPRET_COMPILE_ERROR);
+    mu_assert("A failed compilation should not leave globals behind",
+              env->symbols.size == symbols);
+
+    pop(vm);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_defs_yield_value(void) {
+    VMOptions options = defaultVMOptions();
+    options.defs_yield_value = true;
+    VM* vm = newVM(options);
+    mu_assert("let should run",
+              interpret(vm, "(let x 10)", NULL) == INTERPRET_OK);
+    mu_assert("let yields its value", IS_INT(vm->last_popped_value) &&
+                                          AS_INT(vm->last_popped_value) == 10);
+    mu_assert("fn should run",
+              interpret(vm, "(fn f [] 1)", NULL) == INTERPRET_OK);
+    mu_assert("fn yields the closure", IS_CLOSURE(vm->last_popped_value));
+    destroyVM(vm);
+    return NULL;
+}
+
+typedef struct {
+    OpCode ops[64];
+    int op_cnt;
+    int pushes;
+    int pops;
+    int back_jumps;
+} StepTrace;
+
+static void traceInstr(VM* vm, const StepInstr* instr, void* ctx) {
+    (void)vm;
+    StepTrace* trace = ctx;
+    if (trace->op_cnt < 64) trace->ops[trace->op_cnt] = instr->op;
+    trace->op_cnt++;
+}
+
+static void tracePush(VM* vm, Value value, void* ctx) {
+    (void)vm;
+    (void)value;
+    ((StepTrace*)ctx)->pushes++;
+}
+
+static void tracePop(VM* vm, Value value, void* ctx) {
+    (void)vm;
+    (void)value;
+    ((StepTrace*)ctx)->pops++;
+}
+
+static void traceJump(VM* vm, int from, int to, void* ctx) {
+    (void)vm;
+    if (to < from) ((StepTrace*)ctx)->back_jumps++;
+}
+
+static char* test_vm_step_hooks(void) {
+    VM* vm = newVM(defaultVMOptions());
+    StepTrace trace = {0};
+    vm->hooks = (StepHooks){
+        .on_instr = traceInstr,
+        .on_push = tracePush,
+        .on_pop = tracePop,
+        .on_jump = traceJump,
+        .ctx = &trace,
+    };
+    mu_assert("Script should run",
+              interpret(vm, "(+ 1 2)", NULL) == INTERPRET_OK);
+    OpCode expected[] = {OP_CONSTANT, OP_CONSTANT, OP_ADD, OP_RETURN};
+    mu_assert("Every instruction is reported", trace.op_cnt == 4);
+    for (int i = 0; i < 4; i++) {
+        mu_assert("Instructions are reported in order",
+                  trace.ops[i] == expected[i]);
+    }
+    mu_assert("Every push is matched by a pop", trace.pushes == trace.pops);
+    mu_assert("Straight code does not jump", trace.back_jumps == 0);
+
+    trace = (StepTrace){0};
+    mu_assert("Loop should run",
+              interpret(vm, "(let i 0) (while (< i 3) (set! i (+ i 1)))",
+                        NULL) == INTERPRET_OK);
+    mu_assert("Each pass but the first jumps back", trace.back_jumps == 3);
+    mu_assert("Loop pushes are matched by pops", trace.pushes == trace.pops);
+
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_step(void) {
+    VM* vm = newVM(defaultVMOptions());
+    mu_assert("Program should compile",
+              stepStart(vm, "(+ 1 2)", NULL) == INTERPRET_OK);
+    mu_assert("Program waits for the first step", vm->step.active);
+    mu_assert("Step should run", stepNext(vm) == INTERPRET_OK);
+    mu_assert("Step should run", stepNext(vm) == INTERPRET_OK);
+    mu_assert("Two steps push both operands",
+              IS_INT(peek(vm, 0)) && AS_INT(peek(vm, 0)) == 2 &&
+                  AS_INT(peek(vm, 1)) == 1);
+    int steps = 2;
+    while (vm->step.active) {
+        mu_assert("Step should run", stepNext(vm) == INTERPRET_OK);
+        steps++;
+    }
+    mu_assert("One step per instruction", steps == 4);
+    mu_assert("The program's value is kept",
+              IS_INT(vm->last_popped_value) &&
+                  AS_INT(vm->last_popped_value) == 3);
+    mu_assert("The stack is left as it was", vm->stack_top == vm->stack);
+
+    // A native calling back into Liss runs whole within one step.
+    mu_assert("Program should compile",
+              stepStart(vm,
+                        "(import list) (list:map (fn [x] (* x 2)) [1 2 3])",
+                        NULL) == INTERPRET_OK);
+    steps = 0;
+    while (vm->step.active) {
+        mu_assert("Step should run", stepNext(vm) == INTERPRET_OK);
+        steps++;
+    }
+    mu_assert("Callbacks are not stepped", steps < 12);
+    mu_assert("Unexpected map result",
+              assert_list(vm->last_popped_value, "[2 4 6]") == NULL);
+
+    mu_assert("Program should compile",
+              stepStart(vm, "(+ 1 \"a\")", NULL) == INTERPRET_OK);
+    InterpretResult result = INTERPRET_OK;
+    while (vm->step.active) result = stepNext(vm);
+    mu_assert("A runtime error ends the program",
+              result == INTERPRET_RUNTIME_ERROR);
+
+    destroyVM(vm);
+    return NULL;
+}
+
+// Upper bounds on heap allocations per evaluation of hot paths, so a change
+// that starts allocating in them shows up here rather than in a profile.
+static char* test_vm_allocs(void) {
+    VM* vm = newVM(defaultVMOptions());
+    ObjModule* env = newModule(vm, "bench");
+    push(vm, OBJ_VAL(env));
+    const char* defs =
+        "(fn sum [n acc] (cond (= n 0) acc (sum (- n 1) (+ acc n))))\n"
+        "(fn add [a b] (+ a b))\n"
+        "(fn adder [x] (fn [y] (+ x y)))\n"
+        "(let add5 (adder 5))\n"
+        "(let d (dict (\"a\" . 1) (\"b\" . 2) (\"c\" . 3)))\n";
+    mu_assert("Definitions should run",
+              interpret(vm, defs, env) == INTERPRET_OK);
+
+    struct {
+        const char* expr;
+        uint64_t max_allocs;  // Per evaluation
+    } tests[] = {
+        {"(sum 1000 0)", 0},  // Integer arithmetic in a loop
+        {"(add 1 2)", 0},     // Call of a global function
+        {"(add5 1)", 0},      // Call of a closure reading an upvalue
+        {"(get d \"b\")", 0},  // Dict lookup
+    };
+    const int runs = 100;
+
+    for (size_t i = 0; i < sizeof(tests) / sizeof(tests[0]); i++) {
+        ObjClosure* expr = compileExpr(vm, tests[i].expr, env);
+        mu_assert("Expression should compile", expr != NULL);
+        Value result;
+        // The first call loads the threaded code, which is not measured.
+        mu_assert("Expression should run",
+                  callExpr(vm, expr, &result) == INTERPRET_OK);
+        uint64_t before = vm->alloc_cnt;
+        for (int j = 0; j < runs; j++) {
+            callExpr(vm, expr, &result);
+        }
+        uint64_t allocs = vm->alloc_cnt - before;
+        if (allocs > tests[i].max_allocs * runs) {
+            printf("Failed test: %s\n  expected at most %llu allocations, "
+                   "got %.2f\n",
+                   tests[i].expr, (unsigned long long)tests[i].max_allocs,
+                   (double)allocs / runs);
+            freeExpr(vm, expr);
+            mu_assert("Too many allocations.", false);
+        }
+        freeExpr(vm, expr);
+    }
+
+    pop(vm);
+    destroyVM(vm);
+    return NULL;
+}
+
 // The suite function, called by the main test runner.
+// vmMetrics adds the heap counters, and the JSON export lists them with the
+// opcodes that ran and the calls of every function.
+static char* test_vm_metrics_export(void) {
+    VMOptions options = defaultVMOptions();
+    options.profile = true;
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    const char* src =
+        "(fn fib [n] (cond (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))"
+        "(fib 5)";
+    mu_assert("Script should run", interpret(vm, src, NULL) == INTERPRET_OK);
+
+    VMMetrics metrics = vmMetrics(vm);
+    mu_assert("Stress GC should collect", metrics.gc_cnt > 0);
+    mu_assert("Heap counters should be filled in",
+              metrics.alloc_cnt == vm->alloc_cnt &&
+                  metrics.bytes_allocated == vm->bytes_allocated &&
+                  metrics.alloc_bytes >= metrics.bytes_allocated);
+
+    char* json = NULL;
+    size_t len = 0;
+    FILE* out = open_memstream(&json, &len);
+    writeMetricsJson(vm, out);
+    fclose(out);
+    bool listed =
+        strstr(json, "\"OP_ADD\": ") != NULL &&
+        strstr(json, "{\"name\": \"fib\", \"calls\": 15}") != NULL &&
+        strstr(json, "\"gc_cycles\": ") != NULL;
+    if (!listed) printf("Got:\n%s", json);
+    free(json);
+    destroyVM(vm);
+    mu_assert("JSON should list opcodes, calls and collections", listed);
+    return NULL;
+}
+
+// Past max_heap a script fails with an error that try catches, and the
+// garbage it left behind does not count against the limit.
+static char* test_vm_heap_limit(void) {
+    VMOptions options = defaultVMOptions();
+    options.max_heap = 4 * 1024 * 1024;  // 4MB
+    VM* vm = newVM(options);
+    const char* src =
+        "(fn grow [s] (grow (+ s s)))"
+        "(try (grow \"ab\"))";
+    mu_assert("Script should run", interpret(vm, src, NULL) == INTERPRET_OK);
+    mu_assert("Script should catch the error", IS_ERROR(vm->last_popped_value));
+    mu_assert("Unexpected error message",
+              strstr(AS_ERROR(vm->last_popped_value)->message->chars,
+                     "Memory limit") != NULL);
+
+    const char* churn =
+        "(fn churn [n] (let s (+ \"ab\" (str n)))"
+        "  (cond (= n 0) 0 (churn (- n 1))))"
+        "(churn 20000)";
+    mu_assert("Garbage should be collected under the limit",
+              interpret(vm, churn, NULL) == INTERPRET_OK);
+
+    VMMetrics metrics = vmMetrics(vm);
+    mu_assert("Peak should cover the live heap",
+              metrics.heap_peak >= metrics.bytes_allocated &&
+                  metrics.heap_peak > options.max_heap / 2);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_trace(void) {
+    char* log = NULL;
+    size_t len = 0;
+    VMOptions options = defaultVMOptions();
+    options.trace = open_memstream(&log, &len);
+    options.trace_filter = "main:sq";
+    VM* vm = newVM(options);
+    const char* src = "(fn sq [x] (* x x)) (fn twice [x] (+ x x)) (+ (sq 3) 1)";
+    InterpretResult result = interpret(vm, src, NULL);
+    destroyVM(vm);
+    fclose(options.trace);
+    mu_assert("Script should run", result == INTERPRET_OK);
+    const char* want =
+        "main:sq [2/3] 0000 OP_GET_LOCAL 1\n"
+        "main:sq [2/4] 0002 OP_GET_LOCAL 1\n"
+        "main:sq [2/5] 0004 OP_MULTIPLY\n"
+        "main:sq [2/4] 0005 OP_RETURN\n";
+    bool same = strcmp(log, want) == 0;
+    if (!same) printf("Got:\n%s", log);
+    free(log);
+    mu_assert("Only the instructions of sq should be traced", same);
+    return NULL;
+}
+
+// The hash seed moves the entries of a dict around its trie, but the keys
+// still list in insertion order and the dict still prints sorted by key, even
+// after deletes and with keys that print alike.
+static char* test_vm_hash_seed(void) {
+    const char* src =
+        "(let d (dict))"
+        "(for [i 40]"
+        "  (set! d (put d i (* i i)))"
+        "  (set! d (put d (+ \"k\" (str i)) i)))"
+        "(for [i 40] (cond (= 0 (% i 3)) (set! d (del d i))))"
+        "(let r (repr d))"
+        "(set! d (put d (fn [] 1) \"a\"))"
+        "(set! d (put d (fn [] 2) \"b\"))"
+        "[(keys d) (entries d) d r (get d 37) (has? d 36)]";
+    const uint64_t seeds[] = {0, 1, 0xdeadbeef};
+    char* want = NULL;
+    for (size_t i = 0; i < sizeof(seeds) / sizeof(seeds[0]); i++) {
+        VMOptions options = defaultVMOptions();
+        options.stress_gc = true;
+        options.hash_seed = seeds[i];
+        VM* vm = newVM(options);
+        InterpretResult result = interpret(vm, src, NULL);
+        char* got = result == INTERPRET_OK ? sprintValue(vm->last_popped_value)
+                                           : NULL;
+        destroyVM(vm);
+        mu_assert("Script should run", got != NULL);
+        if (want == NULL) {
+            want = got;
+            continue;
+        }
+        bool same = strcmp(want, got) == 0;
+        if (!same) printf("Want:\n%s\nGot:\n%s\n", want, got);
+        free(got);
+        mu_assert("The hash seed should not change any output", same);
+    }
+    free(want);
+    return NULL;
+}
+
+static char* test_vm_stack_overflow(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    const char* src =
+        "(fn down [n] (+ 1 (down n)))\n"
+        "(let e (try (down 1)))\n"
+        "(cond (is_err? e) (err_trace e) e)";
+    mu_assert("Overflow should be caught",
+              interpret(vm, src, NULL) == INTERPRET_OK);
+    Value trace = vm->last_popped_value;
+    mu_assert("Caught error should carry a trace", IS_STRING(trace));
+    const char* want =
+        "  at main:down [line 1]\n"
+        "  at main:down [line 1]\n";
+    mu_assert("Trace should start at the innermost frame",
+              strncmp(AS_CSTRING(trace), want, strlen(want)) == 0);
+    mu_assert("Trace should count the frames left out",
+              strstr(AS_CSTRING(trace), "  ... 24 more frames\n") != NULL);
+
+    mu_assert("Uncaught overflow should fail",
+              interpret(vm, "(down 1)", NULL) == INTERPRET_RUNTIME_ERROR);
+    mu_assert("Error should name the limit and the function",
+              IS_ERROR(vm->raise_value) &&
+                  strcmp(AS_ERROR(vm->raise_value)->message->chars,
+                         "Stack overflow: more than 32 call frames calling "
+                         "down") == 0);
+    destroyVM(vm);
+
+    options.stack_capacity = 40;
+    vm = newVM(options);
+    mu_assert("Value stack overflow should be caught",
+              interpret(vm,
+                        "(fn down [n] (+ 1 (down n)))\n"
+                        "(is_err? (try (down 1)))",
+                        NULL) == INTERPRET_OK &&
+                  IS_BOOL(vm->last_popped_value) &&
+                  AS_BOOL(vm->last_popped_value));
+    mu_assert("Stack should be usable after the overflow",
+              interpret(vm, "(+ 1 2)", NULL) == INTERPRET_OK &&
+                  AS_INT(vm->last_popped_value) == 3);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_let_pattern(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    const char* src =
+        "(let [a b] #[1 2])\n"
+        "(fn sum3 [t] (let [x y z] t) (+ x y z))\n"
+        "(fn swap [p] (let [l r] p) (r . l))\n"
+        "(fn last [xs] (let [_a _b] xs))\n"
+        "(assert_eq [a b] [1 2])\n"
+        "(assert_eq (sum3 [1 2 3]) 6)\n"
+        "(assert_eq (swap (1 . 2)) (2 . 1))\n"
+        "(assert_eq (last [3 4]) 4)\n"
+        "(let [c] [5])";
+    InterpretResult result = interpret(vm, src, NULL);
+    if (result != INTERPRET_OK) {
+        char* str = sprintValue(vm->raise_value);
+        printf("Got: %s\n", str);
+        free(str);
+    }
+    mu_assert("Patterns should bind the items", result == INTERPRET_OK);
+
+    struct {
+        const char* src;
+        const char* err;
+    } errors[] = {
+        {"(try (sum3 #[1 2]))", "let [...] binds 3 names, got 2 items"},
+        {"(try (sum3 5))", "let [...] expects a tuple, list or pair, got int"},
+    };
+    for (size_t i = 0; i < sizeof(errors) / sizeof(errors[0]); i++) {
+        mu_assert("Script should run",
+                  interpret(vm, errors[i].src, NULL) == INTERPRET_OK);
+        Value got = vm->last_popped_value;
+        mu_assert("Mismatch should raise a catchable error",
+                  IS_ERROR(got) && strcmp(AS_ERROR(got)->message->chars,
+                                          errors[i].err) == 0);
+    }
+    mu_assert("An empty pattern should not compile",
+              interpret(vm, "(let [] [])", NULL) == INTERPRET_COMPILE_ERROR);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_param_defaults(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    const char* src =
+        "(fn greet [name (greeting \"hello\") (end (+ greeting \"!\"))]\n"
+        "    (+ greeting \" \" name end))\n"
+        "(fn sum [n (acc 0)] (cond (= n 0) acc (sum (- n 1) (+ acc n))))\n"
+        "(fn opt [(x 1)] x)\n"
+        "(assert_eq (greet \"bo\") \"hello bohello!\")\n"
+        "(assert_eq (greet \"bo\" \"hi\") \"hi bohi!\")\n"
+        "(assert_eq (greet \"bo\" \"hi\" \".\") \"hi bo.\")\n"
+        "(assert_eq (sum 10) 55)\n"
+        "(assert_eq (opt null) null)\n"
+        "(import list [\"map\"])\n"
+        "(assert_eq (map (fn [x (y 10)] (+ x y)) [1 2]) [11 12])";
+    InterpretResult result = interpret(vm, src, NULL);
+    if (result != INTERPRET_OK) {
+        char* str = sprintValue(vm->raise_value);
+        printf("Got: %s\n", str);
+        free(str);
+    }
+    mu_assert("Defaults should fill in left out arguments",
+              result == INTERPRET_OK);
+
+    mu_assert("Too few arguments should fail",
+              interpret(vm, "(greet)", NULL) == INTERPRET_RUNTIME_ERROR);
+    mu_assert("Error should give the range of arguments",
+              strcmp(AS_ERROR(vm->raise_value)->message->chars,
+                     "Function greet: runtime error: expected 1 to 3 "
+                     "arguments but got 0") == 0);
+    mu_assert("Too many arguments should fail",
+              interpret(vm, "(opt 1 2)", NULL) == INTERPRET_RUNTIME_ERROR);
+    destroyVM(vm);
+    return NULL;
+}
+
+static char* test_vm_keyword_args(void) {
+    VMOptions options = defaultVMOptions();
+    options.stress_gc = true;
+    VM* vm = newVM(options);
+    const char* src =
+        "(fn make_server [host (port 80) (tls false)] #[host port tls])\n"
+        "(assert_eq (make_server :port 8080 :host \"0.0.0.0\")\n"
+        "           #[\"0.0.0.0\" 8080 false])\n"
+        "(assert_eq (make_server \"h\" :tls true) #[\"h\" 80 true])\n"
+        "(assert_eq (make_server :host \"h\" :port null) #[\"h\" null false])\n"
+        "(fn opts [name o] #[name (get o \"port\") (len o)])\n"
+        "(let call_opts opts)\n"
+        "(assert_eq (call_opts \"a\" :port 1 :tls true) #[\"a\" 1 2])\n"
+        "(fn outer [f] (f \"b\" :port 2))\n"
+        "(assert_eq (outer opts) #[\"b\" 2 1])\n"
+        "(assert_eq (outer make_server) #[\"b\" 2 false])\n"
+        // The names bind to the parameters of the function the call reaches,
+        // even one defined after the caller or redefined.
+        "(fn g [] (f 1 :b 2))\n"
+        "(fn f [a (b 0)] b)\n"
+        "(assert_eq (g) 2)\n"
+        "(fn h [] (f :a 10 :b 20))\n"
+        "(fn f [b a] a)\n"
+        "(assert_eq (h) 10)\n"
+        "(fn f [a b] b)\n"
+        "(assert_eq (h) 20)\n"
+        "(assert (is_err? (try (f 1 :a 2))))\n"
+        "(fn k [] (m :b 1))\n"
+        "(fn m [a (b 0)] b)\n"
+        "(assert (is_err? (try (k))))";
+    InterpretResult result = interpret(vm, src, NULL);
+    if (result != INTERPRET_OK) {
+        char* str = sprintValue(vm->raise_value);
+        printf("Got: %s\n", str);
+        free(str);
+    }
+    mu_assert("Keyword arguments should map to parameters or a dict",
+              result == INTERPRET_OK);
+    destroyVM(vm);
+    return NULL;
+}
+
 void vm_suite(void) {
     printf("--- VM Suite ---\n");
     mu_run_test(test_vm_stack);
     mu_run_test(test_vm_interpret);
+    mu_run_test(test_vm_metrics);
+    mu_run_test(test_vm_compile_expr);
+    mu_run_test(test_vm_formula_sandbox);
+    mu_run_test(test_vm_timeout);
+    mu_run_test(test_vm_cancel);
+    mu_run_test(test_vm_host_native);
+    mu_run_test(test_vm_nested_runs);
+    mu_run_test(test_vm_copy_module);
+    mu_run_test(test_vm_reset);
+    mu_run_test(test_vm_deep_closures);
+    mu_run_test(test_vm_long_list_literal);
+    mu_run_test(test_vm_many_globals);
+    mu_run_test(test_vm_defs_yield_value);
+    mu_run_test(test_vm_step_hooks);
+    mu_run_test(test_vm_step);
+    mu_run_test(test_vm_allocs);
+    mu_run_test(test_vm_metrics_export);
+    mu_run_test(test_vm_heap_limit);
+    mu_run_test(test_vm_trace);
+    mu_run_test(test_vm_hash_seed);
+    mu_run_test(test_vm_stack_overflow);
+    mu_run_test(test_vm_let_pattern);
+    mu_run_test(test_vm_param_defaults);
+    mu_run_test(test_vm_keyword_args);
 }